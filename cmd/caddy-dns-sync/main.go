@@ -0,0 +1,269 @@
+// caddy-dns-sync controls a running caddy-dns-syncd through its admin api
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/client"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+	"github.com/evanofslack/caddy-dns-sync/internal/version"
+)
+
+const usage = `usage: caddy-dns-sync <command> [flags]
+
+commands:
+  plan         show the latest plan
+  sync         sync a host or zone right away
+  state        print the synced state
+  audit        print the latest audit entries
+  drift        print the drift report of shadow mode
+  consistency  print the latest cross check of caddy, state and the live zones
+  records      print the managed records
+  failures     print failing hosts with a suggested remediation
+  pause        pause dns writes
+  resume       resume dns writes
+  unskip       retry skipped hosts on the next sync
+  version      print the build version
+
+every command takes -addr and -token, defaulting to CADDY_DNS_SYNC_ADDR and
+CADDY_DNS_SYNC_TOKEN`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string) error {
+	switch command {
+	case "version", "-version", "--version":
+		fmt.Println(version.Get())
+		return nil
+	case "help", "-h", "-help", "--help":
+		fmt.Println(usage)
+		return nil
+	case "plan":
+		return plan(args)
+	case "sync":
+		return syncNow(args)
+	case "state", "audit", "drift", "consistency", "records", "failures":
+		return show(command, args)
+	case "pause", "resume":
+		return pause(command, args)
+	case "unskip":
+		return unskip(args)
+	}
+	return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+}
+
+// connection registers the flags naming the daemon to talk to
+type connection struct {
+	addr    *string
+	token   *string
+	timeout *time.Duration
+}
+
+func connect(fs *flag.FlagSet, timeout time.Duration) connection {
+	addr := os.Getenv("CADDY_DNS_SYNC_ADDR")
+	if addr == "" {
+		addr = client.DefaultAddr
+	}
+	token := os.Getenv("CADDY_DNS_SYNC_TOKEN")
+	if token == "" {
+		token = os.Getenv("CADDY_DNS_SYNC_API_ADMIN_TOKEN")
+	}
+	return connection{
+		addr:    fs.String("addr", addr, "api address of the daemon, defaults to CADDY_DNS_SYNC_ADDR"),
+		token:   fs.String("token", token, "api token, defaults to CADDY_DNS_SYNC_TOKEN"),
+		timeout: fs.Duration("timeout", timeout, "time to wait for the daemon"),
+	}
+}
+
+func (c connection) client() (*client.Client, context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), *c.timeout)
+	return client.New(*c.addr, *c.token, version.UserAgent("ctl")), ctx, cancel
+}
+
+func plan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	conn := connect(fs, 30*time.Second)
+	format := fs.String("format", "text", "output format, text, json or markdown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c, ctx, cancel := conn.client()
+	defer cancel()
+	p, err := c.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "json":
+		return printJSON(p)
+	case "markdown":
+		report := reconcile.Report{
+			Title:      "DNS plan " + p.RunID,
+			Changes:    p.Changes,
+			Violations: p.Violations,
+			Conflicts:  p.OwnershipConflicts,
+			Deferred:   p.Deferred,
+		}
+		return report.WriteMarkdown(os.Stdout)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, ex := range p.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", ex.Op, ex.Zone, ex.Name, ex.Type, ex.Data, ex.Reason)
+	}
+	w.Flush()
+	for _, m := range p.Moves {
+		fmt.Printf("Move %s to %s\n", m.From, m.To)
+	}
+	fmt.Printf("Plan %s: %d to create, %d to update, %d to delete\n", p.RunID, p.Create, p.Update, p.Delete)
+	if p.Deferred > 0 {
+		fmt.Printf("%d operations left for the next run\n", p.Deferred)
+	}
+	for _, v := range p.Violations {
+		if v.Op == "" {
+			fmt.Printf("Violation %s: %s\n", v.Rule, v.Message)
+			continue
+		}
+		fmt.Printf("Violation %s: %s %s %s in %s: %s\n", v.Rule, v.Op, v.Type, v.Name, v.Zone, v.Message)
+	}
+	for _, d := range p.Denied {
+		fmt.Printf("Denied by policy hook: %s %s %s in %s: %s\n", d.Op, d.Type, d.Name, d.Zone, d.Message)
+	}
+	for _, c := range p.OwnershipConflicts {
+		fmt.Printf("Ownership conflict: %s in %s is owned by %s\n", c.Name, c.Zone, c.Owner)
+	}
+	return nil
+}
+
+// syncNow syncs a single host or zone and prints the outcome, failing if any
+// operation failed
+func syncNow(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	conn := connect(fs, 5*time.Minute)
+	host := fs.String("host", "", "host to sync")
+	zone := fs.String("zone", "", "zone whose hosts to sync")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *host == "" && *zone == "" {
+		return fmt.Errorf("-host or -zone is required")
+	}
+	c, ctx, cancel := conn.client()
+	defer cancel()
+	result, err := c.Sync(ctx, *host, *zone)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Run %s: %d created, %d updated, %d deleted\n", result.RunID, result.Created, result.Updated, result.Deleted)
+	switch {
+	case result.Paused:
+		fmt.Println("Writes are paused, nothing was applied")
+	case result.Held:
+		fmt.Println("Dry run by the startup safeguard, nothing was applied")
+	case result.Deferred > 0:
+		fmt.Println("Outside the write windows, deferred until", time.Unix(result.Deferred, 0))
+	}
+	for _, failure := range result.Failures {
+		fmt.Println("Failed:", failure)
+	}
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("%d operations failed", len(result.Failures))
+	}
+	return nil
+}
+
+// show prints a read only endpoint as indented json
+func show(command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	conn := connect(fs, 30*time.Second)
+	limit := fs.Int("limit", 0, "audit entries to print, the api default if 0")
+	zone := fs.String("zone", "", "only records of this zone")
+	recordType := fs.String("type", "", "only records of this type")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c, ctx, cancel := conn.client()
+	defer cancel()
+
+	var raw json.RawMessage
+	var err error
+	switch command {
+	case "state":
+		raw, err = c.State(ctx)
+	case "audit":
+		raw, err = c.Audit(ctx, *limit)
+	case "drift":
+		raw, err = c.Drift(ctx)
+	case "consistency":
+		raw, err = c.Consistency(ctx)
+	case "records":
+		raw, err = c.Records(ctx, *zone, *recordType)
+	case "failures":
+		raw, err = c.Failures(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(raw)
+}
+
+func pause(command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	conn := connect(fs, 30*time.Second)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c, ctx, cancel := conn.client()
+	defer cancel()
+	if err := c.SetPaused(ctx, command == "pause"); err != nil {
+		return err
+	}
+	if command == "pause" {
+		fmt.Println("Paused dns writes")
+	} else {
+		fmt.Println("Resumed dns writes")
+	}
+	return nil
+}
+
+func unskip(args []string) error {
+	fs := flag.NewFlagSet("unskip", flag.ExitOnError)
+	conn := connect(fs, 30*time.Second)
+	host := fs.String("host", "", "host to retry, every skipped host if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c, ctx, cancel := conn.client()
+	defer cancel()
+	if err := c.ClearSkipped(ctx, *host); err != nil {
+		return err
+	}
+	if *host == "" {
+		fmt.Println("Cleared every skipped host")
+	} else {
+		fmt.Println("Cleared skipped host", *host)
+	}
+	return nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/export"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/monitoring"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/cloudflare"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+	"github.com/evanofslack/caddy-dns-sync/internal/source/caddy"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+	"github.com/evanofslack/caddy-dns-sync/internal/version"
+)
+
+// runCommand handles cli subcommands, returning false if args name no command
+func runCommand(args []string) (bool, error) {
+	switch args[0] {
+	case "version", "-version", "--version":
+		fmt.Println(version.Get())
+		return true, nil
+	case "generate-monitoring":
+		return true, generateMonitoring(args[1:])
+	case "export-terraform":
+		return true, exportTerraform(args[1:])
+	case "export":
+		return true, exportRecords(args[1:])
+	case "migrate-external-dns":
+		return true, migrateExternalDNS(args[1:])
+	case "state":
+		return true, stateCommand(args[1:])
+	case "simulate":
+		return true, simulate(args[1:])
+	case "self-test":
+		return true, selfTest(args[1:])
+	case "config":
+		return true, configCommand(args[1:])
+	}
+	return false, nil
+}
+
+func generateMonitoring(args []string) error {
+	fs := flag.NewFlagSet("generate-monitoring", flag.ExitOnError)
+	out := fs.String("out", ".", "directory to write dashboard and alert rules to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	specs := metrics.New(false).Specs()
+	dashboard, err := monitoring.Dashboard(specs)
+	if err != nil {
+		return fmt.Errorf("generate dashboard: %w", err)
+	}
+	rules, err := monitoring.AlertRules(specs)
+	if err != nil {
+		return fmt.Errorf("generate alert rules: %w", err)
+	}
+
+	files := map[string][]byte{
+		"caddy-dns-sync-dashboard.json": dashboard,
+		"caddy-dns-sync-alerts.yaml":    rules,
+	}
+	for name, data := range files {
+		path := filepath.Join(*out, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		fmt.Println("Wrote", path)
+	}
+	return nil
+}
+
+// exportTerraform writes the records managed by this instance as terraform
+// resources with import blocks, and as a terraform import script
+func exportTerraform(args []string) error {
+	fs := flag.NewFlagSet("export-terraform", flag.ExitOnError)
+	out := fs.String("out", ".", "directory to write terraform files to")
+	configPath := fs.String("config", "config.yaml", "config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if cfg.DNS.Provider != "" && cfg.DNS.Provider != config.ProviderCloudflare {
+		return fmt.Errorf("terraform export only supports the cloudflare provider")
+	}
+	ctx := context.Background()
+	m := metrics.New(false)
+
+	if cfg.Reconcile.Owner == config.OwnerAuto {
+		sm, err := state.New(cfg.StatePath, m)
+		if err != nil {
+			return fmt.Errorf("open state to resolve owner: %w", err)
+		}
+		cfg.Reconcile.Owner, err = resolveOwner(ctx, sm)
+		sm.Close()
+		if err != nil {
+			return fmt.Errorf("resolve owner: %w", err)
+		}
+	}
+
+	cf, err := cloudflare.New(cfg.DNS, m)
+	if err != nil {
+		return fmt.Errorf("init dns provider: %w", err)
+	}
+	zones := cfg.DNS.Zones
+	if len(zones) == 0 {
+		zones = cf.Zones()
+	}
+
+	managed := []provider.Record{}
+	zoneIDs := make(map[string]string)
+	for _, zone := range zones {
+		records, err := cf.GetRecords(ctx, zone)
+		if err != nil {
+			return err
+		}
+		managed = append(managed, reconcile.ManagedRecords(records, zone, cfg.Reconcile.Owners()...)...)
+		zoneIDs[zone], _ = cf.ZoneID(zone)
+	}
+
+	resources, err := export.Terraform(managed, zoneIDs)
+	if err != nil {
+		return fmt.Errorf("generate terraform: %w", err)
+	}
+	imports, err := export.TerraformImports(managed, zoneIDs)
+	if err != nil {
+		return fmt.Errorf("generate terraform imports: %w", err)
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{"caddy-dns-sync-records.tf", resources, 0o644},
+		{"caddy-dns-sync-import.sh", imports, 0o755},
+	}
+	for _, f := range files {
+		path := filepath.Join(*out, f.name)
+		if err := os.WriteFile(path, f.data, f.mode); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		fmt.Println("Wrote", path)
+	}
+	fmt.Println("Exported", len(managed), "records owned by", cfg.Reconcile.Owner)
+	return nil
+}
+
+// exportRecords writes the records caddy hosts should have, one file per zone
+func exportRecords(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "zonefile", "output format, only zonefile is supported")
+	out := fs.String("out", ".", "directory to write zone files to")
+	configPath := fs.String("config", "config.yaml", "config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "zonefile" {
+		return fmt.Errorf("unknown format %q, use zonefile or the export-terraform command", *format)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if len(cfg.DNS.Zones) == 0 {
+		return fmt.Errorf("dns.zones is empty, zones can not be discovered without the provider")
+	}
+	m := metrics.New(false)
+
+	domains, err := caddy.New(cfg.Caddy, m).Domains(context.Background())
+	if err != nil {
+		return fmt.Errorf("get caddy domains: %w", err)
+	}
+	engine := reconcile.NewEngine(nil, nil, cfg, m)
+
+	defaultTTL := cfg.DNS.TTL
+	if defaultTTL <= 0 {
+		defaultTTL = 3600
+	}
+	for zone, records := range engine.DesiredRecords(domains) {
+		path := filepath.Join(*out, zone+".zone")
+		if err := os.WriteFile(path, export.ZoneFile(zone, records, defaultTTL), 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		fmt.Println("Wrote", path, "with", len(records), "records")
+	}
+	return nil
+}
+
+// migrateExternalDNS lists records owned by external-dns and, once applied,
+// adds caddy-dns-sync ownership to them, optionally removing the registry
+func migrateExternalDNS(args []string) error {
+	fs := flag.NewFlagSet("migrate-external-dns", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "config file")
+	owners := fs.String("owners", "", "comma separated external-dns owner ids, defaults to reconcile.externalDNSOwners")
+	apply := fs.Bool("apply", false, "write ownership records, only list the migration if false")
+	removeRegistry := fs.Bool("remove-registry", false, "also delete the external-dns registry records")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if *owners != "" {
+		cfg.Reconcile.ExternalDNSOwners = strings.Split(*owners, ",")
+	}
+	if len(cfg.Reconcile.ExternalDNSOwners) == 0 {
+		return fmt.Errorf("no external-dns owners, set -owners or reconcile.externalDNSOwners")
+	}
+	if cfg.Reconcile.Owner == config.OwnerAuto {
+		return fmt.Errorf("owner auto is not supported, set reconcile.owner to the owner of the running instance")
+	}
+	ctx := context.Background()
+
+	dp, err := newProvider(cfg.DNS, metrics.New(false))
+	if err != nil {
+		return fmt.Errorf("init dns provider: %w", err)
+	}
+	zones := cfg.DNS.Zones
+	if len(zones) == 0 {
+		zones = dp.Zones()
+	}
+
+	migrated := 0
+	for _, zone := range zones {
+		records, err := dp.GetRecords(ctx, zone)
+		if err != nil {
+			return err
+		}
+		// Names already owned by this instance need no migration
+		owned := make(map[string]bool)
+		for _, r := range reconcile.ManagedRecords(records, zone, cfg.Reconcile.Owners()...) {
+			owned[r.Name] = true
+		}
+
+		for _, found := range reconcile.ExternalDNSRecords(records, zone, cfg.Reconcile.ExternalDNSOwners) {
+			r := found.Record
+			if owned[r.Name] {
+				continue
+			}
+			fmt.Printf("%s %s %s owned by external-dns %s\n", r.Name, r.Type, r.Data, found.Owner)
+			migrated++
+			if !*apply {
+				continue
+			}
+			heritage := provider.Record{
+				Name: r.Name,
+				Type: "TXT",
+				Data: reconcile.HeritageData(cfg.Reconcile.Owner),
+				TTL:  r.TTL,
+				Zone: zone,
+			}
+			if err := dp.CreateRecord(ctx, zone, heritage); err != nil {
+				return fmt.Errorf("create ownership record for %s: %w", r.Name, err)
+			}
+			owned[r.Name] = true
+			if !*removeRegistry {
+				continue
+			}
+			for _, registry := range found.Registry {
+				if err := dp.DeleteRecord(ctx, zone, registry); err != nil {
+					return fmt.Errorf("delete registry record %s: %w", registry.Name, err)
+				}
+			}
+		}
+	}
+
+	switch {
+	case migrated == 0:
+		fmt.Println("No records to migrate")
+	case !*apply:
+		fmt.Println(migrated, "records to migrate, rerun with -apply once external-dns no longer manages them")
+	default:
+		fmt.Println("Migrated", migrated, "records to owner", cfg.Reconcile.Owner)
+	}
+	return nil
+}
+
+// configCommand prints the json schema of the config file
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "schema" {
+		return fmt.Errorf("missing subcommand, use schema")
+	}
+	fs := flag.NewFlagSet("config schema", flag.ExitOnError)
+	out := fs.String("out", "", "file to write the schema to, defaults to stdout")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	schema, err := config.Schema()
+	if err != nil {
+		return err
+	}
+	schema = append(schema, '\n')
+	if *out == "" {
+		_, err := os.Stdout.Write(schema)
+		return err
+	}
+	if err := os.WriteFile(*out, schema, 0o644); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", *out)
+	return nil
+}
+
+// stateCommand takes, lists or restores state snapshots. The service must be
+// stopped, the state store can only be opened by one process.
+func stateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing subcommand, use snapshot, list or restore")
+	}
+	fs := flag.NewFlagSet("state "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "config file")
+	from := fs.String("from", "", "snapshot to restore, defaults to the newest")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	switch args[0] {
+	case "snapshot":
+		sm, err := state.New(cfg.StatePath, metrics.New(false))
+		if err != nil {
+			return err
+		}
+		defer sm.Close()
+		path, err := state.WriteSnapshot(context.Background(), sm, cfg.Snapshot.Dir, cfg.Snapshot.Keep)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Wrote", path)
+	case "list":
+		snapshots, err := state.Snapshots(cfg.Snapshot.Dir)
+		if err != nil {
+			return err
+		}
+		for _, path := range snapshots {
+			fmt.Println(path)
+		}
+	case "restore":
+		path := *from
+		if path == "" {
+			snapshots, err := state.Snapshots(cfg.Snapshot.Dir)
+			if err != nil {
+				return err
+			}
+			if len(snapshots) == 0 {
+				return fmt.Errorf("no snapshots in %s", cfg.Snapshot.Dir)
+			}
+			path = snapshots[0]
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		moved, err := state.Restore(cfg.StatePath, f)
+		if moved != "" {
+			fmt.Println("Moved previous state to", moved)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("Restored", cfg.StatePath, "from", path)
+	default:
+		return fmt.Errorf("unknown subcommand %q, use snapshot, list or restore", args[0])
+	}
+	return nil
+}
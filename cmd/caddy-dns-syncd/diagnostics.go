@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+	"github.com/evanofslack/caddy-dns-sync/internal/version"
+)
+
+type diagnostics struct {
+	Version           string                       `json:"version"`
+	LastSync          time.Time                    `json:"lastSync"`
+	LastSyncError     string                       `json:"lastSyncError,omitempty"`
+	Zones             map[string]syncStatus        `json:"zones,omitempty"` // zones on their own schedule
+	Plan              planSummary                  `json:"plan"`
+	Summary           reconcile.Summary            `json:"summary"`
+	Failures          map[string]state.HostFailure `json:"failures"`
+	Paused            bool                         `json:"paused"`
+	Goroutines        int                          `json:"goroutines"`
+	ConfigFingerprint string                       `json:"configFingerprint"`
+}
+
+type syncStatus struct {
+	LastSync      time.Time `json:"lastSync"`
+	LastSyncError string    `json:"lastSyncError,omitempty"`
+}
+
+type planSummary struct {
+	Create    int `json:"create"`
+	Update    int `json:"update"`
+	Delete    int `json:"delete"`
+	Conflicts int `json:"conflicts"`
+}
+
+// dumpDiagnostics logs the in memory status, as json with the prod logger,
+// for debugging in the field without the admin api
+func dumpDiagnostics(ctx context.Context, s *syncer, engine reconcile.Engine, sm state.Manager, cfg *config.Config) {
+	plan := engine.LastPlan()
+	d := diagnostics{
+		Version:           version.Get().Version,
+		Plan:              planSummary{len(plan.Create), len(plan.Update), len(plan.Delete), len(plan.Conflicts)},
+		Summary:           engine.Summary(),
+		Goroutines:        runtime.NumGoroutine(),
+		ConfigFingerprint: cfg.Fingerprint(),
+	}
+	lastSync, lastErr := s.status()
+	d.LastSync = lastSync
+	if lastErr != nil {
+		d.LastSyncError = lastErr.Error()
+	}
+	d.Zones = s.zoneStatus()
+
+	var err error
+	if d.Failures, err = sm.LoadFailures(ctx); err != nil {
+		slog.Warn("Failed to load failures for diagnostics", "error", err)
+	}
+	if d.Paused, err = engine.Paused(ctx); err != nil {
+		slog.Warn("Failed to load paused for diagnostics", "error", err)
+	}
+	slog.Info("Diagnostics", "dump", d)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+	"github.com/evanofslack/caddy-dns-sync/internal/version"
+)
+
+// githubComment is an issue comment of the github rest api
+type githubComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// postGitHubComment posts body as a comment on the pull request the github
+// actions run belongs to, replacing the comment of an earlier run. The
+// repository, pull request and token come from the environment github sets,
+// GITHUB_REPOSITORY, GITHUB_EVENT_PATH and GITHUB_TOKEN, pr overrides the
+// pull request number when not zero.
+func postGitHubComment(ctx context.Context, body string, pr int) error {
+	token := os.Getenv("GITHUB_TOKEN")
+	repo := os.Getenv("GITHUB_REPOSITORY")
+	if token == "" || repo == "" {
+		return fmt.Errorf("GITHUB_TOKEN and GITHUB_REPOSITORY must be set")
+	}
+	if pr == 0 {
+		var err error
+		if pr, err = pullRequestNumber(os.Getenv("GITHUB_EVENT_PATH")); err != nil {
+			return err
+		}
+	}
+	api := os.Getenv("GITHUB_API_URL")
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	gh := githubClient{api: strings.TrimSuffix(api, "/"), token: token}
+
+	comments := []githubComment{}
+	if err := gh.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=100", repo, pr), nil, &comments); err != nil {
+		return fmt.Errorf("list comments: %w", err)
+	}
+	payload := map[string]string{"body": body}
+	for _, c := range comments {
+		if strings.HasPrefix(c.Body, reconcile.ReportMarker) {
+			return gh.do(ctx, http.MethodPatch, fmt.Sprintf("/repos/%s/issues/comments/%d", repo, c.ID), payload, nil)
+		}
+	}
+	return gh.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", repo, pr), payload, nil)
+}
+
+// pullRequestNumber reads the pull request number from the event payload of
+// a github actions run
+func pullRequestNumber(eventPath string) (int, error) {
+	if eventPath == "" {
+		return 0, fmt.Errorf("GITHUB_EVENT_PATH is not set, pass -github-pr")
+	}
+	data, err := os.ReadFile(eventPath)
+	if err != nil {
+		return 0, err
+	}
+	var event struct {
+		Number      int `json:"number"`
+		PullRequest struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return 0, fmt.Errorf("parse %s: %w", eventPath, err)
+	}
+	if event.PullRequest.Number != 0 {
+		return event.PullRequest.Number, nil
+	}
+	if event.Number != 0 {
+		return event.Number, nil
+	}
+	return 0, fmt.Errorf("the run was not triggered by a pull request, pass -github-pr")
+}
+
+type githubClient struct {
+	api   string
+	token string
+}
+
+func (g githubClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, g.api+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", version.UserAgent("ci"))
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("github api %s %s, status=%d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,281 @@
+// caddy-dns-syncd syncs dns records with the hosts caddy serves
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/api"
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/hostlist"
+	"github.com/evanofslack/caddy-dns-sync/internal/logger"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/notify"
+	"github.com/evanofslack/caddy-dns-sync/internal/objectstore"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/publicip"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+	"github.com/evanofslack/caddy-dns-sync/internal/source/caddy"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+	"github.com/evanofslack/caddy-dns-sync/internal/version"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		handled, err := runCommand(os.Args[1:])
+		if err != nil {
+			slog.Error("Command failed", "command", os.Args[1], "error", err)
+			os.Exit(1)
+		}
+		if handled {
+			return
+		}
+	}
+
+	cfg, err := config.Load("config.yaml")
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Configure(cfg.Log.Level, cfg.Log.Env)
+
+	metrics := metrics.New(true)
+	if cfg.API.OpenMetrics {
+		metrics.EnableOpenMetrics()
+	}
+
+	// Graceful shutdown handling, ctx stops the loops while work carries
+	// in-flight provider calls and state writes through the drain period
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	work, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	if legacy, err := state.MigrateLegacyPath(cfg.StatePath); err != nil {
+		slog.Error("Failed to migrate legacy state", "error", err)
+		os.Exit(1)
+	} else if legacy != "" {
+		slog.Info("Migrated legacy state", "from", legacy, "to", cfg.StatePath)
+	}
+
+	// Without local state, e.g. on a new node, state comes from the newest upload
+	var remote state.Remote
+	if cfg.Snapshot.Remote.Enabled() {
+		store, err := objectstore.New(cfg.Snapshot.Remote, cfg.Caddy.UserAgent)
+		if err != nil {
+			slog.Error("Failed to initialize remote snapshot store", "error", err)
+			os.Exit(1)
+		}
+		remote = store
+		key, err := state.RestoreRemote(ctx, remote, cfg.StatePath, cfg.Snapshot.Remote.Prefix)
+		if err != nil {
+			slog.Error("Failed to restore state from remote snapshot", "bucket", cfg.Snapshot.Remote.Bucket, "error", err)
+			os.Exit(1)
+		}
+		if key != "" {
+			slog.Info("Restored state from remote snapshot", "bucket", cfg.Snapshot.Remote.Bucket, "key", key)
+		}
+	}
+
+	stateManager, err := state.New(cfg.StatePath, metrics)
+	if err != nil {
+		slog.Error("Failed to initialize state manager", "error", err)
+		os.Exit(1)
+	}
+	defer stateManager.Close()
+
+	if cfg.Reconcile.Owner == config.OwnerAuto {
+		owner, err := resolveOwner(ctx, stateManager)
+		if err != nil {
+			slog.Error("Failed to resolve owner", "error", err)
+			os.Exit(1)
+		}
+		cfg.Reconcile.Owner = owner
+		slog.Info("Using derived owner", "owner", owner)
+	}
+
+	caddyClient := caddy.New(cfg.Caddy, metrics)
+
+	dp, err := newProvider(cfg.DNS, metrics)
+	if err != nil {
+		slog.Error("Failed to initialize DNS provider", "error", err)
+		os.Exit(1)
+	}
+
+	if len(cfg.DNS.Zones) == 0 {
+		cfg.DNS.Zones = dp.Zones()
+		if len(cfg.DNS.Zones) == 0 {
+			slog.Error("No DNS zones discovered from provider")
+			os.Exit(1)
+		}
+		slog.Info("Using discovered DNS zones", "zones", cfg.DNS.Zones)
+	}
+
+	// While migrating every write is mirrored to the secondary provider
+	var secondary provider.Provider
+	if cfg.DNS.Secondary.Enabled() {
+		if secondary, err = providerFor(cfg.DNS.SecondaryDNS(), metrics); err != nil {
+			slog.Error("Failed to initialize secondary DNS provider", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Mirroring writes to secondary DNS provider", "provider", cfg.DNS.Secondary.Provider)
+	}
+	if cfg.Chaos.Enabled() {
+		slog.Warn("Chaos enabled, provider requests fail and are delayed on purpose", "fail_rate", cfg.Chaos.FailRate, "delay_rate", cfg.Chaos.DelayRate, "max_delay", cfg.Chaos.MaxDelay)
+	}
+	engineProvider, mirrored := wrapProvider(dp, secondary, cfg.Chaos, metrics)
+
+	engine := reconcile.NewEngine(stateManager, engineProvider, cfg, metrics)
+	var hosts *hostlist.Loader
+	if cfg.HostList.Source != "" {
+		hosts = hostlist.New(cfg.HostList, cfg.Caddy.UserAgent)
+		if err := hosts.Load(ctx); err != nil {
+			slog.Error("Failed to load host list, syncs fail until it loads", "source", cfg.HostList.Source, "error", err)
+		}
+		engine.SetHostFilter(hosts)
+	}
+	if cfg.Reconcile.PolicyFile != "" {
+		policy, err := reconcile.LoadPolicy(cfg.Reconcile.PolicyFile)
+		if err != nil {
+			slog.Error("Failed to load policy", "file", cfg.Reconcile.PolicyFile, "error", err)
+			os.Exit(1)
+		}
+		engine.SetPolicy(policy)
+		slog.Info("Loaded policy", "file", cfg.Reconcile.PolicyFile, "rules", len(policy.Rules))
+	}
+	if len(cfg.Reconcile.PolicyHook) > 0 {
+		engine.SetHook(reconcile.ExecHook{Command: cfg.Reconcile.PolicyHook})
+		slog.Info("Asking policy hook about every planned operation", "command", cfg.Reconcile.PolicyHook[0])
+	}
+	if cfg.Reconcile.PreSync.Enabled() || cfg.Reconcile.PostSync.Enabled() {
+		engine.SetSyncHooks(
+			reconcile.NewSyncHook(cfg.Reconcile.PreSync, cfg.Caddy.UserAgent),
+			reconcile.NewSyncHook(cfg.Reconcile.PostSync, cfg.Caddy.UserAgent),
+		)
+		slog.Info("Running sync hooks around plan execution", "pre", cfg.Reconcile.PreSync.Enabled(), "post", cfg.Reconcile.PostSync.Enabled())
+	}
+	if cfg.PublicIP.Enabled {
+		engine.SetAddressDetector(publicip.New(cfg.PublicIP, cfg.Caddy.UserAgent))
+		slog.Info("Publishing the public ip of this machine for every host", "dual_stack", cfg.PublicIP.DualStack)
+	}
+	if migrated, err := engine.MigrateHeritage(ctx); err != nil {
+		slog.Warn("Failed to migrate heritage records", "migrated", migrated, "error", err)
+	} else if migrated > 0 {
+		slog.Info("Migrated heritage records to the current format", "count", migrated)
+	}
+
+	syncer := newSyncer(caddyClient, engine, metrics, cfg.Reconcile.Shadow, cfg.DNS.ZoneIntervals(), cfg.Reconcile.VerifyEvery)
+
+	// Set up HTTP server for metrics and admin endpoints
+	apiServer := api.New(engine, stateManager, metrics, cfg.API)
+	apiServer.SetSyncer(syncer)
+	if mirrored != nil {
+		apiServer.SetDivergence(mirrored)
+	}
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: apiServer.Handler(),
+	}
+
+	// Start http server in background
+	go func() {
+		slog.Info("Starting metrics server", "address", server.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics server failed", "error", err)
+		}
+	}()
+
+	build := version.Get()
+	slog.Info("Starting caddy-dns-sync service", "version", build.Version, "commit", build.Commit)
+
+	wg := &sync.WaitGroup{}
+	if cfg.Caddy.WatchInterval > 0 {
+		wg.Add(1)
+		go syncer.watchCaddy(ctx, wg, cfg.Caddy.WatchInterval)
+	}
+	wg.Add(1)
+	go syncer.runLoop(ctx, work, wg, cfg)
+	for _, z := range syncer.zones {
+		slog.Info("Syncing zone on its own schedule", "zone", z.zone, "interval", z.interval)
+		wg.Add(1)
+		go syncer.runZoneLoop(ctx, work, wg, z)
+	}
+	if hosts != nil {
+		wg.Add(1)
+		go hosts.Run(ctx, wg, cfg.HostList.Refresh)
+	}
+	if cfg.Snapshot.Interval > 0 {
+		wg.Add(1)
+		go runSnapshots(ctx, wg, stateManager, cfg.Snapshot, remote, metrics)
+	}
+	wg.Add(1)
+	go runRetention(ctx, wg, syncer, stateManager, metrics, cfg.Retention)
+	if cfg.Reconcile.TombstoneTTL > 0 {
+		wg.Add(1)
+		go runTombstones(ctx, wg, engine, min(cfg.Reconcile.TombstoneTTL, tombstoneInterval))
+	}
+	if mirrored != nil {
+		wg.Add(1)
+		go runSecondaryCheck(ctx, wg, mirrored, cfg.DNS.Zones, cfg.Reconcile.Owners(), cfg.DNS.Secondary)
+	}
+	wg.Add(1)
+	go runWatchdog(ctx, wg, syncer, cfg.MaxInterval(), cfg.Watchdog)
+	if _, err := notify.Send(notify.Ready); err != nil {
+		slog.Warn("Failed to notify systemd of readiness", "error", err)
+	}
+
+	// Dump diagnostics to the log on SIGUSR1
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for {
+			select {
+			case <-usr1:
+				dumpDiagnostics(ctx, syncer, engine, stateManager, cfg)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Handle graceful shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	slog.Info("Shutdown signal received", "drain", cfg.ShutdownDrain)
+	notify.Send(notify.Stopping)
+	cancel()
+
+	// Shutdown server with same context
+	serverShutdownCtx, cancelServer := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelServer()
+	if err := server.Shutdown(serverShutdownCtx); err != nil {
+		slog.Error("Metrics server shutdown error", "error", err)
+	}
+
+	// Let an in-flight sync finish and save state, then cancel it hard
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(cfg.ShutdownDrain):
+		slog.Warn("Shutdown drain period elapsed, cancelling in-flight sync", "drain", cfg.ShutdownDrain)
+		cancelWork()
+		<-drained
+	}
+	if cfg.Watchdog.HealthFile != "" {
+		os.Remove(cfg.Watchdog.HealthFile)
+	}
+	slog.Info("Service shutdown complete")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// resolveOwner returns the persisted instance id, generating it from the
+// hostname on first start. The random suffix keeps instances sharing a
+// hostname distinct, persisting it keeps the owner stable across restarts.
+func resolveOwner(ctx context.Context, sm state.Manager) (string, error) {
+	return sm.InstanceID(ctx, func() string {
+		suffix := randomHex(3)
+		if host := sanitizeOwner(hostname()); host != "" {
+			return host + "-" + suffix
+		}
+		return "instance-" + suffix
+	})
+}
+
+func hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// sanitizeOwner lowercases and keeps only characters safe in a TXT owner value
+func sanitizeOwner(s string) string {
+	s = strings.ToLower(strings.SplitN(s, ".", 2)[0])
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", n*2)
+	}
+	return hex.EncodeToString(b)
+}
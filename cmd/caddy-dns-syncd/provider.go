@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/chaos"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/cloudflare"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/delegate"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/desec"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/httpapi"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/mirror"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/namesilo"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/porkbun"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/rfc2136"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/scaleway"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/vultr"
+)
+
+// dnsProvider is a provider able to list the zones it manages
+type dnsProvider interface {
+	provider.Provider
+	Zones() []string
+}
+
+// wrapProvider returns the provider the engine writes through, mirroring
+// writes to secondary if set. Chaos faults are injected in front of the
+// mirror, so a failed request reaches neither provider.
+func wrapProvider(dp, secondary provider.Provider, cfg config.Chaos, m *metrics.Metrics) (provider.Provider, *mirror.Provider) {
+	wrapped := dp
+	var mirrored *mirror.Provider
+	if secondary != nil {
+		mirrored = mirror.New(dp, secondary, m)
+		wrapped = mirrored
+	}
+	if cfg.Enabled() {
+		wrapped = chaos.Wrap(wrapped, cfg, m)
+	}
+	return wrapped, mirrored
+}
+
+// newProvider returns the provider named by dns.provider, routing delegated
+// subzones to their own providers
+func newProvider(cfg config.DNS, m *metrics.Metrics) (dnsProvider, error) {
+	if len(cfg.Delegations) == 0 {
+		return providerFor(cfg, m)
+	}
+	// The parent provider does not host the delegated subzones
+	parentCfg := cfg
+	parentCfg.Zones = slices.DeleteFunc(slices.Clone(cfg.Zones), func(zone string) bool {
+		_, delegated := cfg.Delegations[zone]
+		return delegated
+	})
+	parent, err := providerFor(parentCfg, m)
+	if err != nil {
+		return nil, err
+	}
+	delegated := make(map[string]delegate.ZoneProvider, len(cfg.Delegations))
+	for subzone := range cfg.Delegations {
+		dp, err := providerFor(cfg.Delegated(subzone), m)
+		if err != nil {
+			return nil, fmt.Errorf("delegated zone %s: %w", subzone, err)
+		}
+		delegated[subzone] = dp
+	}
+	return delegate.New(parent, delegated), nil
+}
+
+func providerFor(cfg config.DNS, m *metrics.Metrics) (dnsProvider, error) {
+	switch cfg.Provider {
+	case "", config.ProviderCloudflare:
+		return cloudflare.New(cfg, m)
+	case config.ProviderPorkbun:
+		return porkbun.New(cfg, m)
+	case config.ProviderNameSilo:
+		return namesilo.New(cfg, m)
+	case config.ProviderVultr:
+		return vultr.New(cfg, m)
+	case config.ProviderScaleway:
+		return scaleway.New(cfg, m)
+	case config.ProviderDesec:
+		return desec.New(cfg, m)
+	case config.ProviderRFC2136:
+		return rfc2136.New(cfg, m)
+	case config.ProviderHTTP:
+		return httpapi.New(cfg, m)
+	}
+	return nil, fmt.Errorf("unknown dns provider %q", cfg.Provider)
+}
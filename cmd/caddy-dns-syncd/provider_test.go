@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/chaos"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/memory"
+)
+
+func TestWrapProviderChaosAndSecondary(t *testing.T) {
+	ctx := context.Background()
+	primary := memory.New(map[string][]provider.Record{"example.com": {}})
+	secondary := memory.New(map[string][]provider.Record{"example.com": {}})
+	// Every read fails, writes pass
+	cfg := config.Chaos{FailRate: 1, Ops: []string{"read"}}
+
+	wrapped, mirrored := wrapProvider(primary, secondary, cfg, metrics.New(false))
+	if mirrored == nil {
+		t.Fatal("Expected a mirror with a secondary provider")
+	}
+	if _, err := wrapped.GetRecords(ctx, "example.com"); !errors.Is(err, chaos.ErrInjected) {
+		t.Errorf("Expected chaos in front of the provider, got %v", err)
+	}
+	if err := wrapped.CreateRecord(ctx, "example.com", provider.Record{Name: "app", Type: "A", Data: "10.0.0.1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for name, p := range map[string]provider.Provider{"primary": primary, "secondary": secondary} {
+		if records, _ := p.GetRecords(ctx, "example.com"); len(records) != 1 {
+			t.Errorf("Expected the create written to the %s, got %+v", name, records)
+		}
+	}
+}
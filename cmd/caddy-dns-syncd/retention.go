@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// runRetention prunes the state store as cfg says and reports its size at
+// start and then every interval. Pruning waits for a running sync, which
+// would otherwise save the hosts it loaded before they were pruned.
+func runRetention(ctx context.Context, wg *sync.WaitGroup, s *syncer, sm state.Manager, m *metrics.Metrics, cfg config.Retention) {
+	defer wg.Done()
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	retention := state.Retention{
+		Audit:        cfg.Audit,
+		AuditEntries: cfg.AuditEntries,
+		StaleHosts:   cfg.StaleHosts,
+	}
+
+	for {
+		s.runMu.Lock()
+		pruned, err := state.Prune(ctx, sm, retention, time.Now())
+		s.runMu.Unlock()
+		m.AddStorePruned("audit", pruned.Audit)
+		m.AddStorePruned("domain", pruned.Hosts)
+		m.AddStorePruned("failure", pruned.Failures)
+		if err != nil {
+			slog.Error("Failed to prune state store", "error", err)
+		} else if pruned != (state.Pruned{}) {
+			slog.Info("Pruned state store", "audit", pruned.Audit, "hosts", pruned.Hosts, "failures", pruned.Failures)
+		}
+
+		size, err := sm.Size(ctx)
+		if err != nil {
+			slog.Error("Failed to measure state store", "error", err)
+		} else {
+			m.SetStoreSize(size.Keys, size.Bytes)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("Stopping state retention")
+			return
+		}
+	}
+}
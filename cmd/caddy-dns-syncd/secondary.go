@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/mirror"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+)
+
+// runSecondaryCheck compares the managed records of every zone at the
+// primary and secondary provider at start and then every check interval,
+// logging the records the secondary holds unlike the primary and copying them
+// when backfill is set
+func runSecondaryCheck(ctx context.Context, wg *sync.WaitGroup, m *mirror.Provider, zones, owners []string, cfg config.Secondary) {
+	defer wg.Done()
+	ticker := time.NewTicker(cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, zone := range zones {
+			divergence, err := m.Compare(ctx, zone, func(records []provider.Record) []provider.Record {
+				return reconcile.ManagedRecords(records, zone, owners...)
+			})
+			if err != nil {
+				slog.Error("Failed to compare secondary provider", "zone", zone, "error", err)
+				continue
+			}
+			if len(divergence) == 0 {
+				slog.Info("Secondary provider matches primary", "zone", zone)
+				continue
+			}
+			for _, d := range divergence {
+				slog.Warn("Secondary provider diverges from primary", "zone", zone, "name", d.Name, "record_type", d.Type, "primary", d.Primary, "secondary", d.Secondary)
+			}
+			if cfg.Backfill {
+				repaired := m.Repair(ctx, divergence)
+				slog.Info("Backfilled secondary provider", "zone", zone, "records", repaired)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("Stopping secondary provider check")
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// selfTestAddress is the data of the test record, from TEST-NET-1 so it never
+// routes anywhere
+const selfTestAddress = "192.0.2.1"
+
+// selfTest creates a uniquely named A and TXT record, reads them back from the
+// provider and optionally through dns, then deletes them, timing every step.
+// It validates credentials and connectivity without involving caddy or state.
+func selfTest(args []string) error {
+	fs := flag.NewFlagSet("self-test", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "config file")
+	zone := fs.String("zone", "", "zone to create the test records in, defaults to the only configured zone")
+	resolve := fs.Bool("resolve", false, "also wait for the records to resolve through dns")
+	resolver := fs.String("resolver", "", "dns server to resolve with, host:port, defaults to the system resolver")
+	timeout := fs.Duration("timeout", 2*time.Minute, "how long to wait for the records to be listed and to resolve")
+	verbose := fs.Bool("verbose", false, "log provider output to stderr")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*verbose {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	dp, err := newProvider(cfg.DNS, metrics.New(false))
+	if err != nil {
+		return fmt.Errorf("init dns provider: %w", err)
+	}
+	zones := dp.Zones()
+	if *zone == "" {
+		if len(zones) != 1 {
+			return fmt.Errorf("pass -zone, one of %v", zones)
+		}
+		*zone = zones[0]
+	}
+	if !slices.Contains(zones, *zone) {
+		return fmt.Errorf("zone %s is not configured, use one of %v", *zone, zones)
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := "caddy-dns-sync-selftest-" + hex.EncodeToString(suffix)
+	fqdn := provider.FQDN(name, *zone)
+	a := provider.Record{Name: fqdn, Type: "A", Data: selfTestAddress, TTL: time.Minute, Zone: *zone}
+	txt := provider.Record{Name: fqdn, Type: "TXT", Data: "caddy-dns-sync self-test " + time.Now().UTC().Format(time.RFC3339), TTL: time.Minute, Zone: *zone}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	ctx := context.Background()
+	start := time.Now()
+	step := func(desc string, fn func() error) error {
+		began := time.Now()
+		err := fn()
+		status := "ok"
+		if err != nil {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", status, desc, time.Since(began).Round(time.Millisecond))
+		if err != nil {
+			fmt.Fprintf(w, "\t%v\t\n", err)
+		}
+		return err
+	}
+
+	fmt.Fprintf(w, "Testing %s provider with %s\n", providerName(cfg.DNS.Provider), fqdn)
+	created := []provider.Record{}
+	err = func() error {
+		for _, r := range []provider.Record{a, txt} {
+			if err := step("create "+r.Type, func() error { return dp.CreateRecord(ctx, *zone, r) }); err != nil {
+				return err
+			}
+			created = append(created, r)
+		}
+		for _, r := range created {
+			if err := step("list "+r.Type, func() error { return waitListed(ctx, dp, r, true, *timeout) }); err != nil {
+				return err
+			}
+		}
+		if *resolve {
+			if err := step("resolve A", func() error { return waitResolved(ctx, *resolver, fqdn, "A", a.Data, *timeout) }); err != nil {
+				return err
+			}
+			if err := step("resolve TXT", func() error { return waitResolved(ctx, *resolver, fqdn, "TXT", txt.Data, *timeout) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+
+	// The records are removed whether or not the checks passed
+	for i := len(created) - 1; i >= 0; i-- {
+		r := created[i]
+		cleanup := step("delete "+r.Type, func() error {
+			if err := dp.DeleteRecord(ctx, *zone, r); err != nil {
+				return err
+			}
+			return waitListed(ctx, dp, r, false, *timeout)
+		})
+		if cleanup != nil {
+			fmt.Fprintf(w, "\tdelete %s %s by hand\t\n", r.Type, fqdn)
+		}
+		err = errors.Join(err, cleanup)
+	}
+	if err != nil {
+		return fmt.Errorf("self-test failed: %w", err)
+	}
+	fmt.Fprintf(w, "Self-test passed in %s\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// waitListed reads the records of want's name until the provider lists it, or
+// no longer does if listed is false, backing off between reads
+func waitListed(ctx context.Context, dp dnsProvider, want provider.Record, listed bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for backoff := 500 * time.Millisecond; ; backoff = min(backoff*2, 10*time.Second) {
+		records, err := findRecords(ctx, dp, want)
+		if err != nil {
+			return err
+		}
+		found := slices.ContainsFunc(records, func(r provider.Record) bool { return provider.SameValue(r, want) })
+		if found == listed {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			if listed {
+				return fmt.Errorf("record not listed after %s", timeout)
+			}
+			return fmt.Errorf("record still listed after %s", timeout)
+		}
+		time.Sleep(backoff)
+	}
+}
+
+// findRecords returns the records of a name and type, looked up by name when
+// the provider can
+func findRecords(ctx context.Context, dp dnsProvider, want provider.Record) ([]provider.Record, error) {
+	if finder, ok := dp.(provider.Finder); ok {
+		return finder.FindRecords(ctx, want.Zone, provider.FQDN(want.Name, want.Zone), want.Type)
+	}
+	records, err := dp.GetRecords(ctx, want.Zone)
+	if err != nil {
+		return nil, err
+	}
+	fqdn := provider.FQDN(want.Name, want.Zone)
+	return slices.DeleteFunc(records, func(r provider.Record) bool {
+		return r.Type != want.Type || provider.FQDN(r.Name, want.Zone) != fqdn
+	}), nil
+}
+
+// waitResolved queries dns until name resolves to data. Negative answers may
+// be cached for the zone's negative ttl, so this can take minutes.
+func waitResolved(ctx context.Context, server, name, recordType, data string, timeout time.Duration) error {
+	r := net.DefaultResolver
+	if server != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, server)
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var lastErr error
+	for {
+		var answers []string
+		var err error
+		if recordType == "TXT" {
+			answers, err = r.LookupTXT(ctx, name)
+		} else {
+			answers, err = r.LookupHost(ctx, name)
+		}
+		if slices.Contains(answers, data) {
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("%s did not resolve within %s: %w", recordType, timeout, lastErr)
+			}
+			return fmt.Errorf("%s did not resolve to %q within %s, got %v", recordType, data, timeout, answers)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func providerName(name string) string {
+	if name == "" {
+		return config.ProviderCloudflare
+	}
+	return name
+}
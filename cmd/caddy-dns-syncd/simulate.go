@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/export"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/memory"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+	"github.com/evanofslack/caddy-dns-sync/internal/source/caddy"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+type simulation struct {
+	Create     int                     `json:"create"`
+	Update     int                     `json:"update"`
+	Delete     int                     `json:"delete"`
+	Changes    []reconcile.Explanation `json:"changes"`
+	Violations []reconcile.Violation   `json:"violations"`
+}
+
+// simulate prints the plan the engine would produce for a caddy config
+// against zone files, without contacting caddy or the DNS provider
+func simulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "config file")
+	zoneFiles := fs.String("zonefile", "", "comma separated zone files holding the current records, as written by export")
+	caddyPath := fs.String("caddy", "", "caddy config json, the full config or apps/http/servers")
+	statePath := fs.String("state", "", "previous state, the json served by /state, defaults to no known hosts")
+	owner := fs.String("owner", "", "owner id, required when reconcile.owner is auto")
+	policyPath := fs.String("policy", "", "policy file the plan must satisfy, defaults to reconcile.policyFile")
+	format := fs.String("format", "text", "output format, text, json or markdown")
+	postComment := fs.Bool("post-github-comment", false, "post the plan as markdown to the pull request of a github actions run")
+	pr := fs.Int("github-pr", 0, "pull request to comment on, defaults to the one in GITHUB_EVENT_PATH")
+	verbose := fs.Bool("verbose", false, "log engine output to stderr")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *caddyPath == "" {
+		return fmt.Errorf("-caddy is required")
+	}
+	if *format != "text" && *format != "json" && *format != "markdown" {
+		return fmt.Errorf("unknown format %q, use text, json or markdown", *format)
+	}
+	if !*verbose {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if *owner != "" {
+		cfg.Reconcile.Owner = *owner
+	}
+	if cfg.Reconcile.Owner == config.OwnerAuto {
+		return fmt.Errorf("reconcile.owner is auto, pass the owner id of the instance with -owner")
+	}
+	// Only ever plan, whatever the config says
+	cfg.Reconcile.DryRun = true
+	cfg.Reconcile.Shadow = false
+	cfg.Reconcile.WriteWindows = nil
+
+	records := make(map[string][]provider.Record)
+	if *zoneFiles != "" {
+		for _, path := range strings.Split(*zoneFiles, ",") {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			zone, zoneRecords, err := export.ParseZoneFile(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("parse %s: %w", path, err)
+			}
+			records[zone] = append(records[zone], zoneRecords...)
+		}
+	}
+	dp := memory.New(records)
+	if len(cfg.DNS.Zones) == 0 {
+		cfg.DNS.Zones = dp.Zones()
+	}
+	if len(cfg.DNS.Zones) == 0 {
+		return fmt.Errorf("no zones, set dns.zones or pass -zonefile")
+	}
+
+	body, err := os.ReadFile(*caddyPath)
+	if err != nil {
+		return err
+	}
+	m := metrics.New(false)
+	domains, err := caddy.DomainsFromConfig(cfg.Caddy, m, body)
+	if err != nil {
+		return fmt.Errorf("parse caddy config: %w", err)
+	}
+
+	// The engine keeps its state in badger, a throwaway store is enough here
+	dir, err := os.MkdirTemp("", "caddy-dns-sync-simulate-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	sm, err := state.New(dir, m)
+	if err != nil {
+		return fmt.Errorf("open state: %w", err)
+	}
+	defer sm.Close()
+
+	ctx := context.Background()
+	if *statePath != "" {
+		data, err := os.ReadFile(*statePath)
+		if err != nil {
+			return err
+		}
+		var prev state.State
+		if err := json.Unmarshal(data, &prev); err != nil {
+			return fmt.Errorf("parse %s: %w", *statePath, err)
+		}
+		if err := sm.SaveState(ctx, prev); err != nil {
+			return fmt.Errorf("save state: %w", err)
+		}
+	}
+
+	engine := reconcile.NewEngine(sm, dp, cfg, m)
+	if *policyPath == "" {
+		*policyPath = cfg.Reconcile.PolicyFile
+	}
+	if *policyPath != "" {
+		policy, err := reconcile.LoadPolicy(*policyPath)
+		if err != nil {
+			return fmt.Errorf("load policy: %w", err)
+		}
+		engine.SetPolicy(policy)
+	}
+	if len(cfg.Reconcile.PolicyHook) > 0 {
+		engine.SetHook(reconcile.ExecHook{Command: cfg.Reconcile.PolicyHook})
+	}
+	// Violations are reported with the plan below
+	if _, err := engine.Reconcile(ctx, domains); err != nil && !errors.Is(err, reconcile.ErrPolicyViolation) {
+		return fmt.Errorf("reconcile: %w", err)
+	}
+	plan := engine.LastPlan()
+	result := simulation{
+		Create:     len(plan.Create),
+		Update:     len(plan.Update),
+		Delete:     len(plan.Delete),
+		Changes:    plan.Explain,
+		Violations: plan.Violations,
+	}
+	if result.Changes == nil {
+		result.Changes = []reconcile.Explanation{}
+	}
+	if result.Violations == nil {
+		result.Violations = []reconcile.Violation{}
+	}
+	// A plan breaking the policy fails the command, so CI can gate on it
+	var violated error
+	if len(result.Violations) > 0 {
+		violated = fmt.Errorf("%w: %d violations", reconcile.ErrPolicyViolation, len(result.Violations))
+	}
+
+	if *postComment || *format == "markdown" {
+		report := reconcile.Report{
+			Changes:    result.Changes,
+			Violations: result.Violations,
+			Conflicts:  plan.Contested,
+			Deferred:   plan.Deferred,
+		}
+		b := &strings.Builder{}
+		if err := report.WriteMarkdown(b); err != nil {
+			return err
+		}
+		if *postComment {
+			if err := postGitHubComment(ctx, b.String(), *pr); err != nil {
+				return fmt.Errorf("post github comment: %w", err)
+			}
+		}
+		if *format == "markdown" {
+			fmt.Print(b.String())
+			return violated
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+		return violated
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, ex := range result.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", ex.Op, ex.Zone, ex.Name, ex.Type, ex.Data, ex.Reason)
+	}
+	w.Flush()
+	for _, f := range engine.Filtered() {
+		fmt.Printf("Filtered %s: %s\n", f.Host, f.Reason)
+	}
+	fmt.Printf("Plan: %d to create, %d to update, %d to delete\n", result.Create, result.Update, result.Delete)
+	for _, v := range result.Violations {
+		if v.Op == "" {
+			fmt.Printf("Violation %s: %s\n", v.Rule, v.Message)
+			continue
+		}
+		fmt.Printf("Violation %s: %s %s %s in %s: %s\n", v.Rule, v.Op, v.Type, v.Name, v.Zone, v.Message)
+	}
+	return violated
+}
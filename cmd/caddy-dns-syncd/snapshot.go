@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// runSnapshots backs the state store up every interval, keeping the newest
+// snapshots so a store corrupted by an unclean shutdown can be restored. With
+// a remote every snapshot is uploaded too, so state outlives the node.
+func runSnapshots(ctx context.Context, wg *sync.WaitGroup, sm state.Manager, cfg config.Snapshot, remote state.Remote, m *metrics.Metrics) {
+	defer wg.Done()
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("Stopping state snapshots")
+			return
+		}
+
+		path, err := state.WriteSnapshot(ctx, sm, cfg.Dir, cfg.Keep)
+		if err != nil {
+			slog.Error("Failed to snapshot state", "dir", cfg.Dir, "error", err)
+			continue
+		}
+		slog.Info("Wrote state snapshot", "path", path)
+		if remote == nil {
+			continue
+		}
+		key, err := state.UploadSnapshot(ctx, remote, path, cfg.Remote.Prefix, cfg.Remote.Keep)
+		// A key is returned once uploaded, even if pruning old snapshots failed
+		m.IncSnapshotUpload(key != "")
+		if err != nil {
+			slog.Error("Failed to upload state snapshot", "bucket", cfg.Remote.Bucket, "key", key, "error", err)
+			continue
+		}
+		slog.Info("Uploaded state snapshot", "bucket", cfg.Remote.Bucket, "key", key)
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/source/caddy"
+)
+
+type syncer struct {
+	client   caddy.Client
+	engine   reconcile.Engine
+	metrics  *metrics.Metrics
+	trigger  chan struct{}
+	loop     loopState   // of runLoop
+	shadow   bool        // compare against the live zones every sync, even if caddy is unchanged
+	verify   int         // syncs of each loop between consistency checks, 0 to never check
+	deferred *time.Timer // triggers a sync when the next write window opens
+	runMu    sync.Mutex  // held while a sync runs, scheduled or scoped
+
+	zones []*zoneSchedule      // zones synced on their own schedule
+	rest  *reconcile.Partition // zones synced by runLoop, nil for every zone
+
+	mu       sync.Mutex
+	lastSync time.Time // end of the last completed sync
+	lastErr  error     // error of the last sync, if it failed
+	beat     time.Time // last progress of the sync loop, the start or end of a sync
+}
+
+// zoneSchedule is a zone synced every interval, apart from the other zones
+type zoneSchedule struct {
+	zone     string
+	interval time.Duration
+	trigger  chan struct{}
+	loop     loopState
+
+	// guarded by the syncer's mu, like those of runLoop
+	lastSync time.Time
+	lastErr  error
+	beat     time.Time
+}
+
+// loopState is what a sync loop carries from one sync to the next
+type loopState struct {
+	lastHash string // caddy config hash of the last fully applied sync
+	runs     int    // syncs run, no-op ones included
+}
+
+// newSyncer returns a syncer of every zone, except those with an interval
+// of their own in zoneIntervals, which are synced by runZoneLoop. Each loop
+// cross checks its zones on its first sync and every verifyEvery after it.
+func newSyncer(client caddy.Client, engine reconcile.Engine, metrics *metrics.Metrics, shadow bool, zoneIntervals map[string]time.Duration, verifyEvery int) *syncer {
+	s := &syncer{
+		client:  client,
+		engine:  engine,
+		metrics: metrics,
+		trigger: make(chan struct{}, 1),
+		shadow:  shadow,
+		verify:  verifyEvery,
+	}
+	if len(zoneIntervals) > 0 {
+		s.rest = &reconcile.Partition{Rest: true}
+		for zone, interval := range zoneIntervals {
+			s.zones = append(s.zones, &zoneSchedule{zone: zone, interval: interval, trigger: make(chan struct{}, 1)})
+			s.rest.Zones = append(s.rest.Zones, zone)
+		}
+		slices.SortFunc(s.zones, func(a, b *zoneSchedule) int { return strings.Compare(a.zone, b.zone) })
+		slices.Sort(s.rest.Zones)
+	}
+	return s
+}
+
+// runLoop syncs every interval until ctx is done. Syncs run with work, which
+// outlives ctx on shutdown so an in-flight sync can drain instead of being
+// cut off between the delete and create of a host. A sync taking longer than
+// the interval is handled as cfg.SyncOverrun says.
+func (s *syncer) runLoop(ctx, work context.Context, wg *sync.WaitGroup, cfg *config.Config) {
+	defer wg.Done()
+	interval := cfg.SyncInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	wait := interval
+	idle := 0
+	s.metrics.SetSyncInterval(interval)
+
+	for {
+		start := time.Now()
+		changed, err := s.performSync(work, s.rest, &s.loop, &s.beat)
+		if err != nil {
+			slog.Error("Sync operation failed", "error", err)
+		}
+		s.mu.Lock()
+		s.lastSync, s.lastErr = time.Now(), err
+		s.beat = s.lastSync
+		s.mu.Unlock()
+
+		// Never start another sync once shutdown began
+		if ctx.Err() != nil {
+			slog.Info("Stopping sync loop")
+			return
+		}
+
+		if cfg.Adaptive.Enabled && err == nil {
+			if changed {
+				idle = 0
+			} else {
+				idle++
+			}
+			if next := adaptInterval(cfg.Adaptive, interval, changed, idle); next != interval {
+				slog.Info("Adapting sync interval", "interval", next, "changed", changed, "idleRuns", idle)
+				interval = next
+				s.metrics.SetSyncInterval(interval)
+			}
+		}
+		wait = s.handleOverrun(ticker, time.Since(start), interval, wait, cfg.SyncOverrun)
+
+		select {
+		case <-ticker.C:
+			continue
+		case <-s.trigger:
+			// Restart the interval from the triggered sync
+			ticker.Reset(wait)
+			continue
+		case <-ctx.Done():
+			slog.Info("Stopping sync loop")
+			return
+		}
+	}
+}
+
+// adaptInterval returns the interval until the next sync, the minimum after
+// a sync with changes and twice the current one, up to the maximum, after
+// every cfg.IdleRuns syncs in a row without any
+func adaptInterval(cfg config.Adaptive, current time.Duration, changed bool, idle int) time.Duration {
+	if changed {
+		return cfg.Min
+	}
+	if idle > 0 && idle%cfg.IdleRuns == 0 {
+		return min(current*2, cfg.Max)
+	}
+	return current
+}
+
+// handleOverrun records how far a sync ran past the interval and adjusts the
+// ticker for the syncs it delayed, returning the wait until the next sync
+func (s *syncer) handleOverrun(ticker *time.Ticker, elapsed, interval, wait time.Duration, overrun string) time.Duration {
+	lag := max(elapsed-interval, 0)
+	s.metrics.SetSyncLag(lag)
+	if lag == 0 {
+		if wait != interval {
+			ticker.Reset(interval)
+		}
+		return interval
+	}
+
+	switch overrun {
+	case config.OverrunSkip, config.OverrunExtend:
+		// Drop the tick that fired during the sync
+		select {
+		case <-ticker.C:
+		default:
+		}
+		if overrun == config.OverrunExtend {
+			ticker.Reset(elapsed)
+			slog.Warn("Sync took longer than the interval, extending it", "duration", elapsed.Round(time.Millisecond), "interval", interval)
+			s.metrics.IncSyncOverrun("extended")
+			return elapsed
+		}
+		ticker.Reset(interval)
+		slog.Warn("Sync took longer than the interval, skipping missed syncs", "duration", elapsed.Round(time.Millisecond), "interval", interval)
+		s.metrics.IncSyncOverrun("skipped")
+		return interval
+	default:
+		// The ticker kept one tick from during the sync, which runs right away
+		slog.Warn("Sync took longer than the interval, running the next sync now", "duration", elapsed.Round(time.Millisecond), "interval", interval)
+		s.metrics.IncSyncOverrun("queued")
+		return wait
+	}
+}
+
+// watchCaddy polls the caddy config hash and triggers a sync when it changes,
+// catching restarts and config reloads between scheduled syncs
+func (s *syncer) watchCaddy(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := ""
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "Stopping caddy watcher")
+			return
+		}
+
+		hash, err := s.client.ConfigHash(ctx)
+		if err != nil {
+			slog.DebugContext(ctx, "Failed to get caddy config hash", "error", err)
+			continue
+		}
+		if last != "" && hash != last {
+			slog.InfoContext(ctx, "Caddy config change detected, triggering sync")
+			s.metrics.IncCaddyConfigChange()
+			s.triggerSync()
+		}
+		last = hash
+	}
+}
+
+// runZoneLoop syncs the hosts of a zone every interval of its own until ctx
+// is done, like runLoop. Ticks missed by a slow sync are skipped.
+func (s *syncer) runZoneLoop(ctx, work context.Context, wg *sync.WaitGroup, z *zoneSchedule) {
+	defer wg.Done()
+	ticker := time.NewTicker(z.interval)
+	defer ticker.Stop()
+	p := &reconcile.Partition{Zones: []string{z.zone}}
+
+	for {
+		_, err := s.performSync(work, p, &z.loop, &z.beat)
+		if err != nil {
+			slog.Error("Zone sync operation failed", "zone", z.zone, "error", err)
+		}
+		s.mu.Lock()
+		z.lastSync, z.lastErr = time.Now(), err
+		z.beat = z.lastSync
+		s.mu.Unlock()
+		if ctx.Err() != nil {
+			slog.Info("Stopping zone sync loop", "zone", z.zone)
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-z.trigger:
+			ticker.Reset(z.interval)
+		case <-ctx.Done():
+			slog.Info("Stopping zone sync loop", "zone", z.zone)
+			return
+		}
+	}
+}
+
+// triggerSync runs the syncs of every zone right away
+func (s *syncer) triggerSync() {
+	pend(s.trigger)
+	for _, z := range s.zones {
+		pend(z.trigger)
+	}
+}
+
+func pend(trigger chan struct{}) {
+	select {
+	case trigger <- struct{}{}:
+	default: // sync already pending
+	}
+}
+
+// performSync syncs the caddy hosts of partition p, every host if nil,
+// reporting whether the sync wrote records or left changes for a later sync.
+// loop is the state of the loop syncing p, beat its heartbeat, set once the
+// sync holds runMu so waiting out another loop's sync is not a stall.
+func (s *syncer) performSync(ctx context.Context, p *reconcile.Partition, loop *loopState, beat *time.Time) (bool, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	s.mu.Lock()
+	*beat = time.Now()
+	s.mu.Unlock()
+	id := runid.New()
+	ctx = runid.WithID(ctx, id)
+	if p != nil && !p.Rest {
+		slog.InfoContext(ctx, "Starting sync operation", "zones", p.Zones)
+	} else {
+		slog.InfoContext(ctx, "Starting sync operation")
+	}
+	start := time.Now()
+	defer func() {
+		s.metrics.SetSyncDuration(time.Since(start), id)
+	}()
+
+	// The zones of p are cross checked once the sync ended, also when caddy
+	// was unchanged and reconcile skipped
+	var domains []source.DomainConfig
+	if verifyDue(loop.runs, s.verify) {
+		defer func() { s.verifyConsistency(ctx, p, domains) }()
+	}
+	loop.runs++
+
+	domains, hash, err := s.client.DomainsSince(ctx, loop.lastHash)
+	if errors.Is(err, caddy.ErrUnchanged) {
+		slog.InfoContext(ctx, "Caddy config unchanged since last sync, skipping reconcile")
+		s.metrics.IncSyncNoop()
+		return false, nil
+	}
+	if err != nil {
+		s.metrics.IncSyncRun(false)
+		return false, err
+	}
+
+	slog.InfoContext(ctx, "Reconciling domains", "count", len(domains))
+	var results reconcile.Results
+	if p != nil {
+		results, err = s.engine.ReconcilePartition(ctx, domains, *p)
+	} else {
+		results, err = s.engine.Reconcile(ctx, domains)
+	}
+	if err != nil {
+		s.metrics.IncSyncRun(false)
+		return false, err
+	}
+
+	// Only skip future runs once everything from this config has been applied,
+	// the live zones can drift without caddy changing in shadow mode
+	if len(results.Failures) == 0 && !results.Paused && !results.Held && results.Deferred.IsZero() && results.Limited == 0 && !s.shadow {
+		loop.lastHash = hash
+	} else {
+		loop.lastHash = ""
+	}
+
+	if !results.Deferred.IsZero() {
+		s.deferUntil(results.Deferred)
+	}
+
+	slog.InfoContext(ctx, "Sync completed",
+		"created", len(results.Created),
+		"updated", len(results.Updated),
+		"deleted", len(results.Deleted))
+	s.metrics.IncSyncRun(true)
+
+	changed := len(results.Created)+len(results.Updated)+len(results.Deleted)+len(results.Failures)+results.Limited > 0
+	return changed, nil
+}
+
+// verifyDue reports whether a loop's sync after runs syncs is cross checked,
+// the first and one of every verifyEvery after it
+func verifyDue(runs, verifyEvery int) bool {
+	return verifyEvery > 0 && runs%verifyEvery == 0
+}
+
+// verifyConsistency cross checks caddy, state and the live zones of partition
+// p, every zone if nil, reading the caddy hosts if the sync did not
+func (s *syncer) verifyConsistency(ctx context.Context, p *reconcile.Partition, domains []source.DomainConfig) {
+	if domains == nil {
+		var err error
+		if domains, _, err = s.client.DomainsSince(ctx, ""); err != nil {
+			slog.ErrorContext(ctx, "Failed to get caddy hosts for consistency check", "error", err)
+			return
+		}
+	}
+	if _, err := s.engine.VerifyConsistency(ctx, domains, p); err != nil {
+		slog.ErrorContext(ctx, "Consistency check failed", "error", err)
+	}
+}
+
+// SyncScope runs a sync of the hosts in scope right away, checking their
+// records against the live zones even if caddy is unchanged. Other hosts are
+// left for the scheduled syncs.
+func (s *syncer) SyncScope(ctx context.Context, scope reconcile.Scope) (reconcile.Results, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if runid.FromContext(ctx) == "" {
+		ctx = runid.WithID(ctx, runid.New())
+	}
+	slog.InfoContext(ctx, "Starting scoped sync operation", "host", scope.Host, "zone", scope.Zone)
+
+	domains, _, err := s.client.DomainsSince(ctx, "")
+	if err != nil {
+		s.metrics.IncSyncRun(false)
+		return reconcile.Results{}, err
+	}
+	results, err := s.engine.ReconcileScope(ctx, domains, scope)
+	if err != nil {
+		s.metrics.IncSyncRun(false)
+		return results, err
+	}
+	if !results.Deferred.IsZero() {
+		s.deferUntil(results.Deferred)
+	}
+	slog.InfoContext(ctx, "Scoped sync completed",
+		"created", len(results.Created),
+		"updated", len(results.Updated),
+		"deleted", len(results.Deleted))
+	s.metrics.IncSyncRun(true)
+	return results, nil
+}
+
+// deferUntil triggers a sync at t, replacing any earlier deferred sync
+func (s *syncer) deferUntil(t time.Time) {
+	if s.deferred != nil {
+		s.deferred.Stop()
+	}
+	s.deferred = time.AfterFunc(time.Until(t), s.triggerSync)
+}
+
+// heartbeat returns the last time the sync loop made progress, zero before
+// the loop started
+func (s *syncer) heartbeat() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.beat
+}
+
+// stalled returns the sync loop without progress for longer than its
+// interval plus timeout, "" for runLoop, which is given interval as it may
+// adapt its own, or the zone of a zone loop. Loops not started are skipped.
+func (s *syncer) stalled(interval, timeout time.Duration) (string, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stale := time.Since(s.beat); !s.beat.IsZero() && stale > interval+timeout {
+		return "", stale, true
+	}
+	for _, z := range s.zones {
+		if stale := time.Since(z.beat); !z.beat.IsZero() && stale > z.interval+timeout {
+			return z.zone, stale, true
+		}
+	}
+	return "", 0, false
+}
+
+// status returns when the last sync completed and its error
+func (s *syncer) status() (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSync, s.lastErr
+}
+
+// zoneStatus returns when the last sync of each zone on its own schedule
+// completed and its error
+func (s *syncer) zoneStatus() map[string]syncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := make(map[string]syncStatus, len(s.zones))
+	for _, z := range s.zones {
+		zs := syncStatus{LastSync: z.lastSync}
+		if z.lastErr != nil {
+			zs.LastSyncError = z.lastErr.Error()
+		}
+		status[z.zone] = zs
+	}
+	return status
+}
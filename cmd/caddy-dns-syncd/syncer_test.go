@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVerifyDue(t *testing.T) {
+	var due []bool
+	for runs := range 5 {
+		due = append(due, verifyDue(runs, 3))
+	}
+	if expected := []bool{true, false, false, true, false}; !reflect.DeepEqual(due, expected) {
+		t.Errorf("Due = %v, want %v", due, expected)
+	}
+	if verifyDue(0, 0) {
+		t.Error("Expected no checks with verifyEvery unset")
+	}
+}
+
+func TestStalled(t *testing.T) {
+	s := newSyncer(nil, nil, nil, false, map[string]time.Duration{"lab.net": time.Minute}, 0)
+	now := time.Now()
+	s.beat = now
+	s.zones[0].beat = now
+	if _, _, hung := s.stalled(time.Hour, 10*time.Minute); hung {
+		t.Error("Expected no loop stalled")
+	}
+	s.zones[0].beat = now.Add(-20 * time.Minute)
+	if zone, _, hung := s.stalled(time.Hour, 10*time.Minute); !hung || zone != "lab.net" {
+		t.Errorf("Expected the lab.net loop stalled, got %q %v", zone, hung)
+	}
+	s.beat = now.Add(-2 * time.Hour)
+	if zone, _, hung := s.stalled(time.Hour, 10*time.Minute); !hung || zone != "" {
+		t.Errorf("Expected the main loop stalled, got %q %v", zone, hung)
+	}
+}
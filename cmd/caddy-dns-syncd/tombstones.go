@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// tombstoneInterval is how often expired tombstones are looked for
+const tombstoneInterval = time.Hour
+
+type tombstoneCollector interface {
+	CollectTombstones(ctx context.Context) (int, error)
+}
+
+// runTombstones deletes tombstones older than tombstoneTTL every interval,
+// as syncs skip reconciling while the caddy config is unchanged
+func runTombstones(ctx context.Context, wg *sync.WaitGroup, collector tombstoneCollector, interval time.Duration) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("Stopping tombstone collection")
+			return
+		}
+
+		collected, err := collector.CollectTombstones(ctx)
+		if err != nil {
+			slog.Error("Failed to collect tombstones", "collected", collected, "error", err)
+			continue
+		}
+		if collected > 0 {
+			slog.Info("Collected expired tombstones", "count", collected)
+		}
+	}
+}
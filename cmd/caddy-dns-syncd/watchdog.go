@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/notify"
+)
+
+// defaultWatchdogCheck is how often the sync loop heartbeat is checked when
+// systemd does not ask for more frequent keepalives
+const defaultWatchdogCheck = 15 * time.Second
+
+// runWatchdog checks the sync loop heartbeats, sending systemd keepalives and
+// touching the health file while they are fresh. A loop without progress for
+// its sync interval plus the timeout, zone loops included, exits the process
+// so the supervisor restarts it, the plan journal recovers an interrupted sync.
+func runWatchdog(ctx context.Context, wg *sync.WaitGroup, s *syncer, interval time.Duration, cfg config.Watchdog) {
+	defer wg.Done()
+	check := defaultWatchdogCheck
+	if keepalive := notify.WatchdogInterval(); keepalive > 0 && keepalive/2 < check {
+		check = keepalive / 2
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("Stopping watchdog")
+			return
+		}
+
+		if s.heartbeat().IsZero() {
+			continue
+		}
+		if zone, stale, hung := s.stalled(interval, cfg.Timeout); cfg.Timeout > 0 && hung {
+			if zone != "" {
+				slog.Error("Zone sync loop hung, exiting for restart", "zone", zone, "since", stale.Round(time.Second), "timeout", cfg.Timeout)
+				notify.Send(notify.Status("zone sync loop hung: " + zone))
+			} else {
+				slog.Error("Sync loop hung, exiting for restart", "since", stale.Round(time.Second), "timeout", cfg.Timeout)
+				notify.Send(notify.Status("sync loop hung"))
+			}
+			os.Exit(1)
+		}
+		if _, err := notify.Send(notify.Watchdog); err != nil {
+			slog.Warn("Failed to send watchdog keepalive", "error", err)
+		}
+		if cfg.HealthFile != "" {
+			if err := touch(cfg.HealthFile); err != nil {
+				slog.Warn("Failed to touch health file", "path", cfg.HealthFile, "error", err)
+			}
+		}
+	}
+}
+
+// touch sets the modification time of path to now, creating it if missing
+func touch(path string) error {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
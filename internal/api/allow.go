@@ -0,0 +1,50 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// allowList holds the networks allowed to reach a handler, any if empty
+type allowList []netip.Prefix
+
+// contains reports whether the address of a request's remote end is allowed.
+// Forwarding headers are ignored, they can be set by any client.
+func (a allowList) contains(remoteAddr string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range a {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOnly wraps h so it is only served to clients in list
+func allowOnly(list allowList, h http.Handler) http.Handler {
+	if len(list) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !list.contains(r.RemoteAddr) {
+			slog.Warn("Rejected api request from disallowed address", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeError(w, http.StatusForbidden, errors.New("address not allowed"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+)
+
+func TestAllowOnly(t *testing.T) {
+	prefixes, err := config.ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		list   allowList
+		remote string
+		want   int
+	}{
+		{"no list", nil, "203.0.113.1:1234", http.StatusOK},
+		{"in network", prefixes, "10.1.2.3:1234", http.StatusOK},
+		{"single host", prefixes, "192.168.1.5:1234", http.StatusOK},
+		{"other host", prefixes, "192.168.1.6:1234", http.StatusForbidden},
+		{"ipv6 network", prefixes, "[fd00::1]:1234", http.StatusOK},
+		{"ipv4 mapped", prefixes, "[::ffff:10.0.0.1]:1234", http.StatusOK},
+		{"outside", prefixes, "203.0.113.1:1234", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set("X-Forwarded-For", "10.0.0.1")
+			rec := httptest.NewRecorder()
+			allowOnly(tt.list, ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d but got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	if _, err := config.ParseCIDRs([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("Expected error for invalid cidr")
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+)
+
+// allows reports whether a token with role may use endpoints requiring need,
+// admin tokens can also read
+func allows(role, need string) bool {
+	return role == need || role == config.RoleAdmin
+}
+
+// require wraps h so it is only served to requests bearing a token with the
+// needed role. Every request is served when no tokens are configured.
+func (s *Server) require(need string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.tokens) == 0 {
+			h(w, r)
+			return
+		}
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || presented == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing bearer token"))
+			return
+		}
+		token, ok := s.lookupToken(presented)
+		if !ok {
+			slog.Warn("Rejected api request with unknown token", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+		if !allows(token.Role, need) {
+			slog.Warn("Rejected api request without role", "token", token.Name, "role", token.Role, "need", need, "method", r.Method, "path", r.URL.Path)
+			writeError(w, http.StatusForbidden, errors.New("token lacks the "+need+" role"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// lookupToken compares presented against every token in constant time
+func (s *Server) lookupToken(presented string) (config.APIToken, bool) {
+	var found config.APIToken
+	ok := false
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(presented)) == 1 {
+			found, ok = t, true
+		}
+	}
+	return found, ok
+}
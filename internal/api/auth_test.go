@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+)
+
+func TestRequire(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	protected := &Server{tokens: []config.APIToken{
+		{Name: "grafana", Token: "read-token", Role: config.RoleRead},
+		{Name: "ops", Token: "admin-token", Role: config.RoleAdmin},
+	}}
+
+	tests := []struct {
+		name   string
+		server *Server
+		need   string
+		header string
+		want   int
+	}{
+		{"no tokens configured", &Server{}, config.RoleAdmin, "", http.StatusOK},
+		{"missing token", protected, config.RoleRead, "", http.StatusUnauthorized},
+		{"not bearer", protected, config.RoleRead, "Basic read-token", http.StatusUnauthorized},
+		{"unknown token", protected, config.RoleRead, "Bearer nope", http.StatusUnauthorized},
+		{"read token reads", protected, config.RoleRead, "Bearer read-token", http.StatusOK},
+		{"read token denied admin", protected, config.RoleAdmin, "Bearer read-token", http.StatusForbidden},
+		{"admin token reads", protected, config.RoleRead, "Bearer admin-token", http.StatusOK},
+		{"admin token admin", protected, config.RoleAdmin, "Bearer admin-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/pause", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			tt.server.require(tt.need, ok)(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d but got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
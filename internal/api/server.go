@@ -0,0 +1,385 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/mirror"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// Syncer runs syncs on demand, between the scheduled ones
+type Syncer interface {
+	SyncScope(ctx context.Context, scope reconcile.Scope) (reconcile.Results, error)
+}
+
+// DivergenceReporter reports the records a secondary provider holds unlike
+// the primary
+type DivergenceReporter interface {
+	Divergence() []mirror.Divergence
+}
+
+type Server struct {
+	engine       reconcile.Engine
+	syncer       Syncer
+	divergence   DivergenceReporter
+	stateManager state.Manager
+	metrics      *metrics.Metrics
+	tokens       []config.APIToken // required on admin endpoints if set
+	allowed      allowList         // clients allowed to reach admin endpoints
+	allowMetrics allowList         // clients allowed to reach /metrics
+}
+
+func New(engine reconcile.Engine, sm state.Manager, metrics *metrics.Metrics, cfg config.API) *Server {
+	allowed, err := config.ParseCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		slog.Error("Invalid api allowed cidrs, denying every address", "error", err)
+		allowed = allowList{netip.Prefix{}}
+	}
+	allowMetrics, err := config.ParseCIDRs(cfg.MetricsAllowedCIDRs)
+	if err != nil {
+		slog.Error("Invalid metrics allowed cidrs, denying every address", "error", err)
+		allowMetrics = allowList{netip.Prefix{}}
+	}
+	return &Server{
+		engine:       engine,
+		stateManager: sm,
+		metrics:      metrics,
+		tokens:       cfg.Tokens,
+		allowed:      allowed,
+		allowMetrics: allowMetrics,
+	}
+}
+
+// SetSyncer enables POST /sync
+func (s *Server) SetSyncer(syncer Syncer) {
+	s.syncer = syncer
+}
+
+// SetDivergence enables GET /divergence
+func (s *Server) SetDivergence(reporter DivergenceReporter) {
+	s.divergence = reporter
+}
+
+func (s *Server) Handler() http.Handler {
+	admin := http.NewServeMux()
+	admin.HandleFunc("GET /state", s.require(config.RoleRead, s.handleState))
+	admin.HandleFunc("GET /plan", s.require(config.RoleRead, s.handlePlan))
+	admin.HandleFunc("GET /audit", s.require(config.RoleRead, s.handleAudit))
+	admin.HandleFunc("GET /drift", s.require(config.RoleRead, s.handleDrift))
+	admin.HandleFunc("GET /consistency", s.require(config.RoleRead, s.handleConsistency))
+	admin.HandleFunc("GET /records", s.require(config.RoleRead, s.handleRecords))
+	admin.HandleFunc("GET /divergence", s.require(config.RoleRead, s.handleDivergence))
+	admin.HandleFunc("GET /failures", s.require(config.RoleRead, s.handleFailures))
+	admin.HandleFunc("POST /sync", s.require(config.RoleAdmin, s.handleSync))
+	admin.HandleFunc("POST /pause", s.require(config.RoleAdmin, s.handlePause(true)))
+	admin.HandleFunc("POST /resume", s.require(config.RoleAdmin, s.handlePause(false)))
+	admin.HandleFunc("DELETE /skipped", s.require(config.RoleAdmin, s.handleClearSkipped))
+	admin.HandleFunc("DELETE /skipped/{host}", s.require(config.RoleAdmin, s.handleClearSkipped))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", allowOnly(s.allowMetrics, s.metrics.Handler()))
+	mux.Handle("/", allowOnly(s.allowed, admin))
+	return mux
+}
+
+const defaultAuditLimit = 100
+
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAuditLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", l))
+			return
+		}
+		limit = parsed
+	}
+	entries, err := s.stateManager.LoadAudit(r.Context(), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+type planResponse struct {
+	RunID      string                  `json:"runId,omitempty"`
+	Create     int                     `json:"create"`
+	Update     int                     `json:"update"`
+	Delete     int                     `json:"delete"`
+	Deferred   int                     `json:"deferred"` // operations left for the next run
+	Changes    []reconcile.Explanation `json:"changes"`
+	Moves      []reconcile.Move        `json:"moves"`
+	Violations []reconcile.Violation   `json:"violations"` // policy rules the plan breaks
+	Denied     []reconcile.Violation   `json:"denied"`     // operations the policy hook denied
+
+	OwnershipConflicts []reconcile.OwnershipConflict `json:"ownershipConflicts"` // hosts whose name another owner holds
+}
+
+func (s *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	plan := s.engine.LastPlan()
+	changes := plan.Explain
+	if changes == nil {
+		changes = []reconcile.Explanation{}
+	}
+	moves := plan.Moves
+	if moves == nil {
+		moves = []reconcile.Move{}
+	}
+	violations := plan.Violations
+	if violations == nil {
+		violations = []reconcile.Violation{}
+	}
+	denied := plan.Denied
+	if denied == nil {
+		denied = []reconcile.Violation{}
+	}
+	contested := plan.Contested
+	if contested == nil {
+		contested = []reconcile.OwnershipConflict{}
+	}
+	writeJSON(w, http.StatusOK, planResponse{
+		RunID:      plan.RunID,
+		Create:     len(plan.Create),
+		Update:     len(plan.Update),
+		Delete:     len(plan.Delete),
+		Deferred:   plan.Deferred,
+		Changes:    changes,
+		Moves:      moves,
+		Violations: violations,
+		Denied:     denied,
+
+		OwnershipConflicts: contested,
+	})
+}
+
+const defaultRecordsLimit = 1000
+
+type recordsResponse struct {
+	Total   int                         `json:"total"`
+	Offset  int                         `json:"offset"`
+	Records []reconcile.InventoryRecord `json:"records"`
+}
+
+// handleRecords lists the records this instance manages according to its
+// state, optionally filtered by zone and type, a page at a time
+func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := queryInt(query.Get("limit"), defaultRecordsLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", query.Get("limit")))
+		return
+	}
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset %q", query.Get("offset")))
+		return
+	}
+
+	inventory, err := s.engine.Inventory(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	zone, recordType := query.Get("zone"), strings.ToUpper(query.Get("type"))
+	matched := []reconcile.InventoryRecord{}
+	for _, record := range inventory {
+		if (zone == "" || record.Zone == zone) && (recordType == "" || record.Type == recordType) {
+			matched = append(matched, record)
+		}
+	}
+
+	page := matched[min(offset, len(matched)):]
+	page = page[:min(limit, len(page))]
+	writeJSON(w, http.StatusOK, recordsResponse{
+		Total:   len(matched),
+		Offset:  offset,
+		Records: page,
+	})
+}
+
+// queryInt parses a non negative query parameter, returning def if empty
+func queryInt(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return parsed, nil
+}
+
+func (s *Server) handleDrift(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Drift())
+}
+
+func (s *Server) handleConsistency(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Consistency())
+}
+
+func (s *Server) handleDivergence(w http.ResponseWriter, r *http.Request) {
+	if s.divergence == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("no secondary provider is configured"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.divergence.Divergence())
+}
+
+type stateResponse struct {
+	Domains  map[string]state.DomainState `json:"domains"`
+	Filtered []reconcile.FilteredHost     `json:"filtered"`
+	Skipped  []reconcile.SkippedHost      `json:"skipped"`
+	Stuck    []reconcile.StuckHost        `json:"stuck"` // seen in caddy, not applied at the provider
+	Summary  reconcile.Summary            `json:"summary"`
+	Paused   bool                         `json:"paused"`
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	st, err := s.stateManager.LoadState(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	skipped, err := s.engine.Skipped(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	paused, err := s.engine.Paused(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stateResponse{
+		Domains:  st.Domains,
+		Filtered: s.engine.Filtered(),
+		Skipped:  skipped,
+		Stuck:    s.engine.Stuck(st),
+		Summary:  s.engine.Summary(),
+		Paused:   paused,
+	})
+}
+
+type syncResponse struct {
+	RunID    string   `json:"runId"`
+	Created  int      `json:"created"`
+	Updated  int      `json:"updated"`
+	Deleted  int      `json:"deleted"`
+	Failures []string `json:"failures"`
+	Paused   bool     `json:"paused"`             // writes are paused, nothing was applied
+	Held     bool     `json:"held,omitempty"`     // dry run by the startup safeguard, nothing was applied
+	Deferred int64    `json:"deferred,omitempty"` // unix time the next write window opens, if outside one
+}
+
+// handleSync runs a sync of a single host or zone right away, named by the
+// host or zone query parameter
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	if s.syncer == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("syncing on demand is not available"))
+		return
+	}
+	scope := reconcile.Scope{Host: r.URL.Query().Get("host"), Zone: r.URL.Query().Get("zone")}
+	if scope.IsZero() {
+		writeError(w, http.StatusBadRequest, errors.New("host or zone is required"))
+		return
+	}
+
+	// The sync outlives a client that gives up waiting, so a plan is never
+	// cut off mid way
+	id := runid.New()
+	ctx := runid.WithID(context.WithoutCancel(r.Context()), id)
+	results, err := s.syncer.SyncScope(ctx, scope)
+	switch {
+	case errors.Is(err, reconcile.ErrInvalidScope):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, reconcile.ErrScopeNoMatch):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, reconcile.ErrPolicyViolation):
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := syncResponse{
+		RunID:    id,
+		Created:  len(results.Created),
+		Updated:  len(results.Updated),
+		Deleted:  len(results.Deleted),
+		Failures: []string{},
+		Paused:   results.Paused,
+		Held:     results.Held,
+	}
+	for _, f := range results.Failures {
+		resp.Failures = append(resp.Failures, fmt.Sprintf("%s %s %s: %s", f.Op, f.Record.Type, f.Record.Name, f.Error))
+	}
+	if !results.Deferred.IsZero() {
+		resp.Deferred = results.Deferred.Unix()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePause pauses or resumes dns writes, plans are still computed
+func (s *Server) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.engine.SetPaused(r.Context(), paused); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": paused})
+	}
+}
+
+// handleFailures lists the hosts failing their latest syncs with a suggested
+// remediation for each
+func (s *Server) handleFailures(w http.ResponseWriter, r *http.Request) {
+	failures, err := s.engine.Failures(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, failures)
+}
+
+// handleClearSkipped removes one host, or every host, from the skip-list
+func (s *Server) handleClearSkipped(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+	cleared, err := s.engine.ClearSkipped(r.Context(), host)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if host != "" && cleared == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("host %s is not skipped", host))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"cleared": cleared})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("fail encode api response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+)
+
+// inventoryEngine serves a fixed inventory, other engine methods are unused
+type inventoryEngine struct {
+	reconcile.Engine
+	records []reconcile.InventoryRecord
+}
+
+func (e inventoryEngine) Inventory(ctx context.Context) ([]reconcile.InventoryRecord, error) {
+	return e.records, nil
+}
+
+func TestHandleRecords(t *testing.T) {
+	s := &Server{engine: inventoryEngine{records: []reconcile.InventoryRecord{
+		{Host: "app.example.com", Zone: "example.com", Name: "app", Type: "A", Data: "10.0.0.1"},
+		{Host: "app.example.com", Zone: "example.com", Name: "app", Type: "TXT", Data: "heritage=caddy-dns-sync"},
+		{Host: "web.example.com", Zone: "example.com", Name: "web", Type: "A", Data: "10.0.0.2"},
+		{Host: "web.example.com", Zone: "example.com", Name: "web", Type: "TXT", Data: "heritage=caddy-dns-sync"},
+		{Host: "api.example.net", Zone: "example.net", Name: "api", Type: "CNAME", Data: "backend.internal"},
+		{Host: "api.example.net", Zone: "example.net", Name: "api", Type: "TXT", Data: "heritage=caddy-dns-sync"},
+	}}}
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		total  int
+		names  []string
+	}{
+		{"all", "", http.StatusOK, 6, []string{"app", "app", "web", "web", "api", "api"}},
+		{"zone", "?zone=example.net", http.StatusOK, 2, []string{"api", "api"}},
+		{"type", "?type=a", http.StatusOK, 2, []string{"app", "web"}},
+		{"page", "?zone=example.com&limit=2&offset=1", http.StatusOK, 4, []string{"app", "web"}},
+		{"past end", "?offset=10", http.StatusOK, 6, []string{}},
+		{"invalid limit", "?limit=-1", http.StatusBadRequest, 0, nil},
+		{"invalid offset", "?offset=x", http.StatusBadRequest, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.handleRecords(rec, httptest.NewRequest(http.MethodGet, "/records"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d but got %d", tt.status, rec.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp recordsResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.Total != tt.total {
+				t.Errorf("Expected total %d but got %d", tt.total, resp.Total)
+			}
+			names := []string{}
+			for _, r := range resp.Records {
+				names = append(names, r.Name)
+			}
+			if len(names) != len(tt.names) {
+				t.Fatalf("Expected records %v but got %v", tt.names, names)
+			}
+			for i := range names {
+				if names[i] != tt.names[i] {
+					t.Errorf("Expected records %v but got %v", tt.names, names)
+					break
+				}
+			}
+		})
+	}
+}
+
+// scopeSyncer records the scope it was asked to sync and returns fixed results
+type scopeSyncer struct {
+	scope   reconcile.Scope
+	results reconcile.Results
+	err     error
+}
+
+func (s *scopeSyncer) SyncScope(ctx context.Context, scope reconcile.Scope) (reconcile.Results, error) {
+	s.scope = scope
+	return s.results, s.err
+}
+
+func TestHandleSync(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		err    error
+		status int
+		scope  reconcile.Scope
+	}{
+		{"host", "?host=app.example.com", nil, http.StatusOK, reconcile.Scope{Host: "app.example.com"}},
+		{"zone", "?zone=example.com", nil, http.StatusOK, reconcile.Scope{Zone: "example.com"}},
+		{"no scope", "", nil, http.StatusBadRequest, reconcile.Scope{}},
+		{"invalid", "?zone=example.net", fmt.Errorf("%w: zone example.net is not configured", reconcile.ErrInvalidScope), http.StatusBadRequest, reconcile.Scope{Zone: "example.net"}},
+		{"no match", "?host=gone.example.com", reconcile.ErrScopeNoMatch, http.StatusNotFound, reconcile.Scope{Host: "gone.example.com"}},
+		{"policy", "?host=app.example.com", fmt.Errorf("%w: 1 violations", reconcile.ErrPolicyViolation), http.StatusUnprocessableEntity, reconcile.Scope{Host: "app.example.com"}},
+		{"failed", "?host=app.example.com", errors.New("caddy unreachable"), http.StatusInternalServerError, reconcile.Scope{Host: "app.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncer := &scopeSyncer{err: tt.err, results: reconcile.Results{
+				Updated: []provider.Record{{Name: "app", Type: "A"}},
+			}}
+			s := &Server{}
+			s.SetSyncer(syncer)
+			rec := httptest.NewRecorder()
+			s.handleSync(rec, httptest.NewRequest(http.MethodPost, "/sync"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d but got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+			if syncer.scope != tt.scope {
+				t.Errorf("Expected scope %+v but got %+v", tt.scope, syncer.scope)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp syncResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.Updated != 1 || resp.RunID == "" {
+				t.Errorf("Unexpected response %+v", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Package client talks to the admin api of a running caddy-dns-syncd
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/reconcile"
+)
+
+// DefaultAddr is where the daemon serves its api unless told otherwise
+const DefaultAddr = "http://localhost:8080"
+
+type Client struct {
+	addr      string
+	token     string // bearer token, sent if set
+	userAgent string
+	http      *http.Client
+}
+
+func New(addr, token, userAgent string) *Client {
+	return &Client{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     token,
+		userAgent: userAgent,
+		http:      &http.Client{},
+	}
+}
+
+// Error is a non 2xx api response
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("api returned %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("api returned %d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// Plan is the latest plan computed by the daemon
+type Plan struct {
+	RunID      string                  `json:"runId"`
+	Create     int                     `json:"create"`
+	Update     int                     `json:"update"`
+	Delete     int                     `json:"delete"`
+	Deferred   int                     `json:"deferred"`
+	Changes    []reconcile.Explanation `json:"changes"`
+	Moves      []reconcile.Move        `json:"moves"`
+	Violations []reconcile.Violation   `json:"violations"`
+	Denied     []reconcile.Violation   `json:"denied"`
+
+	OwnershipConflicts []reconcile.OwnershipConflict `json:"ownershipConflicts"`
+}
+
+// SyncResult is the outcome of a sync on demand
+type SyncResult struct {
+	RunID    string   `json:"runId"`
+	Created  int      `json:"created"`
+	Updated  int      `json:"updated"`
+	Deleted  int      `json:"deleted"`
+	Failures []string `json:"failures"`
+	Paused   bool     `json:"paused"`
+	Held     bool     `json:"held"`
+	Deferred int64    `json:"deferred"`
+}
+
+func (c *Client) Plan(ctx context.Context) (Plan, error) {
+	var plan Plan
+	err := c.do(ctx, http.MethodGet, "/plan", nil, &plan)
+	return plan, err
+}
+
+// Sync syncs a single host or every host of a zone right away
+func (c *Client) Sync(ctx context.Context, host, zone string) (SyncResult, error) {
+	query := url.Values{}
+	if host != "" {
+		query.Set("host", host)
+	}
+	if zone != "" {
+		query.Set("zone", zone)
+	}
+	var result SyncResult
+	err := c.do(ctx, http.MethodPost, "/sync", query, &result)
+	return result, err
+}
+
+// SetPaused pauses or resumes dns writes
+func (c *Client) SetPaused(ctx context.Context, paused bool) error {
+	path := "/resume"
+	if paused {
+		path = "/pause"
+	}
+	return c.do(ctx, http.MethodPost, path, nil, nil)
+}
+
+// ClearSkipped retries a skipped host on the next sync, or every skipped host
+// if host is empty
+func (c *Client) ClearSkipped(ctx context.Context, host string) error {
+	path := "/skipped"
+	if host != "" {
+		path += "/" + url.PathEscape(host)
+	}
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// State returns the raw /state document
+func (c *Client) State(ctx context.Context) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/state", nil, &raw)
+	return raw, err
+}
+
+// Audit returns the raw latest audit entries, limit 0 for the api default
+func (c *Client) Audit(ctx context.Context, limit int) (json.RawMessage, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var raw json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/audit", query, &raw)
+	return raw, err
+}
+
+// Drift returns the raw /drift report
+func (c *Client) Drift(ctx context.Context) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/drift", nil, &raw)
+	return raw, err
+}
+
+// Consistency returns the raw report of the latest consistency check
+func (c *Client) Consistency(ctx context.Context) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/consistency", nil, &raw)
+	return raw, err
+}
+
+// Failures returns the raw hosts failing their latest syncs
+func (c *Client) Failures(ctx context.Context) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/failures", nil, &raw)
+	return raw, err
+}
+
+// Records returns the raw managed records, filtered by zone and type if set
+func (c *Client) Records(ctx context.Context, zone, recordType string) (json.RawMessage, error) {
+	query := url.Values{}
+	if zone != "" {
+		query.Set("zone", zone)
+	}
+	if recordType != "" {
+		query.Set("type", recordType)
+	}
+	var raw json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/records", query, &raw)
+	return raw, err
+}
+
+// do sends a request and decodes the response into out, if not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, out any) error {
+	target := c.addr + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &body) != nil {
+			body.Error = strings.TrimSpace(string(data))
+		}
+		return &Error{Status: resp.StatusCode, Message: body.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	var gotAuth, gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/sync" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		gotAuth = r.Header.Get("Authorization")
+		gotQuery = r.URL.RawQuery
+		w.Write([]byte(`{"runId":"abc","created":2,"failures":["create A app: boom"]}`))
+	}))
+	defer srv.Close()
+
+	result, err := New(srv.URL+"/", "secret", "").Sync(context.Background(), "app.example.com", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Expected bearer token, got %q", gotAuth)
+	}
+	if gotQuery != "host=app.example.com" {
+		t.Errorf("Expected host query, got %q", gotQuery)
+	}
+	if result.RunID != "abc" || result.Created != 2 || len(result.Failures) != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{name: "json error", body: `{"error":"host or zone is required"}`, message: "host or zone is required"},
+		{name: "plain text", body: "forbidden\n", message: "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			err := New(srv.URL, "", "").SetPaused(context.Background(), true)
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected api error, got %v", err)
+			}
+			if apiErr.Status != http.StatusBadRequest || apiErr.Message != tt.message {
+				t.Errorf("Unexpected error %+v", apiErr)
+			}
+		})
+	}
+}
+
+func TestClearSkipped(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.URL.Path
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "", "")
+	if err := c.ClearSkipped(context.Background(), "app.example.com"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != "DELETE /skipped/app.example.com" {
+		t.Errorf("Unexpected request %q", got)
+	}
+	if err := c.ClearSkipped(context.Background(), ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != "DELETE /skipped" {
+		t.Errorf("Unexpected request %q", got)
+	}
+}
@@ -1,43 +1,422 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io/fs"
 	"log/slog"
+	"net/netip"
+	"net/url"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/evanofslack/caddy-dns-sync/internal/schedule"
+	"github.com/evanofslack/caddy-dns-sync/internal/version"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	defaultSyncInterval = time.Minute
-	defaultStatePath    = "caddydnssync.db"
-	defaultOwner        = "default"
-	defaultLogLevel     = "info"
-	defaultLogEnv       = "prod"
+	defaultSyncInterval     = time.Minute
+	defaultDrain            = 30 * time.Second
+	defaultAdaptiveMax      = 10 // times syncInterval
+	defaultAdaptiveIdleRuns = 3
+	defaultStatePath        = "caddydnssync.db"
+	defaultSource           = "caddy"
+	defaultOwner            = "default"
+	defaultWorkers          = 4
+	defaultSnapshotKeep     = 7
+	defaultHostListRefresh  = 5 * time.Minute
+	defaultWatchdogTimeout  = 10 * time.Minute
+	defaultSecondaryCheck   = 15 * time.Minute
+	defaultRetentionCheck   = time.Hour
+	defaultRemoteRegion     = "us-east-1"
+	defaultPublicIPv4URL    = "https://api.ipify.org"
+	defaultPublicIPv6URL    = "https://api6.ipify.org"
+	defaultPublicIPTimeout  = 10 * time.Second
+	defaultSyncHookTimeout  = 30 * time.Second
+	defaultLogLevel         = "info"
+	defaultLogEnv           = "prod"
 )
 
+// Plan execution orders, deletes first respects provider uniqueness
+// constraints at the cost of briefly missing records
+const (
+	OrderCreatesFirst = "creates-first"
+	OrderDeletesFirst = "deletes-first"
+)
+
+// Policies for a host whose name already has a record not owned by us
+const (
+	UnmanagedSkip     = "skip"     // leave the record alone and do not sync the host
+	UnmanagedFail     = "fail"     // report the host as a failed conflict every sync
+	UnmanagedTakeover = "takeover" // adopt the record, replacing it if the data differs
+)
+
+// Ownership modes, where the heritage of managed records is stored. TXT
+// records work with every provider, comments need provider support.
+const (
+	OwnershipTXT     = "txt"
+	OwnershipComment = "comment"
+)
+
+// DNS providers, cloudflare if none is set
+const (
+	ProviderCloudflare = "cloudflare"
+	ProviderPorkbun    = "porkbun"
+	ProviderNameSilo   = "namesilo"
+	ProviderVultr      = "vultr"
+	ProviderScaleway   = "scaleway"
+	ProviderDesec      = "desec"
+	ProviderRFC2136    = "rfc2136"
+	ProviderHTTP       = "http"
+)
+
+// Zone visibilities, public zones never receive private addresses
+const (
+	VisibilityPublic   = "public"
+	VisibilityInternal = "internal"
+)
+
+// API token roles, admin tokens can also read
+const (
+	RoleRead  = "read"
+	RoleAdmin = "admin"
+)
+
+// What happens to syncs missed while a sync ran past the interval
+const (
+	OverrunQueue  = "queue"  // run one sync right after the late one
+	OverrunSkip   = "skip"   // drop missed syncs, the next runs an interval after the late one
+	OverrunExtend = "extend" // wait as long as the late sync took, until syncs fit the interval again
+)
+
+// OwnerAuto derives the owner from the hostname and a persisted instance id
+const OwnerAuto = "auto"
+
 type Config struct {
-	SyncInterval time.Duration `yaml:"syncInterval"`
-	StatePath    string        `yaml:"statePath"`
-	Log          Log           `yaml:"log"`
-	Caddy        Caddy         `yaml:"caddy"`
-	DNS          DNS           `yaml:"dns"`
-	Reconcile    Reconcile     `yaml:"reconcile"`
+	SyncInterval  time.Duration `yaml:"syncInterval"`
+	SyncOverrun   string        `yaml:"syncOverrun"` // queue, skip or extend when a sync takes longer than the interval
+	Adaptive      Adaptive      `yaml:"adaptiveInterval"`
+	ShutdownDrain time.Duration `yaml:"shutdownDrain"` // time an in-flight sync gets to finish on shutdown before it is cancelled
+	StatePath     string        `yaml:"statePath"`
+	UserAgentTag  string        `yaml:"userAgentTag"` // appended to the user agent of provider and caddy requests
+	Snapshot      Snapshot      `yaml:"snapshot"`
+	Retention     Retention     `yaml:"retention"`
+	PublicIP      PublicIP      `yaml:"publicIP"`
+	HostList      HostList      `yaml:"hostList"`
+	Watchdog      Watchdog      `yaml:"watchdog"`
+	Chaos         Chaos         `yaml:"chaos"`
+	API           API           `yaml:"api"`
+	Log           Log           `yaml:"log"`
+	Caddy         Caddy         `yaml:"caddy"`
+	DNS           DNS           `yaml:"dns"`
+	Reconcile     Reconcile     `yaml:"reconcile"`
 }
 
 type Caddy struct {
-	AdminURL string `yaml:"adminUrl"`
+	AdminURL        string        `yaml:"adminUrl"`
+	UserAgent       string        `yaml:"-"`               // derived from the version and userAgentTag
+	PublishHandlers []string      `yaml:"publishHandlers"` // non proxy handlers to publish, e.g. static_response
+	Target          string        `yaml:"target"`          // upstream used for published non proxy handlers
+	WatchInterval   time.Duration `yaml:"watchInterval"`   // poll caddy config for changes and sync immediately, disabled if zero
+	ServersOnly     bool          `yaml:"serversOnly"`     // fetch only apps/http/servers instead of the full config
+	Source          string        `yaml:"source"`          // name hosts of this caddy are attributed to in state and audit, defaults to caddy
+	UpstreamHealth  bool          `yaml:"upstreamHealth"`  // publish a record for every healthy upstream of proxied hosts instead of the first
+	TLS             CaddyTLS      `yaml:"tls"`             // client certificate and ca of an admin api behind mutual tls
+}
+
+// CaddyTLS reaches the admin api over mutual tls. The files are reloaded when
+// they change, so rotated certificates need no restart.
+type CaddyTLS struct {
+	Cert       string `yaml:"cert"`       // pem client certificate file
+	Key        string `yaml:"key"`        // pem private key file of cert
+	CA         string `yaml:"ca"`         // pem ca file verifying the admin api, the system roots if empty
+	ServerName string `yaml:"serverName"` // expected in the admin api certificate, the url host if empty
+}
+
+// Enabled reports whether the admin api is reached with custom tls settings
+func (t CaddyTLS) Enabled() bool {
+	return t.Cert != "" || t.CA != ""
 }
 
 type DNS struct {
-	Provider string   `yaml:"provider"`
-	Zones    []string `yaml:"zones"`
-	Token    string   `yaml:"token"`
-	TTL      int      `yaml:"ttl"`
+	Provider          string   `yaml:"provider"`
+	Zones             []string `yaml:"zones"`
+	AutoDiscoverZones bool     `yaml:"autoDiscoverZones"` // use all zones visible to the provider when zones is empty
+	Token             string   `yaml:"token"`
+	Secret            string   `yaml:"secret"` // second credential of providers using a key pair, the porkbun secret api key
+	TTL               int      `yaml:"ttl"`
+	UserAgent         string   `yaml:"-"`           // derived from the version and userAgentTag
+	Debug             bool     `yaml:"debug"`       // log every provider request
+	DebugBodies       bool     `yaml:"debugBodies"` // also log request and response bodies, with secrets redacted
+	Ownership         string   `yaml:"ownership"`   // txt or comment, where record ownership is stored
+
+	ZoneSettings map[string]ZoneSettings `yaml:"zoneSettings"` // keyed by zone
+
+	HTTP    HTTPProvider `yaml:"http"`    // requests of the http provider
+	RFC2136 RFC2136      `yaml:"rfc2136"` // server of the rfc2136 provider
+
+	Delegations map[string]Delegation `yaml:"delegations"` // subzones hosted at another provider, keyed by subzone
+	Secondary   Secondary             `yaml:"secondary"`   // provider managed records are also written to while migrating
+}
+
+// Delegation is a subzone hosted at another provider than its parent zone,
+// e.g. a homelab subzone served by a dns server at home
+type Delegation struct {
+	Provider string       `yaml:"provider"`
+	Token    string       `yaml:"token"`
+	Secret   string       `yaml:"secret"`
+	HTTP     HTTPProvider `yaml:"http"`    // requests of the http provider
+	RFC2136  RFC2136      `yaml:"rfc2136"` // server of the rfc2136 provider
+}
+
+// Secondary is a second provider every write is mirrored to, so zones can be
+// migrated between providers without downtime
+type Secondary struct {
+	Delegation    `yaml:",inline"`
+	CheckInterval time.Duration `yaml:"checkInterval"` // how often managed records are compared with the primary
+	Backfill      bool          `yaml:"backfill"`      // copy the records the secondary lacks when comparing
+}
+
+// Enabled reports whether writes are mirrored to a secondary provider
+func (s Secondary) Enabled() bool {
+	return s.Provider != ""
+}
+
+// Delegated returns the dns config of the provider hosting subzone
+func (d DNS) Delegated(subzone string) DNS {
+	return d.with(d.Delegations[subzone], []string{subzone})
+}
+
+// SecondaryDNS returns the dns config of the secondary provider, holding the
+// same zones as the primary
+func (d DNS) SecondaryDNS() DNS {
+	return d.with(d.Secondary.Delegation, d.Zones)
+}
+
+// with returns the config of another provider for zones
+func (d DNS) with(p Delegation, zones []string) DNS {
+	other := d
+	other.Provider = p.Provider
+	other.Token = p.Token
+	other.Secret = p.Secret
+	other.HTTP = p.HTTP
+	other.RFC2136 = p.RFC2136
+	other.Zones = zones
+	other.AutoDiscoverZones = false
+	other.Delegations = nil
+	other.Secondary = Secondary{}
+	return other
+}
+
+// HTTPProvider describes a dns api in requests built from templates and
+// responses read with JSONPath, so small apis need no go code
+type HTTPProvider struct {
+	Headers map[string]string `yaml:"headers"` // sent with every request, values are templates
+	List    HTTPRequest       `yaml:"list"`
+	Create  HTTPRequest       `yaml:"create"`
+	Update  HTTPRequest       `yaml:"update"` // optional, updates delete and create the record without it
+	Delete  HTTPRequest       `yaml:"delete"`
+	Records string            `yaml:"records"` // JSONPath of the record list in the list response
+	Fields  HTTPFields        `yaml:"fields"`  // JSONPaths of record fields, relative to a listed record
+}
+
+// HTTPRequest is a request of the http provider, url and body are templates
+type HTTPRequest struct {
+	Method string `yaml:"method"` // GET for list, POST for create, PUT for update and DELETE for delete if empty
+	URL    string `yaml:"url"`
+	Body   string `yaml:"body"`
+	ID     string `yaml:"id"` // JSONPath of the created record id in the create response
+}
+
+// HTTPFields are the JSONPaths of record fields within a listed record
+type HTTPFields struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	Data string `yaml:"data"`
+	TTL  string `yaml:"ttl"`
+}
+
+// RFC2136 is an authoritative server, e.g. bind, zones are read from by AXFR
+// and written to with dynamic updates. Requests are signed with the TSIG key
+// named here, its base64 secret is dns.secret.
+type RFC2136 struct {
+	Server    string        `yaml:"server"`    // host and port of the primary, port 53 if omitted
+	KeyName   string        `yaml:"keyName"`   // requests are unsigned if empty
+	Algorithm string        `yaml:"algorithm"` // hmac-sha256 if empty, or hmac-sha1, hmac-sha224, hmac-sha384, hmac-sha512
+	Timeout   time.Duration `yaml:"timeout"`   // of a single transfer or update, 30s if zero
+}
+
+// ZoneSettings apply to every record of a zone, host attributes override them
+type ZoneSettings struct {
+	Visibility string        `yaml:"visibility"` // public refuses private addresses and defaults to proxied, internal defaults to a short ttl
+	Proxied    *bool         `yaml:"proxied"`
+	TTL        time.Duration `yaml:"ttl"`
+	Target     string        `yaml:"target"`    // record data, overrides the caddy upstream
+	Protected  []string      `yaml:"protected"` // record name globs never touched, defaults to www, mail, mx and _acme-challenge*
+	Canonical  string        `yaml:"canonical"` // name holding target, hosts resolving to target point at it by CNAME
+
+	SyncInterval time.Duration `yaml:"syncInterval"` // sync the zone on its own schedule, apart from the other zones
+}
+
+// ZoneIntervals returns the zones synced on their own schedule with their
+// intervals, by lowercased zone without a trailing dot, nil if every zone is
+// synced together
+func (d DNS) ZoneIntervals() map[string]time.Duration {
+	var intervals map[string]time.Duration
+	for zone, settings := range d.ZoneSettings {
+		if settings.SyncInterval <= 0 {
+			continue
+		}
+		if intervals == nil {
+			intervals = make(map[string]time.Duration)
+		}
+		intervals[normalizeZone(zone)] = settings.SyncInterval
+	}
+	return intervals
+}
+
+// normalizeZone lowercases zone and trims its trailing dot
+func normalizeZone(zone string) string {
+	return strings.ToLower(strings.TrimSuffix(zone, "."))
+}
+
+// Adaptive lengthens the sync interval while syncs find nothing to change and
+// shortens it once they do, for deployments whose hosts rarely change
+type Adaptive struct {
+	Enabled  bool          `yaml:"enabled"`
+	Min      time.Duration `yaml:"min"`      // interval after a sync with changes, defaults to syncInterval
+	Max      time.Duration `yaml:"max"`      // longest interval, defaults to ten times syncInterval
+	IdleRuns int           `yaml:"idleRuns"` // syncs in a row without changes before the interval doubles
+}
+
+// validateProvider checks the provider name and, for the http provider, its
+// requests. field prefixes errors.
+func validateProvider(field, name string, h HTTPProvider) error {
+	switch name {
+	case "", ProviderCloudflare, ProviderPorkbun, ProviderNameSilo, ProviderVultr, ProviderScaleway, ProviderDesec, ProviderRFC2136:
+	case ProviderHTTP:
+		if h.List.URL == "" || h.Create.URL == "" || h.Delete.URL == "" {
+			return fmt.Errorf("%s.http needs list, create and delete urls", field)
+		}
+		if h.Fields.Name == "" || h.Fields.Type == "" || h.Fields.Data == "" {
+			return fmt.Errorf("%s.http.fields needs name, type and data paths", field)
+		}
+	default:
+		return fmt.Errorf("%s.provider %q is invalid, use %s, %s, %s, %s, %s, %s, %s or %s", field, name, ProviderCloudflare, ProviderPorkbun, ProviderNameSilo, ProviderVultr, ProviderScaleway, ProviderDesec, ProviderRFC2136, ProviderHTTP)
+	}
+	return nil
+}
+
+// MaxInterval returns the longest time between scheduled syncs
+func (c *Config) MaxInterval() time.Duration {
+	if c.Adaptive.Enabled {
+		return max(c.SyncInterval, c.Adaptive.Max)
+	}
+	return c.SyncInterval
+}
+
+// Snapshot periodically backs up the state store
+type Snapshot struct {
+	Interval time.Duration `yaml:"interval"` // disabled if zero
+	Keep     int           `yaml:"keep"`     // newest snapshots kept
+	Dir      string        `yaml:"dir"`      // defaults to statePath with a .snapshots suffix
+	Remote   RemoteStore   `yaml:"remote"`   // also upload snapshots, and restore from them on boot without local state
+}
+
+// RemoteStore is a bucket of an s3 compatible object store, e.g. aws s3, gcs
+// with hmac keys or backblaze b2
+type RemoteStore struct {
+	Endpoint  string `yaml:"endpoint"`  // e.g. https://s3.us-east-1.amazonaws.com
+	Region    string `yaml:"region"`    // signing region, defaults to us-east-1
+	Bucket    string `yaml:"bucket"`    // disabled if empty
+	Prefix    string `yaml:"prefix"`    // prepended to the keys of uploaded snapshots
+	AccessKey string `yaml:"accessKey"` // e.g. an aws access key id, gcs hmac key or b2 application key id
+	SecretKey string `yaml:"secretKey"`
+	Keep      int    `yaml:"keep"` // newest uploaded snapshots kept, defaults to snapshot.keep
+}
+
+// Enabled reports whether snapshots are uploaded to a remote store
+func (r RemoteStore) Enabled() bool {
+	return r.Bucket != ""
+}
+
+// Retention bounds what the state store keeps of entries that otherwise grow
+// for as long as the app runs, every limit is disabled if zero
+type Retention struct {
+	Audit        time.Duration `yaml:"audit"`        // delete audit entries older than this
+	AuditEntries int           `yaml:"auditEntries"` // keep at most this many audit entries, the newest
+	StaleHosts   time.Duration `yaml:"staleHosts"`   // forget hosts kept in state though not seen in caddy for this long
+	Interval     time.Duration `yaml:"interval"`     // how often the store is pruned and its size measured
+}
+
+// PublicIP publishes the detected public address of this machine for every
+// host instead of its caddy upstream, for caddy behind a dynamic address
+type PublicIP struct {
+	Enabled   bool          `yaml:"enabled"`
+	DualStack bool          `yaml:"dualStack"` // publish the IPv4 as A and the IPv6 as AAAA record of every host
+	IPv4URL   string        `yaml:"ipv4Url"`   // answers the IPv4 it is reached from in plain text
+	IPv6URL   string        `yaml:"ipv6Url"`   // answers the IPv6 it is reached from in plain text
+	Timeout   time.Duration `yaml:"timeout"`   // of a single detection request
+}
+
+// HostList limits the hosts published to those allowed by a list kept
+// outside this config, in a file or at an http url
+type HostList struct {
+	Source  string        `yaml:"source"`  // file path or http(s) url, disabled if empty
+	Refresh time.Duration `yaml:"refresh"` // how often the list is loaded again
+	Token   string        `yaml:"token"`   // bearer token sent to an http source
+}
+
+// Watchdog restarts the process when the sync loop hangs and reports its
+// liveness to systemd, or through a health file where systemd is not used
+type Watchdog struct {
+	Timeout    time.Duration `yaml:"timeout"`    // exit when no sync made progress for the sync interval plus this long, disabled if negative
+	HealthFile string        `yaml:"healthFile"` // touched while the sync loop is healthy, for rc scripts and other supervisors
+}
+
+// Chaos injects faults into provider requests to rehearse failures, never
+// enable it where the records matter
+type Chaos struct {
+	FailRate  float64       `yaml:"failRate"`  // share of provider requests failing, from 0 to 1
+	DelayRate float64       `yaml:"delayRate"` // share of provider requests delayed, from 0 to 1
+	MaxDelay  time.Duration `yaml:"maxDelay"`  // longest injected delay
+	Ops       []string      `yaml:"ops"`       // read, create, update or delete requests affected, every one if empty
+	Errors    []string      `yaml:"errors"`    // error classes injected, transient if empty
+}
+
+// Enabled reports whether any fault is injected
+func (c Chaos) Enabled() bool {
+	return c.FailRate > 0 || c.DelayRate > 0
+}
+
+// Error classes chaos injects
+const (
+	ChaosTransient   = "transient"
+	ChaosRateLimited = "rate_limited"
+	ChaosConflict    = "conflict"
+	ChaosPermission  = "permission"
+)
+
+// API protects the admin endpoints, /metrics is always served for scraping
+type API struct {
+	Tokens              []APIToken `yaml:"tokens"`              // bearer tokens, every endpoint is open if empty
+	AllowedCIDRs        []string   `yaml:"allowedCIDRs"`        // clients allowed to reach admin endpoints, any if empty
+	MetricsAllowedCIDRs []string   `yaml:"metricsAllowedCIDRs"` // clients allowed to reach /metrics, any if empty
+	OpenMetrics         bool       `yaml:"openMetrics"`         // serve /metrics as openmetrics, with run id exemplars
+}
+
+type APIToken struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"` // read or admin
 }
 
 type Log struct {
@@ -46,9 +425,59 @@ type Log struct {
 }
 
 type Reconcile struct {
-	DryRun           bool     `yaml:"dryRun"`
-	ProtectedRecords []string `yaml:"protectedRecords"`
-	Owner            string   `yaml:"owner"`
+	DryRun            bool                      `yaml:"dryRun"`
+	DryRunOps         []string                  `yaml:"dryRunOps"`      // create, update or delete operations only dry run, the others are written
+	StartupDelay      time.Duration             `yaml:"startupDelay"`   // dry run every plan until this long after start
+	InitialDryRuns    int                       `yaml:"initialDryRuns"` // dry run the first this many plans after start
+	Shadow            bool                      `yaml:"shadow"`         // never write, report drift against the live zones every sync
+	ProtectedRecords  []string                  `yaml:"protectedRecords"`
+	HeritageSource    bool                      `yaml:"heritageSource"`    // also name the source of a host in its heritage record or comment
+	Owner             string                    `yaml:"owner"`             // "auto" derives a stable per instance owner
+	RollbackOnFailure bool                      `yaml:"rollbackOnFailure"` // revert the whole run if any operation fails
+	HostAttributes    map[string]HostAttributes `yaml:"hostAttributes"`    // keyed by host glob, e.g. *.example.com
+	SkipAfterFailures int                       `yaml:"skipAfterFailures"` // stop retrying a host after this many consecutive failures, disabled if zero
+	Workers           int                       `yaml:"workers"`           // hosts applied in parallel
+	ExecutionOrder    string                    `yaml:"executionOrder"`    // creates-first or deletes-first
+	UnmanagedPolicy   string                    `yaml:"unmanagedPolicy"`   // skip, fail or takeover names with records we do not own
+	ExternalDNSOwners []string                  `yaml:"externalDNSOwners"` // external-dns owner ids whose records are adopted
+	AcceptOwners      []string                  `yaml:"acceptOwners"`      // further owners whose records are treated as ours, records are always written as owner
+	CheckBeforeCreate bool                      `yaml:"checkBeforeCreate"` // look a record up right before creating it to avoid duplicates
+	VerifyWrites      bool                      `yaml:"verifyWrites"`      // read created records back until the provider lists them
+	FastSync          bool                      `yaml:"fastSync"`          // plan from the records kept in state instead of listing zones
+	WriteWindows      []string                  `yaml:"writeWindows"`      // only write during these windows, e.g. "Mon-Fri 09:00-17:00"
+	WriteTimezone     string                    `yaml:"writeTimezone"`     // timezone of the write windows, local time if empty
+	ExpireAfter       time.Duration             `yaml:"expireAfter"`       // delete records of hosts not seen in caddy for this long, disabled if zero
+	MaxOpsPerRun      int                       `yaml:"maxOpsPerRun"`      // provider writes per sync, the rest roll to the next sync, unlimited if zero
+	TombstoneTTL      time.Duration             `yaml:"tombstoneTTL"`      // delete tombstones older than this, kept forever if zero
+	ChurnCooldown     time.Duration             `yaml:"churnCooldown"`     // keep the records of a host changed less than this long ago, disabled if zero
+	PolicyFile        string                    `yaml:"policyFile"`        // rules every plan must satisfy before it is executed
+	PolicyHook        []string                  `yaml:"policyHook"`        // command and arguments run for every planned operation, allowing, denying or changing it
+	PreSync           SyncHook                  `yaml:"preSync"`           // run before a plan is executed, failing defers the plan to the next sync
+	PostSync          SyncHook                  `yaml:"postSync"`          // run after a plan was executed without failures
+	VerifyEvery       int                       `yaml:"verifyEvery"`       // cross check caddy, state and the live zones after every this many syncs, disabled if zero
+	// Leave a tombstone in the heritage TXT record of removed hosts instead of deleting it
+	KeepOwnership bool `yaml:"keepOwnershipOnDelete"`
+}
+
+// SyncHook is a command or http url told about the plan of a sync, as json
+// on the stdin of the command or in the body of a POST to the url
+type SyncHook struct {
+	Command []string      `yaml:"command"`
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Enabled reports whether the hook has a command or url to run
+func (h SyncHook) Enabled() bool {
+	return len(h.Command) > 0 || h.URL != ""
+}
+
+// HostAttributes tune the records created for matching hosts, unset fields keep defaults
+type HostAttributes struct {
+	Proxied *bool         `yaml:"proxied"`
+	TTL     time.Duration `yaml:"ttl"`
+	Type    string        `yaml:"type"`   // record type, derived from the target if empty
+	Target  string        `yaml:"target"` // record data, overrides the caddy upstream
 }
 
 func Load(path string) (*Config, error) {
@@ -79,14 +508,54 @@ func Load(path string) (*Config, error) {
 		cfg.SyncInterval = defaultSyncInterval
 	}
 
+	if cfg.SyncOverrun == "" {
+		cfg.SyncOverrun = OverrunQueue
+	}
+
+	if cfg.Caddy.Source == "" {
+		cfg.Caddy.Source = defaultSource
+	}
+
+	if cfg.ShutdownDrain == 0 {
+		cfg.ShutdownDrain = defaultDrain
+	}
+
 	if cfg.StatePath == "" {
 		cfg.StatePath = defaultStatePath
 	}
 
+	if cfg.HostList.Refresh <= 0 {
+		cfg.HostList.Refresh = defaultHostListRefresh
+	}
+
+	if cfg.Watchdog.Timeout == 0 {
+		cfg.Watchdog.Timeout = defaultWatchdogTimeout
+	}
+
+	if cfg.Snapshot.Keep <= 0 {
+		cfg.Snapshot.Keep = defaultSnapshotKeep
+	}
+
 	if cfg.Reconcile.Owner == "" {
 		cfg.Reconcile.Owner = defaultOwner
 	}
 
+	if cfg.Reconcile.Workers <= 0 {
+		cfg.Reconcile.Workers = defaultWorkers
+	}
+
+	if cfg.Reconcile.ExecutionOrder == "" {
+		cfg.Reconcile.ExecutionOrder = OrderCreatesFirst
+	}
+
+	if cfg.DNS.Ownership == "" {
+		cfg.DNS.Ownership = OwnershipTXT
+	}
+
+	if cfg.Reconcile.UnmanagedPolicy == "" {
+		cfg.Reconcile.UnmanagedPolicy = UnmanagedSkip
+	}
+
 	// Set log defaults
 	if cfg.Log.Level == "" {
 		cfg.Log.Level = "info"
@@ -99,46 +568,239 @@ func Load(path string) (*Config, error) {
 	if token := os.Getenv("CADDY_DNS_SYNC_CLOUDFLARE_TOKEN"); token != "" {
 		cfg.DNS.Token = token
 	}
-	if syncInterval := os.Getenv("CADDY_DNS_SYNC_INTERVAL"); syncInterval != "" {
-		if interval, err := time.ParseDuration(syncInterval); err != nil {
-			cfg.SyncInterval = interval
-		} else {
-			slog.Default().Warn("fail parse sync interval to duration from string", "interval", interval, "error", err)
-		}
+	if token := os.Getenv("CADDY_DNS_SYNC_DNS_TOKEN"); token != "" {
+		cfg.DNS.Token = token
+	}
+	if secret := os.Getenv("CADDY_DNS_SYNC_DNS_SECRET"); secret != "" {
+		cfg.DNS.Secret = secret
+	}
+	if server := os.Getenv("CADDY_DNS_SYNC_RFC2136_SERVER"); server != "" {
+		cfg.DNS.RFC2136.Server = server
+	}
+	if keyName := os.Getenv("CADDY_DNS_SYNC_RFC2136_KEY_NAME"); keyName != "" {
+		cfg.DNS.RFC2136.KeyName = keyName
+	}
+	if algorithm := os.Getenv("CADDY_DNS_SYNC_RFC2136_ALGORITHM"); algorithm != "" {
+		cfg.DNS.RFC2136.Algorithm = algorithm
+	}
+	envDuration("CADDY_DNS_SYNC_INTERVAL", &cfg.SyncInterval)
+	if overrun := os.Getenv("CADDY_DNS_SYNC_OVERRUN"); overrun != "" {
+		cfg.SyncOverrun = overrun
+	}
+	envBool("CADDY_DNS_SYNC_ADAPTIVE_INTERVAL", &cfg.Adaptive.Enabled)
+	envDuration("CADDY_DNS_SYNC_ADAPTIVE_MIN", &cfg.Adaptive.Min)
+	envDuration("CADDY_DNS_SYNC_ADAPTIVE_MAX", &cfg.Adaptive.Max)
+	if cfg.Adaptive.Min <= 0 {
+		cfg.Adaptive.Min = cfg.SyncInterval
+	}
+	if cfg.Adaptive.Max <= 0 {
+		cfg.Adaptive.Max = defaultAdaptiveMax * cfg.SyncInterval
 	}
+	if cfg.Adaptive.IdleRuns <= 0 {
+		cfg.Adaptive.IdleRuns = defaultAdaptiveIdleRuns
+	}
+	envDuration("CADDY_DNS_SYNC_SHUTDOWN_DRAIN", &cfg.ShutdownDrain)
 	if statePath := os.Getenv("CADDY_DNS_SYNC_STATE_PATH"); statePath != "" {
 		cfg.StatePath = statePath
 	}
+	envDuration("CADDY_DNS_SYNC_SNAPSHOT_INTERVAL", &cfg.Snapshot.Interval)
+	envInt("CADDY_DNS_SYNC_SNAPSHOT_KEEP", &cfg.Snapshot.Keep)
+	if dir := os.Getenv("CADDY_DNS_SYNC_SNAPSHOT_DIR"); dir != "" {
+		cfg.Snapshot.Dir = dir
+	}
+	if cfg.Snapshot.Dir == "" {
+		cfg.Snapshot.Dir = cfg.StatePath + ".snapshots"
+	}
+	if endpoint := os.Getenv("CADDY_DNS_SYNC_SNAPSHOT_REMOTE_ENDPOINT"); endpoint != "" {
+		cfg.Snapshot.Remote.Endpoint = endpoint
+	}
+	if bucket := os.Getenv("CADDY_DNS_SYNC_SNAPSHOT_REMOTE_BUCKET"); bucket != "" {
+		cfg.Snapshot.Remote.Bucket = bucket
+	}
+	if key := os.Getenv("CADDY_DNS_SYNC_SNAPSHOT_REMOTE_ACCESS_KEY"); key != "" {
+		cfg.Snapshot.Remote.AccessKey = key
+	}
+	if secret := os.Getenv("CADDY_DNS_SYNC_SNAPSHOT_REMOTE_SECRET_KEY"); secret != "" {
+		cfg.Snapshot.Remote.SecretKey = secret
+	}
+	if cfg.Snapshot.Remote.Region == "" {
+		cfg.Snapshot.Remote.Region = defaultRemoteRegion
+	}
+	if cfg.Snapshot.Remote.Keep <= 0 {
+		cfg.Snapshot.Remote.Keep = cfg.Snapshot.Keep
+	}
+	envDuration("CADDY_DNS_SYNC_RETENTION_AUDIT", &cfg.Retention.Audit)
+	envInt("CADDY_DNS_SYNC_RETENTION_AUDIT_ENTRIES", &cfg.Retention.AuditEntries)
+	envDuration("CADDY_DNS_SYNC_RETENTION_STALE_HOSTS", &cfg.Retention.StaleHosts)
+	envDuration("CADDY_DNS_SYNC_RETENTION_INTERVAL", &cfg.Retention.Interval)
+	if cfg.Retention.Interval <= 0 {
+		cfg.Retention.Interval = defaultRetentionCheck
+	}
+	envBool("CADDY_DNS_SYNC_PUBLIC_IP", &cfg.PublicIP.Enabled)
+	envBool("CADDY_DNS_SYNC_PUBLIC_IP_DUAL_STACK", &cfg.PublicIP.DualStack)
+	if url := os.Getenv("CADDY_DNS_SYNC_PUBLIC_IPV4_URL"); url != "" {
+		cfg.PublicIP.IPv4URL = url
+	}
+	if url := os.Getenv("CADDY_DNS_SYNC_PUBLIC_IPV6_URL"); url != "" {
+		cfg.PublicIP.IPv6URL = url
+	}
+	if cfg.PublicIP.IPv4URL == "" {
+		cfg.PublicIP.IPv4URL = defaultPublicIPv4URL
+	}
+	if cfg.PublicIP.IPv6URL == "" {
+		cfg.PublicIP.IPv6URL = defaultPublicIPv6URL
+	}
+	if cfg.PublicIP.Timeout <= 0 {
+		cfg.PublicIP.Timeout = defaultPublicIPTimeout
+	}
+	if source := os.Getenv("CADDY_DNS_SYNC_HOST_LIST"); source != "" {
+		cfg.HostList.Source = source
+	}
+	envDuration("CADDY_DNS_SYNC_HOST_LIST_REFRESH", &cfg.HostList.Refresh)
+	if token := os.Getenv("CADDY_DNS_SYNC_HOST_LIST_TOKEN"); token != "" {
+		cfg.HostList.Token = token
+	}
+	envDuration("CADDY_DNS_SYNC_WATCHDOG_TIMEOUT", &cfg.Watchdog.Timeout)
+	envFloat("CADDY_DNS_SYNC_CHAOS_FAIL_RATE", &cfg.Chaos.FailRate)
+	envFloat("CADDY_DNS_SYNC_CHAOS_DELAY_RATE", &cfg.Chaos.DelayRate)
+	envDuration("CADDY_DNS_SYNC_CHAOS_MAX_DELAY", &cfg.Chaos.MaxDelay)
+	if file := os.Getenv("CADDY_DNS_SYNC_HEALTH_FILE"); file != "" {
+		cfg.Watchdog.HealthFile = file
+	}
 	if caddyUrl := os.Getenv("CADDY_DNS_SYNC_CADDY_URL"); caddyUrl != "" {
 		cfg.Caddy.AdminURL = caddyUrl
 	}
+	if publishHandlers := os.Getenv("CADDY_DNS_SYNC_PUBLISH_HANDLERS"); publishHandlers != "" {
+		cfg.Caddy.PublishHandlers = strings.Split(publishHandlers, ",")
+	}
+	if target := os.Getenv("CADDY_DNS_SYNC_TARGET"); target != "" {
+		cfg.Caddy.Target = target
+	}
+	envDuration("CADDY_DNS_SYNC_CADDY_WATCH_INTERVAL", &cfg.Caddy.WatchInterval)
+	envBool("CADDY_DNS_SYNC_CADDY_SERVERS_ONLY", &cfg.Caddy.ServersOnly)
+	envBool("CADDY_DNS_SYNC_CADDY_UPSTREAM_HEALTH", &cfg.Caddy.UpstreamHealth)
+	if cert := os.Getenv("CADDY_DNS_SYNC_CADDY_TLS_CERT"); cert != "" {
+		cfg.Caddy.TLS.Cert = cert
+	}
+	if key := os.Getenv("CADDY_DNS_SYNC_CADDY_TLS_KEY"); key != "" {
+		cfg.Caddy.TLS.Key = key
+	}
+	if ca := os.Getenv("CADDY_DNS_SYNC_CADDY_TLS_CA"); ca != "" {
+		cfg.Caddy.TLS.CA = ca
+	}
+	if name := os.Getenv("CADDY_DNS_SYNC_CADDY_SOURCE"); name != "" {
+		cfg.Caddy.Source = name
+	}
 	if dnsProvider := os.Getenv("CADDY_DNS_SYNC_PROVIDER"); dnsProvider != "" {
 		cfg.DNS.Provider = dnsProvider
 	}
+	if secondary := os.Getenv("CADDY_DNS_SYNC_SECONDARY_PROVIDER"); secondary != "" {
+		cfg.DNS.Secondary.Provider = secondary
+	}
+	if token := os.Getenv("CADDY_DNS_SYNC_SECONDARY_TOKEN"); token != "" {
+		cfg.DNS.Secondary.Token = token
+	}
+	if secret := os.Getenv("CADDY_DNS_SYNC_SECONDARY_SECRET"); secret != "" {
+		cfg.DNS.Secondary.Secret = secret
+	}
+	envDuration("CADDY_DNS_SYNC_SECONDARY_CHECK_INTERVAL", &cfg.DNS.Secondary.CheckInterval)
+	envBool("CADDY_DNS_SYNC_SECONDARY_BACKFILL", &cfg.DNS.Secondary.Backfill)
+	if cfg.DNS.Secondary.CheckInterval == 0 {
+		cfg.DNS.Secondary.CheckInterval = defaultSecondaryCheck
+	}
 	if dnsZones := os.Getenv("CADDY_DNS_SYNC_ZONES"); dnsZones != "" {
 		zones := strings.Split(dnsZones, ",")
 		cfg.DNS.Zones = zones
 	}
-	if dnsTtl := os.Getenv("CADDY_DNS_SYNC_TTL"); dnsTtl != "" {
-		if ttl, err := strconv.Atoi(dnsTtl); err != nil {
-			cfg.DNS.TTL = ttl
-		} else {
-			slog.Default().Warn("fail parse ttl to int from string", "ttl", dnsTtl, "error", err)
+	envBool("CADDY_DNS_SYNC_AUTO_DISCOVER_ZONES", &cfg.DNS.AutoDiscoverZones)
+	// Delegated subzones are synced along with the listed zones
+	if len(cfg.DNS.Zones) > 0 {
+		subzones := []string{}
+		for subzone := range cfg.DNS.Delegations {
+			if !slices.Contains(cfg.DNS.Zones, subzone) {
+				subzones = append(subzones, subzone)
+			}
 		}
+		slices.Sort(subzones)
+		cfg.DNS.Zones = append(cfg.DNS.Zones, subzones...)
 	}
-	if dryRun := os.Getenv("CADDY_DNS_SYNC_DRYRUN"); dryRun != "" {
-		switch strings.ToLower(dryRun) {
-		case "true":
-			cfg.Reconcile.DryRun = true
-		case "false":
-			cfg.Reconcile.DryRun = false
-		default:
-			slog.Default().Warn("fail parse dryrun to bool from string", "dryrun", dryRun)
+	if zones := os.Getenv("CADDY_DNS_SYNC_PUBLIC_ZONES"); zones != "" {
+		for _, zone := range strings.Split(zones, ",") {
+			if cfg.DNS.ZoneSettings == nil {
+				cfg.DNS.ZoneSettings = make(map[string]ZoneSettings)
+			}
+			settings := cfg.DNS.ZoneSettings[zone]
+			settings.Visibility = VisibilityPublic
+			cfg.DNS.ZoneSettings[zone] = settings
 		}
 	}
+	envInt("CADDY_DNS_SYNC_TTL", &cfg.DNS.TTL)
+	envBool("CADDY_DNS_SYNC_DNS_DEBUG", &cfg.DNS.Debug)
+	envBool("CADDY_DNS_SYNC_DNS_DEBUG_BODIES", &cfg.DNS.DebugBodies)
+	if ownership := os.Getenv("CADDY_DNS_SYNC_OWNERSHIP"); ownership != "" {
+		cfg.DNS.Ownership = ownership
+	}
+	envBool("CADDY_DNS_SYNC_DRYRUN", &cfg.Reconcile.DryRun)
+	if ops := os.Getenv("CADDY_DNS_SYNC_DRYRUN_OPS"); ops != "" {
+		cfg.Reconcile.DryRunOps = strings.Split(ops, ",")
+	}
+	envDuration("CADDY_DNS_SYNC_STARTUP_DELAY", &cfg.Reconcile.StartupDelay)
+	envInt("CADDY_DNS_SYNC_INITIAL_DRY_RUNS", &cfg.Reconcile.InitialDryRuns)
+	envBool("CADDY_DNS_SYNC_SHADOW", &cfg.Reconcile.Shadow)
+	envBool("CADDY_DNS_SYNC_HERITAGE_SOURCE", &cfg.Reconcile.HeritageSource)
+	envBool("CADDY_DNS_SYNC_ROLLBACK_ON_FAILURE", &cfg.Reconcile.RollbackOnFailure)
+	envInt("CADDY_DNS_SYNC_SKIP_AFTER_FAILURES", &cfg.Reconcile.SkipAfterFailures)
+	envInt("CADDY_DNS_SYNC_WORKERS", &cfg.Reconcile.Workers)
+	envInt("CADDY_DNS_SYNC_MAX_OPS_PER_RUN", &cfg.Reconcile.MaxOpsPerRun)
+	envInt("CADDY_DNS_SYNC_VERIFY_EVERY", &cfg.Reconcile.VerifyEvery)
+	envBool("CADDY_DNS_SYNC_KEEP_OWNERSHIP_ON_DELETE", &cfg.Reconcile.KeepOwnership)
+	envDuration("CADDY_DNS_SYNC_TOMBSTONE_TTL", &cfg.Reconcile.TombstoneTTL)
+	envDuration("CADDY_DNS_SYNC_CHURN_COOLDOWN", &cfg.Reconcile.ChurnCooldown)
+	envBool("CADDY_DNS_SYNC_CHECK_BEFORE_CREATE", &cfg.Reconcile.CheckBeforeCreate)
+	envBool("CADDY_DNS_SYNC_VERIFY_WRITES", &cfg.Reconcile.VerifyWrites)
+	envBool("CADDY_DNS_SYNC_FAST_SYNC", &cfg.Reconcile.FastSync)
+	envDuration("CADDY_DNS_SYNC_EXPIRE_AFTER", &cfg.Reconcile.ExpireAfter)
+	if file := os.Getenv("CADDY_DNS_SYNC_POLICY_FILE"); file != "" {
+		cfg.Reconcile.PolicyFile = file
+	}
+	if hook := os.Getenv("CADDY_DNS_SYNC_POLICY_HOOK"); hook != "" {
+		cfg.Reconcile.PolicyHook = strings.Fields(hook)
+	}
+	for _, hook := range []struct {
+		env  string
+		hook *SyncHook
+	}{{"PRE_SYNC", &cfg.Reconcile.PreSync}, {"POST_SYNC", &cfg.Reconcile.PostSync}} {
+		if command := os.Getenv("CADDY_DNS_SYNC_" + hook.env + "_COMMAND"); command != "" {
+			hook.hook.Command = strings.Fields(command)
+		}
+		if url := os.Getenv("CADDY_DNS_SYNC_" + hook.env + "_URL"); url != "" {
+			hook.hook.URL = url
+		}
+		if hook.hook.Timeout <= 0 {
+			hook.hook.Timeout = defaultSyncHookTimeout
+		}
+	}
+	if order := os.Getenv("CADDY_DNS_SYNC_EXECUTION_ORDER"); order != "" {
+		cfg.Reconcile.ExecutionOrder = order
+	}
+	if policy := os.Getenv("CADDY_DNS_SYNC_UNMANAGED_POLICY"); policy != "" {
+		cfg.Reconcile.UnmanagedPolicy = policy
+	}
+	if windows := os.Getenv("CADDY_DNS_SYNC_WRITE_WINDOWS"); windows != "" {
+		cfg.Reconcile.WriteWindows = strings.Split(windows, ";")
+	}
+	if tz := os.Getenv("CADDY_DNS_SYNC_WRITE_TIMEZONE"); tz != "" {
+		cfg.Reconcile.WriteTimezone = tz
+	}
 	if owner := os.Getenv("CADDY_DNS_SYNC_OWNER"); owner != "" {
 		cfg.Reconcile.Owner = owner
 	}
+	if owners := os.Getenv("CADDY_DNS_SYNC_ACCEPT_OWNERS"); owners != "" {
+		cfg.Reconcile.AcceptOwners = strings.Split(owners, ",")
+	}
+	if owners := os.Getenv("CADDY_DNS_SYNC_EXTERNAL_DNS_OWNERS"); owners != "" {
+		cfg.Reconcile.ExternalDNSOwners = strings.Split(owners, ",")
+	}
 	if protectedRecords := os.Getenv("CADDY_DNS_SYNC_PROTECTED_RECORDS"); protectedRecords != "" {
 		records := strings.Split(protectedRecords, ",")
 		cfg.Reconcile.ProtectedRecords = records
@@ -149,5 +811,339 @@ func Load(path string) (*Config, error) {
 	if logenv := os.Getenv("CADDY_DNS_SYNC_LOG_ENV"); logenv != "" {
 		cfg.Log.Env = logenv
 	}
+	if token := os.Getenv("CADDY_DNS_SYNC_API_ADMIN_TOKEN"); token != "" {
+		cfg.API.Tokens = append(cfg.API.Tokens, APIToken{Name: "env-admin", Token: token, Role: RoleAdmin})
+	}
+	if token := os.Getenv("CADDY_DNS_SYNC_API_READ_TOKEN"); token != "" {
+		cfg.API.Tokens = append(cfg.API.Tokens, APIToken{Name: "env-read", Token: token, Role: RoleRead})
+	}
+	if cidrs := os.Getenv("CADDY_DNS_SYNC_API_ALLOWED_CIDRS"); cidrs != "" {
+		cfg.API.AllowedCIDRs = strings.Split(cidrs, ",")
+	}
+	if cidrs := os.Getenv("CADDY_DNS_SYNC_METRICS_ALLOWED_CIDRS"); cidrs != "" {
+		cfg.API.MetricsAllowedCIDRs = strings.Split(cidrs, ",")
+	}
+	envBool("CADDY_DNS_SYNC_OPEN_METRICS", &cfg.API.OpenMetrics)
+	if tag := os.Getenv("CADDY_DNS_SYNC_USER_AGENT_TAG"); tag != "" {
+		cfg.UserAgentTag = tag
+	}
+	cfg.Caddy.UserAgent = version.UserAgent(cfg.UserAgentTag)
+	cfg.DNS.UserAgent = cfg.Caddy.UserAgent
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+// Fingerprint returns a short hash of the effective config, secrets excluded,
+// to tell whether instances run the same config
+func (c *Config) Fingerprint() string {
+	redacted := *c
+	redacted.DNS.Token = ""
+	redacted.DNS.Secret = ""
+	redacted.API.Tokens = nil
+	redacted.Snapshot.Remote.SecretKey = ""
+	data, err := yaml.Marshal(redacted)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseCIDRs parses networks such as 10.0.0.0/8, a bare address is a single host
+func ParseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if addr, err := netip.ParseAddr(cidr); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// envBool overrides dst from a true/false environment variable if set
+func envBool(name string, dst *bool) {
+	val := os.Getenv(name)
+	if val == "" {
+		return
+	}
+	switch strings.ToLower(val) {
+	case "true":
+		*dst = true
+	case "false":
+		*dst = false
+	default:
+		slog.Default().Warn("fail parse bool from string", "env", name, "value", val)
+	}
+}
+
+// envInt overrides dst from an integer environment variable if set
+func envInt(name string, dst *int) {
+	val := os.Getenv(name)
+	if val == "" {
+		return
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		slog.Default().Warn("fail parse int from string", "env", name, "value", val, "error", err)
+		return
+	}
+	*dst = i
+}
+
+// envFloat overrides dst from a decimal environment variable if set
+func envFloat(name string, dst *float64) {
+	val := os.Getenv(name)
+	if val == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		slog.Default().Warn("fail parse float from string", "env", name, "value", val, "error", err)
+		return
+	}
+	*dst = f
+}
+
+// envDuration overrides dst from a duration environment variable if set
+func envDuration(name string, dst *time.Duration) {
+	val := os.Getenv(name)
+	if val == "" {
+		return
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		slog.Default().Warn("fail parse duration from string", "env", name, "value", val, "error", err)
+		return
+	}
+	*dst = d
+}
+
+// Owners returns the owner records are written as, followed by the further
+// owners whose records are also treated as owned
+func (r Reconcile) Owners() []string {
+	return append([]string{r.Owner}, r.AcceptOwners...)
+}
+
+// ValidateOwner rejects owners that can not be carried in heritage TXT data.
+// Commas and equals signs separate heritage fields, they are allowed but
+// escaped when written.
+func ValidateOwner(owner string) error {
+	for _, r := range owner {
+		if r <= ' ' || r > '~' || r == '"' || r == '\\' {
+			return fmt.Errorf("owner %q contains %q, use printable ascii without spaces, quotes or backslashes", owner, r)
+		}
+	}
+	if strings.ContainsAny(owner, ",=") {
+		slog.Default().Warn("owner contains ',' or '=', escaped in heritage records", "owner", owner)
+	}
+	return nil
+}
+
+// Validate checks for configuration that would leave the service unable to sync
+func (c *Config) Validate() error {
+	if len(c.DNS.Zones) == 0 && !c.DNS.AutoDiscoverZones {
+		return errors.New("dns.zones is empty, configure at least one zone or enable dns.autoDiscoverZones")
+	}
+	switch c.SyncOverrun {
+	case "", OverrunQueue, OverrunSkip, OverrunExtend:
+	default:
+		return fmt.Errorf("syncOverrun %q is invalid, use %s, %s or %s", c.SyncOverrun, OverrunQueue, OverrunSkip, OverrunExtend)
+	}
+	if c.Adaptive.Enabled && (c.Adaptive.Min > c.SyncInterval || c.Adaptive.Max < c.SyncInterval) {
+		return fmt.Errorf("adaptiveInterval min %s and max %s must bracket syncInterval %s", c.Adaptive.Min, c.Adaptive.Max, c.SyncInterval)
+	}
+	switch c.Reconcile.ExecutionOrder {
+	case "", OrderCreatesFirst, OrderDeletesFirst:
+	default:
+		return fmt.Errorf("reconcile.executionOrder %q is invalid, use %s or %s", c.Reconcile.ExecutionOrder, OrderCreatesFirst, OrderDeletesFirst)
+	}
+	switch c.Reconcile.UnmanagedPolicy {
+	case "", UnmanagedSkip, UnmanagedFail, UnmanagedTakeover:
+	default:
+		return fmt.Errorf("reconcile.unmanagedPolicy %q is invalid, use %s, %s or %s", c.Reconcile.UnmanagedPolicy, UnmanagedSkip, UnmanagedFail, UnmanagedTakeover)
+	}
+	if err := validateProvider("dns", c.DNS.Provider, c.DNS.HTTP); err != nil {
+		return err
+	}
+	if c.DNS.Secondary.Enabled() {
+		if err := validateProvider("dns.secondary", c.DNS.Secondary.Provider, c.DNS.Secondary.HTTP); err != nil {
+			return err
+		}
+		if len(c.DNS.Delegations) > 0 {
+			return errors.New("dns.secondary cannot be combined with dns.delegations")
+		}
+	}
+	for subzone, delegation := range c.DNS.Delegations {
+		if err := validateProvider(fmt.Sprintf("dns.delegations %q", subzone), delegation.Provider, delegation.HTTP); err != nil {
+			return err
+		}
+		if !strings.Contains(strings.TrimSuffix(subzone, "."), ".") {
+			return fmt.Errorf("dns.delegations %q is not a subzone", subzone)
+		}
+	}
+	switch c.DNS.Ownership {
+	case "", OwnershipTXT, OwnershipComment:
+	default:
+		return fmt.Errorf("dns.ownership %q is invalid, use %s or %s", c.DNS.Ownership, OwnershipTXT, OwnershipComment)
+	}
+	scheduled := make(map[string]string) // zones with a syncInterval to their key
+	for zone, settings := range c.DNS.ZoneSettings {
+		switch settings.Visibility {
+		case "", VisibilityPublic, VisibilityInternal:
+		default:
+			return fmt.Errorf("dns.zoneSettings %q has invalid visibility %q, use %s or %s", zone, settings.Visibility, VisibilityPublic, VisibilityInternal)
+		}
+		for _, pattern := range settings.Protected {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("dns.zoneSettings %q protected pattern %q is invalid: %w", zone, pattern, err)
+			}
+		}
+		if settings.Canonical != "" {
+			canonical := strings.ToLower(strings.TrimSuffix(settings.Canonical, "."))
+			if settings.Target == "" {
+				return fmt.Errorf("dns.zoneSettings %q canonical needs a target to hold", zone)
+			}
+			if !strings.HasSuffix(canonical, "."+strings.ToLower(strings.TrimSuffix(zone, "."))) {
+				return fmt.Errorf("dns.zoneSettings %q canonical %q must be a name below the zone", zone, settings.Canonical)
+			}
+		}
+		if settings.SyncInterval < 0 {
+			return fmt.Errorf("dns.zoneSettings %q syncInterval must not be negative", zone)
+		}
+		if settings.SyncInterval > 0 {
+			// A zone loop for a zone not synced would fail every tick
+			normalized := normalizeZone(zone)
+			if !c.DNS.AutoDiscoverZones && !slices.ContainsFunc(c.DNS.Zones, func(z string) bool { return normalizeZone(z) == normalized }) {
+				return fmt.Errorf("dns.zoneSettings %q has a syncInterval but is not in dns.zones", zone)
+			}
+			if other, dup := scheduled[normalized]; dup {
+				return fmt.Errorf("dns.zoneSettings %q and %q are the same zone, give it one syncInterval", other, zone)
+			}
+			scheduled[normalized] = zone
+		}
+	}
+	for i, t := range c.API.Tokens {
+		if t.Token == "" {
+			return fmt.Errorf("api.tokens[%d] %q has an empty token", i, t.Name)
+		}
+		switch t.Role {
+		case RoleRead, RoleAdmin:
+		default:
+			return fmt.Errorf("api.tokens[%d] %q has invalid role %q, use %s or %s", i, t.Name, t.Role, RoleRead, RoleAdmin)
+		}
+	}
+	if _, err := ParseCIDRs(c.API.AllowedCIDRs); err != nil {
+		return fmt.Errorf("api.allowedCIDRs: %w", err)
+	}
+	if _, err := ParseCIDRs(c.API.MetricsAllowedCIDRs); err != nil {
+		return fmt.Errorf("api.metricsAllowedCIDRs: %w", err)
+	}
+	if len(c.Reconcile.PolicyHook) > 0 && c.Reconcile.PolicyHook[0] == "" {
+		return fmt.Errorf("reconcile.policyHook must start with a command")
+	}
+	for _, hook := range []struct {
+		name string
+		hook SyncHook
+	}{{"preSync", c.Reconcile.PreSync}, {"postSync", c.Reconcile.PostSync}} {
+		if len(hook.hook.Command) > 0 && hook.hook.URL != "" {
+			return fmt.Errorf("reconcile.%s sets both command and url, use one", hook.name)
+		}
+		if len(hook.hook.Command) > 0 && hook.hook.Command[0] == "" {
+			return fmt.Errorf("reconcile.%s.command must start with a command", hook.name)
+		}
+		if u, err := url.Parse(hook.hook.URL); hook.hook.URL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+			return fmt.Errorf("reconcile.%s.url %q must be an http or https url", hook.name, hook.hook.URL)
+		}
+	}
+	for _, op := range c.Reconcile.DryRunOps {
+		switch op {
+		case "create", "update", "delete":
+		default:
+			return fmt.Errorf("reconcile.dryRunOps %q is invalid, use create, update or delete", op)
+		}
+	}
+	if c.Reconcile.StartupDelay < 0 || c.Reconcile.InitialDryRuns < 0 {
+		return fmt.Errorf("reconcile.startupDelay and reconcile.initialDryRuns must not be negative")
+	}
+	if r := c.Snapshot.Remote; r.Enabled() {
+		if r.Endpoint == "" || r.AccessKey == "" || r.SecretKey == "" {
+			return errors.New("snapshot.remote needs endpoint, accessKey and secretKey with bucket")
+		}
+		if c.Snapshot.Interval <= 0 {
+			return errors.New("snapshot.remote uploads snapshots, set snapshot.interval")
+		}
+	}
+	if c.Retention.Audit < 0 || c.Retention.AuditEntries < 0 || c.Retention.StaleHosts < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+	if c.PublicIP.DualStack && !c.PublicIP.Enabled {
+		return errors.New("publicIP.dualStack requires publicIP.enabled")
+	}
+	if c.Chaos.FailRate < 0 || c.Chaos.FailRate > 1 {
+		return fmt.Errorf("chaos.failRate %v is invalid, use a share from 0 to 1", c.Chaos.FailRate)
+	}
+	if c.Chaos.DelayRate < 0 || c.Chaos.DelayRate > 1 {
+		return fmt.Errorf("chaos.delayRate %v is invalid, use a share from 0 to 1", c.Chaos.DelayRate)
+	}
+	if c.Chaos.DelayRate > 0 && c.Chaos.MaxDelay <= 0 {
+		return fmt.Errorf("chaos.maxDelay must be set with chaos.delayRate")
+	}
+	for _, op := range c.Chaos.Ops {
+		switch op {
+		case "read", "create", "update", "delete":
+		default:
+			return fmt.Errorf("chaos.ops %q is invalid, use read, create, update or delete", op)
+		}
+	}
+	for _, class := range c.Chaos.Errors {
+		switch class {
+		case ChaosTransient, ChaosRateLimited, ChaosConflict, ChaosPermission:
+		default:
+			return fmt.Errorf("chaos.errors %q is invalid, use %s, %s, %s or %s", class, ChaosTransient, ChaosRateLimited, ChaosConflict, ChaosPermission)
+		}
+	}
+	if _, err := schedule.ParseAll(c.Reconcile.WriteWindows); err != nil {
+		return fmt.Errorf("reconcile.writeWindows: %w", err)
+	}
+	if _, err := time.LoadLocation(c.Reconcile.WriteTimezone); err != nil {
+		return fmt.Errorf("reconcile.writeTimezone %q is invalid: %w", c.Reconcile.WriteTimezone, err)
+	}
+	if (c.Caddy.TLS.Cert == "") != (c.Caddy.TLS.Key == "") {
+		return fmt.Errorf("caddy.tls.cert and caddy.tls.key must be set together")
+	}
+	if err := ValidateOwner(c.Caddy.Source); err != nil {
+		return fmt.Errorf("caddy.source: %w", err)
+	}
+	if err := ValidateOwner(c.Reconcile.Owner); err != nil {
+		return fmt.Errorf("reconcile.owner: %w", err)
+	}
+	for _, owner := range c.Reconcile.AcceptOwners {
+		if owner == "" || owner == OwnerAuto {
+			return fmt.Errorf("reconcile.acceptOwners: owner %q is invalid, list owners as written in heritage records", owner)
+		}
+		if err := ValidateOwner(owner); err != nil {
+			return fmt.Errorf("reconcile.acceptOwners: %w", err)
+		}
+	}
+	for pattern, attrs := range c.Reconcile.HostAttributes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("reconcile.hostAttributes pattern %q is invalid: %w", pattern, err)
+		}
+		switch attrs.Type {
+		case "", "A", "AAAA", "CNAME":
+		default:
+			return fmt.Errorf("reconcile.hostAttributes %q has unsupported type %q", pattern, attrs.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestZoneSettingsSyncInterval(t *testing.T) {
+	c := &Config{DNS: DNS{
+		Zones:        []string{"example.com"},
+		ZoneSettings: map[string]ZoneSettings{"Example.com.": {SyncInterval: time.Minute}},
+	}}
+	intervals := c.DNS.ZoneIntervals()
+	if len(intervals) != 1 || intervals["example.com"] != time.Minute {
+		t.Errorf("Expected the interval by normalized zone, got %v", intervals)
+	}
+
+	// A zone loop for a zone not synced is a typo
+	c.DNS.ZoneSettings["typo.com"] = ZoneSettings{SyncInterval: time.Minute}
+	if err := c.Validate(); err == nil {
+		t.Error("Expected an error for a syncInterval of a zone not in dns.zones")
+	}
+}
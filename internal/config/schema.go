@@ -0,0 +1,113 @@
+package config
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SchemaID identifies the schema of the config file
+const SchemaID = "https://github.com/evanofslack/caddy-dns-sync/config.schema.json"
+
+// schemaEnums lists the values accepted by fields Validate restricts to a set,
+// keyed by yaml path. Map values are addressed as *, list items as [].
+var schemaEnums = map[string][]string{
+	"syncOverrun":                     {OverrunQueue, OverrunSkip, OverrunExtend},
+	"dns.provider":                    {ProviderCloudflare, ProviderPorkbun, ProviderNameSilo, ProviderVultr, ProviderScaleway, ProviderDesec, ProviderRFC2136, ProviderHTTP},
+	"dns.ownership":                   {OwnershipTXT, OwnershipComment},
+	"dns.delegations.*.provider":      {ProviderCloudflare, ProviderPorkbun, ProviderNameSilo, ProviderVultr, ProviderScaleway, ProviderDesec, ProviderRFC2136, ProviderHTTP},
+	"dns.secondary.provider":          {ProviderCloudflare, ProviderPorkbun, ProviderNameSilo, ProviderVultr, ProviderScaleway, ProviderDesec, ProviderRFC2136, ProviderHTTP},
+	"dns.zoneSettings.*.visibility":   {VisibilityPublic, VisibilityInternal},
+	"api.tokens[].role":               {RoleRead, RoleAdmin},
+	"chaos.ops[]":                     {"read", "create", "update", "delete"},
+	"chaos.errors[]":                  {ChaosTransient, ChaosRateLimited, ChaosConflict, ChaosPermission},
+	"reconcile.executionOrder":        {OrderCreatesFirst, OrderDeletesFirst},
+	"reconcile.dryRunOps[]":           {"create", "update", "delete"},
+	"reconcile.unmanagedPolicy":       {UnmanagedSkip, UnmanagedFail, UnmanagedTakeover},
+	"reconcile.hostAttributes.*.type": {"A", "AAAA", "CNAME"},
+	"log.level":                       {"debug", "info", "warn", "error"},
+	"log.env":                         {"dev", "development", "prod"},
+}
+
+// durationPattern matches the durations time.ParseDuration accepts, e.g. 1m30s
+const durationPattern = `^-?([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^0$`
+
+// Schema returns a JSON Schema of the config file, derived from the yaml tags
+// of Config so it cannot drift from the fields Load reads. Unknown keys are
+// reported by editors as they are most likely typos, Load ignores them.
+func Schema() ([]byte, error) {
+	schema := schemaOf(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = SchemaID
+	schema["title"] = "caddy-dns-sync config"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func schemaOf(t reflect.Type, path string) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]any{
+			"type":        []string{"string", "integer"},
+			"pattern":     durationPattern,
+			"description": "a duration such as 30s, 5m or 1h30m",
+		}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			// Inlined structs add their fields to this object
+			if opts == "inline" {
+				maps.Copy(properties, schemaOf(field.Type, path)["properties"].(map[string]any))
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			properties[name] = schemaOf(field.Type, join(path, name))
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem(), join(path, "*")),
+		}
+	case reflect.Slice:
+		return map[string]any{
+			"type":  "array",
+			"items": schemaOf(t.Elem(), path+"[]"),
+		}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	schema := map[string]any{"type": "string"}
+	if values, ok := schemaEnums[path]; ok {
+		schema["enum"] = values
+	}
+	return schema
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
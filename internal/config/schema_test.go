@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaEnums(t *testing.T) {
+	// Every enum must name a field, or a renamed field silently loses its enum
+	seen := make(map[string]bool)
+	var walk func(t reflect.Type, path string)
+	walk = func(t reflect.Type, path string) {
+		seen[path] = true
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			for i := range t.NumField() {
+				name, opts, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+				if opts == "inline" {
+					walk(t.Field(i).Type, path)
+				} else if name != "" && name != "-" {
+					walk(t.Field(i).Type, join(path, name))
+				}
+			}
+		case reflect.Map:
+			walk(t.Elem(), join(path, "*"))
+		case reflect.Slice:
+			walk(t.Elem(), path+"[]")
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	for path := range schemaEnums {
+		if !seen[path] {
+			t.Errorf("Expected enum path %q to name a config field", path)
+		}
+	}
+
+	data, err := Schema()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Properties map[string]struct {
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := schema.Properties["dns"].Properties["ownership"].Enum; !reflect.DeepEqual(got, []string{OwnershipTXT, OwnershipComment}) {
+		t.Errorf("Expected dns.ownership enum, got %v", got)
+	}
+}
@@ -0,0 +1,137 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// Terraform renders records as cloudflare_record resources, each with an
+// import block so terraform adopts the existing record instead of creating it.
+// zoneIDs maps zone names to cloudflare zone ids.
+func Terraform(records []provider.Record, zoneIDs map[string]string) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("# Generated by caddy-dns-sync export-terraform\n")
+	for _, r := range sortedRecords(records) {
+		zoneID, ok := zoneIDs[r.Zone]
+		if !ok {
+			return nil, fmt.Errorf("no zone id for zone %s", r.Zone)
+		}
+		if r.ID == "" {
+			return nil, fmt.Errorf("record %s %s has no id", r.Type, r.Name)
+		}
+		address := "cloudflare_record." + r.resource
+
+		fmt.Fprintf(&b, "\nimport {\n  to = %s\n  id = %s\n}\n", address, hclString(zoneID+"/"+r.ID))
+		fmt.Fprintf(&b, "\nresource \"cloudflare_record\" %q {\n", r.resource)
+		fmt.Fprintf(&b, "  zone_id = %s\n", hclString(zoneID))
+		fmt.Fprintf(&b, "  name    = %s\n", hclString(r.Name))
+		fmt.Fprintf(&b, "  type    = %s\n", hclString(r.Type))
+		fmt.Fprintf(&b, "  content = %s\n", hclString(r.Data))
+		fmt.Fprintf(&b, "  ttl     = %d\n", ttlSeconds(r.TTL))
+		if r.Type != "TXT" {
+			fmt.Fprintf(&b, "  proxied = %t\n", r.Proxied)
+		}
+		b.WriteString("}\n")
+	}
+	return b.Bytes(), nil
+}
+
+// TerraformImports renders a shell script of terraform import commands, for
+// terraform versions without import blocks
+func TerraformImports(records []provider.Record, zoneIDs map[string]string) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("#!/bin/sh\n# Generated by caddy-dns-sync export-terraform\nset -e\n\n")
+	for _, r := range sortedRecords(records) {
+		zoneID, ok := zoneIDs[r.Zone]
+		if !ok {
+			return nil, fmt.Errorf("no zone id for zone %s", r.Zone)
+		}
+		fmt.Fprintf(&b, "terraform import cloudflare_record.%s %s/%s\n", r.resource, zoneID, r.ID)
+	}
+	return b.Bytes(), nil
+}
+
+type namedRecord struct {
+	provider.Record
+	resource string
+}
+
+// sortedRecords orders records by zone, name and type, naming each resource
+// uniquely after its host and type
+func sortedRecords(records []provider.Record) []namedRecord {
+	sorted := make([]provider.Record, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Zone != b.Zone {
+			return a.Zone < b.Zone
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+
+	named := make([]namedRecord, 0, len(sorted))
+	seen := make(map[string]int)
+	for _, r := range sorted {
+		name := resourceName(r)
+		seen[name]++
+		if n := seen[name]; n > 1 {
+			name = fmt.Sprintf("%s_%d", name, n)
+		}
+		named = append(named, namedRecord{Record: r, resource: name})
+	}
+	return named
+}
+
+// resourceName builds a terraform identifier from the record host and type
+func resourceName(r provider.Record) string {
+	host := r.Name
+	if host == "@" || host == r.Zone {
+		host = r.Zone
+	} else if !strings.HasSuffix(host, "."+r.Zone) {
+		host = host + "." + r.Zone
+	}
+
+	var b strings.Builder
+	for _, c := range strings.ToLower(host + "_" + r.Type) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	name := b.String()
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "record_" + name
+	}
+	return name
+}
+
+// hclString quotes s as an HCL string, escaping template sequences
+func hclString(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+		"${", "$${",
+		"%{", "%%{",
+	)
+	return `"` + r.Replace(s) + `"`
+}
+
+// ttlSeconds converts a record ttl, 1 is automatic in cloudflare
+func ttlSeconds(ttl time.Duration) int {
+	if s := int(ttl / time.Second); s > 0 {
+		return s
+	}
+	return 1
+}
@@ -0,0 +1,72 @@
+package export
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+func TestTerraform(t *testing.T) {
+	records := []provider.Record{
+		{ID: "r2", Name: "app.example.com", Type: "TXT", Data: `"heritage=caddy-dns-sync,caddy-dns-sync/owner=a"`, Zone: "example.com", TTL: 300 * time.Second},
+		{ID: "r1", Name: "app.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com", TTL: 300 * time.Second, Proxied: true},
+		{ID: "r3", Name: "example.com", Type: "CNAME", Data: "${host}", Zone: "example.com"},
+	}
+	out, err := Terraform(records, map[string]string{"example.com": "zone1"})
+	if err != nil {
+		t.Fatalf("Terraform failed: %v", err)
+	}
+	hcl := string(out)
+
+	for _, want := range []string{
+		"to = cloudflare_record.app_example_com_a\n  id = \"zone1/r1\"",
+		`resource "cloudflare_record" "app_example_com_txt"`,
+		`content = "\"heritage=caddy-dns-sync,caddy-dns-sync/owner=a\""`,
+		"proxied = true",
+		`content = "$${host}"`,
+		"ttl     = 1",
+	} {
+		if !strings.Contains(hcl, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, hcl)
+		}
+	}
+	if a, txt := strings.Index(hcl, "app_example_com_a"), strings.Index(hcl, "app_example_com_txt"); a > txt {
+		t.Error("Expected records sorted by type within a host")
+	}
+	if strings.Count(hcl, "proxied") != 2 {
+		t.Errorf("Expected proxied only on address records, got:\n%s", hcl)
+	}
+}
+
+func TestTerraformErrors(t *testing.T) {
+	records := []provider.Record{{ID: "r1", Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"}}
+	if _, err := Terraform(records, map[string]string{}); err == nil {
+		t.Error("Expected error for unknown zone")
+	}
+	records[0].ID = ""
+	if _, err := Terraform(records, map[string]string{"example.com": "zone1"}); err == nil {
+		t.Error("Expected error for record without id")
+	}
+}
+
+func TestTerraformImports(t *testing.T) {
+	records := []provider.Record{
+		{ID: "r1", Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "r2", Name: "app.example.com", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+	}
+	out, err := TerraformImports(records, map[string]string{"example.com": "zone1"})
+	if err != nil {
+		t.Fatalf("TerraformImports failed: %v", err)
+	}
+	script := string(out)
+	for _, want := range []string{
+		"terraform import cloudflare_record.app_example_com_a zone1/r1\n",
+		"terraform import cloudflare_record.app_example_com_a_2 zone1/r2\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected script to contain %q, got:\n%s", want, script)
+		}
+	}
+}
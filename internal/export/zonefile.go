@@ -0,0 +1,176 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// ZoneFile renders records of a zone as an RFC 1035 master file. Records
+// without a ttl use defaultTTL. No SOA or NS records are written since the
+// zone itself is not managed, prepend them to load the file as a full zone.
+func ZoneFile(zone string, records []provider.Record, defaultTTL int) []byte {
+	origin := fqdn(zone)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "; Generated by caddy-dns-sync export\n$ORIGIN %s\n$TTL %d\n", origin, defaultTTL)
+
+	w := tabwriter.NewWriter(&b, 0, 8, 1, '\t', 0)
+	for _, r := range sortedRecords(records) {
+		ttl := ""
+		if r.TTL >= time.Second {
+			ttl = fmt.Sprint(int(r.TTL / time.Second))
+		}
+		fmt.Fprintf(w, "%s\t%s\tIN\t%s\t%s\n", ownerName(r.Name, zone), ttl, r.Type, rdata(r.Record))
+	}
+	w.Flush()
+	return b.Bytes()
+}
+
+// ownerName returns name relative to the zone origin, @ for the apex
+func ownerName(name, zone string) string {
+	name = strings.TrimSuffix(name, ".")
+	switch {
+	case name == "" || name == "@" || name == zone:
+		return "@"
+	case strings.HasSuffix(name, "."+zone):
+		return strings.TrimSuffix(name, "."+zone)
+	}
+	return name
+}
+
+func rdata(r provider.Record) string {
+	switch r.Type {
+	case "TXT":
+		return provider.QuoteTXT(provider.NormalizeTXT(r.Data))
+	case "CNAME":
+		return fqdn(r.Data)
+	}
+	return r.Data
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// ParseZoneFile reads the records of a master file such as written by
+// ZoneFile, returning the zone named by $ORIGIN. Records are named by fqdn
+// without the trailing dot, SOA and NS records are skipped. Only one record
+// per line is supported, parentheses spanning lines are not.
+func ParseZoneFile(r io.Reader) (string, []provider.Record, error) {
+	origin := ""
+	defaultTTL := time.Duration(0)
+	owner := ""
+	records := []provider.Record{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return "", nil, fmt.Errorf("line %d: $ORIGIN without a name", n)
+			}
+			origin = strings.TrimSuffix(fields[1], ".")
+			continue
+		case "$TTL":
+			if len(fields) < 2 {
+				return "", nil, fmt.Errorf("line %d: $TTL without a value", n)
+			}
+			seconds, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return "", nil, fmt.Errorf("line %d: invalid $TTL %q", n, fields[1])
+			}
+			defaultTTL = time.Duration(seconds) * time.Second
+			continue
+		}
+
+		// A line starting with whitespace continues the previous owner
+		if line[0] != ' ' && line[0] != '\t' {
+			owner = absoluteName(fields[0], origin)
+			fields = fields[1:]
+		}
+		if owner == "" {
+			return "", nil, fmt.Errorf("line %d: record without an owner name", n)
+		}
+
+		record := provider.Record{Name: owner, Zone: origin, TTL: defaultTTL}
+		for len(fields) > 0 && record.Type == "" {
+			field := fields[0]
+			fields = fields[1:]
+			if seconds, err := strconv.Atoi(field); err == nil {
+				record.TTL = time.Duration(seconds) * time.Second
+			} else if field != "IN" {
+				record.Type = strings.ToUpper(field)
+			}
+		}
+		if record.Type == "" || len(fields) == 0 {
+			return "", nil, fmt.Errorf("line %d: expected [ttl] [IN] type rdata", n)
+		}
+
+		switch record.Type {
+		case "SOA", "NS":
+			continue
+		case "TXT":
+			rest := strings.TrimSpace(line[strings.Index(line, fields[0]):])
+			record.Data = provider.NormalizeTXT(rest)
+		case "CNAME":
+			record.Data = strings.TrimSuffix(absoluteName(fields[0], origin), ".")
+		default:
+			record.Data = fields[0]
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	if origin == "" {
+		return "", nil, fmt.Errorf("missing $ORIGIN")
+	}
+	return origin, records, nil
+}
+
+// absoluteName resolves a possibly relative owner or target name against
+// origin, @ being the origin itself
+func absoluteName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, ".")
+	case origin == "":
+		return name
+	}
+	return name + "." + origin
+}
+
+// stripComment removes a ; comment, ignoring semicolons in quoted strings
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
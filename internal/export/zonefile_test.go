@@ -0,0 +1,114 @@
+package export
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+func TestZoneFile(t *testing.T) {
+	records := []provider.Record{
+		{Name: "app", Type: "TXT", Data: "heritage=caddy-dns-sync,caddy-dns-sync/owner=a", Zone: "example.com", TTL: 300 * time.Second},
+		{Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com", TTL: 300 * time.Second},
+		{Name: "example.com", Type: "CNAME", Data: "backend.example.net", Zone: "example.com"},
+		{Name: "v6.example.com", Type: "AAAA", Data: "2001:db8::1", Zone: "example.com"},
+	}
+	out := string(ZoneFile("example.com", records, 3600))
+
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	expected := []string{
+		"; Generated by caddy-dns-sync export",
+		"$ORIGIN example.com.",
+		"$TTL 3600",
+		"app 300 IN A 10.0.0.1",
+		`app 300 IN TXT "heritage=caddy-dns-sync,caddy-dns-sync/owner=a"`,
+		"@ IN CNAME backend.example.net.",
+		"v6 IN AAAA 2001:db8::1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("ZoneFile =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestOwnerName(t *testing.T) {
+	tests := map[string]string{
+		"":                  "@",
+		"@":                 "@",
+		"example.com":       "@",
+		"example.com.":      "@",
+		"app":               "app",
+		"app.example.com":   "app",
+		"a.b.example.com.":  "a.b",
+		"app.other.example": "app.other.example",
+	}
+	for name, want := range tests {
+		if got := ownerName(name, "example.com"); got != want {
+			t.Errorf("ownerName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseZoneFile(t *testing.T) {
+	records := []provider.Record{
+		{Name: "app.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com", TTL: 300 * time.Second},
+		{Name: "app.example.com", Type: "TXT", Data: "heritage=caddy-dns-sync,caddy-dns-sync/owner=a", Zone: "example.com", TTL: 300 * time.Second},
+		{Name: "example.com", Type: "CNAME", Data: "backend.example.net", Zone: "example.com", TTL: 3600 * time.Second},
+		{Name: "v6.example.com", Type: "AAAA", Data: "2001:db8::1", Zone: "example.com", TTL: 3600 * time.Second},
+	}
+	zone, parsed, err := ParseZoneFile(strings.NewReader(string(ZoneFile("example.com", records, 3600))))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if zone != "example.com" {
+		t.Errorf("Expected zone example.com, got %q", zone)
+	}
+	if len(parsed) != len(records) {
+		t.Fatalf("Expected %d records, got %d: %v", len(records), len(parsed), parsed)
+	}
+	for i := range records {
+		if parsed[i] != records[i] {
+			t.Errorf("Record %d = %+v, want %+v", i, parsed[i], records[i])
+		}
+	}
+}
+
+func TestParseZoneFileSyntax(t *testing.T) {
+	input := `$ORIGIN example.com.
+$TTL 600
+@	IN	SOA	ns1 hostmaster 1 7200 3600 1209600 600
+	IN	NS	ns1.example.net.
+app	A	10.0.0.1 ; trailing comment
+	60 IN AAAA 2001:db8::1
+www.example.com. IN CNAME app
+note IN TXT "a;b"
+`
+	_, parsed, err := ParseZoneFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []provider.Record{
+		{Name: "app.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com", TTL: 600 * time.Second},
+		{Name: "app.example.com", Type: "AAAA", Data: "2001:db8::1", Zone: "example.com", TTL: 60 * time.Second},
+		{Name: "www.example.com", Type: "CNAME", Data: "app.example.com", Zone: "example.com", TTL: 600 * time.Second},
+		{Name: "note.example.com", Type: "TXT", Data: "a;b", Zone: "example.com", TTL: 600 * time.Second},
+	}
+	if len(parsed) != len(expected) {
+		t.Fatalf("Expected %d records, got %d: %v", len(expected), len(parsed), parsed)
+	}
+	for i := range expected {
+		if parsed[i] != expected[i] {
+			t.Errorf("Record %d = %+v, want %+v", i, parsed[i], expected[i])
+		}
+	}
+
+	for _, bad := range []string{"app IN A 10.0.0.1\n", "$ORIGIN example.com.\napp IN A\n", "$ORIGIN example.com.\n$TTL soon\n"} {
+		if _, _, err := ParseZoneFile(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package hostlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// maxListSize bounds the list fetched from a source
+const maxListSize = 1 << 20
+
+// ErrNotLoaded is returned until the list was loaded once
+var ErrNotLoaded = errors.New("host list not loaded yet")
+
+// List holds host globs, e.g. *.example.com. A host is allowed if it matches
+// an include glob, or include is empty, and matches no exclude glob.
+type List struct {
+	Include []string `yaml:"include" json:"include"`
+	Exclude []string `yaml:"exclude" json:"exclude"`
+}
+
+// Allows reports whether the list allows host
+func (l List) Allows(host string) bool {
+	for _, pattern := range l.Exclude {
+		if match(pattern, host) {
+			return false
+		}
+	}
+	if len(l.Include) == 0 {
+		return true
+	}
+	for _, pattern := range l.Include {
+		if match(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func match(pattern, host string) bool {
+	ok, _ := path.Match(pattern, host)
+	return ok
+}
+
+// Parse reads a list in yaml or json
+func Parse(data []byte) (List, error) {
+	var list List
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return List{}, fmt.Errorf("parse host list: %w", err)
+	}
+	for _, pattern := range append(list.Include, list.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return List{}, fmt.Errorf("host list pattern %q is invalid: %w", pattern, err)
+		}
+	}
+	return list, nil
+}
+
+// Loader keeps the latest list loaded from a file or http source. A failed
+// refresh keeps the list loaded before.
+type Loader struct {
+	source string
+	token  string
+	agent  string
+	http   *http.Client
+
+	mu     sync.RWMutex
+	list   List
+	loaded bool
+}
+
+func New(cfg config.HostList, userAgent string) *Loader {
+	return &Loader{
+		source: cfg.Source,
+		token:  cfg.Token,
+		agent:  userAgent,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Allows reports whether the latest list allows host, failing until the list
+// was loaded so nothing is published or removed on a missing list
+func (l *Loader) Allows(host string) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if !l.loaded {
+		return false, ErrNotLoaded
+	}
+	return l.list.Allows(host), nil
+}
+
+// Load fetches the list from the source and makes it current
+func (l *Loader) Load(ctx context.Context) error {
+	data, err := l.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	list, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.list = list
+	l.loaded = true
+	l.mu.Unlock()
+	slog.Info("Loaded host list", "source", l.source, "include", len(list.Include), "exclude", len(list.Exclude))
+	return nil
+}
+
+func (l *Loader) fetch(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(l.source, "http://") && !strings.HasPrefix(l.source, "https://") {
+		data, err := os.ReadFile(l.source)
+		if err != nil {
+			return nil, fmt.Errorf("read host list: %w", err)
+		}
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create host list request: %w", err)
+	}
+	if l.token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.token)
+	}
+	if l.agent != "" {
+		req.Header.Set("User-Agent", l.agent)
+	}
+	resp, err := l.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch host list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch host list: unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxListSize))
+	if err != nil {
+		return nil, fmt.Errorf("read host list: %w", err)
+	}
+	return data, nil
+}
+
+// Run loads the list again every interval until ctx is done
+func (l *Loader) Run(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("Stopping host list refresh")
+			return
+		}
+
+		if err := l.Load(ctx); err != nil {
+			slog.Error("Failed to refresh host list, keeping the previous list", "source", l.source, "error", err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package hostlist
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+)
+
+func TestListAllows(t *testing.T) {
+	tests := []struct {
+		name  string
+		list  List
+		host  string
+		allow bool
+	}{
+		{name: "empty list allows all", host: "app.example.com", allow: true},
+		{name: "included", list: List{Include: []string{"*.example.com"}}, host: "app.example.com", allow: true},
+		{name: "not included", list: List{Include: []string{"*.example.com"}}, host: "app.example.org", allow: false},
+		{name: "excluded", list: List{Exclude: []string{"admin.*"}}, host: "admin.example.com", allow: false},
+		{name: "exclude wins", list: List{Include: []string{"*.example.com"}, Exclude: []string{"admin.example.com"}}, host: "admin.example.com", allow: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.list.Allows(tt.host); got != tt.allow {
+				t.Errorf("Allows(%q) = %v, want %v", tt.host, got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	list, err := Parse([]byte(`{"include": ["*.example.com"], "exclude": ["admin.example.com"]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(list.Include) != 1 || len(list.Exclude) != 1 {
+		t.Errorf("Parsed %+v", list)
+	}
+	if _, err := Parse([]byte("include: ['[']")); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+	if _, err := Parse([]byte("include: {")); err == nil {
+		t.Error("Expected error for invalid yaml")
+	}
+}
+
+func TestLoader(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hosts.yaml")
+		if err := os.WriteFile(path, []byte("include:\n  - '*.example.com'\n"), 0o644); err != nil {
+			t.Fatalf("failed to write list: %v", err)
+		}
+		loader := New(config.HostList{Source: path}, "")
+		if _, err := loader.Allows("app.example.com"); !errors.Is(err, ErrNotLoaded) {
+			t.Errorf("Expected ErrNotLoaded before loading, got %v", err)
+		}
+		if err := loader.Load(ctx); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if ok, err := loader.Allows("app.example.com"); !ok || err != nil {
+			t.Errorf("Expected app.example.com allowed, got %v, %v", ok, err)
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		body := "exclude: [admin.example.com]"
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		defer server.Close()
+
+		loader := New(config.HostList{Source: server.URL, Token: "secret"}, "test-agent")
+		if err := loader.Load(ctx); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if ok, _ := loader.Allows("admin.example.com"); ok {
+			t.Error("Expected admin.example.com excluded")
+		}
+
+		// A failed refresh keeps the previous list
+		status = http.StatusInternalServerError
+		body = ""
+		if err := loader.Load(ctx); err == nil {
+			t.Error("Expected error for failed fetch")
+		}
+		if ok, err := loader.Allows("admin.example.com"); ok || err != nil {
+			t.Errorf("Expected previous list kept, got %v, %v", ok, err)
+		}
+	})
+}
@@ -1,9 +1,11 @@
 package logger
 
 import (
+	"context"
 	"log/slog"
 	"os"
 
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
 	"github.com/lmittmann/tint"
 )
 
@@ -17,7 +19,28 @@ func Configure(levelStr string, env string) {
 	} else {
 		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
 	}
-	slog.SetDefault(slog.New(handler))
+	slog.SetDefault(slog.New(runHandler{handler}))
+}
+
+// runHandler adds the run id carried by the context to every record logged
+// with one, e.g. through slog.InfoContext
+type runHandler struct {
+	slog.Handler
+}
+
+func (h runHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := runid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("run_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h runHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return runHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h runHandler) WithGroup(name string) slog.Handler {
+	return runHandler{h.Handler.WithGroup(name)}
 }
 
 func parseLogLevel(level string) slog.Level {
@@ -5,19 +5,53 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/evanofslack/caddy-dns-sync/internal/version"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Metrics struct {
 	registry       *prometheus.Registry
+	collectors     []prometheus.Collector
+	specs          []Spec
+	buildInfo      *prometheus.GaugeVec   // constant 1, labeled with build details
 	syncRuns       *prometheus.CounterVec // total syncs
 	syncDuration   prometheus.Histogram   // time to sync
+	syncOverruns   *prometheus.CounterVec // syncs that took longer than the interval
+	syncLag        *prometheus.GaugeVec   // time the latest sync ran past the interval
+	syncInterval   *prometheus.GaugeVec   // current time between scheduled syncs
 	dnsOperations  *prometheus.CounterVec // dns operations
 	dnsRequests    *prometheus.CounterVec // dns provider requests
+	dnsErrors      *prometheus.CounterVec // failed dns provider requests by error class
+	dnsResolved    *prometheus.CounterVec // creates refused as existing that were resolved without failing
+	churnSuppress  *prometheus.CounterVec // upstream changes held back by the churn cooldown
+	secondaryFail  *prometheus.CounterVec // writes the secondary provider failed to mirror
+	secondaryDiv   *prometheus.GaugeVec   // managed records the secondary provider holds unlike the primary
 	caddyEntries   *prometheus.GaugeVec   // known caddy entries
+	caddyHandlers  *prometheus.GaugeVec   // known caddy terminal handlers
 	caddyRequests  *prometheus.CounterVec // caddy requests
+	caddyChanges   *prometheus.CounterVec // detected caddy config changes
+	unhealthy      *prometheus.GaugeVec   // caddy upstreams failing health checks
+	filteredHosts  *prometheus.GaugeVec   // caddy hosts not synced
+	skippedHosts   *prometheus.GaugeVec   // hosts no longer retried after failures
+	discovered     *prometheus.GaugeVec   // caddy hosts discovered
+	managed        *prometheus.GaugeVec   // owned records by zone
+	unmanaged      *prometheus.GaugeVec   // records of removed hosts left in place as not owned
+	drift          *prometheus.GaugeVec   // differences against live zones in shadow mode
+	outOfSync      *prometheus.GaugeVec   // records left diverging from desired state by the latest sync
+	ownership      *prometheus.CounterVec // caddy hosts found with a name another owner holds
+	paused         *prometheus.GaugeVec   // 1 while dns writes are paused
+	safeguard      *prometheus.GaugeVec   // 1 while plans are dry run after startup
 	badgerRequests *prometheus.CounterVec // badgerdb requests
+	storeKeys      *prometheus.GaugeVec   // keys in the state store by kind
+	storeBytes     *prometheus.GaugeVec   // size of the state store on disk
+	storePruned    *prometheus.CounterVec // state store entries deleted by retention
+	snapUploads    *prometheus.CounterVec // state snapshots uploaded to the remote store
+	syncHooks      *prometheus.CounterVec // pre and post sync hook runs
+	consistency    *prometheus.GaugeVec   // hosts of the latest consistency check by kind
+	checks         *prometheus.CounterVec // consistency checks of caddy, state and the live zones
+	faults         *prometheus.CounterVec // faults injected into provider requests
+	openMetrics    bool                   // serve the openmetrics format, which carries exemplars
 }
 
 // Public interface for metrics operations
@@ -26,10 +60,61 @@ func (m *Metrics) IncSyncRun(success bool) {
 	m.syncRuns.WithLabelValues(status).Inc()
 }
 
-func (m *Metrics) SetSyncDuration(duration time.Duration) {
+// IncSyncNoop counts runs skipped because the caddy config was unchanged
+func (m *Metrics) IncSyncNoop() {
+	m.syncRuns.WithLabelValues("noop").Inc()
+}
+
+// IncSyncInterrupted counts runs found interrupted mid execution
+func (m *Metrics) IncSyncInterrupted() {
+	m.syncRuns.WithLabelValues("interrupted").Inc()
+}
+
+// SetSyncDuration observes the duration of a sync, linking it to the run id
+// as an exemplar when one is given
+func (m *Metrics) SetSyncDuration(duration time.Duration, runID string) {
+	if observer, ok := m.syncDuration.(prometheus.ExemplarObserver); ok && runID != "" {
+		observer.ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"run_id": runID})
+		return
+	}
 	m.syncDuration.Observe(duration.Seconds())
 }
 
+// IncSyncOverrun counts a sync that ran past the interval, by what was done
+// with the syncs it delayed
+func (m *Metrics) IncSyncOverrun(action string) {
+	m.syncOverruns.WithLabelValues(action).Inc()
+}
+
+// IncChurnSuppressed counts an upstream change held back by the churn cooldown
+func (m *Metrics) IncChurnSuppressed(zone string) {
+	m.churnSuppress.WithLabelValues(zone).Inc()
+}
+
+// IncSecondaryFailure counts a write that failed to mirror to the secondary
+// provider
+func (m *Metrics) IncSecondaryFailure(operation, zone string) {
+	m.secondaryFail.WithLabelValues(operation, zone).Inc()
+}
+
+// SetSecondaryDivergence sets how many managed records of zone the secondary
+// provider lacks or holds unlike the primary
+func (m *Metrics) SetSecondaryDivergence(zone string, count int) {
+	m.secondaryDiv.WithLabelValues(zone).Set(float64(count))
+}
+
+// SetSyncLag sets how long the latest sync ran past the interval, zero if it
+// finished in time
+func (m *Metrics) SetSyncLag(lag time.Duration) {
+	m.syncLag.WithLabelValues().Set(lag.Seconds())
+}
+
+// SetSyncInterval sets the current time between scheduled syncs, which
+// changes with the adaptive interval
+func (m *Metrics) SetSyncInterval(interval time.Duration) {
+	m.syncInterval.WithLabelValues().Set(interval.Seconds())
+}
+
 func (m *Metrics) IncDNSOperation(operation, zone, recordType string) {
 	if !isValidOperation(operation) || !isValidRecordType(recordType) || zone == "" {
 		return
@@ -45,11 +130,131 @@ func (m *Metrics) IncDNSRequest(operation, zone string, success bool) {
 	m.dnsRequests.WithLabelValues(operation, zone, status).Inc()
 }
 
+func (m *Metrics) IncDNSError(operation, zone, class string) {
+	if !isValidOperation(operation) || zone == "" {
+		return
+	}
+	m.dnsErrors.WithLabelValues(operation, zone, class).Inc()
+}
+
+// IncConflictResolved counts a create the provider refused as already existing
+// that was resolved without failing, by how it was resolved
+func (m *Metrics) IncConflictResolved(zone, recordType, resolution string) {
+	if zone == "" {
+		return
+	}
+	m.dnsResolved.WithLabelValues(zone, recordType, resolution).Inc()
+}
+
+// IncInjectedFault counts a failure or delay chaos injected into a provider request
+func (m *Metrics) IncInjectedFault(operation, kind string) {
+	if !isValidOperation(operation) {
+		return
+	}
+	m.faults.WithLabelValues(operation, kind).Inc()
+}
+
 func (m *Metrics) SetCaddyEntries(count int, rp bool) {
 	rpstr := boolToStr(rp)
 	m.caddyEntries.WithLabelValues(rpstr).Set(float64(count))
 }
 
+func (m *Metrics) SetCaddyHandlers(handler string, count int) {
+	m.caddyHandlers.WithLabelValues(handler).Set(float64(count))
+}
+
+func (m *Metrics) IncCaddyConfigChange() {
+	m.caddyChanges.WithLabelValues().Inc()
+}
+
+// SetUnhealthyUpstreams sets how many caddy upstreams fail health checks
+func (m *Metrics) SetUnhealthyUpstreams(count int) {
+	m.unhealthy.WithLabelValues().Set(float64(count))
+}
+
+func (m *Metrics) SetFilteredHosts(reason string, count int) {
+	m.filteredHosts.WithLabelValues(reason).Set(float64(count))
+}
+
+// SetStoreSize sets the keys by kind and bytes on disk of the state store
+func (m *Metrics) SetStoreSize(keys map[string]int, bytes int64) {
+	for kind, count := range keys {
+		m.storeKeys.WithLabelValues(kind).Set(float64(count))
+	}
+	m.storeBytes.WithLabelValues().Set(float64(bytes))
+}
+
+// AddStorePruned counts state store entries of kind deleted by retention
+func (m *Metrics) AddStorePruned(kind string, count int) {
+	m.storePruned.WithLabelValues(kind).Add(float64(count))
+}
+
+// IncSyncHook counts a run of the pre or post sync hook
+func (m *Metrics) IncSyncHook(hook string, success bool) {
+	m.syncHooks.WithLabelValues(hook, boolToResult(success)).Inc()
+}
+
+// IncSnapshotUpload counts a state snapshot uploaded to the remote store
+func (m *Metrics) IncSnapshotUpload(success bool) {
+	m.snapUploads.WithLabelValues(boolToResult(success)).Inc()
+}
+
+func (m *Metrics) SetSkippedHosts(count int) {
+	m.skippedHosts.WithLabelValues().Set(float64(count))
+}
+
+func (m *Metrics) SetDiscoveredHosts(count int) {
+	m.discovered.WithLabelValues().Set(float64(count))
+}
+
+func (m *Metrics) SetManagedRecords(zone string, count int) {
+	m.managed.WithLabelValues(zone).Set(float64(count))
+}
+
+func (m *Metrics) SetUnmanagedSkipped(zone string, count int) {
+	m.unmanaged.WithLabelValues(zone).Set(float64(count))
+}
+
+func (m *Metrics) SetDriftRecords(zone, kind string, count int) {
+	m.drift.WithLabelValues(zone, kind).Set(float64(count))
+}
+
+// SetConsistency sets the hosts of zone the latest consistency check found kind
+func (m *Metrics) SetConsistency(zone, kind string, count int) {
+	m.consistency.WithLabelValues(zone, kind).Set(float64(count))
+}
+
+// IncConsistencyCheck counts a consistency check of caddy, state and the live zones
+func (m *Metrics) IncConsistencyCheck(success bool) {
+	m.checks.WithLabelValues(boolToResult(success)).Inc()
+}
+
+func (m *Metrics) SetOutOfSync(zone string, count int) {
+	m.outOfSync.WithLabelValues(zone).Set(float64(count))
+}
+
+// IncOwnershipConflict counts a caddy host found with a name another owner holds
+func (m *Metrics) IncOwnershipConflict(zone string) {
+	m.ownership.WithLabelValues(zone).Inc()
+}
+
+func (m *Metrics) SetPaused(paused bool) {
+	value := 0.0
+	if paused {
+		value = 1
+	}
+	m.paused.WithLabelValues().Set(value)
+}
+
+// SetStartupSafeguard sets whether plans are dry run by the startup safeguard
+func (m *Metrics) SetStartupSafeguard(active bool) {
+	value := 0.0
+	if active {
+		value = 1
+	}
+	m.safeguard.WithLabelValues().Set(value)
+}
+
 func (m *Metrics) IncCaddyRequest(success bool, code int) {
 	status := boolToResult(success)
 	scode := strconv.Itoa(code)
@@ -95,71 +300,129 @@ func isValidRecordType(rt string) bool {
 	return false
 }
 
-func New(register bool) *Metrics {
-	registry := prometheus.NewRegistry()
-	namespace := "caddy_dns_sync"
+const namespace = "caddy_dns_sync"
+
+// Metric types reported in Spec
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
 
+// Spec describes an exposed metric, used to generate monitoring assets
+type Spec struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels []string
+}
+
+func New(register bool) *Metrics {
 	m := &Metrics{
-		registry: registry,
-
-		syncRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "sync_runs_total",
-			Help:      "Total number of synchronization runs",
-		}, []string{"status"}),
-
-		syncDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
-			Namespace: namespace,
-			Name:      "sync_duration_milliseconds",
-			Help:      "Duration of synchronization runs in milliseconds",
-			Buckets:   prometheus.DefBuckets,
-		}),
-
-		dnsOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "dns_operations_total",
-			Help:      "Total DNS operations managed by app",
-		}, []string{"operation", "zone", "type"}),
-
-		dnsRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "dns_requests_total",
-			Help:      "Total DNS provider requests",
-		}, []string{"operation", "zone", "status"}),
-
-		caddyEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      "caddy_entries_current",
-			Help:      "Current known caddy entries",
-		}, []string{"reverse_proxy"}),
-
-		caddyRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "caddy_requests_total",
-			Help:      "Total caddy requests",
-		}, []string{"status", "code"}),
-
-		badgerRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      "badgerdb_requests_total",
-			Help:      "Total badgerdb requests",
-		}, []string{"operation", "status"}),
+		registry: prometheus.NewRegistry(),
 	}
 
+	m.buildInfo = m.gaugeVec("build_info", "Build details of the running binary, always 1", "version", "commit", "date", "goversion")
+	m.syncRuns = m.counterVec("sync_runs_total", "Total number of synchronization runs", "status")
+	m.syncDuration = m.histogram("sync_duration_milliseconds", "Duration of synchronization runs in milliseconds", prometheus.DefBuckets)
+	m.syncOverruns = m.counterVec("sync_overruns_total", "Total syncs that took longer than the sync interval, by action taken", "action")
+	m.syncLag = m.gaugeVec("sync_lag_seconds", "Seconds the latest sync ran past the sync interval, 0 if it finished in time")
+	m.syncInterval = m.gaugeVec("sync_interval_seconds", "Current seconds between scheduled syncs")
+	m.dnsOperations = m.counterVec("dns_operations_total", "Total DNS operations managed by app", "operation", "zone", "type")
+	m.dnsRequests = m.counterVec("dns_requests_total", "Total DNS provider requests", "operation", "zone", "status")
+	m.secondaryFail = m.counterVec("secondary_failures_total", "Total writes that failed to mirror to the secondary provider", "operation", "zone")
+	m.secondaryDiv = m.gaugeVec("secondary_divergent_records", "Managed records the secondary provider lacks or holds unlike the primary", "zone")
+	m.churnSuppress = m.counterVec("churn_suppressed_total", "Total upstream changes held back by the churn cooldown", "zone")
+	m.dnsErrors = m.counterVec("dns_errors_total", "Total failed DNS provider requests by error class", "operation", "zone", "class")
+	m.dnsResolved = m.counterVec("dns_conflicts_resolved_total", "Total creates refused as already existing that were resolved, by resolution", "zone", "type", "resolution")
+	m.caddyEntries = m.gaugeVec("caddy_entries_current", "Current known caddy entries", "reverse_proxy")
+	m.caddyHandlers = m.gaugeVec("caddy_handlers_current", "Current known caddy terminal handlers by type", "handler")
+	m.caddyRequests = m.counterVec("caddy_requests_total", "Total caddy requests", "status", "code")
+	m.caddyChanges = m.counterVec("caddy_config_changes_total", "Total caddy config changes detected between syncs")
+	m.unhealthy = m.gaugeVec("caddy_unhealthy_upstreams", "Current caddy upstreams failing health checks, when caddy.upstreamHealth is set")
+	m.filteredHosts = m.gaugeVec("filtered_hosts_current", "Current caddy hosts not synced, by reason", "reason")
+	m.skippedHosts = m.gaugeVec("skipped_hosts_current", "Current hosts no longer retried after consecutive failures")
+	m.discovered = m.gaugeVec("discovered_hosts_current", "Current caddy hosts discovered, synced or not")
+	m.managed = m.gaugeVec("managed_records_current", "Current records managed by app, by zone", "zone")
+	m.unmanaged = m.gaugeVec("unmanaged_records_skipped", "Records of removed hosts not deleted in the latest sync as not owned, by zone", "zone")
+	m.drift = m.gaugeVec("drift_records_current", "Current differences between caddy hosts and live zones in shadow mode, by zone and kind", "zone", "kind")
+	m.outOfSync = m.gaugeVec("records_out_of_sync", "Records whose provider state diverges from desired state after the latest sync, by zone", "zone")
+	m.ownership = m.counterVec("ownership_conflicts_total", "Total caddy hosts found with a heritage record of another owner at their name, by zone", "zone")
+	m.paused = m.gaugeVec("sync_paused", "Whether dns writes are paused, 1 if paused")
+	m.safeguard = m.gaugeVec("startup_safeguard", "Whether plans are dry run by the startup safeguard, 1 while they are")
+	m.badgerRequests = m.counterVec("badgerdb_requests_total", "Total badgerdb requests", "operation", "status")
+	m.storeKeys = m.gaugeVec("state_store_keys", "Current keys in the state store, by kind", "kind")
+	m.storeBytes = m.gaugeVec("state_store_bytes", "Current size of the state store on disk in bytes")
+	m.storePruned = m.counterVec("state_store_pruned_total", "Total state store entries deleted by retention, by kind", "kind")
+	m.snapUploads = m.counterVec("state_snapshot_uploads_total", "Total state snapshots uploaded to the remote store", "status")
+	m.syncHooks = m.counterVec("sync_hooks_total", "Total runs of the pre and post sync hooks", "hook", "status")
+	m.consistency = m.gaugeVec("consistency_hosts_current", "Hosts of the latest consistency check of caddy, state and the live zones, by zone and kind", "zone", "kind")
+	m.checks = m.counterVec("consistency_checks_total", "Total consistency checks of caddy, state and the live zones", "status")
+	m.faults = m.counterVec("injected_faults_total", "Total faults injected into DNS provider requests by chaos, by kind", "operation", "kind")
+
+	build := version.Get()
+	m.buildInfo.WithLabelValues(build.Version, build.Commit, build.Date, build.GoVersion).Set(1)
+
 	if register {
-		registry.MustRegister(
-			m.syncRuns,
-			m.syncDuration,
-			m.dnsOperations,
-			m.dnsRequests,
-			m.caddyEntries,
-			m.caddyRequests,
-			m.badgerRequests,
-		)
+		m.registry.MustRegister(m.collectors...)
 	}
 	return m
 }
 
+// Specs describes every metric exposed by the app
+func (m *Metrics) Specs() []Spec {
+	specs := make([]Spec, len(m.specs))
+	copy(specs, m.specs)
+	return specs
+}
+
+func (m *Metrics) add(c prometheus.Collector, name, help, typ string, labels []string) {
+	m.collectors = append(m.collectors, c)
+	m.specs = append(m.specs, Spec{
+		Name:   namespace + "_" + name,
+		Help:   help,
+		Type:   typ,
+		Labels: labels,
+	})
+}
+
+func (m *Metrics) counterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, labels)
+	m.add(c, name, help, TypeCounter, labels)
+	return c
+}
+
+func (m *Metrics) gaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, labels)
+	m.add(g, name, help, TypeGauge, labels)
+	return g
+}
+
+func (m *Metrics) histogram(name, help string, buckets []float64) prometheus.Histogram {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+		Buckets:   buckets,
+	})
+	m.add(h, name, help, TypeHistogram, nil)
+	return h
+}
+
+// EnableOpenMetrics serves the openmetrics format to scrapers asking for it,
+// exposing the run id exemplars of sync durations
+func (m *Metrics) EnableOpenMetrics() {
+	m.openMetrics = true
+}
+
 func (m *Metrics) Handler() http.Handler {
-	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: m.openMetrics})
 }
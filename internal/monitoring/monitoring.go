@@ -0,0 +1,229 @@
+package monitoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+// alert is a rule template, metric and labels are checked against the exposed
+// metric specs so rules can't silently drift from the code
+type alert struct {
+	name     string
+	metric   string
+	labels   []string
+	expr     string // format string receiving the full metric name
+	forDur   string
+	severity string
+	summary  string
+}
+
+var alerts = []alert{
+	{
+		name:     "CaddyDNSSyncFailing",
+		metric:   "sync_runs_total",
+		labels:   []string{"status"},
+		expr:     `increase(%s{status="failure"}[15m]) > 0`,
+		forDur:   "15m",
+		severity: "warning",
+		summary:  "caddy-dns-sync runs are failing",
+	},
+	{
+		name:     "CaddyDNSSyncStalled",
+		metric:   "sync_runs_total",
+		expr:     `sum(increase(%s[30m])) == 0`,
+		forDur:   "5m",
+		severity: "critical",
+		summary:  "caddy-dns-sync has not completed a run in 30 minutes",
+	},
+	{
+		name:     "CaddyDNSSyncProviderErrors",
+		metric:   "dns_requests_total",
+		labels:   []string{"status", "zone"},
+		expr:     `sum by (zone) (increase(%s{status="failure"}[15m])) > 0`,
+		forDur:   "15m",
+		severity: "warning",
+		summary:  "DNS provider requests are failing for zone {{ $labels.zone }}",
+	},
+	{
+		name:     "CaddyDNSSyncCaddyUnreachable",
+		metric:   "caddy_requests_total",
+		labels:   []string{"status"},
+		expr:     `increase(%s{status="failure"}[15m]) > 0`,
+		forDur:   "15m",
+		severity: "warning",
+		summary:  "caddy admin API requests are failing",
+	},
+	{
+		name:     "CaddyDNSSyncStateErrors",
+		metric:   "badgerdb_requests_total",
+		labels:   []string{"status"},
+		expr:     `increase(%s{status="failure"}[15m]) > 0`,
+		forDur:   "5m",
+		severity: "critical",
+		summary:  "caddy-dns-sync state store requests are failing",
+	},
+	{
+		name:     "CaddyDNSSyncOutOfSync",
+		metric:   "records_out_of_sync",
+		labels:   []string{"zone"},
+		expr:     `%s > 0`,
+		forDur:   "1h",
+		severity: "warning",
+		summary:  "DNS records of zone {{ $labels.zone }} have diverged from caddy for an hour",
+	},
+	{
+		name:     "CaddyDNSSyncOwnershipConflict",
+		metric:   "ownership_conflicts_total",
+		labels:   []string{"zone"},
+		expr:     `sum by (zone) (increase(%s[1h])) > 0`,
+		forDur:   "1m",
+		severity: "warning",
+		summary:  "caddy hosts in zone {{ $labels.zone }} are owned by another caddy-dns-sync instance, two instances may be fighting",
+	},
+	{
+		name:     "CaddyDNSSyncUnmatchedHosts",
+		metric:   "filtered_hosts_current",
+		labels:   []string{"reason"},
+		expr:     `%s{reason="no_zone"} > 0`,
+		forDur:   "1h",
+		severity: "info",
+		summary:  "caddy hosts belong to no configured DNS zone",
+	},
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// AlertRules renders prometheus alerting rules for the given metric specs
+func AlertRules(specs []metrics.Spec) ([]byte, error) {
+	index := make(map[string]metrics.Spec)
+	for _, spec := range specs {
+		index[spec.Name] = spec
+	}
+
+	group := ruleGroup{Name: "caddy-dns-sync"}
+	for _, a := range alerts {
+		name := "caddy_dns_sync_" + a.metric
+		spec, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("alert %s references unknown metric %s", a.name, name)
+		}
+		for _, label := range a.labels {
+			if !hasLabel(spec, label) {
+				return nil, fmt.Errorf("alert %s references unknown label %s on metric %s", a.name, label, name)
+			}
+		}
+		group.Rules = append(group.Rules, rule{
+			Alert:       a.name,
+			Expr:        fmt.Sprintf(a.expr, name),
+			For:         a.forDur,
+			Labels:      map[string]string{"severity": a.severity},
+			Annotations: map[string]string{"summary": a.summary},
+		})
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(ruleFile{Groups: []ruleGroup{group}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Dashboard renders a grafana dashboard with a panel for every metric spec
+func Dashboard(specs []metrics.Spec) ([]byte, error) {
+	panels := []map[string]any{}
+	for i, spec := range specs {
+		panels = append(panels, map[string]any{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       spec.Name,
+			"description": spec.Help,
+			"gridPos": map[string]int{
+				"h": 8,
+				"w": 12,
+				"x": (i % 2) * 12,
+				"y": (i / 2) * 8,
+			},
+			"datasource": map[string]string{"type": "prometheus", "uid": "${datasource}"},
+			"targets": []map[string]string{{
+				"expr":         panelQuery(spec),
+				"legendFormat": legend(spec),
+				"refId":        "A",
+			}},
+		})
+	}
+
+	dashboard := map[string]any{
+		"title":         "caddy-dns-sync",
+		"uid":           "caddy-dns-sync",
+		"schemaVersion": 39,
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]any{
+			"list": []map[string]string{{
+				"name":  "datasource",
+				"type":  "datasource",
+				"query": "prometheus",
+			}},
+		},
+		"panels": panels,
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+func panelQuery(spec metrics.Spec) string {
+	by := ""
+	if len(spec.Labels) > 0 {
+		by = fmt.Sprintf(" by (%s)", strings.Join(spec.Labels, ", "))
+	}
+	switch spec.Type {
+	case metrics.TypeCounter:
+		return fmt.Sprintf("sum%s (rate(%s[$__rate_interval]))", by, spec.Name)
+	case metrics.TypeHistogram:
+		return fmt.Sprintf("histogram_quantile(0.95, sum by (le) (rate(%s_bucket[$__rate_interval])))", spec.Name)
+	default:
+		return fmt.Sprintf("sum%s (%s)", by, spec.Name)
+	}
+}
+
+func legend(spec metrics.Spec) string {
+	if spec.Type == metrics.TypeHistogram {
+		return "p95"
+	}
+	parts := []string{}
+	for _, label := range spec.Labels {
+		parts = append(parts, "{{"+label+"}}")
+	}
+	return strings.Join(parts, " ")
+}
+
+func hasLabel(spec metrics.Spec, label string) bool {
+	for _, l := range spec.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
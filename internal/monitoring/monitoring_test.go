@@ -0,0 +1,66 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+func TestAlertRules(t *testing.T) {
+	specs := metrics.New(false).Specs()
+	out, err := AlertRules(specs)
+	if err != nil {
+		t.Fatalf("AlertRules failed: %v", err)
+	}
+
+	var rules ruleFile
+	if err := yaml.Unmarshal(out, &rules); err != nil {
+		t.Fatalf("Invalid rules yaml: %v", err)
+	}
+	if len(rules.Groups) != 1 || len(rules.Groups[0].Rules) != len(alerts) {
+		t.Fatalf("Expected %d rules, got %+v", len(alerts), rules)
+	}
+	for _, r := range rules.Groups[0].Rules {
+		if !strings.Contains(r.Expr, "caddy_dns_sync_") {
+			t.Errorf("Rule %s expression missing metric name: %s", r.Alert, r.Expr)
+		}
+	}
+}
+
+func TestAlertRulesUnknownMetric(t *testing.T) {
+	specs := []metrics.Spec{{Name: "caddy_dns_sync_sync_runs_total", Type: metrics.TypeCounter}}
+	if _, err := AlertRules(specs); err == nil {
+		t.Fatal("Expected error for rules referencing missing metrics and labels")
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	specs := metrics.New(false).Specs()
+	out, err := Dashboard(specs)
+	if err != nil {
+		t.Fatalf("Dashboard failed: %v", err)
+	}
+
+	var dashboard struct {
+		Panels []struct {
+			Title   string `json:"title"`
+			Targets []struct {
+				Expr string `json:"expr"`
+			} `json:"targets"`
+		} `json:"panels"`
+	}
+	if err := json.Unmarshal(out, &dashboard); err != nil {
+		t.Fatalf("Invalid dashboard json: %v", err)
+	}
+	if len(dashboard.Panels) != len(specs) {
+		t.Fatalf("Expected %d panels, got %d", len(specs), len(dashboard.Panels))
+	}
+	for i, panel := range dashboard.Panels {
+		if panel.Title != specs[i].Name || !strings.Contains(panel.Targets[0].Expr, specs[i].Name) {
+			t.Errorf("Panel %d does not match metric %s: %+v", i, specs[i].Name, panel)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package notify reports service state to systemd over the sd_notify
+// protocol, without linking libsystemd
+package notify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States sent to the service manager
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Send writes state to the socket in NOTIFY_SOCKET. It is a no-op returning
+// false when the process is not supervised by systemd.
+func Send(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// Abstract sockets are passed with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Status formats a free form status line shown by systemctl status
+func Status(msg string) string {
+	return "STATUS=" + msg
+}
+
+// WatchdogInterval returns the keepalive deadline systemd set with
+// WatchdogSec, or zero if the watchdog is not enabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Send(Ready); sent || err != nil {
+		t.Fatalf("Send without socket = %v, %v, want false, nil", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if sent, err := Send(Watchdog); !sent || err != nil {
+		t.Fatalf("Send = %v, %v, want true, nil", sent, err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if got := string(buf[:n]); got != Watchdog {
+		t.Errorf("Received %q, want %q", got, Watchdog)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{name: "unset", want: 0},
+		{name: "enabled", usec: "30000000", want: 30 * time.Second},
+		{name: "own pid", usec: "1000000", pid: strconv.Itoa(os.Getpid()), want: time.Second},
+		{name: "other pid", usec: "1000000", pid: "1", want: 0},
+		{name: "invalid", usec: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			if got := WatchdogInterval(); got != tt.want {
+				t.Errorf("WatchdogInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
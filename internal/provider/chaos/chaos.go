@@ -0,0 +1,171 @@
+// Package chaos wraps a provider to fail or delay a share of its requests, so
+// alerting, retries and rollbacks can be rehearsed before they are needed.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// ErrInjected marks every failure chaos injects
+var ErrInjected = errors.New("injected fault")
+
+// Provider fails or delays requests to the provider it wraps. It implements
+// the optional provider interfaces whatever the wrapped provider supports.
+type Provider struct {
+	next    provider.Provider
+	cfg     config.Chaos
+	metrics *metrics.Metrics
+	random  func() float64 // in [0, 1)
+}
+
+// Wrap injects the faults of cfg into requests to next
+func Wrap(next provider.Provider, cfg config.Chaos, metrics *metrics.Metrics) *Provider {
+	return &Provider{next: next, cfg: cfg, metrics: metrics, random: rand.Float64}
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	if err := p.inject(ctx, "read", zone, ""); err != nil {
+		return nil, err
+	}
+	return p.next.GetRecords(ctx, zone)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	if err := p.inject(ctx, "create", zone, record.Name); err != nil {
+		return err
+	}
+	return p.next.CreateRecord(ctx, zone, record)
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	if err := p.inject(ctx, "update", zone, record.Name); err != nil {
+		return err
+	}
+	return p.next.UpdateRecord(ctx, zone, record)
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	if err := p.inject(ctx, "delete", zone, record.Name); err != nil {
+		return err
+	}
+	return p.next.DeleteRecord(ctx, zone, record)
+}
+
+// FindRecords lists the records of a name, through the zone listing when the
+// wrapped provider cannot look names up
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	if err := p.inject(ctx, "read", zone, name); err != nil {
+		return nil, err
+	}
+	if finder, ok := p.next.(provider.Finder); ok {
+		return finder.FindRecords(ctx, zone, name, recordType)
+	}
+	records, err := p.next.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	found := []provider.Record{}
+	for _, r := range records {
+		if r.Type == recordType && sameName(r.Name, name, zone) {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+func (p *Provider) SupportsComments() bool {
+	c, ok := p.next.(provider.Commenter)
+	return ok && c.SupportsComments()
+}
+
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	if resolver, ok := p.next.(provider.IDResolver); ok {
+		return resolver.RecordID(zone, record)
+	}
+	return "", false
+}
+
+func (p *Provider) SupportsBatch() bool {
+	b, ok := p.next.(provider.Batcher)
+	return ok && b.SupportsBatch()
+}
+
+// ApplyChanges injects faults for every change before passing the batch on,
+// so a failed batch still changes nothing
+func (p *Provider) ApplyChanges(ctx context.Context, zone string, changes []provider.Change) error {
+	b, ok := p.next.(provider.Batcher)
+	if !ok {
+		return fmt.Errorf("provider does not batch changes")
+	}
+	for _, c := range changes {
+		if err := p.inject(ctx, c.Op, zone, c.Record.Name); err != nil {
+			return err
+		}
+	}
+	return b.ApplyChanges(ctx, zone, changes)
+}
+
+// inject delays and fails the request as configured
+func (p *Provider) inject(ctx context.Context, op, zone, name string) error {
+	if len(p.cfg.Ops) > 0 && !slices.Contains(p.cfg.Ops, op) {
+		return nil
+	}
+	if p.cfg.DelayRate > 0 && p.random() < p.cfg.DelayRate {
+		delay := time.Duration(p.random() * float64(p.cfg.MaxDelay))
+		slog.WarnContext(ctx, "Injecting provider delay", "op", op, "zone", zone, "name", name, "delay", delay)
+		p.metrics.IncInjectedFault(op, "delay")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if p.cfg.FailRate > 0 && p.random() < p.cfg.FailRate {
+		class := config.ChaosTransient
+		if len(p.cfg.Errors) > 0 {
+			class = p.cfg.Errors[int(p.random()*float64(len(p.cfg.Errors)))]
+		}
+		slog.WarnContext(ctx, "Injecting provider failure", "op", op, "zone", zone, "name", name, "class", class)
+		p.metrics.IncInjectedFault(op, class)
+		return injectedError(class)
+	}
+	return nil
+}
+
+// injectedError returns an error classified like a real provider failure
+func injectedError(class string) error {
+	switch class {
+	case config.ChaosRateLimited:
+		return fmt.Errorf("%w: %w", provider.ErrRateLimited, ErrInjected)
+	case config.ChaosConflict:
+		return fmt.Errorf("%w: %w", provider.ErrConflict, ErrInjected)
+	case config.ChaosPermission:
+		return fmt.Errorf("%w: %w", provider.ErrPermission, ErrInjected)
+	}
+	return ErrInjected
+}
+
+func sameName(recordName, name, zone string) bool {
+	expand := func(n string) string {
+		n = strings.TrimSuffix(n, ".")
+		switch {
+		case n == "" || n == "@":
+			return zone
+		case n == zone || strings.HasSuffix(n, "."+zone):
+			return n
+		}
+		return n + "." + zone
+	}
+	return expand(recordName) == expand(name)
+}
@@ -0,0 +1,67 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/memory"
+)
+
+func TestProvider(t *testing.T) {
+	record := provider.Record{Name: "app", Type: "A", Data: "10.0.0.1"}
+	tests := []struct {
+		name      string
+		cfg       config.Chaos
+		random    float64
+		createErr error
+		readErr   error
+	}{
+		{name: "disabled", cfg: config.Chaos{}},
+		{name: "every request fails", cfg: config.Chaos{FailRate: 1}, createErr: ErrInjected, readErr: ErrInjected},
+		{name: "below the rate", cfg: config.Chaos{FailRate: 0.5}, random: 0.4, createErr: ErrInjected, readErr: ErrInjected},
+		{name: "above the rate", cfg: config.Chaos{FailRate: 0.5}, random: 0.6},
+		{name: "only creates", cfg: config.Chaos{FailRate: 1, Ops: []string{"create"}}, createErr: ErrInjected},
+		{name: "error class", cfg: config.Chaos{FailRate: 1, Errors: []string{config.ChaosRateLimited}}, createErr: provider.ErrRateLimited, readErr: provider.ErrRateLimited},
+		{name: "delayed", cfg: config.Chaos{DelayRate: 1, MaxDelay: 10 * time.Millisecond}, random: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Wrap(memory.New(map[string][]provider.Record{"example.com": {}}), tt.cfg, metrics.New(false))
+			p.random = func() float64 { return tt.random }
+
+			if err := p.CreateRecord(context.Background(), "example.com", record); !errors.Is(err, tt.createErr) || (err == nil) != (tt.createErr == nil) {
+				t.Errorf("CreateRecord() error = %v, want %v", err, tt.createErr)
+			}
+			if _, err := p.GetRecords(context.Background(), "example.com"); !errors.Is(err, tt.readErr) || (err == nil) != (tt.readErr == nil) {
+				t.Errorf("GetRecords() error = %v, want %v", err, tt.readErr)
+			}
+		})
+	}
+}
+
+func TestProviderOptionalInterfaces(t *testing.T) {
+	p := Wrap(memory.New(map[string][]provider.Record{"example.com": {
+		{Name: "app", Type: "A", Data: "10.0.0.1"},
+		{Name: "app", Type: "TXT", Data: "owner"},
+		{Name: "web", Type: "A", Data: "10.0.0.2"},
+	}}), config.Chaos{}, metrics.New(false))
+
+	found, err := p.FindRecords(context.Background(), "example.com", "app.example.com", "A")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(found) != 1 || found[0].Data != "10.0.0.1" {
+		t.Errorf("Expected the A record of app, got %+v", found)
+	}
+	if !p.SupportsComments() {
+		t.Error("Expected comment support of the memory provider to pass through")
+	}
+	if _, ok := p.RecordID("example.com", provider.Record{Name: "app", Type: "A", Data: "10.0.0.1"}); ok {
+		t.Error("Expected no record ids from the memory provider")
+	}
+}
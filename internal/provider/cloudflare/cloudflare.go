@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cloudflare/cloudflare-go"
@@ -17,6 +20,7 @@ type CloudflareProvider struct {
 	metrics *metrics.Metrics
 	ttl     int
 	zones   map[string]string // Cache zone name to ID mapping
+	ids     *provider.IDCache
 }
 
 func New(cfg config.DNS, metrics *metrics.Metrics) (*CloudflareProvider, error) {
@@ -25,13 +29,31 @@ func New(cfg config.DNS, metrics *metrics.Metrics) (*CloudflareProvider, error)
 		return nil, fmt.Errorf("cloudflare API token required")
 	}
 
-	client, err := cloudflare.NewWithAPIToken(token)
+	opts := []cloudflare.Option{}
+	if cfg.UserAgent != "" {
+		opts = append(opts, cloudflare.UserAgent(cfg.UserAgent))
+	}
+	if cfg.Debug || cfg.DebugBodies {
+		transport := provider.NewDebugTransport(http.DefaultTransport, cfg.DebugBodies, token)
+		opts = append(opts, cloudflare.HTTPClient(&http.Client{Transport: transport}))
+	}
+	client, err := cloudflare.NewWithAPIToken(token, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Cloudflare client: %w", err)
 	}
 
 	// Pre-cache zone IDs for all configured zones
 	zoneCache := make(map[string]string)
+	if len(cfg.Zones) == 0 && cfg.AutoDiscoverZones {
+		zones, err := client.ListZones(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover zones: %w", err)
+		}
+		for _, z := range zones {
+			zoneCache[z.Name] = z.ID
+		}
+		slog.Info("Discovered DNS zones", "count", len(zoneCache))
+	}
 	for _, zone := range cfg.Zones {
 		id, err := client.ZoneIDByName(zone)
 		if err != nil {
@@ -45,11 +67,28 @@ func New(cfg config.DNS, metrics *metrics.Metrics) (*CloudflareProvider, error)
 		metrics: metrics,
 		ttl:     cfg.TTL,
 		zones:   zoneCache,
+		ids:     provider.NewIDCache(),
 	}, nil
 }
 
+// Zones returns the names of all zones known to the provider
+func (p *CloudflareProvider) Zones() []string {
+	zones := make([]string, 0, len(p.zones))
+	for zone := range p.zones {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// ZoneID returns the cloudflare id of a zone
+func (p *CloudflareProvider) ZoneID(zone string) (string, bool) {
+	id, ok := p.zones[zone]
+	return id, ok
+}
+
 func (p *CloudflareProvider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
-	slog.Info("Getting DNS records", "zone", zone)
+	slog.InfoContext(ctx, "Getting DNS records", "zone", zone)
 	start := time.Now()
 
 	zoneID, ok := p.zones[zone]
@@ -71,8 +110,7 @@ func (p *CloudflareProvider) GetRecords(ctx context.Context, zone string) ([]pro
 
 		records, resultInfo, err := p.client.ListDNSRecords(ctx, rc, params)
 		if err != nil {
-			p.metrics.IncDNSRequest("read", zone, false)
-			return nil, fmt.Errorf("failed to list DNS records: %w", err)
+			return nil, fmt.Errorf("failed to list DNS records: %w", p.fail("read", zone, err))
 		}
 
 		allRecords = append(allRecords, records...)
@@ -85,23 +123,22 @@ func (p *CloudflareProvider) GetRecords(ctx context.Context, zone string) ([]pro
 	// Convert to provider records
 	var result []provider.Record
 	for _, r := range allRecords {
-		result = append(result, provider.Record{
-			ID:   r.ID,
-			Name: r.Name,
-			Type: r.Type,
-			Data: r.Content,
-			TTL:  time.Duration(r.TTL) * time.Second,
-			Zone: zone,
-		})
+		result = append(result, toRecord(r, zone))
 	}
 
+	p.ids.Reset(zone, result)
 	p.metrics.IncDNSRequest("read", zone, true)
-	slog.Debug("Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
+	slog.DebugContext(ctx, "Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
 	return result, nil
 }
 
+// RecordID returns the id of a record created or listed by the provider
+func (p *CloudflareProvider) RecordID(zone string, record provider.Record) (string, bool) {
+	return p.ids.Get(zone, record)
+}
+
 func (p *CloudflareProvider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
-	slog.Info("Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	slog.InfoContext(ctx, "Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
 	start := time.Now()
 
 	zoneID, ok := p.zones[zone]
@@ -112,23 +149,27 @@ func (p *CloudflareProvider) CreateRecord(ctx context.Context, zone string, reco
 	params := cloudflare.CreateDNSRecordParams{
 		Type:    record.Type,
 		Name:    record.Name,
-		Content: record.Data,
+		Content: content(record),
 		TTL:     int(record.TTL.Seconds()),
+		Comment: record.Comment,
+	}
+	if record.Type != "TXT" {
+		params.Proxied = &record.Proxied
 	}
 
-	_, err := p.client.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), params)
+	created, err := p.client.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), params)
 	if err != nil {
-		p.metrics.IncDNSRequest("create", zone, false)
-		return fmt.Errorf("failed to create DNS record: %w", err)
+		return fmt.Errorf("failed to create DNS record: %w", p.fail("create", zone, err))
 	}
+	p.ids.Set(zone, record, created.ID)
 
 	p.metrics.IncDNSRequest("create", zone, true)
-	slog.Debug("Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	slog.DebugContext(ctx, "Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
 	return nil
 }
 
 func (p *CloudflareProvider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
-	slog.Info("Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	slog.InfoContext(ctx, "Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
 	start := time.Now()
 
 	zoneID, ok := p.zones[zone]
@@ -140,23 +181,31 @@ func (p *CloudflareProvider) UpdateRecord(ctx context.Context, zone string, reco
 		ID:      record.ID,
 		Type:    record.Type,
 		Name:    record.Name,
-		Content: record.Data,
+		Content: content(record),
 		TTL:     int(record.TTL.Seconds()),
 	}
+	// An empty comment keeps the current one, records not carrying ownership
+	// keep comments added by hand
+	if record.Comment != "" {
+		params.Comment = &record.Comment
+	}
+	if record.Type != "TXT" {
+		params.Proxied = &record.Proxied
+	}
 
 	_, err := p.client.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), params)
 	if err != nil {
-		p.metrics.IncDNSRequest("update", zone, false)
-		return fmt.Errorf("failed to update DNS record: %w", err)
+		return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
 	}
+	p.ids.Set(zone, record, record.ID)
 
 	p.metrics.IncDNSRequest("update", zone, true)
-	slog.Debug("Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	slog.DebugContext(ctx, "Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
 	return nil
 }
 
 func (p *CloudflareProvider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
-	slog.Info("Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
+	slog.InfoContext(ctx, "Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
 	start := time.Now()
 
 	zoneID, ok := p.zones[zone]
@@ -164,13 +213,114 @@ func (p *CloudflareProvider) DeleteRecord(ctx context.Context, zone string, reco
 		return fmt.Errorf("zone %s not found in configuration", zone)
 	}
 
-	err := p.client.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), record.ID)
+	// Records created during this run carry no ID, use the cached one or look it up
+	recordID := record.ID
+	if recordID == "" {
+		recordID, _ = p.ids.Get(zone, record)
+	}
+	if recordID == "" {
+		id, err := p.lookupRecordID(ctx, zoneID, zone, record)
+		if err != nil {
+			return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
+		}
+		recordID = id
+	}
+
+	err := p.client.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), recordID)
 	if err != nil {
-		p.metrics.IncDNSRequest("delete", zone, false)
-		return fmt.Errorf("failed to delete DNS record: %w", err)
+		return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
 	}
+	p.ids.Remove(zone, record)
 
 	p.metrics.IncDNSRequest("delete", zone, true)
-	slog.Debug("Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	slog.DebugContext(ctx, "Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
 	return nil
 }
+
+func (p *CloudflareProvider) lookupRecordID(ctx context.Context, zoneID, zone string, record provider.Record) (string, error) {
+	params := cloudflare.ListDNSRecordsParams{
+		Type: record.Type,
+		Name: fqdn(record.Name, zone),
+	}
+	// TXT content may be stored quoted or split, so match it after listing
+	if record.Type != "TXT" {
+		params.Content = record.Data
+	}
+	records, _, err := p.client.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), params)
+	if err != nil {
+		return "", p.fail("read", zone, err)
+	}
+	p.metrics.IncDNSRequest("read", zone, true)
+	for _, r := range records {
+		if provider.SameValue(provider.Record{Type: r.Type, Data: r.Content}, record) {
+			return r.ID, nil
+		}
+	}
+	return "", fmt.Errorf("record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+}
+
+// FindRecords lists the records of a single name and type
+func (p *CloudflareProvider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	zoneID, ok := p.zones[zone]
+	if !ok {
+		return nil, fmt.Errorf("zone %s not found in configuration", zone)
+	}
+	params := cloudflare.ListDNSRecordsParams{
+		Type: recordType,
+		Name: fqdn(name, zone),
+	}
+	records, _, err := p.client.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find DNS records: %w", p.fail("read", zone, err))
+	}
+	p.metrics.IncDNSRequest("read", zone, true)
+
+	result := make([]provider.Record, 0, len(records))
+	for _, r := range records {
+		record := toRecord(r, zone)
+		p.ids.Set(zone, record, record.ID)
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+func toRecord(r cloudflare.DNSRecord, zone string) provider.Record {
+	record := provider.Record{
+		ID:      r.ID,
+		Name:    r.Name,
+		Type:    r.Type,
+		Data:    r.Content,
+		TTL:     time.Duration(r.TTL) * time.Second,
+		Zone:    zone,
+		Comment: r.Comment,
+	}
+	if r.Proxied != nil {
+		record.Proxied = *r.Proxied
+	}
+	return record
+}
+
+// SupportsComments reports that cloudflare keeps a comment with every record
+func (p *CloudflareProvider) SupportsComments() bool {
+	return true
+}
+
+// content returns the record data as sent to cloudflare, which expects TXT
+// content quoted
+func content(record provider.Record) string {
+	if record.Type == "TXT" {
+		return provider.QuoteTXT(provider.NormalizeTXT(record.Data))
+	}
+	return record.Data
+}
+
+// fqdn expands a zone relative record name
+func fqdn(name, zone string) string {
+	if name == "@" || name == zone {
+		return zone
+	}
+	if strings.HasSuffix(name, "."+zone) {
+		return name
+	}
+	return name + "." + zone
+}
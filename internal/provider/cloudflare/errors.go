@@ -0,0 +1,59 @@
+package cloudflare
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// Cloudflare error codes for records clashing with an existing record
+const (
+	codeRecordExists    = 81057 // identical record already exists
+	codeRecordConflict  = 81053 // A, AAAA or CNAME already exists with that host
+	codeCNAMEConflict   = 81054 // CNAME already exists with that host
+	codeDuplicateRecord = 81058 // record with those settings already exists
+)
+
+// classify wraps a cloudflare api error with the matching provider error
+func classify(err error) error {
+	if provider.ErrorClass(err) != provider.ClassUnknown {
+		return err
+	}
+	var (
+		rateLimit *cloudflare.RatelimitError
+		notFound  *cloudflare.NotFoundError
+		authn     *cloudflare.AuthenticationError
+		authz     *cloudflare.AuthorizationError
+		request   *cloudflare.RequestError
+	)
+	switch {
+	case errors.As(err, &rateLimit):
+		return fmt.Errorf("%w: %w", provider.ErrRateLimited, err)
+	case errors.As(err, &notFound):
+		return fmt.Errorf("%w: %w", provider.ErrNotFound, err)
+	case errors.As(err, &authn), errors.As(err, &authz):
+		return fmt.Errorf("%w: %w", provider.ErrPermission, err)
+	case errors.As(err, &request):
+		for _, code := range []int{codeRecordExists, codeDuplicateRecord} {
+			if request.InternalErrorCodeIs(code) {
+				return fmt.Errorf("%w: %w", provider.ErrExists, err)
+			}
+		}
+		for _, code := range []int{codeRecordConflict, codeCNAMEConflict} {
+			if request.InternalErrorCodeIs(code) {
+				return fmt.Errorf("%w: %w", provider.ErrConflict, err)
+			}
+		}
+	}
+	return err
+}
+
+// fail records a failed request and returns the classified error
+func (p *CloudflareProvider) fail(operation, zone string, err error) error {
+	err = classify(err)
+	p.metrics.IncDNSRequest(operation, zone, false)
+	p.metrics.IncDNSError(operation, zone, provider.ErrorClass(err))
+	return err
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	redacted         = "REDACTED"
+	maxDebugBodySize = 4096
+)
+
+// secretHeaders carry credentials and are never logged
+var secretHeaders = []string{"Authorization", "X-Auth-Key", "X-Auth-Email", "X-Auth-User-Service-Key", "Cookie", "Set-Cookie"}
+
+// secretFields matches json string fields whose names suggest credentials
+var secretFields = regexp.MustCompile(`(?i)("[a-z_]*(token|secret|password|api_?key)[a-z_]*"\s*:\s*)"[^"]*"`)
+
+type debugTransport struct {
+	next    http.RoundTripper
+	bodies  bool
+	secrets []string
+}
+
+// NewDebugTransport wraps next, logging the method, url, headers, status,
+// latency and rate limit headers of every request. With bodies set, request
+// and response bodies are logged too. Credential headers, credential json
+// fields and any of secrets are redacted.
+func NewDebugTransport(next http.RoundTripper, bodies bool, secrets ...string) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	nonEmpty := []string{}
+	for _, s := range secrets {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return &debugTransport{next: next, bodies: bodies, secrets: nonEmpty}
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attrs := []any{"method", req.Method, "url", t.redact(req.URL.String()), "headers", redactHeaders(req.Header)}
+	if t.bodies && req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		attrs = append(attrs, "request_body", t.redactBody(body))
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	attrs = append(attrs, "latency", time.Since(start))
+	if err != nil {
+		slog.Info("Provider request failed", append(attrs, "error", t.redact(err.Error()))...)
+		return resp, err
+	}
+
+	attrs = append(attrs, "status", resp.StatusCode)
+	for name, values := range resp.Header {
+		if isRateLimitHeader(name) {
+			attrs = append(attrs, strings.ToLower(name), strings.Join(values, ","))
+		}
+	}
+	if t.bodies && resp.Body != nil {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		attrs = append(attrs, "response_body", t.redactBody(body))
+	}
+	slog.Info("Provider request", attrs...)
+	return resp, nil
+}
+
+// redactHeaders returns a copy of h with credential headers redacted
+func redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range secretHeaders {
+		if out.Get(name) != "" {
+			out.Set(name, redacted)
+		}
+	}
+	return out
+}
+
+func (t *debugTransport) redact(s string) string {
+	for _, secret := range t.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+func (t *debugTransport) redactBody(body []byte) string {
+	s := string(body)
+	if len(s) > maxDebugBodySize {
+		s = s[:maxDebugBodySize] + "...(truncated)"
+	}
+	s = secretFields.ReplaceAllString(s, `$1"`+redacted+`"`)
+	return t.redact(s)
+}
+
+func isRateLimitHeader(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "ratelimit") || name == "retry-after"
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDebugTransport(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		if string(body) != `{"name":"app","api_token":"abc"}` {
+			t.Errorf("Expected request body passed on intact, got %s", body)
+		}
+		header := http.Header{}
+		header.Set("Retry-After", "30")
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     header,
+			Body:       io.NopCloser(strings.NewReader(`{"errors":[{"message":"rate limited for sekrit-token"}]}`)),
+		}, nil
+	})
+	client := &http.Client{Transport: NewDebugTransport(next, true, "sekrit-token")}
+
+	req, _ := http.NewRequest("POST", "https://api.example.com/zones?key=sekrit-token", strings.NewReader(`{"name":"app","api_token":"abc"}`))
+	req.Header.Set("Authorization", "Bearer sekrit-token")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "rate limited") {
+		t.Errorf("Expected response body passed on intact, got %s", body)
+	}
+
+	out := logs.String()
+	if strings.Contains(out, "sekrit-token") || strings.Contains(out, `\"abc\"`) {
+		t.Errorf("Expected secrets redacted, got %s", out)
+	}
+	for _, want := range []string{"method=POST", "status=429", "retry-after=30", "REDACTED", "latency="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected log to contain %q, got %s", want, out)
+		}
+	}
+}
+
+func TestDebugTransportWithoutBodies(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"result":[]}`))}, nil
+	})
+	client := &http.Client{Transport: NewDebugTransport(next, false)}
+	if _, err := client.Get("https://api.example.com/zones"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(logs.String(), "response_body") {
+		t.Errorf("Expected no bodies logged, got %s", logs.String())
+	}
+}
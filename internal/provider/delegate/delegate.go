@@ -0,0 +1,134 @@
+// Package delegate sends the requests of each zone to the provider hosting
+// it, so a subzone delegated to another dns server, e.g. a homelab subzone
+// served at home, is managed alongside its parent zone.
+package delegate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// ZoneProvider is a provider able to list the zones it manages
+type ZoneProvider interface {
+	provider.Provider
+	Zones() []string
+}
+
+// Provider routes requests by zone. It implements the optional provider
+// interfaces, comments and batches only when every provider supports them.
+type Provider struct {
+	parent    ZoneProvider
+	delegated map[string]ZoneProvider // by subzone
+	zones     []string
+}
+
+// New routes the requests of every subzone in delegated to its provider and
+// those of any other zone to parent
+func New(parent ZoneProvider, delegated map[string]ZoneProvider) *Provider {
+	zones := slices.Clone(parent.Zones())
+	subzones := make([]string, 0, len(delegated))
+	for zone := range delegated {
+		subzones = append(subzones, zone)
+	}
+	slices.Sort(subzones)
+	for _, zone := range subzones {
+		if !slices.Contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	return &Provider{parent: parent, delegated: delegated, zones: zones}
+}
+
+// Zones returns the zones of the parent provider and the delegated subzones
+func (p *Provider) Zones() []string {
+	return p.zones
+}
+
+// For returns the provider hosting zone
+func (p *Provider) For(zone string) provider.Provider {
+	if zp, ok := p.delegated[zone]; ok {
+		return zp
+	}
+	return p.parent
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	return p.For(zone).GetRecords(ctx, zone)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	return p.For(zone).CreateRecord(ctx, zone, record)
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	return p.For(zone).UpdateRecord(ctx, zone, record)
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	return p.For(zone).DeleteRecord(ctx, zone, record)
+}
+
+// FindRecords lists the records of a name, through the zone listing when the
+// provider of the zone cannot look names up
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	next := p.For(zone)
+	if finder, ok := next.(provider.Finder); ok {
+		return finder.FindRecords(ctx, zone, name, recordType)
+	}
+	records, err := next.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	fqdn := provider.FQDN(name, zone)
+	found := []provider.Record{}
+	for _, r := range records {
+		if r.Type == recordType && provider.FQDN(r.Name, zone) == fqdn {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+func (p *Provider) SupportsComments() bool {
+	for _, next := range p.all() {
+		if c, ok := next.(provider.Commenter); !ok || !c.SupportsComments() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	if resolver, ok := p.For(zone).(provider.IDResolver); ok {
+		return resolver.RecordID(zone, record)
+	}
+	return "", false
+}
+
+func (p *Provider) SupportsBatch() bool {
+	for _, next := range p.all() {
+		if b, ok := next.(provider.Batcher); !ok || !b.SupportsBatch() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Provider) ApplyChanges(ctx context.Context, zone string, changes []provider.Change) error {
+	b, ok := p.For(zone).(provider.Batcher)
+	if !ok {
+		return fmt.Errorf("provider of zone %s does not batch changes", zone)
+	}
+	return b.ApplyChanges(ctx, zone, changes)
+}
+
+func (p *Provider) all() []provider.Provider {
+	all := []provider.Provider{p.parent}
+	for _, next := range p.delegated {
+		all = append(all, next)
+	}
+	return all
+}
@@ -0,0 +1,42 @@
+package delegate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/memory"
+)
+
+func TestProvider(t *testing.T) {
+	parent := memory.New(map[string][]provider.Record{"example.net": {{Name: "www", Type: "A", Data: "203.0.113.1"}}})
+	lab := memory.New(nil)
+	p := New(parent, map[string]ZoneProvider{"lab.example.com": lab})
+
+	if got, want := p.Zones(), []string{"example.net", "lab.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Zones() = %v, want %v", got, want)
+	}
+
+	ctx := context.Background()
+	if err := p.CreateRecord(ctx, "lab.example.com", provider.Record{Name: "nas", Type: "A", Data: "10.0.0.1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.CreateRecord(ctx, "example.com", provider.Record{Name: "app", Type: "A", Data: "203.0.113.10"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if records, _ := lab.GetRecords(ctx, "lab.example.com"); len(records) != 1 || records[0].Name != "nas.lab.example.com" {
+		t.Errorf("Expected the subzone record at the delegated provider, got %+v", records)
+	}
+	if records, _ := parent.GetRecords(ctx, "example.com"); len(records) != 1 || records[0].Name != "app.example.com" {
+		t.Errorf("Expected the parent zone record at the parent provider, got %+v", records)
+	}
+
+	found, err := p.FindRecords(ctx, "lab.example.com", "nas.lab.example.com", "A")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(found) != 1 {
+		t.Errorf("Expected the subzone record found, got %+v", found)
+	}
+}
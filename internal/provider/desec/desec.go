@@ -0,0 +1,458 @@
+// Package desec manages records through the desec.io api. deSEC stores rrsets,
+// all records of a name and type, and writes whole rrsets, so changes are
+// applied as a single bulk request per batch to stay within its tight write
+// quotas.
+package desec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+const (
+	defaultBaseURL = "https://desec.io/api/v1"
+	minTTL         = 3600 // deSEC refuses shorter ttls by default
+	maxRetries     = 3
+	// maxRetryAfter is the longest Retry-After waited out in a request, longer
+	// waits are left to the engine as a rate limit error
+	maxRetryAfter = 30 * time.Second
+)
+
+type Provider struct {
+	baseURL   string
+	token     string
+	userAgent string
+	client    *http.Client
+	metrics   *metrics.Metrics
+	zones     []string
+	ids       *provider.IDCache
+	sleep     func(ctx context.Context, d time.Duration) error
+}
+
+// New returns a provider for the configured zones, or every zone of the
+// account when none are configured and autoDiscoverZones is set
+func New(cfg config.DNS, metrics *metrics.Metrics) (*Provider, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("desec token required")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Debug || cfg.DebugBodies {
+		client.Transport = provider.NewDebugTransport(http.DefaultTransport, cfg.DebugBodies, cfg.Token)
+	}
+	p := &Provider{
+		baseURL:   defaultBaseURL,
+		token:     cfg.Token,
+		userAgent: cfg.UserAgent,
+		client:    client,
+		metrics:   metrics,
+		zones:     cfg.Zones,
+		ids:       provider.NewIDCache(),
+		sleep:     sleep,
+	}
+	if len(cfg.Zones) == 0 && cfg.AutoDiscoverZones {
+		zones, err := p.listDomains(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover zones: %w", err)
+		}
+		p.zones = zones
+		slog.Info("Discovered DNS zones", "count", len(zones))
+	}
+	return p, nil
+}
+
+// Zones returns the names of all zones known to the provider
+func (p *Provider) Zones() []string {
+	zones := append([]string{}, p.zones...)
+	sort.Strings(zones)
+	return zones
+}
+
+// SupportsBatch reports that changes can be applied in one bulk request
+func (p *Provider) SupportsBatch() bool {
+	return true
+}
+
+type rrset struct {
+	Subname string   `json:"subname"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl,omitempty"`
+	Records []string `json:"records"`
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	slog.InfoContext(ctx, "Getting DNS records", "zone", zone)
+	start := time.Now()
+
+	rrsets, err := p.listRRsets(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS records: %w", p.fail("read", zone, err))
+	}
+	result := []provider.Record{}
+	for _, set := range rrsets {
+		result = append(result, toRecords(set, zone)...)
+	}
+
+	p.ids.Reset(zone, result)
+	p.metrics.IncDNSRequest("read", zone, true)
+	slog.DebugContext(ctx, "Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
+	return result, nil
+}
+
+// RecordID returns the id of a record created or listed by the provider. deSEC
+// has no record ids, a record's id is its value within the rrset.
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	return p.ids.Get(zone, record)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	if err := p.apply(ctx, zone, []provider.Change{{Op: "create", Record: record}}); err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", p.fail("create", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("create", zone, true)
+	slog.DebugContext(ctx, "Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	if err := p.apply(ctx, zone, []provider.Change{{Op: "update", Record: record}}); err != nil {
+		return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("update", zone, true)
+	slog.DebugContext(ctx, "Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
+	start := time.Now()
+
+	if err := p.apply(ctx, zone, []provider.Change{{Op: "delete", Record: record}}); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("delete", zone, true)
+	slog.DebugContext(ctx, "Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+// ApplyChanges writes every rrset touched by changes in one bulk request,
+// which deSEC applies all or none
+func (p *Provider) ApplyChanges(ctx context.Context, zone string, changes []provider.Change) error {
+	slog.InfoContext(ctx, "Applying DNS record changes", "zone", zone, "count", len(changes))
+	start := time.Now()
+
+	if err := p.apply(ctx, zone, changes); err != nil {
+		return fmt.Errorf("failed to apply DNS record changes: %w", p.fail("batch", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("batch", zone, true)
+	slog.DebugContext(ctx, "Applied DNS record changes", "zone", zone, "count", len(changes), "duration", time.Since(start))
+	return nil
+}
+
+// FindRecords reads the rrset of a single name and type
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	set, err := p.getRRset(ctx, zone, provider.RelativeName(name, zone), recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find DNS records: %w", p.fail("read", zone, err))
+	}
+	p.metrics.IncDNSRequest("read", zone, true)
+
+	records := toRecords(set, zone)
+	for _, r := range records {
+		p.ids.Set(zone, r, r.ID)
+	}
+	return records, nil
+}
+
+// apply reads the rrsets the changes touch, applies the changes to them and
+// writes them back in a single request
+func (p *Provider) apply(ctx context.Context, zone string, changes []provider.Change) error {
+	type key struct{ subname, recordType string }
+	sets := map[key]*rrset{}
+	order := []key{}
+	for _, c := range changes {
+		k := key{provider.RelativeName(c.Record.Name, zone), c.Record.Type}
+		if _, ok := sets[k]; ok {
+			continue
+		}
+		set, err := p.getRRset(ctx, zone, k.subname, k.recordType)
+		if err != nil {
+			return err
+		}
+		set.Subname, set.Type = k.subname, k.recordType
+		sets[k] = &set
+		order = append(order, k)
+	}
+
+	for _, c := range changes {
+		set := sets[key{provider.RelativeName(c.Record.Name, zone), c.Record.Type}]
+		if err := applyChange(set, c); err != nil {
+			return fmt.Errorf("%s %s %s in zone %s: %w", c.Op, c.Record.Type, c.Record.Name, zone, err)
+		}
+	}
+
+	body := make([]rrset, 0, len(order))
+	for _, k := range order {
+		body = append(body, *sets[k])
+	}
+	if err := p.do(ctx, http.MethodPatch, "/domains/"+zone+"/rrsets/", body, nil); err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		switch c.Op {
+		case "delete":
+			p.ids.Remove(zone, c.Record)
+		default:
+			p.ids.Set(zone, c.Record, value(c.Record))
+		}
+	}
+	return nil
+}
+
+// applyChange changes the records of an rrset, an rrset left without records
+// is deleted when written
+func applyChange(set *rrset, c provider.Change) error {
+	v := value(c.Record)
+	switch c.Op {
+	case "create":
+		if slices.Contains(set.Records, v) {
+			return provider.ErrExists
+		}
+		set.Records = append(set.Records, v)
+	case "update":
+		// Without an id the record to replace is the first of the rrset
+		i := 0
+		if c.Record.ID != "" {
+			i = slices.Index(set.Records, c.Record.ID)
+		}
+		if i < 0 || len(set.Records) == 0 {
+			return provider.ErrNotFound
+		}
+		set.Records[i] = v
+	case "delete":
+		i := slices.IndexFunc(set.Records, func(r string) bool {
+			return r == c.Record.ID || provider.SameValue(provider.Record{Type: set.Type, Data: r}, c.Record)
+		})
+		if i < 0 {
+			return provider.ErrNotFound
+		}
+		set.Records = slices.Delete(set.Records, i, i+1)
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %s", c.Op)
+	}
+	set.TTL = max(int(c.Record.TTL.Seconds()), minTTL)
+	return nil
+}
+
+// getRRset reads the rrset of a name and type, empty if it does not exist
+func (p *Provider) getRRset(ctx context.Context, zone, subname, recordType string) (rrset, error) {
+	set := rrset{}
+	if subname == "" {
+		subname = "@"
+	}
+	err := p.do(ctx, http.MethodGet, "/domains/"+zone+"/rrsets/"+url.PathEscape(subname)+"/"+recordType+"/", nil, &set)
+	if provider.ErrorClass(err) == provider.ClassNotFound {
+		return rrset{}, nil
+	}
+	return set, err
+}
+
+// listRRsets reads every page of a zone's rrsets. Pages are only returned when
+// a cursor is passed, the next cursor comes in the Link header.
+func (p *Provider) listRRsets(ctx context.Context, zone string) ([]rrset, error) {
+	all := []rrset{}
+	cursor := ""
+	for {
+		var page []rrset
+		header, err := p.doHeader(ctx, http.MethodGet, "/domains/"+zone+"/rrsets/?cursor="+url.QueryEscape(cursor), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		cursor = nextCursor(header.Get("Link"))
+		if cursor == "" {
+			return all, nil
+		}
+	}
+}
+
+func (p *Provider) listDomains(ctx context.Context) ([]string, error) {
+	var domains []struct {
+		Name string `json:"name"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/domains/", nil, &domains); err != nil {
+		return nil, err
+	}
+	zones := make([]string, 0, len(domains))
+	for _, d := range domains {
+		zones = append(zones, d.Name)
+	}
+	return zones, nil
+}
+
+// nextCursor returns the cursor of the rel="next" url of a Link header
+func nextCursor(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		u, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return ""
+		}
+		return u.Query().Get("cursor")
+	}
+	return ""
+}
+
+func (p *Provider) do(ctx context.Context, method, path string, body, out any) error {
+	_, err := p.doHeader(ctx, method, path, body, out)
+	return err
+}
+
+// doHeader sends body as json and decodes the response into out, if not nil.
+// Throttled requests are retried once the Retry-After delay has passed, as
+// long as it is short.
+func (p *Provider) doHeader(ctx context.Context, method, path string, body, out any) (http.Header, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Token "+p.token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if p.userAgent != "" {
+			req.Header.Set("User-Agent", p.userAgent)
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxRetries {
+			wait := retryAfter(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			if wait <= maxRetryAfter {
+				slog.WarnContext(ctx, "Throttled by deSEC, retrying", "path", path, "attempt", attempt+1, "retryAfter", wait)
+				if err := p.sleep(ctx, wait); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, &provider.HTTPError{Provider: "desec", Status: http.StatusTooManyRequests, Message: fmt.Sprintf("retry after %s", wait)}
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			var apiErr struct {
+				Detail string `json:"detail"`
+			}
+			raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			if json.Unmarshal(raw, &apiErr) != nil || apiErr.Detail == "" {
+				// Validation errors come as objects or lists of field errors
+				apiErr.Detail = strings.TrimSpace(string(raw))
+			}
+			return nil, &provider.HTTPError{Provider: "desec", Status: resp.StatusCode, Message: apiErr.Detail}
+		}
+		if out == nil {
+			return resp.Header, nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+		return resp.Header, nil
+	}
+}
+
+// retryAfter parses a Retry-After header in seconds, one second if missing
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || seconds < 1 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// value returns record data as deSEC stores it, TXT quoted and names with a
+// trailing dot
+func value(r provider.Record) string {
+	switch r.Type {
+	case "TXT":
+		return provider.QuoteTXT(provider.NormalizeTXT(r.Data))
+	case "CNAME", "NS", "MX", "SRV":
+		return strings.TrimSuffix(r.Data, ".") + "."
+	}
+	return r.Data
+}
+
+// toRecords returns a record per value of an rrset, with the value as id
+func toRecords(set rrset, zone string) []provider.Record {
+	records := make([]provider.Record, 0, len(set.Records))
+	for _, v := range set.Records {
+		data := v
+		if set.Type != "TXT" {
+			data = strings.TrimSuffix(v, ".")
+		}
+		records = append(records, provider.Record{
+			ID:   v,
+			Name: provider.FQDN(set.Subname, zone),
+			Type: set.Type,
+			Data: data,
+			TTL:  time.Duration(set.TTL) * time.Second,
+			Zone: zone,
+		})
+	}
+	return records
+}
+
+// fail records a failed request and returns the error
+func (p *Provider) fail(operation, zone string, err error) error {
+	p.metrics.IncDNSRequest(operation, zone, false)
+	p.metrics.IncDNSError(operation, zone, provider.ErrorClass(err))
+	return err
+}
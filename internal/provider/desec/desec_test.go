@@ -0,0 +1,201 @@
+package desec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/providertest"
+)
+
+// fakeAPI serves the deSEC rrset endpoints of a single domain, listing two
+// rrsets a page, and throttles the next throttle requests
+type fakeAPI struct {
+	mu       sync.Mutex
+	rrsets   []rrset
+	patches  int
+	throttle int
+	wait     string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fail := func(status int, detail string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"detail": detail})
+	}
+	if r.Header.Get("Authorization") != "Token token" {
+		fail(http.StatusUnauthorized, "Invalid token.")
+		return
+	}
+	if f.throttle > 0 {
+		f.throttle--
+		w.Header().Set("Retry-After", f.wait)
+		fail(http.StatusTooManyRequests, "Request was throttled.")
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/domains/example.com/rrsets/")
+	if !ok {
+		fail(http.StatusNotFound, "Not found.")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		end := min(start+2, len(f.rrsets))
+		if end < len(f.rrsets) {
+			w.Header().Set("Link", fmt.Sprintf(`<http://desec.test/api/v1/domains/example.com/rrsets/?cursor=%d>; rel="next"`, end))
+		}
+		json.NewEncoder(w).Encode(f.rrsets[start:end])
+	case r.Method == http.MethodGet:
+		subname, recordType, _ := strings.Cut(strings.TrimSuffix(path, "/"), "/")
+		if subname == "@" {
+			subname = ""
+		}
+		for _, set := range f.rrsets {
+			if set.Subname == subname && set.Type == recordType {
+				json.NewEncoder(w).Encode(set)
+				return
+			}
+		}
+		fail(http.StatusNotFound, "Not found.")
+	case r.Method == http.MethodPatch && path == "":
+		f.patches++
+		var sets []rrset
+		json.NewDecoder(r.Body).Decode(&sets)
+		for _, set := range sets {
+			for _, v := range set.Records {
+				if set.Type == "TXT" && !strings.HasPrefix(v, `"`) {
+					fail(http.StatusBadRequest, "TXT records must be quoted")
+					return
+				}
+			}
+			if set.TTL != 0 && set.TTL < minTTL {
+				fail(http.StatusBadRequest, "TTL too low")
+				return
+			}
+		}
+		for _, set := range sets {
+			kept := []rrset{}
+			for _, existing := range f.rrsets {
+				if existing.Subname != set.Subname || existing.Type != set.Type {
+					kept = append(kept, existing)
+				}
+			}
+			if len(set.Records) > 0 {
+				kept = append(kept, set)
+			}
+			f.rrsets = kept
+		}
+		json.NewEncoder(w).Encode(sets)
+	default:
+		fail(http.StatusMethodNotAllowed, "Method not allowed.")
+	}
+}
+
+func newTestProvider(t *testing.T, api http.Handler, token string) *Provider {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	p, err := New(config.DNS{Token: token, Zones: []string{"example.com"}}, metrics.New(false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.baseURL = srv.URL
+	p.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	return p
+}
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, newTestProvider(t, &fakeAPI{}, "token"), "example.com")
+}
+
+func TestApplyChanges(t *testing.T) {
+	ctx := context.Background()
+	api := &fakeAPI{rrsets: []rrset{{Subname: "app", Type: "TXT", TTL: 3600, Records: []string{`"v=spf1 -all"`}}}}
+	p := newTestProvider(t, api, "token")
+
+	err := p.ApplyChanges(ctx, "example.com", []provider.Change{
+		{Op: "create", Record: provider.Record{Name: "app.example.com", Type: "A", Data: "192.0.2.1", TTL: time.Minute}},
+		{Op: "create", Record: provider.Record{Name: "app.example.com", Type: "TXT", Data: "heritage=caddy-dns-sync", TTL: time.Minute}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if api.patches != 1 {
+		t.Errorf("Expected one bulk request, got %d", api.patches)
+	}
+	records, err := p.GetRecords(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 3 {
+		t.Errorf("Expected the TXT record added next to the existing one, got %+v", records)
+	}
+
+	// A failing change fails the whole batch before anything is written
+	err = p.ApplyChanges(ctx, "example.com", []provider.Change{
+		{Op: "delete", Record: provider.Record{Name: "app.example.com", Type: "A", Data: "192.0.2.1"}},
+		{Op: "delete", Record: provider.Record{Name: "app.example.com", Type: "AAAA", Data: "2001:db8::1"}},
+	})
+	if !errors.Is(err, provider.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if api.patches != 1 {
+		t.Errorf("Expected nothing written for a failed batch, got %d requests", api.patches)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Run("short waits are retried", func(t *testing.T) {
+		api := &fakeAPI{throttle: 2, wait: "2"}
+		p := newTestProvider(t, api, "token")
+		waited := []time.Duration{}
+		p.sleep = func(ctx context.Context, d time.Duration) error {
+			waited = append(waited, d)
+			return nil
+		}
+		if _, err := p.GetRecords(context.Background(), "example.com"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(waited) != 2 || waited[0] != 2*time.Second {
+			t.Errorf("Expected two waits of the Retry-After delay, got %v", waited)
+		}
+	})
+
+	t.Run("long waits are rate limit errors", func(t *testing.T) {
+		api := &fakeAPI{throttle: 1, wait: "3600"}
+		_, err := newTestProvider(t, api, "token").GetRecords(context.Background(), "example.com")
+		if !errors.Is(err, provider.ErrRateLimited) {
+			t.Errorf("Expected ErrRateLimited, got %v", err)
+		}
+	})
+}
+
+func TestPagination(t *testing.T) {
+	api := &fakeAPI{}
+	for i := range 5 {
+		api.rrsets = append(api.rrsets, rrset{Subname: fmt.Sprintf("host%d", i), Type: "A", TTL: 3600, Records: []string{"192.0.2.1"}})
+	}
+	records, err := newTestProvider(t, api, "token").GetRecords(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 5 || records[4].Name != "host4.example.com" {
+		t.Errorf("Expected every page with fully qualified names, got %+v", records)
+	}
+}
@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Providers wrap API errors into these so callers can decide to retry, skip
+// or abort without inspecting provider specific error text
+var (
+	ErrRateLimited = errors.New("rate limited")
+	ErrNotFound    = errors.New("not found")
+	ErrPermission  = errors.New("permission denied")
+	ErrConflict    = errors.New("conflicting record")
+	// ErrExists is a create of a record the provider already holds, it is a
+	// conflict that adopting the existing record resolves
+	ErrExists = fmt.Errorf("record already exists: %w", ErrConflict)
+)
+
+// Error classes used as metric labels
+const (
+	ClassRateLimited = "rate_limited"
+	ClassNotFound    = "not_found"
+	ClassPermission  = "permission"
+	ClassConflict    = "conflict"
+	ClassExists      = "exists"
+	ClassUnknown     = "unknown"
+)
+
+// ErrorClass returns the class of a provider error
+func ErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return ClassRateLimited
+	case errors.Is(err, ErrNotFound):
+		return ClassNotFound
+	case errors.Is(err, ErrPermission):
+		return ClassPermission
+	case errors.Is(err, ErrExists):
+		return ClassExists
+	case errors.Is(err, ErrConflict):
+		return ClassConflict
+	}
+	return ClassUnknown
+}
+
+// HTTPError is an error response of a provider's http api. It unwraps to the
+// provider error matching its status, so rest providers need no classifying
+// of their own.
+type HTTPError struct {
+	Provider string
+	Status   int
+	Message  string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s api returned %d %s", e.Provider, e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("%s api returned %d %s: %s", e.Provider, e.Status, http.StatusText(e.Status), e.Message)
+}
+
+func (e *HTTPError) Unwrap() error {
+	switch e.Status {
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrPermission
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPErrorClass(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{status: http.StatusTooManyRequests, want: ClassRateLimited},
+		{status: http.StatusUnauthorized, want: ClassPermission},
+		{status: http.StatusForbidden, want: ClassPermission},
+		{status: http.StatusNotFound, want: ClassNotFound},
+		{status: http.StatusConflict, want: ClassConflict},
+		{status: http.StatusInternalServerError, want: ClassUnknown},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("failed to create DNS record: %w", &HTTPError{Provider: "test", Status: tt.status})
+		if got := ErrorClass(err); got != tt.want {
+			t.Errorf("ErrorClass(%d) = %s, want %s", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+// Package httpapi manages records through any json dns api described in
+// config. Requests are rendered from go templates and responses are read with
+// JSONPath, so small or bespoke apis can be integrated without writing go.
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// request is a parsed HTTPRequest
+type request struct {
+	method string
+	url    *template.Template
+	body   *template.Template // nil without a body
+	id     []step             // nil if the response carries no id
+}
+
+// fields are the parsed paths of record fields
+type fields struct {
+	id, name, recordType, data, ttl []step
+}
+
+type Provider struct {
+	token, secret string
+	userAgent     string
+	client        *http.Client
+	metrics       *metrics.Metrics
+	zones         []string
+	ids           *provider.IDCache
+
+	headers map[string]*template.Template
+	list    request
+	create  request
+	update  *request // nil when updates delete and create
+	delete  request
+	records []step
+	fields  fields
+}
+
+// templateData is what request templates render, with the record of the
+// request and the configured credentials
+type templateData struct {
+	Zone         string
+	Name         string // fully qualified
+	RelativeName string // relative to the zone, @ at the apex
+	Type         string
+	Data         string
+	TTL          int // seconds
+	ID           string
+	Token        string
+	Secret       string
+}
+
+var funcs = template.FuncMap{
+	// json renders a value as json, e.g. a quoted and escaped string
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"quoteTXT": provider.QuoteTXT,
+}
+
+// New returns a provider for the configured zones, with the requests of
+// cfg.HTTP parsed
+func New(cfg config.DNS, metrics *metrics.Metrics) (*Provider, error) {
+	if len(cfg.Zones) == 0 {
+		return nil, errors.New("http provider can not discover zones, configure dns.zones")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Debug || cfg.DebugBodies {
+		client.Transport = provider.NewDebugTransport(http.DefaultTransport, cfg.DebugBodies, cfg.Token, cfg.Secret)
+	}
+	p := &Provider{
+		token:     cfg.Token,
+		secret:    cfg.Secret,
+		userAgent: cfg.UserAgent,
+		client:    client,
+		metrics:   metrics,
+		zones:     cfg.Zones,
+		ids:       provider.NewIDCache(),
+		headers:   map[string]*template.Template{},
+	}
+
+	h := cfg.HTTP
+	var err error
+	for name, value := range h.Headers {
+		if p.headers[name], err = template.New(name).Funcs(funcs).Parse(value); err != nil {
+			return nil, fmt.Errorf("dns.http.headers %s: %w", name, err)
+		}
+	}
+	if p.list, err = parseRequest("list", h.List, http.MethodGet); err != nil {
+		return nil, err
+	}
+	if p.create, err = parseRequest("create", h.Create, http.MethodPost); err != nil {
+		return nil, err
+	}
+	if p.delete, err = parseRequest("delete", h.Delete, http.MethodDelete); err != nil {
+		return nil, err
+	}
+	if h.Update.URL != "" {
+		update, err := parseRequest("update", h.Update, http.MethodPut)
+		if err != nil {
+			return nil, err
+		}
+		p.update = &update
+	}
+
+	records := h.Records
+	if records == "" {
+		records = "$"
+	}
+	paths := []struct {
+		name  string
+		path  string
+		steps *[]step
+	}{
+		{"records", records, &p.records},
+		{"fields.id", h.Fields.ID, &p.fields.id},
+		{"fields.name", h.Fields.Name, &p.fields.name},
+		{"fields.type", h.Fields.Type, &p.fields.recordType},
+		{"fields.data", h.Fields.Data, &p.fields.data},
+		{"fields.ttl", h.Fields.TTL, &p.fields.ttl},
+	}
+	for _, path := range paths {
+		if path.path == "" {
+			continue
+		}
+		if *path.steps, err = parsePath(path.path); err != nil {
+			return nil, fmt.Errorf("dns.http.%s: %w", path.name, err)
+		}
+	}
+	return p, nil
+}
+
+func parseRequest(name string, cfg config.HTTPRequest, method string) (request, error) {
+	r := request{method: method}
+	if cfg.Method != "" {
+		r.method = strings.ToUpper(cfg.Method)
+	}
+	var err error
+	if r.url, err = template.New(name + ".url").Funcs(funcs).Parse(cfg.URL); err != nil {
+		return r, fmt.Errorf("dns.http.%s.url: %w", name, err)
+	}
+	if cfg.Body != "" {
+		if r.body, err = template.New(name + ".body").Funcs(funcs).Parse(cfg.Body); err != nil {
+			return r, fmt.Errorf("dns.http.%s.body: %w", name, err)
+		}
+	}
+	if cfg.ID != "" {
+		if r.id, err = parsePath(cfg.ID); err != nil {
+			return r, fmt.Errorf("dns.http.%s.id: %w", name, err)
+		}
+	}
+	return r, nil
+}
+
+// Zones returns the names of all zones known to the provider
+func (p *Provider) Zones() []string {
+	zones := append([]string{}, p.zones...)
+	sort.Strings(zones)
+	return zones
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	slog.InfoContext(ctx, "Getting DNS records", "zone", zone)
+	start := time.Now()
+
+	result, err := p.listRecords(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS records: %w", p.fail("read", zone, err))
+	}
+
+	p.ids.Reset(zone, result)
+	p.metrics.IncDNSRequest("read", zone, true)
+	slog.DebugContext(ctx, "Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
+	return result, nil
+}
+
+// RecordID returns the id of a record created or listed by the provider
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	return p.ids.Get(zone, record)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	if err := p.createRecord(ctx, zone, record); err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", p.fail("create", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("create", zone, true)
+	slog.DebugContext(ctx, "Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	// Without an id the record to replace is the one at the name and type
+	current := record
+	if record.ID == "" {
+		found, err := p.findRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("failed to update DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+		current = found[0]
+		record.ID = current.ID
+	}
+
+	var err error
+	if p.update != nil {
+		_, err = p.send(ctx, *p.update, zone, record)
+		if err == nil {
+			p.ids.Set(zone, record, record.ID)
+		}
+	} else {
+		// Without an update request the record is replaced, the new one gets
+		// its own id
+		err = p.deleteRecord(ctx, zone, current)
+		if err == nil {
+			record.ID = ""
+			err = p.createRecord(ctx, zone, record)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("update", zone, true)
+	slog.DebugContext(ctx, "Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
+	start := time.Now()
+
+	if err := p.deleteRecord(ctx, zone, record); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("delete", zone, true)
+	slog.DebugContext(ctx, "Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) createRecord(ctx context.Context, zone string, record provider.Record) error {
+	resp, err := p.send(ctx, p.create, zone, record)
+	if err != nil {
+		return err
+	}
+	if p.create.id != nil {
+		if id := scalar(p.create.id, resp); id != "" {
+			p.ids.Set(zone, record, id)
+		}
+	}
+	return nil
+}
+
+// deleteRecord deletes a record by id when the api has ids, using the cached
+// one or looking it up for records created during this run
+func (p *Provider) deleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	if record.ID == "" {
+		record.ID, _ = p.ids.Get(zone, record)
+	}
+	if record.ID == "" && p.fields.id != nil {
+		found, err := p.findRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return err
+		}
+		for _, r := range found {
+			if provider.SameValue(r, record) {
+				record.ID = r.ID
+				break
+			}
+		}
+		if record.ID == "" {
+			return fmt.Errorf("%s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+	}
+	if _, err := p.send(ctx, p.delete, zone, record); err != nil {
+		return err
+	}
+	p.ids.Remove(zone, record)
+	return nil
+}
+
+// findRecords lists the zone and keeps the records of a name and type, the
+// api is not assumed to look names up
+func (p *Provider) findRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	records, err := p.listRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	fqdn := provider.FQDN(name, zone)
+	found := []provider.Record{}
+	for _, r := range records {
+		if r.Type == recordType && r.Name == fqdn {
+			p.ids.Set(zone, r, r.ID)
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+func (p *Provider) listRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	doc, err := p.send(ctx, p.list, zone, provider.Record{})
+	if err != nil {
+		return nil, err
+	}
+	items := evaluate(p.records, doc)
+	// A path selecting the list itself stands for its elements
+	if len(items) == 1 {
+		if list, ok := items[0].([]any); ok {
+			items = list
+		}
+	}
+	records := []provider.Record{}
+	for _, item := range items {
+		r := provider.Record{
+			Name: provider.FQDN(strings.TrimSuffix(scalar(p.fields.name, item), "."), zone),
+			Type: strings.ToUpper(scalar(p.fields.recordType, item)),
+			Data: scalar(p.fields.data, item),
+			Zone: zone,
+		}
+		if p.fields.id != nil {
+			r.ID = scalar(p.fields.id, item)
+		}
+		if p.fields.ttl != nil {
+			ttl, _ := strconv.Atoi(scalar(p.fields.ttl, item))
+			r.TTL = time.Duration(ttl) * time.Second
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// send renders and sends a request for a record and returns the decoded
+// response, nil if it is empty
+func (p *Provider) send(ctx context.Context, r request, zone string, record provider.Record) (any, error) {
+	data := templateData{
+		Zone:   zone,
+		Type:   record.Type,
+		Data:   record.Data,
+		TTL:    int(record.TTL.Seconds()),
+		ID:     record.ID,
+		Token:  p.token,
+		Secret: p.secret,
+	}
+	if record.Name != "" {
+		data.Name = provider.FQDN(record.Name, zone)
+		data.RelativeName = provider.RelativeName(record.Name, zone)
+		if data.RelativeName == "" {
+			data.RelativeName = "@"
+		}
+	}
+
+	url, err := render(r.url, data)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if r.body != nil {
+		rendered, err := render(r.body, data)
+		if err != nil {
+			return nil, err
+		}
+		body = strings.NewReader(rendered)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+	for name, tmpl := range p.headers {
+		value, err := render(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message := strings.TrimSpace(string(raw))
+		if len(message) > 200 {
+			message = message[:200]
+		}
+		return nil, &provider.HTTPError{Provider: "http", Status: resp.StatusCode, Message: message}
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return doc, nil
+}
+
+func render(t *template.Template, data templateData) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render %s: %w", t.Name(), err)
+	}
+	return b.String(), nil
+}
+
+// fail records a failed request and returns the error
+func (p *Provider) fail(operation, zone string, err error) error {
+	p.metrics.IncDNSRequest(operation, zone, false)
+	p.metrics.IncDNSError(operation, zone, provider.ErrorClass(err))
+	return err
+}
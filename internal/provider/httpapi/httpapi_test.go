@@ -0,0 +1,154 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/providertest"
+)
+
+type apiRecord struct {
+	ID      int    `json:"id"`
+	Host    string `json:"host"`
+	Kind    string `json:"kind"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// fakeAPI is a small bespoke dns api nesting its records in a response
+// envelope, without an update endpoint
+type fakeAPI struct {
+	mu      sync.Mutex
+	records []apiRecord
+	nextID  int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("X-Api-Key") != "key" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/zones/example.com/records")
+	id = strings.TrimPrefix(id, "/")
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"items": f.records}})
+	case r.Method == http.MethodPost && id == "":
+		var body apiRecord
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.nextID++
+		body.ID = f.nextID
+		f.records = append(f.records, body)
+		json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"id": body.ID}})
+	case r.Method == http.MethodDelete:
+		for i, rec := range f.records {
+			if fmt.Sprint(rec.ID) == id {
+				f.records = append(f.records[:i], f.records[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestProvider(t *testing.T, api http.Handler, token string) *Provider {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	cfg := config.DNS{
+		Token: token,
+		Zones: []string{"example.com"},
+		HTTP: config.HTTPProvider{
+			Headers: map[string]string{"X-Api-Key": "{{.Token}}"},
+			List:    config.HTTPRequest{URL: srv.URL + "/zones/{{.Zone}}/records"},
+			Create: config.HTTPRequest{
+				URL:  srv.URL + "/zones/{{.Zone}}/records",
+				Body: `{"host": {{json .RelativeName}}, "kind": {{json .Type}}, "content": {{json .Data}}, "ttl": {{.TTL}}}`,
+				ID:   "$.result.id",
+			},
+			Delete:  config.HTTPRequest{URL: srv.URL + "/zones/{{.Zone}}/records/{{.ID}}"},
+			Records: "$.result.items[*]",
+			Fields:  config.HTTPFields{ID: "$.id", Name: "$.host", Type: "$.kind", Data: "$.content", TTL: "$.ttl"},
+		},
+	}
+	p, err := New(cfg, metrics.New(false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return p
+}
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, newTestProvider(t, &fakeAPI{}, "key"), "example.com")
+}
+
+func TestTemplates(t *testing.T) {
+	api := &fakeAPI{}
+	p := newTestProvider(t, api, "key")
+	record := provider.Record{Name: "@", Type: "TXT", Data: `v=spf1 include:"example.net" -all`, TTL: time.Hour}
+	if err := p.CreateRecord(context.Background(), "example.com", record); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := apiRecord{ID: 1, Host: "@", Kind: "TXT", Content: record.Data, TTL: 3600}
+	if len(api.records) != 1 || api.records[0] != want {
+		t.Errorf("Expected %+v sent with json escaping, got %+v", want, api.records)
+	}
+}
+
+func TestPermissionError(t *testing.T) {
+	_, err := newTestProvider(t, &fakeAPI{}, "wrong").GetRecords(context.Background(), "example.com")
+	if !errors.Is(err, provider.ErrPermission) {
+		t.Errorf("Expected permission error for a bad key, got %v", err)
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	var doc any
+	json.Unmarshal([]byte(`{"data": {"records": [{"name": "a", "ttl": 60}, {"name": "b", "ttl": 120}]}, "my key": "x"}`), &doc)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "$.data.records[*].name", want: "[a b]"},
+		{path: "$.data.records[1].ttl", want: "[120]"},
+		{path: "$.data.records[-1].name", want: "[b]"},
+		{path: "$['my key']", want: "[x]"},
+		{path: "$.data.missing", want: "[]"},
+		{path: "$.data.records[5]", want: "[]"},
+	}
+	for _, tt := range tests {
+		steps, err := parsePath(tt.path)
+		if err != nil {
+			t.Fatalf("parsePath(%q) error: %v", tt.path, err)
+		}
+		if got := fmt.Sprint(evaluate(steps, doc)); got != tt.want {
+			t.Errorf("evaluate(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	for _, path := range []string{"data.records", "$.data[", "$.data[x]", "$..name"} {
+		if _, err := parsePath(path); err == nil {
+			t.Errorf("Expected parsePath(%q) to fail", path)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// step is one segment of a JSONPath, a key, an index or a wildcard
+type step struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parsePath parses the JSONPath subset the provider supports: $ for the root,
+// .key and ['key'] for object members, [n] for array elements and [*] or .*
+// for every element
+func parsePath(path string) ([]step, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(path), "$")
+	if !ok {
+		return nil, fmt.Errorf("jsonpath %q must start with $", path)
+	}
+	steps := []step{}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if key == "" {
+				return nil, fmt.Errorf("jsonpath %q has an empty key", path)
+			}
+			steps = append(steps, step{key: key, wildcard: key == "*"})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("jsonpath %q has an unclosed [", path)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, step{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, step{key: inner[1 : len(inner)-1]})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("jsonpath %q has invalid index %q", path, inner)
+				}
+				steps = append(steps, step{index: i, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("jsonpath %q is invalid at %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+// evaluate returns every value the path selects in a decoded json document.
+// Members and elements that do not exist select nothing.
+func evaluate(steps []step, doc any) []any {
+	values := []any{doc}
+	for _, s := range steps {
+		next := []any{}
+		for _, v := range values {
+			switch v := v.(type) {
+			case map[string]any:
+				if s.wildcard {
+					for _, member := range v {
+						next = append(next, member)
+					}
+				} else if member, ok := v[s.key]; ok && !s.isIndex {
+					next = append(next, member)
+				}
+			case []any:
+				switch {
+				case s.wildcard:
+					next = append(next, v...)
+				case s.isIndex && s.index < 0 && -s.index <= len(v):
+					next = append(next, v[len(v)+s.index])
+				case s.isIndex && s.index >= 0 && s.index < len(v):
+					next = append(next, v[s.index])
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// scalar returns the first value a path selects as a string, empty if none
+func scalar(steps []step, doc any) string {
+	values := evaluate(steps, doc)
+	if len(values) == 0 || values[0] == nil {
+		return ""
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(values[0])
+}
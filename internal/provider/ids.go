@@ -0,0 +1,58 @@
+package provider
+
+import "sync"
+
+// IDCache remembers record IDs seen in listings and returned on create, so
+// deletes go by ID instead of matching content, which breaks on TXT quoting
+type IDCache struct {
+	mu  sync.Mutex
+	ids map[string]string // zone, type, fqdn and data to record ID
+}
+
+func NewIDCache() *IDCache {
+	return &IDCache{ids: make(map[string]string)}
+}
+
+func idKey(zone string, record Record) string {
+	data := record.Data
+	if record.Type == "TXT" {
+		data = NormalizeTXT(data)
+	}
+	return zone + "|" + record.Type + "|" + FQDN(record.Name, zone) + "|" + data
+}
+
+// Reset replaces the cached IDs of a zone with the listed records
+func (c *IDCache) Reset(zone string, records []Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := zone + "|"
+	for key := range c.ids {
+		if len(key) > len(prefix) && key[:len(prefix)] == prefix {
+			delete(c.ids, key)
+		}
+	}
+	for _, r := range records {
+		if r.ID != "" {
+			c.ids[idKey(zone, r)] = r.ID
+		}
+	}
+}
+
+func (c *IDCache) Set(zone string, record Record, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids[idKey(zone, record)] = id
+}
+
+func (c *IDCache) Get(zone string, record Record) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.ids[idKey(zone, record)]
+	return id, ok
+}
+
+func (c *IDCache) Remove(zone string, record Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ids, idKey(zone, record))
+}
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"testing"
+)
+
+func TestIDCache(t *testing.T) {
+	cache := NewIDCache()
+	cache.Reset("example.com", []Record{
+		{ID: "1", Name: "app.example.com", Type: "A", Data: "10.0.0.1"},
+		{ID: "2", Name: "app.example.com", Type: "TXT", Data: `"heritage=caddy-dns-sync"`},
+	})
+	cache.Reset("other.com", []Record{
+		{ID: "3", Name: "other.com", Type: "A", Data: "10.0.0.3"},
+	})
+
+	// Relative and fully qualified names share an entry, as do quoted and bare TXT
+	if id, ok := cache.Get("example.com", Record{Name: "app", Type: "TXT", Data: "heritage=caddy-dns-sync"}); !ok || id != "2" {
+		t.Errorf("Expected cached id 2, got %q", id)
+	}
+	if id, ok := cache.Get("other.com", Record{Name: "@", Type: "A", Data: "10.0.0.3"}); !ok || id != "3" {
+		t.Errorf("Expected cached id 3, got %q", id)
+	}
+
+	cache.Set("example.com", Record{Name: "new", Type: "A", Data: "10.0.0.4"}, "4")
+	if id, _ := cache.Get("example.com", Record{Name: "new.example.com", Type: "A", Data: "10.0.0.4"}); id != "4" {
+		t.Errorf("Expected cached id 4, got %q", id)
+	}
+
+	cache.Remove("example.com", Record{Name: "app", Type: "A", Data: "10.0.0.1"})
+	if _, ok := cache.Get("example.com", Record{Name: "app", Type: "A", Data: "10.0.0.1"}); ok {
+		t.Error("Expected removed id to be gone")
+	}
+
+	// Relisting a zone drops stale IDs of that zone only
+	cache.Reset("example.com", nil)
+	if _, ok := cache.Get("example.com", Record{Name: "new", Type: "A", Data: "10.0.0.4"}); ok {
+		t.Error("Expected reset to drop zone ids")
+	}
+	if _, ok := cache.Get("other.com", Record{Name: "@", Type: "A", Data: "10.0.0.3"}); !ok {
+		t.Error("Expected other zone ids to survive reset")
+	}
+}
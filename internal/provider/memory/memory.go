@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// Provider keeps records in memory, used to plan against zone snapshots
+// without a DNS provider
+type Provider struct {
+	mu      sync.Mutex
+	records map[string][]provider.Record
+	nextID  int
+}
+
+// New returns a provider holding records, keyed by zone
+func New(records map[string][]provider.Record) *Provider {
+	p := &Provider{records: make(map[string][]provider.Record)}
+	for zone, rs := range records {
+		for _, r := range rs {
+			p.add(zone, r)
+		}
+	}
+	return p
+}
+
+// Zones lists the zones the provider holds records for
+func (p *Provider) Zones() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	zones := make([]string, 0, len(p.records))
+	for zone := range p.records {
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
+// SupportsComments reports that records keep their comment
+func (p *Provider) SupportsComments() bool {
+	return true
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	records := make([]provider.Record, len(p.records[zone]))
+	copy(records, p.records[zone])
+	return records, nil
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.add(zone, record)
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.find(zone, record, false)
+	if err != nil {
+		return err
+	}
+	record.ID = p.records[zone][i].ID
+	record.Name = p.records[zone][i].Name
+	record.Zone = zone
+	p.records[zone][i] = record
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.find(zone, record, true)
+	if err != nil {
+		return err
+	}
+	p.records[zone] = append(p.records[zone][:i], p.records[zone][i+1:]...)
+	return nil
+}
+
+// add stores a record under its fqdn, as providers return them
+func (p *Provider) add(zone string, record provider.Record) {
+	p.nextID++
+	if record.ID == "" {
+		record.ID = fmt.Sprintf("%d", p.nextID)
+	}
+	record.Name = fqdn(record.Name, zone)
+	record.Zone = zone
+	p.records[zone] = append(p.records[zone], record)
+}
+
+// find locates a record by id, or by name and type when the id is unknown.
+// TXT records, and every record when byValue is set, must also hold the value
+// of record, so a delete never takes another record at the name.
+func (p *Provider) find(zone string, record provider.Record, byValue bool) (int, error) {
+	name := fqdn(record.Name, zone)
+	for i, r := range p.records[zone] {
+		if record.ID != "" && r.ID == record.ID {
+			return i, nil
+		}
+		if record.ID == "" && r.Name == name && r.Type == record.Type {
+			if (record.Type != "TXT" && !byValue) || provider.SameValue(r, record) {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+}
+
+// fqdn expands a zone relative record name
+func fqdn(name, zone string) string {
+	name = strings.TrimSuffix(name, ".")
+	switch {
+	case name == "" || name == "@":
+		return zone
+	case name == zone || strings.HasSuffix(name, "."+zone):
+		return name
+	}
+	return name + "." + zone
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/providertest"
+)
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	p := New(map[string][]provider.Record{
+		"example.com": {{Name: "app", Type: "A", Data: "10.0.0.1"}},
+	})
+
+	if err := p.CreateRecord(ctx, "example.com", provider.Record{Name: "new.example.com", Type: "TXT", Data: `"owner=a"`}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.UpdateRecord(ctx, "example.com", provider.Record{Name: "app", Type: "A", Data: "10.0.0.2"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.DeleteRecord(ctx, "example.com", provider.Record{Name: "new", Type: "TXT", Data: "owner=a"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.DeleteRecord(ctx, "example.com", provider.Record{Name: "missing", Type: "A"}); !errors.Is(err, provider.ErrNotFound) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	records, _ := p.GetRecords(ctx, "example.com")
+	if len(records) != 1 || records[0].Name != "app.example.com" || records[0].Data != "10.0.0.2" {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+}
+
+func TestProviderDeleteByValue(t *testing.T) {
+	ctx := context.Background()
+	heritage := "heritage=caddy-dns-sync,caddy-dns-sync/owner=test"
+	p := New(map[string][]provider.Record{
+		"example.com": {
+			{Name: "@", Type: "A", Data: "10.0.0.1"},
+			{Name: "@", Type: "A", Data: "10.0.0.2"},
+			{Name: "@", Type: "TXT", Data: `"v=spf1 include:_spf.google.com ~all"`},
+			{Name: "@", Type: "TXT", Data: "google-site-verification=abc"},
+			{Name: "@", Type: "TXT", Data: `"` + heritage + `"`},
+		},
+	})
+
+	if err := p.DeleteRecord(ctx, "example.com", provider.Record{Name: "@", Type: "TXT", Data: heritage}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.DeleteRecord(ctx, "example.com", provider.Record{Name: "example.com", Type: "A", Data: "10.0.0.2"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.DeleteRecord(ctx, "example.com", provider.Record{Name: "@", Type: "TXT", Data: heritage}); !errors.Is(err, provider.ErrNotFound) {
+		t.Errorf("Expected not found deleting the heritage value again, got %v", err)
+	}
+
+	records, _ := p.GetRecords(ctx, "example.com")
+	kept := []string{}
+	for _, r := range records {
+		kept = append(kept, r.Data)
+	}
+	want := []string{"10.0.0.1", `"v=spf1 include:_spf.google.com ~all"`, "google-site-verification=abc"}
+	if !slices.Equal(kept, want) {
+		t.Errorf("Kept %q, want %q", kept, want)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, New(nil), "example.com")
+}
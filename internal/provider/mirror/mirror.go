@@ -0,0 +1,324 @@
+// Package mirror writes records to a secondary provider as well as the
+// primary, so zones can be migrated between providers without downtime: the
+// secondary is filled and kept in line while the primary still serves, then
+// the zone's NS records are cut over.
+package mirror
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// Divergence is a record the secondary does not hold like the primary does
+type Divergence struct {
+	Zone      string `json:"zone"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Primary   string `json:"primary,omitempty"`   // data at the primary, empty if missing there
+	Secondary string `json:"secondary,omitempty"` // data at the secondary, empty if missing there
+
+	record provider.Record // at the primary
+}
+
+// Provider reads from the primary and writes to both providers. Writes to the
+// secondary never fail a request, they are logged and show up as divergence.
+type Provider struct {
+	primary   provider.Provider
+	secondary provider.Provider
+	metrics   *metrics.Metrics
+
+	mu         sync.Mutex
+	divergence map[string][]Divergence // by zone, as of the latest comparison
+}
+
+// New mirrors the writes to primary onto secondary
+func New(primary, secondary provider.Provider, metrics *metrics.Metrics) *Provider {
+	return &Provider{
+		primary:    primary,
+		secondary:  secondary,
+		metrics:    metrics,
+		divergence: make(map[string][]Divergence),
+	}
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	return p.primary.GetRecords(ctx, zone)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	if err := p.primary.CreateRecord(ctx, zone, record); err != nil {
+		return err
+	}
+	p.mirror(ctx, zone, provider.Change{Op: "create", Record: record}, "")
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	previous := p.previous(ctx, zone, []provider.Change{{Op: "update", Record: record}})
+	if err := p.primary.UpdateRecord(ctx, zone, record); err != nil {
+		return err
+	}
+	p.mirror(ctx, zone, provider.Change{Op: "update", Record: record}, previous[record.ID])
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	if err := p.primary.DeleteRecord(ctx, zone, record); err != nil {
+		return err
+	}
+	p.mirror(ctx, zone, provider.Change{Op: "delete", Record: record}, "")
+	return nil
+}
+
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	return findRecords(ctx, p.primary, zone, name, recordType)
+}
+
+// SupportsComments reports whether both providers keep comments, ownership
+// stored in comments would otherwise be lost at the secondary
+func (p *Provider) SupportsComments() bool {
+	for _, next := range []provider.Provider{p.primary, p.secondary} {
+		if c, ok := next.(provider.Commenter); !ok || !c.SupportsComments() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	if resolver, ok := p.primary.(provider.IDResolver); ok {
+		return resolver.RecordID(zone, record)
+	}
+	return "", false
+}
+
+func (p *Provider) SupportsBatch() bool {
+	b, ok := p.primary.(provider.Batcher)
+	return ok && b.SupportsBatch()
+}
+
+// ApplyChanges applies the batch at the primary, then each change at the
+// secondary
+func (p *Provider) ApplyChanges(ctx context.Context, zone string, changes []provider.Change) error {
+	previous := p.previous(ctx, zone, changes)
+	if err := p.primary.(provider.Batcher).ApplyChanges(ctx, zone, changes); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		p.mirror(ctx, zone, c, previous[c.Record.ID])
+	}
+	return nil
+}
+
+// previous returns the data the TXT updates of changes replace at the
+// primary, by record id. TXT records are not updated in place at the
+// secondary, the replaced value is deleted there once the new one exists.
+func (p *Provider) previous(ctx context.Context, zone string, changes []provider.Change) map[string]string {
+	data := make(map[string]string)
+	for _, c := range changes {
+		if c.Op != "update" || c.Record.Type != "TXT" || c.Record.ID == "" {
+			continue
+		}
+		existing, err := findRecords(ctx, p.primary, zone, c.Record.Name, c.Record.Type)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to look up replaced record for secondary provider", "zone", zone, "name", c.Record.Name, "error", err)
+			continue
+		}
+		for _, r := range existing {
+			if r.ID == c.Record.ID {
+				data[r.ID] = r.Data
+			}
+		}
+	}
+	return data
+}
+
+// mirror applies a change made at the primary to the secondary. Record ids
+// belong to the primary, so the records of the secondary are found by name,
+// type and data. previous is the data an update replaced, if known.
+func (p *Provider) mirror(ctx context.Context, zone string, c provider.Change, previous string) error {
+	record := c.Record
+	record.ID = ""
+	err := func() error {
+		if c.Op == "create" {
+			return p.secondary.CreateRecord(ctx, zone, record)
+		}
+		existing, err := findRecords(ctx, p.secondary, zone, record.Name, record.Type)
+		if err != nil {
+			return err
+		}
+		switch c.Op {
+		case "update":
+			// TXT records share names with records we do not own, so only
+			// an address or alias is updated in place
+			if record.Type == "TXT" {
+				return p.replaceTXT(ctx, zone, existing, record, previous)
+			}
+			if slices.ContainsFunc(existing, func(r provider.Record) bool { return provider.SameValue(r, record) }) {
+				return nil
+			}
+			if len(existing) == 0 {
+				return p.secondary.CreateRecord(ctx, zone, record)
+			}
+			record.ID = existing[0].ID
+			return p.secondary.UpdateRecord(ctx, zone, record)
+		case "delete":
+			for _, r := range existing {
+				if !provider.SameValue(r, record) {
+					continue
+				}
+				if err := p.secondary.DeleteRecord(ctx, zone, r); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to mirror record to secondary provider", "op", c.Op, "zone", zone, "name", record.Name, "record_type", record.Type, "error", err)
+		p.metrics.IncSecondaryFailure(c.Op, zone)
+	}
+	return err
+}
+
+// replaceTXT creates record at the secondary unless it holds it already, then
+// deletes the previous value it replaces
+func (p *Provider) replaceTXT(ctx context.Context, zone string, existing []provider.Record, record provider.Record, previous string) error {
+	if !slices.ContainsFunc(existing, func(r provider.Record) bool { return provider.SameValue(r, record) }) {
+		if err := p.secondary.CreateRecord(ctx, zone, record); err != nil {
+			return err
+		}
+	}
+	replaced := provider.Record{Type: record.Type, Data: previous}
+	if previous == "" || provider.SameValue(replaced, record) {
+		return nil
+	}
+	for _, r := range existing {
+		if provider.SameValue(r, replaced) {
+			if err := p.secondary.DeleteRecord(ctx, zone, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Compare lists zone at both providers and reports the records of the
+// primary that managed selects which the secondary lacks or holds with other
+// data, and the managed records only the secondary holds
+func (p *Provider) Compare(ctx context.Context, zone string, managed func([]provider.Record) []provider.Record) ([]Divergence, error) {
+	primary, err := p.primary.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := p.secondary.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	type key struct{ name, recordType string }
+	index := func(records []provider.Record) map[key][]string {
+		m := make(map[key][]string)
+		for _, r := range managed(records) {
+			k := key{provider.FQDN(r.Name, zone), r.Type}
+			m[k] = append(m[k], r.Data)
+		}
+		return m
+	}
+	want, got := index(primary), index(secondary)
+	byValue := make(map[Divergence]provider.Record)
+	for _, r := range primary {
+		byValue[Divergence{Zone: zone, Name: provider.FQDN(r.Name, zone), Type: r.Type, Primary: r.Data}] = r
+	}
+
+	divergence := []Divergence{}
+	for k, data := range want {
+		for _, d := range data {
+			if slices.Contains(got[k], d) {
+				continue
+			}
+			div := Divergence{Zone: zone, Name: k.name, Type: k.recordType, Primary: d}
+			div.record = byValue[div]
+			if len(got[k]) > 0 && k.recordType != "TXT" {
+				div.Secondary = got[k][0]
+			}
+			divergence = append(divergence, div)
+		}
+	}
+	for k, data := range got {
+		for _, d := range data {
+			if slices.Contains(want[k], d) || (len(want[k]) > 0 && k.recordType != "TXT") {
+				continue
+			}
+			divergence = append(divergence, Divergence{Zone: zone, Name: k.name, Type: k.recordType, Secondary: d})
+		}
+	}
+	slices.SortFunc(divergence, func(a, b Divergence) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Type, b.Type))
+	})
+
+	p.mu.Lock()
+	p.divergence[zone] = divergence
+	p.mu.Unlock()
+	p.metrics.SetSecondaryDivergence(zone, len(divergence))
+	return divergence, nil
+}
+
+// Repair writes the records of the primary the secondary lacks or holds with
+// other data, e.g. those written before the secondary was added. Records only
+// the secondary holds are left for the operator. It returns how many records
+// were repaired, failures are logged.
+func (p *Provider) Repair(ctx context.Context, divergence []Divergence) int {
+	repaired := 0
+	for _, d := range divergence {
+		if d.Primary == "" {
+			continue
+		}
+		op := "create"
+		if d.Secondary != "" {
+			op = "update"
+		}
+		if p.mirror(ctx, d.Zone, provider.Change{Op: op, Record: d.record}, d.Secondary) == nil {
+			repaired++
+		}
+	}
+	return repaired
+}
+
+// Divergence returns the divergence found by the latest comparison of every
+// zone compared
+func (p *Provider) Divergence() []Divergence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := []Divergence{}
+	zones := make([]string, 0, len(p.divergence))
+	for zone := range p.divergence {
+		zones = append(zones, zone)
+	}
+	slices.Sort(zones)
+	for _, zone := range zones {
+		all = append(all, p.divergence[zone]...)
+	}
+	return all
+}
+
+// findRecords returns the records of a name and type, looked up by name when
+// the provider can
+func findRecords(ctx context.Context, dp provider.Provider, zone, name, recordType string) ([]provider.Record, error) {
+	if finder, ok := dp.(provider.Finder); ok {
+		return finder.FindRecords(ctx, zone, name, recordType)
+	}
+	records, err := dp.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	fqdn := provider.FQDN(name, zone)
+	return slices.DeleteFunc(records, func(r provider.Record) bool {
+		return r.Type != recordType || provider.FQDN(r.Name, zone) != fqdn
+	}), nil
+}
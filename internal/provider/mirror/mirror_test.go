@@ -0,0 +1,117 @@
+package mirror
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/memory"
+)
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	primary := memory.New(map[string][]provider.Record{"example.com": {}})
+	secondary := memory.New(map[string][]provider.Record{"example.com": {
+		{Name: "old", Type: "A", Data: "10.0.0.9"},
+	}})
+	p := New(primary, secondary, metrics.New(false))
+
+	app := provider.Record{Name: "app", Type: "A", Data: "10.0.0.1"}
+	if err := p.CreateRecord(ctx, "example.com", app); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	records, _ := primary.GetRecords(ctx, "example.com")
+	app = records[0]
+	app.Data = "10.0.0.2"
+	if err := p.UpdateRecord(ctx, "example.com", app); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, _ := findRecords(ctx, secondary, "example.com", "app", "A"); len(got) != 1 || got[0].Data != "10.0.0.2" {
+		t.Errorf("Expected the update mirrored, got %+v", got)
+	}
+
+	all := func(records []provider.Record) []provider.Record { return records }
+	divergence, err := p.Compare(ctx, "example.com", all)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Divergence{{Zone: "example.com", Name: "old.example.com", Type: "A", Secondary: "10.0.0.9"}}
+	if !reflect.DeepEqual(divergence, expected) {
+		t.Errorf("Compare() = %+v, want %+v", divergence, expected)
+	}
+
+	if err := p.DeleteRecord(ctx, "example.com", app); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, _ := findRecords(ctx, secondary, "example.com", "app", "A"); len(got) != 0 {
+		t.Errorf("Expected the delete mirrored, got %+v", got)
+	}
+
+	// A write the secondary misses shows up as divergence, without failing
+	if err := primary.CreateRecord(ctx, "example.com", provider.Record{Name: "new", Type: "A", Data: "10.0.0.3"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	divergence, _ = p.Compare(ctx, "example.com", all)
+	if len(divergence) != 2 || divergence[0].Name != "new.example.com" || divergence[0].Primary != "10.0.0.3" {
+		t.Errorf("Expected the missing record reported, got %+v", divergence)
+	}
+	if !reflect.DeepEqual(p.Divergence(), divergence) {
+		t.Errorf("Divergence() = %+v, want %+v", p.Divergence(), divergence)
+	}
+
+	// Repair copies it, the record only the secondary holds is left alone
+	if repaired := p.Repair(ctx, divergence); repaired != 1 {
+		t.Errorf("Expected 1 record repaired, got %d", repaired)
+	}
+	divergence, _ = p.Compare(ctx, "example.com", all)
+	if !reflect.DeepEqual(divergence, expected) {
+		t.Errorf("Compare() after repair = %+v, want %+v", divergence, expected)
+	}
+}
+
+func TestMirrorTXT(t *testing.T) {
+	ctx := context.Background()
+	primary := memory.New(map[string][]provider.Record{"example.com": {
+		{ID: "owner", Name: "app", Type: "TXT", Data: "heritage=old"},
+	}})
+	// The secondary returns TXT data quoted, next to a record we do not own
+	secondary := memory.New(map[string][]provider.Record{"example.com": {
+		{Name: "app", Type: "TXT", Data: `"heritage=old"`},
+		{Name: "app", Type: "TXT", Data: `"v=spf1 -all"`},
+	}})
+	p := New(primary, secondary, metrics.New(false))
+
+	// A rewritten heritage value replaces the old one at the secondary
+	updated := provider.Record{ID: "owner", Name: "app", Type: "TXT", Data: "heritage=new"}
+	if err := p.UpdateRecord(ctx, "example.com", updated); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := func() []string {
+		got, _ := findRecords(ctx, secondary, "example.com", "app", "TXT")
+		values := []string{}
+		for _, r := range got {
+			values = append(values, provider.NormalizeTXT(r.Data))
+		}
+		return values
+	}
+	if expected := []string{"v=spf1 -all", "heritage=new"}; !reflect.DeepEqual(data(), expected) {
+		t.Errorf("Secondary TXT after update = %v, want %v", data(), expected)
+	}
+
+	// Quoted values match, so mirroring again creates no duplicate
+	if err := p.UpdateRecord(ctx, "example.com", updated); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"v=spf1 -all", "heritage=new"}; !reflect.DeepEqual(data(), expected) {
+		t.Errorf("Secondary TXT after second update = %v, want %v", data(), expected)
+	}
+
+	if err := p.DeleteRecord(ctx, "example.com", updated); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"v=spf1 -all"}; !reflect.DeepEqual(data(), expected) {
+		t.Errorf("Secondary TXT after delete = %v, want %v", data(), expected)
+	}
+}
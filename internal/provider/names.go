@@ -0,0 +1,21 @@
+package provider
+
+import "strings"
+
+// FQDN expands a zone relative record name, @ or an empty name being the apex
+func FQDN(name, zone string) string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || name == "@" || name == zone {
+		return zone
+	}
+	if strings.HasSuffix(name, "."+zone) {
+		return name
+	}
+	return name + "." + zone
+}
+
+// RelativeName returns a record name relative to its zone, empty for the apex,
+// as providers taking a subdomain expect
+func RelativeName(name, zone string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(FQDN(name, zone), zone), ".")
+}
@@ -0,0 +1,23 @@
+package provider
+
+import "testing"
+
+func TestRelativeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "app", want: "app"},
+		{name: "app.example.com", want: "app"},
+		{name: "app.example.com.", want: "app"},
+		{name: "a.b.example.com", want: "a.b"},
+		{name: "@", want: ""},
+		{name: "example.com", want: ""},
+		{name: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := RelativeName(tt.name, "example.com"); got != tt.want {
+			t.Errorf("RelativeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package namesilo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// Namesilo reply codes, the http status is 200 for most failures
+const (
+	codeInvalidKey      = 110
+	codeInvalidUser     = 111
+	codeSubAccount      = 112 // api not available to sub accounts
+	codeIPNotAllowed    = 113
+	codeDomainNotActive = 200 // not active or not in this account
+	codeDNSModification = 280
+)
+
+// apiError is a request the api answered with a failure code
+type apiError struct {
+	status int
+	code   int
+	detail string
+}
+
+func (e *apiError) Error() string {
+	if e.code == 0 {
+		return fmt.Sprintf("namesilo api returned %d", e.status)
+	}
+	return fmt.Sprintf("namesilo api returned code %d: %s", e.code, e.detail)
+}
+
+// classify wraps a namesilo api error with the matching provider error
+func classify(err error) error {
+	if provider.ErrorClass(err) != provider.ClassUnknown {
+		return err
+	}
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	detail := strings.ToLower(apiErr.detail)
+	switch {
+	case apiErr.status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %w", provider.ErrRateLimited, err)
+	case apiErr.status == http.StatusUnauthorized, apiErr.status == http.StatusForbidden:
+		return fmt.Errorf("%w: %w", provider.ErrPermission, err)
+	}
+	switch apiErr.code {
+	case codeInvalidKey, codeInvalidUser, codeSubAccount, codeIPNotAllowed:
+		return fmt.Errorf("%w: %w", provider.ErrPermission, err)
+	case codeDomainNotActive:
+		return fmt.Errorf("%w: %w", provider.ErrNotFound, err)
+	case codeDNSModification:
+		// One code covers every failed record change, the detail tells them apart
+		switch {
+		case strings.Contains(detail, "exist"), strings.Contains(detail, "duplicate"):
+			return fmt.Errorf("%w: %w", provider.ErrExists, err)
+		case strings.Contains(detail, "not found"), strings.Contains(detail, "invalid record"):
+			return fmt.Errorf("%w: %w", provider.ErrNotFound, err)
+		}
+	}
+	return err
+}
+
+// fail records a failed request and returns the classified error
+func (p *Provider) fail(operation, zone string, err error) error {
+	err = classify(err)
+	p.metrics.IncDNSRequest(operation, zone, false)
+	p.metrics.IncDNSError(operation, zone, provider.ErrorClass(err))
+	return err
+}
@@ -0,0 +1,290 @@
+// Package namesilo manages records through the namesilo xml api. Requests are
+// GETs carrying the api key in the query, and updating a record assigns it a
+// new id.
+package namesilo
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+const (
+	defaultBaseURL = "https://www.namesilo.com/api"
+	minTTL         = 3600 // namesilo refuses shorter ttls
+	codeSuccess    = 300
+)
+
+type Provider struct {
+	baseURL   string
+	apiKey    string
+	userAgent string
+	client    *http.Client
+	metrics   *metrics.Metrics
+	zones     []string
+	ids       *provider.IDCache
+}
+
+// New returns a provider for the configured zones, or every zone of the
+// account when none are configured and autoDiscoverZones is set
+func New(cfg config.DNS, metrics *metrics.Metrics) (*Provider, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("namesilo api key required")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Debug || cfg.DebugBodies {
+		client.Transport = provider.NewDebugTransport(http.DefaultTransport, cfg.DebugBodies, cfg.Token)
+	}
+	p := &Provider{
+		baseURL:   defaultBaseURL,
+		apiKey:    cfg.Token,
+		userAgent: cfg.UserAgent,
+		client:    client,
+		metrics:   metrics,
+		zones:     cfg.Zones,
+		ids:       provider.NewIDCache(),
+	}
+	if len(cfg.Zones) == 0 && cfg.AutoDiscoverZones {
+		reply, err := p.call(context.Background(), "listDomains", nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover zones: %w", classify(err))
+		}
+		p.zones = reply.Domains
+		slog.Info("Discovered DNS zones", "count", len(p.zones))
+	}
+	return p, nil
+}
+
+// Zones returns the names of all zones known to the provider
+func (p *Provider) Zones() []string {
+	zones := append([]string{}, p.zones...)
+	sort.Strings(zones)
+	return zones
+}
+
+type resourceRecord struct {
+	ID    string `xml:"record_id"`
+	Type  string `xml:"type"`
+	Host  string `xml:"host"`
+	Value string `xml:"value"`
+	TTL   int    `xml:"ttl"`
+}
+
+type reply struct {
+	Code     int              `xml:"code"`
+	Detail   string           `xml:"detail"`
+	RecordID string           `xml:"record_id"`
+	Records  []resourceRecord `xml:"resource_record"`
+	Domains  []string         `xml:"domains>domain"`
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	slog.InfoContext(ctx, "Getting DNS records", "zone", zone)
+	start := time.Now()
+
+	reply, err := p.call(ctx, "dnsListRecords", url.Values{"domain": {zone}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS records: %w", p.fail("read", zone, err))
+	}
+	result := make([]provider.Record, 0, len(reply.Records))
+	for _, r := range reply.Records {
+		result = append(result, toRecord(r, zone))
+	}
+
+	p.ids.Reset(zone, result)
+	p.metrics.IncDNSRequest("read", zone, true)
+	slog.DebugContext(ctx, "Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
+	return result, nil
+}
+
+// RecordID returns the id of a record created or listed by the provider
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	return p.ids.Get(zone, record)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	params := values(record, zone)
+	params.Set("rrtype", record.Type)
+	reply, err := p.call(ctx, "dnsAddRecord", params)
+	if err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", p.fail("create", zone, err))
+	}
+	p.ids.Set(zone, record, reply.RecordID)
+
+	p.metrics.IncDNSRequest("create", zone, true)
+	slog.DebugContext(ctx, "Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	id := record.ID
+	if id == "" {
+		// Without an id the record to replace is the one at the name and type
+		found, err := p.FindRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to update DNS record: %w", err)
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("failed to update DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+		id = found[0].ID
+	}
+
+	params := values(record, zone)
+	params.Set("rrid", id)
+	reply, err := p.call(ctx, "dnsUpdateRecord", params)
+	if err != nil {
+		return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
+	}
+	// The updated record is given a new id
+	p.ids.Set(zone, record, reply.RecordID)
+
+	p.metrics.IncDNSRequest("update", zone, true)
+	slog.DebugContext(ctx, "Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
+	start := time.Now()
+
+	// Records created or updated during this run carry no current ID, use the
+	// cached one or look it up
+	id := record.ID
+	if cached, ok := p.ids.Get(zone, record); ok {
+		id = cached
+	}
+	if id == "" {
+		found, err := p.FindRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to delete DNS record: %w", err)
+		}
+		for _, r := range found {
+			if provider.SameValue(r, record) {
+				id = r.ID
+				break
+			}
+		}
+		if id == "" {
+			return fmt.Errorf("failed to delete DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+	}
+
+	if _, err := p.call(ctx, "dnsDeleteRecord", url.Values{"domain": {zone}, "rrid": {id}}); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
+	}
+	p.ids.Remove(zone, record)
+
+	p.metrics.IncDNSRequest("delete", zone, true)
+	slog.DebugContext(ctx, "Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+// FindRecords lists the records of a single name and type. The api has no
+// lookup by name, so the zone is listed and filtered.
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	reply, err := p.call(ctx, "dnsListRecords", url.Values{"domain": {zone}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find DNS records: %w", p.fail("read", zone, err))
+	}
+	p.metrics.IncDNSRequest("read", zone, true)
+
+	fqdn := provider.FQDN(name, zone)
+	result := []provider.Record{}
+	for _, r := range reply.Records {
+		record := toRecord(r, zone)
+		if record.Type != recordType || provider.FQDN(record.Name, zone) != fqdn {
+			continue
+		}
+		p.ids.Set(zone, record, record.ID)
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+// call runs an api operation and returns its reply, failing unless the reply
+// code reports success
+func (p *Provider) call(ctx context.Context, operation string, params url.Values) (*reply, error) {
+	query := url.Values{"version": {"1"}, "type": {"xml"}, "key": {p.apiKey}}
+	for k, v := range params {
+		query[k] = v
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/"+operation+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// The url carries the api key, keep it out of logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = p.baseURL + "/" + operation
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &apiError{status: resp.StatusCode}
+	}
+	var body struct {
+		Reply reply `xml:"reply"`
+	}
+	if err := xml.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if body.Reply.Code != codeSuccess {
+		return nil, &apiError{status: resp.StatusCode, code: body.Reply.Code, detail: body.Reply.Detail}
+	}
+	return &body.Reply, nil
+}
+
+// values returns the api parameters of a record
+func values(record provider.Record, zone string) url.Values {
+	value := record.Data
+	if record.Type == "TXT" {
+		value = provider.NormalizeTXT(value)
+	}
+	return url.Values{
+		"domain":  {zone},
+		"rrhost":  {provider.RelativeName(record.Name, zone)},
+		"rrvalue": {value},
+		"rrttl":   {strconv.Itoa(max(int(record.TTL.Seconds()), minTTL))},
+	}
+}
+
+func toRecord(r resourceRecord, zone string) provider.Record {
+	return provider.Record{
+		ID:   r.ID,
+		Name: r.Host,
+		Type: r.Type,
+		Data: r.Value,
+		TTL:  time.Duration(r.TTL) * time.Second,
+		Zone: zone,
+	}
+}
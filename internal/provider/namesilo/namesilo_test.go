@@ -0,0 +1,149 @@
+package namesilo
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/providertest"
+)
+
+// fakeAPI serves the namesilo dns operations for a single domain, assigning a
+// new id on every update as namesilo does
+type fakeAPI struct {
+	mu      sync.Mutex
+	domain  string
+	records []resourceRecord
+	nextID  int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	out := reply{Code: codeSuccess, Detail: "success"}
+	write := func() {
+		xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"namesilo"`
+			Reply   reply    `xml:"reply"`
+		}{Reply: out})
+	}
+	if q.Get("key") != "key" {
+		out = reply{Code: codeInvalidKey, Detail: "Invalid API Key"}
+		write()
+		return
+	}
+	host := q.Get("rrhost") + "." + f.domain
+	if q.Get("rrhost") == "" {
+		host = f.domain
+	}
+	ttl, _ := strconv.Atoi(q.Get("rrttl"))
+	index := func() int {
+		for i, rec := range f.records {
+			if rec.ID == q.Get("rrid") {
+				return i
+			}
+		}
+		out = reply{Code: codeDNSModification, Detail: "Invalid record_id"}
+		return -1
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/") {
+	case "dnsListRecords":
+		out.Records = f.records
+	case "dnsAddRecord":
+		f.nextID++
+		out.RecordID = strconv.Itoa(f.nextID)
+		f.records = append(f.records, resourceRecord{ID: out.RecordID, Type: q.Get("rrtype"), Host: host, Value: q.Get("rrvalue"), TTL: ttl})
+	case "dnsUpdateRecord":
+		if i := index(); i >= 0 {
+			f.nextID++
+			out.RecordID = strconv.Itoa(f.nextID)
+			f.records[i] = resourceRecord{ID: out.RecordID, Type: f.records[i].Type, Host: host, Value: q.Get("rrvalue"), TTL: ttl}
+		}
+	case "dnsDeleteRecord":
+		if i := index(); i >= 0 {
+			f.records = append(f.records[:i], f.records[i+1:]...)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	write()
+}
+
+func newTestProvider(t *testing.T, api http.Handler, key string) *Provider {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	p, err := New(config.DNS{Token: key, Zones: []string{"example.com"}}, metrics.New(false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.baseURL = srv.URL
+	return p
+}
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, newTestProvider(t, &fakeAPI{domain: "example.com"}, "key"), "example.com")
+}
+
+func TestDeleteAfterUpdate(t *testing.T) {
+	ctx := context.Background()
+	api := &fakeAPI{domain: "example.com"}
+	p := newTestProvider(t, api, "key")
+	record := provider.Record{Name: "app", Type: "A", Data: "192.0.2.1", TTL: time.Minute}
+	if err := p.CreateRecord(ctx, "example.com", record); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ttl := api.records[0].TTL; ttl != minTTL {
+		t.Errorf("Expected ttl raised to %d, got %d", minTTL, ttl)
+	}
+
+	// The id listed before the update is stale once namesilo replaces it
+	records, _ := p.GetRecords(ctx, "example.com")
+	updated := records[0]
+	updated.Data = "192.0.2.2"
+	if err := p.UpdateRecord(ctx, "example.com", updated); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.DeleteRecord(ctx, "example.com", updated); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(api.records) != 0 {
+		t.Errorf("Expected record to be deleted, got %+v", api.records)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	p := newTestProvider(t, &fakeAPI{domain: "example.com"}, "wrong")
+	_, err := p.GetRecords(context.Background(), "example.com")
+	if !errors.Is(err, provider.ErrPermission) {
+		t.Errorf("Expected permission error for a bad key, got %v", err)
+	}
+
+	tests := []struct {
+		err  *apiError
+		want error
+	}{
+		{err: &apiError{status: http.StatusTooManyRequests}, want: provider.ErrRateLimited},
+		{err: &apiError{status: http.StatusOK, code: codeDNSModification, detail: "Invalid record_id"}, want: provider.ErrNotFound},
+		{err: &apiError{status: http.StatusOK, code: codeDNSModification, detail: "DNS record already exists"}, want: provider.ErrExists},
+		{err: &apiError{status: http.StatusOK, code: codeDomainNotActive, detail: "Domain is not active, or does not belong to this user"}, want: provider.ErrNotFound},
+	}
+	for _, tt := range tests {
+		if err := classify(tt.err); !errors.Is(err, tt.want) {
+			t.Errorf("classify(%v) = %v, want %v", tt.err, err, tt.want)
+		}
+	}
+}
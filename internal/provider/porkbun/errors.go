@@ -0,0 +1,55 @@
+package porkbun
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// apiError is a request the api answered with an error status
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("porkbun api returned %d", e.status)
+	}
+	return fmt.Sprintf("porkbun api returned %d: %s", e.status, e.message)
+}
+
+// classify wraps a porkbun api error with the matching provider error. The
+// api answers most failures with a 400 and a message, so the message decides.
+func classify(err error) error {
+	if provider.ErrorClass(err) != provider.ClassUnknown {
+		return err
+	}
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	message := strings.ToLower(apiErr.message)
+	switch {
+	case apiErr.status == http.StatusTooManyRequests, apiErr.status == http.StatusServiceUnavailable, strings.Contains(message, "rate limit"):
+		return fmt.Errorf("%w: %w", provider.ErrRateLimited, err)
+	case apiErr.status == http.StatusForbidden, apiErr.status == http.StatusUnauthorized, strings.Contains(message, "api key"), strings.Contains(message, "api access"):
+		return fmt.Errorf("%w: %w", provider.ErrPermission, err)
+	case apiErr.status == http.StatusNotFound, strings.Contains(message, "invalid record id"), strings.Contains(message, "not found"):
+		return fmt.Errorf("%w: %w", provider.ErrNotFound, err)
+	case strings.Contains(message, "already exists"), strings.Contains(message, "duplicate"):
+		return fmt.Errorf("%w: %w", provider.ErrExists, err)
+	}
+	return err
+}
+
+// fail records a failed request and returns the classified error
+func (p *Provider) fail(operation, zone string, err error) error {
+	err = classify(err)
+	p.metrics.IncDNSRequest(operation, zone, false)
+	p.metrics.IncDNSError(operation, zone, provider.ErrorClass(err))
+	return err
+}
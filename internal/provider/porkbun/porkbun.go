@@ -0,0 +1,311 @@
+// Package porkbun manages records through the porkbun json api. Every request
+// is a POST carrying the api key pair in its body, and records are edited and
+// deleted one at a time by id.
+package porkbun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+const (
+	defaultBaseURL = "https://api.porkbun.com/api/json/v3"
+	minTTL         = 600 // porkbun refuses shorter ttls
+)
+
+type Provider struct {
+	baseURL   string
+	apiKey    string
+	secretKey string
+	userAgent string
+	client    *http.Client
+	metrics   *metrics.Metrics
+	zones     []string
+	ids       *provider.IDCache
+}
+
+// New returns a provider for the configured zones, or every zone of the
+// account when none are configured and autoDiscoverZones is set
+func New(cfg config.DNS, metrics *metrics.Metrics) (*Provider, error) {
+	if cfg.Token == "" || cfg.Secret == "" {
+		return nil, fmt.Errorf("porkbun api key and secret api key required")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Debug || cfg.DebugBodies {
+		client.Transport = provider.NewDebugTransport(http.DefaultTransport, cfg.DebugBodies, cfg.Token, cfg.Secret)
+	}
+	p := &Provider{
+		baseURL:   defaultBaseURL,
+		apiKey:    cfg.Token,
+		secretKey: cfg.Secret,
+		userAgent: cfg.UserAgent,
+		client:    client,
+		metrics:   metrics,
+		zones:     cfg.Zones,
+		ids:       provider.NewIDCache(),
+	}
+	if len(cfg.Zones) == 0 && cfg.AutoDiscoverZones {
+		zones, err := p.listDomains(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover zones: %w", err)
+		}
+		p.zones = zones
+		slog.Info("Discovered DNS zones", "count", len(zones))
+	}
+	return p, nil
+}
+
+// Zones returns the names of all zones known to the provider
+func (p *Provider) Zones() []string {
+	zones := append([]string{}, p.zones...)
+	sort.Strings(zones)
+	return zones
+}
+
+// record is a record as the api lists it, numbers may be sent as strings
+type record struct {
+	ID      flexString `json:"id"`
+	Name    string     `json:"name"`
+	Type    string     `json:"type"`
+	Content string     `json:"content"`
+	TTL     flexString `json:"ttl"`
+}
+
+type response struct {
+	Status  string     `json:"status"`
+	Message string     `json:"message"`
+	ID      flexString `json:"id"`
+	Records []record   `json:"records"`
+	Domains []struct {
+		Domain string `json:"domain"`
+	} `json:"domains"`
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	slog.InfoContext(ctx, "Getting DNS records", "zone", zone)
+	start := time.Now()
+
+	resp, err := p.call(ctx, "/dns/retrieve/"+zone, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS records: %w", p.fail("read", zone, err))
+	}
+	result := make([]provider.Record, 0, len(resp.Records))
+	for _, r := range resp.Records {
+		result = append(result, toRecord(r, zone))
+	}
+
+	p.ids.Reset(zone, result)
+	p.metrics.IncDNSRequest("read", zone, true)
+	slog.DebugContext(ctx, "Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
+	return result, nil
+}
+
+// RecordID returns the id of a record created or listed by the provider
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	return p.ids.Get(zone, record)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	resp, err := p.call(ctx, "/dns/create/"+zone, body(record, zone))
+	if err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", p.fail("create", zone, err))
+	}
+	p.ids.Set(zone, record, string(resp.ID))
+
+	p.metrics.IncDNSRequest("create", zone, true)
+	slog.DebugContext(ctx, "Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	id := record.ID
+	if id == "" {
+		// Without an id the record to replace is the one at the name and type
+		found, err := p.FindRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to update DNS record: %w", err)
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("failed to update DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+		id = found[0].ID
+	}
+
+	if _, err := p.call(ctx, "/dns/edit/"+zone+"/"+id, body(record, zone)); err != nil {
+		return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
+	}
+	p.ids.Set(zone, record, id)
+
+	p.metrics.IncDNSRequest("update", zone, true)
+	slog.DebugContext(ctx, "Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
+	start := time.Now()
+
+	// Records created during this run carry no ID, use the cached one or look it up
+	id := record.ID
+	if id == "" {
+		id, _ = p.ids.Get(zone, record)
+	}
+	if id == "" {
+		found, err := p.FindRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to delete DNS record: %w", err)
+		}
+		for _, r := range found {
+			if provider.SameValue(r, record) {
+				id = r.ID
+				break
+			}
+		}
+		if id == "" {
+			return fmt.Errorf("failed to delete DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+	}
+
+	if _, err := p.call(ctx, "/dns/delete/"+zone+"/"+id, nil); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
+	}
+	p.ids.Remove(zone, record)
+
+	p.metrics.IncDNSRequest("delete", zone, true)
+	slog.DebugContext(ctx, "Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+// FindRecords lists the records of a single name and type
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	path := "/dns/retrieveByNameType/" + zone + "/" + recordType
+	if sub := provider.RelativeName(name, zone); sub != "" {
+		path += "/" + sub
+	}
+	resp, err := p.call(ctx, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find DNS records: %w", p.fail("read", zone, err))
+	}
+	p.metrics.IncDNSRequest("read", zone, true)
+
+	result := make([]provider.Record, 0, len(resp.Records))
+	for _, r := range resp.Records {
+		record := toRecord(r, zone)
+		p.ids.Set(zone, record, record.ID)
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+func (p *Provider) listDomains(ctx context.Context) ([]string, error) {
+	resp, err := p.call(ctx, "/domain/listAll", nil)
+	if err != nil {
+		return nil, classify(err)
+	}
+	zones := make([]string, 0, len(resp.Domains))
+	for _, d := range resp.Domains {
+		zones = append(zones, d.Domain)
+	}
+	return zones, nil
+}
+
+// call posts fields with the api key pair to path
+func (p *Provider) call(ctx context.Context, path string, fields map[string]string) (*response, error) {
+	payload := map[string]string{"apikey": p.apiKey, "secretapikey": p.secretKey}
+	for k, v := range fields {
+		payload[k] = v
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+	httpResp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	resp := &response{}
+	if err := json.Unmarshal(raw, resp); err != nil && httpResp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK || resp.Status != "SUCCESS" {
+		return nil, &apiError{status: httpResp.StatusCode, message: resp.Message}
+	}
+	return resp, nil
+}
+
+// body returns the api fields of a record
+func body(record provider.Record, zone string) map[string]string {
+	content := record.Data
+	if record.Type == "TXT" {
+		content = provider.NormalizeTXT(content)
+	}
+	return map[string]string{
+		"name":    provider.RelativeName(record.Name, zone),
+		"type":    record.Type,
+		"content": content,
+		"ttl":     strconv.Itoa(max(int(record.TTL.Seconds()), minTTL)),
+	}
+}
+
+func toRecord(r record, zone string) provider.Record {
+	ttl, _ := strconv.Atoi(string(r.TTL))
+	return provider.Record{
+		ID:   string(r.ID),
+		Name: r.Name,
+		Type: r.Type,
+		Data: r.Content,
+		TTL:  time.Duration(ttl) * time.Second,
+		Zone: zone,
+	}
+}
+
+// flexString decodes a json string or number, the api sends ids and ttls as
+// either
+type flexString string
+
+func (f *flexString) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*f = flexString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*f = flexString(strings.TrimSpace(n.String()))
+	return nil
+}
@@ -0,0 +1,134 @@
+package porkbun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/providertest"
+)
+
+// fakeAPI serves the porkbun dns endpoints for a single domain
+type fakeAPI struct {
+	mu      sync.Mutex
+	domain  string
+	records []record
+	nextID  int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var req map[string]string
+	json.NewDecoder(r.Body).Decode(&req)
+	if r.Method != http.MethodPost || req["apikey"] != "pk" || req["secretapikey"] != "sk" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "message": "Invalid API key. (002)"})
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/dns/"), "/")
+	fqdn := func(sub string) string {
+		if sub == "" {
+			return f.domain
+		}
+		return sub + "." + f.domain
+	}
+	switch parts[0] {
+	case "retrieve":
+		json.NewEncoder(w).Encode(map[string]any{"status": "SUCCESS", "records": f.records})
+	case "retrieveByNameType":
+		sub := ""
+		if len(parts) > 3 {
+			sub = parts[3]
+		}
+		found := []record{}
+		for _, rec := range f.records {
+			if rec.Type == parts[2] && rec.Name == fqdn(sub) {
+				found = append(found, rec)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "SUCCESS", "records": found})
+	case "create":
+		f.nextID++
+		f.records = append(f.records, record{ID: flexString(strconv.Itoa(f.nextID)), Name: fqdn(req["name"]), Type: req["type"], Content: req["content"], TTL: flexString(req["ttl"])})
+		// The api sends the new id as a number
+		json.NewEncoder(w).Encode(map[string]any{"status": "SUCCESS", "id": f.nextID})
+	case "edit", "delete":
+		for i, rec := range f.records {
+			if string(rec.ID) != parts[2] {
+				continue
+			}
+			if parts[0] == "edit" {
+				f.records[i] = record{ID: rec.ID, Name: fqdn(req["name"]), Type: req["type"], Content: req["content"], TTL: flexString(req["ttl"])}
+			} else {
+				f.records = append(f.records[:i], f.records[i+1:]...)
+			}
+			json.NewEncoder(w).Encode(map[string]string{"status": "SUCCESS"})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "message": "Invalid record ID."})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestProvider(t *testing.T, api http.Handler, key string) *Provider {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	p, err := New(config.DNS{Token: key, Secret: "sk", Zones: []string{"example.com"}}, metrics.New(false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.baseURL = srv.URL
+	return p
+}
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, newTestProvider(t, &fakeAPI{domain: "example.com"}, "pk"), "example.com")
+}
+
+func TestMinimumTTL(t *testing.T) {
+	api := &fakeAPI{domain: "example.com"}
+	p := newTestProvider(t, api, "pk")
+	if err := p.CreateRecord(context.Background(), "example.com", provider.Record{Name: "app", Type: "A", Data: "192.0.2.1", TTL: time.Minute}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ttl := string(api.records[0].TTL); ttl != "600" {
+		t.Errorf("Expected ttl raised to 600, got %s", ttl)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	p := newTestProvider(t, &fakeAPI{domain: "example.com"}, "wrong")
+	_, err := p.GetRecords(context.Background(), "example.com")
+	if !errors.Is(err, provider.ErrPermission) {
+		t.Errorf("Expected permission error for a bad key, got %v", err)
+	}
+
+	tests := []struct {
+		err  *apiError
+		want error
+	}{
+		{err: &apiError{status: http.StatusServiceUnavailable}, want: provider.ErrRateLimited},
+		{err: &apiError{status: http.StatusBadRequest, message: "Invalid record ID."}, want: provider.ErrNotFound},
+		{err: &apiError{status: http.StatusBadRequest, message: "Edit error: We were unable to edit the DNS record. Record already exists."}, want: provider.ErrExists},
+	}
+	for _, tt := range tests {
+		if err := classify(tt.err); !errors.Is(err, tt.want) {
+			t.Errorf("classify(%v) = %v, want %v", tt.err, err, tt.want)
+		}
+	}
+}
@@ -12,6 +12,37 @@ type Provider interface {
 	DeleteRecord(ctx context.Context, zone string, record Record) error
 }
 
+// Finder is implemented by providers able to list the records of a single
+// name without reading the whole zone
+type Finder interface {
+	FindRecords(ctx context.Context, zone, name, recordType string) ([]Record, error)
+}
+
+// IDResolver is implemented by providers remembering the id assigned to each
+// record they created
+type IDResolver interface {
+	RecordID(zone string, record Record) (string, bool)
+}
+
+// Commenter is implemented by providers storing a comment with each record,
+// which can then hold ownership instead of a separate TXT record
+type Commenter interface {
+	SupportsComments() bool
+}
+
+// Batcher is implemented by providers applying several changes to a zone in
+// one request, all of them or none
+type Batcher interface {
+	SupportsBatch() bool
+	ApplyChanges(ctx context.Context, zone string, changes []Change) error
+}
+
+// Change is a record operation of a batch, Op is create, update or delete
+type Change struct {
+	Op     string
+	Record Record
+}
+
 type Record struct {
 	ID   string
 	Name string
@@ -19,5 +50,8 @@ type Record struct {
 	Data string
 	Zone string
 	TTL  time.Duration
+	// Proxied routes traffic through the provider, where supported
+	Proxied bool
+	// Comment is free text kept with the record, where supported
+	Comment string
 }
-
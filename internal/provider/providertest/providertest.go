@@ -0,0 +1,98 @@
+// Package providertest checks that a provider behaves the way the reconcile
+// engine relies on, whatever api it talks to
+package providertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// Run creates, lists, updates and deletes records in zone, which must start
+// empty. Names are passed zone relative as the engine does, and may come back
+// relative or fully qualified.
+func Run(t *testing.T, p provider.Provider, zone string) {
+	t.Helper()
+	ctx := context.Background()
+	a := provider.Record{Name: "app", Type: "A", Data: "192.0.2.1", TTL: time.Hour}
+	txt := provider.Record{Name: "app", Type: "TXT", Data: "heritage=caddy-dns-sync,caddy-dns-sync/owner=test", TTL: time.Hour}
+	apex := provider.Record{Name: "@", Type: "A", Data: "192.0.2.10", TTL: time.Hour}
+
+	for _, r := range []provider.Record{a, txt, apex} {
+		if err := p.CreateRecord(ctx, zone, r); err != nil {
+			t.Fatalf("CreateRecord(%s %s) error: %v", r.Type, r.Name, err)
+		}
+	}
+	records := list(t, p, zone)
+	for _, r := range []provider.Record{a, txt, apex} {
+		got, ok := find(records, zone, r)
+		if !ok {
+			t.Fatalf("Expected created %s %s in listing, got %+v", r.Type, r.Name, records)
+		}
+		if got.ID == "" {
+			t.Errorf("Expected listed %s %s to carry an id", r.Type, r.Name)
+		}
+	}
+
+	// Update the record as listed, the engine passes its id along
+	listed, _ := find(records, zone, a)
+	listed.Data = "192.0.2.2"
+	if err := p.UpdateRecord(ctx, zone, listed); err != nil {
+		t.Fatalf("UpdateRecord error: %v", err)
+	}
+	records = list(t, p, zone)
+	if _, ok := find(records, zone, a); ok {
+		t.Errorf("Expected old data to be gone after update, got %+v", records)
+	}
+	updated := a
+	updated.Data = "192.0.2.2"
+	if _, ok := find(records, zone, updated); !ok {
+		t.Fatalf("Expected updated data in listing, got %+v", records)
+	}
+
+	if finder, ok := p.(provider.Finder); ok {
+		found, err := finder.FindRecords(ctx, zone, "app."+zone, "A")
+		if err != nil {
+			t.Fatalf("FindRecords error: %v", err)
+		}
+		if len(found) != 1 || found[0].Data != "192.0.2.2" {
+			t.Errorf("Expected the updated A record, got %+v", found)
+		}
+	}
+
+	// Records created in this run carry no id when deleted
+	for _, r := range []provider.Record{updated, txt, apex} {
+		if err := p.DeleteRecord(ctx, zone, r); err != nil {
+			t.Fatalf("DeleteRecord(%s %s) error: %v", r.Type, r.Name, err)
+		}
+	}
+	if records := list(t, p, zone); len(records) != 0 {
+		t.Errorf("Expected zone to be empty after deletes, got %+v", records)
+	}
+
+	err := p.DeleteRecord(ctx, zone, a)
+	if !errors.Is(err, provider.ErrNotFound) {
+		t.Errorf("Expected deleting a missing record to fail with ErrNotFound, got %v", err)
+	}
+}
+
+func list(t *testing.T, p provider.Provider, zone string) []provider.Record {
+	t.Helper()
+	records, err := p.GetRecords(context.Background(), zone)
+	if err != nil {
+		t.Fatalf("GetRecords error: %v", err)
+	}
+	return records
+}
+
+func find(records []provider.Record, zone string, want provider.Record) (provider.Record, bool) {
+	for _, r := range records {
+		if r.Type == want.Type && provider.FQDN(r.Name, zone) == provider.FQDN(want.Name, zone) && provider.SameValue(r, want) {
+			return r, true
+		}
+	}
+	return provider.Record{}, false
+}
@@ -0,0 +1,511 @@
+// Package rfc2136 manages records on a self hosted authoritative server, such
+// as bind, without any http api. Zones are read by zone transfer (AXFR) and
+// written with dynamic updates (RFC 2136), both signed with a TSIG key. The
+// server holds rrsets, all records of a name and type, and has no record ids.
+package rfc2136
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+const (
+	defaultPort    = "53"
+	defaultTimeout = 30 * time.Second
+	defaultTTL     = 300 // seconds, of records created without a ttl
+	maxTXTString   = 255
+)
+
+type Provider struct {
+	server  string
+	tsig    *tsig // nil when requests are unsigned
+	timeout time.Duration
+	ttl     time.Duration
+	metrics *metrics.Metrics
+	zones   []string
+	ids     *provider.IDCache
+}
+
+// New returns a provider for the configured zones. The server cannot list
+// its zones, so they must be configured.
+func New(cfg config.DNS, metrics *metrics.Metrics) (*Provider, error) {
+	if cfg.RFC2136.Server == "" {
+		return nil, fmt.Errorf("rfc2136 server required")
+	}
+	if len(cfg.Zones) == 0 {
+		return nil, fmt.Errorf("rfc2136 zones required, the server cannot list them")
+	}
+	server := cfg.RFC2136.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), defaultPort)
+	}
+	p := &Provider{
+		server:  server,
+		timeout: cfg.RFC2136.Timeout,
+		ttl:     defaultTTL * time.Second,
+		metrics: metrics,
+		zones:   cfg.Zones,
+		ids:     provider.NewIDCache(),
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultTimeout
+	}
+	if cfg.TTL > 1 {
+		p.ttl = time.Duration(cfg.TTL) * time.Second
+	}
+	if cfg.RFC2136.KeyName != "" {
+		t, err := newTSIG(cfg.RFC2136.KeyName, cfg.RFC2136.Algorithm, cfg.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("rfc2136 key %s: %w", cfg.RFC2136.KeyName, err)
+		}
+		p.tsig = t
+	}
+	return p, nil
+}
+
+// Zones returns the names of all zones known to the provider
+func (p *Provider) Zones() []string {
+	zones := append([]string{}, p.zones...)
+	sort.Strings(zones)
+	return zones
+}
+
+// SupportsBatch reports that changes can be applied in one update, which
+// the server applies all or none
+func (p *Provider) SupportsBatch() bool {
+	return true
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	slog.InfoContext(ctx, "Getting DNS records", "zone", zone)
+	start := time.Now()
+
+	result, err := p.transfer(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transfer zone %s: %w", zone, p.fail("read", zone, err))
+	}
+
+	p.ids.Reset(zone, result)
+	p.metrics.IncDNSRequest("read", zone, true)
+	slog.DebugContext(ctx, "Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
+	return result, nil
+}
+
+// RecordID returns the id of a record created or listed by the provider. DNS
+// has no record ids, a record's id is its value within the rrset.
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	return p.ids.Get(zone, record)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	if err := p.apply(ctx, zone, []provider.Change{{Op: "create", Record: record}}); err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", p.fail("create", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("create", zone, true)
+	slog.DebugContext(ctx, "Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	if err := p.apply(ctx, zone, []provider.Change{{Op: "update", Record: record}}); err != nil {
+		return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("update", zone, true)
+	slog.DebugContext(ctx, "Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
+	start := time.Now()
+
+	if err := p.apply(ctx, zone, []provider.Change{{Op: "delete", Record: record}}); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("delete", zone, true)
+	slog.DebugContext(ctx, "Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+// ApplyChanges writes every change in one update, which the server applies
+// all or none
+func (p *Provider) ApplyChanges(ctx context.Context, zone string, changes []provider.Change) error {
+	slog.InfoContext(ctx, "Applying DNS record changes", "zone", zone, "count", len(changes))
+	start := time.Now()
+
+	if err := p.apply(ctx, zone, changes); err != nil {
+		return fmt.Errorf("failed to apply DNS record changes: %w", p.fail("batch", zone, err))
+	}
+
+	p.metrics.IncDNSRequest("batch", zone, true)
+	slog.DebugContext(ctx, "Applied DNS record changes", "zone", zone, "count", len(changes), "duration", time.Since(start))
+	return nil
+}
+
+// FindRecords queries the server for the rrset of a single name and type
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	records, err := p.query(ctx, zone, provider.FQDN(name, zone), recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find DNS records: %w", p.fail("read", zone, err))
+	}
+	p.metrics.IncDNSRequest("read", zone, true)
+
+	for _, r := range records {
+		p.ids.Set(zone, r, r.ID)
+	}
+	return records, nil
+}
+
+// update is a record added to or deleted from an rrset
+type update struct {
+	delete bool
+	record provider.Record
+}
+
+// apply queries the rrsets the changes touch, applies the changes to them
+// and sends the records added and deleted in a single update
+func (p *Provider) apply(ctx context.Context, zone string, changes []provider.Change) error {
+	type key struct{ name, recordType string }
+	sets := map[key][]provider.Record{}
+	for _, c := range changes {
+		k := key{provider.FQDN(c.Record.Name, zone), c.Record.Type}
+		if _, ok := sets[k]; ok {
+			continue
+		}
+		set, err := p.query(ctx, zone, k.name, k.recordType)
+		if err != nil {
+			return err
+		}
+		sets[k] = set
+	}
+
+	updates := []update{}
+	for _, c := range changes {
+		k := key{provider.FQDN(c.Record.Name, zone), c.Record.Type}
+		r := c.Record
+		r.Name, r.Zone = k.name, zone
+		if r.TTL <= 0 {
+			r.TTL = p.ttl
+		}
+		set, applied, err := applyChange(sets[k], c.Op, r)
+		if err != nil {
+			return fmt.Errorf("%s %s %s in zone %s: %w", c.Op, c.Record.Type, c.Record.Name, zone, err)
+		}
+		sets[k] = set
+		updates = append(updates, applied...)
+	}
+
+	msg, err := updateMessage(zone, updates)
+	if err != nil {
+		return err
+	}
+	if err := p.exchange(ctx, msg, func([]byte) (bool, error) { return false, nil }); err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		switch c.Op {
+		case "delete":
+			p.ids.Remove(zone, c.Record)
+		default:
+			p.ids.Set(zone, c.Record, value(c.Record))
+		}
+	}
+	return nil
+}
+
+// applyChange changes the records of an rrset, returning the records to add
+// and delete at the server
+func applyChange(set []provider.Record, op string, r provider.Record) ([]provider.Record, []update, error) {
+	v := value(r)
+	index := func(id string) int {
+		return slices.IndexFunc(set, func(s provider.Record) bool { return s.ID == id })
+	}
+	switch op {
+	case "create":
+		if index(v) >= 0 {
+			return nil, nil, provider.ErrExists
+		}
+		r.ID = v
+		return append(set, r), []update{{record: r}}, nil
+	case "update":
+		// Without an id the record to replace is the first of the rrset
+		i := 0
+		if r.ID != "" {
+			i = index(r.ID)
+		}
+		if i < 0 || len(set) == 0 {
+			return nil, nil, provider.ErrNotFound
+		}
+		updates := []update{}
+		if set[i].ID != v {
+			updates = append(updates, update{delete: true, record: set[i]})
+		}
+		// Adding a record again sets the ttl of its rrset
+		r.ID = v
+		set[i] = r
+		return set, append(updates, update{record: r}), nil
+	case "delete":
+		i := slices.IndexFunc(set, func(s provider.Record) bool {
+			return (r.ID != "" && s.ID == r.ID) || s.ID == v
+		})
+		if i < 0 {
+			return nil, nil, provider.ErrNotFound
+		}
+		deleted := set[i]
+		return slices.Delete(set, i, i+1), []update{{delete: true, record: deleted}}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown operation %s", op)
+}
+
+// updateMessage builds the update of zone. Records other than CNAMEs are
+// only added while their name holds no CNAME, which the server would
+// otherwise silently ignore them for.
+func updateMessage(zone string, updates []update) ([]byte, error) {
+	zoneName, err := dnsmessage.NewName(fqdn(zone))
+	if err != nil {
+		return nil, err
+	}
+	header := dnsmessage.Header{OpCode: opcodeUpdate}
+	question := dnsmessage.Question{Name: zoneName, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}
+	return newMessage(header, question, func(b *dnsmessage.Builder) error {
+		// Prerequisites go in the answer section
+		if err := b.StartAnswers(); err != nil {
+			return err
+		}
+		checked := map[string]bool{}
+		for _, u := range updates {
+			if u.delete || u.record.Type == "CNAME" || checked[u.record.Name] {
+				continue
+			}
+			checked[u.record.Name] = true
+			name, err := dnsmessage.NewName(fqdn(u.record.Name))
+			if err != nil {
+				return err
+			}
+			h := dnsmessage.ResourceHeader{Name: name, Class: classNONE}
+			if err := b.UnknownResource(h, dnsmessage.UnknownResource{Type: dnsmessage.TypeCNAME}); err != nil {
+				return err
+			}
+		}
+
+		// Updates go in the authority section
+		if err := b.StartAuthorities(); err != nil {
+			return err
+		}
+		for _, u := range updates {
+			name, err := dnsmessage.NewName(fqdn(u.record.Name))
+			if err != nil {
+				return err
+			}
+			h := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: uint32(u.record.TTL.Seconds())}
+			if u.delete {
+				h.Class, h.TTL = classNONE, 0
+			}
+			if err := addResource(b, h, u.record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addResource adds the record r with header h
+func addResource(b *dnsmessage.Builder, h dnsmessage.ResourceHeader, r provider.Record) error {
+	switch r.Type {
+	case "A", "AAAA":
+		addr, err := netip.ParseAddr(r.Data)
+		if err != nil || addr.Is4() != (r.Type == "A") {
+			return fmt.Errorf("%s record data %q is not a valid address", r.Type, r.Data)
+		}
+		if r.Type == "A" {
+			return b.AResource(h, dnsmessage.AResource{A: addr.As4()})
+		}
+		return b.AAAAResource(h, dnsmessage.AAAAResource{AAAA: addr.As16()})
+	case "CNAME":
+		target, err := dnsmessage.NewName(fqdn(r.Data))
+		if err != nil {
+			return fmt.Errorf("CNAME record data %q is not a valid name: %w", r.Data, err)
+		}
+		return b.CNAMEResource(h, dnsmessage.CNAMEResource{CNAME: target})
+	case "TXT":
+		data := provider.NormalizeTXT(r.Data)
+		parts := []string{}
+		for len(data) > maxTXTString {
+			parts = append(parts, data[:maxTXTString])
+			data = data[maxTXTString:]
+		}
+		return b.TXTResource(h, dnsmessage.TXTResource{TXT: append(parts, data)})
+	}
+	return fmt.Errorf("record type %s cannot be written", r.Type)
+}
+
+// query reads the rrset of a name and type, empty if it does not exist
+func (p *Provider) query(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+	qtype, ok := types[recordType]
+	if !ok {
+		return nil, fmt.Errorf("record type %s cannot be queried", recordType)
+	}
+	msg, err := newMessage(dnsmessage.Header{}, dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []provider.Record{}
+	err = p.exchange(ctx, msg, func(resp []byte) (bool, error) {
+		rrs, err := answers(resp)
+		if err != nil {
+			return false, fmt.Errorf("parse response: %w", err)
+		}
+		for _, rr := range rrs {
+			r, ok := toRecord(rr, zone)
+			if ok && r.Type == recordType && strings.EqualFold(r.Name, name) {
+				records = append(records, r)
+			}
+		}
+		return false, nil
+	})
+	var rcode *RcodeError
+	if errors.As(err, &rcode) && rcode.Rcode == dnsmessage.RCodeNameError {
+		return records, nil
+	}
+	return records, err
+}
+
+// transfer reads every record of zone but its SOA by zone transfer
+func (p *Provider) transfer(ctx context.Context, zone string) ([]provider.Record, error) {
+	zoneName, err := dnsmessage.NewName(fqdn(zone))
+	if err != nil {
+		return nil, err
+	}
+	msg, err := newMessage(dnsmessage.Header{}, dnsmessage.Question{Name: zoneName, Type: dnsmessage.TypeAXFR, Class: dnsmessage.ClassINET}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The transfer starts and ends with the SOA of the zone
+	records := []provider.Record{}
+	soas := 0
+	err = p.exchange(ctx, msg, func(resp []byte) (bool, error) {
+		rrs, err := answers(resp)
+		if err != nil {
+			return false, fmt.Errorf("parse response: %w", err)
+		}
+		for _, rr := range rrs {
+			if rr.Header.Type == dnsmessage.TypeSOA {
+				soas++
+				continue
+			}
+			if soas == 0 {
+				return false, fmt.Errorf("zone transfer does not start with a SOA")
+			}
+			if r, ok := toRecord(rr, zone); ok {
+				records = append(records, r)
+			}
+		}
+		if soas == 0 {
+			return false, fmt.Errorf("zone transfer does not start with a SOA")
+		}
+		return soas < 2, nil
+	})
+	return records, err
+}
+
+// types are the record types read from the server
+var types = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"TXT":   dnsmessage.TypeTXT,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"SRV":   dnsmessage.TypeSRV,
+	"PTR":   dnsmessage.TypePTR,
+}
+
+// toRecord returns the record of rr with its value as id, false for types
+// not read from the server
+func toRecord(rr dnsmessage.Resource, zone string) (provider.Record, bool) {
+	r := provider.Record{
+		Name: strings.ToLower(strings.TrimSuffix(rr.Header.Name.String(), ".")),
+		Zone: zone,
+		TTL:  time.Duration(rr.Header.TTL) * time.Second,
+	}
+	switch body := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		r.Type, r.Data = "A", netip.AddrFrom4(body.A).String()
+	case *dnsmessage.AAAAResource:
+		r.Type, r.Data = "AAAA", netip.AddrFrom16(body.AAAA).String()
+	case *dnsmessage.CNAMEResource:
+		r.Type, r.Data = "CNAME", hostname(body.CNAME)
+	case *dnsmessage.TXTResource:
+		r.Type, r.Data = "TXT", strings.Join(body.TXT, "")
+	case *dnsmessage.MXResource:
+		r.Type, r.Data = "MX", strconv.Itoa(int(body.Pref))+" "+hostname(body.MX)
+	case *dnsmessage.NSResource:
+		r.Type, r.Data = "NS", hostname(body.NS)
+	case *dnsmessage.SRVResource:
+		r.Type, r.Data = "SRV", fmt.Sprintf("%d %d %d %s", body.Priority, body.Weight, body.Port, hostname(body.Target))
+	case *dnsmessage.PTRResource:
+		r.Type, r.Data = "PTR", hostname(body.PTR)
+	default:
+		return provider.Record{}, false
+	}
+	r.ID = value(r)
+	return r, true
+}
+
+// value returns record data as the server holds it, comparable across
+// quoting of TXT data, address notations and the case of names
+func value(r provider.Record) string {
+	switch r.Type {
+	case "TXT":
+		return provider.NormalizeTXT(r.Data)
+	case "A", "AAAA":
+		if addr, err := netip.ParseAddr(r.Data); err == nil {
+			return addr.String()
+		}
+	case "CNAME", "NS", "PTR":
+		return strings.ToLower(strings.TrimSuffix(r.Data, "."))
+	}
+	return r.Data
+}
+
+func hostname(name dnsmessage.Name) string {
+	return strings.TrimSuffix(name.String(), ".")
+}
+
+// fail records a failed request and returns the error
+func (p *Provider) fail(operation, zone string, err error) error {
+	p.metrics.IncDNSRequest(operation, zone, false)
+	p.metrics.IncDNSError(operation, zone, provider.ErrorClass(err))
+	return err
+}
@@ -0,0 +1,272 @@
+package rfc2136
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/providertest"
+)
+
+const (
+	testKey    = "sync-key"
+	testSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0IQ=="
+)
+
+// fakeServer is an authoritative server of a single zone, answering queries,
+// zone transfers with a record per message and dynamic updates over tcp.
+// Requests must be signed with the test key.
+type fakeServer struct {
+	mu       sync.Mutex
+	zone     dnsmessage.Name
+	records  []dnsmessage.Resource
+	tsig     *tsig
+	listener net.Listener
+}
+
+func newFakeServer(t *testing.T, zone string) *fakeServer {
+	t.Helper()
+	key, err := newTSIG(testKey, "", testSecret)
+	if err != nil {
+		t.Fatalf("newTSIG error: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	s := &fakeServer{zone: dnsmessage.MustNewName(fqdn(zone)), tsig: key, listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			req, err := readMsg(conn)
+			if err != nil {
+				return
+			}
+			for _, resp := range s.handle(req) {
+				if writeMsg(conn, resp) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle answers a request, signing the answers of a verified request. Of a
+// transfer only the first and last message are signed.
+func (s *fakeServer) handle(req []byte) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p dnsmessage.Parser
+	header, err := p.Start(req)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil || p.SkipAllQuestions() != nil {
+		return nil
+	}
+	reply := func(rcode dnsmessage.RCode, answers []dnsmessage.Resource) []byte {
+		msg := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: header.ID, Response: true, OpCode: header.OpCode, Authoritative: true, RCode: rcode},
+			Questions: []dnsmessage.Question{q},
+			Answers:   answers,
+		}
+		packed, _ := msg.Pack()
+		return packed
+	}
+	requestMAC, ok := s.verify(req)
+	if !ok {
+		return [][]byte{reply(rcodeNotAuth, nil)}
+	}
+
+	var resps [][]byte
+	switch {
+	case header.OpCode == opcodeUpdate:
+		resps = [][]byte{reply(s.update(&p), nil)}
+	case q.Type == dnsmessage.TypeAXFR:
+		soa := dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: s.zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+			Body:   &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns1." + s.zone.String()), MBox: dnsmessage.MustNewName("hostmaster." + s.zone.String()), Serial: 1},
+		}
+		resps = append(resps, reply(dnsmessage.RCodeSuccess, []dnsmessage.Resource{soa}))
+		for _, r := range s.records {
+			resps = append(resps, reply(dnsmessage.RCodeSuccess, []dnsmessage.Resource{r}))
+		}
+		resps = append(resps, reply(dnsmessage.RCodeSuccess, []dnsmessage.Resource{soa}))
+	default:
+		answers := []dnsmessage.Resource{}
+		exists := false
+		for _, r := range s.records {
+			if strings.EqualFold(r.Header.Name.String(), q.Name.String()) {
+				exists = true
+				if r.Header.Type == q.Type {
+					answers = append(answers, r)
+				}
+			}
+		}
+		rcode := dnsmessage.RCodeSuccess
+		if !exists {
+			rcode = dnsmessage.RCodeNameError
+		}
+		resps = [][]byte{reply(rcode, answers)}
+	}
+
+	// Chain the MAC of every signed message into the next
+	prior, pending := requestMAC, []byte{}
+	now := uint64(s.tsig.now().Unix())
+	for i, resp := range resps {
+		if i > 0 && i < len(resps)-1 {
+			pending = append(pending, resp...)
+			continue
+		}
+		var mac []byte
+		if i == 0 {
+			mac = s.tsig.mac(prior, resp, s.tsig.variables(now, tsigFudge, 0, nil))
+		} else {
+			timers := appendTime(nil, now)
+			timers = append(timers, byte(tsigFudge>>8), byte(tsigFudge&0xff))
+			mac = s.tsig.mac(prior, append(pending, resp...), timers)
+		}
+		resps[i] = s.tsig.appendTSIG(resp, mac, now)
+		prior, pending = mac, nil
+	}
+	return resps
+}
+
+// verify checks the TSIG of a request, returning its MAC
+func (s *fakeServer) verify(req []byte) ([]byte, bool) {
+	body, rr, err := splitTSIG(req)
+	if err != nil || rr == nil {
+		return nil, false
+	}
+	r, err := parseTSIG(req, rr)
+	if err != nil || r.name != fqdn(testKey) {
+		return nil, false
+	}
+	expected := s.tsig.mac(nil, body, s.tsig.variables(r.signed, r.fudge, r.err, r.other))
+	return r.mac, reflect.DeepEqual(expected, r.mac)
+}
+
+// update applies the prerequisites and updates of a dynamic update
+func (s *fakeServer) update(p *dnsmessage.Parser) dnsmessage.RCode {
+	// Prerequisites carry no data, only their headers are read
+	for {
+		pr, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil || p.SkipAnswer() != nil {
+			return dnsmessage.RCodeFormatError
+		}
+		for _, r := range s.records {
+			if r.Header.Name == pr.Name && r.Header.Type == pr.Type {
+				return rcodeYXRRSet
+			}
+		}
+	}
+	updates, err := p.AllAuthorities()
+	if err != nil {
+		return dnsmessage.RCodeFormatError
+	}
+	for _, u := range updates {
+		i := -1
+		for j, r := range s.records {
+			if r.Header.Name == u.Header.Name && r.Header.Type == u.Header.Type && reflect.DeepEqual(r.Body, u.Body) {
+				i = j
+			}
+		}
+		switch {
+		case u.Header.Class == classNONE && i >= 0:
+			s.records = append(s.records[:i], s.records[i+1:]...)
+		case u.Header.Class == dnsmessage.ClassINET && i >= 0:
+			s.records[i].Header.TTL = u.Header.TTL
+		case u.Header.Class == dnsmessage.ClassINET:
+			s.records = append(s.records, u)
+		}
+	}
+	return dnsmessage.RCodeSuccess
+}
+
+func newTestProvider(t *testing.T, s *fakeServer, secret string) *Provider {
+	t.Helper()
+	p, err := New(config.DNS{
+		Zones:   []string{"example.com"},
+		Secret:  secret,
+		RFC2136: config.RFC2136{Server: s.listener.Addr().String(), KeyName: testKey, Timeout: 5 * time.Second},
+	}, metrics.New(false))
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	return p
+}
+
+func TestProvider(t *testing.T) {
+	s := newFakeServer(t, "example.com")
+	providertest.Run(t, newTestProvider(t, s, testSecret), "example.com")
+}
+
+func TestBatchAndConflict(t *testing.T) {
+	s := newFakeServer(t, "example.com")
+	p := newTestProvider(t, s, testSecret)
+	ctx := context.Background()
+
+	long := strings.Repeat("x", 300)
+	changes := []provider.Change{
+		{Op: "create", Record: provider.Record{Name: "www", Type: "CNAME", Data: "app.example.com"}},
+		{Op: "create", Record: provider.Record{Name: "app", Type: "TXT", Data: long}},
+	}
+	if err := p.ApplyChanges(ctx, "example.com", changes); err != nil {
+		t.Fatalf("ApplyChanges error: %v", err)
+	}
+	records, err := p.GetRecords(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("GetRecords error: %v", err)
+	}
+	expected := []provider.Record{
+		{ID: "app.example.com", Name: "www.example.com", Type: "CNAME", Data: "app.example.com", Zone: "example.com", TTL: defaultTTL * time.Second},
+		{ID: long, Name: "app.example.com", Type: "TXT", Data: long, Zone: "example.com", TTL: defaultTTL * time.Second},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected records %+v, got %+v", expected, records)
+	}
+
+	// The server would silently ignore an address next to a CNAME
+	err = p.CreateRecord(ctx, "example.com", provider.Record{Name: "www", Type: "A", Data: "192.0.2.1"})
+	if !errors.Is(err, provider.ErrConflict) {
+		t.Errorf("Expected ErrConflict creating an A record next to a CNAME, got %v", err)
+	}
+	err = p.CreateRecord(ctx, "example.com", provider.Record{Name: "www", Type: "CNAME", Data: "app.example.com."})
+	if !errors.Is(err, provider.ErrExists) {
+		t.Errorf("Expected ErrExists creating an identical record, got %v", err)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	s := newFakeServer(t, "example.com")
+	p := newTestProvider(t, s, "b3RoZXItc2VjcmV0")
+	_, err := p.GetRecords(context.Background(), "example.com")
+	if !errors.Is(err, provider.ErrPermission) {
+		t.Errorf("Expected ErrPermission with the wrong key, got %v", err)
+	}
+}
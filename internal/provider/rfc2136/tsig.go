@@ -0,0 +1,400 @@
+package rfc2136
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+const (
+	typeTSIG  = 250
+	classANY  = 255
+	tsigFudge = 300 // seconds the clocks of server and client may differ
+
+	// maxUnsigned bounds the messages of a transfer a server may leave
+	// unsigned between two signed ones
+	maxUnsigned = 99
+)
+
+// TSIG errors of a response, the server could not verify the request
+const (
+	tsigBadSig  = 16
+	tsigBadKey  = 17
+	tsigBadTime = 18
+)
+
+var algorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha224": sha256.New224,
+	"hmac-sha256": sha256.New,
+	"hmac-sha384": sha512.New384,
+	"hmac-sha512": sha512.New,
+}
+
+// errUnsigned is a response to a signed request that carries no TSIG
+var errUnsigned = errors.New("response is not signed")
+
+// tsig signs requests and verifies responses with a shared key (RFC 8945)
+type tsig struct {
+	name      string // key name, fully qualified
+	algorithm string // algorithm name, fully qualified
+	secret    []byte
+	hash      func() hash.Hash
+	now       func() time.Time
+}
+
+func newTSIG(name, algorithm, secret string) (*tsig, error) {
+	if algorithm == "" {
+		algorithm = "hmac-sha256"
+	}
+	algorithm = strings.ToLower(strings.TrimSuffix(algorithm, "."))
+	h, ok := algorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("tsig algorithm %q is not supported", algorithm)
+	}
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("tsig secret must be base64")
+	}
+	return &tsig{
+		name:      fqdn(name),
+		algorithm: algorithm + ".",
+		secret:    key,
+		hash:      h,
+		now:       time.Now,
+	}, nil
+}
+
+// sign appends a TSIG record to msg, returning the signed message and its MAC
+func (t *tsig) sign(msg []byte) ([]byte, []byte) {
+	signed := uint64(t.now().Unix())
+	mac := t.mac(nil, msg, t.variables(signed, tsigFudge, 0, nil))
+	return t.appendTSIG(msg, mac, signed), mac
+}
+
+// appendTSIG returns msg with a TSIG record of mac appended
+func (t *tsig) appendTSIG(msg, mac []byte, signed uint64) []byte {
+	rdata := appendName(nil, t.algorithm)
+	rdata = appendTime(rdata, signed)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, msg[0:2]...) // original id
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)
+
+	out := append([]byte{}, msg...)
+	out = appendName(out, t.name)
+	out = binary.BigEndian.AppendUint16(out, typeTSIG)
+	out = binary.BigEndian.AppendUint16(out, classANY)
+	out = binary.BigEndian.AppendUint32(out, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+	binary.BigEndian.PutUint16(out[10:12], binary.BigEndian.Uint16(out[10:12])+1)
+	return out
+}
+
+// variables returns the TSIG variables covered by the MAC of a message
+func (t *tsig) variables(signed uint64, fudge, tsigErr uint16, other []byte) []byte {
+	b := appendName(nil, t.name)
+	b = binary.BigEndian.AppendUint16(b, classANY)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = appendName(b, t.algorithm)
+	b = appendTime(b, signed)
+	b = binary.BigEndian.AppendUint16(b, fudge)
+	b = binary.BigEndian.AppendUint16(b, tsigErr)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(other)))
+	return append(b, other...)
+}
+
+// mac computes the MAC of msg, chained to the MAC of the request or the
+// previous message of a response when prior is set
+func (t *tsig) mac(prior, msg, variables []byte) []byte {
+	h := hmac.New(t.hash, t.secret)
+	if prior != nil {
+		h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(prior))))
+		h.Write(prior)
+	}
+	h.Write(msg)
+	h.Write(variables)
+	return h.Sum(nil)
+}
+
+// verifier checks the TSIG of the responses to a signed request. The MAC of
+// each signed message is chained into the next, as the messages of a zone
+// transfer are signed.
+type verifier struct {
+	tsig     *tsig
+	prior    []byte // MAC of the request or the last signed message
+	pending  []byte // unsigned messages since the last signed one
+	unsigned int
+	signed   bool
+}
+
+func (t *tsig) verifier(requestMAC []byte) *verifier {
+	return &verifier{tsig: t, prior: requestMAC}
+}
+
+// verify checks the TSIG of the next response message
+func (v *verifier) verify(msg []byte) error {
+	body, rr, err := splitTSIG(msg)
+	if err != nil {
+		return err
+	}
+	if rr == nil {
+		// Only messages of a transfer after the first may go unsigned
+		if !v.signed || v.unsigned >= maxUnsigned {
+			return errUnsigned
+		}
+		v.pending = append(v.pending, msg...)
+		v.unsigned++
+		return nil
+	}
+
+	r, err := parseTSIG(msg, rr)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(r.name, v.tsig.name) || !strings.EqualFold(r.algorithm, v.tsig.algorithm) {
+		return fmt.Errorf("response signed with key %s %s", r.name, r.algorithm)
+	}
+	if r.err != 0 {
+		return &TSIGError{Code: r.err}
+	}
+	// The MAC covers the message as sent, before the server added the TSIG
+	binary.BigEndian.PutUint16(body[0:2], r.originalID)
+
+	var expected []byte
+	if !v.signed {
+		expected = v.tsig.mac(v.prior, body, v.tsig.variables(r.signed, r.fudge, r.err, r.other))
+	} else {
+		timers := appendTime(nil, r.signed)
+		timers = binary.BigEndian.AppendUint16(timers, r.fudge)
+		expected = v.tsig.mac(v.prior, append(v.pending, body...), timers)
+	}
+	if !hmac.Equal(expected, r.mac) {
+		return &TSIGError{Code: tsigBadSig}
+	}
+	now := v.tsig.now().Unix()
+	if diff := now - int64(r.signed); diff > int64(r.fudge) || -diff > int64(r.fudge) {
+		return &TSIGError{Code: tsigBadTime}
+	}
+	v.prior = r.mac
+	v.pending = nil
+	v.unsigned = 0
+	v.signed = true
+	return nil
+}
+
+// done checks that the last message of a transfer was signed
+func (v *verifier) done() error {
+	if v.unsigned > 0 {
+		return errUnsigned
+	}
+	return nil
+}
+
+// TSIGError is a message the server or this client could not verify
+type TSIGError struct {
+	Code uint16
+}
+
+func (e *TSIGError) Error() string {
+	switch e.Code {
+	case tsigBadSig:
+		return "tsig signature does not verify, the key secret or algorithm differ"
+	case tsigBadKey:
+		return "tsig key is not known to the server"
+	case tsigBadTime:
+		return "tsig time is outside the allowed window, the clocks differ"
+	}
+	return fmt.Sprintf("tsig error %d", e.Code)
+}
+
+// tsigRecord is the TSIG record of a message
+type tsigRecord struct {
+	name       string
+	algorithm  string
+	signed     uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	err        uint16
+	other      []byte
+}
+
+// parseTSIG reads the TSIG record rr, a slice of msg
+func parseTSIG(msg, rr []byte) (tsigRecord, error) {
+	start := len(msg) - len(rr)
+	name, off, err := readName(msg, start)
+	if err != nil {
+		return tsigRecord{}, err
+	}
+	if off+10 > len(msg) {
+		return tsigRecord{}, errShort
+	}
+	rdlen := int(binary.BigEndian.Uint16(msg[off+8 : off+10]))
+	off += 10
+	end := off + rdlen
+	if end > len(msg) {
+		return tsigRecord{}, errShort
+	}
+
+	r := tsigRecord{name: name}
+	if r.algorithm, off, err = readName(msg, off); err != nil {
+		return tsigRecord{}, err
+	}
+	if off+10 > end {
+		return tsigRecord{}, errShort
+	}
+	r.signed = uint64(binary.BigEndian.Uint16(msg[off:]))<<32 | uint64(binary.BigEndian.Uint32(msg[off+2:]))
+	r.fudge = binary.BigEndian.Uint16(msg[off+6:])
+	size := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+size+6 > end {
+		return tsigRecord{}, errShort
+	}
+	r.mac = msg[off : off+size]
+	off += size
+	r.originalID = binary.BigEndian.Uint16(msg[off:])
+	r.err = binary.BigEndian.Uint16(msg[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(msg[off+4:]))
+	off += 6
+	if off+otherLen > end {
+		return tsigRecord{}, errShort
+	}
+	r.other = msg[off : off+otherLen]
+	return r, nil
+}
+
+var errShort = errors.New("dns message is truncated")
+
+// splitTSIG splits msg into the message without its TSIG record, with the
+// additional count lowered, and the TSIG record. The record is nil if the
+// last additional record is not a TSIG.
+func splitTSIG(msg []byte) ([]byte, []byte, error) {
+	if len(msg) < 12 {
+		return nil, nil, errShort
+	}
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(msg[4+2*i:]))
+	}
+	if counts[3] == 0 {
+		return msg, nil, nil
+	}
+
+	off := 12
+	var err error
+	for range counts[0] {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, nil, err
+		}
+		off += 4
+	}
+	last, lastType := 0, uint16(0)
+	for range counts[1] + counts[2] + counts[3] {
+		last = off
+		if off, err = skipName(msg, off); err != nil {
+			return nil, nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, nil, errShort
+		}
+		lastType = binary.BigEndian.Uint16(msg[off:])
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+		if off > len(msg) {
+			return nil, nil, errShort
+		}
+	}
+	if lastType != typeTSIG {
+		return msg, nil, nil
+	}
+	body := append([]byte{}, msg[:last]...)
+	binary.BigEndian.PutUint16(body[10:12], uint16(counts[3]-1))
+	return body, msg[last:], nil
+}
+
+// skipName returns the offset after the name at off
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errShort
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&0xc0 == 0xc0:
+			return off + 2, nil
+		}
+		off += n + 1
+	}
+}
+
+// readName reads the name at off, following compression pointers, and
+// returns it fully qualified with the offset after it
+func readName(msg []byte, off int) (string, int, error) {
+	labels := []string{}
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errShort
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 64 {
+				return "", 0, errShort
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+			continue
+		}
+		if off+1+n > len(msg) {
+			return "", 0, errShort
+		}
+		labels = append(labels, string(msg[off+1:off+1+n]))
+		off += n + 1
+	}
+}
+
+// appendName appends name in the canonical wire format, lowercase and
+// uncompressed
+func appendName(b []byte, name string) []byte {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+// appendTime appends a 48 bit time
+func appendTime(b []byte, t uint64) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(t>>32))
+	return binary.BigEndian.AppendUint32(b, uint32(t))
+}
+
+// fqdn returns name with a trailing dot
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
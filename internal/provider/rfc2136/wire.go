@@ -0,0 +1,191 @@
+package rfc2136
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+const (
+	opcodeUpdate = 5
+	classNONE    = 254
+)
+
+// Response codes of dynamic updates (RFC 2136)
+const (
+	rcodeYXDomain dnsmessage.RCode = 6
+	rcodeYXRRSet  dnsmessage.RCode = 7
+	rcodeNXRRSet  dnsmessage.RCode = 8
+	rcodeNotAuth  dnsmessage.RCode = 9
+	rcodeNotZone  dnsmessage.RCode = 10
+)
+
+var rcodeNames = map[dnsmessage.RCode]string{
+	dnsmessage.RCodeFormatError:    "FORMERR",
+	dnsmessage.RCodeServerFailure:  "SERVFAIL",
+	dnsmessage.RCodeNameError:      "NXDOMAIN",
+	dnsmessage.RCodeNotImplemented: "NOTIMP",
+	dnsmessage.RCodeRefused:        "REFUSED",
+	rcodeYXDomain:                  "YXDOMAIN",
+	rcodeYXRRSet:                   "YXRRSET",
+	rcodeNXRRSet:                   "NXRRSET",
+	rcodeNotAuth:                   "NOTAUTH",
+	rcodeNotZone:                   "NOTZONE",
+}
+
+// RcodeError is a response of the server refusing a request. It unwraps to
+// the provider error matching its code.
+type RcodeError struct {
+	Rcode dnsmessage.RCode
+}
+
+func (e *RcodeError) Error() string {
+	if name, ok := rcodeNames[e.Rcode]; ok {
+		return "server answered " + name
+	}
+	return fmt.Sprintf("server answered rcode %d", e.Rcode)
+}
+
+func (e *RcodeError) Unwrap() error {
+	switch e.Rcode {
+	case dnsmessage.RCodeRefused, rcodeNotAuth:
+		return provider.ErrPermission
+	case dnsmessage.RCodeNameError, rcodeNotZone:
+		return provider.ErrNotFound
+	case rcodeYXRRSet:
+		return provider.ErrConflict
+	}
+	return nil
+}
+
+// Unwrap classifies a failed verification as a permission error, the key
+// does not match the one the server knows
+func (e *TSIGError) Unwrap() error {
+	return provider.ErrPermission
+}
+
+// newMessage builds a message of a single question, build adds the records
+func newMessage(header dnsmessage.Header, q dnsmessage.Question, build func(b *dnsmessage.Builder) error) ([]byte, error) {
+	header.ID = uint16(rand.N(1 << 16))
+	b := dnsmessage.NewBuilder(nil, header)
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if build != nil {
+		if err := build(&b); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// exchange sends msg over tcp and passes every response message to handle
+// until it returns false. Responses are verified when requests are signed.
+func (p *Provider) exchange(ctx context.Context, msg []byte, handle func(msg []byte) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	id := binary.BigEndian.Uint16(msg)
+	var verifier *verifier
+	if p.tsig != nil {
+		var mac []byte
+		msg, mac = p.tsig.sign(msg)
+		verifier = p.tsig.verifier(mac)
+	}
+	if err := writeMsg(conn, msg); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := readMsg(conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(resp)
+		if err != nil {
+			return fmt.Errorf("parse response: %w", err)
+		}
+		if header.ID != id {
+			return fmt.Errorf("response id %d does not match request id %d", header.ID, id)
+		}
+		if verifier != nil {
+			if err := verifier.verify(resp); err != nil {
+				// Servers may refuse an unknown key without signing the answer
+				if header.RCode != dnsmessage.RCodeSuccess && errors.Is(err, errUnsigned) {
+					return &RcodeError{Rcode: header.RCode}
+				}
+				return err
+			}
+		}
+		if header.RCode != dnsmessage.RCodeSuccess {
+			return &RcodeError{Rcode: header.RCode}
+		}
+		more, err := handle(resp)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	if verifier != nil {
+		return verifier.done()
+	}
+	return nil
+}
+
+// answers returns the answer records of a response
+func answers(msg []byte) ([]dnsmessage.Resource, error) {
+	var parser dnsmessage.Parser
+	if _, err := parser.Start(msg); err != nil {
+		return nil, err
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	return parser.AllAnswers()
+}
+
+// writeMsg writes a message with the length prefix of dns over tcp
+func writeMsg(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+// readMsg reads a length prefixed message
+func readMsg(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,384 @@
+// Package scaleway manages records through the scaleway domains api. Every
+// change is a PATCH of the zone carrying a list of add, set and delete
+// changes, and records keep a comment.
+package scaleway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+const (
+	defaultBaseURL = "https://api.scaleway.com/domain/v2beta1"
+	pageSize       = 100
+	minTTL         = 60 // scaleway refuses shorter ttls
+)
+
+type Provider struct {
+	baseURL   string
+	secretKey string
+	userAgent string
+	client    *http.Client
+	metrics   *metrics.Metrics
+	zones     []string
+	ids       *provider.IDCache
+}
+
+// New returns a provider for the configured zones, or every zone of the
+// account when none are configured and autoDiscoverZones is set
+func New(cfg config.DNS, metrics *metrics.Metrics) (*Provider, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("scaleway secret key required")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Debug || cfg.DebugBodies {
+		client.Transport = provider.NewDebugTransport(http.DefaultTransport, cfg.DebugBodies, cfg.Token)
+	}
+	p := &Provider{
+		baseURL:   defaultBaseURL,
+		secretKey: cfg.Token,
+		userAgent: cfg.UserAgent,
+		client:    client,
+		metrics:   metrics,
+		zones:     cfg.Zones,
+		ids:       provider.NewIDCache(),
+	}
+	if len(cfg.Zones) == 0 && cfg.AutoDiscoverZones {
+		zones, err := p.listZones(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover zones: %w", err)
+		}
+		p.zones = zones
+		slog.Info("Discovered DNS zones", "count", len(zones))
+	}
+	return p, nil
+}
+
+// Zones returns the names of all zones known to the provider
+func (p *Provider) Zones() []string {
+	zones := append([]string{}, p.zones...)
+	sort.Strings(zones)
+	return zones
+}
+
+// SupportsComments reports that scaleway keeps a comment with every record
+func (p *Provider) SupportsComments() bool {
+	return true
+}
+
+type apiRecord struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Data    string `json:"data"`
+	TTL     int    `json:"ttl"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// change is one entry of a zone PATCH, only one of its fields is set
+type change struct {
+	Add    *addChange    `json:"add,omitempty"`
+	Set    *setChange    `json:"set,omitempty"`
+	Delete *deleteChange `json:"delete,omitempty"`
+}
+
+type addChange struct {
+	Records []apiRecord `json:"records"`
+}
+
+// setChange replaces the record with the id by the given records
+type setChange struct {
+	ID      string      `json:"id"`
+	Records []apiRecord `json:"records"`
+}
+
+type deleteChange struct {
+	ID string `json:"id"`
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	slog.InfoContext(ctx, "Getting DNS records", "zone", zone)
+	start := time.Now()
+
+	records, err := p.listRecords(ctx, zone, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS records: %w", p.fail("read", zone, err))
+	}
+	result := make([]provider.Record, 0, len(records))
+	for _, r := range records {
+		result = append(result, toRecord(r, zone))
+	}
+
+	p.ids.Reset(zone, result)
+	p.metrics.IncDNSRequest("read", zone, true)
+	slog.DebugContext(ctx, "Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
+	return result, nil
+}
+
+// RecordID returns the id of a record created or listed by the provider
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	return p.ids.Get(zone, record)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	c := change{Add: &addChange{Records: []apiRecord{toAPI(record, zone)}}}
+	changed, err := p.patch(ctx, zone, c)
+	if err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", p.fail("create", zone, err))
+	}
+	if len(changed) > 0 {
+		p.ids.Set(zone, record, changed[0].ID)
+	}
+
+	p.metrics.IncDNSRequest("create", zone, true)
+	slog.DebugContext(ctx, "Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	id := record.ID
+	if id == "" {
+		// Without an id the record to replace is the one at the name and type
+		found, err := p.FindRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to update DNS record: %w", err)
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("failed to update DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+		id = found[0].ID
+		// A set replaces the comment too, keep the current one unless given
+		if record.Comment == "" {
+			record.Comment = found[0].Comment
+		}
+	}
+
+	c := change{Set: &setChange{ID: id, Records: []apiRecord{toAPI(record, zone)}}}
+	changed, err := p.patch(ctx, zone, c)
+	if err != nil {
+		return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
+	}
+	if len(changed) > 0 {
+		id = changed[0].ID
+	}
+	p.ids.Set(zone, record, id)
+
+	p.metrics.IncDNSRequest("update", zone, true)
+	slog.DebugContext(ctx, "Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
+	start := time.Now()
+
+	// Records created during this run carry no ID, use the cached one or look it up
+	id := record.ID
+	if id == "" {
+		id, _ = p.ids.Get(zone, record)
+	}
+	if id == "" {
+		found, err := p.FindRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to delete DNS record: %w", err)
+		}
+		for _, r := range found {
+			if provider.SameValue(r, record) {
+				id = r.ID
+				break
+			}
+		}
+		if id == "" {
+			return fmt.Errorf("failed to delete DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+	}
+
+	c := change{Delete: &deleteChange{ID: id}}
+	if _, err := p.patch(ctx, zone, c); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
+	}
+	p.ids.Remove(zone, record)
+
+	p.metrics.IncDNSRequest("delete", zone, true)
+	slog.DebugContext(ctx, "Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+// FindRecords lists the records of a single name and type
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	query := url.Values{"name": {provider.RelativeName(name, zone)}, "type": {recordType}}
+	records, err := p.listRecords(ctx, zone, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find DNS records: %w", p.fail("read", zone, err))
+	}
+	p.metrics.IncDNSRequest("read", zone, true)
+
+	fqdn := provider.FQDN(name, zone)
+	result := []provider.Record{}
+	for _, r := range records {
+		record := toRecord(r, zone)
+		// The name filter also matches the names below it
+		if record.Type != recordType || record.Name != fqdn {
+			continue
+		}
+		p.ids.Set(zone, record, record.ID)
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+// listRecords reads every page of a zone's records matching query
+func (p *Provider) listRecords(ctx context.Context, zone string, query url.Values) ([]apiRecord, error) {
+	all := []apiRecord{}
+	for page := 1; ; page++ {
+		q := url.Values{"page": {fmt.Sprint(page)}, "page_size": {fmt.Sprint(pageSize)}}
+		for k, v := range query {
+			q[k] = v
+		}
+		var resp struct {
+			Records    []apiRecord `json:"records"`
+			TotalCount int         `json:"total_count"`
+		}
+		if err := p.do(ctx, http.MethodGet, "/dns-zones/"+zone+"/records?"+q.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		all = append(all, resp.Records...)
+		if len(resp.Records) == 0 || len(all) >= resp.TotalCount {
+			return all, nil
+		}
+	}
+}
+
+func (p *Provider) listZones(ctx context.Context) ([]string, error) {
+	zones := []string{}
+	for page := 1; ; page++ {
+		var resp struct {
+			Zones []struct {
+				Domain    string `json:"domain"`
+				Subdomain string `json:"subdomain"`
+			} `json:"dns_zones"`
+			TotalCount int `json:"total_count"`
+		}
+		path := fmt.Sprintf("/dns-zones?page=%d&page_size=%d", page, pageSize)
+		if err := p.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, z := range resp.Zones {
+			if z.Subdomain != "" {
+				zones = append(zones, z.Subdomain+"."+z.Domain)
+				continue
+			}
+			zones = append(zones, z.Domain)
+		}
+		if len(resp.Zones) == 0 || (page-1)*pageSize+len(resp.Zones) >= resp.TotalCount {
+			return zones, nil
+		}
+	}
+}
+
+// patch applies changes to a zone and returns the records they touched
+func (p *Provider) patch(ctx context.Context, zone string, changes ...change) ([]apiRecord, error) {
+	body := map[string]any{
+		"changes":                    changes,
+		"return_all_records":         false,
+		"disallow_new_zone_creation": true,
+	}
+	var resp struct {
+		Records []apiRecord `json:"records"`
+	}
+	err := p.do(ctx, http.MethodPatch, "/dns-zones/"+zone+"/records", body, &resp)
+	return resp.Records, err
+}
+
+// do sends body as json and decodes the response into out
+func (p *Provider) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", p.secretKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		return &provider.HTTPError{Provider: "scaleway", Status: resp.StatusCode, Message: apiErr.Message}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// toAPI returns the api fields of a record, TXT data quoted as the zone file
+// syntax scaleway takes
+func toAPI(r provider.Record, zone string) apiRecord {
+	data := r.Data
+	if r.Type == "TXT" {
+		data = provider.QuoteTXT(provider.NormalizeTXT(data))
+	}
+	return apiRecord{
+		Name:    provider.RelativeName(r.Name, zone),
+		Type:    r.Type,
+		Data:    data,
+		TTL:     max(int(r.TTL.Seconds()), minTTL),
+		Comment: r.Comment,
+	}
+}
+
+func toRecord(r apiRecord, zone string) provider.Record {
+	return provider.Record{
+		ID:      r.ID,
+		Name:    provider.FQDN(r.Name, zone),
+		Type:    r.Type,
+		Data:    r.Data,
+		TTL:     time.Duration(r.TTL) * time.Second,
+		Zone:    zone,
+		Comment: r.Comment,
+	}
+}
+
+// fail records a failed request and returns the error
+func (p *Provider) fail(operation, zone string, err error) error {
+	p.metrics.IncDNSRequest(operation, zone, false)
+	p.metrics.IncDNSError(operation, zone, provider.ErrorClass(err))
+	return err
+}
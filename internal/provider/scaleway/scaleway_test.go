@@ -0,0 +1,168 @@
+package scaleway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/providertest"
+)
+
+// fakeAPI serves the scaleway record endpoints of a single zone, a page of
+// two records at a time
+type fakeAPI struct {
+	mu      sync.Mutex
+	records []apiRecord
+	nextID  int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fail := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"message": message})
+	}
+	if r.Header.Get("X-Auth-Token") != "secret" {
+		fail(http.StatusUnauthorized, "authentication is denied")
+		return
+	}
+	if r.URL.Path != "/dns-zones/example.com/records" {
+		fail(http.StatusNotFound, "resource is not found")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		matched := []apiRecord{}
+		for _, rec := range f.records {
+			if q.Has("name") && rec.Name != q.Get("name") || q.Has("type") && rec.Type != q.Get("type") {
+				continue
+			}
+			matched = append(matched, rec)
+		}
+		page, _ := strconv.Atoi(q.Get("page"))
+		start := min((page-1)*2, len(matched))
+		end := min(start+2, len(matched))
+		json.NewEncoder(w).Encode(map[string]any{"records": matched[start:end], "total_count": len(matched)})
+		return
+	}
+
+	var body struct {
+		Changes []change `json:"changes"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	index := func(id string) int {
+		for i, rec := range f.records {
+			if rec.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+	changed := []apiRecord{}
+	for _, c := range body.Changes {
+		var added []apiRecord
+		switch {
+		case c.Add != nil:
+			added = c.Add.Records
+		case c.Set != nil:
+			i := index(c.Set.ID)
+			if i < 0 {
+				fail(http.StatusNotFound, "record is not found")
+				return
+			}
+			f.records = append(f.records[:i], f.records[i+1:]...)
+			added = c.Set.Records
+		case c.Delete != nil:
+			i := index(c.Delete.ID)
+			if i < 0 {
+				fail(http.StatusNotFound, "record is not found")
+				return
+			}
+			f.records = append(f.records[:i], f.records[i+1:]...)
+		}
+		for _, rec := range added {
+			if rec.Type == "TXT" && !strings.HasPrefix(rec.Data, `"`) {
+				fail(http.StatusBadRequest, "TXT data must be quoted")
+				return
+			}
+			f.nextID++
+			rec.ID = fmt.Sprintf("uuid-%d", f.nextID)
+			f.records = append(f.records, rec)
+			changed = append(changed, rec)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"records": changed})
+}
+
+func newTestProvider(t *testing.T, api http.Handler, key string) *Provider {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	p, err := New(config.DNS{Token: key, Zones: []string{"example.com"}}, metrics.New(false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.baseURL = srv.URL
+	return p
+}
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, newTestProvider(t, &fakeAPI{}, "secret"), "example.com")
+}
+
+func TestComments(t *testing.T) {
+	ctx := context.Background()
+	api := &fakeAPI{}
+	p := newTestProvider(t, api, "secret")
+	record := provider.Record{Name: "app.example.com", Type: "A", Data: "192.0.2.1", TTL: time.Minute, Comment: "managed by caddy-dns-sync"}
+	if err := p.CreateRecord(ctx, "example.com", record); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// An update without a comment keeps the one already on the record
+	if err := p.UpdateRecord(ctx, "example.com", provider.Record{Name: "app.example.com", Type: "A", Data: "192.0.2.2", TTL: time.Minute}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	records, err := p.GetRecords(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 1 || records[0].Data != "192.0.2.2" || records[0].Comment != record.Comment {
+		t.Errorf("Expected updated record to keep its comment, got %+v", records)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	api := &fakeAPI{}
+	for i := range 5 {
+		api.records = append(api.records, apiRecord{ID: strconv.Itoa(i), Type: "A", Name: fmt.Sprintf("host%d", i), Data: "192.0.2.1"})
+	}
+	records, err := newTestProvider(t, api, "secret").GetRecords(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 5 || records[4].Name != "host4.example.com" {
+		t.Errorf("Expected every page with fully qualified names, got %+v", records)
+	}
+}
+
+func TestPermissionError(t *testing.T) {
+	_, err := newTestProvider(t, &fakeAPI{}, "wrong").GetRecords(context.Background(), "example.com")
+	if !errors.Is(err, provider.ErrPermission) {
+		t.Errorf("Expected permission error for a bad key, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"strings"
+)
+
+const maxTXTString = 255
+
+// NormalizeTXT returns TXT data as a single unquoted value. Providers return
+// TXT data bare (Cloudflare), quoted (Route53), split into several quoted
+// strings for long values, or with zone file escapes like \" and \044
+// (PowerDNS). Data that is not validly quoted is returned trimmed.
+func NormalizeTXT(data string) string {
+	s := strings.TrimSpace(data)
+	if !strings.HasPrefix(s, `"`) {
+		return s
+	}
+	// A single string without escapes, as most providers return, needs no copy
+	if inner := s[1:]; inner != "" && strings.IndexAny(inner, `"\`) == len(inner)-1 {
+		return inner[:len(inner)-1]
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	i := 0
+	for i < len(s) {
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+		if s[i] != '"' {
+			return s
+		}
+		i++
+		closed := false
+		for i < len(s) && !closed {
+			switch c := s[i]; {
+			case c == '"':
+				closed = true
+				i++
+			case c == '\\' && i+3 < len(s) && isDigits(s[i+1:i+4]):
+				b.WriteByte(byte((int(s[i+1]-'0')*100 + int(s[i+2]-'0')*10 + int(s[i+3]-'0')) & 0xff))
+				i += 4
+			case c == '\\' && i+1 < len(s):
+				b.WriteByte(s[i+1])
+				i += 2
+			default:
+				b.WriteByte(c)
+				i++
+			}
+		}
+		if !closed {
+			return s
+		}
+	}
+	return b.String()
+}
+
+// SameValue reports whether two records of the same type hold the same value,
+// comparing TXT data after normalizing its quoting. Records without an id are
+// deleted by value, so a name holding several TXT records, e.g. SPF and
+// verification records next to a heritage record at the apex, only loses the
+// one with the value asked for.
+func SameValue(a, b Record) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Type == "TXT" {
+		return NormalizeTXT(a.Data) == NormalizeTXT(b.Data)
+	}
+	return a.Data == b.Data
+}
+
+// QuoteTXT renders a value as quoted character strings, split at the 255 byte
+// limit of a single string, with quotes and backslashes escaped
+func QuoteTXT(value string) string {
+	parts := []string{}
+	for {
+		chunk := value
+		if len(chunk) > maxTXTString {
+			chunk = chunk[:maxTXTString]
+		}
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(chunk)
+		parts = append(parts, `"`+escaped+`"`)
+		value = value[len(chunk):]
+		if value == "" {
+			return strings.Join(parts, " ")
+		}
+	}
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTXT(t *testing.T) {
+	heritage := "heritage=caddy-dns-sync,caddy-dns-sync/owner=test"
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{name: "cloudflare bare", data: heritage, expected: heritage},
+		{name: "cloudflare quoted", data: `"` + heritage + `"`, expected: heritage},
+		{name: "route53 quoted", data: `"heritage=caddy-dns-sync,caddy-dns-sync/owner=test"`, expected: heritage},
+		{name: "split strings", data: `"heritage=caddy-dns-sync," "caddy-dns-sync/owner=test"`, expected: heritage},
+		{name: "powerdns decimal escape", data: `"heritage=caddy-dns-sync\044caddy-dns-sync/owner=test"`, expected: heritage},
+		{name: "escaped quote", data: `"say \"hi\""`, expected: `say "hi"`},
+		{name: "escaped backslash", data: `"a\\b"`, expected: `a\b`},
+		{name: "surrounding whitespace", data: "  " + heritage + "\n", expected: heritage},
+		{name: "unterminated quote", data: `"heritage`, expected: `"heritage`},
+		{name: "empty quoted", data: `""`, expected: ""},
+		{name: "lone quote", data: `"`, expected: `"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeTXT(tt.data); got != tt.expected {
+				t.Errorf("NormalizeTXT(%q) = %q, want %q", tt.data, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSameValue(t *testing.T) {
+	heritage := Record{Type: "TXT", Data: "heritage=caddy-dns-sync,caddy-dns-sync/owner=test"}
+	tests := []struct {
+		name     string
+		a, b     Record
+		expected bool
+	}{
+		{name: "quoted heritage", a: heritage, b: Record{Type: "TXT", Data: `"heritage=caddy-dns-sync," "caddy-dns-sync/owner=test"`}, expected: true},
+		{name: "spf", a: heritage, b: Record{Type: "TXT", Data: `"v=spf1 include:_spf.google.com ~all"`}},
+		{name: "verification", a: heritage, b: Record{Type: "TXT", Data: "google-site-verification=abc"}},
+		{name: "empty value", a: heritage, b: Record{Type: "TXT"}},
+		{name: "same address", a: Record{Type: "A", Data: "10.0.0.1"}, b: Record{Type: "A", Data: "10.0.0.1"}, expected: true},
+		{name: "other address", a: Record{Type: "A", Data: "10.0.0.1"}, b: Record{Type: "A", Data: "10.0.0.2"}},
+		{name: "other type", a: Record{Type: "A", Data: "10.0.0.1"}, b: Record{Type: "TXT", Data: "10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameValue(tt.a, tt.b); got != tt.expected {
+				t.Errorf("SameValue(%q, %q) = %v, want %v", tt.a.Data, tt.b.Data, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestQuoteTXT(t *testing.T) {
+	for _, value := range []string{
+		"heritage=caddy-dns-sync,caddy-dns-sync/owner=test",
+		`say "hi" \ bye`,
+		strings.Repeat("a", 600),
+	} {
+		quoted := QuoteTXT(value)
+		if got := NormalizeTXT(quoted); got != value {
+			t.Errorf("NormalizeTXT(QuoteTXT(%q)) = %q", value, got)
+		}
+	}
+	if got := QuoteTXT(strings.Repeat("a", 300)); strings.Count(got, `"`) != 4 {
+		t.Errorf("Expected long value split into two strings, got %q", got)
+	}
+}
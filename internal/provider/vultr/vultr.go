@@ -0,0 +1,338 @@
+// Package vultr manages records through the vultr v2 api. Record names are
+// zone relative and TXT data must be sent quoted.
+package vultr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+const (
+	defaultBaseURL = "https://api.vultr.com/v2"
+	perPage        = 500
+)
+
+type Provider struct {
+	baseURL   string
+	token     string
+	userAgent string
+	client    *http.Client
+	metrics   *metrics.Metrics
+	zones     []string
+	ids       *provider.IDCache
+}
+
+// New returns a provider for the configured zones, or every zone of the
+// account when none are configured and autoDiscoverZones is set
+func New(cfg config.DNS, metrics *metrics.Metrics) (*Provider, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("vultr api key required")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Debug || cfg.DebugBodies {
+		client.Transport = provider.NewDebugTransport(http.DefaultTransport, cfg.DebugBodies, cfg.Token)
+	}
+	p := &Provider{
+		baseURL:   defaultBaseURL,
+		token:     cfg.Token,
+		userAgent: cfg.UserAgent,
+		client:    client,
+		metrics:   metrics,
+		zones:     cfg.Zones,
+		ids:       provider.NewIDCache(),
+	}
+	if len(cfg.Zones) == 0 && cfg.AutoDiscoverZones {
+		zones, err := p.listDomains(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover zones: %w", err)
+		}
+		p.zones = zones
+		slog.Info("Discovered DNS zones", "count", len(zones))
+	}
+	return p, nil
+}
+
+// Zones returns the names of all zones known to the provider
+func (p *Provider) Zones() []string {
+	zones := append([]string{}, p.zones...)
+	sort.Strings(zones)
+	return zones
+}
+
+type apiRecord struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+type meta struct {
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+func (p *Provider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	slog.InfoContext(ctx, "Getting DNS records", "zone", zone)
+	start := time.Now()
+
+	records, err := p.listRecords(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS records: %w", p.fail("read", zone, err))
+	}
+	result := make([]provider.Record, 0, len(records))
+	for _, r := range records {
+		result = append(result, toRecord(r, zone))
+	}
+
+	p.ids.Reset(zone, result)
+	p.metrics.IncDNSRequest("read", zone, true)
+	slog.DebugContext(ctx, "Retrieved DNS records", "zone", zone, "count", len(result), "duration", time.Since(start))
+	return result, nil
+}
+
+// RecordID returns the id of a record created or listed by the provider
+func (p *Provider) RecordID(zone string, record provider.Record) (string, bool) {
+	return p.ids.Get(zone, record)
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Creating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	body := toAPI(record, zone)
+	body.Type = record.Type
+	var resp struct {
+		Record apiRecord `json:"record"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/domains/"+zone+"/records", body, &resp); err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", p.fail("create", zone, err))
+	}
+	p.ids.Set(zone, record, resp.Record.ID)
+
+	p.metrics.IncDNSRequest("create", zone, true)
+	slog.DebugContext(ctx, "Created DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Updating DNS record", "zone", zone, "name", record.Name, "type", record.Type, "data", record.Data)
+	start := time.Now()
+
+	id := record.ID
+	if id == "" {
+		// Without an id the record to replace is the one at the name and type
+		found, err := p.FindRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to update DNS record: %w", err)
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("failed to update DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+		id = found[0].ID
+	}
+
+	if err := p.do(ctx, http.MethodPatch, "/domains/"+zone+"/records/"+id, toAPI(record, zone), nil); err != nil {
+		return fmt.Errorf("failed to update DNS record: %w", p.fail("update", zone, err))
+	}
+	p.ids.Set(zone, record, id)
+
+	p.metrics.IncDNSRequest("update", zone, true)
+	slog.DebugContext(ctx, "Updated DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zone string, record provider.Record) error {
+	slog.InfoContext(ctx, "Deleting DNS record", "zone", zone, "name", record.Name, "type", record.Type)
+	start := time.Now()
+
+	// Records created during this run carry no ID, use the cached one or look it up
+	id := record.ID
+	if id == "" {
+		id, _ = p.ids.Get(zone, record)
+	}
+	if id == "" {
+		found, err := p.FindRecords(ctx, zone, record.Name, record.Type)
+		if err != nil {
+			return fmt.Errorf("failed to delete DNS record: %w", err)
+		}
+		for _, r := range found {
+			if provider.SameValue(r, record) {
+				id = r.ID
+				break
+			}
+		}
+		if id == "" {
+			return fmt.Errorf("failed to delete DNS record %s %s in zone %s: %w", record.Type, record.Name, zone, provider.ErrNotFound)
+		}
+	}
+
+	if err := p.do(ctx, http.MethodDelete, "/domains/"+zone+"/records/"+id, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", p.fail("delete", zone, err))
+	}
+	p.ids.Remove(zone, record)
+
+	p.metrics.IncDNSRequest("delete", zone, true)
+	slog.DebugContext(ctx, "Deleted DNS record", "zone", zone, "name", record.Name, "type", record.Type, "duration", time.Since(start))
+	return nil
+}
+
+// FindRecords lists the records of a single name and type. The api has no
+// lookup by name, so the zone is listed and filtered.
+func (p *Provider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	records, err := p.listRecords(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find DNS records: %w", p.fail("read", zone, err))
+	}
+	p.metrics.IncDNSRequest("read", zone, true)
+
+	fqdn := provider.FQDN(name, zone)
+	result := []provider.Record{}
+	for _, r := range records {
+		record := toRecord(r, zone)
+		if record.Type != recordType || record.Name != fqdn {
+			continue
+		}
+		p.ids.Set(zone, record, record.ID)
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+// listRecords reads every page of a zone's records
+func (p *Provider) listRecords(ctx context.Context, zone string) ([]apiRecord, error) {
+	all := []apiRecord{}
+	cursor := ""
+	for {
+		query := url.Values{"per_page": {fmt.Sprint(perPage)}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		var page struct {
+			Records []apiRecord `json:"records"`
+			Meta    meta        `json:"meta"`
+		}
+		if err := p.do(ctx, http.MethodGet, "/domains/"+zone+"/records?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Records...)
+		if page.Meta.Links.Next == "" {
+			return all, nil
+		}
+		cursor = page.Meta.Links.Next
+	}
+}
+
+func (p *Provider) listDomains(ctx context.Context) ([]string, error) {
+	zones := []string{}
+	cursor := ""
+	for {
+		query := url.Values{"per_page": {fmt.Sprint(perPage)}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		var page struct {
+			Domains []struct {
+				Domain string `json:"domain"`
+			} `json:"domains"`
+			Meta meta `json:"meta"`
+		}
+		if err := p.do(ctx, http.MethodGet, "/domains?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		for _, d := range page.Domains {
+			zones = append(zones, d.Domain)
+		}
+		if page.Meta.Links.Next == "" {
+			return zones, nil
+		}
+		cursor = page.Meta.Links.Next
+	}
+}
+
+// do sends body as json and decodes the response into out, if not nil
+func (p *Provider) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		return &provider.HTTPError{Provider: "vultr", Status: resp.StatusCode, Message: apiErr.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// toAPI returns the api fields of a record, TXT data quoted as vultr expects
+func toAPI(r provider.Record, zone string) apiRecord {
+	data := r.Data
+	if r.Type == "TXT" {
+		data = provider.QuoteTXT(provider.NormalizeTXT(data))
+	}
+	return apiRecord{
+		Name: provider.RelativeName(r.Name, zone),
+		Data: data,
+		TTL:  int(r.TTL.Seconds()),
+	}
+}
+
+func toRecord(r apiRecord, zone string) provider.Record {
+	return provider.Record{
+		ID:   r.ID,
+		Name: provider.FQDN(r.Name, zone),
+		Type: r.Type,
+		Data: r.Data,
+		TTL:  time.Duration(r.TTL) * time.Second,
+		Zone: zone,
+	}
+}
+
+// fail records a failed request and returns the error
+func (p *Provider) fail(operation, zone string, err error) error {
+	p.metrics.IncDNSRequest(operation, zone, false)
+	p.metrics.IncDNSError(operation, zone, provider.ErrorClass(err))
+	return err
+}
@@ -0,0 +1,117 @@
+package vultr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider/providertest"
+)
+
+// fakeAPI serves the vultr record endpoints of a single domain, a page of
+// two records at a time
+type fakeAPI struct {
+	mu      sync.Mutex
+	records []apiRecord
+	nextID  int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]any{"error": "Invalid API token.", "status": 401})
+		return
+	}
+	var body apiRecord
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.Type == "TXT" && !strings.HasPrefix(body.Data, `"`) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"error": "TXT data must be quoted"})
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/domains/example.com/records")
+	id = strings.TrimPrefix(id, "/")
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		end := min(start+2, len(f.records))
+		page := map[string]any{"records": f.records[start:end], "meta": map[string]any{"links": map[string]string{}}}
+		if end < len(f.records) {
+			page["meta"] = map[string]any{"links": map[string]string{"next": strconv.Itoa(end)}}
+		}
+		json.NewEncoder(w).Encode(page)
+	case r.Method == http.MethodPost:
+		f.nextID++
+		body.ID = fmt.Sprintf("uuid-%d", f.nextID)
+		f.records = append(f.records, body)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"record": body})
+	case r.Method == http.MethodPatch || r.Method == http.MethodDelete:
+		for i, rec := range f.records {
+			if rec.ID != id {
+				continue
+			}
+			if r.Method == http.MethodPatch {
+				f.records[i].Name, f.records[i].Data, f.records[i].TTL = body.Name, body.Data, body.TTL
+			} else {
+				f.records = append(f.records[:i], f.records[i+1:]...)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"error": "Record not found."})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestProvider(t *testing.T, api http.Handler, key string) *Provider {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	p, err := New(config.DNS{Token: key, Zones: []string{"example.com"}}, metrics.New(false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.baseURL = srv.URL
+	return p
+}
+
+func TestConformance(t *testing.T) {
+	providertest.Run(t, newTestProvider(t, &fakeAPI{}, "key"), "example.com")
+}
+
+func TestPagination(t *testing.T) {
+	api := &fakeAPI{}
+	for i := range 5 {
+		api.records = append(api.records, apiRecord{ID: strconv.Itoa(i), Type: "A", Name: fmt.Sprintf("host%d", i), Data: "192.0.2.1"})
+	}
+	records, err := newTestProvider(t, api, "key").GetRecords(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 5 || records[4].Name != "host4.example.com" {
+		t.Errorf("Expected every page with fully qualified names, got %+v", records)
+	}
+}
+
+func TestPermissionError(t *testing.T) {
+	_, err := newTestProvider(t, &fakeAPI{}, "wrong").GetRecords(context.Background(), "example.com")
+	if !errors.Is(err, provider.ErrPermission) {
+		t.Errorf("Expected permission error for a bad key, got %v", err)
+	}
+}
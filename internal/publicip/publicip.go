@@ -0,0 +1,120 @@
+package publicip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+)
+
+// maxAnswerSize bounds the answer read from a detection url
+const maxAnswerSize = 1 << 10
+
+// Addresses are the public addresses of this machine, empty if not detected
+type Addresses struct {
+	IPv4 string
+	IPv6 string
+}
+
+// Detector asks plain text services which address this machine reaches them
+// from. Every family is asked over its own network, so a dual-stack machine
+// learns both addresses. A family failing to detect keeps the address it
+// was last detected with.
+type Detector struct {
+	ipv4URL string
+	ipv6URL string
+	agent   string
+	ipv4    *http.Client
+	ipv6    *http.Client
+
+	mu   sync.Mutex
+	last Addresses
+}
+
+func New(cfg config.PublicIP, userAgent string) *Detector {
+	return &Detector{
+		ipv4URL: cfg.IPv4URL,
+		ipv6URL: cfg.IPv6URL,
+		agent:   userAgent,
+		ipv4:    familyClient("tcp4", cfg.Timeout),
+		ipv6:    familyClient("tcp6", cfg.Timeout),
+	}
+}
+
+// familyClient returns a client dialing only over network, tcp4 or tcp6
+func familyClient(network string, timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// Detect returns the current public addresses, failing only when neither
+// family has ever been detected
+func (d *Detector) Detect(ctx context.Context) (Addresses, error) {
+	ipv4, err4 := d.detect(ctx, d.ipv4, d.ipv4URL, netip.Addr.Is4)
+	ipv6, err6 := d.detect(ctx, d.ipv6, d.ipv6URL, func(a netip.Addr) bool { return a.Is6() && !a.Is4In6() })
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err4 == nil {
+		d.last.IPv4 = ipv4
+	} else if d.last.IPv4 != "" {
+		slog.WarnContext(ctx, "Failed to detect public IPv4, keeping the last one", "ipv4", d.last.IPv4, "error", err4)
+	}
+	if err6 == nil {
+		d.last.IPv6 = ipv6
+	} else if d.last.IPv6 != "" {
+		slog.WarnContext(ctx, "Failed to detect public IPv6, keeping the last one", "ipv6", d.last.IPv6, "error", err6)
+	}
+	if d.last == (Addresses{}) {
+		return Addresses{}, fmt.Errorf("detect public ip: %w", errors.Join(err4, err6))
+	}
+	return d.last, nil
+}
+
+// detect asks url for the address client reaches it from, which must be of
+// the family family accepts
+func (d *Detector) detect(ctx context.Context, client *http.Client, url string, family func(netip.Addr) bool) (string, error) {
+	if url == "" {
+		return "", errors.New("no detection url")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	if d.agent != "" {
+		req.Header.Set("User-Agent", d.agent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAnswerSize))
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", url, err)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", url, err)
+	}
+	if !family(addr) || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return "", fmt.Errorf("%s answered %s, not a public address of the family asked for", url, addr)
+	}
+	return addr.String(), nil
+}
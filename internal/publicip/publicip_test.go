@@ -0,0 +1,42 @@
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+)
+
+func TestDetect(t *testing.T) {
+	answer := "203.0.113.7\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, answer)
+	}))
+	defer server.Close()
+
+	// The IPv6 url is reached over tcp6 only, which the server does not listen on
+	d := New(config.PublicIP{IPv4URL: server.URL, IPv6URL: server.URL, Timeout: time.Second}, "test")
+	ctx := context.Background()
+	addrs, err := d.Detect(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if addrs != (Addresses{IPv4: "203.0.113.7"}) {
+		t.Errorf("Expected the IPv4 detected, got %+v", addrs)
+	}
+
+	// A failed detection keeps the last address
+	answer = "192.168.1.2"
+	if addrs, err := d.Detect(ctx); err != nil || addrs.IPv4 != "203.0.113.7" {
+		t.Errorf("Expected the last IPv4 kept, got %+v, %v", addrs, err)
+	}
+
+	fresh := New(config.PublicIP{IPv4URL: server.URL, Timeout: time.Second}, "test")
+	if _, err := fresh.Detect(ctx); err == nil {
+		t.Error("Expected an error with no address ever detected")
+	}
+}
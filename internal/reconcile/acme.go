@@ -0,0 +1,38 @@
+package reconcile
+
+import (
+	"context"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// acmeChallengeLabel names the TXT records of ACME dns-01 challenges
+const acmeChallengeLabel = "_acme-challenge"
+
+// isACMEChallenge reports whether a record name, relative to its zone or
+// not, holds ACME dns-01 challenges. Caddy's dns plugins create and remove
+// these in the same zones, they are never read, planned or deleted.
+func isACMEChallenge(name string) bool {
+	return name == acmeChallengeLabel || strings.HasPrefix(name, acmeChallengeLabel+".")
+}
+
+// withoutACMEChallenges drops ACME challenge records from records of zone
+func withoutACMEChallenges(records []provider.Record, zone string) []provider.Record {
+	kept := make([]provider.Record, 0, len(records))
+	for _, r := range records {
+		if !isACMEChallenge(getRecordName(r.Name, zone)) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// zoneRecords returns the records of zone the engine may consider
+func (e *engine) zoneRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	records, err := e.dnsProvider.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	return withoutACMEChallenges(records, zone), nil
+}
@@ -0,0 +1,126 @@
+package reconcile
+
+import (
+	"slices"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+const (
+	ReasonAlternate        = "healthy upstream published besides the first"
+	ReasonAlternateRemoved = "upstream no longer published"
+)
+
+// alternateRecords returns the A records published besides main for the
+// alternates of a host, its further healthy upstreams with an IPv4 address.
+// Only a main A record holding the host's own upstream gets them, a target
+// or canonical name is the same whichever upstream is healthy.
+func alternateRecords(main provider.Record, upstream string, alternates []string) []provider.Record {
+	if len(alternates) == 0 || main.Type != "A" || main.Data != extractHostFromUpstream(upstream) {
+		return nil
+	}
+	records := make([]provider.Record, 0, len(alternates))
+	seen := map[string]bool{main.Data: true}
+	for _, alternate := range alternates {
+		data := extractHostFromUpstream(alternate)
+		if seen[data] || getRecordType(data) != "A" {
+			continue
+		}
+		seen[data] = true
+		record := main
+		record.ID = ""
+		record.Data = data
+		records = append(records, record)
+	}
+	return records
+}
+
+// planAlternates plans the A records of the alternates of the added hosts of
+// zone the plan brings in line. The main record settles ownership of the
+// name, of the other A records at it only those holding an address the host
+// was published with before are removed.
+func (e *engine) planAlternates(plan *Plan, index *zoneIndex, zone string, changes state.StateChanges) {
+	for _, domain := range changes.Added {
+		if !e.inZone(domain.Host, zone) {
+			continue
+		}
+		main, applied := plan.Applies[domain.Host]
+		if !applied || main.Type != "A" {
+			continue
+		}
+		name := getRecordName(domain.Host, zone)
+		desired := alternateRecords(main, domain.Upstream, domain.Alternates)
+		records := index.lookup(name, "A")
+		if len(desired) == 0 && len(records) < 2 {
+			continue
+		}
+
+		// A records the main record's plan leaves alone
+		existing := slices.DeleteFunc(slices.Clone(records), func(r provider.Record) bool {
+			return r.Data == main.Data || plan.replaces(r)
+		})
+		for _, want := range desired {
+			matched := slices.IndexFunc(existing, func(r provider.Record) bool {
+				return r.Data == want.Data
+			})
+			if matched < 0 {
+				plan.addCreate(want, ReasonAlternate)
+				e.metrics.IncDNSOperation("create", zone, want.Type)
+				continue
+			}
+			r := existing[matched]
+			existing = slices.Delete(existing, matched, matched+1)
+			switch {
+			case e.matchesAttributes(domain.Host, zone, r):
+				plan.keep(r)
+			case r.ID != "":
+				plan.addUpdate(want, r, ReasonAlternate)
+				e.metrics.IncDNSOperation("update", zone, want.Type)
+			default:
+				plan.addReplace(r, ReasonDataMismatch)
+				e.metrics.IncDNSOperation("delete", zone, r.Type)
+				plan.addCreate(want, ReasonAlternate)
+				e.metrics.IncDNSOperation("create", zone, want.Type)
+			}
+		}
+
+		published := make(map[string]bool)
+		for _, upstream := range append([]string{changes.Previous[domain.Host]}, changes.PreviousAlternates[domain.Host]...) {
+			published[extractHostFromUpstream(upstream)] = true
+		}
+		for _, r := range existing {
+			if published[r.Data] {
+				plan.addDelete(r, ReasonAlternateRemoved)
+				e.metrics.IncDNSOperation("delete", zone, r.Type)
+			}
+		}
+	}
+}
+
+// ownsAlternates reports whether name holds A records, the alternates of a
+// host, owned in the configured ownership mode and current heritage format
+func (e *engine) ownsAlternates(index *zoneIndex, name string, txt provider.Record, txtExists bool, ownerTXT string) bool {
+	records := index.lookup(name, "A")
+	if len(records) == 0 {
+		return false
+	}
+	if e.useComments {
+		return !txtExists && !slices.ContainsFunc(records, func(r provider.Record) bool {
+			return r.Comment != ownerTXT
+		})
+	}
+	return txtExists && provider.NormalizeTXT(txt.Data) == ownerTXT
+}
+
+// planAlternatesDelete plans deleting the A records left at name, those of
+// the alternates of a removed host, whose main record is already deleted
+func (e *engine) planAlternatesDelete(plan *Plan, index *zoneIndex, name, reason string) {
+	for _, r := range index.lookup(name, "A") {
+		if slices.Contains(plan.Delete, r) {
+			continue
+		}
+		plan.addDelete(r, reason)
+		e.metrics.IncDNSOperation("delete", r.Zone, r.Type)
+	}
+}
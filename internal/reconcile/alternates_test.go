@@ -0,0 +1,102 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+func TestAlternates(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	ctx := context.Background()
+
+	// The zone takes what was written
+	next := 0
+	apply := func() {
+		for _, r := range dp.created {
+			r.ID = fmt.Sprintf("id-%d", next)
+			next++
+			dp.records["example.com"] = append(dp.records["example.com"], r)
+		}
+		dp.records["example.com"] = slices.DeleteFunc(dp.records["example.com"], func(r provider.Record) bool {
+			return slices.ContainsFunc(dp.deleted, func(d provider.Record) bool { return d.ID == r.ID })
+		})
+		dp.created, dp.updated, dp.deleted = nil, nil, nil
+	}
+	addresses := func(records []provider.Record) []string {
+		var data []string
+		for _, r := range records {
+			if r.Type == "A" {
+				data = append(data, r.Data)
+			}
+		}
+		slices.Sort(data)
+		return data
+	}
+
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080", Alternates: []string{"10.0.0.2:8080", "10.0.0.3:8080", "backend:8080"}}}
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := addresses(dp.created); !slices.Equal(got, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}) || len(dp.created) != 4 {
+		t.Fatalf("Expected an A record per upstream address and the TXT record, got %+v", dp.created)
+	}
+	if d := stateManager.state.Domains["app.example.com"]; len(d.Alternates) != 3 || len(d.Records) != 4 {
+		t.Errorf("Expected the alternates and their records in state, got %+v", d)
+	}
+	apply()
+
+	// The first upstream turns unhealthy, only its record goes
+	domains[0].Upstream, domains[0].Alternates = "10.0.0.2:8080", []string{"10.0.0.3:8080"}
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dp.created) != 0 || len(dp.updated) != 0 || !slices.Equal(addresses(dp.deleted), []string{"10.0.0.1"}) {
+		t.Fatalf("Expected only the A record of the unhealthy upstream deleted, got created %+v updated %+v deleted %+v", dp.created, dp.updated, dp.deleted)
+	}
+	apply()
+
+	// It recovers
+	domains[0].Upstream, domains[0].Alternates = "10.0.0.1:8080", []string{"10.0.0.2:8080", "10.0.0.3:8080"}
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dp.updated) != 0 || len(dp.deleted) != 0 || !slices.Equal(addresses(dp.created), []string{"10.0.0.1"}) {
+		t.Fatalf("Expected only the A record of the recovered upstream created, got created %+v updated %+v deleted %+v", dp.created, dp.updated, dp.deleted)
+	}
+	apply()
+
+	// Removed hosts lose every address record
+	if _, err := engine.Reconcile(ctx, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := addresses(dp.deleted); !slices.Equal(got, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}) {
+		t.Errorf("Expected every A record of the host deleted, got %+v", dp.deleted)
+	}
+}
+
+func TestAlternateRecords(t *testing.T) {
+	main := provider.Record{Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"}
+	records := alternateRecords(main, "10.0.0.1:8080", []string{"10.0.0.1:9090", "[2001:db8::1]:8080", "backend:8080", "10.0.0.2:8080"})
+	if len(records) != 1 || records[0].Data != "10.0.0.2" || records[0].Type != "A" {
+		t.Errorf("Expected only the other IPv4 upstream, got %+v", records)
+	}
+	// A target replaces every upstream
+	target := provider.Record{Name: "app", Type: "A", Data: "203.0.113.7", Zone: "example.com"}
+	if records := alternateRecords(target, "10.0.0.1:8080", []string{"10.0.0.2:8080"}); records != nil {
+		t.Errorf("Expected no alternates with a target, got %+v", records)
+	}
+}
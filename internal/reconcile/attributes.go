@@ -0,0 +1,170 @@
+package reconcile
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+)
+
+const defaultTTL = 3600 // TODO: This should be configurable
+
+// internalTTL is the default ttl of internal zones, where records change
+// with the lab and no resolver outside needs caching
+const internalTTL = time.Minute
+
+type hostPattern struct {
+	pattern string
+	attrs   config.HostAttributes
+}
+
+// sortedPatterns orders host attribute patterns from least to most specific,
+// so merging in order lets specific patterns override broad ones
+func sortedPatterns(attributes map[string]config.HostAttributes) []hostPattern {
+	patterns := make([]hostPattern, 0, len(attributes))
+	for pattern, attrs := range attributes {
+		patterns = append(patterns, hostPattern{pattern: pattern, attrs: attrs})
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i].pattern) != len(patterns[j].pattern) {
+			return len(patterns[i].pattern) < len(patterns[j].pattern)
+		}
+		return patterns[i].pattern < patterns[j].pattern
+	})
+	return patterns
+}
+
+// zoneAttributes returns the attributes every record of zone gets, from its
+// settings over the defaults of its visibility: internal zones use a short
+// ttl, public zones are proxied
+func (e *engine) zoneAttributes(zone string) config.HostAttributes {
+	settings := e.zoneSettings[zone]
+	var attrs config.HostAttributes
+	switch settings.Visibility {
+	case config.VisibilityInternal:
+		attrs.TTL = internalTTL
+	case config.VisibilityPublic:
+		proxied := true
+		attrs.Proxied = &proxied
+	}
+	if settings.Proxied != nil {
+		attrs.Proxied = settings.Proxied
+	}
+	if settings.TTL > 0 {
+		attrs.TTL = settings.TTL
+	}
+	attrs.Target = settings.Target
+	return attrs
+}
+
+// attributesFor merges the attributes of zone with those of every pattern
+// matching host, host patterns being more specific
+func (e *engine) attributesFor(host, zone string) config.HostAttributes {
+	merged := e.zoneAttributes(zone)
+	for _, p := range e.hostPatterns {
+		if ok, _ := path.Match(p.pattern, host); !ok {
+			continue
+		}
+		if p.attrs.Proxied != nil {
+			merged.Proxied = p.attrs.Proxied
+		}
+		if p.attrs.TTL > 0 {
+			merged.TTL = p.attrs.TTL
+		}
+		if p.attrs.Type != "" {
+			merged.Type = p.attrs.Type
+		}
+		if p.attrs.Target != "" {
+			merged.Target = p.attrs.Target
+		}
+	}
+	return merged
+}
+
+// desiredRecord builds the main record for a host, applying zone and host
+// attributes over the values derived from the caddy upstream
+func (e *engine) desiredRecord(d source.DomainConfig, zone string) provider.Record {
+	host := d.Host
+	attrs := e.attributesFor(host, zone)
+	data := extractHostFromUpstream(d.Upstream)
+	if attrs.Target != "" {
+		data = attrs.Target
+	}
+	recordType := attrs.Type
+	if recordType == "" {
+		recordType = getRecordType(data)
+	}
+	if alias := e.aliasOf(host, zone, recordType, data); alias != "" {
+		recordType, data = "CNAME", alias
+	}
+	ttl := time.Duration(defaultTTL)
+	if attrs.TTL > 0 {
+		ttl = attrs.TTL
+	}
+	record := provider.Record{
+		Name: getRecordName(host, zone),
+		Type: recordType,
+		Data: data,
+		TTL:  ttl,
+		Zone: zone,
+	}
+	if attrs.Proxied != nil {
+		record.Proxied = *attrs.Proxied
+	}
+	if e.useComments {
+		record.Comment = e.heritageData(d.Source)
+	}
+	return record
+}
+
+// heritageRecord builds the ownership TXT record holding data for a main record
+func heritageRecord(main provider.Record, data string) provider.Record {
+	return provider.Record{
+		Name: main.Name,
+		Type: "TXT",
+		Data: data,
+		TTL:  main.TTL,
+		Zone: main.Zone,
+	}
+}
+
+// DesiredRecords returns the records caddy hosts should have, by zone. Each
+// host's main record is followed by the records of its alternates and its
+// heritage TXT record unless ownership is stored in comments, protected
+// hosts and hosts outside the configured zones are left out.
+func (e *engine) DesiredRecords(domains []source.DomainConfig) map[string][]provider.Record {
+	domains, _ = normalizeDomains(domains)
+	domains = e.withCanonical(domains)
+	desired := make(map[string][]provider.Record, len(e.zones))
+	for _, zone := range e.zones {
+		desired[zone] = []provider.Record{}
+		for _, d := range domains {
+			if !e.inZone(d.Host, zone) || e.isProtected(d.Host) {
+				continue
+			}
+			main := e.desiredRecord(d, zone)
+			desired[zone] = append(desired[zone], main)
+			desired[zone] = append(desired[zone], alternateRecords(main, d.Upstream, d.Alternates)...)
+			if !e.useComments {
+				desired[zone] = append(desired[zone], heritageRecord(main, e.heritageData(d.Source)))
+			}
+		}
+	}
+	return desired
+}
+
+// matchesAttributes reports whether an existing record already carries the
+// attributes explicitly configured for host and its zone
+func (e *engine) matchesAttributes(host, zone string, existing provider.Record) bool {
+	attrs := e.attributesFor(host, zone)
+	if attrs.Proxied != nil && existing.Proxied != *attrs.Proxied {
+		return false
+	}
+	if attrs.TTL > 0 && existing.TTL != attrs.TTL {
+		return false
+	}
+	return true
+}
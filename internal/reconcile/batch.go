@@ -0,0 +1,68 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// executeBatch applies the records of a group in a single request when the
+// provider batches changes, sparing providers with tight quotas a request per
+// record. Returns false when the group is left to executeGroup: single
+// records, creates looked up first, and batches failing with not found or
+// conflict, which are handled record by record. A failed batch changed
+// nothing, so there is nothing to revert.
+func (e *engine) executeBatch(ctx context.Context, group RecordGroup, results *Results) bool {
+	batcher, ok := e.dnsProvider.(provider.Batcher)
+	if !ok || !batcher.SupportsBatch() || len(group.Records) < 2 {
+		return false
+	}
+	if group.Op == "create" && e.cfg.Reconcile.CheckBeforeCreate {
+		return false
+	}
+
+	changes := make([]provider.Change, 0, len(group.Records))
+	for _, record := range group.Records {
+		changes = append(changes, provider.Change{Op: group.Op, Record: record})
+	}
+	slog.DebugContext(ctx, "Start execute batch from plan", "op", group.Op, "zone", group.Zone, "name", group.Name, "records", len(changes))
+	err := e.retryRateLimited(ctx, group.Op, group.Name, func() error {
+		return batcher.ApplyChanges(ctx, group.Zone, changes)
+	})
+	if errors.Is(err, provider.ErrNotFound) || errors.Is(err, provider.ErrConflict) {
+		slog.InfoContext(ctx, "Batch not applied, applying records one by one", "op", group.Op, "zone", group.Zone, "name", group.Name, "error", err)
+		return false
+	}
+
+	status := GroupApplied
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to execute record batch", "op", group.Op, "zone", group.Zone, "name", group.Name, "error", err)
+		results.Failures = append(results.Failures, OperationResult{
+			Record: group.Records[0],
+			Op:     group.Op,
+			Error:  err.Error(),
+			Class:  provider.ErrorClass(err),
+		})
+		status = GroupRolledBack
+		slog.WarnContext(ctx, "Record group not applied", "op", group.Op, "zone", group.Zone, "name", group.Name, "status", status)
+	} else {
+		for _, record := range group.Records {
+			if group.Op == "create" {
+				if id, ok := e.createdID(ctx, record); ok {
+					results.setID(record, id)
+				}
+			}
+			e.journalApplied(ctx, group.Op, record)
+			e.collect(group.Op, record, results)
+		}
+	}
+	results.Groups = append(results.Groups, GroupResult{
+		Op:     group.Op,
+		Zone:   group.Zone,
+		Name:   group.Name,
+		Status: status,
+	})
+	return true
+}
@@ -0,0 +1,94 @@
+package reconcile
+
+import (
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// canonicalSource is the source of canonical hosts in state and audit
+const canonicalSource = "canonical"
+
+// withCanonical adds the canonical name of every zone that has one as a host
+// holding the zone target. Hosts of the zone resolving to the target point at
+// it by CNAME instead, so a new target is a single record update. A canonical
+// name also served by caddy is left to caddy.
+func (e *engine) withCanonical(domains []source.DomainConfig) []source.DomainConfig {
+	served := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		served[d.Host] = true
+	}
+	for _, zone := range e.zones {
+		settings := e.zoneSettings[zone]
+		if settings.Canonical == "" || settings.Target == "" || served[settings.Canonical] {
+			continue
+		}
+		domains = append(domains, source.DomainConfig{
+			Host:     settings.Canonical,
+			Upstream: settings.Target,
+			Source:   canonicalSource,
+		})
+	}
+	return domains
+}
+
+// aliasOf returns the canonical name a host resolving to data of recordType
+// points at, empty if it keeps its own record
+func (e *engine) aliasOf(host, zone, recordType, data string) string {
+	settings := e.zoneSettings[zone]
+	if settings.Canonical == "" || host == settings.Canonical || data != settings.Target {
+		return ""
+	}
+	if recordType != "A" && recordType != "AAAA" {
+		return ""
+	}
+	return settings.Canonical
+}
+
+// realiasHosts adds the unchanged hosts whose records were applied through a
+// canonical name they should no longer use, or that should now use one, to
+// the added hosts of changes. Canonical names are those configured and those
+// of canonical hosts in previous, so hosts are moved off a canonical name
+// being removed.
+func (e *engine) realiasHosts(changes *state.StateChanges, current, previous state.State) map[string]bool {
+	canonical := make(map[string]bool)
+	for host, d := range previous.Domains {
+		if d.Source == canonicalSource {
+			canonical[host] = true
+		}
+	}
+	for _, settings := range e.zoneSettings {
+		if settings.Canonical != "" {
+			canonical[settings.Canonical] = true
+		}
+	}
+	if len(canonical) == 0 {
+		return nil
+	}
+
+	added := make(map[string]bool, len(changes.Added))
+	for _, d := range changes.Added {
+		added[d.Host] = true
+	}
+	realiased := make(map[string]bool)
+	for host, d := range current.Domains {
+		if added[host] || d.LastApplied == 0 || d.Source == canonicalSource {
+			continue
+		}
+		zone, ok := e.zoneOf(host)
+		if !ok || e.isProtected(host) {
+			continue
+		}
+		domain := source.DomainConfig{Host: host, Upstream: d.ServerName, Source: d.Source, IPv6: d.IPv6, Alternates: d.Alternates}
+		desired := e.desiredRecord(domain, zone)
+		if desired.Type == d.AppliedType && desired.Data == d.AppliedData {
+			continue
+		}
+		aliased := d.AppliedType == "CNAME" && canonical[d.AppliedData]
+		if aliased == (desired.Type == "CNAME" && canonical[desired.Data]) {
+			continue
+		}
+		changes.Added = append(changes.Added, domain)
+		realiased[host] = true
+	}
+	return realiased
+}
@@ -0,0 +1,238 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+const (
+	ConsistencyMatched           = "matched"             // caddy, state and the live record agree
+	ConsistencyMissingAtProvider = "missing_at_provider" // a host in caddy or state has no live record
+	ConsistencyMissingInState    = "missing_in_state"    // a live record of a caddy host or owned by us is not in state
+	ConsistencyDataMismatch      = "data_mismatch"       // the live record differs from caddy or what state says was applied, or caddy dropped its host
+)
+
+var consistencyKinds = []string{ConsistencyMatched, ConsistencyMissingAtProvider, ConsistencyMissingInState, ConsistencyDataMismatch}
+
+// ConsistencyEntry is a host whose caddy config, state and live record do
+// not agree
+type ConsistencyEntry struct {
+	Kind     string `json:"kind"`
+	Zone     string `json:"zone"`
+	Host     string `json:"host"`
+	Type     string `json:"type,omitempty"`
+	Caddy    string `json:"caddy,omitempty"`    // desired data, empty if the host is not in caddy
+	State    string `json:"state,omitempty"`    // data state says was applied
+	Provider string `json:"provider,omitempty"` // live data
+}
+
+// Consistency is the report of the latest cross check of caddy, state and
+// the live zones, an fsck of the sync
+type Consistency struct {
+	Checked int64              `json:"checked"` // unix time of the check, zero if never run
+	Counts  map[string]int     `json:"counts"`  // hosts by kind
+	Entries []ConsistencyEntry `json:"entries"` // every host not matched
+}
+
+// Consistency returns the report of the latest consistency check of every
+// zone, the zones of each sync schedule are checked on their own
+func (e *engine) Consistency() Consistency {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return mergeConsistency(e.consistency)
+}
+
+// VerifyConsistency cross checks the desired records of domains, state and
+// the live zones of partition p, every zone if nil, reading only. Hosts
+// refused for their target are never published, so they are left out.
+func (e *engine) VerifyConsistency(ctx context.Context, domains []source.DomainConfig, p *Partition) (Consistency, error) {
+	var scope Scope
+	if p != nil {
+		partition, err := e.normalizePartition(*p)
+		if err != nil {
+			return Consistency{}, err
+		}
+		scope.partition = &partition
+	}
+	domains, _, _, err := e.desiredDomains(ctx, domains)
+	var zones map[string]Consistency
+	if err == nil {
+		zones, err = e.checkConsistency(ctx, domains, e.privateTargets(ctx, domains), scope)
+	}
+	e.metrics.IncConsistencyCheck(err == nil)
+	if err != nil {
+		return Consistency{}, err
+	}
+	e.mu.Lock()
+	if e.consistency == nil {
+		e.consistency = make(map[string]Consistency, len(e.zones))
+	}
+	for zone, report := range zones {
+		e.consistency[zone] = report
+	}
+	e.mu.Unlock()
+
+	report := mergeConsistency(zones)
+	args := []any{}
+	for _, kind := range consistencyKinds {
+		args = append(args, kind, report.Counts[kind])
+	}
+	if len(report.Entries) > 0 {
+		slog.WarnContext(ctx, "Consistency check found hosts out of line", args...)
+		for _, entry := range report.Entries {
+			slog.DebugContext(ctx, "Inconsistent host", "kind", entry.Kind, "host", entry.Host, "type", entry.Type, "caddy", entry.Caddy, "state", entry.State, "provider", entry.Provider)
+		}
+	} else {
+		slog.InfoContext(ctx, "Consistency check found caddy, state and the live zones in line", args...)
+	}
+	return report, nil
+}
+
+// mergeConsistency joins the reports of zones into one, checked when the
+// latest of them was
+func mergeConsistency(zones map[string]Consistency) Consistency {
+	report := Consistency{Counts: make(map[string]int, len(consistencyKinds)), Entries: []ConsistencyEntry{}}
+	names := make([]string, 0, len(zones))
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+	for _, zone := range names {
+		z := zones[zone]
+		report.Checked = max(report.Checked, z.Checked)
+		for kind, count := range z.Counts {
+			report.Counts[kind] += count
+		}
+		report.Entries = append(report.Entries, z.Entries...)
+	}
+	return report
+}
+
+// checkConsistency compares every host of caddy, of state and with an owned
+// live record three ways in each zone scope covers, returning the report of
+// each zone
+func (e *engine) checkConsistency(ctx context.Context, domains []source.DomainConfig, refused map[string]bool, scope Scope) (map[string]Consistency, error) {
+	st, err := e.stateManager.LoadState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+
+	zones := make(map[string]Consistency)
+	for _, zone := range e.zones {
+		if !scope.covers(zone) {
+			continue
+		}
+		records, err := e.zoneRecords(ctx, zone)
+		if err != nil {
+			return nil, fmt.Errorf("get records for zone %s: %w", zone, err)
+		}
+		// Live records by record name, and the names owned by us
+		live := make(map[string][]provider.Record)
+		owned := make(map[string]bool)
+		for _, r := range records {
+			name := getRecordName(r.Name, zone)
+			switch r.Type {
+			case "A", "AAAA", "CNAME":
+				live[name] = append(live[name], r)
+				if ownsComment(r, e.owners) {
+					owned[name] = true
+				}
+			case "TXT":
+				if ownsHeritage(r.Data, e.owners) {
+					owned[name] = true
+				}
+			}
+		}
+
+		desired := make(map[string]provider.Record)
+		for _, d := range domains {
+			if e.inZone(d.Host, zone) && !refused[d.Host] && !e.isProtected(d.Host) {
+				desired[d.Host] = e.desiredRecord(d, zone)
+			}
+		}
+		hosts := make(map[string]bool)
+		for host := range desired {
+			hosts[host] = true
+		}
+		for host := range st.Domains {
+			if e.inZone(host, zone) && !e.isProtected(host) {
+				hosts[host] = true
+			}
+		}
+		for name := range owned {
+			host := recordHost(provider.Record{Name: name, Zone: zone})
+			if len(live[name]) > 0 && e.inZone(host, zone) && !e.isProtected(host) {
+				hosts[host] = true
+			}
+		}
+
+		report := Consistency{Counts: make(map[string]int, len(consistencyKinds)), Entries: []ConsistencyEntry{}}
+		for _, kind := range consistencyKinds {
+			report.Counts[kind] = 0
+		}
+		for host := range hosts {
+			want, inCaddy := desired[host]
+			applied, inState := st.Domains[host]
+			entry := e.compareHost(zone, host, want, inCaddy, applied, inState, live[getRecordName(host, zone)])
+			report.Counts[entry.Kind]++
+			if entry.Kind != ConsistencyMatched {
+				report.Entries = append(report.Entries, entry)
+			}
+		}
+		for _, kind := range consistencyKinds {
+			e.metrics.SetConsistency(zone, kind, report.Counts[kind])
+		}
+		sort.Slice(report.Entries, func(i, j int) bool { return report.Entries[i].Host < report.Entries[j].Host })
+		report.Checked = e.now().Unix()
+		zones[zone] = report
+	}
+	return zones, nil
+}
+
+// compareHost classifies the live records of host against want, its desired
+// record if in caddy, and the record state says was applied if in state
+func (e *engine) compareHost(zone, host string, want provider.Record, inCaddy bool, applied state.DomainState, inState bool, live []provider.Record) ConsistencyEntry {
+	entry := ConsistencyEntry{Zone: zone, Host: host, Type: applied.AppliedType, State: applied.AppliedData}
+	if inCaddy {
+		entry.Type, entry.Caddy = want.Type, want.Data
+	}
+	if len(live) == 0 {
+		entry.Kind = ConsistencyMissingAtProvider
+		return entry
+	}
+	// The live record of the expected type, an AAAA or the A records of
+	// alternates may sit beside the main record
+	got := live[0]
+	for _, r := range live {
+		if r.Type == entry.Type {
+			got = r
+			if r.Data == want.Data || r.Data == applied.AppliedData {
+				break
+			}
+		}
+	}
+	if entry.Type == "" {
+		entry.Type = got.Type
+	}
+	entry.Provider = got.Data
+	switch {
+	case !inState:
+		entry.Kind = ConsistencyMissingInState
+	case !inCaddy:
+		// Gone from caddy, its live record is due for removal
+		entry.Kind = ConsistencyDataMismatch
+	case inCaddy && (got.Type != want.Type || got.Data != want.Data || !e.matchesAttributes(host, zone, got)):
+		entry.Kind = ConsistencyDataMismatch
+	case applied.AppliedData != "" && (got.Type != applied.AppliedType || got.Data != applied.AppliedData):
+		entry.Kind = ConsistencyDataMismatch
+	default:
+		entry.Kind = ConsistencyMatched
+	}
+	return entry
+}
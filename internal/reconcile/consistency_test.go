@@ -0,0 +1,109 @@
+package reconcile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	heritage := HeritageData("test-owner")
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"a.example.com": {ServerName: "10.0.0.1:8080", AppliedType: "A", AppliedData: "10.0.0.1"},
+		"b.example.com": {ServerName: "10.0.0.2:8080", AppliedType: "A", AppliedData: "10.0.0.2"},
+		"d.example.com": {ServerName: "10.0.0.4:8080", AppliedType: "A", AppliedData: "10.0.0.4"},
+		// Gone from caddy, still in state and live
+		"g.example.com": {ServerName: "10.0.0.7:8080", AppliedType: "A", AppliedData: "10.0.0.7"},
+	}}}
+	dp := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			{ID: "a", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+			{ID: "b", Name: "b.example.com", Type: "A", Data: "10.0.0.9", Zone: "example.com"},
+			{ID: "b-txt", Name: "b.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+			{ID: "c", Name: "c.example.com", Type: "A", Data: "10.0.0.3", Zone: "example.com"},
+			{ID: "e", Name: "e.example.com", Type: "A", Data: "10.0.0.5", Zone: "example.com"},
+			{ID: "e-txt", Name: "e.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+			// Neither in caddy, state nor owned
+			{ID: "f", Name: "f.example.com", Type: "A", Data: "10.0.0.6", Zone: "example.com"},
+			{ID: "g", Name: "g.example.com", Type: "A", Data: "10.0.0.7", Zone: "example.com"},
+			{ID: "g-txt", Name: "g.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+		},
+	}}
+	e := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "c.example.com", Upstream: "10.0.0.3:8080"},
+	}
+
+	report, err := e.VerifyConsistency(context.Background(), domains, nil)
+	if err != nil {
+		t.Fatalf("VerifyConsistency error: %v", err)
+	}
+	expected := []ConsistencyEntry{
+		{Kind: ConsistencyDataMismatch, Zone: "example.com", Host: "b.example.com", Type: "A", Caddy: "10.0.0.2", State: "10.0.0.2", Provider: "10.0.0.9"},
+		{Kind: ConsistencyMissingInState, Zone: "example.com", Host: "c.example.com", Type: "A", Caddy: "10.0.0.3", Provider: "10.0.0.3"},
+		{Kind: ConsistencyMissingAtProvider, Zone: "example.com", Host: "d.example.com", Type: "A", State: "10.0.0.4"},
+		{Kind: ConsistencyMissingInState, Zone: "example.com", Host: "e.example.com", Type: "A", Provider: "10.0.0.5"},
+		{Kind: ConsistencyDataMismatch, Zone: "example.com", Host: "g.example.com", Type: "A", State: "10.0.0.7", Provider: "10.0.0.7"},
+	}
+	if !reflect.DeepEqual(report.Entries, expected) {
+		t.Errorf("Entries = %+v, want %+v", report.Entries, expected)
+	}
+	counts := map[string]int{ConsistencyMatched: 1, ConsistencyDataMismatch: 2, ConsistencyMissingInState: 2, ConsistencyMissingAtProvider: 1}
+	if !reflect.DeepEqual(report.Counts, counts) {
+		t.Errorf("Counts = %v, want %v", report.Counts, counts)
+	}
+	if len(dp.ops) > 0 {
+		t.Errorf("Expected no writes, got %v", dp.ops)
+	}
+}
+
+func TestVerifyConsistencyPartition(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com", "lab.net"}},
+	}
+	dp := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {{ID: "a", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"}},
+		"lab.net":     {{ID: "b", Name: "b.lab.net", Type: "A", Data: "10.0.0.2", Zone: "lab.net"}},
+	}}
+	e := NewEngine(&MockStateManager{}, dp, cfg, metrics.New(false))
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.lab.net", Upstream: "10.0.0.2:8080"},
+	}
+
+	if _, err := e.VerifyConsistency(context.Background(), domains, nil); err != nil {
+		t.Fatalf("VerifyConsistency error: %v", err)
+	}
+	// Only the partition's zone is checked again, the other keeps its report
+	dp.records["example.com"] = nil
+	dp.records["lab.net"] = nil
+	report, err := e.VerifyConsistency(context.Background(), domains, &Partition{Zones: []string{"lab.net"}})
+	if err != nil {
+		t.Fatalf("VerifyConsistency error: %v", err)
+	}
+	if len(report.Entries) != 1 || report.Entries[0].Host != "b.lab.net" || report.Entries[0].Kind != ConsistencyMissingAtProvider {
+		t.Errorf("Expected only lab.net missing at the provider, got %+v", report.Entries)
+	}
+	merged := e.Consistency()
+	expected := []ConsistencyEntry{
+		{Kind: ConsistencyMissingInState, Zone: "example.com", Host: "a.example.com", Type: "A", Caddy: "10.0.0.1", Provider: "10.0.0.1"},
+		{Kind: ConsistencyMissingAtProvider, Zone: "lab.net", Host: "b.lab.net", Type: "A", Caddy: "10.0.0.2"},
+	}
+	if !reflect.DeepEqual(merged.Entries, expected) {
+		t.Errorf("Entries = %+v, want %+v", merged.Entries, expected)
+	}
+}
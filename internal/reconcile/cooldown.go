@@ -0,0 +1,37 @@
+package reconcile
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// cooldownHosts keeps the previous upstream of hosts whose records were
+// changed less than reconcile.churnCooldown ago, so an upstream flapping
+// between two values, e.g. during blue/green deploys, does not rewrite the
+// records every sync. A change still there once the cooldown has passed is
+// applied. Forced hosts are synced regardless.
+func (e *engine) cooldownHosts(ctx context.Context, current, previous state.State, forced map[string]bool) {
+	cooldown := e.cfg.Reconcile.ChurnCooldown
+	if cooldown <= 0 {
+		return
+	}
+	now := e.now()
+	for host, d := range current.Domains {
+		prev, exists := previous.Domains[host]
+		if !exists || prev.LastApplied == 0 || prev.ServerName == d.ServerName || forced[host] {
+			continue
+		}
+		remaining := time.Unix(prev.LastApplied, 0).Add(cooldown).Sub(now)
+		if remaining <= 0 {
+			continue
+		}
+		slog.InfoContext(ctx, "Suppressing upstream change during churn cooldown", "host", host, "upstream", d.ServerName, "previous", prev.ServerName, "remaining", remaining.Round(time.Second))
+		zone, _ := e.zoneOf(host)
+		e.metrics.IncChurnSuppressed(zone)
+		d.ServerName = prev.ServerName
+		current.Domains[host] = d
+	}
+}
@@ -0,0 +1,148 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+)
+
+const (
+	DriftMissing  = "missing"  // no record exists for a caddy host
+	DriftMismatch = "mismatch" // the record differs from the caddy host
+	DriftUnowned  = "unowned"  // the record matches but has no owned TXT record
+	DriftStale    = "stale"    // an owned record has no caddy host
+)
+
+var driftKinds = []string{DriftMissing, DriftMismatch, DriftUnowned, DriftStale}
+
+// DriftEntry is a difference between the desired record of a host and the
+// live zone
+type DriftEntry struct {
+	Kind    string `json:"kind"`
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Desired string `json:"desired,omitempty"`
+	Live    string `json:"live,omitempty"`
+}
+
+// Drift is every difference found by the latest shadow sync
+type Drift struct {
+	Checked int64        `json:"checked"` // unix time of the comparison, zero if never run
+	Entries []DriftEntry `json:"entries"`
+}
+
+// Drift returns the drift computed during the latest shadow sync
+func (e *engine) Drift() Drift {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	drift := Drift{Checked: e.drift.Checked, Entries: make([]DriftEntry, len(e.drift.Entries))}
+	copy(drift.Entries, e.drift.Entries)
+	return drift
+}
+
+// computeDrift compares the desired record of every caddy host against the
+// live zones without writing anything
+func (e *engine) computeDrift(ctx context.Context, domains []source.DomainConfig) (Drift, error) {
+	drift := Drift{Entries: []DriftEntry{}}
+	for _, zone := range e.zones {
+		records, err := e.zoneRecords(ctx, zone)
+		if err != nil {
+			return drift, fmt.Errorf("get records for zone %s: %w", zone, err)
+		}
+
+		live := make(map[string][]provider.Record)
+		owned := make(map[string]bool)
+		for _, r := range records {
+			recordName := getRecordName(r.Name, zone)
+			switch r.Type {
+			case "A", "AAAA", "CNAME":
+				live[recordName] = append(live[recordName], r)
+				if ownsComment(r, e.owners) {
+					owned[recordName] = true
+				}
+			case "TXT":
+				if ownsHeritage(r.Data, e.owners) {
+					owned[recordName] = true
+				}
+			}
+		}
+
+		counts := make(map[string]int, len(driftKinds))
+		desired := make(map[string]bool)
+		for _, d := range domains {
+			if !e.inZone(d.Host, zone) || e.isProtected(d.Host) {
+				continue
+			}
+			want := e.desiredRecord(d, zone)
+			desired[want.Name] = true
+
+			entry := DriftEntry{Zone: zone, Name: want.Name, Type: want.Type, Desired: want.Data}
+			got, exists := liveRecord(live[want.Name], want)
+			switch {
+			case !exists:
+				entry.Kind = DriftMissing
+			case got.Type != want.Type || got.Data != want.Data || !e.matchesAttributes(d.Host, zone, got):
+				entry.Kind = DriftMismatch
+				entry.Live = got.Data
+			case !owned[want.Name]:
+				entry.Kind = DriftUnowned
+				entry.Live = got.Data
+			default:
+				continue
+			}
+			drift.Entries = append(drift.Entries, entry)
+			counts[entry.Kind]++
+		}
+
+		for name := range owned {
+			records := live[name]
+			if desired[name] || len(records) == 0 {
+				continue
+			}
+			got := records[len(records)-1]
+			if e.isProtected(recordHost(got)) {
+				continue
+			}
+			drift.Entries = append(drift.Entries, DriftEntry{Kind: DriftStale, Zone: zone, Name: name, Type: got.Type, Live: got.Data})
+			counts[DriftStale]++
+		}
+
+		total := 0
+		for _, kind := range driftKinds {
+			e.metrics.SetDriftRecords(zone, kind, counts[kind])
+			total += counts[kind]
+		}
+		e.metrics.SetOutOfSync(zone, total)
+	}
+
+	sort.Slice(drift.Entries, func(i, j int) bool {
+		a, b := drift.Entries[i], drift.Entries[j]
+		if a.Zone != b.Zone {
+			return a.Zone < b.Zone
+		}
+		return a.Name < b.Name
+	})
+	drift.Checked = e.now().Unix()
+	slog.InfoContext(ctx, "Computed drift against live zones", "entries", len(drift.Entries))
+	return drift, nil
+}
+
+// liveRecord returns the live record at a name to compare want with, the one
+// holding its type and data if any, as an AAAA record or the A records of
+// alternates may sit beside the main record, else the last
+func liveRecord(records []provider.Record, want provider.Record) (provider.Record, bool) {
+	if len(records) == 0 {
+		return provider.Record{}, false
+	}
+	for _, r := range records {
+		if r.Type == want.Type && r.Data == want.Data {
+			return r, true
+		}
+	}
+	return records[len(records)-1], true
+}
@@ -0,0 +1,69 @@
+package reconcile
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// simulateOps takes the hosts with an operation of reconcile.dryRunOps out of
+// plan, logging and auditing their operations as dry runs. The hosts keep
+// their previous state, so they are planned again until the operation is
+// allowed. A host's groups are never split and both hosts of a move go
+// together, so e.g. the create following a simulated delete is simulated too.
+func (e *engine) simulateOps(ctx context.Context, plan Plan, current, previous state.State, changes state.StateChanges) Plan {
+	ops := e.cfg.Reconcile.DryRunOps
+	if len(ops) == 0 {
+		return plan
+	}
+	partner := plan.movePartners()
+	simulated := make(map[string]bool)
+	for _, g := range plan.Groups {
+		if !slices.Contains(ops, g.Op) {
+			continue
+		}
+		key := groupKey(g.Zone, g.Name)
+		simulated[key] = true
+		if other, moved := partner[key]; moved {
+			simulated[other] = true
+		}
+	}
+	if len(simulated) == 0 {
+		return plan
+	}
+
+	now := e.now().Unix()
+	runID := runid.FromContext(ctx)
+	entries := []state.AuditEntry{}
+	for _, g := range plan.Groups {
+		key := groupKey(g.Zone, g.Name)
+		if !simulated[key] {
+			continue
+		}
+		for _, r := range g.Records {
+			reason := plan.Reason(g.Op, r)
+			slog.InfoContext(ctx, "Dry run operation - planned change", "op", g.Op, "zone", r.Zone, "name", r.Name, "type", r.Type, "data", r.Data, "reason", reason)
+			entries = append(entries, state.AuditEntry{
+				Time:   now,
+				Op:     g.Op,
+				Zone:   r.Zone,
+				Name:   r.Name,
+				Type:   r.Type,
+				Data:   r.Data,
+				Reason: reason,
+				Result: "dry_run",
+				RunID:  runID,
+				Source: plan.Sources[key],
+			})
+		}
+	}
+	if err := e.stateManager.AppendAudit(ctx, entries); err != nil {
+		slog.WarnContext(ctx, "Failed to write audit entries", "count", len(entries), "error", err)
+	}
+	slog.InfoContext(ctx, "Dry running operations of reconcile.dryRunOps", "ops", ops, "hosts", len(simulated), "records", len(entries))
+	e.deferHosts(current, previous, changes, simulated)
+	return plan.without(simulated)
+}
@@ -2,31 +2,100 @@ package reconcile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/evanofslack/caddy-dns-sync/internal/config"
 	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
 	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
+	"github.com/evanofslack/caddy-dns-sync/internal/schedule"
 	"github.com/evanofslack/caddy-dns-sync/internal/source"
 	"github.com/evanofslack/caddy-dns-sync/internal/state"
 )
 
+const maxRateLimitRetries = 3
+
 type Engine interface {
 	Reconcile(ctx context.Context, domains []source.DomainConfig) (Results, error)
+	ReconcileScope(ctx context.Context, domains []source.DomainConfig, scope Scope) (Results, error)
+	ReconcilePartition(ctx context.Context, domains []source.DomainConfig, p Partition) (Results, error)
+	Filtered() []FilteredHost
+	LastPlan() Plan
+	Summary() Summary
+	Drift() Drift
+	Consistency() Consistency
+	VerifyConsistency(ctx context.Context, domains []source.DomainConfig, p *Partition) (Consistency, error)
+	Paused(ctx context.Context) (bool, error)
+	SetPaused(ctx context.Context, paused bool) error
+	Skipped(ctx context.Context) ([]SkippedHost, error)
+	ClearSkipped(ctx context.Context, host string) (int, error)
+	Failures(ctx context.Context) ([]FailedHost, error)
+	Inventory(ctx context.Context) ([]InventoryRecord, error)
+	Stuck(st state.State) []StuckHost
 }
 
 type engine struct {
+	mu           sync.RWMutex
+	filtered     []FilteredHost         // hosts skipped in the latest reconcile
+	lastPlan     Plan                   // plan generated in the latest reconcile
+	summary      Summary                // host and record counts of the latest reconcile
+	drift        Drift                  // differences found by the latest shadow sync
+	consistency  map[string]Consistency // latest cross check of caddy, state and the live records, by zone
+	failMu       sync.Mutex             // guards the persisted host failures
+	journalMu    sync.Mutex             // guards the journal of the running plan
+	journal      map[string]int         // journal index of each operation of the running plan
+	retryBackoff time.Duration          // initial wait before retrying a rate limited operation
+	workers      int                    // groups executed in parallel
 	stateManager state.Manager
 	dnsProvider  provider.Provider
 	dryRun       bool
 	protected    map[string]bool
+	hostPatterns []hostPattern // host attributes, least specific first
 	zones        []string
+	zoneSettings map[string]config.ZoneSettings // by normalized zone
+	windows      schedule.Windows               // writes are deferred outside these
+	location     *time.Location                 // timezone of the write windows
+	now          func() time.Time
 	metrics      *metrics.Metrics
 	cfg          *config.Config
+	useComments  bool     // ownership is stored in record comments, not TXT records
+	owners       []string // owners whose records are ours, the written owner first
+	hostFilter   HostFilter
+	policy       *Policy         // rules every plan must satisfy before it is executed
+	hook         Hook            // asked to allow, deny or change every planned operation
+	addresses    AddressDetector // detects the public addresses published for every host
+	preSync      SyncHook        // run before a plan is executed
+	postSync     SyncHook        // run after a plan was executed without failures
+	listZones    atomic.Bool     // the next plan lists the zones, set after a run with failures
+	startOnce    sync.Once
+	started      time.Time    // first run, the startup safeguard counts from it
+	safeguard    atomic.Bool  // the current run is dry run by the startup safeguard
+	heldRuns     atomic.Int64 // plans dry run by the startup safeguard
+}
+
+// HostFilter decides which caddy hosts may be published. Hosts it does not
+// allow are treated as gone from caddy, so their records are removed.
+type HostFilter interface {
+	Allows(host string) (bool, error)
+}
+
+// SetHostFilter limits the hosts published to those f allows
+func (e *engine) SetHostFilter(f HostFilter) {
+	e.hostFilter = f
+}
+
+// SetPolicy blocks the execution of plans breaking the rules of p
+func (e *engine) SetPolicy(p Policy) {
+	e.policy = &p
 }
 
 func NewEngine(sm state.Manager, dp provider.Provider, cfg *config.Config, metrics *metrics.Metrics) *engine {
@@ -34,18 +103,66 @@ func NewEngine(sm state.Manager, dp provider.Provider, cfg *config.Config, metri
 	for _, r := range cfg.Reconcile.ProtectedRecords {
 		protected[r] = true
 	}
-	return &engine{
+	windows, err := schedule.ParseAll(cfg.Reconcile.WriteWindows)
+	if err != nil {
+		slog.Warn("Invalid write windows, writing at any time", "error", err)
+	}
+	location, err := time.LoadLocation(cfg.Reconcile.WriteTimezone)
+	if err != nil {
+		slog.Warn("Invalid write timezone, using local time", "error", err)
+		location = time.Local
+	}
+	useComments := cfg.DNS.Ownership == config.OwnershipComment
+	if c, ok := dp.(provider.Commenter); useComments && dp != nil && (!ok || !c.SupportsComments()) {
+		slog.Warn("Provider does not support record comments, storing ownership in TXT records")
+		useComments = false
+	}
+	if _, ok := dp.(provider.IDResolver); cfg.Reconcile.FastSync && !ok && !cfg.Reconcile.VerifyWrites {
+		slog.Warn("Provider does not report the id of created records, enable verifyWrites for fastSync to skip zone listing")
+	}
+	if useComments && cfg.Reconcile.KeepOwnership {
+		slog.Warn("Ownership is stored in record comments, keepOwnershipOnDelete has no heritage TXT records to keep")
+	}
+	e := &engine{
 		stateManager: sm,
 		dnsProvider:  dp,
 		dryRun:       cfg.Reconcile.DryRun,
 		protected:    protected,
-		zones:        cfg.DNS.Zones,
+		hostPatterns: sortedPatterns(cfg.Reconcile.HostAttributes),
+		retryBackoff: time.Second,
+		workers:      max(cfg.Reconcile.Workers, 1),
+		zones:        normalizeZones(cfg.DNS.Zones),
+		zoneSettings: zoneSettings(cfg.DNS.ZoneSettings),
+		windows:      windows,
+		location:     location,
+		now:          time.Now,
 		metrics:      metrics,
 		cfg:          cfg,
+		useComments:  useComments,
+		owners:       cfg.Reconcile.Owners(),
 	}
+	// Writes outside of syncs wait for the safeguard as well
+	e.safeguard.Store(cfg.Reconcile.StartupDelay > 0 || cfg.Reconcile.InitialDryRuns > 0)
+	return e
 }
 
 func (e *engine) Reconcile(ctx context.Context, domains []source.DomainConfig) (Results, error) {
+	return e.reconcile(ctx, domains, Scope{})
+}
+
+func (e *engine) reconcile(ctx context.Context, domains []source.DomainConfig, scope Scope) (Results, error) {
+	// Log lines, audit entries and the plan of a run carry its id
+	if runid.FromContext(ctx) == "" {
+		ctx = runid.WithID(ctx, runid.New())
+	}
+	e.safeguard.Store(e.startupSafeguard())
+	e.metrics.SetStartupSafeguard(e.safeguard.Load())
+
+	domains, invalid, denied, err := e.desiredDomains(ctx, domains)
+	if err != nil {
+		return Results{}, err
+	}
+
 	// Load current state
 	prevState, err := e.stateManager.LoadState(ctx)
 	if err != nil {
@@ -58,264 +175,1346 @@ func (e *engine) Reconcile(ctx context.Context, domains []source.DomainConfig) (
 	}
 
 	for _, d := range domains {
+		// What was last applied carries over until the plan applies again
+		prev := prevState.Domains[d.Host]
 		currentState.Domains[d.Host] = state.DomainState{
-			ServerName: d.Upstream,
-			LastSeen:   time.Now().Unix(),
+			ServerName:  d.Upstream,
+			LastSeen:    e.now().Unix(),
+			Source:      d.Source,
+			LastApplied: prev.LastApplied,
+			AppliedType: prev.AppliedType,
+			AppliedData: prev.AppliedData,
+			IPv6:        d.IPv6,
+			Alternates:  d.Alternates,
+			Records:     prev.Records,
+		}
+	}
+
+	// Skipped hosts keep their previous state so no change is attempted
+	skipped, err := e.skippedHosts(ctx)
+	if err != nil {
+		return Results{}, fmt.Errorf("load failures: %w", err)
+	}
+	// Hosts refused for publishing private addresses are likewise left alone
+	refused := e.privateTargets(ctx, domains)
+	for host := range refused {
+		if prev, exists := prevState.Domains[host]; exists {
+			currentState.Domains[host] = prev
+		} else {
+			delete(currentState.Domains, host)
+		}
+	}
+	for host := range skipped {
+		if prev, exists := prevState.Domains[host]; exists {
+			// Still in caddy, so still seen
+			if current, seen := currentState.Domains[host]; seen {
+				prev.LastSeen = current.LastSeen
+			}
+			currentState.Domains[host] = prev
+		} else {
+			delete(currentState.Domains, host)
+		}
+	}
+	expired := e.expireHosts(ctx, currentState)
+	// A scoped run leaves every other host as it was
+	var forced map[string]bool
+	switch {
+	case scope.partition != nil:
+		e.applyPartition(*scope.partition, currentState, prevState)
+	case !scope.IsZero():
+		if forced, err = applyScope(scope, currentState, prevState); err != nil {
+			return Results{}, err
+		}
+		slog.InfoContext(ctx, "Reconciling scoped hosts", "host", scope.Host, "zone", scope.Zone, "hosts", len(forced))
+	}
+	e.cooldownHosts(ctx, currentState, prevState, forced)
+	e.recordFiltered(ctx, domains, skipped, refused, invalid, denied)
+
+	// Shadow mode only reports what would change against the live zones
+	if e.cfg.Reconcile.Shadow {
+		drift, err := e.computeDrift(ctx, domains)
+		if err != nil {
+			return Results{}, fmt.Errorf("compute drift: %w", err)
 		}
+		e.mu.Lock()
+		e.drift = drift
+		e.mu.Unlock()
+		return Results{}, nil
+	}
+
+	// A journal left behind means the last run was interrupted
+	recovered, err := e.recoverJournal(ctx)
+	if err != nil {
+		return Results{}, fmt.Errorf("load journal: %w", err)
 	}
 
 	// Compare states to find changes
 	changes := e.compareStates(currentState, prevState)
-	slog.Debug("State comparison", "added", len(changes.Added), "removed", len(changes.Removed))
+	changes.Expired = expired
+	changes.Recovered = recovered
+	changes.Moved = e.detectMoves(changes, prevState)
+	changes.Forced = forceHosts(&changes, currentState, forced)
+	changes.Realiased = e.realiasHosts(&changes, currentState, prevState)
+	if changes.Records = e.fastRecords(changes, prevState); changes.Records != nil {
+		slog.InfoContext(ctx, "Planning from records in state, skipping zone listing")
+	}
+	slog.DebugContext(ctx, "State comparison", "added", len(changes.Added), "removed", len(changes.Removed))
 	if changes.IsEmpty() {
-		slog.Info("No state changes, ending reconciliation")
+		e.mu.Lock()
+		e.lastPlan = Plan{}
+		e.mu.Unlock()
+		e.recordCounts(prevState, Plan{})
+		e.recordOutOfSync(scope, Plan{}, Results{})
+		slog.InfoContext(ctx, "No state changes, ending reconciliation")
 		return Results{}, nil
 	}
 
 	// Generate and execute plan
-	plan, err := e.generatePlan(ctx, changes)
+	plan, err := e.generatePlan(ctx, changes, scope)
 	if err != nil {
 		return Results{}, fmt.Errorf("generate plan: %w", err)
 	}
+	// Changes beyond the per run limit are planned again by the next run, they
+	// still count as out of sync
+	full := plan
+	if limit := e.cfg.Reconcile.MaxOpsPerRun; limit > 0 {
+		var deferred map[string]bool
+		if plan, deferred = plan.limitOps(limit); len(deferred) > 0 {
+			e.deferHosts(currentState, prevState, changes, deferred)
+			slog.InfoContext(ctx, "Plan exceeds maxOpsPerRun, deferring changes to the next run", "limit", limit, "deferred_ops", plan.Deferred, "deferred_hosts", len(deferred))
+		}
+	}
+	plan = e.applyHook(ctx, plan, currentState, prevState, changes)
+	if e.policy != nil {
+		plan.Violations = e.policy.Evaluate(plan)
+	}
+	e.mu.Lock()
+	e.lastPlan = plan
+	e.mu.Unlock()
+
+	// A plan breaking the policy is reported but never executed
+	if len(plan.Violations) > 0 {
+		for _, v := range plan.Violations {
+			slog.ErrorContext(ctx, "Plan violates policy", "rule", v.Rule, "op", v.Op, "zone", v.Zone, "name", v.Name, "type", v.Type, "reason", v.Message)
+		}
+		e.recordCounts(prevState, plan)
+		e.recordOutOfSync(scope, full, Results{})
+		return Results{}, fmt.Errorf("%w: %d violations", ErrPolicyViolation, len(plan.Violations))
+	}
 
+	// While paused the plan is still reported, but nothing is written
+	paused, err := e.Paused(ctx)
+	if err != nil {
+		return Results{}, fmt.Errorf("load paused: %w", err)
+	}
+	if paused {
+		slog.InfoContext(ctx, "Sync paused, skipping plan execution", "create", len(plan.Create), "update", len(plan.Update), "delete", len(plan.Delete))
+		e.recordCounts(prevState, plan)
+		e.recordOutOfSync(scope, full, Results{})
+		return Results{Paused: true}, nil
+	}
+
+	// Outside the write windows the plan is recomputed and applied once one opens
+	now := e.now().In(e.location)
+	if !e.windows.Open(now) {
+		next := e.windows.Next(now)
+		slog.InfoContext(ctx, "Outside write window, deferring plan execution", "until", next, "create", len(plan.Create), "update", len(plan.Update), "delete", len(plan.Delete))
+		e.recordCounts(prevState, plan)
+		e.recordOutOfSync(scope, full, Results{})
+		return Results{Deferred: next}, nil
+	}
+
+	if !e.isDryRun() {
+		plan = e.simulateOps(ctx, plan, currentState, prevState, changes)
+		// A failing pre sync hook keeps the previous state, so the plan is retried
+		event := SyncEvent{Hook: PreSync, Create: plan.Create, Update: plan.Update, Delete: plan.Delete}
+		if err := e.runSyncHook(ctx, e.preSync, event); err != nil {
+			e.recordCounts(prevState, plan)
+			e.recordOutOfSync(scope, full, Results{})
+			return Results{}, fmt.Errorf("pre sync hook: %w", err)
+		}
+	}
 	results, err := e.executePlan(ctx, plan, currentState)
+	results.Limited = plan.Deferred
+	e.listZones.Store(err != nil || len(results.Failures) > 0)
 	if err != nil {
+		e.recordCounts(prevState, plan)
+		e.recordOutOfSync(scope, full, results)
 		return results, fmt.Errorf("execute plan: %w", err)
 	}
+	if e.safeguard.Load() && !e.dryRun {
+		e.holdPlan(ctx, plan, &results)
+	}
+	if e.isDryRun() || len(results.Failures) > 0 {
+		e.recordCounts(prevState, plan)
+	} else {
+		e.recordCounts(currentState, plan)
+	}
+	if e.isDryRun() {
+		e.recordOutOfSync(scope, full, Results{})
+	} else {
+		e.recordOutOfSync(scope, full, results)
+	}
+	if !e.isDryRun() {
+		if err := e.trackFailures(ctx, results); err != nil {
+			slog.ErrorContext(ctx, "Failed to track host failures", "error", err)
+		}
+	}
+	// The records are written, a failing post sync hook does not fail the sync
+	if !e.isDryRun() && len(results.Failures) == 0 {
+		event := SyncEvent{Hook: PostSync, Create: results.Created, Update: results.Updated, Delete: results.Deleted}
+		if err := e.runSyncHook(ctx, e.postSync, event); err != nil {
+			slog.ErrorContext(ctx, "Post sync hook failed", "error", err)
+		}
+	}
 	return results, nil
 }
 
+// Paused reports whether DNS writes are paused
+func (e *engine) Paused(ctx context.Context) (bool, error) {
+	paused, err := e.stateManager.LoadPaused(ctx)
+	if err != nil {
+		return false, err
+	}
+	e.metrics.SetPaused(paused)
+	return paused, nil
+}
+
+// SetPaused pauses or resumes DNS writes, persisted across restarts
+func (e *engine) SetPaused(ctx context.Context, paused bool) error {
+	if err := e.stateManager.SavePaused(ctx, paused); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Sync pause changed", "paused", paused)
+	e.metrics.SetPaused(paused)
+	return nil
+}
+
+// Filtered returns the hosts skipped during the latest reconcile
+func (e *engine) Filtered() []FilteredHost {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	filtered := make([]FilteredHost, len(e.filtered))
+	copy(filtered, e.filtered)
+	return filtered
+}
+
+// LastPlan returns the plan generated during the latest reconcile
+func (e *engine) LastPlan() Plan {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lastPlan
+}
+
+// Summary returns host and record counts of the latest reconcile
+func (e *engine) Summary() Summary {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	summary := e.summary
+	summary.Managed = make(map[string]int, len(e.summary.Managed))
+	for zone, count := range e.summary.Managed {
+		summary.Managed[zone] = count
+	}
+	summary.Unmanaged = make(map[string]int, len(e.summary.Unmanaged))
+	for zone, count := range e.summary.Unmanaged {
+		summary.Unmanaged[zone] = count
+	}
+	summary.Contested = make(map[string]int, len(e.summary.Contested))
+	for zone, count := range e.summary.Contested {
+		summary.Contested[zone] = count
+	}
+	return summary
+}
+
+// recordCounts counts hosts of the persisted state, and unowned records and
+// ownership conflicts of the plan in each zone
+func (e *engine) recordCounts(persisted state.State, plan Plan) {
+	managed := make(map[string]int, len(e.zones))
+	unmanaged := make(map[string]int, len(e.zones))
+	for _, zone := range e.zones {
+		managed[zone] = 0
+		unmanaged[zone] = 0
+		for host := range persisted.Domains {
+			if e.inZone(host, zone) {
+				managed[zone]++
+			}
+		}
+	}
+	contested := make(map[string]int, len(e.zones))
+	for _, zone := range e.zones {
+		contested[zone] = 0
+	}
+	for _, r := range plan.Unmanaged {
+		unmanaged[r.Zone]++
+	}
+	for _, c := range plan.Contested {
+		contested[c.Zone]++
+	}
+	for zone := range managed {
+		e.metrics.SetManagedRecords(zone, managed[zone])
+		e.metrics.SetUnmanagedSkipped(zone, unmanaged[zone])
+	}
+
+	e.mu.Lock()
+	e.summary.Managed = managed
+	e.summary.Unmanaged = unmanaged
+	e.summary.Contested = contested
+	e.mu.Unlock()
+}
+
+// recordOutOfSync counts the records of each zone still diverging from the
+// desired state after a run, the planned records not applied and the
+// conflicts blocking hosts. Zones of other partitions keep their counts.
+func (e *engine) recordOutOfSync(scope Scope, plan Plan, results Results) {
+	counts := make(map[string]int, len(e.zones))
+	for _, zone := range e.zones {
+		if scope.covers(zone) {
+			counts[zone] = 0
+		}
+	}
+	for _, g := range plan.Groups {
+		counts[g.Zone] += len(g.Records)
+	}
+	for _, r := range plan.Conflicts {
+		counts[r.Zone]++
+	}
+	for _, applied := range [][]provider.Record{results.Created, results.Updated, results.Deleted} {
+		for _, r := range applied {
+			counts[r.Zone]--
+		}
+	}
+	// A rolled back operation leaves its record diverging again
+	for _, op := range results.Reverted {
+		counts[op.Record.Zone]++
+	}
+	for zone, count := range counts {
+		e.metrics.SetOutOfSync(zone, max(count, 0))
+	}
+}
+
+// desiredDomains returns the caddy hosts that may be published, with the
+// addresses and canonical names their records carry, along with the invalid
+// hosts and those the host filter denied
+func (e *engine) desiredDomains(ctx context.Context, domains []source.DomainConfig) ([]source.DomainConfig, []string, []string, error) {
+	// Internationalized hosts become punycode, invalid names are never synced
+	domains, invalid := normalizeDomains(domains)
+	domains, denied, err := e.allowedDomains(domains)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("filter hosts: %w", err)
+	}
+	if domains, err = e.withPublicAddresses(ctx, domains); err != nil {
+		return nil, nil, nil, fmt.Errorf("detect public ip: %w", err)
+	}
+	return e.withCanonical(domains), invalid, denied, nil
+}
+
+func (e *engine) recordFiltered(ctx context.Context, domains []source.DomainConfig, skipped, refused map[string]bool, invalid, denied []string) {
+	filtered := []FilteredHost{}
+	counts := map[string]int{
+		FilterReasonNoZone:     0,
+		FilterReasonProtected:  0,
+		FilterReasonSkipped:    0,
+		FilterReasonPrivate:    0,
+		FilterReasonInvalid:    len(invalid),
+		FilterReasonNotAllowed: len(denied),
+	}
+	for _, host := range invalid {
+		filtered = append(filtered, FilteredHost{Host: host, Reason: FilterReasonInvalid})
+	}
+	for _, host := range denied {
+		filtered = append(filtered, FilteredHost{Host: host, Reason: FilterReasonNotAllowed})
+	}
+	for _, d := range domains {
+		reason := ""
+		switch {
+		case !e.inAnyZone(d.Host):
+			reason = FilterReasonNoZone
+		case e.isProtected(d.Host):
+			reason = FilterReasonProtected
+		case skipped[d.Host]:
+			reason = FilterReasonSkipped
+		case refused[d.Host]:
+			reason = FilterReasonPrivate
+		default:
+			continue
+		}
+		slog.DebugContext(ctx, "Host filtered from sync", "host", d.Host, "reason", reason)
+		filtered = append(filtered, FilteredHost{Host: d.Host, Reason: reason})
+		counts[reason]++
+	}
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i].Host < filtered[j].Host })
+
+	for reason, count := range counts {
+		e.metrics.SetFilteredHosts(reason, count)
+	}
+	e.metrics.SetDiscoveredHosts(len(domains) + len(invalid) + len(denied))
+	if counts[FilterReasonNoZone] > 0 {
+		slog.WarnContext(ctx, "Hosts matched no configured zone", "count", counts[FilterReasonNoZone], "zones", e.zones)
+	}
+
+	e.mu.Lock()
+	e.filtered = filtered
+	e.summary.Discovered = len(domains) + len(invalid) + len(denied)
+	e.summary.Filtered = len(filtered)
+	e.mu.Unlock()
+}
+
+// allowedDomains drops the hosts the host filter does not allow, returning
+// them separately
+func (e *engine) allowedDomains(domains []source.DomainConfig) ([]source.DomainConfig, []string, error) {
+	if e.hostFilter == nil {
+		return domains, nil, nil
+	}
+	allowed := make([]source.DomainConfig, 0, len(domains))
+	var denied []string
+	for _, d := range domains {
+		ok, err := e.hostFilter.Allows(d.Host)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			denied = append(denied, d.Host)
+			continue
+		}
+		allowed = append(allowed, d)
+	}
+	return allowed, denied, nil
+}
+
 func (e *engine) compareStates(current, previous state.State) state.StateChanges {
 	changes := state.StateChanges{
-		Added:   []source.DomainConfig{},
-		Removed: []string{},
+		Added:              []source.DomainConfig{},
+		Removed:            []string{},
+		Previous:           make(map[string]string),
+		PreviousIPv6:       make(map[string]string),
+		PreviousAlternates: make(map[string][]string),
 	}
 
 	// Find added or modified domains
 	for host, domainCfg := range current.Domains {
-		if prev, exists := previous.Domains[host]; !exists || prev.ServerName != domainCfg.ServerName {
+		prev, exists := previous.Domains[host]
+		modified := exists && prev.ServerName != domainCfg.ServerName
+		readdressed := exists && prev.IPv6 != domainCfg.IPv6
+		realternated := exists && !slices.Equal(prev.Alternates, domainCfg.Alternates)
+		// A host found in another source has its heritage rewritten when the
+		// heritage names the source
+		moved := exists && e.cfg.Reconcile.HeritageSource && prev.Source != domainCfg.Source
+		if !exists || modified || moved || readdressed || realternated {
 			changes.Added = append(changes.Added, source.DomainConfig{
-				Host:     host,
-				Upstream: domainCfg.ServerName,
+				Host:       host,
+				Upstream:   domainCfg.ServerName,
+				Source:     domainCfg.Source,
+				IPv6:       domainCfg.IPv6,
+				Alternates: domainCfg.Alternates,
 			})
+			if modified {
+				changes.Previous[host] = prev.ServerName
+			}
+			if readdressed {
+				changes.PreviousIPv6[host] = prev.IPv6
+			}
+			if (modified || realternated) && len(prev.Alternates) > 0 {
+				changes.PreviousAlternates[host] = prev.Alternates
+			}
 		}
 	}
 
 	// Find removed domains
-	for host := range previous.Domains {
+	for host, prev := range previous.Domains {
 		if _, exists := current.Domains[host]; !exists {
 			changes.Removed = append(changes.Removed, host)
+			if prev.IPv6 != "" {
+				changes.PreviousIPv6[host] = prev.IPv6
+			}
+			if len(prev.Alternates) > 0 {
+				changes.PreviousAlternates[host] = prev.Alternates
+			}
 		}
 	}
 	return changes
 }
 
-func (e *engine) generatePlan(ctx context.Context, changes state.StateChanges) (Plan, error) {
+// generatePlan plans the changes in the zones scope covers, zones outside a
+// partition are never listed
+func (e *engine) generatePlan(ctx context.Context, changes state.StateChanges, scope Scope) (Plan, error) {
 	plan := Plan{
+		RunID:  runid.FromContext(ctx),
 		Create: []provider.Record{},
 		Delete: []provider.Record{},
 	}
 
+	// Logging every record boxes its fields even when debug is disabled
+	debug := slog.Default().Enabled(ctx, slog.LevelDebug)
+	index := newZoneIndex()
+
 	for _, zone := range e.zones {
+		if !scope.covers(zone) {
+			continue
+		}
 		// Get existing records
-		records, err := e.dnsProvider.GetRecords(ctx, zone)
+		records, err := e.planRecords(ctx, zone, changes)
 		if err != nil {
 			return plan, fmt.Errorf("get records for zone %s: %w", zone, err)
 		}
-		slog.Info("Got records from dns provider", "count", len(records))
-
-		recordMap := make(map[string]provider.Record)
-		managedTXTRecords := make(map[string]provider.Record)
-		for _, r := range records {
-			slog.Debug("Got record", "name", r.Name, "type", r.Type, "data", r.Data)
-			recordName := getRecordName(r.Name, zone)
-			switch r.Type {
-			case "A", "CNAME":
-				recordMap[recordName] = r
-			case "TXT":
-				if strings.Contains(r.Data, "heritage=caddy-dns-sync") && strings.Contains(r.Data, "caddy-dns-sync/owner="+e.cfg.Reconcile.Owner) {
-					managedTXTRecords[recordName] = r
-				}
+		slog.InfoContext(ctx, "Got records from dns provider", "count", len(records))
+		if debug {
+			for _, r := range records {
+				slog.DebugContext(ctx, "Got record", "name", r.Name, "type", r.Type, "data", r.Data)
 			}
 		}
 
+		index.reset(zone, e.owners, records)
+		externalDNS := externalDNSOwned(records, zone, e.cfg.Reconcile.ExternalDNSOwners)
+
 		// Process additions
 		for _, domain := range changes.Added {
-			if !belongsToZone(domain.Host, zone) {
+			if !e.inZone(domain.Host, zone) {
 				continue
 			}
 
 			recordName := getRecordName(domain.Host, zone)
 			if e.isProtected(domain.Host) {
-				slog.Warn("Skipping protected record", "name", recordName, "zone", zone)
+				slog.WarnContext(ctx, "Skipping protected record", "name", recordName, "zone", zone)
 				continue
 			}
 
-			host := extractHostFromUpstream(domain.Upstream)
-			recordType := getRecordType(host)
-			desiredData := host
+			mainRecord := e.desiredRecord(domain, zone)
+			ownerTXT := e.heritageData(domain.Source)
+			if domain.Source != "" {
+				plan.attribute(mainRecord, domain.Source)
+			}
 
 			// Check if existing records need to be updated
-			existingMainRecord, mainExists := recordMap[recordName]
-			existingTXTRecord, txtExists := managedTXTRecords[recordName]
+			existingMainRecord, mainExists := index.mainRecordFor(recordName, mainRecord, alternateRecords(mainRecord, domain.Upstream, domain.Alternates))
+			existingTXTRecord, txtExists := index.ownedTXT(recordName)
+
+			// Only one heritage record is kept for an owned host
+			for _, dup := range index.duplicateTXT(recordName) {
+				plan.addDelete(dup, ReasonDuplicate)
+				e.metrics.IncDNSOperation("delete", zone, "TXT")
+			}
+
+			// Ownership is recognized in either form, so switching the
+			// ownership mode keeps existing records managed
+			commentOwned := mainExists && ownsComment(existingMainRecord, e.owners)
+			owned := txtExists || commentOwned
+			matches := mainExists &&
+				existingMainRecord.Data == mainRecord.Data &&
+				e.matchesAttributes(domain.Host, zone, existingMainRecord)
 
 			// If existing records match desired state, skip creation
-			if mainExists && txtExists &&
-				existingMainRecord.Data == desiredData &&
-				existingTXTRecord.Data == txtIdentifier(e.cfg.Reconcile.Owner) {
+			if matches && e.currentOwnership(existingMainRecord, existingTXTRecord, txtExists, ownerTXT) {
+				plan.markApplied(domain.Host, mainRecord)
+				plan.keep(existingMainRecord, existingTXTRecord)
+				continue
+			}
+
+			// An owned record only differing in how ownership is stored, e.g. a
+			// heritage record written before owners were escaped or in the
+			// other ownership mode, has its ownership rewritten in place
+			if matches && owned && e.planOwnership(&plan, mainRecord, existingMainRecord, existingTXTRecord, txtExists, ownerTXT) {
+				plan.markApplied(domain.Host, mainRecord)
+				plan.keep(existingMainRecord, existingTXTRecord)
+				continue
+			}
+
+			reason := ReasonHostAdded
+			if prev, modified := changes.Previous[domain.Host]; modified {
+				reason = reasonUpstreamChanged(prev, domain.Upstream)
+			} else if changes.Forced[domain.Host] {
+				reason = ReasonScoped
+			} else if changes.Realiased[domain.Host] {
+				reason = ReasonCanonical
+			}
+
+			// A name left with just the alternates the host keeps gets its
+			// main record beside them
+			if !mainExists && e.ownsAlternates(index, recordName, existingTXTRecord, txtExists, ownerTXT) {
+				plan.markApplied(domain.Host, mainRecord)
+				plan.keep(existingTXTRecord)
+				plan.addCreate(mainRecord, reason)
+				e.metrics.IncDNSOperation("create", zone, mainRecord.Type)
+				continue
+			}
+
+			if !owned {
+				e.checkOwnership(ctx, &plan, index, zone, recordName, existingMainRecord, mainExists)
+			}
+
+			// A name holding a record we do not own is handled by policy
+			takeover := false
+			if mainExists && !owned {
+				_, imported := externalDNS[recordName]
+				switch {
+				case changes.Recovered[zone+"/"+recordName]:
+					slog.InfoContext(ctx, "Adopting record created by interrupted plan", "name", recordName, "zone", zone, "record_type", existingMainRecord.Type)
+					takeover = true
+				case imported:
+					slog.InfoContext(ctx, "Importing record owned by external-dns", "name", recordName, "zone", zone, "record_type", existingMainRecord.Type)
+					takeover = true
+				case e.cfg.Reconcile.UnmanagedPolicy == config.UnmanagedTakeover:
+					slog.WarnContext(ctx, "Taking over unmanaged record", "name", recordName, "zone", zone, "record_type", existingMainRecord.Type, "data", existingMainRecord.Data)
+					takeover = true
+				case e.cfg.Reconcile.UnmanagedPolicy == config.UnmanagedFail:
+					slog.ErrorContext(ctx, "Name already has an unmanaged record", "name", recordName, "zone", zone, "record_type", existingMainRecord.Type, "data", existingMainRecord.Data)
+					plan.Conflicts = append(plan.Conflicts, existingMainRecord)
+					continue
+				default:
+					slog.WarnContext(ctx, "Skipping name with unmanaged record", "name", recordName, "zone", zone, "record_type", existingMainRecord.Type)
+					e.metrics.IncDNSOperation("skip", zone, existingMainRecord.Type)
+					continue
+				}
+			}
+
+			txtRecord := heritageRecord(mainRecord, ownerTXT)
+			plan.markApplied(domain.Host, mainRecord)
+
+			// A record already matching the desired state is adopted as is
+			if takeover && existingMainRecord.Type == mainRecord.Type && matches {
+				if !e.useComments {
+					plan.keep(existingMainRecord)
+					e.planHeritageCreate(&plan, index, recordName, txtRecord, ReasonTakeover)
+					continue
+				}
+				if existingMainRecord.ID != "" {
+					plan.addUpdate(mainRecord, existingMainRecord, ReasonTakeover)
+					e.metrics.IncDNSOperation("update", zone, mainRecord.Type)
+					continue
+				}
+			}
+
+			// Records that cannot coexist with the desired one are only
+			// removed when we own the host
+			conflicts := conflictingRecords(mainRecord, existingMainRecord, index.addressRecords(recordName))
+			if len(conflicts) > 0 && !owned && !takeover {
+				slog.WarnContext(ctx, "Desired record conflicts with unowned records", "name", recordName, "zone", zone, "record_type", mainRecord.Type)
+				conflicts = nil
+			}
+
+			// Owned records of the same type are updated in place
+			if mainExists && owned && existingMainRecord.ID != "" && len(conflicts) == 0 &&
+				existingMainRecord.Type == mainRecord.Type {
+				plan.addUpdate(mainRecord, existingMainRecord, reason)
+				e.metrics.IncDNSOperation("update", zone, mainRecord.Type)
+				plan.keep(existingTXTRecord)
+				e.planHeritage(&plan, mainRecord, existingTXTRecord, txtExists, ownerTXT)
 				continue
 			}
 
 			// If existing records don't match, plan to delete them first
 			if mainExists {
-				plan.Delete = append(plan.Delete, existingMainRecord)
+				replaceReason := ReasonDataMismatch
+				switch {
+				case takeover:
+					replaceReason = ReasonTakeover
+				case existingMainRecord.Type != mainRecord.Type:
+					replaceReason = reasonTypeChanged(existingMainRecord.Type, mainRecord.Type)
+				}
+				plan.addReplace(existingMainRecord, replaceReason)
 				e.metrics.IncDNSOperation("delete", zone, existingMainRecord.Type)
 			}
+			for _, conflict := range conflicts {
+				plan.addReplace(conflict, ReasonConflict)
+				e.metrics.IncDNSOperation("delete", zone, conflict.Type)
+			}
 			if txtExists {
-				plan.Delete = append(plan.Delete, existingTXTRecord)
+				plan.addReplace(existingTXTRecord, ReasonDataMismatch)
 				e.metrics.IncDNSOperation("delete", zone, "TXT")
 			}
 
 			// Create new records
-			mainRecord := provider.Record{
-				Name: recordName,
-				Type: recordType,
-				Data: desiredData,
-				TTL:  3600, // TODO: This should be configurable
-				Zone: zone,
+			plan.addCreate(mainRecord, reason)
+			e.metrics.IncDNSOperation("create", zone, mainRecord.Type)
+			if !e.useComments {
+				e.planHeritageCreate(&plan, index, recordName, txtRecord, reason)
 			}
-			plan.Create = append(plan.Create, mainRecord)
-			e.metrics.IncDNSOperation("create", zone, recordType)
-
-			txtRecord := provider.Record{
-				Name: recordName,
-				Type: "TXT",
-				Data: txtIdentifier(e.cfg.Reconcile.Owner),
-				TTL:  3600,
-				Zone: zone,
-			}
-			plan.Create = append(plan.Create, txtRecord)
-			e.metrics.IncDNSOperation("create", zone, "TXT")
 		}
+		e.planCompanions(&plan, index, zone, changes)
+		e.planAlternates(&plan, index, zone, changes)
 
 		// Process removals
 		for _, host := range changes.Removed {
-			if !belongsToZone(host, zone) {
+			if !e.inZone(host, zone) {
 				continue
 			}
 
 			recordName := getRecordName(host, zone)
 			recordType := getRecordType(host)
-			if e.isProtected(recordName) {
-				slog.Info("Skipping delete protected record", "name", recordName, "zone", zone, "record_type", recordType)
+			if e.isProtected(host) || e.isProtected(recordName) {
+				slog.InfoContext(ctx, "Skipping delete protected record", "name", recordName, "zone", zone, "record_type", recordType)
 				continue
 			}
 
 			// If entry has been removed and associated DNS record exists, plan to delete it
-			if record, exists := recordMap[recordName]; exists {
+			if record, exists := index.mainRecord(recordName); exists {
 				// But only delete if we manage it, confirmed by checking existance of txt record
-				if _, txtExists := managedTXTRecords[recordName]; !txtExists {
-					slog.Warn("Skipping delete record without associated owned TXT record", "name", recordName, "zone", zone, "record_type", recordType)
-					slog.Debug("TXT record check", "recordName", recordName, "exists", txtExists, "managedRecords", managedTXTRecords)
+				// or an owned comment. A tombstone is left by a removal whose delete failed.
+				_, tombstoned := index.tombstone(recordName)
+				if _, txtExists := index.ownedTXT(recordName); !txtExists && !tombstoned && !ownsComment(record, e.owners) {
+					slog.WarnContext(ctx, "Skipping delete record without associated owned TXT record", "name", recordName, "zone", zone, "record_type", recordType)
+					slog.DebugContext(ctx, "TXT record check", "recordName", recordName, "exists", txtExists)
 					e.metrics.IncDNSOperation("skip", zone, recordType)
+					plan.Unmanaged = append(plan.Unmanaged, record)
 					continue
 				}
-				plan.Delete = append(plan.Delete, record)
+				plan.addDelete(record, removedReason(host, changes))
 				e.metrics.IncDNSOperation("delete", zone, recordType)
+				if len(changes.PreviousAlternates[host]) > 0 {
+					e.planAlternatesDelete(&plan, index, recordName, removedReason(host, changes))
+				}
 			}
+			e.planCompanionDelete(&plan, index, recordName, changes.PreviousIPv6[host], removedReason(host, changes))
 
-			// Delete associated TXT record if managed
-			if txtRecord, exists := managedTXTRecords[recordName]; exists {
-                // txtRecord.Data = txtIdentifier(e.cfg.Reconcile.Owner) // cf check
-			    // Set data to empty to match all data, we already know its correct
-				plan.Delete = append(plan.Delete, txtRecord)
+			// Delete associated TXT record if managed. Only the heritage value
+			// goes, other TXT records at the name, e.g. SPF at the apex, stay.
+			if txtRecord, exists := index.ownedTXT(recordName); exists {
+				e.planTombstone(&plan, txtRecord, removedReason(host, changes))
+			}
+			for _, dup := range index.duplicateTXT(recordName) {
+				plan.addDelete(dup, removedReason(host, changes))
 				e.metrics.IncDNSOperation("delete", zone, "TXT")
 			}
 		}
 	}
+	plan.linkMoves(ctx, changes.Moved, e.zoneOf)
 	return plan, nil
 }
 
+// checkOwnership reports a host whose name another owner claims, by heritage
+// TXT record or record comment. It usually means two instances with
+// different owners serve the same host, and the records flip between them.
+func (e *engine) checkOwnership(ctx context.Context, plan *Plan, index *zoneIndex, zone, name string, main provider.Record, mainExists bool) {
+	owners := index.foreignOwners(name)
+	if owner, ok := parseHeritage(main.Comment); mainExists && ok && !isTombstone(main.Comment) && !slices.Contains(owners, owner) {
+		owners = append(slices.Clone(owners), owner)
+	}
+	for _, owner := range owners {
+		slog.WarnContext(ctx, "Ownership conflict, name is owned by another instance", "name", name, "zone", zone, "owner", owner, "this", e.cfg.Reconcile.Owner)
+		plan.Contested = append(plan.Contested, OwnershipConflict{Zone: zone, Name: name, Owner: owner})
+		e.metrics.IncOwnershipConflict(zone)
+	}
+}
+
+// currentOwnership reports whether an existing main record is owned in the
+// configured ownership mode with heritage in the current format
+func (e *engine) currentOwnership(main, txt provider.Record, txtExists bool, ownerTXT string) bool {
+	if e.useComments {
+		return main.Comment == ownerTXT && !txtExists
+	}
+	return txtExists && provider.NormalizeTXT(txt.Data) == ownerTXT
+}
+
+// planOwnership plans moving the ownership of a matching, owned main record
+// to the configured mode and heritage format. Returns false if it cannot be
+// done in place.
+func (e *engine) planOwnership(plan *Plan, desired, main, txt provider.Record, txtExists bool, ownerTXT string) bool {
+	reason := ReasonHeritage
+	if txtExists == e.useComments {
+		reason = ReasonOwnership
+	}
+	if e.useComments {
+		if main.ID == "" {
+			return false
+		}
+		if main.Comment != ownerTXT {
+			if ownsComment(main, e.owners) {
+				reason = e.heritageReason(main.Comment, ownerTXT)
+			}
+			plan.addUpdate(desired, main, reason)
+			e.metrics.IncDNSOperation("update", desired.Zone, desired.Type)
+		}
+		if txtExists {
+			plan.addDelete(txt, reason)
+			e.metrics.IncDNSOperation("delete", desired.Zone, "TXT")
+		}
+		return true
+	}
+	if txtExists && txt.ID == "" {
+		return false
+	}
+	e.planHeritage(plan, desired, txt, txtExists, ownerTXT)
+	return true
+}
+
+// planHeritage brings the heritage TXT record of an owned host in line with
+// the ownership mode, after its main record was planned
+func (e *engine) planHeritage(plan *Plan, desired, txt provider.Record, txtExists bool, ownerTXT string) {
+	heritage := heritageRecord(desired, ownerTXT)
+	switch {
+	case e.useComments && txtExists:
+		plan.addDelete(txt, ReasonOwnership)
+		e.metrics.IncDNSOperation("delete", desired.Zone, "TXT")
+	case e.useComments:
+	case !txtExists:
+		plan.addCreate(heritage, ReasonOwnership)
+		e.metrics.IncDNSOperation("create", desired.Zone, "TXT")
+	case txt.ID != "" && provider.NormalizeTXT(txt.Data) != ownerTXT:
+		plan.addUpdate(heritage, txt, e.heritageReason(txt.Data, ownerTXT))
+		e.metrics.IncDNSOperation("update", desired.Zone, "TXT")
+	}
+}
+
+// heritageReason explains rewriting owned heritage data to ownerTXT, either
+// written by an accepted owner, naming another source or in an outdated format
+func (e *engine) heritageReason(data, ownerTXT string) string {
+	if owner, _ := parseHeritage(data); owner != e.cfg.Reconcile.Owner {
+		return reasonAcceptedOwner(owner)
+	}
+	if withoutSource(provider.NormalizeTXT(data)) == withoutSource(ownerTXT) {
+		return ReasonSource
+	}
+	return ReasonHeritage
+}
+
+// ownsComment reports whether the comment of a record marks it as owned by
+// one of owners
+func ownsComment(r provider.Record, owners []string) bool {
+	return r.Comment != "" && ownsHeritage(r.Comment, owners)
+}
+
+// ownsHeritage reports whether heritage data names one of owners, a
+// tombstone left for deleted records does not
+func ownsHeritage(data string, owners []string) bool {
+	owner, ok := parseHeritage(data)
+	return ok && slices.Contains(owners, owner) && !isTombstone(data)
+}
+
+// expireHosts drops hosts from st not seen in caddy for longer than
+// expireAfter, so their records are deleted even if the removal was missed
+func (e *engine) expireHosts(ctx context.Context, st state.State) map[string]bool {
+	expired := make(map[string]bool)
+	if e.cfg.Reconcile.ExpireAfter <= 0 {
+		return expired
+	}
+	cutoff := e.now().Add(-e.cfg.Reconcile.ExpireAfter).Unix()
+	for host, d := range st.Domains {
+		if d.LastSeen < cutoff {
+			slog.InfoContext(ctx, "Host not seen in caddy, expiring records", "host", host, "last_seen", time.Unix(d.LastSeen, 0))
+			delete(st.Domains, host)
+			expired[host] = true
+		}
+	}
+	return expired
+}
+
+func removedReason(host string, changes state.StateChanges) string {
+	if changes.Expired[host] {
+		return ReasonHostExpired
+	}
+	return ReasonHostRemoved
+}
+
+// conflictingRecords returns the address records at a name, other than the
+// main record already being replaced, that providers refuse to keep alongside
+// the desired record. A CNAME cannot share its name with any address record.
+func conflictingRecords(desired, main provider.Record, existing []provider.Record) []provider.Record {
+	var conflicts []provider.Record
+	for _, r := range existing {
+		if r == main {
+			continue
+		}
+		if desired.Type == "CNAME" || r.Type == "CNAME" {
+			conflicts = append(conflicts, r)
+		}
+	}
+	return conflicts
+}
+
 func (e *engine) executePlan(ctx context.Context, plan Plan, newState state.State) (Results, error) {
 	results := Results{}
-	slog.Info("Execution mode", "dryRun", e.dryRun)
+	slog.InfoContext(ctx, "Execution mode", "dryRun", e.isDryRun())
 
-	if e.dryRun {
-		slog.Info("Dry run mode - would create records", "count", len(plan.Create))
-		slog.Info("Dry run mode - would delete records", "count", len(plan.Delete))
-
-		results.Created = make([]provider.Record, len(plan.Create))
-		copy(results.Created, plan.Create)
+	if e.isDryRun() {
+		slog.InfoContext(ctx, "Dry run mode - would create records", "count", len(plan.Create))
+		slog.InfoContext(ctx, "Dry run mode - would update records", "count", len(plan.Update))
+		slog.InfoContext(ctx, "Dry run mode - would delete records", "count", len(plan.Delete))
+		for _, ex := range plan.Explain {
+			slog.InfoContext(ctx, "Dry run mode - planned change", "op", ex.Op, "zone", ex.Zone, "name", ex.Name, "type", ex.Type, "data", ex.Data, "reason", ex.Reason)
+		}
 
-		results.Deleted = make([]provider.Record, len(plan.Delete))
-		copy(results.Deleted, plan.Delete)
 		// In dry-run mode, return early without saving state
 		results.Created = make([]provider.Record, len(plan.Create))
 		copy(results.Created, plan.Create)
+		results.Updated = make([]provider.Record, len(plan.Update))
+		copy(results.Updated, plan.Update)
 		results.Deleted = make([]provider.Record, len(plan.Delete))
 		copy(results.Deleted, plan.Delete)
+		results.Failures = plan.conflictFailures()
+		e.audit(ctx, plan, results)
 		return results, nil
 	}
 
-	// Execute creates
-	for _, record := range plan.Create {
-		slog.Debug("Start execute create from plan", "name", record.Name, "type", record.Type, "data", record.Data, "zone", record.Zone)
-		if err := e.dnsProvider.CreateRecord(ctx, record.Zone, record); err != nil {
-			slog.Error("Failed to create record", "name", record.Name, "error", err)
-			results.Failures = append(results.Failures, OperationResult{
-				Record: record,
-				Op:     "create",
-				Error:  err.Error(),
-			})
-		} else {
-			results.Created = append(results.Created, record)
-		}
+	// Journal the plan so an interrupted run can be recovered
+	if err := e.startJournal(ctx, plan); err != nil {
+		return results, fmt.Errorf("save journal: %w", err)
 	}
 
-	// Execute deletes
-	for _, record := range plan.Delete {
-		slog.Debug("Start execute delete from plan", "name", record.Name, "type", record.Type, "data", record.Data, "zone", record.Zone)
-		if err := e.dnsProvider.DeleteRecord(ctx, record.Zone, record); err != nil {
-			slog.Error("Failed to delete record", "name", record.Name, "error", err)
-			results.Failures = append(results.Failures, OperationResult{
-				Record: record,
-				Op:     "delete",
-				Error:  err.Error(),
-			})
-		} else {
-			results.Deleted = append(results.Deleted, record)
+	executed := []RecordGroup{}
+	aborted := &atomic.Bool{}
+	ids := knownIDs(plan, newState)
+	for _, inPhase := range e.executionPhases() {
+		groups := []RecordGroup{}
+		applied := appliedGroups(results)
+		for _, group := range plan.Groups {
+			if !inPhase(group) {
+				continue
+			}
+			// A moved host keeps its old records until the new ones exist
+			if group.After != "" && !applied[group.After] {
+				slog.WarnContext(ctx, "Keeping records of moved host, records in the new zone were not applied", "zone", group.Zone, "name", group.Name, "after", group.After)
+				continue
+			}
+			groups = append(groups, group)
 		}
+		executed = append(executed, e.executePhase(ctx, groups, aborted, ids, &results)...)
+	}
+
+	if len(results.Failures) > 0 && e.cfg.Reconcile.RollbackOnFailure {
+		e.rollback(ctx, executed, &results)
 	}
+	// Conflicts never reached the provider, so there is nothing to roll back
+	results.Failures = append(results.Failures, plan.conflictFailures()...)
+	e.audit(ctx, plan, results)
+	e.finishJournal(ctx)
 
 	// Only persist state if all operations succeeded
 	if len(results.Failures) == 0 {
+		now := e.now().Unix()
+		for host, record := range plan.Applies {
+			if d, exists := newState.Domains[host]; exists {
+				d.LastApplied = now
+				d.AppliedType = record.Type
+				d.AppliedData = record.Data
+				d.Records = e.managedRecords(plan, results, record, d)
+				newState.Domains[host] = d
+			}
+		}
 		if err := e.stateManager.SaveState(ctx, newState); err != nil {
 			return results, fmt.Errorf("save state: %w", err)
 		}
 	} else {
-		slog.Warn("Not persisting state due to failed operations", "failures", len(results.Failures))
+		slog.WarnContext(ctx, "Not persisting state due to failed operations", "failures", len(results.Failures))
 	}
 
 	return results, nil
 }
 
-func (e *engine) isProtected(name string) bool {
-	return e.protected[name]
+// executionPhases returns group filters in execution order. Creates run
+// before deletes unless configured otherwise, but records replaced for a host
+// are always removed before the host's new records are created, and the old
+// records of a host moved across zones are always removed last.
+func (e *engine) executionPhases() []func(RecordGroup) bool {
+	op := func(name string) func(RecordGroup) bool {
+		return func(g RecordGroup) bool { return g.Op == name }
+	}
+	if e.cfg.Reconcile.ExecutionOrder == config.OrderDeletesFirst {
+		deleted := func(g RecordGroup) bool { return g.Op == "delete" && g.After == "" }
+		moved := func(g RecordGroup) bool { return g.Op == "delete" && g.After != "" }
+		return []func(RecordGroup) bool{deleted, op("create"), op("update"), moved}
+	}
+	replaced := func(g RecordGroup) bool { return g.Op == "delete" && g.Replaces }
+	removed := func(g RecordGroup) bool { return g.Op == "delete" && !g.Replaces }
+	return []func(RecordGroup) bool{replaced, op("create"), op("update"), removed}
+}
+
+// executePhase runs groups on up to e.workers workers. A group is handled by
+// a single worker so a host's main and TXT records stay together, and group
+// results are merged in plan order. Returns the groups that were executed.
+func (e *engine) executePhase(ctx context.Context, groups []RecordGroup, aborted *atomic.Bool, ids map[string]string, results *Results) []RecordGroup {
+	partial := make([]Results, len(groups))
+	ran := make([]bool, len(groups))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < min(e.workers, len(groups)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if aborted.Load() {
+					continue
+				}
+				e.executeGroup(ctx, groups[i], ids, &partial[i])
+				ran[i] = true
+
+				// Every remaining operation would be denied as well
+				for _, f := range partial[i].Failures {
+					if f.Class == provider.ClassPermission && aborted.CompareAndSwap(false, true) {
+						slog.ErrorContext(ctx, "Aborting run, provider denied permission", "error", f.Error)
+					}
+				}
+			}
+		}()
+	}
+	for i := range groups {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	executed := []RecordGroup{}
+	for i, group := range groups {
+		if ran[i] {
+			results.merge(partial[i])
+			executed = append(executed, group)
+		}
+	}
+	return executed
+}
+
+// executeGroup applies every record in the group, stopping at the first
+// failure and reverting the records already applied in the group. ids holds
+// the provider id state recorded for main records, see knownIDs. Groups the
+// provider can batch go through executeBatch instead.
+func (e *engine) executeGroup(ctx context.Context, group RecordGroup, ids map[string]string, results *Results) {
+	if e.executeBatch(ctx, group, results) {
+		return
+	}
+	applied := []provider.Record{}
+	var failure *OperationResult
+	// Set once the group turns out to have been created by an earlier sync
+	// whose records the provider does not list yet
+	created := false
+	for _, record := range group.Records {
+		slog.DebugContext(ctx, "Start execute from plan", "op", group.Op, "name", record.Name, "type", record.Type, "data", record.Data, "zone", record.Zone)
+		err := e.apply(ctx, group.Op, record)
+		if group.Op == "create" && errors.Is(err, provider.ErrConflict) {
+			if id, ok := ids[idKey(record)]; ok || created {
+				slog.InfoContext(ctx, "Record created by an earlier sync is not listed yet, treating as created", "name", record.Name, "type", record.Type, "zone", record.Zone, "id", id)
+				e.metrics.IncConflictResolved(record.Zone, record.Type, ResolutionUnlisted)
+				results.setID(record, id)
+				created, err = true, nil
+			} else if id, ok := e.adoptExisting(ctx, record); ok {
+				if id != "" {
+					results.setID(record, id)
+				}
+				err = nil
+			}
+		} else if err == nil && group.Op == "create" {
+			if id, ok := e.createdID(ctx, record); ok {
+				results.setID(record, id)
+			}
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to execute record operation", "op", group.Op, "name", record.Name, "type", record.Type, "error", err)
+			failure = &OperationResult{
+				Record: record,
+				Op:     group.Op,
+				Error:  err.Error(),
+				Class:  provider.ErrorClass(err),
+			}
+			break
+		}
+		e.journalApplied(ctx, group.Op, record)
+		applied = append(applied, record)
+	}
+
+	status := GroupApplied
+	if failure != nil {
+		results.Failures = append(results.Failures, *failure)
+		status = GroupRolledBack
+		// Revert in reverse order so the main record goes last
+		for i := len(applied) - 1; i >= 0; i-- {
+			record := applied[i]
+			op, revert := group.inverse(i)
+			if err := e.apply(ctx, op, revert); err != nil {
+				slog.ErrorContext(ctx, "Failed to roll back record operation", "op", group.Op, "name", record.Name, "type", record.Type, "error", err)
+				status = GroupPartial
+				e.collect(group.Op, record, results)
+			}
+		}
+	} else {
+		for _, record := range applied {
+			e.collect(group.Op, record, results)
+		}
+	}
+
+	if status != GroupApplied {
+		slog.WarnContext(ctx, "Record group not applied", "op", group.Op, "zone", group.Zone, "name", group.Name, "status", status)
+	}
+	results.Groups = append(results.Groups, GroupResult{
+		Op:     group.Op,
+		Zone:   group.Zone,
+		Name:   group.Name,
+		Status: status,
+	})
+}
+
+// rollback reverts every group applied during the run, newest first, restoring
+// deleted records from the snapshot fetched while planning
+func (e *engine) rollback(ctx context.Context, executed []RecordGroup, results *Results) {
+	slog.WarnContext(ctx, "Rolling back run after failed operations", "failures", len(results.Failures))
+	for i := len(executed) - 1; i >= 0; i-- {
+		if results.Groups[i].Status != GroupApplied {
+			continue
+		}
+		group := executed[i]
+		status := GroupRolledBack
+		for j := len(group.Records) - 1; j >= 0; j-- {
+			record := group.Records[j]
+			op, revert := group.inverse(j)
+			reverted := OperationResult{Record: revert, Op: op}
+			if err := e.apply(ctx, op, revert); err != nil {
+				slog.ErrorContext(ctx, "Failed to roll back record operation", "op", group.Op, "name", record.Name, "type", record.Type, "error", err)
+				reverted.Error = err.Error()
+				status = GroupPartial
+			} else {
+				results.Created = removeRecord(results.Created, record)
+				results.Updated = removeRecord(results.Updated, record)
+				results.Deleted = removeRecord(results.Deleted, record)
+			}
+			results.Reverted = append(results.Reverted, reverted)
+		}
+		results.Groups[i].Status = status
+	}
+}
+
+func removeRecord(records []provider.Record, record provider.Record) []provider.Record {
+	for i, r := range records {
+		if r == record {
+			return append(records[:i], records[i+1:]...)
+		}
+	}
+	return records
+}
+
+// apply runs a single operation, retrying while rate limited. Deleting a
+// record that no longer exists counts as success.
+func (e *engine) apply(ctx context.Context, op string, record provider.Record) error {
+	err := e.retryRateLimited(ctx, op, record.Name, func() error {
+		return e.applyOnce(ctx, op, record)
+	})
+	if op == "delete" && errors.Is(err, provider.ErrNotFound) {
+		slog.InfoContext(ctx, "Record already deleted", "name", record.Name, "type", record.Type, "zone", record.Zone)
+		return nil
+	}
+	return err
+}
+
+// retryRateLimited runs fn, backing off and retrying while it is rate limited
+func (e *engine) retryRateLimited(ctx context.Context, op, name string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if !errors.Is(err, provider.ErrRateLimited) || attempt >= maxRateLimitRetries {
+			return err
+		}
+		backoff := e.retryBackoff << attempt
+		slog.WarnContext(ctx, "Rate limited by provider, retrying", "op", op, "name", name, "attempt", attempt+1, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (e *engine) applyOnce(ctx context.Context, op string, record provider.Record) error {
+	switch op {
+	case "create":
+		if e.cfg.Reconcile.CheckBeforeCreate {
+			exists, err := e.existsBeforeCreate(ctx, record)
+			if err != nil || exists {
+				return err
+			}
+		}
+		return e.dnsProvider.CreateRecord(ctx, record.Zone, record)
+	case "update":
+		return e.dnsProvider.UpdateRecord(ctx, record.Zone, record)
+	case "delete":
+		return e.dnsProvider.DeleteRecord(ctx, record.Zone, record)
+	}
+	return fmt.Errorf("unknown operation %s", op)
+}
+
+// existsBeforeCreate looks a record up right before creating it, so instances
+// misconfigured with the same owner do not create duplicates. Returns true if
+// an identical record exists, and a conflict if the name already has a
+// different record of the same address type.
+func (e *engine) existsBeforeCreate(ctx context.Context, record provider.Record) (bool, error) {
+	existing, err := e.lookupRecords(ctx, record)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range existing {
+		if provider.SameValue(r, record) {
+			slog.WarnContext(ctx, "Record already exists, skipping create", "name", record.Name, "type", record.Type, "zone", record.Zone)
+			return true, nil
+		}
+	}
+	if record.Type != "TXT" && len(existing) > 0 {
+		return false, fmt.Errorf("%s already has %s record %s: %w", record.Name, record.Type, existing[0].Data, provider.ErrConflict)
+	}
+	return false, nil
+}
+
+// lookupRecords returns the records at the provider with the name and type of record
+func (e *engine) lookupRecords(ctx context.Context, record provider.Record) ([]provider.Record, error) {
+	if finder, ok := e.dnsProvider.(provider.Finder); ok {
+		return finder.FindRecords(ctx, record.Zone, record.Name, record.Type)
+	}
+	records, err := e.dnsProvider.GetRecords(ctx, record.Zone)
+	if err != nil {
+		return nil, err
+	}
+	existing := []provider.Record{}
+	name := getRecordName(record.Name, record.Zone)
+	for _, r := range records {
+		if r.Type == record.Type && getRecordName(r.Name, r.Zone) == name {
+			existing = append(existing, r)
+		}
+	}
+	return existing, nil
+}
+
+// collect adds a record whose operation took effect at the provider to results
+func (e *engine) collect(op string, record provider.Record, results *Results) {
+	switch op {
+	case "create":
+		results.Created = append(results.Created, record)
+	case "update":
+		results.Updated = append(results.Updated, record)
+	case "delete":
+		results.Deleted = append(results.Deleted, record)
+	}
 }
 
+// audit persists the outcome of every planned operation along with its reason
+func (e *engine) audit(ctx context.Context, plan Plan, results Results) {
+	now := e.now().Unix()
+	result := "success"
+	if e.isDryRun() {
+		result = "dry_run"
+	}
+
+	runID := runid.FromContext(ctx)
+
+	// A completed move is a single entry rather than its creates and deletes
+	collapsed := make(map[string]bool)
+	entries := []state.AuditEntry{}
+	applied := appliedGroups(results)
+	for _, m := range plan.Moves {
+		record := plan.Applies[m.To]
+		to := groupKey(record.Zone, record.Name)
+		from := groupKey(m.FromZone, m.From)
+		if !e.isDryRun() && (!applied[to] || !applied[from]) {
+			continue
+		}
+		collapsed[to], collapsed[from] = true, true
+		entries = append(entries, state.AuditEntry{
+			Time:   now,
+			Op:     "move",
+			Zone:   record.Zone,
+			Name:   record.Name,
+			Type:   record.Type,
+			Data:   record.Data,
+			Reason: reasonMovedFrom(m.From),
+			Result: result,
+			RunID:  runID,
+			Source: plan.Sources[to],
+		})
+	}
+	add := func(op, result, errStr string, record provider.Record) {
+		entries = append(entries, state.AuditEntry{
+			Time:   now,
+			Op:     op,
+			Zone:   record.Zone,
+			Name:   record.Name,
+			Type:   record.Type,
+			Data:   record.Data,
+			Reason: plan.Reason(op, record),
+			Result: result,
+			Error:  errStr,
+			RunID:  runID,
+			Source: plan.Sources[groupKey(record.Zone, record.Name)],
+		})
+	}
+	for _, record := range results.Created {
+		if collapsed[groupKey(record.Zone, record.Name)] {
+			continue
+		}
+		add("create", result, "", record)
+	}
+	for _, record := range results.Updated {
+		if collapsed[groupKey(record.Zone, record.Name)] {
+			continue
+		}
+		add("update", result, "", record)
+	}
+	for _, record := range results.Deleted {
+		if collapsed[groupKey(record.Zone, record.Name)] {
+			continue
+		}
+		add("delete", result, "", record)
+	}
+	for _, failure := range results.Failures {
+		add(failure.Op, "failure", failure.Error, failure.Record)
+	}
+	// Ownership conflicts are recorded every run they are seen, they change
+	// nothing but need attention
+	for _, c := range plan.Contested {
+		entries = append(entries, state.AuditEntry{
+			Time:   now,
+			Op:     "ownership_conflict",
+			Zone:   c.Zone,
+			Name:   c.Name,
+			Reason: reasonOwnedBy(c.Owner),
+			Result: "conflict",
+			RunID:  runID,
+		})
+	}
+	for _, reverted := range results.Reverted {
+		result := "rolled_back"
+		if reverted.Error != "" {
+			result = "failure"
+		}
+		add(reverted.Op, result, reverted.Error, reverted.Record)
+		entries[len(entries)-1].Reason = ReasonRollback
+	}
+
+	if err := e.stateManager.AppendAudit(ctx, entries); err != nil {
+		slog.WarnContext(ctx, "Failed to write audit entries", "count", len(entries), "error", err)
+	}
+}
+
+// isProtected reports whether host is listed in protectedRecords, or its
+// record name matches a protected pattern of its zone
+func (e *engine) isProtected(host string) bool {
+	if e.protected[host] || isACMEChallenge(host) {
+		return true
+	}
+	for _, zone := range e.zones {
+		if e.inZone(host, zone) && e.zoneProtected(zone, getRecordName(host, zone)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *engine) inAnyZone(host string) bool {
+	for _, zone := range e.zones {
+		if belongsToZone(host, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// belongsToZone matches the exact zone or subdomains with dot separator. It
+// runs for every record and host of a plan, so avoids building "."+zone.
 func belongsToZone(host, zone string) bool {
-	// Match exact zone or subdomains with dot separator
-	slog.Debug("Zone check", "host", host, "zone", zone, "matches", host == zone || strings.HasSuffix(host, "."+zone))
-	return host == zone || strings.HasSuffix(host, "."+zone)
+	if !strings.HasSuffix(host, zone) {
+		return false
+	}
+	return len(host) == len(zone) || host[len(host)-len(zone)-1] == '.'
 }
 
 func getRecordName(host, zone string) string {
-	name := strings.TrimSuffix(host, "."+zone)
-	slog.Debug("Record name extraction", "host", host, "zone", zone, "result", name)
 	if host == zone {
 		return "@"
 	}
-	return name
+	if belongsToZone(host, zone) {
+		return host[:len(host)-len(zone)-1]
+	}
+	return host
 }
 
 func getRecordType(host string) string {
@@ -367,6 +1566,11 @@ func extractHostFromUpstream(upstream string) string {
 		}
 	}
 
+	// A bare IPv6 address has no port to split off
+	if net.ParseIP(upstream) != nil {
+		return upstream
+	}
+
 	// Split on first colon to handle invalid formats like "host:port:extra"
 	if host, _, ok := strings.Cut(upstream, ":"); ok {
 		return host
@@ -374,7 +1578,114 @@ func extractHostFromUpstream(upstream string) string {
 	return upstream
 }
 
+// HeritageData returns the ownership TXT record data for owner
+func HeritageData(owner string) string {
+	return txtIdentifier(owner)
+}
+
 // TXT record used to identify managed records
 func txtIdentifier(owner string) string {
-	return fmt.Sprintf("heritage=caddy-dns-sync,caddy-dns-sync/owner=%s", owner)
+	return "heritage=caddy-dns-sync,caddy-dns-sync/owner=" + escapeOwner(owner)
+}
+
+// sourceField names the source of a host in heritage data
+const sourceField = "caddy-dns-sync/source"
+
+// heritageData returns the heritage the written owner marks the records of a
+// host found in src with, naming src when reconcile.heritageSource is set
+func (e *engine) heritageData(src string) string {
+	data := txtIdentifier(e.cfg.Reconcile.Owner)
+	if e.cfg.Reconcile.HeritageSource && src != "" {
+		data += "," + sourceField + "=" + escapeOwner(src)
+	}
+	return data
+}
+
+// withoutSource drops the source field from heritage data, leaving what
+// identifies the owner
+func withoutSource(data string) string {
+	i := strings.Index(data, ","+sourceField+"=")
+	if i < 0 {
+		return data
+	}
+	if end := strings.IndexByte(data[i+1:], ','); end >= 0 {
+		return data[:i] + data[i+1+end:]
+	}
+	return data[:i]
+}
+
+// ownerEscaper percent encodes the characters separating heritage fields, and
+// the percent sign itself so escaping round trips
+var ownerEscaper = strings.NewReplacer("%", "%25", ",", "%2C", "=", "%3D")
+
+var ownerUnescaper = strings.NewReplacer("%25", "%", "%2C", ",", "%2c", ",", "%3D", "=", "%3d", "=")
+
+func escapeOwner(owner string) string {
+	if !strings.ContainsAny(owner, "%,=") {
+		return owner
+	}
+	return ownerEscaper.Replace(owner)
+}
+
+func unescapeOwner(owner string) string {
+	if !strings.Contains(owner, "%") {
+		return owner
+	}
+	return ownerUnescaper.Replace(owner)
+}
+
+// ManagedRecords returns the records of zone owned by any of owners, each
+// host's address record followed by its heritage TXT record. Address records
+// owned through their comment are returned alone.
+func ManagedRecords(records []provider.Record, zone string, owners ...string) []provider.Record {
+	records = withoutACMEChallenges(records, zone)
+	owned := make(map[string]provider.Record)
+	for _, r := range records {
+		if r.Type != "TXT" {
+			continue
+		}
+		if ownsHeritage(r.Data, owners) {
+			owned[getRecordName(r.Name, zone)] = r
+		}
+	}
+	managed := []provider.Record{}
+	for _, r := range records {
+		switch r.Type {
+		case "A", "AAAA", "CNAME":
+			if txt, ok := owned[getRecordName(r.Name, zone)]; ok {
+				managed = append(managed, r, txt)
+			} else if ownsComment(r, owners) {
+				managed = append(managed, r)
+			}
+		}
+	}
+	return managed
+}
+
+// parseHeritage returns the owner of a heritage TXT record, ok is false if
+// the data is not a caddy-dns-sync heritage record. Records written before
+// owners were escaped may hold a raw comma in the owner, fields following the
+// owner that are not heritage fields are taken as part of it.
+func parseHeritage(data string) (owner string, ok bool) {
+	heritage := false
+	inOwner := false
+	fields, _ := legacyHeritage(data)
+	for fields != "" {
+		var field string
+		field, fields, _ = strings.Cut(fields, ",")
+		key, value, hasValue := strings.Cut(strings.TrimSpace(field), "=")
+		switch {
+		case key == "heritage":
+			heritage = value == "caddy-dns-sync"
+			inOwner = false
+		case key == "caddy-dns-sync/owner":
+			owner = value
+			inOwner = true
+		case inOwner && !hasValue:
+			owner += "," + field
+		default:
+			inOwner = false
+		}
+	}
+	return unescapeOwner(owner), heritage && owner != ""
 }
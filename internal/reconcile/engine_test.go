@@ -3,19 +3,49 @@ package reconcile
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/evanofslack/caddy-dns-sync/internal/config"
 	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
 	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
 	"github.com/evanofslack/caddy-dns-sync/internal/source"
 	"github.com/evanofslack/caddy-dns-sync/internal/state"
 )
 
 type MockStateManager struct {
-	state state.State
-	err   error
+	state    state.State
+	audit    []state.AuditEntry
+	failures map[string]state.HostFailure
+	paused   bool
+	journal  []state.JournalEntry
+	err      error
+}
+
+func (m *MockStateManager) LoadJournal(ctx context.Context) ([]state.JournalEntry, error) {
+	return m.journal, nil
+}
+func (m *MockStateManager) SaveJournal(ctx context.Context, entries []state.JournalEntry) error {
+	m.journal = entries
+	return nil
+}
+func (m *MockStateManager) MarkJournalApplied(ctx context.Context, index int) error {
+	m.journal[index].Applied = true
+	return nil
+}
+
+func (m *MockStateManager) LoadPaused(ctx context.Context) (bool, error) { return m.paused, nil }
+func (m *MockStateManager) SavePaused(ctx context.Context, paused bool) error {
+	m.paused = paused
+	return nil
 }
 
 func (m *MockStateManager) LoadState(ctx context.Context) (state.State, error) { return m.state, m.err }
@@ -23,29 +53,120 @@ func (m *MockStateManager) SaveState(ctx context.Context, s state.State) error {
 	m.state = s
 	return m.err
 }
-func (m *MockStateManager) Close() error { return nil }
+func (m *MockStateManager) AppendAudit(ctx context.Context, entries []state.AuditEntry) error {
+	m.audit = append(m.audit, entries...)
+	return nil
+}
+func (m *MockStateManager) LoadAudit(ctx context.Context, limit int) ([]state.AuditEntry, error) {
+	return m.audit, nil
+}
+func (m *MockStateManager) PruneAudit(ctx context.Context, before int64, keep int) (int, error) {
+	return 0, nil
+}
+func (m *MockStateManager) Size(ctx context.Context) (state.Size, error) {
+	return state.Size{}, nil
+}
+func (m *MockStateManager) InstanceID(ctx context.Context, generate func() string) (string, error) {
+	return generate(), nil
+}
+func (m *MockStateManager) LoadFailures(ctx context.Context) (map[string]state.HostFailure, error) {
+	failures := make(map[string]state.HostFailure)
+	for host, f := range m.failures {
+		failures[host] = f
+	}
+	return failures, nil
+}
+func (m *MockStateManager) SaveFailures(ctx context.Context, failures map[string]state.HostFailure) error {
+	m.failures = failures
+	return nil
+}
+func (m *MockStateManager) Backup(ctx context.Context, w io.Writer) error { return nil }
+func (m *MockStateManager) Close() error                                  { return nil }
 
 type MockProvider struct {
-	records      map[string][]provider.Record
-	createErr    error
-	deleteErr    error
+	mu            sync.Mutex
+	records       map[string][]provider.Record
+	createErr     error
+	createErrType string // only fail creates of this record type when set
+	createErrName string // only fail creates of this record name when set
+	createErrs    []error // returned by successive creates before createErr applies
+	deleteErr     error
+	updateErr     error
+	updateErrName string // only fail updates of this record name when set
 	getRecordsErr error
+	listed        []string // zones GetRecords was called for
+	created       []provider.Record
+	deleted       []provider.Record
+	updated       []provider.Record
+	ops           []string      // "op name" of every applied operation in order
+	delay         time.Duration // time each create takes
+	inflight      int
+	maxInflight   int
+	comments      bool // records keep comments
+}
+
+func (m *MockProvider) SupportsComments() bool {
+	return m.comments
 }
 
 func (m *MockProvider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	m.mu.Lock()
+	m.listed = append(m.listed, zone)
+	m.mu.Unlock()
 	return m.records[zone], m.getRecordsErr
 }
 
 func (m *MockProvider) CreateRecord(ctx context.Context, zone string, r provider.Record) error {
-	return m.createErr
+	if m.delay > 0 {
+		m.mu.Lock()
+		m.inflight++
+		m.maxInflight = max(m.maxInflight, m.inflight)
+		m.mu.Unlock()
+		time.Sleep(m.delay)
+		defer func() {
+			m.mu.Lock()
+			m.inflight--
+			m.mu.Unlock()
+		}()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.createErrs) > 0 {
+		err := m.createErrs[0]
+		m.createErrs = m.createErrs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	if m.createErr != nil && (m.createErrType == "" || m.createErrType == r.Type) &&
+		(m.createErrName == "" || m.createErrName == r.Name) {
+		return m.createErr
+	}
+	m.created = append(m.created, r)
+	m.ops = append(m.ops, "create "+r.Name)
+	return nil
 }
 
 func (m *MockProvider) UpdateRecord(ctx context.Context, zone string, r provider.Record) error {
-	return nil // Not used in current tests
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.updateErr != nil && (m.updateErrName == "" || m.updateErrName == r.Name) {
+		return m.updateErr
+	}
+	m.updated = append(m.updated, r)
+	m.ops = append(m.ops, "update "+r.Name)
+	return nil
 }
 
 func (m *MockProvider) DeleteRecord(ctx context.Context, zone string, r provider.Record) error {
-	return m.deleteErr
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.deleteErr != nil {
+		return m.deleteErr
+	}
+	m.deleted = append(m.deleted, r)
+	m.ops = append(m.ops, "delete "+r.Name)
+	return nil
 }
 
 func TestEngine(t *testing.T) {
@@ -271,6 +392,22 @@ func TestEngine(t *testing.T) {
 				Created: []provider.Record{},
 			},
 		},
+		{
+			name: "host outside configured zones",
+			initialState: state.State{
+				Domains: map[string]state.DomainState{},
+			},
+			currentDomains: []source.DomainConfig{
+				{Host: "app.example.net", Upstream: "10.0.0.1:8080"},
+			},
+			providerSetup: map[string][]provider.Record{
+				"example.com": {},
+			},
+			config: testConfig,
+			expected: Results{
+				Created: []provider.Record{},
+			},
+		},
 		{
 			name: "cname creation",
 			initialState: state.State{
@@ -531,3 +668,3237 @@ func TestExtractHostname(t *testing.T) {
 		})
 	}
 }
+
+func TestFilteredHosts(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{
+			ProtectedRecords: []string{"protected.example.com"},
+			Owner:            "test-owner",
+		},
+		DNS: config.DNS{
+			Zones: []string{"example.com"},
+		},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	provider := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "protected.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "app.example.net", Upstream: "10.0.0.3:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []FilteredHost{
+		{Host: "app.example.net", Reason: FilterReasonNoZone},
+		{Host: "protected.example.com", Reason: FilterReasonProtected},
+	}
+	if got := engine.Filtered(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Filtered() = %+v, want %+v", got, expected)
+	}
+}
+
+func TestPlanExplain(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{
+		Domains: map[string]state.DomainState{
+			"changed.example.com": {ServerName: "10.0.0.1:8080"},
+			"old.example.com":     {ServerName: "10.0.0.2:8080"},
+		},
+	}}
+	provider := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			{Name: "changed", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "changed", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			{Name: "old", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+			{Name: "old", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+		},
+	}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "changed.example.com", Upstream: "10.0.0.3:8080"},
+		{Host: "new.example.com", Upstream: "10.0.0.4:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reasons := make(map[string]string)
+	for _, ex := range engine.LastPlan().Explain {
+		reasons[ex.Op+" "+ex.Name+" "+ex.Type] = ex.Reason
+	}
+	expected := map[string]string{
+		"delete changed A":   ReasonDataMismatch,
+		"delete changed TXT": ReasonDataMismatch,
+		"create changed A":   "upstream changed from 10.0.0.1:8080 to 10.0.0.3:8080",
+		"create changed TXT": "upstream changed from 10.0.0.1:8080 to 10.0.0.3:8080",
+		"create new A":       ReasonHostAdded,
+		"create new TXT":     ReasonHostAdded,
+		"delete old A":       ReasonHostRemoved,
+		"delete old TXT":     ReasonHostRemoved,
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Explain reasons = %+v, want %+v", reasons, expected)
+	}
+
+	if len(stateManager.audit) != len(expected) {
+		t.Fatalf("Audit entries mismatch: got %d, want %d", len(stateManager.audit), len(expected))
+	}
+	for _, entry := range stateManager.audit {
+		if entry.Result != "success" || entry.Reason != expected[entry.Op+" "+entry.Name+" "+entry.Type] {
+			t.Errorf("Unexpected audit entry %+v", entry)
+		}
+	}
+}
+
+func TestRecordGroupRollback(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	provider := &MockProvider{
+		records:       map[string][]provider.Record{"example.com": {}},
+		createErr:     errors.New("dns failure"),
+		createErrType: "TXT",
+	}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "new.example.com", Upstream: "10.0.0.1:8080"},
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(results.Created) != 0 {
+		t.Errorf("Created records mismatch: got %d, want 0", len(results.Created))
+	}
+	if len(results.Failures) != 1 || results.Failures[0].Record.Type != "TXT" {
+		t.Errorf("Expected single TXT failure, got %+v", results.Failures)
+	}
+	expectedGroups := []GroupResult{
+		{Op: "create", Zone: "example.com", Name: "new", Status: GroupRolledBack},
+	}
+	if !reflect.DeepEqual(results.Groups, expectedGroups) {
+		t.Errorf("Groups = %+v, want %+v", results.Groups, expectedGroups)
+	}
+	// The A record was created then rolled back
+	if len(provider.created) != 1 || len(provider.deleted) != 1 || provider.deleted[0].Type != "A" {
+		t.Errorf("Expected A record create and rollback delete, got created=%+v deleted=%+v", provider.created, provider.deleted)
+	}
+	if len(stateManager.state.Domains) != 0 {
+		t.Error("State should not be persisted after rolled back group")
+	}
+}
+
+func TestRollbackOnFailure(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", RollbackOnFailure: true},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	provider := &MockProvider{
+		records:       map[string][]provider.Record{"example.com": {}},
+		createErr:     errors.New("dns failure"),
+		createErrType: "CNAME",
+	}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "ip.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "cname.example.com", Upstream: "backend.internal:8080"},
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(results.Created) != 0 {
+		t.Errorf("Created records should be rolled back, got %+v", results.Created)
+	}
+	if len(results.Reverted) != 2 {
+		t.Errorf("Reverted mismatch: got %d, want 2", len(results.Reverted))
+	}
+	for _, reverted := range results.Reverted {
+		if reverted.Op != "delete" || reverted.Record.Name != "ip" || reverted.Error != "" {
+			t.Errorf("Unexpected reverted operation %+v", reverted)
+		}
+	}
+	for _, group := range results.Groups {
+		if group.Status != GroupRolledBack {
+			t.Errorf("Group %s status = %s, want %s", group.Name, group.Status, GroupRolledBack)
+		}
+	}
+}
+
+func TestHostAttributes(t *testing.T) {
+	proxied := true
+	unproxied := false
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{
+			Owner: "test-owner",
+			HostAttributes: map[string]config.HostAttributes{
+				"*.example.com":      {Proxied: &proxied, TTL: 300 * time.Second},
+				"cdn.example.com":    {Type: "CNAME", Target: "edge.example.net"},
+				"direct.example.com": {Proxied: &unproxied},
+			},
+		},
+		DNS: config.DNS{Zones: []string{"example.com"}},
+	}
+	provider := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(&MockStateManager{}, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "cdn.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "direct.example.com", Upstream: "10.0.0.3:8080"},
+		{Host: "example.com", Upstream: "10.0.0.4:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, r := range provider.created {
+		if r.Type == "TXT" {
+			continue
+		}
+		got[r.Name] = fmt.Sprintf("%s %s ttl=%s proxied=%v", r.Type, r.Data, r.TTL, r.Proxied)
+	}
+	expected := map[string]string{
+		"app":    "A 10.0.0.1 ttl=5m0s proxied=true",
+		"cdn":    "CNAME edge.example.net ttl=5m0s proxied=true",
+		"direct": "A 10.0.0.3 ttl=5m0s proxied=false",
+		"@":      fmt.Sprintf("A 10.0.0.4 ttl=%s proxied=false", time.Duration(defaultTTL)),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Created records = %+v, want %+v", got, expected)
+	}
+}
+
+func TestSkipAfterFailures(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", SkipAfterFailures: 2},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{}
+	provider := &MockProvider{
+		records:       map[string][]provider.Record{"example.com": {}},
+		createErr:     errors.New("cname at apex not supported"),
+		createErrName: "bad",
+	}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+	domains := []source.DomainConfig{
+		{Host: "bad.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "good.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	ctx := context.Background()
+
+	for run := 1; run <= 2; run++ {
+		results, err := engine.Reconcile(ctx, domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(results.Failures) != 1 {
+			t.Fatalf("Run %d: expected 1 failure, got %d", run, len(results.Failures))
+		}
+		if got := stateManager.failures["bad.example.com"]; got.Count != run || got.Skipped != (run == 2) {
+			t.Errorf("Run %d: unexpected failure tracking %+v", run, got)
+		}
+	}
+	if _, ok := stateManager.failures["good.example.com"]; ok {
+		t.Error("Expected no failure tracked for applied host")
+	}
+
+	// Skipped host is no longer attempted, so the run succeeds and state persists
+	provider.created = nil
+	results, err := engine.Reconcile(ctx, domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results.Failures) != 0 {
+		t.Errorf("Expected skipped host not to be retried, got failures %+v", results.Failures)
+	}
+	if _, ok := stateManager.state.Domains["bad.example.com"]; ok {
+		t.Error("Expected skipped host to be left out of saved state")
+	}
+	expected := []FilteredHost{{Host: "bad.example.com", Reason: FilterReasonSkipped}}
+	if got := engine.Filtered(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Filtered() = %+v, want %+v", got, expected)
+	}
+	skipped, err := engine.Skipped(ctx)
+	if err != nil || len(skipped) != 1 || skipped[0].Host != "bad.example.com" || skipped[0].Reason == "" {
+		t.Errorf("Unexpected skipped hosts %+v err=%v", skipped, err)
+	}
+
+	// Clearing retries the host on the next sync
+	if cleared, err := engine.ClearSkipped(ctx, "bad.example.com"); err != nil || cleared != 1 {
+		t.Fatalf("Expected 1 host cleared, got %d err=%v", cleared, err)
+	}
+	results, err = engine.Reconcile(ctx, domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results.Failures) != 1 {
+		t.Errorf("Expected cleared host to be retried, got failures %+v", results.Failures)
+	}
+}
+
+func TestProviderErrorClasses(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	rateLimited := fmt.Errorf("failed to create DNS record: %w", provider.ErrRateLimited)
+	denied := fmt.Errorf("failed to create DNS record: %w", provider.ErrPermission)
+	notFound := fmt.Errorf("failed to delete DNS record: %w", provider.ErrNotFound)
+
+	tests := []struct {
+		name         string
+		initialState state.State
+		records      []provider.Record
+		domains      []source.DomainConfig
+		createErrs   []error
+		createErr    error
+		deleteErr    error
+		failures     int
+		groups       int
+	}{
+		{
+			name:       "rate limited create is retried",
+			domains:    []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}},
+			createErrs: []error{rateLimited, rateLimited},
+			failures:   0,
+			groups:     1,
+		},
+		{
+			name:      "permission denied aborts run",
+			domains:   []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}, {Host: "b.example.com", Upstream: "10.0.0.2:8080"}},
+			createErr: denied,
+			failures:  1,
+			groups:    1,
+		},
+		{
+			name: "delete of missing record succeeds",
+			initialState: state.State{Domains: map[string]state.DomainState{
+				"old.example.com": {ServerName: "10.0.0.1:8080"},
+			}},
+			records: []provider.Record{
+				{Name: "old", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+				{Name: "old", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			},
+			deleteErr: notFound,
+			failures:  0,
+			groups:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &MockProvider{
+				records:    map[string][]provider.Record{"example.com": tt.records},
+				createErrs: tt.createErrs,
+				createErr:  tt.createErr,
+				deleteErr:  tt.deleteErr,
+			}
+			engine := NewEngine(&MockStateManager{state: tt.initialState}, provider, cfg, metrics.New(false))
+			engine.retryBackoff = 0
+
+			results, err := engine.Reconcile(context.Background(), tt.domains)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(results.Failures) != tt.failures {
+				t.Errorf("Failures = %+v, want %d", results.Failures, tt.failures)
+			}
+			if len(results.Groups) != tt.groups {
+				t.Errorf("Groups = %+v, want %d", results.Groups, tt.groups)
+			}
+		})
+	}
+}
+
+func TestUpdateInPlace(t *testing.T) {
+	initial := state.State{Domains: map[string]state.DomainState{
+		"a.example.com": {ServerName: "10.0.0.1:8080"},
+		"b.example.com": {ServerName: "10.0.0.2:8080"},
+	}}
+	existing := []provider.Record{
+		{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+		{ID: "b-main", Name: "b.example.com", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+		{ID: "b-txt", Name: "b.example.com", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+	}
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.1.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.1.2:8080"},
+	}
+
+	t.Run("updated records keep their id", func(t *testing.T) {
+		cfg := &config.Config{
+			Reconcile: config.Reconcile{Owner: "test-owner"},
+			DNS:       config.DNS{Zones: []string{"example.com"}},
+		}
+		stateManager := &MockStateManager{state: initial}
+		provider := &MockProvider{records: map[string][]provider.Record{"example.com": existing}}
+		engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+		results, err := engine.Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(results.Created) != 0 || len(results.Deleted) != 0 || len(results.Updated) != 2 {
+			t.Fatalf("Expected 2 updates only, got %+v", results)
+		}
+		got := map[string]string{}
+		for _, r := range provider.updated {
+			got[r.ID] = r.Data
+		}
+		if expected := map[string]string{"a-main": "10.0.1.1", "b-main": "10.0.1.2"}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("Updated records = %+v, want %+v", got, expected)
+		}
+		for _, entry := range stateManager.audit {
+			if entry.Op != "update" || entry.Reason == "" {
+				t.Errorf("Unexpected audit entry %+v", entry)
+			}
+		}
+	})
+
+	t.Run("rollback restores previous data", func(t *testing.T) {
+		cfg := &config.Config{
+			Reconcile: config.Reconcile{Owner: "test-owner", RollbackOnFailure: true},
+			DNS:       config.DNS{Zones: []string{"example.com"}},
+		}
+		provider := &MockProvider{
+			records:       map[string][]provider.Record{"example.com": existing},
+			updateErr:     errors.New("dns failure"),
+			updateErrName: "b",
+		}
+		engine := NewEngine(&MockStateManager{state: initial}, provider, cfg, metrics.New(false))
+
+		results, err := engine.Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(results.Updated) != 0 || len(results.Failures) != 1 || len(results.Reverted) != 1 {
+			t.Fatalf("Expected update reverted, got %+v", results)
+		}
+		if last := provider.updated[len(provider.updated)-1]; last.ID != "a-main" || last.Data != "10.0.0.1" {
+			t.Errorf("Expected a restored to 10.0.0.1, got %+v", last)
+		}
+	})
+}
+
+func TestParseHeritage(t *testing.T) {
+	tests := []struct {
+		data  string
+		owner string
+		ok    bool
+	}{
+		{data: txtIdentifier("test-owner"), owner: "test-owner", ok: true},
+		{data: `"` + txtIdentifier("test-owner") + `"`, owner: "test-owner", ok: true},
+		{data: `"heritage=caddy-dns-sync," "caddy-dns-sync/owner=test-owner"`, owner: "test-owner", ok: true},
+		{data: `"heritage=caddy-dns-sync\044caddy-dns-sync/owner=test-owner"`, owner: "test-owner", ok: true},
+		{data: "heritage=external-dns,external-dns/owner=test-owner", ok: false},
+		{data: "heritage=caddy-dns-sync", ok: false},
+		{data: "v=spf1 -all", ok: false},
+		{data: txtIdentifier("team,a=b%c"), owner: "team,a=b%c", ok: true},
+		{data: "heritage=caddy-dns-sync,caddy-dns-sync/owner=team%2Ca", owner: "team,a", ok: true},
+		// Written before owners were escaped
+		{data: "heritage=caddy-dns-sync,caddy-dns-sync/owner=team,a", owner: "team,a", ok: true},
+		{data: "heritage=caddy-dns-sync,caddy-dns-sync/owner=a=b", owner: "a=b", ok: true},
+		{data: "caddy-dns-sync/owner=team,a,heritage=caddy-dns-sync", owner: "team,a", ok: true},
+		{data: "heritage=caddy-dns-sync,caddy-dns-sync/owner=test-owner,note=x", owner: "test-owner", ok: true},
+		// Quoted twice by the legacy engine
+		{data: `"\"heritage=caddy-dns-sync,caddy-dns-sync/owner=test-owner\""`, owner: "test-owner", ok: true},
+	}
+	for _, tt := range tests {
+		owner, ok := parseHeritage(tt.data)
+		if owner != tt.owner || ok != tt.ok {
+			t.Errorf("parseHeritage(%q) = %q, %v, want %q, %v", tt.data, owner, ok, tt.owner, tt.ok)
+		}
+	}
+
+	// Owners are matched exactly, not as a prefix
+	if owner, _ := parseHeritage(txtIdentifier("test-owner-2")); owner == "test-owner" {
+		t.Error("Expected owner test-owner-2 not to match test-owner")
+	}
+}
+
+func TestQuotedHeritageOwnership(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"old.example.com": {ServerName: "10.0.0.1:8080"},
+	}}}
+	provider := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			{Name: "old", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "old", Type: "TXT", Data: `"` + txtIdentifier("test-owner") + `"`, Zone: "example.com"},
+		},
+	}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	results, err := engine.Reconcile(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results.Deleted) != 2 {
+		t.Errorf("Expected quoted heritage record to be recognized as owned, deleted %+v", results.Deleted)
+	}
+}
+
+func TestParallelExecution(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", Workers: 4},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	provider := &MockProvider{
+		records: map[string][]provider.Record{"example.com": {}},
+		delay:   5 * time.Millisecond,
+	}
+	engine := NewEngine(&MockStateManager{}, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{}
+	for i := 0; i < 20; i++ {
+		domains = append(domains, source.DomainConfig{
+			Host:     fmt.Sprintf("host%d.example.com", i),
+			Upstream: fmt.Sprintf("10.0.0.%d:8080", i),
+		})
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results.Created) != 40 || len(results.Groups) != 20 {
+		t.Fatalf("Expected 40 records in 20 groups, got %d in %d", len(results.Created), len(results.Groups))
+	}
+	if provider.maxInflight < 2 || provider.maxInflight > 4 {
+		t.Errorf("Expected between 2 and 4 concurrent operations, got %d", provider.maxInflight)
+	}
+
+	// Each host's main record is created before its TXT record by the same worker
+	seen := map[string]bool{}
+	for _, r := range provider.created {
+		if r.Type == "TXT" && !seen[r.Name] {
+			t.Errorf("TXT record for %s created before its main record", r.Name)
+		}
+		seen[r.Name] = true
+	}
+
+	// Results stay in plan order regardless of completion order
+	for i, g := range results.Groups {
+		if g.Name != engine.LastPlan().Groups[i].Name {
+			t.Errorf("Group %d = %s, want %s", i, g.Name, engine.LastPlan().Groups[i].Name)
+		}
+	}
+}
+
+func TestExecutionOrder(t *testing.T) {
+	// index of the first and last occurrence of op in ops
+	span := func(ops []string, op string) (int, int) {
+		first, last := -1, -1
+		for i, o := range ops {
+			if o == op {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		return first, last
+	}
+
+	tests := []struct {
+		name   string
+		order  string
+		before [][2]string // each pair must fully run in order
+	}{
+		{
+			name:  "creates first",
+			order: config.OrderCreatesFirst,
+			before: [][2]string{
+				{"delete changed", "create changed"},
+				{"create new", "delete old"},
+			},
+		},
+		{
+			name:  "deletes first",
+			order: config.OrderDeletesFirst,
+			before: [][2]string{
+				{"delete changed", "create changed"},
+				{"delete old", "create new"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Reconcile: config.Reconcile{Owner: "test-owner", ExecutionOrder: tt.order},
+				DNS:       config.DNS{Zones: []string{"example.com"}},
+			}
+			stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+				"changed.example.com": {ServerName: "10.0.0.1:8080"},
+				"old.example.com":     {ServerName: "10.0.0.2:8080"},
+			}}}
+			provider := &MockProvider{records: map[string][]provider.Record{
+				"example.com": {
+					{Name: "changed", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+					{Name: "changed", Type: "TXT", Data: txtIdentifier("other-owner"), Zone: "example.com"},
+					{Name: "changed", Type: "TXT", Data: txtIdentifier("test-owner") + " ", Zone: "example.com"},
+					{Name: "old", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+					{Name: "old", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+				},
+			}}
+			engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+			domains := []source.DomainConfig{
+				{Host: "changed.example.com", Upstream: "10.0.0.3:8080"},
+				{Host: "new.example.com", Upstream: "10.0.0.4:8080"},
+			}
+			if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, pair := range tt.before {
+				_, lastFirst := span(provider.ops, pair[0])
+				firstSecond, _ := span(provider.ops, pair[1])
+				if lastFirst < 0 || firstSecond < 0 || lastFirst > firstSecond {
+					t.Errorf("Expected %q before %q, got ops %v", pair[0], pair[1], provider.ops)
+				}
+			}
+		})
+	}
+}
+
+func TestTypeChangeConflicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    []provider.Record
+		previous    string
+		upstream    string
+		wantDeleted []string // "type data" of deleted address records
+		wantCreated string
+	}{
+		{
+			name: "a to cname",
+			existing: []provider.Record{
+				{ID: "main", Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+				{ID: "txt", Name: "app", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			},
+			previous:    "10.0.0.1:8080",
+			upstream:    "backend.example.net:8080",
+			wantDeleted: []string{"A 10.0.0.1"},
+			wantCreated: "CNAME backend.example.net",
+		},
+		{
+			name: "cname to a",
+			existing: []provider.Record{
+				{ID: "main", Name: "app", Type: "CNAME", Data: "backend.example.net", Zone: "example.com"},
+				{ID: "txt", Name: "app", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			},
+			previous:    "backend.example.net:8080",
+			upstream:    "10.0.0.1:8080",
+			wantDeleted: []string{"CNAME backend.example.net"},
+			wantCreated: "A 10.0.0.1",
+		},
+		{
+			name: "dual stack to cname",
+			existing: []provider.Record{
+				{ID: "main", Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+				{ID: "v6", Name: "app", Type: "AAAA", Data: "2001:db8::1", Zone: "example.com"},
+				{ID: "txt", Name: "app", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			},
+			previous:    "10.0.0.1:8080",
+			upstream:    "backend.example.net:8080",
+			wantDeleted: []string{"A 10.0.0.1", "AAAA 2001:db8::1"},
+			wantCreated: "CNAME backend.example.net",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Reconcile: config.Reconcile{Owner: "test-owner", Workers: 4},
+				DNS:       config.DNS{Zones: []string{"example.com"}},
+			}
+			stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+				"app.example.com": {ServerName: tt.previous},
+			}}}
+			provider := &MockProvider{records: map[string][]provider.Record{"example.com": tt.existing}}
+			engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+			domains := []source.DomainConfig{{Host: "app.example.com", Upstream: tt.upstream}}
+			if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(provider.updated) != 0 {
+				t.Errorf("Expected no in place update across types, got %+v", provider.updated)
+			}
+
+			deleted := []string{}
+			for _, r := range provider.deleted {
+				if r.Type != "TXT" {
+					deleted = append(deleted, r.Type+" "+r.Data)
+				}
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDeleted) {
+				t.Errorf("Deleted %v, want %v", deleted, tt.wantDeleted)
+			}
+			created := []string{}
+			for _, r := range provider.created {
+				if r.Type != "TXT" {
+					created = append(created, r.Type+" "+r.Data)
+				}
+			}
+			if !reflect.DeepEqual(created, []string{tt.wantCreated}) {
+				t.Errorf("Created %v, want %v", created, tt.wantCreated)
+			}
+
+			// Every conflicting record is gone before the new one is created
+			lastDelete, firstCreate := -1, -1
+			for i, op := range provider.ops {
+				if strings.HasPrefix(op, "delete ") {
+					lastDelete = i
+				}
+				if strings.HasPrefix(op, "create ") && firstCreate < 0 {
+					firstCreate = i
+				}
+			}
+			if lastDelete > firstCreate {
+				t.Errorf("Expected deletes before creates, got ops %v", provider.ops)
+			}
+
+			plan := engine.LastPlan()
+			if reason := plan.Reason("delete", tt.existing[0]); !strings.Contains(reason, "type changed") {
+				t.Errorf("Expected type change reason, got %q", reason)
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com", "example.org"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"old.example.com": {ServerName: "10.0.0.9:8080"},
+	}}}
+	provider := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			// no owned TXT record, so it is left in place
+			{Name: "old", Type: "A", Data: "10.0.0.9", Zone: "example.com"},
+		},
+	}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "api.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "app.other.net", Upstream: "10.0.0.3:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := Summary{
+		Discovered: 3,
+		Filtered:   1,
+		Managed:    map[string]int{"example.com": 2, "example.org": 0},
+		Unmanaged:  map[string]int{"example.com": 1, "example.org": 0},
+		Contested:  map[string]int{"example.com": 0, "example.org": 0},
+	}
+	if got := engine.Summary(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Summary = %+v, want %+v", got, expected)
+	}
+
+	// Nothing changed, the unowned record is no longer part of a plan
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected.Unmanaged = map[string]int{"example.com": 0, "example.org": 0}
+	if got := engine.Summary(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Summary = %+v, want %+v", got, expected)
+	}
+}
+
+func TestUnmanagedPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		data        string // data of the unmanaged record
+		wantCreated []string
+		wantDeleted []string
+		wantFailed  bool
+	}{
+		{name: "skip by default", policy: "", data: "10.0.0.1"},
+		{name: "skip", policy: config.UnmanagedSkip, data: "10.0.0.1"},
+		{name: "fail", policy: config.UnmanagedFail, data: "10.0.0.1", wantFailed: true},
+		{
+			name:        "takeover replaces",
+			policy:      config.UnmanagedTakeover,
+			data:        "10.0.0.1",
+			wantCreated: []string{"A", "TXT"},
+			wantDeleted: []string{"A"},
+		},
+		{
+			name:        "takeover adopts matching record",
+			policy:      config.UnmanagedTakeover,
+			data:        "10.0.0.2",
+			wantCreated: []string{"TXT"},
+		},
+	}
+
+	types := func(records []provider.Record) []string {
+		out := []string{}
+		for _, r := range records {
+			out = append(out, r.Type)
+		}
+		return out
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Reconcile: config.Reconcile{Owner: "test-owner", UnmanagedPolicy: tt.policy},
+				DNS:       config.DNS{Zones: []string{"example.com"}},
+			}
+			provider := &MockProvider{records: map[string][]provider.Record{
+				"example.com": {
+					{Name: "app", Type: "A", Data: tt.data, Zone: "example.com"},
+					{Name: "app", Type: "TXT", Data: txtIdentifier("other-owner"), Zone: "example.com"},
+				},
+			}}
+			engine := NewEngine(&MockStateManager{}, provider, cfg, metrics.New(false))
+
+			domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.2:8080"}}
+			results, err := engine.Reconcile(context.Background(), domains)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got := types(provider.created); !reflect.DeepEqual(got, append([]string{}, tt.wantCreated...)) {
+				t.Errorf("Created %v, want %v", got, tt.wantCreated)
+			}
+			if got := types(provider.deleted); !reflect.DeepEqual(got, append([]string{}, tt.wantDeleted...)) {
+				t.Errorf("Deleted %v, want %v", got, tt.wantDeleted)
+			}
+			if tt.wantFailed {
+				if len(results.Failures) != 1 || results.Failures[0].Class != "conflict" {
+					t.Errorf("Expected a conflict failure, got %+v", results.Failures)
+				}
+			} else if len(results.Failures) != 0 {
+				t.Errorf("Unexpected failures %+v", results.Failures)
+			}
+			if tt.policy == config.UnmanagedTakeover {
+				explained := false
+				for _, ex := range engine.LastPlan().Explain {
+					explained = explained || ex.Reason == ReasonTakeover
+				}
+				if !explained {
+					t.Errorf("Expected takeover reason in plan, got %+v", engine.LastPlan().Explain)
+				}
+			}
+		})
+	}
+}
+
+func TestOwnershipConflicts(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", AcceptOwners: []string{"old-owner"}},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	mock := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			{Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "app", Type: "TXT", Data: txtIdentifier("other-owner"), Zone: "example.com"},
+			{Name: "api", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "api", Type: "TXT", Data: txtIdentifier("old-owner"), Zone: "example.com"},
+			{Name: "gone", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "gone", Type: "TXT", Data: tombstoneData("other-owner", time.Unix(1, 0)), Zone: "example.com"},
+		},
+	}}
+	sm := &MockStateManager{}
+	engine := NewEngine(sm, mock, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "app.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "api.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "gone.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Accepted owners and tombstones are not conflicts
+	want := []OwnershipConflict{{Zone: "example.com", Name: "app", Owner: "other-owner"}}
+	if got := engine.LastPlan().Contested; !reflect.DeepEqual(got, want) {
+		t.Errorf("Contested = %+v, want %+v", got, want)
+	}
+	if got := engine.Summary().Contested["example.com"]; got != 1 {
+		t.Errorf("Expected one conflict in summary, got %d", got)
+	}
+	audited := false
+	for _, entry := range sm.audit {
+		if entry.Op == "ownership_conflict" {
+			audited = entry.Name == "app" && entry.Reason == reasonOwnedBy("other-owner")
+		}
+	}
+	if !audited {
+		t.Errorf("Expected ownership conflict audit entry, got %+v", sm.audit)
+	}
+}
+
+func TestHeritageSource(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", HeritageSource: true},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	mock := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			{ID: "1", Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{ID: "2", Name: "app", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+		},
+	}}
+	sm := &MockStateManager{}
+	engine := NewEngine(sm, mock, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080", Source: "edge"}}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Only the heritage record is rewritten to name the source
+	want := txtIdentifier("test-owner") + ",caddy-dns-sync/source=edge"
+	if len(mock.updated) != 1 || mock.updated[0].Data != want {
+		t.Fatalf("Expected heritage updated to %q, got %+v", want, mock.updated)
+	}
+	if owner, ok := parseHeritage(want); !ok || owner != "test-owner" {
+		t.Errorf("Expected heritage with source owned by test-owner, got %q", owner)
+	}
+	if got := sm.state.Domains["app.example.com"].Source; got != "edge" {
+		t.Errorf("Expected source in state, got %q", got)
+	}
+	if len(sm.audit) != 1 || sm.audit[0].Source != "edge" || sm.audit[0].Reason != ReasonSource {
+		t.Errorf("Expected audit entry attributed to edge, got %+v", sm.audit)
+	}
+
+	// The heritage now matches, so nothing is rewritten on the next sync
+	mock.records["example.com"][1].Data = want
+	mock.updated = nil
+	sm.state = state.State{}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(mock.updated) != 0 {
+		t.Errorf("Expected no updates once the heritage names the source, got %+v", mock.updated)
+	}
+}
+
+func TestShadowDrift(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", Shadow: true},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{}
+	provider := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			{Name: "same", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "same", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			{Name: "changed", Type: "A", Data: "10.0.0.9", Zone: "example.com"},
+			{Name: "changed", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			{Name: "manual", Type: "A", Data: "10.0.0.3", Zone: "example.com"},
+			{Name: "gone", Type: "A", Data: "10.0.0.4", Zone: "example.com"},
+			{Name: "gone", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+		},
+	}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+	now := time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+
+	domains := []source.DomainConfig{
+		{Host: "same.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "changed.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "manual.example.com", Upstream: "10.0.0.3:8080"},
+		{Host: "new.example.com", Upstream: "backend.example.net:8080"},
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results.Created)+len(results.Updated)+len(results.Deleted) != 0 || len(provider.ops) != 0 {
+		t.Errorf("Expected no writes in shadow mode, got %+v", provider.ops)
+	}
+	if len(stateManager.state.Domains) != 0 {
+		t.Errorf("Expected state untouched, got %+v", stateManager.state)
+	}
+
+	expected := []DriftEntry{
+		{Kind: DriftMismatch, Zone: "example.com", Name: "changed", Type: "A", Desired: "10.0.0.2", Live: "10.0.0.9"},
+		{Kind: DriftStale, Zone: "example.com", Name: "gone", Type: "A", Live: "10.0.0.4"},
+		{Kind: DriftUnowned, Zone: "example.com", Name: "manual", Type: "A", Desired: "10.0.0.3", Live: "10.0.0.3"},
+		{Kind: DriftMissing, Zone: "example.com", Name: "new", Type: "CNAME", Desired: "backend.example.net"},
+	}
+	drift := engine.Drift()
+	if drift.Checked != now.Unix() {
+		t.Errorf("Checked = %d, want %d", drift.Checked, now.Unix())
+	}
+	if !reflect.DeepEqual(drift.Entries, expected) {
+		t.Errorf("Drift = %+v, want %+v", drift.Entries, expected)
+	}
+}
+
+func TestManagedRecords(t *testing.T) {
+	records := []provider.Record{
+		{Name: "app.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{Name: "app.example.com", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+		{Name: "other.example.com", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+		{Name: "other.example.com", Type: "TXT", Data: txtIdentifier("other-owner"), Zone: "example.com"},
+		{Name: "manual.example.com", Type: "CNAME", Data: "example.net", Zone: "example.com"},
+	}
+	got := ManagedRecords(records, "example.com", "test-owner")
+	if expected := records[:2]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("ManagedRecords = %+v, want %+v", got, expected)
+	}
+}
+
+func TestDesiredRecords(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", ProtectedRecords: []string{"protect.example.com"}},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	engine := NewEngine(&MockStateManager{}, &MockProvider{}, cfg, metrics.New(false))
+
+	desired := engine.DesiredRecords([]source.DomainConfig{
+		{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "protect.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "app.other.net", Upstream: "10.0.0.3:8080"},
+	})
+	records := desired["example.com"]
+	if len(desired) != 1 || len(records) != 2 {
+		t.Fatalf("Expected one host in example.com, got %+v", desired)
+	}
+	if records[0].Type != "A" || records[0].Data != "10.0.0.1" || records[1].Data != txtIdentifier("test-owner") {
+		t.Errorf("Unexpected records %+v", records)
+	}
+}
+
+func TestExternalDNS(t *testing.T) {
+	registry := func(owner string) string {
+		return `"heritage=external-dns,external-dns/owner=` + owner + `,external-dns/resource=ingress/default/app"`
+	}
+	records := []provider.Record{
+		{Name: "app.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{Name: "app.example.com", Type: "TXT", Data: registry("cluster"), Zone: "example.com"},
+		{Name: "a-app.example.com", Type: "TXT", Data: registry("cluster"), Zone: "example.com"},
+		{Name: "web.example.com", Type: "CNAME", Data: "lb.example.net", Zone: "example.com"},
+		{Name: "cname-web.example.com", Type: "TXT", Data: registry("cluster"), Zone: "example.com"},
+		{Name: "other.example.com", Type: "A", Data: "10.0.0.3", Zone: "example.com"},
+		{Name: "other.example.com", Type: "TXT", Data: registry("other-cluster"), Zone: "example.com"},
+	}
+
+	t.Run("records of allowed owners", func(t *testing.T) {
+		found := ExternalDNSRecords(records, "example.com", []string{"cluster"})
+		if len(found) != 2 {
+			t.Fatalf("Expected 2 records, got %+v", found)
+		}
+		if found[0].Record.Name != "app.example.com" || len(found[0].Registry) != 2 || found[0].Owner != "cluster" {
+			t.Errorf("Unexpected app record %+v", found[0])
+		}
+		if found[1].Record.Name != "web.example.com" || len(found[1].Registry) != 1 {
+			t.Errorf("Unexpected web record %+v", found[1])
+		}
+		if found := ExternalDNSRecords(records, "example.com", nil); len(found) != 0 {
+			t.Errorf("Expected no records without owners, got %+v", found)
+		}
+	})
+
+	t.Run("allowed owners are imported", func(t *testing.T) {
+		cfg := &config.Config{
+			Reconcile: config.Reconcile{Owner: "test-owner", ExternalDNSOwners: []string{"cluster"}},
+			DNS:       config.DNS{Zones: []string{"example.com"}},
+		}
+		provider := &MockProvider{records: map[string][]provider.Record{"example.com": records}}
+		engine := NewEngine(&MockStateManager{}, provider, cfg, metrics.New(false))
+
+		domains := []source.DomainConfig{
+			{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+			{Host: "other.example.com", Upstream: "10.0.0.4:8080"},
+		}
+		if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// app already matches so only ownership is added, other is skipped
+		if len(provider.created) != 1 || provider.created[0].Name != "app" || provider.created[0].Type != "TXT" {
+			t.Errorf("Expected only app ownership created, got %+v", provider.created)
+		}
+		if len(provider.deleted) != 0 {
+			t.Errorf("Expected nothing deleted, got %+v", provider.deleted)
+		}
+	})
+}
+
+// racingProvider returns records created by another instance once the plan
+// has been read
+type racingProvider struct {
+	*MockProvider
+	concurrent []provider.Record
+	reads      int
+}
+
+func (p *racingProvider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	p.reads++
+	records, err := p.MockProvider.GetRecords(ctx, zone)
+	if p.reads > 1 {
+		records = append(append([]provider.Record{}, records...), p.concurrent...)
+	}
+	return records, err
+}
+
+// finderProvider looks records up by name and type
+type finderProvider struct {
+	*MockProvider
+	found []provider.Record
+	finds int
+}
+
+func (p *finderProvider) FindRecords(ctx context.Context, zone, name, recordType string) ([]provider.Record, error) {
+	p.finds++
+	var found []provider.Record
+	for _, r := range p.found {
+		if r.Name == name && r.Type == recordType {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+func TestCheckBeforeCreate(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", CheckBeforeCreate: true},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+
+	t.Run("identical records are not duplicated", func(t *testing.T) {
+		mock := &MockProvider{records: map[string][]provider.Record{}}
+		racing := &racingProvider{MockProvider: mock, concurrent: []provider.Record{
+			{Name: "app.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "app.example.com", Type: "TXT", Data: `"` + txtIdentifier("test-owner") + `"`, Zone: "example.com"},
+		}}
+		engine := NewEngine(&MockStateManager{}, racing, cfg, metrics.New(false))
+
+		results, err := engine.Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(mock.created) != 0 {
+			t.Errorf("Expected no creates, got %+v", mock.created)
+		}
+		if len(results.Failures) != 0 || len(results.Created) != 2 {
+			t.Errorf("Expected records reported as created, got %+v", results)
+		}
+	})
+
+	t.Run("different record is a conflict", func(t *testing.T) {
+		mock := &MockProvider{records: map[string][]provider.Record{}}
+		finder := &finderProvider{MockProvider: mock, found: []provider.Record{
+			{Name: "app", Type: "A", Data: "10.0.0.9", Zone: "example.com"},
+		}}
+		engine := NewEngine(&MockStateManager{}, finder, cfg, metrics.New(false))
+
+		results, err := engine.Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if finder.finds == 0 {
+			t.Error("Expected records looked up by name")
+		}
+		if len(mock.created) != 0 {
+			t.Errorf("Expected no creates, got %+v", mock.created)
+		}
+		if len(results.Failures) != 1 || results.Failures[0].Class != provider.ClassConflict {
+			t.Errorf("Expected a conflict failure, got %+v", results.Failures)
+		}
+	})
+}
+
+func TestPauseResume(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{}
+	provider := &MockProvider{records: map[string][]provider.Record{}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+	ctx := context.Background()
+
+	if err := engine.SetPaused(ctx, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+	results, err := engine.Reconcile(ctx, domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !results.Paused || len(provider.ops) != 0 || len(stateManager.state.Domains) != 0 {
+		t.Errorf("Expected no writes while paused, got %+v, ops %v", results, provider.ops)
+	}
+	if plan := engine.LastPlan(); len(plan.Create) == 0 {
+		t.Errorf("Expected plan computed while paused, got %+v", plan)
+	}
+
+	if err := engine.SetPaused(ctx, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	results, err = engine.Reconcile(ctx, domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results.Paused || len(results.Created) == 0 {
+		t.Errorf("Expected records created after resume, got %+v", results)
+	}
+}
+
+func TestWriteWindows(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", WriteWindows: []string{"Mon-Fri 09:00-17:00"}, WriteTimezone: "UTC"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{}
+	provider := &MockProvider{records: map[string][]provider.Record{}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+	ctx := context.Background()
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+
+	// Saturday, deferred until Monday morning
+	engine.now = func() time.Time { return time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC) }
+	results, err := engine.Reconcile(ctx, domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := time.Date(2024, 6, 10, 9, 0, 0, 0, time.UTC); !results.Deferred.Equal(want) {
+		t.Errorf("Expected deferred until %v, got %v", want, results.Deferred)
+	}
+	if len(provider.ops) != 0 || len(stateManager.state.Domains) != 0 {
+		t.Errorf("Expected no writes outside the window, got %v", provider.ops)
+	}
+	if plan := engine.LastPlan(); len(plan.Create) == 0 {
+		t.Errorf("Expected plan computed outside the window, got %+v", plan)
+	}
+
+	engine.now = func() time.Time { return time.Date(2024, 6, 10, 9, 0, 0, 0, time.UTC) }
+	results, err = engine.Reconcile(ctx, domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !results.Deferred.IsZero() || len(results.Created) == 0 {
+		t.Errorf("Expected records created inside the window, got %+v", results)
+	}
+}
+
+func TestExpireAfter(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", SkipAfterFailures: 3, ExpireAfter: 24 * time.Hour},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	// Skipped hosts keep their state, so their removal from caddy is never seen
+	stateManager := &MockStateManager{
+		state: state.State{Domains: map[string]state.DomainState{
+			"old.example.com":    {ServerName: "10.0.0.1:8080", LastSeen: now.Add(-48 * time.Hour).Unix()},
+			"recent.example.com": {ServerName: "10.0.0.2:8080", LastSeen: now.Add(-time.Hour).Unix()},
+			"live.example.com":   {ServerName: "10.0.0.3:8080", LastSeen: now.Add(-48 * time.Hour).Unix()},
+		}},
+		failures: map[string]state.HostFailure{
+			"old.example.com":    {Count: 3, Skipped: true},
+			"recent.example.com": {Count: 3, Skipped: true},
+			"live.example.com":   {Count: 3, Skipped: true},
+		},
+	}
+	provider := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			{Name: "old", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "old", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			{Name: "recent", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+			{Name: "recent", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+			{Name: "live", Type: "A", Data: "10.0.0.3", Zone: "example.com"},
+			{Name: "live", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+		},
+	}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+	engine.now = func() time.Time { return now }
+
+	domains := []source.DomainConfig{{Host: "live.example.com", Upstream: "10.0.0.3:8080"}}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results.Deleted) != 2 {
+		t.Fatalf("Expected the expired host's 2 records deleted, got %+v", results.Deleted)
+	}
+	for _, r := range results.Deleted {
+		if r.Name != "old" {
+			t.Errorf("Expected only old deleted, got %+v", r)
+		}
+	}
+	for _, ex := range engine.LastPlan().Explain {
+		if ex.Reason != ReasonHostExpired {
+			t.Errorf("Expected reason %q, got %+v", ReasonHostExpired, ex)
+		}
+	}
+	if _, exists := stateManager.state.Domains["old.example.com"]; exists {
+		t.Errorf("Expected expired host removed from state")
+	}
+	if d := stateManager.state.Domains["live.example.com"]; d.LastSeen != now.Unix() {
+		t.Errorf("Expected skipped host in caddy to stay seen, got %+v", d)
+	}
+}
+
+func TestInterruptedPlanRecovery(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	// The last run created the main record but crashed before its TXT record
+	stateManager := &MockStateManager{journal: []state.JournalEntry{
+		{Op: "create", Zone: "example.com", Name: "app.example.com", Type: "A", Data: "10.0.0.1", Applied: true},
+		{Op: "create", Zone: "example.com", Name: "app.example.com", Type: "TXT", Data: txtIdentifier("test-owner")},
+	}}
+	provider := &MockProvider{records: map[string][]provider.Record{
+		"example.com": {
+			{Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+			{Name: "other", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+		},
+	}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "other.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The half created host is adopted, the unmanaged one is still skipped
+	if len(results.Created) != 1 || results.Created[0].Type != "TXT" || getRecordName(results.Created[0].Name, "example.com") != "app" {
+		t.Errorf("Expected only the missing TXT record created, got %+v", results.Created)
+	}
+	if len(results.Deleted) != 0 {
+		t.Errorf("Expected no deletes, got %+v", results.Deleted)
+	}
+	if len(stateManager.journal) != 0 {
+		t.Errorf("Expected journal cleared after the run, got %+v", stateManager.journal)
+	}
+}
+
+func TestJournalTracksApplied(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &journalStateManager{MockStateManager: &MockStateManager{}}
+	provider := &MockProvider{records: map[string][]provider.Record{}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(stateManager.started) != 2 {
+		t.Fatalf("Expected the plan journaled before execution, got %+v", stateManager.started)
+	}
+	for _, entry := range stateManager.started {
+		if entry.Applied {
+			t.Errorf("Expected journaled operations pending before execution, got %+v", entry)
+		}
+	}
+	if len(stateManager.applied) != 2 {
+		t.Errorf("Expected every operation marked applied, got %v", stateManager.applied)
+	}
+}
+
+// journalStateManager records the journal as first written and every
+// operation marked applied
+type journalStateManager struct {
+	*MockStateManager
+	started []state.JournalEntry
+	applied []int
+}
+
+func (m *journalStateManager) SaveJournal(ctx context.Context, entries []state.JournalEntry) error {
+	if len(entries) > 0 {
+		m.started = append([]state.JournalEntry{}, entries...)
+	}
+	return m.MockStateManager.SaveJournal(ctx, entries)
+}
+
+func (m *journalStateManager) MarkJournalApplied(ctx context.Context, index int) error {
+	m.applied = append(m.applied, index)
+	return m.MockStateManager.MarkJournalApplied(ctx, index)
+}
+
+func TestInternationalizedHosts(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{}
+	provider := &MockProvider{records: map[string][]provider.Record{}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "bücher.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "bad_name.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results.Created) != 2 {
+		t.Errorf("Expected 2 records created, got %+v", results.Created)
+	}
+	for _, r := range results.Created {
+		if r.Name != "xn--bcher-kva" {
+			t.Errorf("Expected only the punycode name created, got %+v", r)
+		}
+	}
+	if _, exists := stateManager.state.Domains["xn--bcher-kva.example.com"]; !exists {
+		t.Errorf("Expected state keyed by the punycode name, got %+v", stateManager.state.Domains)
+	}
+	expected := []FilteredHost{{Host: "bad_name.example.com", Reason: FilterReasonInvalid}}
+	if filtered := engine.Filtered(); !reflect.DeepEqual(filtered, expected) {
+		t.Errorf("Expected filtered %+v, got %+v", expected, filtered)
+	}
+}
+
+func TestPrivateTargetsInPublicZones(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS: config.DNS{
+			Zones: []string{"example.com", "internal.net"},
+			ZoneSettings: map[string]config.ZoneSettings{
+				"example.com":  {Visibility: config.VisibilityPublic},
+				"internal.net": {Visibility: config.VisibilityInternal},
+			},
+		},
+	}
+	stateManager := &MockStateManager{}
+	provider := &MockProvider{records: map[string][]provider.Record{}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "public.example.com", Upstream: "203.0.113.10:8080"},
+		{Host: "lan.example.com", Upstream: "192.168.1.10:8080"},
+		{Host: "loop.example.com", Upstream: "127.0.0.1:8080"},
+		{Host: "ula.example.com", Upstream: "[fd00::1]:8080"},
+		{Host: "cgnat.example.com", Upstream: "100.64.1.1:8080"},
+		{Host: "backend.example.com", Upstream: "backend.lan:8080"},
+		{Host: "lan.internal.net", Upstream: "192.168.1.10:8080"},
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	created := map[string]bool{}
+	for _, r := range results.Created {
+		created[r.Zone+"/"+r.Name] = true
+	}
+	expected := map[string]bool{"example.com/public": true, "example.com/backend": true, "internal.net/lan": true}
+	if !reflect.DeepEqual(created, expected) {
+		t.Errorf("Expected records for %v, got %v", expected, created)
+	}
+	for _, host := range []string{"lan.example.com", "loop.example.com", "ula.example.com", "cgnat.example.com"} {
+		if _, exists := stateManager.state.Domains[host]; exists {
+			t.Errorf("Expected refused host %s left out of state", host)
+		}
+	}
+	refused := 0
+	for _, f := range engine.Filtered() {
+		if f.Reason == FilterReasonPrivate {
+			refused++
+		}
+	}
+	if refused != 4 {
+		t.Errorf("Expected 4 hosts filtered as private targets, got %+v", engine.Filtered())
+	}
+}
+
+func TestZoneVisibilityAttributes(t *testing.T) {
+	notProxied := false
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{
+			Owner: "test-owner",
+			HostAttributes: map[string]config.HostAttributes{
+				"direct.example.com": {Proxied: &notProxied, Target: "203.0.113.20"},
+			},
+		},
+		DNS: config.DNS{
+			Zones: []string{"example.com", "internal.net", "plain.org"},
+			ZoneSettings: map[string]config.ZoneSettings{
+				"example.com":  {Visibility: config.VisibilityPublic, Target: "203.0.113.10"},
+				"internal.net": {Visibility: config.VisibilityInternal},
+			},
+		},
+	}
+	engine := NewEngine(&MockStateManager{}, &MockProvider{}, cfg, metrics.New(false))
+
+	tests := []struct {
+		host string
+		zone string
+		want provider.Record
+	}{
+		// Public zones publish the target override, proxied
+		{"app.example.com", "example.com", provider.Record{Name: "app", Type: "A", Data: "203.0.113.10", TTL: time.Duration(defaultTTL), Proxied: true, Zone: "example.com"}},
+		// Host attributes override the zone
+		{"direct.example.com", "example.com", provider.Record{Name: "direct", Type: "A", Data: "203.0.113.20", TTL: time.Duration(defaultTTL), Zone: "example.com"}},
+		// Internal zones publish the upstream with a short ttl
+		{"app.internal.net", "internal.net", provider.Record{Name: "app", Type: "A", Data: "192.168.1.10", TTL: internalTTL, Zone: "internal.net"}},
+		{"app.plain.org", "plain.org", provider.Record{Name: "app", Type: "A", Data: "192.168.1.10", TTL: time.Duration(defaultTTL), Zone: "plain.org"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got := engine.desiredRecord(source.DomainConfig{Host: tt.host, Upstream: "192.168.1.10:8080"}, tt.zone)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	// The target override is public, so the private upstream is not refused
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "192.168.1.10:8080"}}
+	if refused := engine.privateTargets(context.Background(), domains); len(refused) != 0 {
+		t.Errorf("Expected no refused hosts, got %v", refused)
+	}
+}
+
+func TestZoneProtectedNames(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS: config.DNS{
+			Zones: []string{"example.com", "custom.org", "open.net"},
+			ZoneSettings: map[string]config.ZoneSettings{
+				"custom.org": {Protected: []string{"legacy-*"}},
+				"open.net":   {Protected: []string{}},
+			},
+		},
+	}
+	stateManager := &MockStateManager{}
+	provider := &MockProvider{records: map[string][]provider.Record{}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "www.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "mail.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "www.custom.org", Upstream: "10.0.0.1:8080"},
+		{Host: "legacy-app.custom.org", Upstream: "10.0.0.1:8080"},
+		{Host: "www.open.net", Upstream: "10.0.0.1:8080"},
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	created := map[string]bool{}
+	for _, r := range results.Created {
+		created[r.Zone+"/"+r.Name] = true
+	}
+	expected := map[string]bool{"example.com/app": true, "custom.org/www": true, "open.net/www": true}
+	if !reflect.DeepEqual(created, expected) {
+		t.Errorf("Expected records for %v, got %v", expected, created)
+	}
+}
+
+func TestACMEChallengeRecordsUntouched(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", UnmanagedPolicy: config.UnmanagedTakeover},
+		DNS: config.DNS{
+			Zones: []string{"example.com"},
+			// Even with every default protection removed
+			ZoneSettings: map[string]config.ZoneSettings{"example.com": {Protected: []string{}}},
+		},
+	}
+	challenges := []provider.Record{
+		{Name: "_acme-challenge", Type: "TXT", Data: "apex-token", Zone: "example.com"},
+		{Name: "_acme-challenge.app", Type: "TXT", Data: "app-token", Zone: "example.com"},
+		{Name: "_acme-challenge.old", Type: "CNAME", Data: "old.acme-dns.example.net", Zone: "example.com"},
+		{Name: "_acme-challenge.old", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"app.example.com":                 {ServerName: "10.0.0.1:8080"},
+		"_acme-challenge.old.example.com": {ServerName: "old.acme-dns.example.net:80"},
+	}}}
+	records := append([]provider.Record{
+		{Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{Name: "app", Type: "TXT", Data: txtIdentifier("test-owner"), Zone: "example.com"},
+	}, challenges...)
+	provider := &MockProvider{records: map[string][]provider.Record{"example.com": records}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	// Removing every host only deletes the owned app records
+	results, err := engine.Reconcile(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, r := range append(results.Deleted, results.Created...) {
+		if isACMEChallenge(r.Name) {
+			t.Errorf("Expected challenge records untouched, got %+v", r)
+		}
+	}
+	if len(results.Deleted) != 2 {
+		t.Errorf("Expected the 2 app records deleted, got %+v", results.Deleted)
+	}
+
+	// Nor are they managed, exported or reported as drift
+	for _, r := range ManagedRecords(records, "example.com", "test-owner") {
+		if isACMEChallenge(r.Name) {
+			t.Errorf("Expected challenge records not managed, got %+v", r)
+		}
+	}
+	drift, err := engine.computeDrift(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, entry := range drift.Entries {
+		if isACMEChallenge(entry.Name) {
+			t.Errorf("Expected no drift for challenge records, got %+v", entry)
+		}
+	}
+}
+
+// benchmarkEngine spreads hosts over four zones, each host owning an A and a
+// heritage TXT record, padded with unrelated records up to records in total.
+// A tenth of the hosts changed upstream, a tenth were removed and a tenth
+// are new.
+func benchmarkEngine(hosts, records int) (*engine, state.State, state.State) {
+	zones := []string{"a.example", "b.example", "c.example", "d.example"}
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "bench"},
+		DNS:       config.DNS{Zones: zones},
+	}
+	existing := make(map[string][]provider.Record)
+	previous := state.State{Domains: make(map[string]state.DomainState, hosts)}
+	current := state.State{Domains: make(map[string]state.DomainState, hosts)}
+	total := 0
+	for i := 0; i < hosts; i++ {
+		zone := zones[i%len(zones)]
+		host := fmt.Sprintf("host%d.%s", i, zone)
+		upstream := fmt.Sprintf("10.0.%d.%d:8080", i/256, i%256)
+		switch i % 10 {
+		case 0:
+			current.Domains[host] = state.DomainState{ServerName: "10.1.0.1:8080"}
+		case 1:
+			current.Domains[host] = state.DomainState{ServerName: upstream}
+			continue
+		case 2:
+			previous.Domains[host] = state.DomainState{ServerName: upstream}
+		default:
+			current.Domains[host] = state.DomainState{ServerName: upstream}
+		}
+		if i%10 != 2 {
+			previous.Domains[host] = state.DomainState{ServerName: upstream}
+		}
+		existing[zone] = append(existing[zone],
+			provider.Record{ID: host + "-a", Name: host, Type: "A", Data: extractHostFromUpstream(upstream), Zone: zone, TTL: time.Duration(defaultTTL)},
+			provider.Record{ID: host + "-txt", Name: host, Type: "TXT", Data: `"` + txtIdentifier("bench") + `"`, Zone: zone, TTL: time.Duration(defaultTTL)},
+		)
+		total += 2
+	}
+	for i := 0; total < records; i++ {
+		zone := zones[i%len(zones)]
+		existing[zone] = append(existing[zone], provider.Record{ID: fmt.Sprintf("other%d", i), Name: fmt.Sprintf("other%d.%s", i, zone), Type: "CNAME", Data: "elsewhere.example.net", Zone: zone})
+		total++
+	}
+	sm := &MockStateManager{state: previous}
+	return NewEngine(sm, &MockProvider{records: existing}, cfg, metrics.New(false)), current, previous
+}
+
+func BenchmarkCompareStates(b *testing.B) {
+	engine, current, previous := benchmarkEngine(1000, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.compareStates(current, previous)
+	}
+}
+
+func BenchmarkGeneratePlan(b *testing.B) {
+	engine, current, previous := benchmarkEngine(1000, 10000)
+	changes := engine.compareStates(current, previous)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := engine.generatePlan(ctx, changes, Scope{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestGeneratePlanAllocations guards the allocation budget of plan
+// generation, about one allocation per record once per record debug logging
+// and zone suffix concatenation were taken off the hot path
+func TestGeneratePlanAllocations(t *testing.T) {
+	const hosts, records = 1000, 10000
+	engine, current, previous := benchmarkEngine(hosts, records)
+	changes := engine.compareStates(current, previous)
+	ctx := context.Background()
+	allocs := testing.AllocsPerRun(5, func() {
+		if _, err := engine.generatePlan(ctx, changes, Scope{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if budget := float64(2 * records); allocs > budget {
+		t.Errorf("generatePlan allocated %.0f times, budget is %.0f", allocs, budget)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{
+			ProtectedRecords: []string{"protected.example.com"},
+			Owner:            "test-owner",
+		},
+		DNS: config.DNS{Zones: []string{"example.com", "example.net"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"web.example.net":       {ServerName: "backend.internal:80"},
+		"app.example.com":       {ServerName: "10.0.0.1:8080"},
+		"protected.example.com": {ServerName: "10.0.0.2:8080"},
+		"other.example.org":     {ServerName: "10.0.0.3:8080"},
+	}}}
+	engine := NewEngine(stateManager, &MockProvider{}, cfg, metrics.New(false))
+
+	inventory, err := engine.Inventory(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := []string{}
+	for _, r := range inventory {
+		got = append(got, fmt.Sprintf("%s %s %s %s %s", r.Host, r.Zone, r.Name, r.Type, r.Data))
+	}
+	expected := []string{
+		"app.example.com example.com app A 10.0.0.1",
+		"app.example.com example.com app TXT " + txtIdentifier("test-owner"),
+		"web.example.net example.net web CNAME backend.internal",
+		"web.example.net example.net web TXT " + txtIdentifier("test-owner"),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected inventory %v, got %v", expected, got)
+	}
+
+	stateManager.err = errors.New("state unavailable")
+	if _, err := engine.Inventory(context.Background()); err == nil {
+		t.Errorf("Expected state error")
+	}
+}
+
+func TestOutdatedHeritageRewritten(t *testing.T) {
+	owner := "team,a"
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: owner},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	// Both hosts were written before owners were escaped, only b changed
+	legacy := "heritage=caddy-dns-sync,caddy-dns-sync/owner=team,a"
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"b.example.com": {ServerName: "10.0.0.2:8080"},
+	}}}
+	provider := &MockProvider{records: map[string][]provider.Record{"example.com": {
+		{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: legacy, Zone: "example.com"},
+		{ID: "b-main", Name: "b.example.com", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+		{ID: "b-txt", Name: "b.example.com", Type: "TXT", Data: legacy, Zone: "example.com"},
+	}}}
+	engine := NewEngine(stateManager, provider, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.1.2:8080"},
+	}
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results.Created) != 0 || len(results.Deleted) != 0 {
+		t.Fatalf("Expected records updated in place, got %+v", results)
+	}
+	got := map[string]string{}
+	for _, r := range provider.updated {
+		got[r.ID] = r.Data
+	}
+	expected := map[string]string{
+		"a-txt":  "heritage=caddy-dns-sync,caddy-dns-sync/owner=team%2Ca",
+		"b-main": "10.0.1.2",
+		"b-txt":  "heritage=caddy-dns-sync,caddy-dns-sync/owner=team%2Ca",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Updated records = %+v, want %+v", got, expected)
+	}
+	if reason := engine.LastPlan().Reason("update", provider.updated[0]); reason == "" {
+		t.Errorf("Expected a reason for %+v", provider.updated[0])
+	}
+}
+
+func TestCommentOwnership(t *testing.T) {
+	heritage := "heritage=caddy-dns-sync,caddy-dns-sync/owner=test-owner"
+	tests := []struct {
+		name      string
+		ownership string
+		comments  bool
+		previous  map[string]state.DomainState
+		domains   []source.DomainConfig
+		existing  []provider.Record
+		created   []string // "type comment" of created records
+		updated   []string // "id comment" of updated records
+		deleted   []string // ids of deleted records
+	}{
+		{
+			name:      "new host has no TXT record",
+			ownership: config.OwnershipComment,
+			comments:  true,
+			domains:   []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}},
+			created:   []string{"A " + heritage},
+		},
+		{
+			name:      "TXT ownership moved to comment",
+			ownership: config.OwnershipComment,
+			comments:  true,
+			domains:   []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}},
+			existing: []provider.Record{
+				{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+				{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+			},
+			updated: []string{"a-main " + heritage},
+			deleted: []string{"a-txt"},
+		},
+		{
+			name:      "comment owned record is current",
+			ownership: config.OwnershipComment,
+			comments:  true,
+			domains:   []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}},
+			existing: []provider.Record{
+				{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com", Comment: heritage},
+			},
+		},
+		{
+			name:      "comment owned record updated in place",
+			ownership: config.OwnershipComment,
+			comments:  true,
+			previous:  map[string]state.DomainState{"a.example.com": {ServerName: "10.0.0.1:8080"}},
+			domains:   []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.2:8080"}},
+			existing: []provider.Record{
+				{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com", Comment: heritage},
+			},
+			updated: []string{"a-main " + heritage},
+		},
+		{
+			name:      "comment ownership moved to TXT",
+			ownership: config.OwnershipTXT,
+			comments:  true,
+			domains:   []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}},
+			existing: []provider.Record{
+				{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com", Comment: heritage},
+			},
+			created: []string{"TXT "},
+		},
+		{
+			name:      "comment owned record removed",
+			ownership: config.OwnershipComment,
+			comments:  true,
+			previous:  map[string]state.DomainState{"a.example.com": {ServerName: "10.0.0.1:8080"}},
+			existing: []provider.Record{
+				{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com", Comment: heritage},
+			},
+			deleted: []string{"a-main"},
+		},
+		{
+			name:      "unowned comment is not ours",
+			ownership: config.OwnershipComment,
+			comments:  true,
+			previous:  map[string]state.DomainState{"a.example.com": {ServerName: "10.0.0.1:8080"}},
+			existing: []provider.Record{
+				{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com", Comment: "heritage=caddy-dns-sync,caddy-dns-sync/owner=other"},
+			},
+		},
+		{
+			name:      "provider without comments falls back to TXT",
+			ownership: config.OwnershipComment,
+			domains:   []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}},
+			created:   []string{"A ", "TXT "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Reconcile: config.Reconcile{Owner: "test-owner"},
+				DNS:       config.DNS{Zones: []string{"example.com"}, Ownership: tt.ownership},
+			}
+			stateManager := &MockStateManager{state: state.State{Domains: tt.previous}}
+			if tt.previous == nil {
+				stateManager.state.Domains = map[string]state.DomainState{}
+			}
+			dp := &MockProvider{
+				records:  map[string][]provider.Record{"example.com": tt.existing},
+				comments: tt.comments,
+			}
+			engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+			if _, err := engine.Reconcile(context.Background(), tt.domains); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var created, updated, deleted []string
+			for _, r := range dp.created {
+				created = append(created, r.Type+" "+r.Comment)
+			}
+			for _, r := range dp.updated {
+				updated = append(updated, r.ID+" "+r.Comment)
+			}
+			for _, r := range dp.deleted {
+				deleted = append(deleted, r.ID)
+			}
+			if !reflect.DeepEqual(created, tt.created) {
+				t.Errorf("Created = %q, want %q", created, tt.created)
+			}
+			if !reflect.DeepEqual(updated, tt.updated) {
+				t.Errorf("Updated = %q, want %q", updated, tt.updated)
+			}
+			if !reflect.DeepEqual(deleted, tt.deleted) {
+				t.Errorf("Deleted = %q, want %q", deleted, tt.deleted)
+			}
+		})
+	}
+}
+
+func TestLastApplied(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"c.example.com": {ServerName: "10.0.0.3:8080", LastApplied: 100, AppliedType: "A", AppliedData: "10.0.0.3"},
+	}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {
+		{ID: "b-main", Name: "b.example.com", Type: "A", Data: "10.9.9.9", Zone: "example.com"},
+		{ID: "c-main", Name: "c.example.com", Type: "A", Data: "10.0.0.3", Zone: "example.com"},
+		{ID: "c-txt", Name: "c.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+	}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	now := time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.0.2:8080"}, // name has an unmanaged record
+		{Host: "c.example.com", Upstream: "10.0.0.3:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := stateManager.state.Domains
+	if a := got["a.example.com"]; a.LastApplied != now.Unix() || a.AppliedType != "A" || a.AppliedData != "10.0.0.1" {
+		t.Errorf("a.example.com = %+v, want applied at %d", a, now.Unix())
+	}
+	if b := got["b.example.com"]; b.LastApplied != 0 || b.AppliedData != "" {
+		t.Errorf("b.example.com = %+v, want never applied", b)
+	}
+	if c := got["c.example.com"]; c.LastApplied != 100 {
+		t.Errorf("c.example.com = %+v, want applied time kept", c)
+	}
+
+	stuck := engine.Stuck(stateManager.state)
+	expected := []StuckHost{{Host: "b.example.com", LastSeen: now.Unix(), Desired: "A 10.0.0.2"}}
+	if !reflect.DeepEqual(stuck, expected) {
+		t.Errorf("Stuck = %+v, want %+v", stuck, expected)
+	}
+}
+
+func TestCrossZoneMove(t *testing.T) {
+	heritage := HeritageData("test-owner")
+	tests := []struct {
+		name      string
+		order     string
+		createErr error
+		ops       []string
+		audit     []string // "op zone name" of audit entries
+	}{
+		{
+			name:  "created before deleted",
+			ops:   []string{"create app", "create app", "delete app.example.com", "delete app.example.com"},
+			audit: []string{"move example.org app"},
+		},
+		{
+			name:  "deletes first still deletes the old records last",
+			order: config.OrderDeletesFirst,
+			ops:   []string{"create app", "create app", "delete app.example.com", "delete app.example.com"},
+			audit: []string{"move example.org app"},
+		},
+		{
+			name:      "old records kept when the new zone fails",
+			createErr: fmt.Errorf("boom"),
+			audit:     []string{"create example.org app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Reconcile: config.Reconcile{Owner: "test-owner", ExecutionOrder: tt.order},
+				DNS:       config.DNS{Zones: []string{"example.com", "example.org"}},
+			}
+			stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+				"app.example.com": {ServerName: "10.0.0.1:8080"},
+			}}}
+			dp := &MockProvider{
+				records: map[string][]provider.Record{
+					"example.com": {
+						{ID: "main", Name: "app.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+						{ID: "txt", Name: "app.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+					},
+					"example.org": {},
+				},
+				createErr: tt.createErr,
+			}
+			engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+			domains := []source.DomainConfig{{Host: "app.example.org", Upstream: "10.0.0.1:8080"}}
+			if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(dp.ops, tt.ops) {
+				t.Errorf("Operations = %q, want %q", dp.ops, tt.ops)
+			}
+			for _, r := range dp.created {
+				if r.Zone != "example.org" {
+					t.Errorf("Created %+v outside the new zone", r)
+				}
+			}
+			for _, r := range dp.deleted {
+				if r.Zone != "example.com" {
+					t.Errorf("Deleted %+v outside the old zone", r)
+				}
+			}
+
+			expected := []Move{{From: "app.example.com", To: "app.example.org", FromZone: "example.com", ToZone: "example.org"}}
+			if moves := engine.LastPlan().Moves; !reflect.DeepEqual(moves, expected) {
+				t.Errorf("Moves = %+v, want %+v", moves, expected)
+			}
+			var audit []string
+			for _, entry := range stateManager.audit {
+				if entry.Type != "TXT" || entry.Op == "move" {
+					audit = append(audit, entry.Op+" "+entry.Zone+" "+entry.Name)
+				}
+			}
+			if !reflect.DeepEqual(audit, tt.audit) {
+				t.Errorf("Audit = %q, want %q", audit, tt.audit)
+			}
+		})
+	}
+}
+
+func TestMaxOpsPerRun(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		runs  []int // hosts in state after each run
+	}{
+		{name: "unlimited", runs: []int{3}},
+		{name: "whole hosts within the limit", limit: 5, runs: []int{2, 3}},
+		{name: "first host kept above the limit", limit: 1, runs: []int{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Reconcile: config.Reconcile{Owner: "test-owner", MaxOpsPerRun: tt.limit},
+				DNS:       config.DNS{Zones: []string{"example.com"}},
+			}
+			stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+			dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+			engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+			domains := []source.DomainConfig{
+				{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+				{Host: "b.example.com", Upstream: "10.0.0.2:8080"},
+				{Host: "c.example.com", Upstream: "10.0.0.3:8080"},
+			}
+			created := 0
+			for run, expected := range tt.runs {
+				dp.created = nil
+				results, err := engine.Reconcile(context.Background(), domains)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if left := (len(domains) - expected) * 2; results.Limited != left {
+					t.Errorf("Run %d: results limited = %d, want %d", run, results.Limited, left)
+				}
+				if got := len(stateManager.state.Domains); got != expected {
+					t.Errorf("Run %d: hosts in state = %d, want %d", run, got, expected)
+				}
+				if tt.limit > 0 && len(dp.created) > max(tt.limit, 2) {
+					t.Errorf("Run %d: created %d records, limit %d", run, len(dp.created), tt.limit)
+				}
+				plan := engine.LastPlan()
+				if left := (len(domains) - expected) * 2; plan.Deferred != left {
+					t.Errorf("Run %d: deferred = %d, want %d", run, plan.Deferred, left)
+				}
+				created += len(dp.created)
+			}
+			if created != 6 {
+				t.Errorf("Created %d records over every run, want 6", created)
+			}
+		})
+	}
+}
+
+func TestMigrateHeritage(t *testing.T) {
+	legacy := `"\"heritage=caddy-dns-sync,caddy-dns-sync/owner=test-owner\""`
+	records := []provider.Record{
+		{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: legacy, Zone: "example.com"},
+		{ID: "b-txt", Name: "b.example.com", Type: "TXT", Data: `"` + HeritageData("test-owner") + `"`, Zone: "example.com"},
+		{ID: "c-txt", Name: "c.example.com", Type: "TXT", Data: `"\"heritage=caddy-dns-sync,caddy-dns-sync/owner=other\""`, Zone: "example.com"},
+	}
+	for _, dryRun := range []bool{false, true} {
+		cfg := &config.Config{
+			Reconcile: config.Reconcile{Owner: "test-owner", DryRun: dryRun},
+			DNS:       config.DNS{Zones: []string{"example.com"}},
+		}
+		dp := &MockProvider{records: map[string][]provider.Record{"example.com": records}}
+		engine := NewEngine(&MockStateManager{}, dp, cfg, metrics.New(false))
+
+		migrated, err := engine.MigrateHeritage(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if migrated != 1 {
+			t.Errorf("dryRun %v: migrated %d records, want 1", dryRun, migrated)
+		}
+		var expected []provider.Record
+		if !dryRun {
+			expected = []provider.Record{{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"}}
+		}
+		if !reflect.DeepEqual(dp.updated, expected) {
+			t.Errorf("dryRun %v: updated %+v, want %+v", dryRun, dp.updated, expected)
+		}
+	}
+}
+
+func TestAcceptOwners(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "green", AcceptOwners: []string{"blue"}},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"b.example.com": {ServerName: "10.0.0.2:8080"},
+		"c.example.com": {ServerName: "10.0.0.3:8080"},
+	}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {
+		{ID: "a-main", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: HeritageData("blue"), Zone: "example.com"},
+		{ID: "b-main", Name: "b.example.com", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+		{ID: "b-txt", Name: "b.example.com", Type: "TXT", Data: HeritageData("blue"), Zone: "example.com"},
+		{ID: "c-main", Name: "c.example.com", Type: "A", Data: "10.0.0.3", Zone: "example.com"},
+		{ID: "c-txt", Name: "c.example.com", Type: "TXT", Data: HeritageData("red"), Zone: "example.com"},
+	}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Records of accepted owners are rewritten as the primary owner
+	expected := []provider.Record{{ID: "a-txt", Name: "a", Type: "TXT", Data: HeritageData("green"), Zone: "example.com", TTL: time.Duration(defaultTTL)}}
+	if !reflect.DeepEqual(dp.updated, expected) {
+		t.Errorf("Updated = %+v, want %+v", dp.updated, expected)
+	}
+	if reason := engine.LastPlan().Reason("update", dp.updated[0]); reason != "owned by accepted owner blue" {
+		t.Errorf("Reason = %q", reason)
+	}
+
+	// Accepted owners' records are deleted, other owners' are left alone
+	var deleted []string
+	for _, r := range dp.deleted {
+		deleted = append(deleted, r.ID)
+	}
+	if !reflect.DeepEqual(deleted, []string{"b-main", "b-txt"}) {
+		t.Errorf("Deleted = %q, want b-main and b-txt", deleted)
+	}
+	if len(dp.created) != 0 {
+		t.Errorf("Expected nothing created, got %+v", dp.created)
+	}
+}
+
+type mockHostFilter struct {
+	allowed map[string]bool
+	err     error
+}
+
+func (f mockHostFilter) Allows(host string) (bool, error) {
+	return f.allowed[host], f.err
+}
+
+func TestHostFilter(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	newEngine := func() (*engine, *MockStateManager, *MockProvider) {
+		stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+			"b.example.com": {ServerName: "10.0.0.2:8080"},
+		}}}
+		dp := &MockProvider{records: map[string][]provider.Record{"example.com": {
+			{ID: "b-main", Name: "b.example.com", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+			{ID: "b-txt", Name: "b.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+		}}}
+		return NewEngine(stateManager, dp, cfg, metrics.New(false)), stateManager, dp
+	}
+
+	t.Run("hosts not allowed are removed", func(t *testing.T) {
+		engine, stateManager, dp := newEngine()
+		engine.SetHostFilter(mockHostFilter{allowed: map[string]bool{"a.example.com": true}})
+		if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(dp.created) != 2 || len(dp.deleted) != 2 {
+			t.Errorf("Expected a created and b deleted, got created %+v deleted %+v", dp.created, dp.deleted)
+		}
+		if _, exists := stateManager.state.Domains["b.example.com"]; exists {
+			t.Error("Expected b.example.com dropped from state")
+		}
+		expected := []FilteredHost{{Host: "b.example.com", Reason: FilterReasonNotAllowed}}
+		if filtered := engine.Filtered(); !reflect.DeepEqual(filtered, expected) {
+			t.Errorf("Filtered = %+v, want %+v", filtered, expected)
+		}
+	})
+
+	t.Run("nothing is written without a list", func(t *testing.T) {
+		engine, _, dp := newEngine()
+		engine.SetHostFilter(mockHostFilter{err: errors.New("not loaded")})
+		if _, err := engine.Reconcile(context.Background(), domains); err == nil {
+			t.Error("Expected error without a host list")
+		}
+		if len(dp.ops) != 0 {
+			t.Errorf("Expected no operations, got %q", dp.ops)
+		}
+	})
+}
+
+func TestRunID(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	domains := []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}}
+
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	ctx := runid.WithID(context.Background(), "run-1")
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id := engine.LastPlan().RunID; id != "run-1" {
+		t.Errorf("Plan run id = %q, want run-1", id)
+	}
+	if len(stateManager.audit) == 0 {
+		t.Fatal("Expected audit entries")
+	}
+	for _, entry := range stateManager.audit {
+		if entry.RunID != "run-1" {
+			t.Errorf("Audit entry %+v has run id %q, want run-1", entry, entry.RunID)
+		}
+	}
+
+	// A run without an id gets one
+	stateManager = &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	engine = NewEngine(stateManager, dp, cfg, metrics.New(false))
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id := engine.LastPlan().RunID; len(id) != 26 {
+		t.Errorf("Expected a generated run id, got %q", id)
+	}
+}
+
+// gaugeValue scrapes the value of a zone labeled gauge from the metrics handler
+func gaugeValue(t *testing.T, m *metrics.Metrics, name, zone string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	prefix := fmt.Sprintf("caddy_dns_sync_%s{zone=%q} ", name, zone)
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+	return ""
+}
+
+func TestRecordsOutOfSync(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com", "example.org"}},
+	}
+	domains := []source.DomainConfig{
+		{Host: "bad.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "good.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "app.example.org", Upstream: "10.0.0.3:8080"},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{
+		records:       map[string][]provider.Record{"example.com": {}, "example.org": {}},
+		createErr:     errors.New("rejected"),
+		createErrName: "bad",
+	}
+	m := metrics.New(true)
+	engine := NewEngine(stateManager, dp, cfg, m)
+	ctx := context.Background()
+
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The failed host's record and its ownership TXT record
+	if got := gaugeValue(t, m, "records_out_of_sync", "example.com"); got != "2" {
+		t.Errorf("example.com out of sync = %q, want 2", got)
+	}
+	if got := gaugeValue(t, m, "records_out_of_sync", "example.org"); got != "0" {
+		t.Errorf("example.org out of sync = %q, want 0", got)
+	}
+
+	dp.createErr = nil
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := gaugeValue(t, m, "records_out_of_sync", "example.com"); got != "0" {
+		t.Errorf("example.com out of sync after retry = %q, want 0", got)
+	}
+
+	// Dry runs apply nothing, so the plan stays out of sync
+	cfg.Reconcile.DryRun = true
+	dp = &MockProvider{records: map[string][]provider.Record{"example.com": {}, "example.org": {}}}
+	engine = NewEngine(&MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}, dp, cfg, m)
+	if _, err := engine.Reconcile(ctx, domains[2:]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := gaugeValue(t, m, "records_out_of_sync", "example.org"); got != "2" {
+		t.Errorf("example.org out of sync in dry run = %q, want 2", got)
+	}
+}
+
+func TestKeepOwnershipOnDelete(t *testing.T) {
+	removed := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", KeepOwnership: true, TombstoneTTL: 24 * time.Hour},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	heritage := HeritageData("test-owner")
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"app.example.com": {ServerName: "10.0.0.1"},
+	}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {
+		{ID: "a", Name: "app.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "txt", Name: "app.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+	}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	engine.now = func() time.Time { return removed }
+	ctx := context.Background()
+
+	// Removing the host deletes its record and turns the heritage record into a tombstone
+	if _, err := engine.Reconcile(ctx, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tombstone := tombstoneData("test-owner", removed)
+	if len(dp.deleted) != 1 || dp.deleted[0].Type != "A" {
+		t.Fatalf("Deleted = %+v, want only the A record", dp.deleted)
+	}
+	if len(dp.updated) != 1 || dp.updated[0].ID != "txt" || dp.updated[0].Data != tombstone {
+		t.Fatalf("Updated = %+v, want the TXT record as tombstone %q", dp.updated, tombstone)
+	}
+	if owner, deleted, ok := parseTombstone(tombstone); !ok || owner != "test-owner" || !deleted.Equal(removed) {
+		t.Errorf("parseTombstone = %q, %v, %v", owner, deleted, ok)
+	}
+	if ownsHeritage(tombstone, []string{"test-owner"}) {
+		t.Error("A tombstone must not confer ownership")
+	}
+
+	// Adding the host back reuses the tombstone as its heritage record
+	dp.records["example.com"] = []provider.Record{
+		{ID: "txt", Name: "app.example.com", Type: "TXT", Data: tombstone, Zone: "example.com"},
+	}
+	dp.created, dp.updated, dp.deleted = nil, nil, nil
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dp.created) != 1 || dp.created[0].Type != "A" {
+		t.Errorf("Created = %+v, want only the A record", dp.created)
+	}
+	if len(dp.updated) != 1 || dp.updated[0].ID != "txt" || dp.updated[0].Data != heritage {
+		t.Errorf("Updated = %+v, want the tombstone rewritten as heritage", dp.updated)
+	}
+
+	// Tombstones older than the ttl are collected, those of other owners are left alone
+	dp.records["example.com"] = []provider.Record{
+		{ID: "old", Name: "old.example.com", Type: "TXT", Data: tombstone, Zone: "example.com"},
+		{ID: "new", Name: "new.example.com", Type: "TXT", Data: tombstoneData("test-owner", removed.Add(12*time.Hour)), Zone: "example.com"},
+		{ID: "other", Name: "other.example.com", Type: "TXT", Data: tombstoneData("other-owner", removed), Zone: "example.com"},
+	}
+	dp.deleted = nil
+	engine.now = func() time.Time { return removed.Add(30 * time.Hour) }
+	collected, err := engine.CollectTombstones(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if collected != 1 || len(dp.deleted) != 1 || dp.deleted[0].ID != "old" {
+		t.Errorf("Collected %d, deleted %+v, want only the old tombstone", collected, dp.deleted)
+	}
+	if last := stateManager.audit[len(stateManager.audit)-1]; last.Reason != ReasonTombstone || last.Name != "old.example.com" {
+		t.Errorf("Last audit entry = %+v, want the collected tombstone", last)
+	}
+}
+
+func TestReconcileScope(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com", "example.org"}},
+	}
+	heritage := HeritageData("test-owner")
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.0.9:8080"},
+		{Host: "c.example.org", Upstream: "10.0.0.3:8080"},
+	}
+	newEngine := func() (*engine, *MockStateManager, *MockProvider) {
+		stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+			"a.example.com": {ServerName: "10.0.0.1:8080"},
+			"b.example.com": {ServerName: "10.0.0.2:8080"},
+		}}}
+		// a drifted at the provider without caddy changing
+		dp := &MockProvider{records: map[string][]provider.Record{
+			"example.com": {
+				{ID: "a", Name: "a.example.com", Type: "A", Data: "10.0.0.7", Zone: "example.com"},
+				{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+				{ID: "b", Name: "b.example.com", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+				{ID: "b-txt", Name: "b.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+			},
+			"example.org": {},
+		}}
+		return NewEngine(stateManager, dp, cfg, metrics.New(false)), stateManager, dp
+	}
+
+	tests := []struct {
+		name  string
+		scope Scope
+		ops   []string
+		state map[string]string // host to upstream in the saved state
+		err   error
+	}{
+		{
+			name:  "unchanged host",
+			scope: Scope{Host: "A.example.com."},
+			ops:   []string{"update a"},
+			state: map[string]string{"a.example.com": "10.0.0.1:8080", "b.example.com": "10.0.0.2:8080"},
+		},
+		{
+			name:  "changed host",
+			scope: Scope{Host: "b.example.com"},
+			ops:   []string{"update b"},
+			state: map[string]string{"a.example.com": "10.0.0.1:8080", "b.example.com": "10.0.0.9:8080"},
+		},
+		{
+			name:  "zone",
+			scope: Scope{Zone: "example.org"},
+			ops:   []string{"create c", "create c"},
+			state: map[string]string{"a.example.com": "10.0.0.1:8080", "b.example.com": "10.0.0.2:8080", "c.example.org": "10.0.0.3:8080"},
+		},
+		{name: "unknown host", scope: Scope{Host: "d.example.com"}, err: ErrScopeNoMatch},
+		{name: "host outside zones", scope: Scope{Host: "a.example.net"}, err: ErrInvalidScope},
+		{name: "unconfigured zone", scope: Scope{Zone: "example.net"}, err: ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, stateManager, dp := newEngine()
+			_, err := engine.ReconcileScope(context.Background(), domains, tt.scope)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if !reflect.DeepEqual(dp.ops, tt.ops) {
+				t.Errorf("Ops = %v, want %v", dp.ops, tt.ops)
+			}
+			got := make(map[string]string)
+			for host, d := range stateManager.state.Domains {
+				got[host] = d.ServerName
+			}
+			if !reflect.DeepEqual(got, tt.state) {
+				t.Errorf("State = %v, want %v", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestReconcilePartition(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com", "example.org"}},
+	}
+	heritage := HeritageData("test-owner")
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.0.9:8080"},
+		{Host: "c.example.org", Upstream: "10.0.0.3:8080"},
+	}
+	newEngine := func() (*engine, *MockStateManager, *MockProvider) {
+		stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+			"a.example.com": {ServerName: "10.0.0.1:8080"},
+			"b.example.com": {ServerName: "10.0.0.2:8080"},
+		}}}
+		// a drifted at the provider, only scoped syncs would notice
+		dp := &MockProvider{records: map[string][]provider.Record{
+			"example.com": {
+				{ID: "a", Name: "a.example.com", Type: "A", Data: "10.0.0.7", Zone: "example.com"},
+				{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+				{ID: "b", Name: "b.example.com", Type: "A", Data: "10.0.0.2", Zone: "example.com"},
+				{ID: "b-txt", Name: "b.example.com", Type: "TXT", Data: heritage, Zone: "example.com"},
+			},
+			"example.org": {},
+		}}
+		return NewEngine(stateManager, dp, cfg, metrics.New(false)), stateManager, dp
+	}
+
+	tests := []struct {
+		name      string
+		partition Partition
+		ops       []string
+		listed    []string          // zones read from the provider
+		state     map[string]string // host to upstream in the saved state
+		err       error
+	}{
+		{
+			name:      "zone",
+			partition: Partition{Zones: []string{"Example.org."}},
+			ops:       []string{"create c", "create c"},
+			listed:    []string{"example.org"},
+			state:     map[string]string{"a.example.com": "10.0.0.1:8080", "b.example.com": "10.0.0.2:8080", "c.example.org": "10.0.0.3:8080"},
+		},
+		{
+			name:      "rest",
+			partition: Partition{Zones: []string{"example.org"}, Rest: true},
+			ops:       []string{"update b"},
+			listed:    []string{"example.com"},
+			state:     map[string]string{"a.example.com": "10.0.0.1:8080", "b.example.com": "10.0.0.9:8080"},
+		},
+		{name: "unconfigured zone", partition: Partition{Zones: []string{"example.net"}}, err: ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, stateManager, dp := newEngine()
+			_, err := engine.ReconcilePartition(context.Background(), domains, tt.partition)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if !reflect.DeepEqual(dp.ops, tt.ops) {
+				t.Errorf("Ops = %v, want %v", dp.ops, tt.ops)
+			}
+			if !reflect.DeepEqual(dp.listed, tt.listed) {
+				t.Errorf("Listed zones = %v, want %v", dp.listed, tt.listed)
+			}
+			got := make(map[string]string)
+			for host, d := range stateManager.state.Domains {
+				got[host] = d.ServerName
+			}
+			if !reflect.DeepEqual(got, tt.state) {
+				t.Errorf("State = %v, want %v", got, tt.state)
+			}
+		})
+	}
+}
+
+// laggingProvider only lists created records after lag further reads of the zone
+type laggingProvider struct {
+	*MockProvider
+	lag   int
+	reads int
+}
+
+func (p *laggingProvider) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reads++
+	records := append([]provider.Record{}, p.records[zone]...)
+	if p.reads > p.lag {
+		for _, r := range p.created {
+			r.ID = "id-" + r.Type + "-" + r.Name
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func TestVerifyWrites(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", VerifyWrites: true},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	domains := []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}}
+
+	tests := []struct {
+		name string
+		lag  int
+		id   string
+	}{
+		{name: "listed right away", lag: 1, id: "id-A-a"},
+		{name: "listed after retries", lag: 3, id: "id-A-a"},
+		{name: "never listed", lag: 100, id: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+			// The first read is the plan's own
+			dp := &laggingProvider{MockProvider: &MockProvider{records: map[string][]provider.Record{"example.com": {}}}, lag: tt.lag}
+			engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+			engine.retryBackoff = 0
+
+			results, err := engine.Reconcile(context.Background(), domains)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(results.Failures) != 0 {
+				t.Fatalf("Unexpected failures: %+v", results.Failures)
+			}
+			records := stateManager.state.Domains["a.example.com"].Records
+			if len(records) != 2 || records[0].ID != tt.id {
+				t.Errorf("Records = %+v, want main record id %q", records, tt.id)
+			}
+		})
+	}
+}
+
+func TestKnownIDConflict(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	domains := []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}}
+
+	tests := []struct {
+		name     string
+		applied  state.DomainState
+		failures int
+	}{
+		{
+			name:    "created by an earlier sync",
+			applied: state.DomainState{ServerName: "10.0.0.1:8080", Records: []state.ManagedRecord{
+				{ID: "a-id", Zone: "example.com", Name: "a", Type: "A", Data: "10.0.0.1"},
+			}},
+		},
+		{
+			name:     "no id recorded",
+			applied: state.DomainState{ServerName: "10.0.0.1:8080", Records: []state.ManagedRecord{
+				{Zone: "example.com", Name: "a", Type: "A", Data: "10.0.0.1"},
+			}},
+			failures: 1,
+		},
+		{
+			name:     "id of another record",
+			applied: state.DomainState{ServerName: "10.0.0.1:8080", Records: []state.ManagedRecord{
+				{ID: "a-id", Zone: "example.com", Name: "a", Type: "A", Data: "10.0.0.9"},
+			}},
+			failures: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{"a.example.com": tt.applied}}}
+			// The records exist but are not listed yet, so creating them conflicts
+			dp := &MockProvider{
+				records:   map[string][]provider.Record{"example.com": {}},
+				createErr: fmt.Errorf("%w: record already exists", provider.ErrConflict),
+			}
+			engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+			results, err := engine.ReconcileScope(context.Background(), domains, Scope{Host: "a.example.com"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(results.Failures) != tt.failures {
+				t.Fatalf("Failures = %+v, want %d", results.Failures, tt.failures)
+			}
+			if records := stateManager.state.Domains["a.example.com"].Records; tt.failures == 0 && records[0].ID != "a-id" {
+				t.Errorf("Expected main record id to be kept, got %+v", records)
+			}
+		})
+	}
+}
+
+// resolvingProvider reports the id of the records it created
+type resolvingProvider struct {
+	*MockProvider
+}
+
+func (p *resolvingProvider) RecordID(zone string, record provider.Record) (string, bool) {
+	return "id-" + record.Type + "-" + record.Name, true
+}
+
+func TestFastSync(t *testing.T) {
+	heritage := txtIdentifier("test-owner")
+	managed := func(name, data string, ids bool) []state.ManagedRecord {
+		records := []state.ManagedRecord{
+			{ID: name + "-a", Zone: "example.com", Name: name, Type: "A", Data: data},
+			{ID: name + "-txt", Zone: "example.com", Name: name, Type: "TXT", Data: heritage},
+		}
+		if !ids {
+			records[1].ID = ""
+		}
+		return records
+	}
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.5:8080"},
+		{Host: "c.example.com", Upstream: "10.0.0.3:8080"},
+	}
+
+	tests := []struct {
+		name     string
+		fastSync bool
+		ids      bool // state holds the id of every record of b
+		listed   bool // the zone was listed, which fails
+	}{
+		{name: "planned from state", fastSync: true, ids: true},
+		{name: "record id missing", fastSync: true, ids: false, listed: true},
+		{name: "disabled", fastSync: false, ids: true, listed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Reconcile: config.Reconcile{Owner: "test-owner", FastSync: tt.fastSync},
+				DNS:       config.DNS{Zones: []string{"example.com"}},
+			}
+			stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+				"a.example.com": {ServerName: "10.0.0.1:8080", Records: managed("a", "10.0.0.1", true)},
+				"b.example.com": {ServerName: "10.0.0.2:8080", Records: managed("b", "10.0.0.2", tt.ids)},
+			}}}
+			dp := &resolvingProvider{&MockProvider{getRecordsErr: errors.New("zone listing failed")}}
+			engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+			results, err := engine.Reconcile(context.Background(), domains)
+			if tt.listed {
+				if err == nil {
+					t.Fatal("Expected the zone to be listed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(results.Updated) != 1 || len(results.Deleted) != 2 || len(results.Created) != 2 {
+				t.Errorf("Expected 1 update, 2 deletes and 2 creates, got %v", dp.ops)
+			}
+			for _, r := range dp.deleted {
+				if r.ID == "" {
+					t.Errorf("Expected delete by id, got %+v", r)
+				}
+			}
+			want := map[string][]state.ManagedRecord{
+				"a.example.com": {
+					{ID: "a-a", Zone: "example.com", Name: "a", Type: "A", Data: "10.0.0.5", TTL: defaultTTL},
+					{ID: "a-txt", Zone: "example.com", Name: "a", Type: "TXT", Data: heritage, TTL: defaultTTL},
+				},
+				"c.example.com": {
+					{ID: "id-A-c", Zone: "example.com", Name: "c", Type: "A", Data: "10.0.0.3", TTL: defaultTTL},
+					{ID: "id-TXT-c", Zone: "example.com", Name: "c", Type: "TXT", Data: heritage, TTL: defaultTTL},
+				},
+			}
+			for host, records := range want {
+				if got := stateManager.state.Domains[host].Records; !reflect.DeepEqual(got, records) {
+					t.Errorf("Records of %s = %+v, want %+v", host, got, records)
+				}
+			}
+
+			// A failed run has the next one list the zones
+			dp.createErr = errors.New("create failed")
+			added := append(domains, source.DomainConfig{Host: "d.example.com", Upstream: "10.0.0.4:8080"})
+			if _, err := engine.Reconcile(context.Background(), added); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := engine.Reconcile(context.Background(), added); err == nil {
+				t.Error("Expected the zones to be listed after a failed run")
+			}
+		})
+	}
+}
+
+func TestDuplicateHeritage(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", AcceptOwners: []string{"old-owner"}},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	records := []provider.Record{
+		{ID: "a", Name: "a.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "a-old", Name: "a.example.com", Type: "TXT", Data: HeritageData("old-owner"), Zone: "example.com"},
+		{ID: "a-txt", Name: "a.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+		{ID: "a-dup", Name: "a.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+		{ID: "a-other", Name: "a.example.com", Type: "TXT", Data: HeritageData("other-owner"), Zone: "example.com"},
+	}
+
+	tests := []struct {
+		name    string
+		domains []source.DomainConfig
+		prev    map[string]state.DomainState
+		deleted []string // ids
+	}{
+		{
+			name:    "planned host keeps one",
+			domains: []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}},
+			prev:    map[string]state.DomainState{},
+			deleted: []string{"a-old", "a-dup"},
+		},
+		{
+			name:    "removed host deletes every owned",
+			prev:    map[string]state.DomainState{"a.example.com": {ServerName: "10.0.0.1:8080"}},
+			deleted: []string{"a", "a-txt", "a-old", "a-dup"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateManager := &MockStateManager{state: state.State{Domains: tt.prev}}
+			dp := &MockProvider{records: map[string][]provider.Record{"example.com": records}}
+			engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+			results, err := engine.Reconcile(context.Background(), tt.domains)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(results.Failures) != 0 || len(dp.created) != 0 || len(dp.updated) != 0 {
+				t.Fatalf("Expected only deletes, got %v", dp.ops)
+			}
+			deleted := []string{}
+			for _, r := range dp.deleted {
+				deleted = append(deleted, r.ID)
+			}
+			slices.Sort(deleted)
+			slices.Sort(tt.deleted)
+			if !slices.Equal(deleted, tt.deleted) {
+				t.Errorf("Deleted %v, want %v", deleted, tt.deleted)
+			}
+		})
+	}
+}
+
+func TestApexTXT(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	spf := provider.Record{ID: "spf", Name: "example.com", Type: "TXT", Data: `"v=spf1 include:_spf.google.com ~all"`, Zone: "example.com"}
+	verification := provider.Record{ID: "verify", Name: "example.com", Type: "TXT", Data: "google-site-verification=abc", Zone: "example.com"}
+
+	tests := []struct {
+		name    string
+		records []provider.Record
+		domains []source.DomainConfig
+		prev    map[string]state.DomainState
+		created []string // types
+		deleted []string // ids
+	}{
+		{
+			name:    "added apex host",
+			records: []provider.Record{spf, verification},
+			domains: []source.DomainConfig{{Host: "example.com", Upstream: "10.0.0.1:8080"}},
+			prev:    map[string]state.DomainState{},
+			created: []string{"A", "TXT"},
+		},
+		{
+			name: "removed apex host",
+			records: []provider.Record{
+				spf,
+				{ID: "apex", Name: "example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+				{ID: "heritage", Name: "example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+				verification,
+			},
+			prev:    map[string]state.DomainState{"example.com": {ServerName: "10.0.0.1:8080"}},
+			deleted: []string{"apex", "heritage"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateManager := &MockStateManager{state: state.State{Domains: tt.prev}}
+			dp := &MockProvider{records: map[string][]provider.Record{"example.com": tt.records}}
+			engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+			results, err := engine.Reconcile(context.Background(), tt.domains)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(results.Failures) != 0 {
+				t.Fatalf("Unexpected failures: %+v", results.Failures)
+			}
+			created := []string{}
+			for _, r := range dp.created {
+				created = append(created, r.Type)
+			}
+			deleted := []string{}
+			for _, r := range dp.deleted {
+				deleted = append(deleted, r.ID)
+			}
+			if !slices.Equal(created, tt.created) && len(created)+len(tt.created) > 0 {
+				t.Errorf("Created %v, want %v", created, tt.created)
+			}
+			if !slices.Equal(deleted, tt.deleted) && len(deleted)+len(tt.deleted) > 0 {
+				t.Errorf("Deleted %v, want %v", deleted, tt.deleted)
+			}
+		})
+	}
+}
+
+// batchProvider applies batches through the mock, failing all of a batch
+// with batchErr
+type batchProvider struct {
+	*MockProvider
+	batches  [][]provider.Change
+	batchErr error
+}
+
+func (p *batchProvider) SupportsBatch() bool { return true }
+
+func (p *batchProvider) ApplyChanges(ctx context.Context, zone string, changes []provider.Change) error {
+	p.batches = append(p.batches, changes)
+	if p.batchErr != nil {
+		return p.batchErr
+	}
+	for _, c := range changes {
+		if err := p.MockProvider.CreateRecord(ctx, zone, c.Record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestBatchedGroups(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	domains := []source.DomainConfig{
+		{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "api.example.com", Upstream: "10.0.0.2:8080"},
+	}
+
+	t.Run("each group is one batch", func(t *testing.T) {
+		mock := &MockProvider{records: map[string][]provider.Record{}}
+		batcher := &batchProvider{MockProvider: mock}
+		results, err := NewEngine(&MockStateManager{}, batcher, cfg, metrics.New(false)).Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(batcher.batches) != 2 || len(batcher.batches[0]) != 2 {
+			t.Errorf("Expected a batch of main and TXT record per host, got %+v", batcher.batches)
+		}
+		if len(results.Failures) != 0 || len(results.Created) != 4 || len(mock.created) != 4 {
+			t.Errorf("Expected every record created, got %+v", results)
+		}
+	})
+
+	t.Run("failed batch changes nothing", func(t *testing.T) {
+		mock := &MockProvider{records: map[string][]provider.Record{}}
+		batcher := &batchProvider{MockProvider: mock, batchErr: errors.New("server error")}
+		results, err := NewEngine(&MockStateManager{}, batcher, cfg, metrics.New(false)).Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(results.Failures) != 2 || len(results.Created) != 0 {
+			t.Errorf("Expected a failure per host and nothing created, got %+v", results)
+		}
+		if len(mock.deleted) != 0 {
+			t.Errorf("Expected nothing to revert, got %+v", mock.deleted)
+		}
+	})
+
+	t.Run("conflicts fall back to single records", func(t *testing.T) {
+		mock := &MockProvider{records: map[string][]provider.Record{}}
+		batcher := &batchProvider{MockProvider: mock, batchErr: provider.ErrConflict}
+		results, err := NewEngine(&MockStateManager{}, batcher, cfg, metrics.New(false)).Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(results.Failures) != 0 || len(mock.created) != 4 {
+			t.Errorf("Expected records created one by one, got %+v", results)
+		}
+	})
+}
+
+func TestChurnCooldown(t *testing.T) {
+	now := time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", ChurnCooldown: 10 * time.Minute},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"blue.example.com": {ServerName: "10.0.0.1:8080", LastApplied: now.Add(-time.Minute).Unix()},
+		"old.example.com":  {ServerName: "10.0.0.1:8080", LastApplied: now.Add(-time.Hour).Unix()},
+	}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {
+		{ID: "blue-main", Name: "blue.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "blue-txt", Name: "blue.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+		{ID: "old-main", Name: "old.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "old-txt", Name: "old.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+	}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	engine.now = func() time.Time { return now }
+
+	domains := []source.DomainConfig{
+		{Host: "blue.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "old.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	plan := engine.LastPlan()
+	if len(plan.Update) != 1 || plan.Update[0].Name != "old" {
+		t.Fatalf("Expected only the host outside the cooldown updated, got %+v", plan.Update)
+	}
+	if d := stateManager.state.Domains["blue.example.com"]; d.ServerName != "10.0.0.1:8080" {
+		t.Errorf("Expected the suppressed host to keep its upstream in state, got %+v", d)
+	}
+
+	// Once the cooldown has passed the change is applied
+	now = now.Add(10 * time.Minute)
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	plan = engine.LastPlan()
+	if len(plan.Update) != 1 || plan.Update[0].Name != "blue" || plan.Update[0].Data != "10.0.0.2" {
+		t.Errorf("Expected the host updated after the cooldown, got %+v", plan.Update)
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{
+			Owner:          "test-owner",
+			HostAttributes: map[string]config.HostAttributes{"lan.example.com": {Target: "10.0.0.5"}},
+		},
+		DNS: config.DNS{
+			Zones: []string{"example.com"},
+			ZoneSettings: map[string]config.ZoneSettings{
+				"example.com": {Target: "203.0.113.10", Canonical: "services.example.com"},
+			},
+		},
+	}
+	now := time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.0.2:8080"},
+		{Host: "lan.example.com", Upstream: "10.0.0.3:8080"},
+	}
+
+	stateManager := &MockStateManager{}
+	dp := &MockProvider{records: map[string][]provider.Record{}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	engine.now = func() time.Time { return now }
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := make(map[string]string)
+	for _, r := range dp.created {
+		if r.Type != "TXT" {
+			got[r.Name] = r.Type + " " + r.Data
+		}
+	}
+	expected := map[string]string{
+		"services": "A 203.0.113.10",
+		"a":        "CNAME services.example.com",
+		"b":        "CNAME services.example.com",
+		"lan":      "A 10.0.0.5",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Created = %v, want %v", got, expected)
+	}
+	if d := stateManager.state.Domains["services.example.com"]; d.Source != canonicalSource {
+		t.Errorf("Expected the canonical name in state, got %+v", d)
+	}
+
+	// Hosts applied as address records before are moved onto the canonical name
+	stateManager = &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"a.example.com": {ServerName: "10.0.0.1:8080", LastApplied: now.Unix(), AppliedType: "A", AppliedData: "203.0.113.10"},
+	}}}
+	dp = &MockProvider{records: map[string][]provider.Record{"example.com": {
+		{ID: "a-main", Name: "a", Type: "A", Data: "203.0.113.10", Zone: "example.com"},
+		{ID: "a-txt", Name: "a", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+	}}}
+	engine = NewEngine(stateManager, dp, cfg, metrics.New(false))
+	if _, err := engine.Reconcile(context.Background(), domains[:1]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reasons := make(map[string]string)
+	for _, ex := range engine.LastPlan().Explain {
+		if ex.Type != "TXT" {
+			reasons[ex.Op+" "+ex.Name+" "+ex.Type] = ex.Reason
+		}
+	}
+	expected = map[string]string{
+		"create services A": ReasonHostAdded,
+		"delete a A":        reasonTypeChanged("A", "CNAME"),
+		"create a CNAME":    ReasonCanonical,
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Plan = %v, want %v", reasons, expected)
+	}
+
+	// Without a canonical name they are moved back off it
+	cfg.DNS.ZoneSettings = nil
+	stateManager = &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"a.example.com":        {ServerName: "10.0.0.1:8080", LastApplied: now.Unix(), AppliedType: "CNAME", AppliedData: "services.example.com"},
+		"services.example.com": {ServerName: "203.0.113.10", LastApplied: now.Unix(), Source: canonicalSource},
+	}}}
+	dp = &MockProvider{records: map[string][]provider.Record{"example.com": {
+		{ID: "a-main", Name: "a", Type: "CNAME", Data: "services.example.com", Zone: "example.com"},
+		{ID: "a-txt", Name: "a", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+		{ID: "s-main", Name: "services", Type: "A", Data: "203.0.113.10", Zone: "example.com"},
+		{ID: "s-txt", Name: "services", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+	}}}
+	engine = NewEngine(stateManager, dp, cfg, metrics.New(false))
+	if _, err := engine.Reconcile(context.Background(), domains[:1]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reasons = make(map[string]string)
+	for _, ex := range engine.LastPlan().Explain {
+		if ex.Type != "TXT" {
+			reasons[ex.Op+" "+ex.Name+" "+ex.Type] = ex.Reason
+		}
+	}
+	expected = map[string]string{
+		"delete services A": ReasonHostRemoved,
+		"delete a CNAME":    reasonTypeChanged("CNAME", "A"),
+		"create a A":        ReasonCanonical,
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Plan = %v, want %v", reasons, expected)
+	}
+}
+
+func TestDelegatedSubzone(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com", "lab.example.com"}},
+	}
+	stateManager := &MockStateManager{}
+	dp := &MockProvider{records: map[string][]provider.Record{}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{
+		{Host: "app.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "nas.lab.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := make(map[string]string)
+	for _, r := range dp.created {
+		if r.Type == "A" {
+			got[r.Name] += r.Zone
+		}
+	}
+	expected := map[string]string{"app": "example.com", "nas": "lab.example.com"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected each host created in its most specific zone only, got %v", got)
+	}
+}
+
+func TestStartupSafeguard(t *testing.T) {
+	now := time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", StartupDelay: 10 * time.Minute, InitialDryRuns: 3},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	engine.now = func() time.Time { return now }
+	domains := []source.DomainConfig{{Host: "a.example.com", Upstream: "10.0.0.1:8080"}}
+
+	// Held during the delay, then until three plans were dry run
+	for i, at := range []time.Duration{0, time.Minute, 11 * time.Minute} {
+		now = now.Add(at)
+		results, err := engine.Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !results.Held || len(dp.created) != 0 {
+			t.Fatalf("Run %d: expected the plan held without writes, got held=%v created=%+v", i, results.Held, dp.created)
+		}
+	}
+
+	results, err := engine.Reconcile(context.Background(), domains)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results.Held || len(dp.created) != 2 {
+		t.Errorf("Expected the plan written once the safeguard passed, got held=%v created=%+v", results.Held, dp.created)
+	}
+}
+
+func TestDryRunOps(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner", DryRunOps: []string{"delete"}},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{
+		"old.example.com": {ServerName: "10.0.0.1:8080", LastSeen: time.Now().Unix()},
+	}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {
+		{ID: "old-main", Name: "old.example.com", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		{ID: "old-txt", Name: "old.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+	}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+
+	domains := []source.DomainConfig{{Host: "new.example.com", Upstream: "10.0.0.2:8080"}}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dp.created) != 2 || len(dp.deleted) != 0 {
+		t.Fatalf("Expected creates written and deletes dry run, got created=%+v deleted=%+v", dp.created, dp.deleted)
+	}
+	simulated := 0
+	for _, entry := range stateManager.audit {
+		if entry.Op == "delete" && entry.Result == "dry_run" {
+			simulated++
+		}
+	}
+	if simulated != 2 {
+		t.Errorf("Expected both deletes audited as dry runs, got %+v", stateManager.audit)
+	}
+	// The removed host stays in state, so its deletes are planned again
+	if _, ok := stateManager.state.Domains["old.example.com"]; !ok {
+		t.Errorf("Expected the host of the dry run deletes kept in state, got %+v", stateManager.state.Domains)
+	}
+	if _, ok := stateManager.state.Domains["new.example.com"]; !ok {
+		t.Errorf("Expected the created host in state, got %+v", stateManager.state.Domains)
+	}
+}
@@ -0,0 +1,35 @@
+package reconcile
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// How a create the provider refused as already existing was resolved
+const (
+	ResolutionAdopted  = "adopted"  // an identical record was found and taken as created
+	ResolutionUnlisted = "unlisted" // created by an earlier sync, not listed by the provider yet
+)
+
+// adoptExisting looks up the records at the name of a record whose create
+// the provider refused as conflicting. An identical record is adopted as the
+// one created, with its provider id when listed, so the host is not failed
+// and its state not left unsaved every sync. A different record stays a
+// conflict.
+func (e *engine) adoptExisting(ctx context.Context, record provider.Record) (string, bool) {
+	existing, err := e.lookupRecords(ctx, record)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to look up existing record", "name", record.Name, "type", record.Type, "zone", record.Zone, "error", err)
+		return "", false
+	}
+	for _, r := range existing {
+		if provider.SameValue(r, record) {
+			slog.InfoContext(ctx, "Record already exists, adopting it", "name", record.Name, "type", record.Type, "zone", record.Zone, "id", r.ID)
+			e.metrics.IncConflictResolved(record.Zone, record.Type, ResolutionAdopted)
+			return r.ID, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,67 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+func TestAdoptExisting(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+
+	t.Run("identical record is adopted", func(t *testing.T) {
+		stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+		// The zone listing misses the record the create then runs into
+		mock := &MockProvider{
+			records:       map[string][]provider.Record{"example.com": {}},
+			createErr:     fmt.Errorf("%w: identical record", provider.ErrExists),
+			createErrType: "A",
+		}
+		finder := &finderProvider{MockProvider: mock, found: []provider.Record{
+			{ID: "a-id", Name: "app", Type: "A", Data: "10.0.0.1", Zone: "example.com"},
+		}}
+		engine := NewEngine(stateManager, finder, cfg, metrics.New(false))
+
+		results, err := engine.Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(results.Failures) != 0 || len(results.Created) != 2 {
+			t.Fatalf("Expected the existing record adopted as created, got %+v", results)
+		}
+		d, saved := stateManager.state.Domains["app.example.com"]
+		if !saved || d.Records[0].ID != "a-id" {
+			t.Errorf("Expected state saved with the id of the adopted record, got %+v", d)
+		}
+	})
+
+	t.Run("different record stays a failure", func(t *testing.T) {
+		mock := &MockProvider{
+			records:       map[string][]provider.Record{"example.com": {}},
+			createErr:     fmt.Errorf("%w: identical record", provider.ErrExists),
+			createErrType: "A",
+		}
+		finder := &finderProvider{MockProvider: mock, found: []provider.Record{
+			{ID: "a-id", Name: "app", Type: "A", Data: "10.0.0.9", Zone: "example.com"},
+		}}
+		engine := NewEngine(&MockStateManager{}, finder, cfg, metrics.New(false))
+
+		results, err := engine.Reconcile(context.Background(), domains)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(results.Failures) != 1 || results.Failures[0].Class != provider.ClassExists {
+			t.Errorf("Expected an exists failure, got %+v", results.Failures)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package reconcile
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// ExternalDNSRecord is an address record owned by an external-dns registry
+type ExternalDNSRecord struct {
+	Record   provider.Record
+	Owner    string
+	Registry []provider.Record // external-dns TXT records claiming the name
+}
+
+// parseExternalDNS returns the owner of an external-dns registry TXT record,
+// ok is false if the data is not an external-dns registry record
+func parseExternalDNS(data string) (owner string, ok bool) {
+	heritage := false
+	for _, field := range strings.Split(provider.NormalizeTXT(data), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch key {
+		case "heritage":
+			heritage = value == "external-dns"
+		case "external-dns/owner":
+			owner = value
+		}
+	}
+	return owner, heritage && owner != ""
+}
+
+// externalDNSNames returns the record names a registry TXT record may claim.
+// external-dns writes the registry at the record name, and since v0.12 also
+// at the name prefixed by the lowercase record type.
+func externalDNSNames(name string) []string {
+	names := []string{name}
+	for _, prefix := range []string{"a-", "aaaa-", "cname-"} {
+		if rest, ok := strings.CutPrefix(name, prefix); ok && rest != "" {
+			names = append(names, rest)
+		}
+	}
+	return names
+}
+
+// externalDNSOwned maps record names in zone to the registry TXT records of
+// external-dns owners in owners
+func externalDNSOwned(records []provider.Record, zone string, owners []string) map[string][]provider.Record {
+	owned := make(map[string][]provider.Record)
+	if len(owners) == 0 {
+		return owned
+	}
+	for _, r := range records {
+		if r.Type != "TXT" {
+			continue
+		}
+		owner, ok := parseExternalDNS(r.Data)
+		if !ok || !slices.Contains(owners, owner) {
+			continue
+		}
+		for _, name := range externalDNSNames(getRecordName(r.Name, zone)) {
+			owned[name] = append(owned[name], r)
+		}
+	}
+	return owned
+}
+
+// ExternalDNSRecords returns the address records of zone owned by any of the
+// external-dns owners
+func ExternalDNSRecords(records []provider.Record, zone string, owners []string) []ExternalDNSRecord {
+	records = withoutACMEChallenges(records, zone)
+	owned := externalDNSOwned(records, zone, owners)
+	found := []ExternalDNSRecord{}
+	for _, r := range records {
+		switch r.Type {
+		case "A", "AAAA", "CNAME":
+		default:
+			continue
+		}
+		registry, ok := owned[getRecordName(r.Name, zone)]
+		if !ok {
+			continue
+		}
+		owner, _ := parseExternalDNS(registry[0].Data)
+		found = append(found, ExternalDNSRecord{Record: r, Owner: owner, Registry: registry})
+	}
+	return found
+}
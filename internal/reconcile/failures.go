@@ -0,0 +1,144 @@
+package reconcile
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// skippedHosts returns hosts on the skip-list, empty when skipping is disabled
+func (e *engine) skippedHosts(ctx context.Context) (map[string]bool, error) {
+	skipped := make(map[string]bool)
+	if e.cfg.Reconcile.SkipAfterFailures <= 0 {
+		return skipped, nil
+	}
+	failures, err := e.stateManager.LoadFailures(ctx)
+	if err != nil {
+		return skipped, err
+	}
+	for host, f := range failures {
+		if f.Skipped {
+			skipped[host] = true
+		}
+	}
+	e.metrics.SetSkippedHosts(len(skipped))
+	return skipped, nil
+}
+
+// trackFailures counts consecutive failures per host, moving a host to the
+// skip-list once it reaches skipAfterFailures if set. Hosts applied cleanly
+// reset.
+func (e *engine) trackFailures(ctx context.Context, results Results) error {
+	e.failMu.Lock()
+	defer e.failMu.Unlock()
+
+	failures, err := e.stateManager.LoadFailures(ctx)
+	if err != nil {
+		return err
+	}
+
+	failed := make(map[string]OperationResult)
+	for _, f := range results.Failures {
+		failed[recordHost(f.Record)] = f
+	}
+	for _, g := range results.Groups {
+		host := recordHost(provider.Record{Name: g.Name, Zone: g.Zone})
+		if _, ok := failed[host]; !ok && g.Status == GroupApplied {
+			delete(failures, host)
+		}
+	}
+	now := e.now().Unix()
+	limit := e.cfg.Reconcile.SkipAfterFailures
+	for host, result := range failed {
+		f := failures[host]
+		f.Count++
+		f.LastError = result.Error
+		f.Class = result.Class
+		f.Op = result.Op
+		f.Type = result.Record.Type
+		f.Last = now
+		// A conflicting record will not resolve by retrying
+		if !f.Skipped && limit > 0 && (f.Count >= limit || result.Class == provider.ClassConflict) {
+			f.Skipped = true
+			f.Since = now
+			slog.WarnContext(ctx, "Skipping host after consecutive failures", "host", host, "failures", f.Count, "error", result.Error)
+		}
+		failures[host] = f
+	}
+
+	e.metrics.SetSkippedHosts(countSkipped(failures))
+	return e.stateManager.SaveFailures(ctx, failures)
+}
+
+// Skipped returns hosts on the skip-list, sorted by host
+func (e *engine) Skipped(ctx context.Context) ([]SkippedHost, error) {
+	failures, err := e.stateManager.LoadFailures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	skipped := []SkippedHost{}
+	for host, f := range failures {
+		if !f.Skipped {
+			continue
+		}
+		skipped = append(skipped, SkippedHost{
+			Host:     host,
+			Failures: f.Count,
+			Reason:   f.LastError,
+			Since:    f.Since,
+		})
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].Host < skipped[j].Host })
+	return skipped, nil
+}
+
+// ClearSkipped removes host from the skip-list so it is retried on the next
+// sync, an empty host clears every entry. Returns the number of hosts cleared.
+func (e *engine) ClearSkipped(ctx context.Context, host string) (int, error) {
+	e.failMu.Lock()
+	defer e.failMu.Unlock()
+
+	failures, err := e.stateManager.LoadFailures(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cleared := 0
+	for h, f := range failures {
+		if f.Skipped && (host == "" || h == host) {
+			delete(failures, h)
+			cleared++
+		}
+	}
+	if cleared == 0 {
+		return 0, nil
+	}
+	slog.InfoContext(ctx, "Cleared skipped hosts", "host", host, "count", cleared)
+	e.metrics.SetSkippedHosts(countSkipped(failures))
+	return cleared, e.stateManager.SaveFailures(ctx, failures)
+}
+
+func countSkipped(failures map[string]state.HostFailure) int {
+	count := 0
+	for _, f := range failures {
+		if f.Skipped {
+			count++
+		}
+	}
+	return count
+}
+
+// recordHost returns the fully qualified host of a record, whose name may be
+// relative to the zone or already fully qualified
+func recordHost(r provider.Record) string {
+	switch {
+	case r.Name == "@" || r.Name == r.Zone:
+		return r.Zone
+	case strings.HasSuffix(r.Name, "."+r.Zone):
+		return r.Name
+	}
+	return r.Name + "." + r.Zone
+}
@@ -0,0 +1,102 @@
+package reconcile
+
+import (
+	"context"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// fastRecords returns the records of the hosts changes touch, by zone, when
+// state holds the provider id of every one of them, so the plan can be made
+// without listing the zones. Hosts new to state have nothing written yet.
+// Returns nil when the zones must be listed: fastSync is off, a scoped or
+// recovering run must see the live zones, the last run failed, or a host's
+// records are not fully known.
+func (e *engine) fastRecords(changes state.StateChanges, prev state.State) map[string][]state.ManagedRecord {
+	if !e.cfg.Reconcile.FastSync || e.listZones.Load() || len(changes.Forced) > 0 || len(changes.Recovered) > 0 {
+		return nil
+	}
+	records := make(map[string][]state.ManagedRecord)
+	known := func(host string) bool {
+		d, exists := prev.Domains[host]
+		if !exists {
+			return true
+		}
+		if len(d.Records) == 0 {
+			return false
+		}
+		for _, r := range d.Records {
+			if r.ID == "" {
+				return false
+			}
+			records[r.Zone] = append(records[r.Zone], r)
+		}
+		return true
+	}
+	for _, d := range changes.Added {
+		if !known(d.Host) {
+			return nil
+		}
+	}
+	for _, host := range changes.Removed {
+		if !known(host) {
+			return nil
+		}
+	}
+	return records
+}
+
+// planRecords returns the records a plan for zone is made against, the
+// records of changes when planning from state or else the live zone
+func (e *engine) planRecords(ctx context.Context, zone string, changes state.StateChanges) ([]provider.Record, error) {
+	if changes.Records == nil {
+		return e.zoneRecords(ctx, zone)
+	}
+	records := make([]provider.Record, 0, len(changes.Records[zone]))
+	for _, r := range changes.Records[zone] {
+		records = append(records, provider.Record{
+			ID:      r.ID,
+			Name:    r.Name,
+			Type:    r.Type,
+			Data:    r.Data,
+			Zone:    r.Zone,
+			TTL:     r.TTL,
+			Proxied: r.Proxied,
+			Comment: r.Comment,
+		})
+	}
+	return records, nil
+}
+
+// managedRecords returns the records host d has once main, its desired main
+// record, its AAAA record and the records of its alternates were applied,
+// along with their provider ids where known
+func (e *engine) managedRecords(plan Plan, results Results, main provider.Record, d state.DomainState) []state.ManagedRecord {
+	records := []provider.Record{main}
+	if companion, ok := companionRecord(main, d.IPv6); ok {
+		records = append(records, companion)
+	}
+	records = append(records, alternateRecords(main, d.ServerName, d.Alternates)...)
+	if !e.useComments {
+		records = append(records, heritageRecord(main, e.heritageData(d.Source)))
+	}
+	managed := make([]state.ManagedRecord, 0, len(records))
+	for _, r := range records {
+		id, created := results.IDs[idKey(r)]
+		if !created {
+			id = plan.IDs[idKey(r)]
+		}
+		managed = append(managed, state.ManagedRecord{
+			ID:      id,
+			Zone:    r.Zone,
+			Name:    r.Name,
+			Type:    r.Type,
+			Data:    r.Data,
+			TTL:     r.TTL,
+			Proxied: r.Proxied,
+			Comment: r.Comment,
+		})
+	}
+	return managed
+}
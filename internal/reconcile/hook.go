@@ -0,0 +1,263 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// HookRule names the policy hook in the violations of the operations it denied
+const HookRule = "hook"
+
+// Hook decides on every planned operation before the plan is executed, so
+// org specific rules such as naming conventions need no fork of the engine
+type Hook interface {
+	Decide(ctx context.Context, op Operation) (Decision, error)
+}
+
+// Operation is a planned operation with the context it was planned in
+type Operation struct {
+	RunID    string
+	Op       string // create, update or delete
+	Reason   string
+	Source   string // source of the host the record belongs to
+	Record   provider.Record
+	Previous *provider.Record // record an update replaces
+}
+
+// Decision is what a hook decided for an operation, the zero value allows it
+type Decision struct {
+	Deny   bool
+	Reason string
+	// Replaces the ttl, proxied and comment of a created or updated record
+	// when set, and its data unless empty. Its zone, name and type are kept.
+	Record *provider.Record
+}
+
+// SetHook asks h to allow, deny or change every planned operation
+func (e *engine) SetHook(h Hook) {
+	e.hook = h
+}
+
+// applyHook asks the hook about every operation of plan. A host with a denied
+// operation is taken out of the plan, its groups are never split and both
+// hosts of a move go together, and keeps its previous state so it is asked
+// about again next run. A hook failing denies the operation.
+func (e *engine) applyHook(ctx context.Context, plan Plan, current, previous state.State, changes state.StateChanges) Plan {
+	if e.hook == nil {
+		return plan
+	}
+	type recordKey struct{ op, zone, name, recordType, data string }
+	changed := make(map[recordKey]provider.Record)
+	denied := make(map[string]bool)
+	violations := []Violation{}
+	runID := runid.FromContext(ctx)
+
+	plan.Groups = slices.Clone(plan.Groups)
+	for gi, g := range plan.Groups {
+		key := groupKey(g.Zone, g.Name)
+		records := slices.Clone(g.Records)
+		for i, r := range records {
+			op := Operation{
+				RunID:  runID,
+				Op:     g.Op,
+				Reason: plan.Reason(g.Op, r),
+				Source: plan.Sources[key],
+				Record: r,
+			}
+			if g.Op == "update" && i < len(g.Previous) {
+				prev := g.Previous[i]
+				op.Previous = &prev
+			}
+			decision, err := e.hook.Decide(ctx, op)
+			if err != nil {
+				slog.ErrorContext(ctx, "Policy hook failed, denying operation", "op", g.Op, "zone", r.Zone, "name", r.Name, "record_type", r.Type, "error", err)
+				decision = Decision{Deny: true, Reason: fmt.Sprintf("policy hook failed: %v", err)}
+			}
+			if decision.Deny {
+				slog.WarnContext(ctx, "Policy hook denied operation", "op", g.Op, "zone", r.Zone, "name", r.Name, "record_type", r.Type, "reason", decision.Reason)
+				denied[key] = true
+				violations = append(violations, Violation{Rule: HookRule, Op: g.Op, Zone: r.Zone, Name: r.Name, Type: r.Type, Message: decision.Reason})
+				break
+			}
+			if decision.Record == nil || g.Op == "delete" {
+				continue
+			}
+			mutated := r
+			if decision.Record.Data != "" {
+				mutated.Data = decision.Record.Data
+			}
+			mutated.TTL = decision.Record.TTL
+			mutated.Proxied = decision.Record.Proxied
+			mutated.Comment = decision.Record.Comment
+			if mutated == r {
+				continue
+			}
+			slog.InfoContext(ctx, "Policy hook changed record", "op", g.Op, "zone", r.Zone, "name", r.Name, "record_type", r.Type, "data", mutated.Data, "reason", decision.Reason)
+			records[i] = mutated
+			changed[recordKey{g.Op, r.Zone, r.Name, r.Type, r.Data}] = mutated
+		}
+		plan.Groups[gi].Records = records
+	}
+
+	if len(changed) > 0 {
+		replace := func(op string, records []provider.Record) []provider.Record {
+			out := slices.Clone(records)
+			for i, r := range out {
+				if m, ok := changed[recordKey{op, r.Zone, r.Name, r.Type, r.Data}]; ok {
+					out[i] = m
+				}
+			}
+			return out
+		}
+		plan.Create = replace("create", plan.Create)
+		plan.Update = replace("update", plan.Update)
+		plan.Explain = slices.Clone(plan.Explain)
+		for i, ex := range plan.Explain {
+			if m, ok := changed[recordKey{ex.Op, ex.Zone, ex.Name, ex.Type, ex.Data}]; ok {
+				plan.Explain[i].Data = m.Data
+			}
+		}
+		applies := make(map[string]provider.Record, len(plan.Applies))
+		for host, r := range plan.Applies {
+			for _, op := range []string{"create", "update"} {
+				if m, ok := changed[recordKey{op, r.Zone, r.Name, r.Type, r.Data}]; ok {
+					r = m
+				}
+			}
+			applies[host] = r
+		}
+		plan.Applies = applies
+	}
+	if len(denied) == 0 {
+		return plan
+	}
+
+	partner := plan.movePartners()
+	for key := range denied {
+		if other, moved := partner[key]; moved {
+			denied[other] = true
+		}
+	}
+	e.deferHosts(current, previous, changes, denied)
+	e.auditDenied(ctx, violations)
+	kept := plan.without(denied)
+	kept.Deferred = plan.Deferred
+	kept.Denied = violations
+	return kept
+}
+
+// auditDenied records the operations the hook denied
+func (e *engine) auditDenied(ctx context.Context, violations []Violation) {
+	now := e.now().Unix()
+	entries := make([]state.AuditEntry, 0, len(violations))
+	for _, v := range violations {
+		entries = append(entries, state.AuditEntry{
+			Time:   now,
+			Op:     v.Op,
+			Zone:   v.Zone,
+			Name:   v.Name,
+			Type:   v.Type,
+			Reason: v.Message,
+			Result: "denied",
+			RunID:  runid.FromContext(ctx),
+		})
+	}
+	if err := e.stateManager.AppendAudit(ctx, entries); err != nil {
+		slog.WarnContext(ctx, "Failed to write audit entries", "count", len(entries), "error", err)
+	}
+}
+
+// execHookTimeout bounds a single run of an exec hook
+const execHookTimeout = 10 * time.Second
+
+// ExecHook runs a command for every planned operation. The operation is
+// written to its stdin as json, and it answers on stdout with a decision in
+// json, nothing allows the operation. A command exiting non-zero fails.
+type ExecHook struct {
+	Command []string
+}
+
+// hookRecord is a record as exchanged with an exec hook
+type hookRecord struct {
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Data    string `json:"data"`
+	TTL     int    `json:"ttl,omitempty"` // seconds, zero for the provider's automatic ttl
+	Proxied bool   `json:"proxied,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+func toHookRecord(r provider.Record) hookRecord {
+	return hookRecord{
+		Zone:    r.Zone,
+		Name:    provider.FQDN(r.Name, r.Zone),
+		Type:    r.Type,
+		Data:    r.Data,
+		TTL:     int(r.TTL.Seconds()),
+		Proxied: r.Proxied,
+		Comment: r.Comment,
+	}
+}
+
+func (h ExecHook) Decide(ctx context.Context, op Operation) (Decision, error) {
+	in := struct {
+		RunID    string      `json:"runId,omitempty"`
+		Op       string      `json:"op"`
+		Reason   string      `json:"reason,omitempty"`
+		Source   string      `json:"source,omitempty"`
+		Record   hookRecord  `json:"record"`
+		Previous *hookRecord `json:"previous,omitempty"`
+	}{RunID: op.RunID, Op: op.Op, Reason: op.Reason, Source: op.Source, Record: toHookRecord(op.Record)}
+	if op.Previous != nil {
+		prev := toHookRecord(*op.Previous)
+		in.Previous = &prev
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, execHookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return Decision{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return Decision{}, nil
+	}
+
+	var out struct {
+		Deny   bool        `json:"deny"`
+		Reason string      `json:"reason"`
+		Record *hookRecord `json:"record"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Decision{}, fmt.Errorf("parse decision: %w", err)
+	}
+	decision := Decision{Deny: out.Deny, Reason: out.Reason}
+	if out.Record != nil {
+		decision.Record = &provider.Record{
+			Data:    out.Record.Data,
+			TTL:     time.Duration(out.Record.TTL) * time.Second,
+			Proxied: out.Record.Proxied,
+			Comment: out.Record.Comment,
+		}
+	}
+	return decision, nil
+}
@@ -0,0 +1,86 @@
+package reconcile
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// namingHook denies hosts not prefixed with svc- and gives A records a ttl
+type namingHook struct{}
+
+func (namingHook) Decide(ctx context.Context, op Operation) (Decision, error) {
+	if !strings.HasPrefix(op.Record.Name, "svc-") {
+		return Decision{Deny: true, Reason: "names must start with svc-"}, nil
+	}
+	if op.Record.Type != "A" {
+		return Decision{}, nil
+	}
+	r := op.Record
+	r.TTL = 5 * time.Minute
+	return Decision{Record: &r, Reason: "environment ttl"}, nil
+}
+
+func TestHook(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	engine.SetHook(namingHook{})
+
+	domains := []source.DomainConfig{
+		{Host: "svc-a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dp.created) != 2 {
+		t.Fatalf("Expected only the records of the allowed host created, got %+v", dp.created)
+	}
+	for _, r := range dp.created {
+		if r.Name != "svc-a" {
+			t.Errorf("Expected only svc-a created, got %+v", r)
+		}
+		if r.Type == "A" && r.TTL != 5*time.Minute {
+			t.Errorf("Expected the ttl set by the hook, got %+v", r)
+		}
+	}
+	if d := engine.LastPlan().Denied; len(d) != 1 || d[0].Name != "b" || d[0].Rule != HookRule {
+		t.Errorf("Expected the denied host reported, got %+v", d)
+	}
+	if _, ok := stateManager.state.Domains["b.example.com"]; ok {
+		t.Errorf("Expected the denied host left out of state, got %+v", stateManager.state.Domains)
+	}
+}
+
+func TestExecHook(t *testing.T) {
+	record := provider.Record{Name: "app", Zone: "example.com", Type: "A", Data: "10.0.0.1"}
+	hook := ExecHook{Command: []string{"sh", "-c", `grep -q '"name":"app.example.com"' && echo '{"record":{"data":"10.0.0.9","ttl":60}}'`}}
+	decision, err := hook.Decide(context.Background(), Operation{Op: "create", Record: record})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decision.Deny || decision.Record == nil || decision.Record.Data != "10.0.0.9" || decision.Record.TTL != time.Minute {
+		t.Errorf("Expected the record changed, got %+v", decision)
+	}
+
+	allow := ExecHook{Command: []string{"true"}}
+	if decision, err := allow.Decide(context.Background(), Operation{Op: "create", Record: record}); err != nil || decision.Deny || decision.Record != nil {
+		t.Errorf("Expected no output to allow, got %+v, %v", decision, err)
+	}
+	fail := ExecHook{Command: []string{"sh", "-c", "echo broken >&2; exit 1"}}
+	if _, err := fail.Decide(context.Background(), Operation{Op: "create", Record: record}); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected the failure with stderr, got %v", err)
+	}
+}
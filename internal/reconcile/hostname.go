@@ -0,0 +1,71 @@
+package reconcile
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"golang.org/x/net/idna"
+)
+
+// hostProfile maps hosts the way resolvers do, converting internationalized
+// names to punycode, and rejects names that are not valid in dns: labels of
+// letters, digits and hyphens, at most 63 bytes each and 253 in total
+var hostProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.VerifyDNSLength(true),
+)
+
+// normalizeHost returns host as a lowercase ascii dns name. A leading
+// wildcard label is kept.
+func normalizeHost(host string) (string, error) {
+	name := strings.TrimSuffix(host, ".")
+	wildcard := false
+	if rest, ok := strings.CutPrefix(name, "*."); ok {
+		name, wildcard = rest, true
+	}
+	ascii, err := hostProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname %q: %w", host, err)
+	}
+	if wildcard {
+		ascii = "*." + ascii
+	}
+	return ascii, nil
+}
+
+// normalizeDomains converts hosts to ascii dns names, returning hosts that
+// are not valid names separately
+func normalizeDomains(domains []source.DomainConfig) ([]source.DomainConfig, []string) {
+	valid := make([]source.DomainConfig, 0, len(domains))
+	invalid := []string{}
+	for _, d := range domains {
+		host, err := normalizeHost(d.Host)
+		if err != nil {
+			slog.Warn("Skipping invalid hostname from caddy", "host", d.Host, "error", err)
+			invalid = append(invalid, d.Host)
+			continue
+		}
+		if host != d.Host {
+			slog.Debug("Normalized hostname", "host", d.Host, "name", host)
+		}
+		d.Host = host
+		valid = append(valid, d)
+	}
+	return valid, invalid
+}
+
+// normalizeZones converts internationalized zones to punycode so they match
+// normalized hosts, zones that fail to convert are kept as configured
+func normalizeZones(zones []string) []string {
+	normalized := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		if ascii, err := normalizeHost(zone); err == nil {
+			zone = ascii
+		}
+		normalized = append(normalized, zone)
+	}
+	return normalized
+}
@@ -0,0 +1,43 @@
+package reconcile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{host: "app.example.com", want: "app.example.com"},
+		{host: "App.Example.COM.", want: "app.example.com"},
+		{host: "bücher.example.com", want: "xn--bcher-kva.example.com"},
+		{host: "*.münchen.example.com", want: "*.xn--mnchen-3ya.example.com"},
+		{host: "xn--bcher-kva.example.com", want: "xn--bcher-kva.example.com"},
+		{host: "bad_name.example.com", wantErr: true},
+		{host: "space name.example.com", wantErr: true},
+		{host: "-dash.example.com", wantErr: true},
+		{host: "a..example.com", wantErr: true},
+		{host: strings.Repeat("a", 64) + ".example.com", wantErr: true},
+		{host: strings.Repeat("abcdefghi.", 26) + "example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := normalizeHost(tt.host)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package reconcile
+
+import (
+	"slices"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// recordKey identifies the records at a zone relative name of one type
+type recordKey struct {
+	name       string
+	recordType string
+}
+
+// zoneIndex holds the records of one zone by name and type, built once per
+// zone and shared by addition and removal processing. Its maps are cleared
+// rather than reallocated between zones, so a plan over many zones reuses
+// the memory of the largest.
+type zoneIndex struct {
+	records map[recordKey][]provider.Record
+	main    map[string]provider.Record   // last A or CNAME record at a name
+	owned   map[string][]provider.Record // heritage TXT records of the accepted owners
+	tombs   map[string]provider.Record   // tombstones of the accepted owners, left by removed hosts
+	foreign map[string][]string          // owners named by heritage TXT records of other owners
+	current string                       // heritage of the written owner in the current format
+}
+
+func newZoneIndex() *zoneIndex {
+	return &zoneIndex{
+		records: make(map[recordKey][]provider.Record),
+		main:    make(map[string]provider.Record),
+		owned:   make(map[string][]provider.Record),
+		tombs:   make(map[string]provider.Record),
+		foreign: make(map[string][]string),
+	}
+}
+
+// reset indexes records of zone, replacing anything indexed before
+func (idx *zoneIndex) reset(zone string, owners []string, records []provider.Record) {
+	clear(idx.records)
+	clear(idx.main)
+	clear(idx.owned)
+	clear(idx.tombs)
+	clear(idx.foreign)
+	idx.current = ""
+	if len(owners) > 0 {
+		idx.current = txtIdentifier(owners[0])
+	}
+
+	for _, r := range records {
+		name := getRecordName(r.Name, zone)
+		key := recordKey{name: name, recordType: r.Type}
+		idx.records[key] = append(idx.records[key], r)
+		switch r.Type {
+		case "A", "CNAME":
+			idx.main[name] = r
+		case "TXT":
+			if ownsHeritage(r.Data, owners) {
+				idx.owned[name] = append(idx.owned[name], r)
+			} else if owner, ok := parseHeritage(r.Data); ok && !isTombstone(r.Data) && !slices.Contains(owners, owner) {
+				idx.foreign[name] = append(idx.foreign[name], owner)
+			} else if owner, _, ok := parseTombstone(r.Data); ok && slices.Contains(owners, owner) {
+				idx.tombs[name] = r
+			}
+		}
+	}
+}
+
+// lookup returns the records at name of recordType
+func (idx *zoneIndex) lookup(name, recordType string) []provider.Record {
+	return idx.records[recordKey{name: name, recordType: recordType}]
+}
+
+// mainRecord returns the A or CNAME record at name
+func (idx *zoneIndex) mainRecord(name string) (provider.Record, bool) {
+	r, ok := idx.main[name]
+	return r, ok
+}
+
+// mainRecordFor returns the main record at name to bring in line with
+// desired. Of several A records, those of a host with alternates, the one
+// already holding the desired data is preferred, then one not holding the
+// data of an alternate the host keeps. A name holding just those alternates
+// has no main record.
+func (idx *zoneIndex) mainRecordFor(name string, desired provider.Record, alternates []provider.Record) (provider.Record, bool) {
+	if desired.Type != "A" {
+		return idx.mainRecord(name)
+	}
+	records := idx.lookup(name, "A")
+	for _, r := range records {
+		if r.Data == desired.Data {
+			return r, true
+		}
+	}
+	for _, r := range records {
+		if !slices.ContainsFunc(alternates, func(a provider.Record) bool { return a.Data == r.Data }) {
+			return r, true
+		}
+	}
+	if main, ok := idx.mainRecord(name); ok && main.Type != "A" {
+		return main, true
+	}
+	return provider.Record{}, false
+}
+
+// ownedTXT returns the heritage TXT record of an accepted owner at name. Of
+// several, e.g. left by a crash or by more than one accepted owner, the one
+// in the current format for the written owner is preferred.
+func (idx *zoneIndex) ownedTXT(name string) (provider.Record, bool) {
+	records := idx.owned[name]
+	if len(records) == 0 {
+		return provider.Record{}, false
+	}
+	return records[idx.preferred(records)], true
+}
+
+// duplicateTXT returns the heritage TXT records of accepted owners at name
+// besides the one ownedTXT returns
+func (idx *zoneIndex) duplicateTXT(name string) []provider.Record {
+	records := idx.owned[name]
+	if len(records) < 2 {
+		return nil
+	}
+	i := idx.preferred(records)
+	return append(slices.Clone(records[:i]), records[i+1:]...)
+}
+
+func (idx *zoneIndex) preferred(records []provider.Record) int {
+	for i, r := range records {
+		if withoutSource(provider.NormalizeTXT(r.Data)) == idx.current {
+			return i
+		}
+	}
+	return 0
+}
+
+// tombstone returns the tombstone of an accepted owner at name
+func (idx *zoneIndex) tombstone(name string) (provider.Record, bool) {
+	r, ok := idx.tombs[name]
+	return r, ok
+}
+
+// foreignOwners returns the owners not accepted by this instance that heritage
+// TXT records at name claim it for
+func (idx *zoneIndex) foreignOwners(name string) []string {
+	return idx.foreign[name]
+}
+
+// addressRecords returns the A, AAAA and CNAME records at name
+func (idx *zoneIndex) addressRecords(name string) []provider.Record {
+	var records []provider.Record
+	for _, recordType := range []string{"A", "AAAA", "CNAME"} {
+		records = append(records, idx.lookup(name, recordType)...)
+	}
+	return records
+}
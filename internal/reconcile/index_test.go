@@ -0,0 +1,73 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+func TestZoneIndex(t *testing.T) {
+	a := provider.Record{ID: "1", Name: "app.example.com", Type: "A", Data: "10.0.0.1"}
+	aaaa := provider.Record{ID: "2", Name: "app.example.com", Type: "AAAA", Data: "2001:db8::1"}
+	owned := provider.Record{ID: "3", Name: "app.example.com", Type: "TXT", Data: `"` + txtIdentifier("test") + `"`}
+	other := provider.Record{ID: "4", Name: "web.example.com", Type: "TXT", Data: txtIdentifier("other")}
+	cname := provider.Record{ID: "5", Name: "example.com", Type: "CNAME", Data: "backend.example.net"}
+
+	idx := newZoneIndex()
+	idx.reset("example.com", []string{"test"}, []provider.Record{a, aaaa, owned, other, cname})
+
+	if r, ok := idx.mainRecord("app"); !ok || r != a {
+		t.Errorf("Expected main record %+v, got %+v", a, r)
+	}
+	if r, ok := idx.mainRecord("@"); !ok || r != cname {
+		t.Errorf("Expected apex main record %+v, got %+v", cname, r)
+	}
+	if r, ok := idx.ownedTXT("app"); !ok || r != owned {
+		t.Errorf("Expected owned TXT %+v, got %+v", owned, r)
+	}
+	if _, ok := idx.ownedTXT("web"); ok {
+		t.Errorf("Expected TXT of another owner not to be owned")
+	}
+	if got := idx.lookup("web", "TXT"); !reflect.DeepEqual(got, []provider.Record{other}) {
+		t.Errorf("Expected lookup to find %+v, got %+v", other, got)
+	}
+	if got := idx.addressRecords("app"); !reflect.DeepEqual(got, []provider.Record{a, aaaa}) {
+		t.Errorf("Expected address records %+v, got %+v", []provider.Record{a, aaaa}, got)
+	}
+
+	// Reusing the index for another zone forgets the previous one
+	idx.reset("example.net", []string{"test"}, []provider.Record{{Name: "api.example.net", Type: "A", Data: "10.0.0.2"}})
+	if _, ok := idx.mainRecord("app"); ok {
+		t.Errorf("Expected records of the previous zone to be cleared")
+	}
+	if r, ok := idx.mainRecord("api"); !ok || r.Data != "10.0.0.2" {
+		t.Errorf("Expected api record after reset, got %+v", r)
+	}
+}
+
+func TestZoneIndexDuplicateTXT(t *testing.T) {
+	accepted := provider.Record{ID: "1", Name: "app.example.com", Type: "TXT", Data: txtIdentifier("old")}
+	current := provider.Record{ID: "2", Name: "app.example.com", Type: "TXT", Data: `"` + txtIdentifier("test") + `"`}
+	again := provider.Record{ID: "3", Name: "app.example.com", Type: "TXT", Data: txtIdentifier("test")}
+	other := provider.Record{ID: "4", Name: "app.example.com", Type: "TXT", Data: txtIdentifier("other")}
+
+	idx := newZoneIndex()
+	idx.reset("example.com", []string{"test", "old"}, []provider.Record{accepted, current, other, again})
+
+	if r, ok := idx.ownedTXT("app"); !ok || r != current {
+		t.Errorf("Expected owned TXT %+v, got %+v", current, r)
+	}
+	if got := idx.duplicateTXT("app"); !reflect.DeepEqual(got, []provider.Record{accepted, again}) {
+		t.Errorf("Expected duplicates %+v, got %+v", []provider.Record{accepted, again}, got)
+	}
+
+	// Without one in the current format the first is kept
+	idx.reset("example.com", []string{"test", "old"}, []provider.Record{accepted, other})
+	if r, ok := idx.ownedTXT("app"); !ok || r != accepted {
+		t.Errorf("Expected owned TXT %+v, got %+v", accepted, r)
+	}
+	if got := idx.duplicateTXT("app"); got != nil {
+		t.Errorf("Expected no duplicates, got %+v", got)
+	}
+}
@@ -0,0 +1,71 @@
+package reconcile
+
+import (
+	"context"
+	"sort"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+)
+
+// InventoryRecord is a record the engine believes it manages, derived from
+// persisted state rather than read from the provider
+type InventoryRecord struct {
+	Host    string `json:"host"`
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Data    string `json:"data"`
+	TTL     int    `json:"ttl"` // seconds
+	Proxied bool   `json:"proxied"`
+	Source  string `json:"source,omitempty"` // source the host was last found in
+}
+
+// Inventory returns the records of every host in state, each host's main
+// record followed by its heritage TXT record unless ownership is stored in
+// comments, sorted by zone, name and type.
+// Protected hosts and hosts outside the configured zones are left out.
+func (e *engine) Inventory(ctx context.Context) ([]InventoryRecord, error) {
+	st, err := e.stateManager.LoadState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inventory := []InventoryRecord{}
+	for host, d := range st.Domains {
+		for _, zone := range e.zones {
+			if !e.inZone(host, zone) || e.isProtected(host) {
+				continue
+			}
+			main := e.desiredRecord(source.DomainConfig{Host: host, Upstream: d.ServerName, Source: d.Source}, zone)
+			inventory = append(inventory, inventoryRecord(host, d.Source, main))
+			if !e.useComments {
+				inventory = append(inventory, inventoryRecord(host, d.Source, heritageRecord(main, e.heritageData(d.Source))))
+			}
+			break
+		}
+	}
+	sort.Slice(inventory, func(i, j int) bool {
+		a, b := inventory[i], inventory[j]
+		if a.Zone != b.Zone {
+			return a.Zone < b.Zone
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+	return inventory, nil
+}
+
+func inventoryRecord(host, src string, r provider.Record) InventoryRecord {
+	return InventoryRecord{
+		Host:    host,
+		Source:  src,
+		Zone:    r.Zone,
+		Name:    r.Name,
+		Type:    r.Type,
+		Data:    r.Data,
+		TTL:     int(r.TTL.Seconds()),
+		Proxied: r.Proxied,
+	}
+}
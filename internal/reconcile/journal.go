@@ -0,0 +1,80 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// journalKey identifies an operation of the running plan
+func journalKey(op string, r provider.Record) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", op, r.Zone, r.Name, r.Type, r.Data)
+}
+
+// startJournal persists every operation of plan before it is executed
+func (e *engine) startJournal(ctx context.Context, plan Plan) error {
+	entries := []state.JournalEntry{}
+	index := make(map[string]int)
+	for _, group := range plan.Groups {
+		for _, r := range group.Records {
+			index[journalKey(group.Op, r)] = len(entries)
+			entries = append(entries, state.JournalEntry{Op: group.Op, Zone: r.Zone, Name: r.Name, Type: r.Type, Data: r.Data})
+		}
+	}
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+	e.journal = index
+	return e.stateManager.SaveJournal(ctx, entries)
+}
+
+// journalApplied marks an operation of the running plan as completed
+func (e *engine) journalApplied(ctx context.Context, op string, record provider.Record) {
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+	i, ok := e.journal[journalKey(op, record)]
+	if !ok {
+		return
+	}
+	if err := e.stateManager.MarkJournalApplied(ctx, i); err != nil {
+		slog.ErrorContext(ctx, "Failed to mark journal operation applied", "op", op, "name", record.Name, "type", record.Type, "error", err)
+	}
+}
+
+// finishJournal clears the journal once the plan has run to completion
+func (e *engine) finishJournal(ctx context.Context) {
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+	e.journal = nil
+	if err := e.stateManager.SaveJournal(ctx, nil); err != nil {
+		slog.ErrorContext(ctx, "Failed to clear plan journal", "error", err)
+	}
+}
+
+// recoverJournal inspects the journal left by an interrupted run. Plans are
+// always generated against the live zones, so the next plan picks up where
+// the run stopped, except for records created without their ownership TXT
+// record yet. Those are returned by zone/name so they are adopted.
+func (e *engine) recoverJournal(ctx context.Context) (map[string]bool, error) {
+	recovered := make(map[string]bool)
+	entries, err := e.stateManager.LoadJournal(ctx)
+	if err != nil || len(entries) == 0 {
+		return recovered, err
+	}
+
+	applied := 0
+	for _, entry := range entries {
+		if !entry.Applied {
+			continue
+		}
+		applied++
+		if entry.Op == "create" && entry.Type != "TXT" {
+			recovered[entry.Zone+"/"+getRecordName(entry.Name, entry.Zone)] = true
+		}
+	}
+	slog.WarnContext(ctx, "Found interrupted plan, reconciling against provider", "applied", applied, "pending", len(entries)-applied)
+	e.metrics.IncSyncInterrupted()
+	return recovered, nil
+}
@@ -0,0 +1,169 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
+)
+
+// Lifecycle hooks run around the execution of a plan
+const (
+	PreSync  = "pre"
+	PostSync = "post"
+)
+
+// SyncHook is told about a sync around the execution of its plan, e.g. to
+// flush a local resolver cache once new records exist
+type SyncHook interface {
+	Run(ctx context.Context, event SyncEvent) error
+}
+
+// SyncEvent is the plan about to be executed for the pre sync hook, and the
+// records written for the post sync hook
+type SyncEvent struct {
+	Hook   string // PreSync or PostSync
+	RunID  string
+	Create []provider.Record
+	Update []provider.Record
+	Delete []provider.Record
+}
+
+// SetSyncHooks runs pre before every plan executed and post after every plan
+// executed without failures. Either may be nil.
+func (e *engine) SetSyncHooks(pre, post SyncHook) {
+	e.preSync = pre
+	e.postSync = post
+}
+
+// runSyncHook runs h for the records of event, unless there are none
+func (e *engine) runSyncHook(ctx context.Context, h SyncHook, event SyncEvent) error {
+	if h == nil || len(event.Create)+len(event.Update)+len(event.Delete) == 0 {
+		return nil
+	}
+	event.RunID = runid.FromContext(ctx)
+	start := time.Now()
+	err := h.Run(ctx, event)
+	e.metrics.IncSyncHook(event.Hook, err == nil)
+	if err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Ran sync hook", "hook", event.Hook, "duration", time.Since(start))
+	return nil
+}
+
+// syncEventJSON is a SyncEvent as passed to commands and urls
+type syncEventJSON struct {
+	Hook   string       `json:"hook"`
+	RunID  string       `json:"runId,omitempty"`
+	Create []hookRecord `json:"create"`
+	Update []hookRecord `json:"update"`
+	Delete []hookRecord `json:"delete"`
+}
+
+func marshalSyncEvent(event SyncEvent) ([]byte, error) {
+	records := func(rs []provider.Record) []hookRecord {
+		out := make([]hookRecord, 0, len(rs))
+		for _, r := range rs {
+			out = append(out, toHookRecord(r))
+		}
+		return out
+	}
+	return json.Marshal(syncEventJSON{
+		Hook:   event.Hook,
+		RunID:  event.RunID,
+		Create: records(event.Create),
+		Update: records(event.Update),
+		Delete: records(event.Delete),
+	})
+}
+
+// NewSyncHook returns the command or url hook of cfg, nil if it has neither
+func NewSyncHook(cfg config.SyncHook, userAgent string) SyncHook {
+	switch {
+	case len(cfg.Command) > 0:
+		return CommandSyncHook{Command: cfg.Command, Timeout: cfg.Timeout}
+	case cfg.URL != "":
+		return HTTPSyncHook{URL: cfg.URL, UserAgent: userAgent, Client: &http.Client{Timeout: cfg.Timeout}}
+	}
+	return nil
+}
+
+// CommandSyncHook runs a command with the event as json on its stdin. The
+// hook, run id and record counts are set in its environment as well, for
+// scripts that need no details. A command exiting non-zero fails.
+type CommandSyncHook struct {
+	Command []string
+	Timeout time.Duration // zero for no limit
+}
+
+func (h CommandSyncHook) Run(ctx context.Context, event SyncEvent) error {
+	data, err := marshalSyncEvent(event)
+	if err != nil {
+		return err
+	}
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"CADDY_DNS_SYNC_HOOK="+event.Hook,
+		"CADDY_DNS_SYNC_RUN_ID="+event.RunID,
+		"CADDY_DNS_SYNC_CREATE="+strconv.Itoa(len(event.Create)),
+		"CADDY_DNS_SYNC_UPDATE="+strconv.Itoa(len(event.Update)),
+		"CADDY_DNS_SYNC_DELETE="+strconv.Itoa(len(event.Delete)),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// HTTPSyncHook POSTs the event as json to a url. Any status but success fails.
+type HTTPSyncHook struct {
+	URL       string
+	UserAgent string
+	Client    *http.Client
+}
+
+func (h HTTPSyncHook) Run(ctx context.Context, event SyncEvent) error {
+	data, err := marshalSyncEvent(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.UserAgent != "" {
+		req.Header.Set("User-Agent", h.UserAgent)
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("hook returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// recordingHook records the events it ran for, failing with err
+type recordingHook struct {
+	events []SyncEvent
+	err    error
+}
+
+func (h *recordingHook) Run(ctx context.Context, event SyncEvent) error {
+	h.events = append(h.events, event)
+	return h.err
+}
+
+func TestSyncHooks(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	pre := &recordingHook{err: errors.New("cache busy")}
+	post := &recordingHook{}
+	engine.SetSyncHooks(pre, post)
+
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+	ctx := context.Background()
+
+	// A failing pre sync hook keeps the plan from being executed
+	if _, err := engine.Reconcile(ctx, domains); err == nil {
+		t.Fatal("Expected the failing pre sync hook to fail the sync")
+	}
+	if len(dp.created) != 0 || len(post.events) != 0 {
+		t.Fatalf("Expected nothing written, got %+v and post events %+v", dp.created, post.events)
+	}
+	if len(stateManager.state.Domains) != 0 {
+		t.Errorf("Expected the previous state kept, got %+v", stateManager.state.Domains)
+	}
+
+	pre.err = nil
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pre.events) != 2 || pre.events[1].Hook != PreSync || len(pre.events[1].Create) != 2 {
+		t.Errorf("Expected the planned creates passed to the pre sync hook, got %+v", pre.events)
+	}
+	if len(post.events) != 1 || post.events[0].Hook != PostSync || len(post.events[0].Create) != 2 {
+		t.Errorf("Expected the created records passed to the post sync hook, got %+v", post.events)
+	}
+
+	// Neither hook runs without changes
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pre.events) != 2 || len(post.events) != 1 {
+		t.Errorf("Expected no hook runs without changes, got %d pre and %d post", len(pre.events), len(post.events))
+	}
+}
+
+func TestCommandSyncHook(t *testing.T) {
+	event := SyncEvent{Hook: PostSync, Create: []provider.Record{{Name: "app", Zone: "example.com", Type: "A", Data: "10.0.0.1"}}}
+	hook := CommandSyncHook{Command: []string{"sh", "-c", `[ "$CADDY_DNS_SYNC_CREATE" = 1 ] && grep -q '"name":"app.example.com"'`}, Timeout: 10 * time.Second}
+	if err := hook.Run(context.Background(), event); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	failing := CommandSyncHook{Command: []string{"sh", "-c", "echo flush failed >&2; exit 1"}}
+	if err := failing.Run(context.Background(), event); err == nil {
+		t.Error("Expected an error for a command exiting non-zero")
+	}
+}
+
+func TestHTTPSyncHook(t *testing.T) {
+	var got syncEventJSON
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	hook := NewSyncHook(config.SyncHook{URL: server.URL, Timeout: time.Second}, "test")
+	event := SyncEvent{Hook: PreSync, RunID: "run-1", Delete: []provider.Record{{Name: "old", Zone: "example.com", Type: "A", Data: "10.0.0.2"}}}
+	if err := hook.Run(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got.Hook != PreSync || got.RunID != "run-1" || len(got.Delete) != 1 || got.Delete[0].Name != "old.example.com" {
+		t.Errorf("Expected the event posted, got %+v", got)
+	}
+	status = http.StatusBadGateway
+	if err := hook.Run(context.Background(), event); err == nil {
+		t.Error("Expected an error for a failed status")
+	}
+}
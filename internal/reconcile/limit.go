@@ -0,0 +1,146 @@
+package reconcile
+
+import (
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// limitOps keeps the operations of as many hosts as fit in max provider
+// writes, in plan order, and returns the zone/name of hosts left for the next
+// run. A host's groups are never split and the first host is always kept, so
+// a host with more records than the limit still goes through. Both hosts of a
+// move are kept or left together.
+func (p Plan) limitOps(max int) (Plan, map[string]bool) {
+	ops := make(map[string]int)
+	order := []string{}
+	for _, g := range p.Groups {
+		key := groupKey(g.Zone, g.Name)
+		if _, seen := ops[key]; !seen {
+			order = append(order, key)
+		}
+		ops[key] += len(g.Records)
+	}
+	partner := p.movePartners()
+
+	kept := make(map[string]bool)
+	deferred := make(map[string]bool)
+	used := 0
+	for _, key := range order {
+		if kept[key] || deferred[key] {
+			continue
+		}
+		unit := []string{key}
+		if other, moved := partner[key]; moved {
+			unit = append(unit, other)
+		}
+		cost := 0
+		for _, k := range unit {
+			cost += ops[k]
+		}
+		fits := len(deferred) == 0 && (used == 0 || used+cost <= max)
+		for _, k := range unit {
+			if fits {
+				kept[k] = true
+			} else {
+				deferred[k] = true
+			}
+		}
+		if fits {
+			used += cost
+		}
+	}
+	if len(deferred) == 0 {
+		return p, nil
+	}
+
+	limited := p.without(deferred)
+	for _, g := range p.Groups {
+		if deferred[groupKey(g.Zone, g.Name)] {
+			limited.Deferred += len(g.Records)
+		}
+	}
+	return limited, deferred
+}
+
+// movePartners maps the zone/name of either host of a move to the other
+func (p Plan) movePartners() map[string]string {
+	partner := make(map[string]string)
+	for _, m := range p.Moves {
+		to, from := groupKey(m.ToZone, m.To), groupKey(m.FromZone, m.From)
+		partner[to], partner[from] = from, to
+	}
+	return partner
+}
+
+// without returns the plan less the operations of the hosts in deferred, by
+// zone/name
+func (p Plan) without(deferred map[string]bool) Plan {
+	limited := Plan{
+		RunID:     p.RunID,
+		Create:    keptRecords(p.Create, deferred),
+		Update:    keptRecords(p.Update, deferred),
+		Delete:    keptRecords(p.Delete, deferred),
+		Unmanaged: p.Unmanaged,
+		Conflicts: p.Conflicts,
+		Contested: p.Contested,
+		Sources:   p.Sources,
+		IDs:       p.IDs,
+	}
+	for _, g := range p.Groups {
+		if !deferred[groupKey(g.Zone, g.Name)] {
+			limited.Groups = append(limited.Groups, g)
+		}
+	}
+	for _, ex := range p.Explain {
+		if !deferred[groupKey(ex.Zone, ex.Name)] {
+			limited.Explain = append(limited.Explain, ex)
+		}
+	}
+	for host, record := range p.Applies {
+		if !deferred[groupKey(record.Zone, record.Name)] {
+			limited.markApplied(host, record)
+		}
+	}
+	for _, m := range p.Moves {
+		if !deferred[groupKey(m.ToZone, m.To)] {
+			limited.Moves = append(limited.Moves, m)
+		}
+	}
+	return limited
+}
+
+func keptRecords(records []provider.Record, deferred map[string]bool) []provider.Record {
+	kept := []provider.Record{}
+	for _, r := range records {
+		if !deferred[groupKey(r.Zone, r.Name)] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// deferHosts keeps the previous state of hosts whose operations were left for
+// the next run, so they are planned again then
+func (e *engine) deferHosts(current, previous state.State, changes state.StateChanges, deferred map[string]bool) {
+	hosts := make([]string, 0, len(changes.Added)+len(changes.Removed))
+	for _, d := range changes.Added {
+		hosts = append(hosts, d.Host)
+	}
+	hosts = append(hosts, changes.Removed...)
+	for _, host := range hosts {
+		zone, ok := e.zoneOf(host)
+		if !ok || !deferred[groupKey(zone, host)] {
+			continue
+		}
+		prev, exists := previous.Domains[host]
+		if !exists {
+			delete(current.Domains, host)
+			continue
+		}
+		// Still in caddy, so still seen
+		if d, seen := current.Domains[host]; seen {
+			prev.LastSeen = d.LastSeen
+		}
+		current.Domains[host] = prev
+	}
+}
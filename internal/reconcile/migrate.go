@@ -0,0 +1,59 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// legacyHeritage unwraps heritage data quoted twice, as written by the legacy
+// engine, e.g. "\"heritage=caddy-dns-sync,...\"". ok is false for data in
+// any other format.
+func legacyHeritage(data string) (string, bool) {
+	normalized := provider.NormalizeTXT(data)
+	if !strings.HasPrefix(normalized, `"`) {
+		return normalized, false
+	}
+	return provider.NormalizeTXT(normalized), true
+}
+
+// MigrateHeritage rewrites owned heritage TXT records still in a legacy or
+// outdated format in place, so hosts whose records do not change are not
+// left in the old format. Returns how many records were, or in dry run would
+// be, rewritten.
+func (e *engine) MigrateHeritage(ctx context.Context) (int, error) {
+	if e.useComments {
+		return 0, nil
+	}
+	ownerTXT := txtIdentifier(e.cfg.Reconcile.Owner)
+	migrated := 0
+	for _, zone := range e.zones {
+		records, err := e.zoneRecords(ctx, zone)
+		if err != nil {
+			return migrated, fmt.Errorf("get records for zone %s: %w", zone, err)
+		}
+		for _, r := range records {
+			if r.Type != "TXT" || r.ID == "" || withoutSource(provider.NormalizeTXT(r.Data)) == ownerTXT || isTombstone(r.Data) {
+				continue
+			}
+			if owner, ok := parseHeritage(r.Data); !ok || owner != e.cfg.Reconcile.Owner {
+				continue
+			}
+			migrated++
+			if e.isDryRun() || e.cfg.Reconcile.Shadow {
+				slog.InfoContext(ctx, "Would migrate heritage record", "name", r.Name, "zone", zone, "data", r.Data)
+				continue
+			}
+			updated := r
+			updated.Data = ownerTXT
+			if err := e.apply(ctx, "update", updated); err != nil {
+				return migrated - 1, fmt.Errorf("update heritage record %s: %w", r.Name, err)
+			}
+			slog.InfoContext(ctx, "Migrated heritage record", "name", r.Name, "zone", zone)
+		}
+	}
+	return migrated, nil
+}
@@ -0,0 +1,164 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// Move is a host that left one zone for another in the same sync, e.g.
+// app.example.com becoming app.example.org. Its records are created in the
+// new zone first, and the old records are only deleted once that succeeded.
+type Move struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	FromZone string `json:"fromZone"`
+	ToZone   string `json:"toZone"`
+}
+
+func reasonMovedFrom(from string) string {
+	return fmt.Sprintf("host moved from %s", from)
+}
+
+func reasonMovedTo(to string) string {
+	return fmt.Sprintf("host moved to %s", to)
+}
+
+// zoneOf returns the most specific configured zone host belongs to, so a
+// host of a subzone delegated to another provider is only ever planned there
+func (e *engine) zoneOf(host string) (string, bool) {
+	best := ""
+	for _, zone := range e.zones {
+		if len(zone) > len(best) && belongsToZone(host, zone) {
+			best = zone
+		}
+	}
+	return best, best != ""
+}
+
+// inZone reports whether zone is the zone host is planned in
+func (e *engine) inZone(host, zone string) bool {
+	if !belongsToZone(host, zone) {
+		return false
+	}
+	best, _ := e.zoneOf(host)
+	return best == zone
+}
+
+// detectMoves pairs added hosts with removed hosts of another zone that had
+// the same record name and upstream. Only unambiguous pairs are moves, the
+// rest stay independent additions and removals.
+func (e *engine) detectMoves(changes state.StateChanges, previous state.State) map[string]string {
+	type candidate struct{ name, upstream string }
+	removed := make(map[candidate][]string)
+	for _, host := range changes.Removed {
+		zone, ok := e.zoneOf(host)
+		if !ok {
+			continue
+		}
+		key := candidate{getRecordName(host, zone), previous.Domains[host].ServerName}
+		removed[key] = append(removed[key], host)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	added := make(map[candidate][]string)
+	for _, d := range changes.Added {
+		if _, modified := changes.Previous[d.Host]; modified {
+			continue
+		}
+		zone, ok := e.zoneOf(d.Host)
+		if !ok {
+			continue
+		}
+		key := candidate{getRecordName(d.Host, zone), d.Upstream}
+		added[key] = append(added[key], d.Host)
+	}
+
+	moved := make(map[string]string)
+	for key, to := range added {
+		from := removed[key]
+		if len(to) != 1 || len(from) != 1 {
+			continue
+		}
+		fromZone, _ := e.zoneOf(from[0])
+		toZone, _ := e.zoneOf(to[0])
+		if fromZone != toZone {
+			moved[to[0]] = from[0]
+		}
+	}
+	return moved
+}
+
+// linkMoves records the moves of the plan, ordering the delete of each moved
+// host's old records after its records in the new zone. A move whose new host
+// is not brought in line by the plan, e.g. left alone as unmanaged, is an
+// ordinary removal.
+func (p *Plan) linkMoves(ctx context.Context, moved map[string]string, zoneOf func(string) (string, bool)) {
+	hosts := make([]string, 0, len(moved))
+	for to := range moved {
+		hosts = append(hosts, to)
+	}
+	sort.Strings(hosts)
+	for _, to := range hosts {
+		from := moved[to]
+		record, applies := p.Applies[to]
+		if !applies {
+			continue
+		}
+		fromZone, _ := zoneOf(from)
+		fromKey := groupKey(fromZone, from)
+		toKey := groupKey(record.Zone, record.Name)
+		move := Move{From: from, To: to, FromZone: fromZone, ToZone: record.Zone}
+		after := ""
+		for _, g := range p.Groups {
+			if g.Op != "delete" && groupKey(g.Zone, g.Name) == toKey {
+				after = toKey
+			}
+		}
+		for i := range p.Groups {
+			g := &p.Groups[i]
+			// Including the tombstone replacing the old heritage record
+			if g.Op != "create" && groupKey(g.Zone, g.Name) == fromKey {
+				g.After = after
+			}
+		}
+		for i := range p.Explain {
+			ex := &p.Explain[i]
+			key := groupKey(ex.Zone, ex.Name)
+			switch {
+			case ex.Op == "delete" && key == fromKey:
+				ex.Reason = reasonMovedTo(to)
+			case ex.Op != "delete" && key == toKey:
+				ex.Reason = reasonMovedFrom(from)
+			}
+		}
+		slog.InfoContext(ctx, "Planned host move across zones", "from", from, "to", to, "from_zone", fromZone, "to_zone", record.Zone)
+		p.Moves = append(p.Moves, move)
+	}
+}
+
+// groupKey identifies the records of a host, planned records are named
+// relative to their zone but records from the provider may be fully qualified
+func groupKey(zone, name string) string {
+	return zone + "/" + getRecordName(name, zone)
+}
+
+// appliedGroups returns the zone/name of hosts whose every group was applied
+func appliedGroups(results Results) map[string]bool {
+	applied := make(map[string]bool)
+	for _, g := range results.Groups {
+		key := groupKey(g.Zone, g.Name)
+		if _, seen := applied[key]; !seen {
+			applied[key] = true
+		}
+		if g.Status != GroupApplied {
+			applied[key] = false
+		}
+	}
+	return applied
+}
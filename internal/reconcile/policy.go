@@ -0,0 +1,190 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPolicyViolation is returned when a plan breaks a policy rule, the plan
+// is not executed
+var ErrPolicyViolation = errors.New("plan violates policy")
+
+// Addresses a policy rule can require of matching A and AAAA records
+const (
+	AddressPrivate = "private"
+	AddressPublic  = "public"
+)
+
+// Policy holds rules every generated plan must satisfy
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule applies to the planned operations matching all of its selectors,
+// an empty selector matches everything. Each check set is enforced.
+type PolicyRule struct {
+	Name string `yaml:"name"`
+
+	// Selectors
+	Zones []string `yaml:"zones"`
+	Names []string `yaml:"names"` // record name globs, relative to the zone, @ for the apex
+	Ops   []string `yaml:"ops"`   // create, update or delete
+	Types []string `yaml:"types"`
+
+	// Checks
+	Deny      bool          `yaml:"deny"`      // every matching operation is a violation
+	MaxOps    int           `yaml:"maxOps"`    // matching operations allowed in a plan, unlimited if zero
+	MinTTL    time.Duration `yaml:"minTTL"`    // created and updated records with an explicit ttl must have at least this
+	Addresses string        `yaml:"addresses"` // private or public, the addresses created and updated records may point to
+}
+
+// Violation is a planned operation, or a plan, breaking a policy rule
+type Violation struct {
+	Rule    string `json:"rule"`
+	Op      string `json:"op,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+}
+
+// LoadPolicy reads a policy file in yaml or json
+func LoadPolicy(file string) (Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Policy{}, err
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses policy rules in yaml or json
+func ParsePolicy(data []byte) (Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return Policy{}, fmt.Errorf("parse policy: %w", err)
+	}
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return Policy{}, fmt.Errorf("policy rule %d has no name", i+1)
+		}
+		for _, pattern := range rule.Names {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return Policy{}, fmt.Errorf("policy rule %s name pattern %q is invalid: %w", rule.Name, pattern, err)
+			}
+		}
+		for _, op := range rule.Ops {
+			switch op {
+			case "create", "update", "delete":
+			default:
+				return Policy{}, fmt.Errorf("policy rule %s op %q is invalid, use create, update or delete", rule.Name, op)
+			}
+		}
+		switch rule.Addresses {
+		case "", AddressPrivate, AddressPublic:
+		default:
+			return Policy{}, fmt.Errorf("policy rule %s addresses %q is invalid, use %s or %s", rule.Name, rule.Addresses, AddressPrivate, AddressPublic)
+		}
+		if !rule.Deny && rule.MaxOps <= 0 && rule.MinTTL <= 0 && rule.Addresses == "" {
+			return Policy{}, fmt.Errorf("policy rule %s checks nothing, set deny, maxOps, minTTL or addresses", rule.Name)
+		}
+	}
+	return p, nil
+}
+
+// Evaluate returns every violation of the rules by plan, in rule order
+func (p Policy) Evaluate(plan Plan) []Violation {
+	type planned struct {
+		op     string
+		record provider.Record
+	}
+	ops := make([]planned, 0, len(plan.Create)+len(plan.Update)+len(plan.Delete))
+	for _, r := range plan.Create {
+		ops = append(ops, planned{"create", r})
+	}
+	for _, r := range plan.Update {
+		ops = append(ops, planned{"update", r})
+	}
+	for _, r := range plan.Delete {
+		ops = append(ops, planned{"delete", r})
+	}
+
+	violations := []Violation{}
+	for _, rule := range p.Rules {
+		matched := 0
+		for _, o := range ops {
+			if !rule.matches(o.op, o.record) {
+				continue
+			}
+			matched++
+			violation := func(msg string) {
+				violations = append(violations, Violation{
+					Rule:    rule.Name,
+					Op:      o.op,
+					Zone:    o.record.Zone,
+					Name:    o.record.Name,
+					Type:    o.record.Type,
+					Message: msg,
+				})
+			}
+			if rule.Deny {
+				violation(o.op + " denied")
+			}
+			if o.op == "delete" {
+				continue
+			}
+			// Ttls under a second are written as the provider's automatic ttl
+			if rule.MinTTL > 0 && o.record.TTL >= time.Second && o.record.TTL < rule.MinTTL {
+				violation(fmt.Sprintf("ttl %s below %s", o.record.TTL, rule.MinTTL))
+			}
+			if rule.Addresses != "" && (o.record.Type == "A" || o.record.Type == "AAAA") &&
+				isPrivateAddress(o.record.Data) != (rule.Addresses == AddressPrivate) {
+				violation(fmt.Sprintf("%s address %s not allowed", otherAddresses(rule.Addresses), o.record.Data))
+			}
+		}
+		if rule.MaxOps > 0 && matched > rule.MaxOps {
+			violations = append(violations, Violation{
+				Rule:    rule.Name,
+				Message: fmt.Sprintf("%d operations exceed the limit of %d", matched, rule.MaxOps),
+			})
+		}
+	}
+	return violations
+}
+
+// otherAddresses names the kind of address a rule requiring addresses refuses
+func otherAddresses(addresses string) string {
+	if addresses == AddressPrivate {
+		return AddressPublic
+	}
+	return AddressPrivate
+}
+
+func (r PolicyRule) matches(op string, record provider.Record) bool {
+	if len(r.Ops) > 0 && !slices.Contains(r.Ops, op) {
+		return false
+	}
+	if len(r.Types) > 0 && !slices.ContainsFunc(r.Types, func(t string) bool { return strings.EqualFold(t, record.Type) }) {
+		return false
+	}
+	if len(r.Zones) > 0 && !slices.Contains(r.Zones, record.Zone) {
+		return false
+	}
+	if len(r.Names) > 0 {
+		name := getRecordName(record.Name, record.Zone)
+		if !slices.ContainsFunc(r.Names, func(pattern string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		rules   int
+		wantErr bool
+	}{
+		{name: "empty", data: ``},
+		{
+			name: "yaml",
+			data: `
+rules:
+  - name: no-deletes
+    zones: [example.com]
+    ops: [delete]
+    deny: true
+  - name: ttl
+    minTTL: 5m
+`,
+			rules: 2,
+		},
+		{name: "json", data: `{"rules": [{"name": "public", "addresses": "public"}]}`, rules: 1},
+		{name: "missing name", data: `rules: [{deny: true}]`, wantErr: true},
+		{name: "no checks", data: `rules: [{name: nothing, zones: [example.com]}]`, wantErr: true},
+		{name: "unknown op", data: `rules: [{name: op, ops: [remove], deny: true}]`, wantErr: true},
+		{name: "unknown addresses", data: `rules: [{name: addr, addresses: internal}]`, wantErr: true},
+		{name: "invalid pattern", data: `rules: [{name: glob, names: ["[a"], deny: true}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePolicy([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(p.Rules) != tt.rules {
+				t.Errorf("ParsePolicy() rules = %d, want %d", len(p.Rules), tt.rules)
+			}
+		})
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	plan := Plan{
+		Create: []provider.Record{
+			{Name: "app", Type: "A", Data: "10.0.0.1", TTL: time.Minute, Zone: "example.com"},
+			{Name: "web", Type: "A", Data: "93.184.216.34", TTL: time.Duration(defaultTTL), Zone: "internal.example"},
+			{Name: "web", Type: "TXT", Data: HeritageData("test-owner"), Zone: "internal.example"},
+		},
+		Update: []provider.Record{
+			{Name: "@", Type: "CNAME", Data: "backend.example.net", TTL: time.Hour, Zone: "example.com"},
+		},
+		Delete: []provider.Record{
+			{ID: "1", Name: "old.example.com", Type: "A", Data: "10.0.0.9", Zone: "example.com"},
+			{ID: "2", Name: "old.example.com", Type: "TXT", Data: HeritageData("test-owner"), Zone: "example.com"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		rule PolicyRule
+		want []Violation
+	}{
+		{
+			name: "deny deletes in zone",
+			rule: PolicyRule{Name: "keep", Zones: []string{"example.com"}, Ops: []string{"delete"}, Types: []string{"a"}, Deny: true},
+			want: []Violation{{Rule: "keep", Op: "delete", Zone: "example.com", Name: "old.example.com", Type: "A", Message: "delete denied"}},
+		},
+		{
+			name: "deny matches relative names",
+			rule: PolicyRule{Name: "old", Names: []string{"ol*"}, Types: []string{"TXT"}, Deny: true},
+			want: []Violation{{Rule: "old", Op: "delete", Zone: "example.com", Name: "old.example.com", Type: "TXT", Message: "delete denied"}},
+		},
+		{
+			name: "minimum ttl skips automatic ttl",
+			rule: PolicyRule{Name: "ttl", MinTTL: 5 * time.Minute},
+			want: []Violation{{Rule: "ttl", Op: "create", Zone: "example.com", Name: "app", Type: "A", Message: "ttl 1m0s below 5m0s"}},
+		},
+		{
+			name: "private addresses only",
+			rule: PolicyRule{Name: "private", Zones: []string{"internal.example"}, Addresses: AddressPrivate},
+			want: []Violation{{Rule: "private", Op: "create", Zone: "internal.example", Name: "web", Type: "A", Message: "public address 93.184.216.34 not allowed"}},
+		},
+		{
+			name: "public addresses ignore cnames",
+			rule: PolicyRule{Name: "public", Zones: []string{"example.com"}, Addresses: AddressPublic},
+			want: []Violation{{Rule: "public", Op: "create", Zone: "example.com", Name: "app", Type: "A", Message: "private address 10.0.0.1 not allowed"}},
+		},
+		{
+			name: "max ops",
+			rule: PolicyRule{Name: "small", Ops: []string{"create", "delete"}, MaxOps: 4},
+			want: []Violation{{Rule: "small", Message: "5 operations exceed the limit of 4"}},
+		},
+		{
+			name: "within every check",
+			rule: PolicyRule{Name: "fine", Zones: []string{"example.org"}, Deny: true},
+			want: []Violation{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Policy{Rules: []PolicyRule{tt.rule}}.Evaluate(plan)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyBlocksExecution(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	engine.SetPolicy(Policy{Rules: []PolicyRule{{Name: "internal", Addresses: AddressPrivate}}})
+
+	domains := []source.DomainConfig{
+		{Host: "a.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "b.example.com", Upstream: "93.184.216.34:8080"},
+	}
+	_, err := engine.Reconcile(context.Background(), domains)
+	if !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("Expected policy violation, got %v", err)
+	}
+	if len(dp.ops) != 0 {
+		t.Errorf("Expected no provider operations, got %v", dp.ops)
+	}
+	if len(stateManager.state.Domains) != 0 {
+		t.Errorf("Expected state not to be saved, got %v", stateManager.state.Domains)
+	}
+	if v := engine.LastPlan().Violations; len(v) != 1 || v[0].Name != "b" {
+		t.Errorf("Expected a violation for b, got %+v", v)
+	}
+
+	// Once the host is gone from caddy the plan is executed
+	if _, err := engine.Reconcile(context.Background(), domains[:1]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(stateManager.state.Domains) != 1 {
+		t.Errorf("Expected 1 host in state, got %d", len(stateManager.state.Domains))
+	}
+}
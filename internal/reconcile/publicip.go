@@ -0,0 +1,146 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/publicip"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+const (
+	ReasonDualStack   = "public IPv6 published besides the IPv4"
+	ReasonIPv6Removed = "public IPv6 no longer published"
+)
+
+func reasonIPv6Changed(from, to string) string {
+	return fmt.Sprintf("public IPv6 changed from %s to %s", from, to)
+}
+
+// AddressDetector detects the public addresses of this machine
+type AddressDetector interface {
+	Detect(ctx context.Context) (publicip.Addresses, error)
+}
+
+// SetAddressDetector publishes the public addresses d detects for every host
+// instead of its caddy upstream
+func (e *engine) SetAddressDetector(d AddressDetector) {
+	e.addresses = d
+}
+
+// withPublicAddresses points every host at the detected public IPv4, or the
+// IPv6 without one. With publicIP.dualStack a host also gets the IPv6 as an
+// AAAA record besides its A record, and a change of either address is a
+// change of every host.
+func (e *engine) withPublicAddresses(ctx context.Context, domains []source.DomainConfig) ([]source.DomainConfig, error) {
+	if e.addresses == nil {
+		return domains, nil
+	}
+	addrs, err := e.addresses.Detect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	upstream, ipv6 := addrs.IPv4, ""
+	if upstream == "" {
+		upstream = addrs.IPv6
+	} else if e.cfg.PublicIP.DualStack {
+		ipv6 = addrs.IPv6
+	}
+	slog.DebugContext(ctx, "Publishing public addresses", "ipv4", addrs.IPv4, "ipv6", addrs.IPv6, "dual_stack", e.cfg.PublicIP.DualStack)
+
+	published := make([]source.DomainConfig, len(domains))
+	for i, d := range domains {
+		d.Upstream, d.Port, d.IPv6, d.Alternates = upstream, 0, ipv6, nil
+		published[i] = d
+	}
+	return published, nil
+}
+
+// companionRecord returns the AAAA record published besides main for a host
+// with ipv6, false if there is none. Only an A record gets one, a CNAME
+// cannot share its name and an AAAA already holds the address.
+func companionRecord(main provider.Record, ipv6 string) (provider.Record, bool) {
+	if ipv6 == "" || main.Type != "A" {
+		return provider.Record{}, false
+	}
+	companion := main
+	companion.ID = ""
+	companion.Type = "AAAA"
+	companion.Data = ipv6
+	return companion, true
+}
+
+// planCompanions plans the AAAA records of the added hosts of zone the plan
+// brings in line, creating, updating or removing them as their IPv6 changed.
+// The main record settles ownership of the name, so an AAAA record at it is
+// taken as the host's.
+func (e *engine) planCompanions(plan *Plan, index *zoneIndex, zone string, changes state.StateChanges) {
+	for _, domain := range changes.Added {
+		if !e.inZone(domain.Host, zone) {
+			continue
+		}
+		main, applied := plan.Applies[domain.Host]
+		if !applied {
+			continue
+		}
+		name := getRecordName(domain.Host, zone)
+		prev, readdressed := changes.PreviousIPv6[domain.Host]
+
+		desired, ok := companionRecord(main, domain.IPv6)
+		if !ok {
+			// An AAAA main record holding the address stays
+			if readdressed && !(main.Type == "AAAA" && main.Data == prev) {
+				e.planCompanionDelete(plan, index, name, prev, ReasonIPv6Removed)
+			}
+			continue
+		}
+
+		// Records already replaced for the host are not reused
+		existing := slices.DeleteFunc(slices.Clone(index.lookup(name, "AAAA")), func(r provider.Record) bool {
+			return slices.Contains(plan.Delete, r)
+		})
+		matched := slices.IndexFunc(existing, func(r provider.Record) bool {
+			return r.Data == desired.Data
+		})
+		if matched >= 0 && e.matchesAttributes(domain.Host, zone, existing[matched]) {
+			plan.keep(existing[matched])
+			continue
+		}
+
+		reason := ReasonDualStack
+		if readdressed && prev != "" {
+			reason = reasonIPv6Changed(prev, desired.Data)
+		}
+		stale := max(matched, 0)
+		if len(existing) > 0 && existing[stale].ID != "" {
+			plan.addUpdate(desired, existing[stale], reason)
+			e.metrics.IncDNSOperation("update", zone, desired.Type)
+			continue
+		}
+		for _, r := range existing {
+			plan.addReplace(r, ReasonDataMismatch)
+			e.metrics.IncDNSOperation("delete", zone, r.Type)
+		}
+		plan.addCreate(desired, reason)
+		e.metrics.IncDNSOperation("create", zone, desired.Type)
+	}
+}
+
+// planCompanionDelete plans deleting the AAAA records at name holding data,
+// the IPv6 last published for a host
+func (e *engine) planCompanionDelete(plan *Plan, index *zoneIndex, name, data, reason string) {
+	if data == "" {
+		return
+	}
+	for _, r := range index.lookup(name, "AAAA") {
+		if r.Data != data || slices.Contains(plan.Delete, r) {
+			continue
+		}
+		plan.addDelete(r, reason)
+		e.metrics.IncDNSOperation("delete", r.Zone, r.Type)
+	}
+}
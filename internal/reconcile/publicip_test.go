@@ -0,0 +1,109 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/publicip"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+type staticDetector struct {
+	addrs publicip.Addresses
+}
+
+func (d *staticDetector) Detect(ctx context.Context) (publicip.Addresses, error) {
+	return d.addrs, nil
+}
+
+func TestPublicIPDualStack(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+		PublicIP:  config.PublicIP{Enabled: true, DualStack: true},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	detector := &staticDetector{addrs: publicip.Addresses{IPv4: "203.0.113.7", IPv6: "2001:db8::7"}}
+	engine.SetAddressDetector(detector)
+	ctx := context.Background()
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := map[string]string{}
+	for _, r := range dp.created {
+		data[r.Type] = r.Data
+	}
+	if len(dp.created) != 3 || data["A"] != "203.0.113.7" || data["AAAA"] != "2001:db8::7" || data["TXT"] == "" {
+		t.Fatalf("Expected the A, AAAA and TXT records of the host created, got %+v", dp.created)
+	}
+	if d := stateManager.state.Domains["app.example.com"]; d.ServerName != "203.0.113.7" || d.IPv6 != "2001:db8::7" {
+		t.Errorf("Expected both addresses in state, got %+v", d)
+	}
+
+	// The zone now holds what was created
+	for i, r := range dp.created {
+		r.ID = fmt.Sprintf("id-%d", i)
+		dp.records["example.com"] = append(dp.records["example.com"], r)
+	}
+	dp.created = nil
+
+	// Only the IPv6 changed
+	detector.addrs.IPv6 = "2001:db8::8"
+	if _, err := engine.Reconcile(ctx, domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dp.created) != 0 || len(dp.deleted) != 0 || len(dp.updated) != 1 ||
+		dp.updated[0].Type != "AAAA" || dp.updated[0].Data != "2001:db8::8" {
+		t.Fatalf("Expected only the AAAA record updated, got created %+v updated %+v deleted %+v", dp.created, dp.updated, dp.deleted)
+	}
+	if ex := engine.LastPlan().Explain; len(ex) != 1 || ex[0].Reason != reasonIPv6Changed("2001:db8::7", "2001:db8::8") {
+		t.Errorf("Expected the update explained by the IPv6 change, got %+v", ex)
+	}
+	for i, r := range dp.records["example.com"] {
+		if r.Type == "AAAA" {
+			dp.records["example.com"][i].Data = "2001:db8::8"
+		}
+	}
+	dp.updated = nil
+
+	// Removed hosts lose both address records
+	if _, err := engine.Reconcile(ctx, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	deleted := map[string]bool{}
+	for _, r := range dp.deleted {
+		deleted[r.Type] = true
+	}
+	if len(dp.deleted) != 3 || !deleted["A"] || !deleted["AAAA"] || !deleted["TXT"] {
+		t.Errorf("Expected the A, AAAA and TXT records deleted, got %+v", dp.deleted)
+	}
+}
+
+func TestPublicIPSingleStack(t *testing.T) {
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}},
+		PublicIP:  config.PublicIP{Enabled: true},
+	}
+	stateManager := &MockStateManager{state: state.State{Domains: map[string]state.DomainState{}}}
+	dp := &MockProvider{records: map[string][]provider.Record{"example.com": {}}}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	engine.SetAddressDetector(&staticDetector{addrs: publicip.Addresses{IPv6: "2001:db8::7"}})
+
+	domains := []source.DomainConfig{{Host: "app.example.com", Upstream: "10.0.0.1:8080"}}
+	if _, err := engine.Reconcile(context.Background(), domains); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(dp.created) != 2 || dp.created[0].Type != "AAAA" || dp.created[0].Data != "2001:db8::7" {
+		t.Errorf("Expected the IPv6 published without an IPv4, got %+v", dp.created)
+	}
+}
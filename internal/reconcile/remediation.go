@@ -0,0 +1,85 @@
+package reconcile
+
+import (
+	"context"
+	"sort"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+)
+
+// FailedHost is a host whose latest syncs failed, with what to do about it
+type FailedHost struct {
+	Host        string `json:"host"`
+	Op          string `json:"op,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Class       string `json:"class"`
+	Attempts    int    `json:"attempts"`
+	LastError   string `json:"lastError"`
+	Last        int64  `json:"last,omitempty"`
+	Skipped     bool   `json:"skipped"`
+	Since       int64  `json:"since,omitempty"`
+	Remediation string `json:"remediation"`
+}
+
+// Failures returns the hosts that failed their latest syncs, sorted by host
+func (e *engine) Failures(ctx context.Context) ([]FailedHost, error) {
+	failures, err := e.stateManager.LoadFailures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	failed := make([]FailedHost, 0, len(failures))
+	for host, f := range failures {
+		class := f.Class
+		if class == "" {
+			class = provider.ClassUnknown
+		}
+		failed = append(failed, FailedHost{
+			Host:        host,
+			Op:          f.Op,
+			Type:        f.Type,
+			Class:       class,
+			Attempts:    f.Count,
+			LastError:   f.LastError,
+			Last:        f.Last,
+			Skipped:     f.Skipped,
+			Since:       f.Since,
+			Remediation: Remediation(e.cfg.DNS.Provider, f.Op, class),
+		})
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Host < failed[j].Host })
+	return failed, nil
+}
+
+// Remediation suggests how to fix a failed operation of a provider, from
+// the class of its error
+func Remediation(providerName, op, class string) string {
+	switch class {
+	case provider.ClassPermission:
+		switch providerName {
+		case "", config.ProviderCloudflare:
+			return "token lacks Zone.DNS edit permission for the zone, or the zone is not among its zone resources"
+		case config.ProviderPorkbun:
+			return "api access is not enabled for the domain, turn it on in the porkbun domain management, or the api key is wrong"
+		case config.ProviderNameSilo:
+			return "api key is invalid, belongs to a sub account, or does not allow the ip this instance calls from"
+		case config.ProviderDesec:
+			return "token is invalid or restricted by a token policy that does not allow writing the rrset"
+		case config.ProviderRFC2136:
+			return "tsig key is unknown to the server, its secret or algorithm differ, or allow-transfer and update-policy of the zone do not grant it"
+		}
+		return "provider credentials are invalid or lack permission to edit the records of the zone"
+	case provider.ClassRateLimited:
+		return "provider rate limit reached, lower reconcile.workers, set reconcile.maxOpsPerRun or lengthen syncInterval"
+	case provider.ClassNotFound:
+		if op == "update" {
+			return "record was deleted at the provider, it is recreated once the host is synced with the zones listed"
+		}
+		return "zone is not found at the provider, check dns.zones against the zones the credentials can see"
+	case provider.ClassExists:
+		return "a record of the same type but different data holds the name, remove it or set reconcile.unmanagedPolicy to takeover"
+	case provider.ClassConflict:
+		return "a record of another type, e.g. a CNAME, holds the name, remove it or set reconcile.unmanagedPolicy to takeover, then clear the host with DELETE /skipped/{host}"
+	}
+	return "unclassified provider error, check lastError and the provider status, the host is retried every sync"
+}
@@ -0,0 +1,52 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+)
+
+func TestFailures(t *testing.T) {
+	// Failures are tracked without skipAfterFailures, nothing is skipped
+	cfg := &config.Config{
+		Reconcile: config.Reconcile{Owner: "test-owner"},
+		DNS:       config.DNS{Zones: []string{"example.com"}, Provider: config.ProviderCloudflare},
+	}
+	stateManager := &MockStateManager{}
+	dp := &MockProvider{
+		records:       map[string][]provider.Record{"example.com": {}},
+		createErr:     fmt.Errorf("%w: 403 forbidden", provider.ErrPermission),
+		createErrName: "bad",
+	}
+	engine := NewEngine(stateManager, dp, cfg, metrics.New(false))
+	domains := []source.DomainConfig{
+		{Host: "bad.example.com", Upstream: "10.0.0.1:8080"},
+		{Host: "good.example.com", Upstream: "10.0.0.2:8080"},
+	}
+	ctx := context.Background()
+	for range 2 {
+		if _, err := engine.Reconcile(ctx, domains); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	failures, err := engine.Failures(ctx)
+	if err != nil {
+		t.Fatalf("Failures failed: %v", err)
+	}
+	if len(failures) != 1 {
+		t.Fatalf("Expected the failing host only, got %+v", failures)
+	}
+	f := failures[0]
+	if f.Host != "bad.example.com" || f.Op != "create" || f.Type != "A" || f.Class != provider.ClassPermission || f.Attempts != 2 || f.Skipped {
+		t.Errorf("Unexpected failure %+v", f)
+	}
+	if f.Remediation != Remediation(config.ProviderCloudflare, "create", provider.ClassPermission) || f.Remediation == Remediation(config.ProviderHTTP, "create", provider.ClassPermission) {
+		t.Errorf("Expected the cloudflare permission remediation, got %q", f.Remediation)
+	}
+}
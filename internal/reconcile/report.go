@@ -0,0 +1,114 @@
+package reconcile
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// ReportMarker starts every rendered report, so a pull request comment
+// holding an earlier report can be found and replaced
+const ReportMarker = "<!-- caddy-dns-sync plan -->"
+
+// Report is a plan rendered for review, e.g. as a pull request comment in a
+// GitOps workflow
+type Report struct {
+	Title      string
+	Changes    []Explanation
+	Violations []Violation
+	Conflicts  []OwnershipConflict
+	Deferred   int // operations left for the next run
+}
+
+// WriteMarkdown renders the report as markdown: a table of changes by zone,
+// a diff of the records each zone gains and loses, and any policy violations
+// and ownership conflicts
+func (r Report) WriteMarkdown(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, ReportMarker)
+	title := r.Title
+	if title == "" {
+		title = "DNS plan"
+	}
+	fmt.Fprintf(b, "### %s\n\n", title)
+
+	if len(r.Changes) == 0 {
+		fmt.Fprintln(b, "No changes, the zones match caddy.")
+	}
+	zones := []string{}
+	byZone := make(map[string][]Explanation)
+	for _, ex := range r.Changes {
+		if _, seen := byZone[ex.Zone]; !seen {
+			zones = append(zones, ex.Zone)
+		}
+		byZone[ex.Zone] = append(byZone[ex.Zone], ex)
+	}
+	slices.Sort(zones)
+
+	if len(zones) > 0 {
+		fmt.Fprintln(b, "| zone | create | update | delete |")
+		fmt.Fprintln(b, "| --- | ---: | ---: | ---: |")
+		for _, zone := range zones {
+			counts := make(map[string]int)
+			for _, ex := range byZone[zone] {
+				counts[ex.Op]++
+			}
+			fmt.Fprintf(b, "| %s | %d | %d | %d |\n", markdownCell(zone), counts["create"], counts["update"], counts["delete"])
+		}
+		fmt.Fprintln(b)
+
+		fmt.Fprintln(b, "```diff")
+		for _, zone := range zones {
+			fmt.Fprintf(b, "@@ %s @@\n", zone)
+			for _, ex := range byZone[zone] {
+				switch ex.Op {
+				case "create":
+					fmt.Fprintf(b, "+ %s\n", diffLine(ex, ex.Data))
+				case "delete":
+					fmt.Fprintf(b, "- %s\n", diffLine(ex, ex.Data))
+				case "update":
+					fmt.Fprintf(b, "- %s\n", diffLine(Explanation{Name: ex.Name, Type: ex.Type}, ex.Previous))
+					fmt.Fprintf(b, "+ %s\n", diffLine(ex, ex.Data))
+				}
+			}
+		}
+		fmt.Fprintln(b, "```")
+	}
+	if r.Deferred > 0 {
+		fmt.Fprintf(b, "\n%d operations are left for the next run by `reconcile.maxOpsPerRun`.\n", r.Deferred)
+	}
+
+	if len(r.Violations) > 0 {
+		fmt.Fprintln(b, "\n**Policy violations**, the plan is not applied:")
+		for _, v := range r.Violations {
+			if v.Op == "" {
+				fmt.Fprintf(b, "- `%s`: %s\n", v.Rule, v.Message)
+				continue
+			}
+			fmt.Fprintf(b, "- `%s`: %s %s %s in %s, %s\n", v.Rule, v.Op, v.Type, v.Name, v.Zone, v.Message)
+		}
+	}
+	if len(r.Conflicts) > 0 {
+		fmt.Fprintln(b, "\n**Ownership conflicts:**")
+		for _, c := range r.Conflicts {
+			fmt.Fprintf(b, "- %s in %s is owned by `%s`\n", c.Name, c.Zone, c.Owner)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// diffLine renders a record as a diff line, with the reason as a comment
+func diffLine(ex Explanation, data string) string {
+	line := fmt.Sprintf("%s %s %s", ex.Name, ex.Type, data)
+	if ex.Reason != "" {
+		line += "  # " + ex.Reason
+	}
+	return line
+}
+
+// markdownCell escapes pipes, which would end a table cell
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
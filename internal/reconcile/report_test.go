@@ -0,0 +1,40 @@
+package reconcile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReportMarkdown(t *testing.T) {
+	report := Report{
+		Changes: []Explanation{
+			{Op: "create", Zone: "example.com", Name: "app", Type: "A", Data: "10.0.0.1", Reason: ReasonHostAdded},
+			{Op: "update", Zone: "example.com", Name: "api", Type: "A", Data: "10.0.0.2", Previous: "10.0.0.1", Reason: reasonUpstreamChanged("10.0.0.1:80", "10.0.0.2:80")},
+			{Op: "delete", Zone: "example.net", Name: "old", Type: "CNAME", Data: "lb.example.net", Reason: ReasonHostRemoved},
+		},
+		Violations: []Violation{{Rule: "maxDeletes", Message: "too many deletes"}},
+	}
+	b := &strings.Builder{}
+	if err := report.WriteMarkdown(b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		ReportMarker,
+		"| example.com | 1 | 1 | 0 |",
+		"| example.net | 0 | 0 | 1 |",
+		"@@ example.com @@\n+ app A 10.0.0.1  # host added in Caddy\n- api A 10.0.0.1\n+ api A 10.0.0.2  # upstream changed",
+		"- old CNAME lb.example.net  # host removed",
+		"- `maxDeletes`: too many deletes",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, got)
+		}
+	}
+
+	b.Reset()
+	Report{}.WriteMarkdown(b)
+	if !strings.Contains(b.String(), "No changes") || strings.Contains(b.String(), "```") {
+		t.Errorf("Expected an empty plan without a diff, got:\n%s", b.String())
+	}
+}
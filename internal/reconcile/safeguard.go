@@ -0,0 +1,38 @@
+package reconcile
+
+import (
+	"context"
+	"log/slog"
+)
+
+// isDryRun reports whether the current run only simulates its writes, as
+// configured or held back by the startup safeguard
+func (e *engine) isDryRun() bool {
+	return e.dryRun || e.safeguard.Load()
+}
+
+// startupSafeguard reports whether plans are still dry run after start,
+// before reconcile.startupDelay has passed since the first run or until
+// reconcile.initialDryRuns plans were, so a fresh or misconfigured instance
+// cannot rewrite the zones before its plans were reviewed
+func (e *engine) startupSafeguard() bool {
+	e.startOnce.Do(func() { e.started = e.now() })
+	if e.now().Before(e.started.Add(e.cfg.Reconcile.StartupDelay)) {
+		return true
+	}
+	return e.heldRuns.Load() < int64(e.cfg.Reconcile.InitialDryRuns)
+}
+
+// holdPlan counts a plan dry run by the startup safeguard and warns that it
+// waits for review
+func (e *engine) holdPlan(ctx context.Context, plan Plan, results *Results) {
+	results.Held = true
+	if len(plan.Create)+len(plan.Update)+len(plan.Delete) == 0 {
+		return
+	}
+	held := e.heldRuns.Add(1)
+	slog.WarnContext(ctx, "Startup safeguard dry ran the plan, review it at /plan before writes begin",
+		"create", len(plan.Create), "update", len(plan.Update), "delete", len(plan.Delete),
+		"dry_runs", held, "initial_dry_runs", e.cfg.Reconcile.InitialDryRuns,
+		"writes_from", e.started.Add(e.cfg.Reconcile.StartupDelay))
+}
@@ -0,0 +1,184 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+var (
+	// ErrInvalidScope is returned for a scope outside the configured zones
+	ErrInvalidScope = errors.New("invalid scope")
+	// ErrScopeNoMatch is returned when a scoped reconcile matches no known host
+	ErrScopeNoMatch = errors.New("scope matches no host in caddy or state")
+)
+
+// Scope limits a reconcile to a single host or the hosts of one zone. Hosts in
+// scope are planned even when unchanged, so their records are checked
+// against the live zone, hosts outside it keep their previous state.
+type Scope struct {
+	Host string `json:"host,omitempty"`
+	Zone string `json:"zone,omitempty"`
+
+	partition *Partition
+}
+
+// IsZero reports whether the scope covers every host
+func (s Scope) IsZero() bool {
+	return s.Host == "" && s.Zone == "" && s.partition == nil
+}
+
+// covers reports whether a run of the scope counts the records of zone
+func (s Scope) covers(zone string) bool {
+	return s.partition == nil || s.partition.has(zone)
+}
+
+// Partition is a set of zones synced on its own schedule, e.g. a lab zone
+// every minute and a stable zone hourly. With Rest it holds every zone but
+// the listed ones, the zones without a schedule of their own.
+type Partition struct {
+	Zones []string
+	Rest  bool
+}
+
+func (p Partition) has(zone string) bool {
+	return slices.Contains(p.Zones, zone) != p.Rest
+}
+
+// ReconcilePartition reconciles only the hosts of the zones of a partition,
+// planned like Reconcile when they changed since its last run. Hosts of other
+// zones keep the state the runs of their own partition left.
+func (e *engine) ReconcilePartition(ctx context.Context, domains []source.DomainConfig, p Partition) (Results, error) {
+	p, err := e.normalizePartition(p)
+	if err != nil {
+		return Results{}, err
+	}
+	return e.reconcile(ctx, domains, Scope{partition: &p})
+}
+
+// normalizePartition normalizes the zones of p, each must be configured
+func (e *engine) normalizePartition(p Partition) (Partition, error) {
+	zones := make([]string, 0, len(p.Zones))
+	for _, zone := range p.Zones {
+		normalized, err := normalizeHost(zone)
+		if err != nil {
+			return p, fmt.Errorf("%w: %w", ErrInvalidScope, err)
+		}
+		if !slices.Contains(e.zones, normalized) {
+			return p, fmt.Errorf("%w: zone %s is not configured", ErrInvalidScope, normalized)
+		}
+		zones = append(zones, normalized)
+	}
+	p.Zones = zones
+	return p, nil
+}
+
+func (s Scope) contains(host string) bool {
+	return (s.Host == "" || host == s.Host) && (s.Zone == "" || belongsToZone(host, s.Zone))
+}
+
+// ReconcileScope reconciles only the hosts in scope, a zero scope reconciles
+// every host like Reconcile
+func (e *engine) ReconcileScope(ctx context.Context, domains []source.DomainConfig, scope Scope) (Results, error) {
+	scope, err := e.normalizeScope(scope)
+	if err != nil {
+		return Results{}, err
+	}
+	return e.reconcile(ctx, domains, scope)
+}
+
+// normalizeScope converts the scope to the normalized names hosts and zones
+// are compared by, rejecting zones and hosts outside the configured zones
+func (e *engine) normalizeScope(scope Scope) (Scope, error) {
+	if scope.Host != "" {
+		host, err := normalizeHost(scope.Host)
+		if err != nil {
+			return scope, fmt.Errorf("%w: %w", ErrInvalidScope, err)
+		}
+		if !e.inAnyZone(host) {
+			return scope, fmt.Errorf("%w: host %s is in no configured zone", ErrInvalidScope, host)
+		}
+		scope.Host = host
+	}
+	if scope.Zone != "" {
+		zone, err := normalizeHost(scope.Zone)
+		if err != nil {
+			return scope, fmt.Errorf("%w: %w", ErrInvalidScope, err)
+		}
+		if !slices.Contains(e.zones, zone) {
+			return scope, fmt.Errorf("%w: zone %s is not configured", ErrInvalidScope, zone)
+		}
+		scope.Zone = zone
+	}
+	return scope, nil
+}
+
+// applyScope restores the previous state of hosts outside scope, returning the
+// hosts in scope still in caddy, which are planned whether changed or not
+func applyScope(scope Scope, current, previous state.State) (map[string]bool, error) {
+	forced := make(map[string]bool)
+	matched := false
+	for host := range previous.Domains {
+		if scope.contains(host) {
+			matched = true
+			continue
+		}
+		current.Domains[host] = previous.Domains[host]
+	}
+	for host := range current.Domains {
+		if !scope.contains(host) {
+			if _, known := previous.Domains[host]; !known {
+				delete(current.Domains, host)
+			}
+			continue
+		}
+		matched = true
+		forced[host] = true
+	}
+	if !matched {
+		return nil, ErrScopeNoMatch
+	}
+	return forced, nil
+}
+
+// applyPartition restores the previous state of hosts outside partition p.
+// Hosts in no zone belong to the rest.
+func (e *engine) applyPartition(p Partition, current, previous state.State) {
+	in := func(host string) bool {
+		zone, _ := e.zoneOf(host)
+		return p.has(zone)
+	}
+	for host := range previous.Domains {
+		if !in(host) {
+			current.Domains[host] = previous.Domains[host]
+		}
+	}
+	for host := range current.Domains {
+		if _, known := previous.Domains[host]; !known && !in(host) {
+			delete(current.Domains, host)
+		}
+	}
+}
+
+// forceHosts adds the unchanged forced hosts to the added hosts of changes,
+// returning those added
+func forceHosts(changes *state.StateChanges, current state.State, forced map[string]bool) map[string]bool {
+	added := make(map[string]bool)
+	for _, d := range changes.Added {
+		added[d.Host] = true
+	}
+	unchanged := make(map[string]bool)
+	for host := range forced {
+		if added[host] {
+			continue
+		}
+		d := current.Domains[host]
+		changes.Added = append(changes.Added, source.DomainConfig{Host: host, Upstream: d.ServerName, IPv6: d.IPv6, Alternates: d.Alternates})
+		unchanged[host] = true
+	}
+	return unchanged
+}
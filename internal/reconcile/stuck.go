@@ -0,0 +1,49 @@
+package reconcile
+
+import (
+	"sort"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// StuckHost is a host seen in caddy whose desired record was never applied at
+// the provider, or differs from the record applied last
+type StuckHost struct {
+	Host        string `json:"host"`
+	LastSeen    int64  `json:"lastSeen"`
+	LastApplied int64  `json:"lastApplied,omitempty"`
+	Desired     string `json:"desired"`           // type and data of the desired main record
+	Applied     string `json:"applied,omitempty"` // type and data of the main record applied last
+}
+
+// Stuck returns the hosts of st whose desired main record does not match what
+// was last applied, sorted by host. Protected hosts and hosts outside the
+// configured zones are left out.
+func (e *engine) Stuck(st state.State) []StuckHost {
+	stuck := []StuckHost{}
+	for host, d := range st.Domains {
+		for _, zone := range e.zones {
+			if !e.inZone(host, zone) || e.isProtected(host) {
+				continue
+			}
+			desired := e.desiredRecord(source.DomainConfig{Host: host, Upstream: d.ServerName, Source: d.Source}, zone)
+			if d.LastApplied != 0 && d.AppliedType == desired.Type && d.AppliedData == desired.Data {
+				break
+			}
+			entry := StuckHost{
+				Host:        host,
+				LastSeen:    d.LastSeen,
+				LastApplied: d.LastApplied,
+				Desired:     desired.Type + " " + desired.Data,
+			}
+			if d.AppliedType != "" {
+				entry.Applied = d.AppliedType + " " + d.AppliedData
+			}
+			stuck = append(stuck, entry)
+			break
+		}
+	}
+	sort.Slice(stuck, func(i, j int) bool { return stuck[i].Host < stuck[j].Host })
+	return stuck
+}
@@ -0,0 +1,151 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/runid"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// tombstoneField is the heritage field holding the unix time the records of
+// a host were deleted, heritage data carrying it no longer confers ownership
+const tombstoneField = "caddy-dns-sync/deleted"
+
+// tombstoneData returns the heritage data left behind for owner when the
+// records of a host are deleted at t
+func tombstoneData(owner string, t time.Time) string {
+	return txtIdentifier(owner) + "," + tombstoneField + "=" + strconv.FormatInt(t.Unix(), 10)
+}
+
+// parseTombstone returns the owner and deletion time of tombstone heritage
+// data, ok is false if data is not a tombstone
+func parseTombstone(data string) (owner string, deleted time.Time, ok bool) {
+	owner, heritage := parseHeritage(data)
+	if !heritage {
+		return "", time.Time{}, false
+	}
+	fields, _ := legacyHeritage(data)
+	for _, field := range strings.Split(fields, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		if key != tombstoneField {
+			continue
+		}
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", time.Time{}, false
+		}
+		return owner, time.Unix(unix, 0), true
+	}
+	return "", time.Time{}, false
+}
+
+// isTombstone reports whether heritage data marks deleted records
+func isTombstone(data string) bool {
+	_, _, ok := parseTombstone(data)
+	return ok
+}
+
+// planTombstone replaces the heritage TXT record of a removed host with a
+// tombstone when keepOwnershipOnDelete is set, otherwise it is deleted
+func (e *engine) planTombstone(plan *Plan, txt provider.Record, reason string) {
+	if !e.cfg.Reconcile.KeepOwnership || txt.ID == "" {
+		plan.addDelete(txt, reason)
+		e.metrics.IncDNSOperation("delete", txt.Zone, "TXT")
+		return
+	}
+	tombstone := txt
+	tombstone.Data = tombstoneData(e.cfg.Reconcile.Owner, e.now())
+	plan.addUpdate(tombstone, txt, reason)
+	e.metrics.IncDNSOperation("update", txt.Zone, "TXT")
+}
+
+// planHeritageCreate plans the heritage TXT record of a host, reusing a
+// tombstone left at its name when the host was removed earlier
+func (e *engine) planHeritageCreate(plan *Plan, idx *zoneIndex, recordName string, txt provider.Record, reason string) {
+	if tombstone, ok := idx.tombstone(recordName); ok && tombstone.ID != "" {
+		plan.addUpdate(txt, tombstone, reason)
+		e.metrics.IncDNSOperation("update", txt.Zone, "TXT")
+		return
+	}
+	plan.addCreate(txt, reason)
+	e.metrics.IncDNSOperation("create", txt.Zone, "TXT")
+}
+
+// CollectTombstones deletes the tombstones of accepted owners older than
+// tombstoneTTL. Nothing is written while paused, outside the write windows,
+// in dry run or in shadow mode. Returns how many tombstones were, or would
+// be, deleted.
+func (e *engine) CollectTombstones(ctx context.Context) (int, error) {
+	ttl := e.cfg.Reconcile.TombstoneTTL
+	if ttl <= 0 {
+		return 0, nil
+	}
+	ctx = runid.WithID(ctx, runid.New())
+	paused, err := e.Paused(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("load paused: %w", err)
+	}
+	if now := e.now().In(e.location); paused || !e.windows.Open(now) {
+		slog.DebugContext(ctx, "Writes held back, skipping tombstone collection", "paused", paused)
+		return 0, nil
+	}
+
+	cutoff := e.now().Add(-ttl)
+	collected := 0
+	entries := []state.AuditEntry{}
+	defer func() {
+		if len(entries) == 0 {
+			return
+		}
+		if err := e.stateManager.AppendAudit(ctx, entries); err != nil {
+			slog.WarnContext(ctx, "Failed to write audit entries", "count", len(entries), "error", err)
+		}
+	}()
+	for _, zone := range e.zones {
+		records, err := e.zoneRecords(ctx, zone)
+		if err != nil {
+			return collected, fmt.Errorf("get records for zone %s: %w", zone, err)
+		}
+		for _, r := range records {
+			if r.Type != "TXT" {
+				continue
+			}
+			owner, deleted, ok := parseTombstone(r.Data)
+			if !ok || !slices.Contains(e.owners, owner) || !deleted.Before(cutoff) {
+				continue
+			}
+			if e.isDryRun() || e.cfg.Reconcile.Shadow {
+				collected++
+				slog.InfoContext(ctx, "Would delete expired tombstone", "name", r.Name, "zone", zone, "deleted", deleted)
+				continue
+			}
+			entry := state.AuditEntry{
+				Time:   e.now().Unix(),
+				Op:     "delete",
+				Zone:   zone,
+				Name:   r.Name,
+				Type:   r.Type,
+				Data:   r.Data,
+				Reason: ReasonTombstone,
+				Result: "success",
+				RunID:  runid.FromContext(ctx),
+			}
+			if err := e.apply(ctx, "delete", r); err != nil {
+				entry.Result, entry.Error = "failure", err.Error()
+				entries = append(entries, entry)
+				return collected, fmt.Errorf("delete tombstone %s: %w", r.Name, err)
+			}
+			entries = append(entries, entry)
+			collected++
+			slog.InfoContext(ctx, "Deleted expired tombstone", "name", r.Name, "zone", zone, "deleted", deleted)
+		}
+	}
+	return collected, nil
+}
@@ -1,13 +1,211 @@
 package reconcile
 
 import (
+	"fmt"
+	"slices"
+	"time"
+
 	"github.com/evanofslack/caddy-dns-sync/internal/provider"
 )
 
 type Plan struct {
-	Create []provider.Record
-	Update []provider.Record
-	Delete []provider.Record
+	RunID      string // id of the run that generated the plan
+	Create     []provider.Record
+	Update     []provider.Record
+	Delete     []provider.Record
+	Groups     []RecordGroup
+	Explain    []Explanation
+	Unmanaged  []provider.Record          // records of removed hosts left in place as not owned
+	Conflicts  []provider.Record          // records not owned blocking added hosts, under the fail policy
+	Contested  []OwnershipConflict        // hosts whose name another owner holds
+	Applies    map[string]provider.Record // desired main record of added hosts the plan brings in line
+	Sources    map[string]string          // source of added hosts by zone and name
+	Moves      []Move                     // hosts moved across zones
+	Deferred   int                        // operations left for the next run by maxOpsPerRun
+	Violations []Violation                // policy rules the plan breaks, it is not executed if any
+	Denied     []Violation                // operations the policy hook denied, their hosts are left out
+	IDs        map[string]string          // provider id of existing records the plan keeps or updates, by idKey
+}
+
+// RecordGroup is a host's main record together with its ownership TXT record,
+// applied as a single unit so a host is never left half managed
+type RecordGroup struct {
+	Op       string
+	Zone     string
+	Name     string
+	Records  []provider.Record
+	Previous []provider.Record // records replaced by an update, used to revert it
+	Replaces bool              // delete of records about to be recreated for the same host
+	After    string            // zone/name whose groups must be applied first, for the old records of a moved host
+}
+
+// inverse returns the operation and record that revert the i-th record of the group
+func (g RecordGroup) inverse(i int) (string, provider.Record) {
+	switch g.Op {
+	case "create":
+		return "delete", g.Records[i]
+	case "update":
+		return "update", g.Previous[i]
+	}
+	return "create", g.Records[i]
+}
+
+// Explanation records why an operation was planned
+type Explanation struct {
+	Op       string `json:"op"`
+	Zone     string `json:"zone"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Data     string `json:"data"`
+	Reason   string `json:"reason"`
+	Previous string `json:"previous,omitempty"` // data an update replaces
+}
+
+const (
+	ReasonHostAdded    = "host added in Caddy"
+	ReasonHostRemoved  = "host removed"
+	ReasonHostExpired  = "host not seen in Caddy within expireAfter"
+	ReasonDataMismatch = "existing record data mismatch"
+	ReasonConflict     = "conflicts with desired CNAME"
+	ReasonTakeover     = "taking over unmanaged record"
+	ReasonRollback     = "rollback after failed run"
+	ReasonHeritage     = "heritage record in outdated format"
+	ReasonOwnership    = "ownership stored in another mode"
+	ReasonTombstone    = "tombstone older than tombstoneTTL"
+	ReasonScoped       = "host checked by scoped sync"
+	ReasonDuplicate    = "duplicate heritage record"
+	ReasonSource       = "host found in another source"
+	ReasonCanonical    = "canonical name of the zone target changed"
+)
+
+func reasonUpstreamChanged(from, to string) string {
+	return fmt.Sprintf("upstream changed from %s to %s", from, to)
+}
+
+func reasonAcceptedOwner(owner string) string {
+	return fmt.Sprintf("owned by accepted owner %s", owner)
+}
+
+func reasonOwnedBy(owner string) string {
+	return fmt.Sprintf("heritage names owner %s", owner)
+}
+
+func reasonTypeChanged(from, to string) string {
+	return fmt.Sprintf("record type changed from %s to %s", from, to)
+}
+
+// attribute notes the source of the host a record is planned for, carried
+// into the audit log
+func (p *Plan) attribute(record provider.Record, src string) {
+	if p.Sources == nil {
+		p.Sources = make(map[string]string)
+	}
+	p.Sources[groupKey(record.Zone, record.Name)] = src
+}
+
+// markApplied notes that once executed the plan leaves host with its desired
+// main record, whether or not it writes anything for it
+func (p *Plan) markApplied(host string, record provider.Record) {
+	if p.Applies == nil {
+		p.Applies = make(map[string]provider.Record)
+	}
+	p.Applies[host] = record
+}
+
+// replaces reports whether the plan deletes r or updates it in place
+func (p *Plan) replaces(r provider.Record) bool {
+	if slices.Contains(p.Delete, r) {
+		return true
+	}
+	for _, g := range p.Groups {
+		if g.Op == "update" && slices.Contains(g.Previous, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// keep remembers the provider id of existing records left in place
+func (p *Plan) keep(records ...provider.Record) {
+	for _, r := range records {
+		if r.ID == "" {
+			continue
+		}
+		if p.IDs == nil {
+			p.IDs = make(map[string]string)
+		}
+		p.IDs[idKey(r)] = r.ID
+	}
+}
+
+func (p *Plan) addCreate(record provider.Record, reason string) {
+	p.Create = append(p.Create, record)
+	p.group("create", record)
+	p.explain("create", record, reason)
+}
+
+// addUpdate plans replacing previous in place, keeping its provider ID
+func (p *Plan) addUpdate(record, previous provider.Record, reason string) {
+	record.ID = previous.ID
+	p.keep(record)
+	p.Update = append(p.Update, record)
+	p.group("update", record)
+	last := &p.Groups[len(p.Groups)-1]
+	last.Previous = append(last.Previous, previous)
+	p.explain("update", record, reason)
+	p.Explain[len(p.Explain)-1].Previous = previous.Data
+}
+
+func (p *Plan) addDelete(record provider.Record, reason string) {
+	p.Delete = append(p.Delete, record)
+	p.group("delete", record)
+	p.explain("delete", record, reason)
+}
+
+// addReplace plans deleting a mismatched record that is recreated for the
+// same host, it always runs before the host's creates
+func (p *Plan) addReplace(record provider.Record, reason string) {
+	p.addDelete(record, reason)
+	p.Groups[len(p.Groups)-1].Replaces = true
+}
+
+// group adds the record to the group for its host, records for a host are
+// always planned consecutively
+func (p *Plan) group(op string, record provider.Record) {
+	if n := len(p.Groups); n > 0 {
+		last := &p.Groups[n-1]
+		if last.Op == op && last.Zone == record.Zone && last.Name == record.Name {
+			last.Records = append(last.Records, record)
+			return
+		}
+	}
+	p.Groups = append(p.Groups, RecordGroup{
+		Op:      op,
+		Zone:    record.Zone,
+		Name:    record.Name,
+		Records: []provider.Record{record},
+	})
+}
+
+func (p *Plan) explain(op string, record provider.Record, reason string) {
+	p.Explain = append(p.Explain, Explanation{
+		Op:     op,
+		Zone:   record.Zone,
+		Name:   record.Name,
+		Type:   record.Type,
+		Data:   record.Data,
+		Reason: reason,
+	})
+}
+
+// Reason returns the planned reason for an operation on a record
+func (p Plan) Reason(op string, record provider.Record) string {
+	for _, e := range p.Explain {
+		if e.Op == op && e.Zone == record.Zone && e.Name == record.Name && e.Type == record.Type && e.Data == record.Data {
+			return e.Reason
+		}
+	}
+	return ""
 }
 
 type Results struct {
@@ -15,10 +213,114 @@ type Results struct {
 	Updated  []provider.Record
 	Deleted  []provider.Record
 	Failures []OperationResult
+	Reverted []OperationResult // operations performed to roll back the run
+	Groups   []GroupResult
+	Paused   bool              // writes were paused, the plan was not executed
+	Held     bool              // the plan was dry run by the startup safeguard
+	Deferred time.Time         // outside the write windows, the plan is deferred until this time
+	Limited  int               // operations left for the next run by maxOpsPerRun
+	IDs      map[string]string // provider id of created records known after the write, by idKey
+}
+
+// setID records the provider id of a created record, unless it is unknown
+func (r *Results) setID(record provider.Record, id string) {
+	if id == "" {
+		return
+	}
+	if r.IDs == nil {
+		r.IDs = make(map[string]string)
+	}
+	r.IDs[idKey(record)] = id
+}
+
+// merge appends the results of another execution
+func (r *Results) merge(other Results) {
+	r.Created = append(r.Created, other.Created...)
+	r.Updated = append(r.Updated, other.Updated...)
+	r.Deleted = append(r.Deleted, other.Deleted...)
+	r.Failures = append(r.Failures, other.Failures...)
+	r.Reverted = append(r.Reverted, other.Reverted...)
+	r.Groups = append(r.Groups, other.Groups...)
+	for key, id := range other.IDs {
+		if r.IDs == nil {
+			r.IDs = make(map[string]string)
+		}
+		r.IDs[key] = id
+	}
+}
+
+const (
+	GroupApplied    = "applied"     // every record in the group succeeded
+	GroupRolledBack = "rolled_back" // a record failed and applied siblings were reverted
+	GroupPartial    = "partial"     // a record failed and reverting siblings also failed
+)
+
+type GroupResult struct {
+	Op     string
+	Zone   string
+	Name   string
+	Status string
 }
 
 type OperationResult struct {
 	Record provider.Record
 	Op     string
 	Error  string
+	Class  string // provider error class, see provider.ErrorClass
+}
+
+const (
+	FilterReasonNoZone     = "no_zone"
+	FilterReasonProtected  = "protected"
+	FilterReasonSkipped    = "skipped"
+	FilterReasonInvalid    = "invalid_name"
+	FilterReasonPrivate    = "private_target"
+	FilterReasonNotAllowed = "not_allowed"
+)
+
+// FilteredHost is a caddy host that was discovered but not synced
+type FilteredHost struct {
+	Host   string `json:"host"`
+	Reason string `json:"reason"`
+}
+
+// SkippedHost is a host that failed too many consecutive syncs and is no
+// longer retried until cleared
+type SkippedHost struct {
+	Host     string `json:"host"`
+	Failures int    `json:"failures"`
+	Reason   string `json:"reason"`
+	Since    int64  `json:"since"`
+}
+
+// conflictFailures reports every conflict of the plan as a failed create
+func (p Plan) conflictFailures() []OperationResult {
+	var failures []OperationResult
+	for _, r := range p.Conflicts {
+		failures = append(failures, OperationResult{
+			Record: r,
+			Op:     "create",
+			Error:  fmt.Sprintf("%s has a %s record not owned by this instance", r.Name, r.Type),
+			Class:  provider.ClassConflict,
+		})
+	}
+	return failures
+}
+
+// OwnershipConflict is a host served by this instance's caddy whose name has
+// a heritage record of an owner this instance does not accept, which usually
+// means two instances are syncing the same name
+type OwnershipConflict struct {
+	Zone  string `json:"zone"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+}
+
+// Summary counts the hosts and records seen during the latest reconcile
+type Summary struct {
+	Discovered int            `json:"discovered"`         // caddy hosts
+	Filtered   int            `json:"filtered"`           // caddy hosts not synced
+	Managed    map[string]int `json:"managed"`            // owned records by zone
+	Unmanaged  map[string]int `json:"unmanagedSkipped"`   // records not deleted as not owned, by zone
+	Contested  map[string]int `json:"ownershipConflicts"` // hosts whose name another owner holds, by zone
 }
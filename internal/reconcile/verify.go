@@ -0,0 +1,74 @@
+package reconcile
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/provider"
+	"github.com/evanofslack/caddy-dns-sync/internal/state"
+)
+
+// maxVerifyAttempts bounds the reads made waiting for a created record to be
+// listed by the provider
+const maxVerifyAttempts = 5
+
+// idKey identifies a record by zone, name and type
+func idKey(record provider.Record) string {
+	return groupKey(record.Zone, record.Name) + "/" + record.Type
+}
+
+// knownIDs returns the provider id recorded in state for the main record of
+// each planned host, keyed by idKey. A host is only included while its
+// desired record is still the one that was applied.
+func knownIDs(plan Plan, st state.State) map[string]string {
+	ids := make(map[string]string)
+	for host, record := range plan.Applies {
+		for _, r := range st.Domains[host].Records {
+			if r.ID != "" && r.Type == record.Type && r.Data == record.Data {
+				ids[idKey(record)] = r.ID
+			}
+		}
+	}
+	return ids
+}
+
+// createdID returns the provider id of a record just created, read back when
+// verifyWrites is set or else as reported by the provider
+func (e *engine) createdID(ctx context.Context, record provider.Record) (string, bool) {
+	if e.cfg.Reconcile.VerifyWrites {
+		return e.verifyCreate(ctx, record)
+	}
+	if resolver, ok := e.dnsProvider.(provider.IDResolver); ok {
+		return resolver.RecordID(record.Zone, record)
+	}
+	return "", false
+}
+
+// verifyCreate reads a created record back until the provider lists it,
+// backing off between reads, and returns its id. Providers may acknowledge a
+// create before the record is listable, so without waiting the next sync
+// plans it again.
+func (e *engine) verifyCreate(ctx context.Context, record provider.Record) (string, bool) {
+	for attempt := 0; attempt < maxVerifyAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return "", false
+			case <-time.After(e.retryBackoff << (attempt - 1)):
+			}
+		}
+		existing, err := e.lookupRecords(ctx, record)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to read back created record", "name", record.Name, "type", record.Type, "zone", record.Zone, "error", err)
+			continue
+		}
+		for _, r := range existing {
+			if provider.SameValue(r, record) {
+				return r.ID, true
+			}
+		}
+	}
+	slog.WarnContext(ctx, "Created record not listed by provider", "name", record.Name, "type", record.Type, "zone", record.Zone, "attempts", maxVerifyAttempts)
+	return "", false
+}
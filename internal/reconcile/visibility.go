@@ -0,0 +1,82 @@
+package reconcile
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"path"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+	"github.com/evanofslack/caddy-dns-sync/internal/source"
+)
+
+// sharedAddressSpace is the carrier grade nat range of RFC 6598, not
+// covered by netip's private check
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPrivateAddress reports whether data is an address that is not reachable
+// from the internet: RFC 1918 and unique local, loopback, link local,
+// carrier grade nat or unspecified
+func isPrivateAddress(data string) bool {
+	addr, err := netip.ParseAddr(data)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() ||
+		addr.IsUnspecified() || sharedAddressSpace.Contains(addr)
+}
+
+// defaultProtectedNames are reserved in every zone without its own protected
+// patterns: names usually managed by hand, and the records caddy's dns
+// plugins write for ACME challenges
+var defaultProtectedNames = []string{"_acme-challenge*", "www", "mail", "mx"}
+
+// zoneProtected reports whether the record name matches a protected pattern
+// of zone
+func (e *engine) zoneProtected(zone, name string) bool {
+	patterns := e.zoneSettings[zone].Protected
+	if patterns == nil {
+		patterns = defaultProtectedNames
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneSettings keys zone settings by normalized zone
+func zoneSettings(zones map[string]config.ZoneSettings) map[string]config.ZoneSettings {
+	settings := make(map[string]config.ZoneSettings, len(zones))
+	for zone, s := range zones {
+		if ascii, err := normalizeHost(zone); err == nil {
+			zone = ascii
+		}
+		if ascii, err := normalizeHost(s.Canonical); s.Canonical != "" && err == nil {
+			s.Canonical = ascii
+		}
+		settings[zone] = s
+	}
+	return settings
+}
+
+// privateTargets returns hosts whose address record would publish a private
+// address in a public zone. They are refused rather than synced.
+func (e *engine) privateTargets(ctx context.Context, domains []source.DomainConfig) map[string]bool {
+	refused := make(map[string]bool)
+	for _, d := range domains {
+		for _, zone := range e.zones {
+			if !e.inZone(d.Host, zone) || e.zoneSettings[zone].Visibility != config.VisibilityPublic {
+				continue
+			}
+			record := e.desiredRecord(d, zone)
+			if (record.Type == "A" || record.Type == "AAAA") && isPrivateAddress(record.Data) {
+				slog.WarnContext(ctx, "Refusing to publish private address in public zone", "host", d.Host, "zone", zone, "data", record.Data)
+				refused[d.Host] = true
+			}
+		}
+	}
+	return refused
+}
@@ -0,0 +1,55 @@
+// Package runid identifies a single sync, so its log lines, audit entries
+// and metric exemplars can be correlated
+package runid
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"math/big"
+	"time"
+)
+
+// crockford is the base32 alphabet of ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type contextKey struct{}
+
+// New returns a ULID, sortable by the time it was generated
+func New() string {
+	return newAt(time.Now(), rand.Reader)
+}
+
+func newAt(t time.Time, entropy io.Reader) string {
+	var b [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := io.ReadFull(entropy, b[6:]); err != nil {
+		// The time part alone still orders runs
+		clear(b[6:])
+	}
+
+	n := new(big.Int).SetBytes(b[:])
+	mask := big.NewInt(31)
+	digit := new(big.Int)
+	out := make([]byte, 26)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[digit.And(n, mask).Int64()]
+		n.Rsh(n, 5)
+	}
+	return string(out)
+}
+
+// WithID returns a copy of ctx carrying the run id
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the run id carried by ctx, or "" if none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
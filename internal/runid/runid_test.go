@@ -0,0 +1,37 @@
+package runid
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	// Known ULID for the unix epoch plus 1ms with zero entropy
+	id := newAt(time.UnixMilli(1), bytes.NewReader(make([]byte, 10)))
+	if id != "00000000010000000000000000" {
+		t.Errorf("newAt = %q", id)
+	}
+
+	// Later ids sort after earlier ones
+	a := newAt(time.UnixMilli(1718000000000), bytes.NewReader(bytes.Repeat([]byte{0xff}, 10)))
+	b := newAt(time.UnixMilli(1718000000001), bytes.NewReader(make([]byte, 10)))
+	if len(a) != 26 || a >= b {
+		t.Errorf("Expected %q < %q", a, b)
+	}
+	if strings.Trim(New(), crockford) != "" {
+		t.Error("Expected only crockford base32 characters")
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if id := FromContext(ctx); id != "" {
+		t.Errorf("Expected no run id, got %q", id)
+	}
+	if id := FromContext(WithID(ctx, "run")); id != "run" {
+		t.Errorf("Expected run id run, got %q", id)
+	}
+}
@@ -0,0 +1,160 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a daily time range on some days of the week. A range ending
+// before it starts runs overnight and belongs to the day it starts on.
+type Window struct {
+	days  [7]bool
+	start int // minutes after midnight
+	end   int // minutes after midnight, up to 24:00
+}
+
+// Parse reads a window such as "Mon-Fri 09:00-17:00", "Sat,Sun 22:00-06:00"
+// or "01:00-05:00", days default to every day
+func Parse(spec string) (Window, error) {
+	var w Window
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		if err := w.parseDays(fields[0]); err != nil {
+			return Window{}, fmt.Errorf("window %q: %w", spec, err)
+		}
+		fields = fields[1:]
+	default:
+		return Window{}, fmt.Errorf("window %q: expected [days] HH:MM-HH:MM", spec)
+	}
+
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q: expected HH:MM-HH:MM", spec)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil || w.start == 24*60 {
+		return Window{}, fmt.Errorf("window %q: invalid start %q", spec, from)
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return Window{}, fmt.Errorf("window %q: invalid end %q", spec, to)
+	}
+	if w.start == w.end {
+		return Window{}, fmt.Errorf("window %q: start and end are equal", spec)
+	}
+	return w, nil
+}
+
+// parseDays reads comma separated days or day ranges, e.g. Mon-Fri,Sun
+func (w *Window) parseDays(spec string) error {
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return fmt.Errorf("invalid day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return fmt.Errorf("invalid day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Open reports whether t falls inside the window
+func (w Window) Open(t time.Time) bool {
+	day := t.Weekday()
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	yesterday := (day + 6) % 7
+	return (w.days[day] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+// Windows allow writes when any of them is open, no windows are always open
+type Windows []Window
+
+// ParseAll parses every spec, see Parse
+func ParseAll(specs []string) (Windows, error) {
+	windows := make(Windows, 0, len(specs))
+	for _, spec := range specs {
+		w, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// Open reports whether t falls inside any window
+func (ws Windows) Open(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.Open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the earliest time at or after t when a window is open
+func (ws Windows) Next(t time.Time) time.Time {
+	if ws.Open(t) {
+		return t
+	}
+	var next time.Time
+	for i := 0; i <= 7; i++ {
+		for _, w := range ws {
+			day := time.Date(t.Year(), t.Month(), t.Day()+i, 0, 0, 0, 0, t.Location())
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), 0, w.start, 0, 0, t.Location())
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
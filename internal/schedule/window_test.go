@@ -0,0 +1,78 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowOpen(t *testing.T) {
+	// 2024-06-03 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		spec string
+		time time.Time
+		want bool
+	}{
+		{"weekday inside", "Mon-Fri 09:00-17:00", at(3, 10, 0), true},
+		{"weekday start", "Mon-Fri 09:00-17:00", at(3, 9, 0), true},
+		{"weekday end", "Mon-Fri 09:00-17:00", at(3, 17, 0), false},
+		{"weekend", "Mon-Fri 09:00-17:00", at(8, 10, 0), false},
+		{"every day", "01:00-05:00", at(8, 2, 30), true},
+		{"day list", "Sat,Sun 00:00-24:00", at(9, 23, 59), true},
+		{"wrapping range", "Fri-Mon 12:00-13:00", at(9, 12, 30), true},
+		{"overnight same day", "Fri 22:00-06:00", at(7, 23, 0), true},
+		{"overnight next day", "Fri 22:00-06:00", at(8, 5, 59), true},
+		{"overnight wrong day", "Fri 22:00-06:00", at(7, 5, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := w.Open(tt.time); got != tt.want {
+				t.Errorf("Expected open %v at %v, got %v", tt.want, tt.time, got)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "Mon", "Mon 9-17", "Funday 09:00-17:00", "09:00-09:00", "24:00-01:00", "Mon 09:00-25:00", "Mon Tue 09:00-10:00"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestWindowsNext(t *testing.T) {
+	windows, err := ParseAll([]string{"Mon-Fri 09:00-17:00", "Sat 22:00-02:00"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tests := []struct {
+		name string
+		time time.Time
+		want time.Time
+	}{
+		{"open", time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC), time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)},
+		{"before open", time.Date(2024, 6, 3, 7, 0, 0, 0, time.UTC), time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)},
+		{"after close", time.Date(2024, 6, 3, 18, 0, 0, 0, time.UTC), time.Date(2024, 6, 4, 9, 0, 0, 0, time.UTC)},
+		{"friday evening", time.Date(2024, 6, 7, 18, 0, 0, 0, time.UTC), time.Date(2024, 6, 8, 22, 0, 0, 0, time.UTC)},
+		{"sunday", time.Date(2024, 6, 9, 3, 0, 0, 0, time.UTC), time.Date(2024, 6, 10, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windows.Next(tt.time); !got.Equal(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if !Windows(nil).Open(time.Now()) {
+		t.Errorf("Expected no windows to always be open")
+	}
+}
@@ -2,129 +2,308 @@ package caddy
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
 	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
 	"github.com/evanofslack/caddy-dns-sync/internal/source"
 )
 
 type Client interface {
 	Domains(ctx context.Context) ([]source.DomainConfig, error)
+	DomainsSince(ctx context.Context, hash string) ([]source.DomainConfig, string, error)
+	ConfigHash(ctx context.Context) (string, error)
 }
 
+// ErrUnchanged is returned when the caddy config matches a previously seen hash
+var ErrUnchanged = errors.New("caddy config unchanged")
+
 type Httper interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+const (
+	handlerReverseProxy   = "reverse_proxy"
+	handlerStaticResponse = "static_response"
+	handlerFileServer     = "file_server"
+	handlerSubroute       = "subroute"
+)
+
 type client struct {
 	adminURL string
 	http     Httper
 	metrics  *metrics.Metrics
+	publish  map[string]bool // non proxy handlers to publish
+	target   string          // upstream used for published non proxy handlers
+	scoped   bool            // fetch only apps/http/servers
+	health   bool            // publish healthy upstreams, as reported by /reverse_proxy/upstreams
+	agent    string          // user agent of admin api requests
+	source   string          // name hosts are attributed to
 }
 
-func New(adminURL string, metrics *metrics.Metrics) Client {
+func New(cfg config.Caddy, metrics *metrics.Metrics) Client {
+	publish := make(map[string]bool)
+	for _, h := range cfg.PublishHandlers {
+		publish[h] = true
+	}
+	httpClient := &http.Client{}
+	if cfg.TLS.Enabled() {
+		httpClient.Transport = newTLSTransport(cfg.TLS)
+	}
 	return &client{
-		adminURL: adminURL,
-		http:     &http.Client{},
+		adminURL: cfg.AdminURL,
+		http:     httpClient,
 		metrics:  metrics,
+		publish:  publish,
+		target:   cfg.Target,
+		scoped:   cfg.ServersOnly,
+		health:   cfg.UpstreamHealth,
+		agent:    cfg.UserAgent,
+		source:   cfg.Source,
 	}
 }
 
 func (c *client) Domains(ctx context.Context) ([]source.DomainConfig, error) {
+	domains, _, err := c.DomainsSince(ctx, "")
+	return domains, err
+}
+
+// DomainsSince returns domains and the config hash, skipping parsing and
+// returning ErrUnchanged if the config hash equals the given hash
+func (c *client) DomainsSince(ctx context.Context, hash string) ([]source.DomainConfig, string, error) {
 	domains := []source.DomainConfig{}
-	config, err := c.getConfiguration(ctx)
+	body, err := c.fetchConfig(ctx)
 	if err != nil {
-		return domains, err
+		return domains, "", err
 	}
-	domains, err = c.extractDomains(config)
+	// Health changes alter the domains without changing the config
+	unhealthy := c.fetchHealth(ctx)
+	current := hashConfig(body, unhealthy)
+	if hash != "" && current == hash {
+		slog.Debug("Caddy config unchanged, skipping parse", "hash", current)
+		return domains, current, ErrUnchanged
+	}
+
+	decode := decodeStream
+	if c.scoped {
+		decode = decodeServers
+	}
+	config, err := decode(body)
 	if err != nil {
-		return domains, err
+		return domains, current, err
 	}
-	return domains, nil
+	domains, err = c.extractDomains(config, unhealthy)
+	if err != nil {
+		return domains, current, err
+	}
+	return domains, current, nil
 }
 
-func (c *client) getConfiguration(ctx context.Context) (Config, error) {
+// DomainsFromConfig extracts domains from a caddy config read elsewhere,
+// such as saved from the admin api. Both the full config and the servers
+// object of apps/http/servers are accepted.
+func DomainsFromConfig(cfg config.Caddy, metrics *metrics.Metrics, body []byte) ([]source.DomainConfig, error) {
+	c := New(cfg, metrics).(*client)
+	config, err := decodeStream(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Apps.HTTP.Servers) == 0 {
+		if config, err = decodeServers(body); err != nil {
+			return nil, err
+		}
+	}
+	return c.extractDomains(config, nil)
+}
+
+// ConfigHash returns a hash of the current caddy config, used to detect
+// restarts and config changes between syncs
+func (c *client) ConfigHash(ctx context.Context) (string, error) {
+	body, err := c.fetchConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	return hashConfig(body, c.fetchHealth(ctx)), nil
+}
+
+func (c *client) fetchConfig(ctx context.Context) ([]byte, error) {
 	endpoint := fmt.Sprintf("%s/config/", c.adminURL)
+	if c.scoped {
+		// skip tls certificates and other apps, which can dwarf the routes
+		endpoint = fmt.Sprintf("%s/config/apps/http/servers", c.adminURL)
+	}
 	slog.Debug("Get caddy config", "endpoint", endpoint)
+	return c.get(ctx, endpoint)
+}
+
+// get reads an admin api endpoint, failing on any status but 200
+func (c *client) get(ctx context.Context, endpoint string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		c.metrics.IncCaddyRequest(false, 0)
-		return Config{}, err
+		return nil, err
+	}
+	if c.agent != "" {
+		req.Header.Set("User-Agent", c.agent)
 	}
 	resp, err := c.http.Do(req)
 	if err != nil {
 		c.metrics.IncCaddyRequest(false, 0)
-		return Config{}, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		c.metrics.IncCaddyRequest(false, resp.StatusCode)
-		return Config{}, fmt.Errorf("caddy api request, status=%d", resp.StatusCode)
+		return nil, fmt.Errorf("caddy api request, status=%d", resp.StatusCode)
 	}
 
-	var config Config
-	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
 		c.metrics.IncCaddyRequest(false, 0)
-		return Config{}, fmt.Errorf("parse caddy config, err=%w", err)
+		return nil, fmt.Errorf("read caddy config, err=%w", err)
 	}
 	c.metrics.IncCaddyRequest(true, resp.StatusCode)
-	return config, nil
+	return body, nil
+}
+
+// hashConfig hashes the caddy config along with the upstreams found unhealthy
+func hashConfig(body []byte, unhealthy upstreamHealth) string {
+	h := sha256.New()
+	h.Write(body)
+	h.Write([]byte(unhealthy.signature()))
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-func (c *client) extractDomains(config Config) ([]source.DomainConfig, error) {
+// extractDomains returns the hosts of config, proxied hosts pointing at their
+// first upstream not in unhealthy
+func (c *client) extractDomains(config Config, unhealthy upstreamHealth) ([]source.DomainConfig, error) {
 	slog.Debug("Parse caddy config")
 	domains := []source.DomainConfig{}
-	entries := 0
+	handlers := map[string]int{
+		handlerReverseProxy:   0,
+		handlerStaticResponse: 0,
+		handlerFileServer:     0,
+	}
+	hosts := make(map[string]bool)
 	for _, server := range config.Apps.HTTP.Servers {
 		for _, route := range server.Routes {
-			for _, match := range route.Match {
-				for _, host := range match.Host {
-					entries++
-					c.processHandlers(host, route.Handle, &domains)
-				}
+			for _, host := range route.Hosts() {
+				hosts[host] = true
+				c.processHandlers(host, route.Handle, &domains, handlers, unhealthy)
 			}
 		}
 	}
+	domains = dedupeDomains(domains)
+
+	rp := 0
+	for _, d := range domains {
+		hosts[d.Host] = true
+		if d.Handler == handlerReverseProxy {
+			rp++
+		}
+	}
 
 	// Count reverse proxies
-	c.metrics.SetCaddyEntries(len(domains), true)
-	// Count non reverse proxies
-	norp := entries - len(domains)
-	if norp > 0 {
-		c.metrics.SetCaddyEntries(norp, false)
+	c.metrics.SetCaddyEntries(rp, true)
+	// Count non reverse proxies, by distinct host like the reverse proxies
+	c.metrics.SetCaddyEntries(len(hosts)-rp, false)
+	for handler, count := range handlers {
+		c.metrics.SetCaddyHandlers(handler, count)
 	}
 	return domains, nil
 }
 
-func (c *client) processHandlers(parentHost string, handlers []Handler, domains *[]source.DomainConfig) {
+func (c *client) processHandlers(parentHost string, handlers []Handler, domains *[]source.DomainConfig, counts map[string]int, unhealthy upstreamHealth) {
 	for _, handler := range handlers {
 		slog.Debug("Processing handler", "handler", handler.Handler, "upstreams", handler.Upstreams)
 
-		// Track current host context through nested routes
-		currentHost := parentHost
-		if handler.Handler == "subroute" {
+		switch handler.Handler {
+		case handlerSubroute:
 			for _, nestedRoute := range handler.Routes {
-				// Update host context if route has host matches
-				for _, match := range nestedRoute.Match {
-					if len(match.Host) > 0 {
-						currentHost = match.Host[0]
-					}
+				// Nested host matchers narrow the host context, otherwise inherit it
+				hosts := nestedRoute.Hosts()
+				if len(hosts) == 0 {
+					hosts = []string{parentHost}
+				}
+				for _, host := range hosts {
+					c.processHandlers(host, nestedRoute.Handle, domains, counts, unhealthy)
 				}
-				c.processHandlers(currentHost, nestedRoute.Handle, domains)
 			}
-		}
-
-		if handler.Handler == "reverse_proxy" && len(handler.Upstreams) > 0 {
-			upstream := handler.Upstreams[0].Dial
-			slog.Info("Added domain", "host", currentHost, "upstream", upstream)
+		case handlerReverseProxy:
+			if len(handler.Upstreams) == 0 {
+				continue
+			}
+			counts[handlerReverseProxy]++
+			upstream, alternates, healthy := unhealthy.pick(handler.Upstreams)
+			if !c.health {
+				// Without health checks only the first upstream is published
+				alternates = nil
+			}
+			switch {
+			case !healthy:
+				slog.Warn("Every upstream unhealthy, keeping the first", "host", parentHost, "upstream", upstream)
+			case upstream != handler.Upstreams[0].Dial:
+				slog.Info("First upstream unhealthy, publishing the healthy ones", "host", parentHost, "unhealthy", handler.Upstreams[0].Dial, "upstream", upstream, "alternates", alternates)
+			}
+			slog.Info("Added domain", "host", parentHost, "upstream", upstream, "alternates", alternates)
 			*domains = append(*domains, source.DomainConfig{
-				Host:     currentHost, // Use most specific host context
-				Upstream: handler.Upstreams[0].Dial,
+				Host:       parentHost, // Use most specific host context
+				Upstream:   upstream,
+				Alternates: alternates,
+				Port:       upstreamPort(upstream),
+				Handler:    handlerReverseProxy,
+				Source:     c.source,
 			})
+		case handlerStaticResponse, handlerFileServer:
+			counts[handler.Handler]++
+			if !c.publish[handler.Handler] || c.target == "" {
+				slog.Debug("Skipping non proxy handler", "host", parentHost, "handler", handler.Handler)
+				continue
+			}
+			slog.Info("Added domain", "host", parentHost, "upstream", c.target, "handler", handler.Handler)
+			*domains = append(*domains, source.DomainConfig{
+				Host:     parentHost,
+				Upstream: c.target,
+				Port:     upstreamPort(c.target),
+				Handler:  handler.Handler,
+				Source:   c.source,
+			})
+		}
+	}
+}
+
+func upstreamPort(upstream string) int {
+	_, portStr, err := net.SplitHostPort(upstream)
+	if err != nil {
+		return 0
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return 0
+	}
+	return port
+}
+
+// dedupeDomains drops repeated host entries, keeping the first upstream seen
+// as caddy would for the first matching route.
+func dedupeDomains(domains []source.DomainConfig) []source.DomainConfig {
+	seen := make(map[string]bool)
+	deduped := []source.DomainConfig{}
+	for _, d := range domains {
+		if seen[d.Host] {
+			continue
 		}
+		seen[d.Host] = true
+		deduped = append(deduped, d)
 	}
+	return deduped
 }
@@ -5,11 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
 	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
 	"github.com/evanofslack/caddy-dns-sync/internal/source"
 )
@@ -87,9 +91,9 @@ func TestDomains(t *testing.T) {
 			mockStatusCode: http.StatusOK,
 			mockError:      nil,
 			expected: []source.DomainConfig{
-				{Host: "example.com", Upstream: "localhost:8080"},
-				{Host: "www.example.com", Upstream: "localhost:8080"},
-				{Host: "api.example.com", Upstream: "localhost:9000"},
+				{Host: "example.com", Upstream: "localhost:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "www.example.com", Upstream: "localhost:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "api.example.com", Upstream: "localhost:9000", Port: 9000, Handler: "reverse_proxy"},
 			},
 			expectError: false,
 		},
@@ -165,7 +169,7 @@ func TestDomains(t *testing.T) {
 			},
 			mockStatusCode: http.StatusOK,
 			expected: []source.DomainConfig{
-				{Host: "synctest.local.eslack.net", Upstream: "1.1.1.1:443"},
+				{Host: "synctest.local.eslack.net", Upstream: "1.1.1.1:443", Port: 443, Handler: "reverse_proxy"},
 			},
 		},
 	}
@@ -226,3 +230,402 @@ func TestDomains(t *testing.T) {
 		})
 	}
 }
+
+func TestDomainsFixtures(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		publish  []string
+		target   string
+		expected []source.DomainConfig
+	}{
+		{
+			name:    "named matchers and matcher sets",
+			fixture: "testdata/named_matchers.json",
+			expected: []source.DomainConfig{
+				{Host: "app.example.com", Upstream: "10.0.0.1:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "one.example.com", Upstream: "10.0.0.2:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "two.example.com", Upstream: "10.0.0.2:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "expr.example.com", Upstream: "10.0.0.3:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "expr2.example.com", Upstream: "10.0.0.3:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "second.example.com", Upstream: "10.0.0.4:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "object.example.com", Upstream: "10.0.0.5:8080", Port: 8080, Handler: "reverse_proxy"},
+			},
+		},
+		{
+			name:    "non proxy handlers skipped",
+			fixture: "testdata/static_handlers.json",
+			expected: []source.DomainConfig{
+				{Host: "app.example.com", Upstream: "10.0.0.1:8080", Port: 8080, Handler: "reverse_proxy"},
+			},
+		},
+		{
+			name:    "non proxy handlers published with target",
+			fixture: "testdata/static_handlers.json",
+			publish: []string{"static_response"},
+			target:  "203.0.113.10",
+			expected: []source.DomainConfig{
+				{Host: "app.example.com", Upstream: "10.0.0.1:8080", Port: 8080, Handler: "reverse_proxy"},
+				{Host: "old.example.com", Upstream: "203.0.113.10", Handler: "static_response"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := os.ReadFile(tt.fixture)
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+			c := New(config.Caddy{
+				AdminURL:        "http://localhost:2019",
+				PublishHandlers: tt.publish,
+				Target:          tt.target,
+			}, metrics.New(false)).(*client)
+			c.http = &MockHttpClient{
+				GetFunc: func(url string) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewReader(body)),
+					}, nil
+				},
+			}
+
+			result, err := c.Domains(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Expected domains %+v but got %+v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestExpressionHosts(t *testing.T) {
+	tests := []struct {
+		expr     Expression
+		expected []string
+	}{
+		{"host('a.example.com')", []string{"a.example.com"}},
+		{`host("a.example.com", "b.example.com")`, []string{"a.example.com", "b.example.com"}},
+		{"path('/api/*')", nil},
+		{"!host('a.example.com') && host('b.example.com')", []string{"b.example.com"}},
+		{"vhost('a.example.com') || host('b.example.com')", []string{"b.example.com"}},
+		{"host('a.example.com')||host('b.example.com')", []string{"a.example.com", "b.example.com"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.expr), func(t *testing.T) {
+			if got := tt.expr.Hosts(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Hosts() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	body := []byte(`{"apps":{"http":{"servers":{}}}}`)
+	c := &client{
+		adminURL: "http://localhost:2019",
+		http: &MockHttpClient{
+			GetFunc: func(url string) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(body)),
+				}, nil
+			},
+		},
+		metrics: metrics.New(false),
+	}
+
+	ctx := context.Background()
+	first, err := c.ConfigHash(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := c.ConfigHash(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first != second {
+		t.Errorf("Expected stable hash for unchanged config, got %s and %s", first, second)
+	}
+
+	body = []byte(`{"apps":{"http":{"servers":{"srv0":{}}}}}`)
+	changed, err := c.ConfigHash(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if changed == first {
+		t.Error("Expected hash to change with config")
+	}
+}
+
+func TestDomainsSince(t *testing.T) {
+	body, err := os.ReadFile("testdata/named_matchers.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	c := &client{
+		adminURL: "http://localhost:2019",
+		http: &MockHttpClient{
+			GetFunc: func(url string) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewReader(body)),
+				}, nil
+			},
+		},
+		metrics: metrics.New(false),
+	}
+
+	ctx := context.Background()
+	domains, hash, err := c.DomainsSince(ctx, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(domains) == 0 || hash == "" {
+		t.Fatalf("Expected domains and hash, got %d domains and hash %q", len(domains), hash)
+	}
+
+	domains, again, err := c.DomainsSince(ctx, hash)
+	if !errors.Is(err, ErrUnchanged) {
+		t.Fatalf("Expected ErrUnchanged, got %v", err)
+	}
+	if again != hash || len(domains) != 0 {
+		t.Errorf("Expected unchanged hash and no domains, got %q and %d domains", again, len(domains))
+	}
+
+	if _, _, err := c.DomainsSince(ctx, "stale"); err != nil {
+		t.Errorf("Expected parse for changed hash, got %v", err)
+	}
+}
+
+func TestDecodeStream(t *testing.T) {
+	for _, fixture := range []string{"testdata/named_matchers.json", "testdata/static_handlers.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			body, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+			c := New(config.Caddy{}, metrics.New(false)).(*client)
+
+			var full Config
+			if err := json.Unmarshal(body, &full); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			streamed, err := decodeStream(body)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			expected, _ := c.extractDomains(full, nil)
+			result, _ := c.extractDomains(streamed, nil)
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("Expected domains %+v but got %+v", expected, result)
+			}
+		})
+	}
+
+	for _, body := range []string{
+		`{"apps": [`,
+		`{"apps": {"http": {"servers": {"srv0": {"routes": {}}}}}}`,
+	} {
+		if _, err := decodeStream([]byte(body)); err == nil {
+			t.Errorf("Expected error for config %s", body)
+		}
+	}
+}
+
+// largeConfig builds a config with many routes carrying handler options and
+// tls data that domain extraction never looks at
+func largeConfig(routes int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"apps":{"tls":{"certificates":{"load_pem":[`)
+	for i := 0; i < routes; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(`{"certificate":"` + strings.Repeat("A", 512) + `","key":"` + strings.Repeat("B", 256) + `","tags":["a","b"]}`)
+	}
+	buf.WriteString(`]}},"http":{"servers":{"srv0":{"listen":[":443"],"routes":[`)
+	for i := 0; i < routes; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `{"match":[{"host":["app%d.example.com"],"path":["/api/*","/static/*"]}],`, i)
+		buf.WriteString(`"handle":[{"handler":"headers","response":{"set":{"X-Frame-Options":["DENY"],"X-Content-Type-Options":["nosniff"]}}},`)
+		fmt.Fprintf(&buf, `{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.%d.%d:8080"}],`, i/256, i%256)
+		buf.WriteString(`"transport":{"protocol":"http","tls":{"insecure_skip_verify":true},"dial_timeout":"5s"},"health_checks":{"active":{"uri":"/health","interval":"10s"}}}],"terminal":true}`)
+	}
+	buf.WriteString(`]}}}}}`)
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeConfig(b *testing.B) {
+	body := largeConfig(2000)
+
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var config Config
+			if err := json.Unmarshal(body, &config); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeStream(body); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+type httperFunc func(req *http.Request) (*http.Response, error)
+
+func (f httperFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestServersOnly(t *testing.T) {
+	body, err := os.ReadFile("testdata/named_matchers.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	var full struct {
+		Apps struct {
+			HTTP struct {
+				Servers json.RawMessage `json:"servers"`
+			} `json:"http"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal(body, &full); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	responses := map[string][]byte{
+		"/config/":                  body,
+		"/config/apps/http/servers": full.Apps.HTTP.Servers,
+	}
+	domains := make(map[bool][]source.DomainConfig)
+	for _, scoped := range []bool{false, true} {
+		c := New(config.Caddy{AdminURL: "http://localhost:2019", ServersOnly: scoped}, metrics.New(false)).(*client)
+		c.http = httperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, ok := responses[req.URL.Path]
+			if !ok {
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(resp))}, nil
+		})
+		result, err := c.Domains(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error with servers only %v: %v", scoped, err)
+		}
+		domains[scoped] = result
+	}
+	if len(domains[true]) == 0 || !reflect.DeepEqual(domains[true], domains[false]) {
+		t.Errorf("Expected scoped domains %+v to match full domains %+v", domains[true], domains[false])
+	}
+
+	// no http app configured
+	result, err := decodeServers([]byte("null"))
+	if err != nil || len(result.Apps.HTTP.Servers) != 0 {
+		t.Errorf("Expected empty config for null servers, got %+v err=%v", result, err)
+	}
+}
+
+func TestDomainsFromConfig(t *testing.T) {
+	body, err := os.ReadFile("testdata/named_matchers.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	var full struct {
+		Apps struct {
+			HTTP struct {
+				Servers json.RawMessage `json:"servers"`
+			} `json:"http"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal(body, &full); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	fromFull, err := DomainsFromConfig(config.Caddy{}, metrics.New(false), body)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fromServers, err := DomainsFromConfig(config.Caddy{}, metrics.New(false), full.Apps.HTTP.Servers)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fromFull) == 0 || !reflect.DeepEqual(fromFull, fromServers) {
+		t.Errorf("Expected servers domains %+v to match full domains %+v", fromServers, fromFull)
+	}
+
+	if _, err := DomainsFromConfig(config.Caddy{}, metrics.New(false), []byte("{")); err == nil {
+		t.Errorf("Expected error for truncated config")
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	c := New(config.Caddy{AdminURL: "http://localhost:2019", UserAgent: "caddy-dns-sync/dev (test)"}, metrics.New(false)).(*client)
+	agent := ""
+	c.http = httperFunc(func(req *http.Request) (*http.Response, error) {
+		agent = req.Header.Get("User-Agent")
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+	})
+	if _, err := c.ConfigHash(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if agent != "caddy-dns-sync/dev (test)" {
+		t.Errorf("User-Agent = %q", agent)
+	}
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	caddyConfig := `{"apps":{"http":{"servers":{"srv0":{"routes":[{"match":[{"host":["app.example.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.1:8080"},{"dial":"10.0.0.2:8080"}]}]}]}}}}}`
+	health := `[{"address":"10.0.0.1:8080","num_requests":0,"fails":2},{"address":"10.0.0.2:8080","num_requests":1,"fails":0}]`
+	c := New(config.Caddy{AdminURL: "http://localhost:2019", UpstreamHealth: true}, metrics.New(false)).(*client)
+	c.http = httperFunc(func(req *http.Request) (*http.Response, error) {
+		body := caddyConfig
+		if req.URL.Path == "/reverse_proxy/upstreams" {
+			body = health
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	})
+
+	ctx := context.Background()
+	domains, hash, err := c.DomainsSince(ctx, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(domains) != 1 || domains[0].Upstream != "10.0.0.2:8080" || domains[0].Alternates != nil {
+		t.Errorf("Expected only the healthy second upstream, got %+v", domains)
+	}
+
+	// Recovery changes the hash though the config did not change
+	health = `[{"address":"10.0.0.1:8080","num_requests":0,"fails":0}]`
+	domains, recovered, err := c.DomainsSince(ctx, hash)
+	if err != nil {
+		t.Fatalf("Expected a parse once health changed, got %v", err)
+	}
+	if recovered == hash || len(domains) != 1 || domains[0].Upstream != "10.0.0.1:8080" {
+		t.Errorf("Expected the first upstream once healthy, got %+v", domains)
+	}
+	// Every healthy upstream is published
+	if expected := []string{"10.0.0.2:8080"}; !reflect.DeepEqual(domains[0].Alternates, expected) {
+		t.Errorf("Alternates = %v, want %v", domains[0].Alternates, expected)
+	}
+
+	// With every upstream failing the first is kept
+	health = `[{"address":"10.0.0.1:8080","fails":1},{"address":"10.0.0.2:8080","fails":1}]`
+	if domains, _, _ = c.DomainsSince(ctx, ""); len(domains) != 1 || domains[0].Upstream != "10.0.0.1:8080" {
+		t.Errorf("Expected the first upstream kept, got %+v", domains)
+	}
+}
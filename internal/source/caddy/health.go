@@ -0,0 +1,82 @@
+package caddy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// upstreamStatus is an upstream as reported by /reverse_proxy/upstreams
+type upstreamStatus struct {
+	Address     string `json:"address"`
+	NumRequests int    `json:"num_requests"`
+	Fails       int    `json:"fails"`
+	Healthy     *bool  `json:"healthy,omitempty"` // only reported by older caddy releases
+}
+
+// upstreamHealth holds the dial addresses of unhealthy upstreams, a nil
+// health treats every upstream as healthy
+type upstreamHealth map[string]bool
+
+// fetchHealth returns the upstreams caddy currently counts failures against,
+// nil if upstream health is not used or could not be read. Caddy reports
+// passive health check failures within fail_duration and failed active health
+// checks as fails.
+func (c *client) fetchHealth(ctx context.Context) upstreamHealth {
+	if !c.health {
+		return nil
+	}
+	body, err := c.get(ctx, fmt.Sprintf("%s/reverse_proxy/upstreams", c.adminURL))
+	if err != nil {
+		slog.Warn("Failed to get caddy upstream health, treating every upstream as healthy", "error", err)
+		return nil
+	}
+	var statuses []upstreamStatus
+	if err := json.Unmarshal(body, &statuses); err != nil {
+		slog.Warn("Failed to decode caddy upstream health, treating every upstream as healthy", "error", err)
+		return nil
+	}
+	unhealthy := make(upstreamHealth)
+	for _, s := range statuses {
+		if s.Fails > 0 || (s.Healthy != nil && !*s.Healthy) {
+			unhealthy[s.Address] = true
+		}
+	}
+	c.metrics.SetUnhealthyUpstreams(len(unhealthy))
+	return unhealthy
+}
+
+// pick returns the first healthy upstream and the healthy ones after it, or
+// the first upstream if none is healthy so a host is never unpublished by
+// its health checks alone
+func (h upstreamHealth) pick(upstreams []Upstream) (string, []string, bool) {
+	var healthy []string
+	for _, u := range upstreams {
+		if !h[u.Dial] {
+			healthy = append(healthy, u.Dial)
+		}
+	}
+	if len(healthy) == 0 {
+		return upstreams[0].Dial, nil, false
+	}
+	if len(healthy) == 1 {
+		return healthy[0], nil, true
+	}
+	return healthy[0], healthy[1:], true
+}
+
+// signature is a stable string of the unhealthy upstreams, empty if none
+func (h upstreamHealth) signature() string {
+	if len(h) == 0 {
+		return ""
+	}
+	addresses := make([]string, 0, len(h))
+	for address := range h {
+		addresses = append(addresses, address)
+	}
+	slices.Sort(addresses)
+	return "\nunhealthy=" + strings.Join(addresses, ",")
+}
@@ -0,0 +1,342 @@
+package caddy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// decodeStream walks the raw caddy config, keeping only host matchers,
+// handler names, nested routes and upstream dials. Everything else (tls
+// certificates, handler options, transports) and handlers that domain
+// extraction ignores are stepped over without being decoded, so large configs
+// are extracted with fewer allocations than a full unmarshal.
+func decodeStream(data []byte) (Config, error) {
+	return decode(data, func(w *walker, config *Config) error {
+		return w.object(func(key []byte) error {
+			if string(key) != "apps" {
+				return w.skip()
+			}
+			return w.object(func(key []byte) error {
+				if string(key) != "http" {
+					return w.skip()
+				}
+				return w.object(func(key []byte) error {
+					if string(key) != "servers" {
+						return w.skip()
+					}
+					return w.servers(config)
+				})
+			})
+		})
+	})
+}
+
+// decodeServers is decodeStream for a config scoped to apps/http/servers
+func decodeServers(data []byte) (Config, error) {
+	return decode(data, func(w *walker, config *Config) error {
+		return w.servers(config)
+	})
+}
+
+func decode(data []byte, walk func(w *walker, config *Config) error) (Config, error) {
+	var config Config
+	if !json.Valid(data) {
+		return config, errors.New("parse caddy config, err=invalid json")
+	}
+	if err := walk(&walker{data: data}, &config); err != nil {
+		return Config{}, fmt.Errorf("parse caddy config, err=%w", err)
+	}
+	return config, nil
+}
+
+// walker steps through json that is already known to be valid
+type walker struct {
+	data []byte
+	pos  int
+}
+
+func (w *walker) servers(config *Config) error {
+	config.Apps.HTTP.Servers = make(map[string]Server)
+	return w.object(func(name []byte) error {
+		server, err := w.server()
+		config.Apps.HTTP.Servers[string(name)] = server
+		return err
+	})
+}
+
+func (w *walker) server() (Server, error) {
+	var server Server
+	err := w.object(func(key []byte) error {
+		if string(key) != "routes" {
+			return w.skip()
+		}
+		routes, err := w.routes()
+		server.Routes = routes
+		return err
+	})
+	return server, err
+}
+
+func (w *walker) routes() ([]Route, error) {
+	var routes []Route
+	err := w.array(func() error {
+		var route Route
+		err := w.object(func(key []byte) error {
+			switch string(key) {
+			case "match":
+				return w.array(func() error {
+					match, err := w.match()
+					route.Match = append(route.Match, match)
+					return err
+				})
+			case "handle":
+				return w.array(func() error {
+					handler, err := w.handler()
+					if handler.Handler != "" {
+						route.Handle = append(route.Handle, handler)
+					}
+					return err
+				})
+			}
+			return w.skip()
+		})
+		routes = append(routes, route)
+		return err
+	})
+	return routes, err
+}
+
+func (w *walker) match() (Match, error) {
+	var match Match
+	err := w.object(func(key []byte) error {
+		switch string(key) {
+		case "host":
+			return w.array(func() error {
+				host, err := w.str()
+				match.Host = append(match.Host, host)
+				return err
+			})
+		case "expression":
+			expr, err := w.expression()
+			match.Expression = expr
+			return err
+		case "not":
+			return w.array(func() error {
+				not, err := w.match()
+				match.Not = append(match.Not, not)
+				return err
+			})
+		}
+		return w.skip()
+	})
+	return match, err
+}
+
+func (w *walker) expression() (Expression, error) {
+	if w.peek() != '{' {
+		s, err := w.str()
+		return Expression(s), err
+	}
+	var expr Expression
+	err := w.object(func(key []byte) error {
+		if string(key) != "expr" {
+			return w.skip()
+		}
+		s, err := w.str()
+		expr = Expression(s)
+		return err
+	})
+	return expr, err
+}
+
+func (w *walker) handler() (Handler, error) {
+	var handler Handler
+	err := w.object(func(key []byte) error {
+		switch string(key) {
+		case "handler":
+			name, err := w.rawString()
+			handler.Handler = knownHandler(name)
+			return err
+		case "routes":
+			routes, err := w.routes()
+			handler.Routes = routes
+			return err
+		case "upstreams":
+			return w.array(func() error {
+				var upstream Upstream
+				err := w.object(func(key []byte) error {
+					if string(key) != "dial" {
+						return w.skip()
+					}
+					dial, err := w.str()
+					upstream.Dial = dial
+					return err
+				})
+				handler.Upstreams = append(handler.Upstreams, upstream)
+				return err
+			})
+		}
+		return w.skip()
+	})
+	return handler, err
+}
+
+// knownHandler returns the handler name if domain extraction acts on it,
+// otherwise empty so the handler can be dropped
+func knownHandler(name []byte) string {
+	switch string(name) {
+	case handlerReverseProxy:
+		return handlerReverseProxy
+	case handlerStaticResponse:
+		return handlerStaticResponse
+	case handlerFileServer:
+		return handlerFileServer
+	case handlerSubroute:
+		return handlerSubroute
+	}
+	return ""
+}
+
+// object calls fn for every key of the next object, fn must consume the value.
+// A null value is treated as an empty object.
+func (w *walker) object(fn func(key []byte) error) error {
+	if w.null() {
+		return nil
+	}
+	if w.peek() != '{' {
+		return fmt.Errorf("expected object at offset %d", w.pos)
+	}
+	w.pos++
+	for w.peek() != '}' {
+		key, err := w.rawString()
+		if err != nil {
+			return err
+		}
+		w.peek()
+		w.pos++ // colon
+		if err := fn(key); err != nil {
+			return err
+		}
+		if w.peek() == ',' {
+			w.pos++
+		}
+	}
+	w.pos++
+	return nil
+}
+
+// array calls fn for every element of the next array, fn must consume the element.
+// A null value is treated as an empty array.
+func (w *walker) array(fn func() error) error {
+	if w.null() {
+		return nil
+	}
+	if w.peek() != '[' {
+		return fmt.Errorf("expected array at offset %d", w.pos)
+	}
+	w.pos++
+	for w.peek() != ']' {
+		if err := fn(); err != nil {
+			return err
+		}
+		if w.peek() == ',' {
+			w.pos++
+		}
+	}
+	w.pos++
+	return nil
+}
+
+// str decodes the next string value, only unescaping when needed
+func (w *walker) str() (string, error) {
+	start := w.pos
+	raw, err := w.rawString()
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw), nil
+	}
+	var s string
+	err = json.Unmarshal(w.data[start:w.pos], &s)
+	return s, err
+}
+
+// rawString returns the bytes between the quotes of the next string
+func (w *walker) rawString() ([]byte, error) {
+	if w.peek() != '"' {
+		return nil, fmt.Errorf("expected string at offset %d", w.pos)
+	}
+	start := w.pos + 1
+	w.skipString()
+	return w.data[start : w.pos-1], nil
+}
+
+// skip steps over the next value of any type
+func (w *walker) skip() error {
+	switch w.peek() {
+	case '"':
+		w.skipString()
+	case '{', '[':
+		depth := 0
+		for {
+			switch w.data[w.pos] {
+			case '"':
+				w.skipString()
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			w.pos++
+			if depth == 0 {
+				return nil
+			}
+		}
+	default:
+		for w.pos < len(w.data) {
+			switch w.data[w.pos] {
+			case ',', '}', ']', ' ', '\t', '\r', '\n':
+				return nil
+			}
+			w.pos++
+		}
+	}
+	return nil
+}
+
+// skipString moves past the closing quote of the string at the current offset
+func (w *walker) skipString() {
+	w.pos++
+	for w.data[w.pos] != '"' {
+		if w.data[w.pos] == '\\' {
+			w.pos++
+		}
+		w.pos++
+	}
+	w.pos++
+}
+
+func (w *walker) null() bool {
+	if w.peek() == 'n' {
+		w.pos += len("null")
+		return true
+	}
+	return false
+}
+
+// peek skips whitespace and returns the next byte, or zero at the end of input
+func (w *walker) peek() byte {
+	for w.pos < len(w.data) {
+		switch w.data[w.pos] {
+		case ' ', '\t', '\r', '\n':
+			w.pos++
+			continue
+		}
+		return w.data[w.pos]
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package caddy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+)
+
+// tlsTransport reaches the admin api with the client certificate and ca of
+// cfg. The files are checked for changes before every request and the
+// transport is rebuilt once they changed, so certificates rotated in place,
+// e.g. by cert-manager, are used without a restart. Files that fail to load
+// mid rotation keep the previous transport until they load.
+type tlsTransport struct {
+	cfg config.CaddyTLS
+
+	mu        sync.Mutex
+	transport *http.Transport
+	loaded    map[string]time.Time // modification time of each file when loaded
+}
+
+func newTLSTransport(cfg config.CaddyTLS) *tlsTransport {
+	return &tlsTransport{cfg: cfg}
+}
+
+func (t *tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+// current returns the transport of the files as they are now, rebuilding it
+// if they changed since it was built
+func (t *tlsTransport) current() (*http.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	modified, err := t.modTimes()
+	if err == nil && t.transport != nil && maps.Equal(modified, t.loaded) {
+		return t.transport, nil
+	}
+	var tlsConfig *tls.Config
+	if err == nil {
+		tlsConfig, err = t.load()
+	}
+	if err != nil {
+		if t.transport == nil {
+			return nil, fmt.Errorf("load caddy tls: %w", err)
+		}
+		slog.Warn("Failed to reload caddy tls files, keeping the previous ones", "error", err)
+		return t.transport, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if t.transport != nil {
+		t.transport.CloseIdleConnections()
+		slog.Info("Reloaded caddy tls files", "cert", t.cfg.Cert, "ca", t.cfg.CA)
+	}
+	t.transport, t.loaded = transport, modified
+	return transport, nil
+}
+
+func (t *tlsTransport) modTimes() (map[string]time.Time, error) {
+	modified := make(map[string]time.Time)
+	for _, file := range []string{t.cfg.Cert, t.cfg.Key, t.cfg.CA} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modified[file] = info.ModTime()
+	}
+	return modified, nil
+}
+
+func (t *tlsTransport) load() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: t.cfg.ServerName,
+	}
+	if t.cfg.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.cfg.Cert, t.cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if t.cfg.CA != "" {
+		data, err := os.ReadFile(t.cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", t.cfg.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
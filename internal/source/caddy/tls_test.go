@@ -0,0 +1,120 @@
+package caddy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/config"
+)
+
+// issue signs a certificate for name with ca, self signed if ca is nil
+func issue(t *testing.T, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca, caKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestTLSTransportRotation(t *testing.T) {
+	ca, caKey, caPEM, _ := issue(t, "ca", nil, nil, true)
+	_, _, serverCert, serverKey := issue(t, "caddy", ca, caKey, false)
+	pair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("Failed to load server certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{pair}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	cfg := config.CaddyTLS{
+		Cert: filepath.Join(dir, "tls.crt"),
+		Key:  filepath.Join(dir, "tls.key"),
+		CA:   filepath.Join(dir, "ca.crt"),
+	}
+	write := func(name string, modified time.Time) {
+		_, _, cert, key := issue(t, name, ca, caKey, false)
+		for file, data := range map[string][]byte{cfg.Cert: cert, cfg.Key: key, cfg.CA: caPEM} {
+			if err := os.WriteFile(file, data, 0o600); err != nil {
+				t.Fatalf("Failed to write %s: %v", file, err)
+			}
+			os.Chtimes(file, modified, modified)
+		}
+	}
+	client := &http.Client{Transport: newTLSTransport(cfg)}
+	get := func() string {
+		t.Helper()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("Expected an error before the files exist")
+	}
+	write("first", time.Now().Add(-time.Minute))
+	if got := get(); got != "first" {
+		t.Errorf("Expected the first certificate, got %q", got)
+	}
+
+	// A half written rotation keeps the loaded certificate
+	os.WriteFile(cfg.Key, []byte("partial"), 0o600)
+	if got := get(); got != "first" {
+		t.Errorf("Expected the first certificate kept, got %q", got)
+	}
+
+	write("second", time.Now())
+	if got := get(); got != "second" {
+		t.Errorf("Expected the rotated certificate, got %q", got)
+	}
+}
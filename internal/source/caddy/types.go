@@ -1,5 +1,11 @@
 package caddy
 
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
 type Config struct {
 	Apps struct {
 		HTTP struct {
@@ -19,8 +25,77 @@ type Route struct {
 	Terminal bool      `json:"terminal,omitempty"`
 }
 
+// Match is a single matcher set. Matchers within a set are AND'ed together
+// and sets within a route are OR'ed, so any host found in any set is served.
 type Match struct {
-	Host []string `json:"host"`
+	Host       []string   `json:"host,omitempty"`
+	Path       []string   `json:"path,omitempty"`
+	Not        []Match    `json:"not,omitempty"`
+	Expression Expression `json:"expression,omitempty"`
+}
+
+// Expression is a CEL matcher, encoded either as a plain string or as an
+// object with an "expr" field.
+type Expression string
+
+func (e *Expression) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*e = Expression(s)
+		return nil
+	}
+	var obj struct {
+		Expr string `json:"expr"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*e = Expression(obj.Expr)
+	return nil
+}
+
+var (
+	hostCallRe = regexp.MustCompile(`(?:^|[^A-Za-z0-9_])(host)\(([^)]*)\)`) // not vhost( or myhost(
+	quotedRe   = regexp.MustCompile(`['"]([^'"]+)['"]`)
+)
+
+// Hosts returns hosts referenced by host() calls in the expression.
+// Hosts inside a negated call are ignored.
+func (e Expression) Hosts() []string {
+	var hosts []string
+	expr := string(e)
+	for _, loc := range hostCallRe.FindAllStringSubmatchIndex(expr, -1) {
+		if strings.HasSuffix(strings.TrimSpace(expr[:loc[2]]), "!") {
+			continue
+		}
+		for _, q := range quotedRe.FindAllStringSubmatch(expr[loc[4]:loc[5]], -1) {
+			hosts = append(hosts, q[1])
+		}
+	}
+	return hosts
+}
+
+// Hosts returns the positively matched hosts in the set. Hosts under a not
+// matcher are excluded since the route does not serve them.
+func (m Match) Hosts() []string {
+	hosts := append([]string{}, m.Host...)
+	return append(hosts, m.Expression.Hosts()...)
+}
+
+// Hosts returns the unique hosts across all matcher sets of the route.
+func (r Route) Hosts() []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, match := range r.Match {
+		for _, host := range match.Hosts() {
+			if seen[host] {
+				continue
+			}
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
 }
 
 type Handler struct {
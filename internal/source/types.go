@@ -1,6 +1,13 @@
 package source
 
 type DomainConfig struct {
-	Host       string
+	Host     string
 	Upstream string
+	Port     int    // upstream port, zero if none
+	Handler  string // caddy handler serving the host
+	Source   string // name of the source the host was found in, e.g. caddy
+	IPv6     string // published as an AAAA record besides the main record, see publicIP.dualStack
+	// Further healthy upstreams, published as A records besides the main
+	// record, see caddy.upstreamHealth
+	Alternates []string
 }
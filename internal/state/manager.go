@@ -4,16 +4,37 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/dgraph-io/badger/v3"
 	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
 )
 
-const domainPrefix = "domain:"
+const (
+	domainPrefix  = "domain:"
+	auditPrefix   = "audit:"
+	instanceKey   = "meta:instance_id"
+	pausedKey     = "meta:paused"
+	failurePrefix = "failure:"
+	journalPrefix = "journal:"
+)
 
 type Manager interface {
 	LoadState(ctx context.Context) (State, error)
 	SaveState(ctx context.Context, state State) error
+	AppendAudit(ctx context.Context, entries []AuditEntry) error
+	LoadAudit(ctx context.Context, limit int) ([]AuditEntry, error)
+	PruneAudit(ctx context.Context, before int64, keep int) (int, error)
+	InstanceID(ctx context.Context, generate func() string) (string, error)
+	LoadFailures(ctx context.Context) (map[string]HostFailure, error)
+	SaveFailures(ctx context.Context, failures map[string]HostFailure) error
+	LoadPaused(ctx context.Context) (bool, error)
+	SavePaused(ctx context.Context, paused bool) error
+	LoadJournal(ctx context.Context) ([]JournalEntry, error)
+	SaveJournal(ctx context.Context, entries []JournalEntry) error
+	MarkJournalApplied(ctx context.Context, index int) error
+	Backup(ctx context.Context, w io.Writer) error
+	Size(ctx context.Context) (Size, error)
 	Close() error
 }
 
@@ -40,15 +61,15 @@ func (m *badgerManager) LoadState(ctx context.Context) (State, error) {
 	}
 
 	err := m.db.View(func(txn *badger.Txn) error {
+		prefix := []byte(domainPrefix)
 		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
-		prefix := []byte(domainPrefix)
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
-			key := string(item.Key())
-			host := key[len(domainPrefix):]
+			host := string(item.Key()[len(domainPrefix):])
 
 			err := item.Value(func(val []byte) error {
 				var domain DomainState
@@ -73,14 +94,16 @@ func (m *badgerManager) SaveState(ctx context.Context, state State) error {
 	defer txn.Discard()
 
 	// First, get all existing keys to handle deletions
-	existingHosts := make(map[string]bool)
+	existingHosts := make(map[string]bool, len(state.Domains))
 
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	// Only keys are needed, skip fetching values
 	prefix := []byte(domainPrefix)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
 	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		key := string(it.Item().Key())
-		host := key[len(domainPrefix):]
-		existingHosts[host] = true
+		existingHosts[string(it.Item().Key()[len(domainPrefix):])] = true
 	}
 	it.Close()
 
@@ -91,8 +114,7 @@ func (m *badgerManager) SaveState(ctx context.Context, state State) error {
 			m.metrics.IncBadgerRequest("update", false)
 			return err
 		}
-		key := domainPrefix + host
-		if err := txn.Set([]byte(key), data); err != nil {
+		if err := txn.Set([]byte(domainPrefix+host), data); err != nil {
 			m.metrics.IncBadgerRequest("update", false)
 			return err
 		}
@@ -113,6 +135,269 @@ func (m *badgerManager) SaveState(ctx context.Context, state State) error {
 	return err
 }
 
+func (m *badgerManager) AppendAudit(ctx context.Context, entries []AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	err := m.db.Update(func(txn *badger.Txn) error {
+		for i, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			// Zero padded timestamp keeps keys in chronological order
+			key := fmt.Sprintf("%s%020d-%06d", auditPrefix, entry.Time, i)
+			if err := txn.Set([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	m.metrics.IncBadgerRequest("create", err == nil)
+	return err
+}
+
+// LoadAudit returns up to limit of the most recent audit entries, oldest first.
+// A limit of zero returns all entries.
+func (m *badgerManager) LoadAudit(ctx context.Context, limit int) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := m.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(auditPrefix)
+		// Seek past the last possible key with the prefix when iterating in reverse
+		for it.Seek(append(prefix, 0xff)); it.ValidForPrefix(prefix); it.Next() {
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+			err := it.Item().Value(func(val []byte) error {
+				var entry AuditEntry
+				if err := json.Unmarshal(val, &entry); err != nil {
+					return err
+				}
+				entries = append(entries, entry)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	m.metrics.IncBadgerRequest("read", err == nil)
+
+	// Reverse into chronological order
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, err
+}
+
+func (m *badgerManager) LoadFailures(ctx context.Context) (map[string]HostFailure, error) {
+	failures := make(map[string]HostFailure)
+	err := m.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(failurePrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			host := string(item.Key())[len(failurePrefix):]
+			err := item.Value(func(val []byte) error {
+				var failure HostFailure
+				if err := json.Unmarshal(val, &failure); err != nil {
+					return err
+				}
+				failures[host] = failure
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	m.metrics.IncBadgerRequest("read", err == nil)
+	return failures, err
+}
+
+// SaveFailures replaces all tracked host failures
+func (m *badgerManager) SaveFailures(ctx context.Context, failures map[string]HostFailure) error {
+	err := m.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		prefix := []byte(failurePrefix)
+		stale := [][]byte{}
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			host := string(it.Item().Key())[len(failurePrefix):]
+			if _, ok := failures[host]; !ok {
+				stale = append(stale, it.Item().KeyCopy(nil))
+			}
+		}
+		it.Close()
+
+		for _, key := range stale {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		for host, failure := range failures {
+			data, err := json.Marshal(failure)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set([]byte(failurePrefix+host), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	m.metrics.IncBadgerRequest("update", err == nil)
+	return err
+}
+
+// InstanceID returns the persisted instance id, storing one from generate on
+// first use so it remains stable across restarts.
+func (m *badgerManager) InstanceID(ctx context.Context, generate func() string) (string, error) {
+	var id string
+	err := m.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(instanceKey))
+		if err == nil {
+			return item.Value(func(val []byte) error {
+				id = string(val)
+				return nil
+			})
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+		id = generate()
+		return txn.Set([]byte(instanceKey), []byte(id))
+	})
+	m.metrics.IncBadgerRequest("read", err == nil)
+	return id, err
+}
+
+// LoadPaused reports whether syncing was paused, surviving restarts
+func (m *badgerManager) LoadPaused(ctx context.Context) (bool, error) {
+	paused := false
+	err := m.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(pausedKey))
+		switch err {
+		case nil:
+			paused = true
+			return nil
+		case badger.ErrKeyNotFound:
+			return nil
+		}
+		return err
+	})
+	m.metrics.IncBadgerRequest("read", err == nil)
+	return paused, err
+}
+
+func (m *badgerManager) SavePaused(ctx context.Context, paused bool) error {
+	err := m.db.Update(func(txn *badger.Txn) error {
+		if paused {
+			return txn.Set([]byte(pausedKey), []byte("true"))
+		}
+		return txn.Delete([]byte(pausedKey))
+	})
+	m.metrics.IncBadgerRequest("update", err == nil)
+	return err
+}
+
+// LoadJournal returns the operations of the plan being executed, empty
+// unless a run is in progress or was interrupted
+func (m *badgerManager) LoadJournal(ctx context.Context) ([]JournalEntry, error) {
+	entries := []JournalEntry{}
+	err := m.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := []byte(journalPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var entry JournalEntry
+				if err := json.Unmarshal(val, &entry); err != nil {
+					return err
+				}
+				entries = append(entries, entry)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	m.metrics.IncBadgerRequest("read", err == nil)
+	return entries, err
+}
+
+// SaveJournal replaces the journal, an empty journal clears it
+func (m *badgerManager) SaveJournal(ctx context.Context, entries []JournalEntry) error {
+	err := m.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		prefix := []byte(journalPrefix)
+		keys := [][]byte{}
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		it.Close()
+
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		for i, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(journalKey(i), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	m.metrics.IncBadgerRequest("update", err == nil)
+	return err
+}
+
+// MarkJournalApplied records that the index-th journal operation completed
+func (m *badgerManager) MarkJournalApplied(ctx context.Context, index int) error {
+	err := m.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(journalKey(index))
+		if err != nil {
+			return err
+		}
+		var entry JournalEntry
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &entry)
+		})
+		if err != nil {
+			return err
+		}
+		entry.Applied = true
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return txn.Set(journalKey(index), data)
+	})
+	m.metrics.IncBadgerRequest("update", err == nil)
+	return err
+}
+
+// journalKey zero pads the index so entries iterate in plan order
+func journalKey(index int) []byte {
+	return []byte(fmt.Sprintf("%s%08d", journalPrefix, index))
+}
+
 func (m *badgerManager) Close() error {
 	return m.db.Close()
 }
@@ -3,6 +3,7 @@ package state
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -228,3 +229,294 @@ func TestBadgerManagerError(t *testing.T) {
 		t.Fatal("expected error for invalid path but got nil")
 	}
 }
+
+func TestBadgerManagerAudit(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-audit-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	manager, err := New(filepath.Join(tempDir, "badger"), metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	ctx := context.Background()
+	first := []AuditEntry{
+		{Time: 100, Op: "create", Zone: "example.com", Name: "a", Type: "A", Data: "10.0.0.1", Reason: "host added in Caddy", Result: "success"},
+		{Time: 100, Op: "create", Zone: "example.com", Name: "a", Type: "TXT", Data: "heritage", Reason: "host added in Caddy", Result: "success"},
+	}
+	second := []AuditEntry{
+		{Time: 200, Op: "delete", Zone: "example.com", Name: "a", Type: "A", Data: "10.0.0.1", Reason: "host removed", Result: "failure", Error: "dns failure"},
+	}
+	if err := manager.AppendAudit(ctx, first); err != nil {
+		t.Fatalf("AppendAudit failed: %v", err)
+	}
+	if err := manager.AppendAudit(ctx, second); err != nil {
+		t.Fatalf("AppendAudit failed: %v", err)
+	}
+
+	all, err := manager.LoadAudit(ctx, 0)
+	if err != nil {
+		t.Fatalf("LoadAudit failed: %v", err)
+	}
+	if expected := append(append([]AuditEntry{}, first...), second...); !reflect.DeepEqual(all, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, all)
+	}
+
+	latest, err := manager.LoadAudit(ctx, 2)
+	if err != nil {
+		t.Fatalf("LoadAudit failed: %v", err)
+	}
+	if expected := []AuditEntry{first[1], second[0]}; !reflect.DeepEqual(latest, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, latest)
+	}
+}
+
+func TestBadgerManagerInstanceID(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-instance-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "badger")
+	ctx := context.Background()
+
+	manager, err := New(path, metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	id, err := manager.InstanceID(ctx, func() string { return "first" })
+	if err != nil {
+		t.Fatalf("InstanceID failed: %v", err)
+	}
+	if id != "first" {
+		t.Errorf("Expected generated id first but got %s", id)
+	}
+	manager.Close()
+
+	// Reopen to confirm the id survives a restart
+	manager, err = New(path, metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to reopen manager: %v", err)
+	}
+	defer manager.Close()
+	id, err = manager.InstanceID(ctx, func() string { return "second" })
+	if err != nil {
+		t.Fatalf("InstanceID failed: %v", err)
+	}
+	if id != "first" {
+		t.Errorf("Expected persisted id first but got %s", id)
+	}
+}
+
+func TestBadgerManagerFailures(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-failures-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	manager, err := New(filepath.Join(tempDir, "badger"), metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	ctx := context.Background()
+	first := map[string]HostFailure{
+		"a.example.com": {Count: 1, LastError: "rate limited"},
+		"b.example.com": {Count: 3, LastError: "cname at apex", Skipped: true, Since: 100},
+	}
+	if err := manager.SaveFailures(ctx, first); err != nil {
+		t.Fatalf("SaveFailures failed: %v", err)
+	}
+	got, err := manager.LoadFailures(ctx)
+	if err != nil {
+		t.Fatalf("LoadFailures failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, first) {
+		t.Errorf("Expected %+v but got %+v", first, got)
+	}
+
+	// Hosts missing from the saved map are removed
+	second := map[string]HostFailure{
+		"b.example.com": first["b.example.com"],
+	}
+	if err := manager.SaveFailures(ctx, second); err != nil {
+		t.Fatalf("SaveFailures failed: %v", err)
+	}
+	got, err = manager.LoadFailures(ctx)
+	if err != nil {
+		t.Fatalf("LoadFailures failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, second) {
+		t.Errorf("Expected %+v but got %+v", second, got)
+	}
+}
+
+func TestBadgerManagerPaused(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-paused-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	manager, err := New(filepath.Join(tempDir, "badger"), metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	ctx := context.Background()
+	for _, want := range []bool{false, true, true, false} {
+		if err := manager.SavePaused(ctx, want); err != nil {
+			t.Fatalf("SavePaused failed: %v", err)
+		}
+		got, err := manager.LoadPaused(ctx)
+		if err != nil {
+			t.Fatalf("LoadPaused failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("Expected paused %v but got %v", want, got)
+		}
+	}
+}
+
+func TestBadgerManagerJournal(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-journal-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	manager, err := New(filepath.Join(tempDir, "badger"), metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	ctx := context.Background()
+	entries := []JournalEntry{}
+	for i := 0; i < 12; i++ {
+		entries = append(entries, JournalEntry{Op: "create", Zone: "example.com", Name: fmt.Sprintf("host%d", i), Type: "A", Data: "10.0.0.1"})
+	}
+	if err := manager.SaveJournal(ctx, entries); err != nil {
+		t.Fatalf("SaveJournal failed: %v", err)
+	}
+	if err := manager.MarkJournalApplied(ctx, 10); err != nil {
+		t.Fatalf("MarkJournalApplied failed: %v", err)
+	}
+	got, err := manager.LoadJournal(ctx)
+	if err != nil {
+		t.Fatalf("LoadJournal failed: %v", err)
+	}
+	entries[10].Applied = true
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("Expected %+v in plan order but got %+v", entries, got)
+	}
+
+	if err := manager.SaveJournal(ctx, nil); err != nil {
+		t.Fatalf("SaveJournal failed: %v", err)
+	}
+	got, err = manager.LoadJournal(ctx)
+	if err != nil {
+		t.Fatalf("LoadJournal failed: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Expected journal cleared but got %+v", got)
+	}
+}
+
+func BenchmarkBadgerManagerState(b *testing.B) {
+	manager, err := New(b.TempDir(), metrics.New(false))
+	if err != nil {
+		b.Fatalf("failed to create manager: %v", err)
+	}
+	defer manager.Close()
+	ctx := context.Background()
+
+	st := State{Domains: make(map[string]DomainState, 1000)}
+	for i := 0; i < 1000; i++ {
+		st.Domains[fmt.Sprintf("host%d.example.com", i)] = DomainState{ServerName: "10.0.0.1:8080", LastSeen: int64(i)}
+	}
+
+	b.Run("save", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := manager.SaveState(ctx, st); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("load", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := manager.LoadState(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestMigrateLegacyPath(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-migrate-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	legacyPath := filepath.Join(tempDir, "caddy-sync-dns.db")
+	path := filepath.Join(tempDir, "caddydnssync.db")
+
+	// Nothing to migrate
+	if legacy, err := MigrateLegacyPath(path); err != nil || legacy != "" {
+		t.Fatalf("Expected no migration, got %q, %v", legacy, err)
+	}
+
+	ctx := context.Background()
+	manager, err := New(legacyPath, metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	want := State{Domains: map[string]DomainState{"app.example.com": {ServerName: "10.0.0.1:8080"}}}
+	if err := manager.SaveState(ctx, want); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+	manager.Close()
+
+	legacy, err := MigrateLegacyPath(path)
+	if err != nil {
+		t.Fatalf("MigrateLegacyPath failed: %v", err)
+	}
+	if legacy != legacyPath {
+		t.Errorf("Expected %s migrated, got %q", legacyPath, legacy)
+	}
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Errorf("Expected legacy state moved away, got %v", err)
+	}
+
+	manager, err = New(path, metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to open migrated state: %v", err)
+	}
+	defer manager.Close()
+	got, err := manager.LoadState(ctx)
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected state %+v but got %+v", want, got)
+	}
+
+	// An existing state is never replaced
+	if err := os.Mkdir(legacyPath, 0o755); err != nil {
+		t.Fatalf("failed to create legacy dir: %v", err)
+	}
+	if legacy, err := MigrateLegacyPath(path); err != nil || legacy != "" {
+		t.Errorf("Expected no migration over existing state, got %q, %v", legacy, err)
+	}
+}
@@ -0,0 +1,39 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// legacyNames are state database names used by earlier releases
+var legacyNames = []string{"caddy-sync-dns.db"}
+
+// MigrateLegacyPath moves a state database left under a legacy name next to
+// path into place, so upgrading keeps the known hosts. Nothing is moved if
+// path already exists. Returns the legacy path moved, or "" if none was.
+func MigrateLegacyPath(path string) (string, error) {
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	dir := filepath.Dir(path)
+	for _, name := range legacyNames {
+		legacy := filepath.Join(dir, name)
+		if legacy == filepath.Clean(path) {
+			continue
+		}
+		if _, err := os.Stat(legacy); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return "", err
+		}
+		if err := os.Rename(legacy, path); err != nil {
+			return "", fmt.Errorf("move legacy state %s: %w", legacy, err)
+		}
+		return legacy, nil
+	}
+	return "", nil
+}
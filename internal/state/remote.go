@@ -0,0 +1,86 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Remote is an object store snapshots are uploaded to
+type Remote interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// UploadSnapshot uploads the snapshot file at path under prefix, keeping the
+// newest keep snapshots of the remote. Returns the key uploaded to.
+func UploadSnapshot(ctx context.Context, remote Remote, path, prefix string, keep int) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key := prefix + filepath.Base(path)
+	if err := remote.Put(ctx, key, data); err != nil {
+		return "", fmt.Errorf("upload %s: %w", key, err)
+	}
+
+	snapshots, err := RemoteSnapshots(ctx, remote, prefix)
+	if err != nil {
+		return key, err
+	}
+	for _, old := range snapshots[min(keep, len(snapshots)):] {
+		if err := remote.Delete(ctx, old); err != nil {
+			return key, fmt.Errorf("delete %s: %w", old, err)
+		}
+	}
+	return key, nil
+}
+
+// RemoteSnapshots lists the keys of the snapshots under prefix, newest first
+func RemoteSnapshots(ctx context.Context, remote Remote, prefix string) ([]string, error) {
+	keys, err := remote.List(ctx, prefix+snapshotPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	snapshots := []string{}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		if !strings.Contains(name, "/") && strings.HasSuffix(name, snapshotSuffix) {
+			snapshots = append(snapshots, key)
+		}
+	}
+	// Names embed the time, so they sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(snapshots)))
+	return snapshots, nil
+}
+
+// RestoreRemote restores the state store at path from the newest snapshot
+// under prefix when there is no store at path yet, as after losing the node
+// of a deployment without a persistent volume. Returns the key restored from,
+// empty when a store exists or the remote holds no snapshot.
+func RestoreRemote(ctx context.Context, remote Remote, path, prefix string) (string, error) {
+	if _, err := os.Stat(path); err == nil {
+		return "", nil
+	}
+	snapshots, err := RemoteSnapshots(ctx, remote, prefix)
+	if err != nil || len(snapshots) == 0 {
+		return "", err
+	}
+	key := snapshots[0]
+	data, err := remote.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", key, err)
+	}
+	if _, err := Restore(path, bytes.NewReader(data)); err != nil {
+		// A partial store would keep the next boot from restoring
+		os.RemoveAll(path)
+		return "", fmt.Errorf("restore %s: %w", key, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,115 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+)
+
+// memoryRemote is a Remote holding objects in memory
+type memoryRemote map[string][]byte
+
+func (m memoryRemote) Put(ctx context.Context, key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func (m memoryRemote) Get(ctx context.Context, key string) ([]byte, error) {
+	return m[key], nil
+}
+
+func (m memoryRemote) Delete(ctx context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memoryRemote) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	for key := range m {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func TestRemoteSnapshotRestore(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-remote-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	manager, err := New(filepath.Join(tempDir, "badger"), metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	ctx := context.Background()
+	want := State{Domains: map[string]DomainState{
+		"app.example.com": {ServerName: "10.0.0.1:8080", LastSeen: 100},
+	}}
+	if err := manager.SaveState(ctx, want); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+
+	// Only the newest snapshots are kept remotely, other objects are left alone
+	remote := memoryRemote{"node/other.txt": []byte("x")}
+	keys := []string{}
+	for i := 0; i < 3; i++ {
+		path, err := WriteSnapshot(ctx, manager, filepath.Join(tempDir, "snapshots"), 5)
+		if err != nil {
+			t.Fatalf("WriteSnapshot failed: %v", err)
+		}
+		key, err := UploadSnapshot(ctx, remote, path, "node/", 2)
+		if err != nil {
+			t.Fatalf("UploadSnapshot failed: %v", err)
+		}
+		keys = append(keys, key)
+		time.Sleep(5 * time.Millisecond)
+	}
+	manager.Close()
+	snapshots, err := RemoteSnapshots(ctx, remote, "node/")
+	if err != nil {
+		t.Fatalf("RemoteSnapshots failed: %v", err)
+	}
+	if expected := []string{keys[2], keys[1]}; !reflect.DeepEqual(snapshots, expected) {
+		t.Errorf("Expected remote snapshots %v but got %v", expected, snapshots)
+	}
+	if _, ok := remote["node/other.txt"]; !ok {
+		t.Error("Expected objects other than snapshots kept")
+	}
+
+	// A missing store is restored from the newest snapshot
+	restored := filepath.Join(tempDir, "restored")
+	key, err := RestoreRemote(ctx, remote, restored, "node/")
+	if err != nil {
+		t.Fatalf("RestoreRemote failed: %v", err)
+	}
+	if key != keys[2] {
+		t.Errorf("Expected restore from %s but got %s", keys[2], key)
+	}
+
+	// An existing store is never replaced
+	if key, err := RestoreRemote(ctx, remote, restored, "node/"); err != nil || key != "" {
+		t.Errorf("Expected no restore over an existing store, got %q, %v", key, err)
+	}
+
+	manager, err = New(restored, metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to open restored store: %v", err)
+	}
+	defer manager.Close()
+	got, err := manager.LoadState(ctx)
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if !reflect.DeepEqual(got.Domains, want.Domains) {
+		t.Errorf("Expected restored domains %v but got %v", want.Domains, got.Domains)
+	}
+}
@@ -0,0 +1,164 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Retention bounds the entries of the store that otherwise grow for as long
+// as the app runs, zero disables a limit
+type Retention struct {
+	Audit        time.Duration // age after which audit entries are deleted
+	AuditEntries int           // newest audit entries kept
+	StaleHosts   time.Duration // age of LastSeen after which a host is forgotten
+}
+
+// Pruned counts the entries deleted by Prune
+type Pruned struct {
+	Audit    int
+	Hosts    int
+	Failures int
+}
+
+// Size is the number of keys by kind and the bytes on disk of the store
+type Size struct {
+	Keys  map[string]int
+	Bytes int64
+}
+
+// Prune deletes what r no longer keeps. The LastSeen of a host only falls
+// behind while it is skipped or refused, so a stale host is dropped with its
+// failures and its records are left as they are. Callers must not run
+// Prune alongside a sync, which would save the state it loaded before.
+func Prune(ctx context.Context, m Manager, r Retention, now time.Time) (Pruned, error) {
+	pruned := Pruned{}
+	if r.Audit > 0 || r.AuditEntries > 0 {
+		var before int64
+		if r.Audit > 0 {
+			before = now.Add(-r.Audit).Unix()
+		}
+		n, err := m.PruneAudit(ctx, before, r.AuditEntries)
+		pruned.Audit = n
+		if err != nil {
+			return pruned, fmt.Errorf("prune audit: %w", err)
+		}
+	}
+	if r.StaleHosts <= 0 {
+		return pruned, nil
+	}
+
+	st, err := m.LoadState(ctx)
+	if err != nil {
+		return pruned, fmt.Errorf("load state: %w", err)
+	}
+	cutoff := now.Add(-r.StaleHosts).Unix()
+	stale := make(map[string]bool)
+	for host, d := range st.Domains {
+		if d.LastSeen < cutoff {
+			stale[host] = true
+			delete(st.Domains, host)
+		}
+	}
+	if len(stale) == 0 {
+		return pruned, nil
+	}
+	if err := m.SaveState(ctx, st); err != nil {
+		return pruned, fmt.Errorf("save state: %w", err)
+	}
+	pruned.Hosts = len(stale)
+
+	failures, err := m.LoadFailures(ctx)
+	if err != nil {
+		return pruned, fmt.Errorf("load failures: %w", err)
+	}
+	for host := range failures {
+		if stale[host] {
+			delete(failures, host)
+			pruned.Failures++
+		}
+	}
+	if pruned.Failures == 0 {
+		return pruned, nil
+	}
+	return pruned, m.SaveFailures(ctx, failures)
+}
+
+// PruneAudit deletes the audit entries older than before, a unix time, and
+// all but the newest keep. Zero disables either limit. Returns how many
+// entries were deleted.
+func (m *badgerManager) PruneAudit(ctx context.Context, before int64, keep int) (int, error) {
+	keys := [][]byte{}
+	err := m.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(auditPrefix)
+		seen := 0
+		// Newest first, so entries past keep are the oldest
+		for it.Seek(append(prefix, 0xff)); it.ValidForPrefix(prefix); it.Next() {
+			seen++
+			key := it.Item().Key()
+			if (keep > 0 && seen > keep) || (before > 0 && auditTime(key) < before) {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	m.metrics.IncBadgerRequest("read", err == nil)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	// A batch splits into transactions, a single one may be too big
+	batch := m.db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			m.metrics.IncBadgerRequest("delete", false)
+			return 0, err
+		}
+	}
+	err = batch.Flush()
+	m.metrics.IncBadgerRequest("delete", err == nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// auditTime returns the unix time an audit entry was written at from its key
+func auditTime(key []byte) int64 {
+	ts, _, _ := strings.Cut(string(key[len(auditPrefix):]), "-")
+	t, _ := strconv.ParseInt(ts, 10, 64)
+	return t
+}
+
+// Size counts the keys of the store by kind, the part of the key before the
+// first colon, and reports its bytes on disk
+func (m *badgerManager) Size(ctx context.Context) (Size, error) {
+	size := Size{Keys: map[string]int{"domain": 0, "audit": 0, "failure": 0, "journal": 0}}
+	err := m.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			kind, _, _ := strings.Cut(string(it.Item().Key()), ":")
+			size.Keys[kind]++
+		}
+		return nil
+	})
+	m.metrics.IncBadgerRequest("read", err == nil)
+	lsm, vlog := m.db.Size()
+	size.Bytes = lsm + vlog
+	return size, err
+}
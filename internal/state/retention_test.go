@@ -0,0 +1,85 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+)
+
+func TestPrune(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-retention-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	manager, err := New(filepath.Join(tempDir, "badger"), metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer manager.Close()
+
+	ctx := context.Background()
+	now := time.Unix(10000, 0)
+	entries := []AuditEntry{}
+	for _, ts := range []int64{1000, 2000, 9000, 9500, 9900} {
+		entries = append(entries, AuditEntry{Time: ts, Op: "create", Zone: "example.com", Name: "a", Result: "success"})
+	}
+	if err := manager.AppendAudit(ctx, entries); err != nil {
+		t.Fatalf("AppendAudit failed: %v", err)
+	}
+	st := State{Domains: map[string]DomainState{
+		"app.example.com":  {ServerName: "app:80", LastSeen: 9900},
+		"gone.example.com": {ServerName: "gone:80", LastSeen: 1000},
+	}}
+	if err := manager.SaveState(ctx, st); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+	failures := map[string]HostFailure{
+		"app.example.com":  {Count: 1},
+		"gone.example.com": {Count: 3, Skipped: true},
+	}
+	if err := manager.SaveFailures(ctx, failures); err != nil {
+		t.Fatalf("SaveFailures failed: %v", err)
+	}
+
+	// Older than 5000s and past the newest two
+	pruned, err := Prune(ctx, manager, Retention{Audit: 5000 * time.Second, AuditEntries: 2, StaleHosts: time.Hour}, now)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if expected := (Pruned{Audit: 3, Hosts: 1, Failures: 1}); pruned != expected {
+		t.Errorf("Expected %+v but got %+v", expected, pruned)
+	}
+
+	audit, _ := manager.LoadAudit(ctx, 0)
+	if !reflect.DeepEqual(audit, entries[3:]) {
+		t.Errorf("Expected the newest two audit entries but got %+v", audit)
+	}
+	loaded, _ := manager.LoadState(ctx)
+	if _, ok := loaded.Domains["gone.example.com"]; ok || len(loaded.Domains) != 1 {
+		t.Errorf("Expected the stale host dropped but got %+v", loaded.Domains)
+	}
+	left, _ := manager.LoadFailures(ctx)
+	if _, ok := left["app.example.com"]; !ok || len(left) != 1 {
+		t.Errorf("Expected only the failures of the stale host dropped but got %+v", left)
+	}
+
+	size, err := manager.Size(ctx)
+	if err != nil {
+		t.Fatalf("Size failed: %v", err)
+	}
+	if size.Keys["audit"] != 2 || size.Keys["domain"] != 1 || size.Keys["failure"] != 1 {
+		t.Errorf("Expected key counts of the pruned store but got %+v", size.Keys)
+	}
+
+	// Nothing left to prune
+	if pruned, _ := Prune(ctx, manager, Retention{AuditEntries: 2, StaleHosts: time.Hour}, now); pruned != (Pruned{}) {
+		t.Errorf("Expected nothing pruned but got %+v", pruned)
+	}
+}
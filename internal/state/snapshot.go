@@ -0,0 +1,111 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	snapshotPrefix = "state-"
+	snapshotSuffix = ".bak"
+)
+
+// Backup writes a full backup of the state store to w
+func (m *badgerManager) Backup(ctx context.Context, w io.Writer) error {
+	_, err := m.db.Backup(w, 0)
+	m.metrics.IncBadgerRequest("read", err == nil)
+	return err
+}
+
+// WriteSnapshot backs the state store up into dir, keeping the newest keep
+// snapshots. The backup is written to a temporary file and renamed once
+// complete, so an interrupted snapshot never replaces a good one.
+func WriteSnapshot(ctx context.Context, m Manager, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := m.Backup(ctx, tmp); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("backup: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, snapshotPrefix+time.Now().UTC().Format("20060102T150405.000Z")+snapshotSuffix)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	snapshots, err := Snapshots(dir)
+	if err != nil {
+		return path, err
+	}
+	for _, old := range snapshots[min(keep, len(snapshots)):] {
+		if err := os.Remove(old); err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
+// Snapshots lists the snapshots in dir, newest first
+func Snapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotSuffix) {
+			snapshots = append(snapshots, filepath.Join(dir, name))
+		}
+	}
+	// Names embed the time, so they sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(snapshots)))
+	return snapshots, nil
+}
+
+// Restore replaces the state store at path with the backup read from r. An
+// existing store is moved aside rather than deleted, it may be the only copy
+// of state newer than the backup.
+func Restore(path string, r io.Reader) (string, error) {
+	moved := ""
+	if _, err := os.Stat(path); err == nil {
+		moved = fmt.Sprintf("%s.before-restore-%d", path, time.Now().Unix())
+		if err := os.Rename(path, moved); err != nil {
+			return "", fmt.Errorf("move existing state: %w", err)
+		}
+	}
+
+	opts := badger.DefaultOptions(path)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		return moved, fmt.Errorf("open badger db: %w", err)
+	}
+	if err := db.Load(r, 256); err != nil {
+		db.Close()
+		return moved, fmt.Errorf("load backup: %w", err)
+	}
+	return moved, db.Close()
+}
@@ -0,0 +1,85 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/evanofslack/caddy-dns-sync/internal/metrics"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "badger-snapshot-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dbPath := filepath.Join(tempDir, "badger")
+	snapshotDir := filepath.Join(tempDir, "snapshots")
+	manager, err := New(dbPath, metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	ctx := context.Background()
+	want := State{Domains: map[string]DomainState{
+		"app.example.com": {ServerName: "10.0.0.1:8080", LastSeen: 100},
+	}}
+	if err := manager.SaveState(ctx, want); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+
+	// Only the newest snapshots are kept
+	paths := []string{}
+	for i := 0; i < 3; i++ {
+		path, err := WriteSnapshot(ctx, manager, snapshotDir, 2)
+		if err != nil {
+			t.Fatalf("WriteSnapshot failed: %v", err)
+		}
+		paths = append(paths, path)
+		time.Sleep(5 * time.Millisecond)
+	}
+	snapshots, err := Snapshots(snapshotDir)
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if expected := []string{paths[2], paths[1]}; !reflect.DeepEqual(snapshots, expected) {
+		t.Errorf("Expected snapshots %v but got %v", expected, snapshots)
+	}
+
+	// Changes after the snapshot are lost on restore
+	if err := manager.SaveState(ctx, State{Domains: map[string]DomainState{}}); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+	manager.Close()
+
+	f, err := os.Open(snapshots[0])
+	if err != nil {
+		t.Fatalf("failed to open snapshot: %v", err)
+	}
+	defer f.Close()
+	moved, err := Restore(dbPath, f)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(moved); err != nil {
+		t.Errorf("Expected previous state kept at %s: %v", moved, err)
+	}
+
+	restored, err := New(dbPath, metrics.New(false))
+	if err != nil {
+		t.Fatalf("failed to open restored state: %v", err)
+	}
+	defer restored.Close()
+	got, err := restored.LoadState(ctx)
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v but got %+v", want, got)
+	}
+}
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"time"
+
 	"github.com/evanofslack/caddy-dns-sync/internal/source"
 )
 
@@ -9,15 +11,89 @@ type State struct {
 }
 
 type DomainState struct {
-	ServerName string `json:"serverName"`
-	LastSeen   int64  `json:"lastSeen"`
+	ServerName  string `json:"serverName"`
+	LastSeen    int64  `json:"lastSeen"`
+	Source      string `json:"source,omitempty"`      // source the host was last found in
+	LastApplied int64  `json:"lastApplied,omitempty"` // unix time records were last brought in line
+	AppliedType string `json:"appliedType,omitempty"` // main record type written
+	AppliedData string `json:"appliedData,omitempty"` // main record data written
+	IPv6        string `json:"ipv6,omitempty"`        // data of the AAAA record published besides the main record
+	// Further healthy upstreams published as A records besides the main record
+	Alternates []string `json:"alternates,omitempty"`
+	// Records written for the host with their provider ids, see reconcile.fastSync
+	Records []ManagedRecord `json:"records,omitempty"`
+}
+
+// ManagedRecord is a record written for a host, with the id the provider
+// assigned it when known
+type ManagedRecord struct {
+	ID      string        `json:"id,omitempty"`
+	Zone    string        `json:"zone"`
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Data    string        `json:"data"`
+	TTL     time.Duration `json:"ttl,omitempty"`
+	Proxied bool          `json:"proxied,omitempty"`
+	Comment string        `json:"comment,omitempty"`
 }
 
 type StateChanges struct {
-	Added   []source.DomainConfig
-	Removed []string
+	Added        []source.DomainConfig
+	Removed      []string
+	Previous     map[string]string // modified host to previous upstream
+	PreviousIPv6 map[string]string // modified and removed hosts to the AAAA data last published besides their main record
+	Expired      map[string]bool   // removed hosts not seen for longer than expireAfter
+	Recovered    map[string]bool   // zone/name of records created by an interrupted plan
+	Moved        map[string]string // added host to the removed host of another zone it replaces
+	Forced       map[string]bool   // unchanged hosts planned anyway by a scoped reconcile
+	Realiased    map[string]bool   // unchanged hosts moving onto or off a canonical name
+	// Records of the planned hosts by zone, when set the plan is made from
+	// them rather than by listing the zones
+	Records map[string][]ManagedRecord
+	// Modified and removed hosts to the alternates they were last published with
+	PreviousAlternates map[string][]string
 }
 
 func (st StateChanges) IsEmpty() bool {
 	return len(st.Added) == 0 && len(st.Removed) == 0
 }
+
+// AuditEntry records a single operation applied (or simulated) against the provider
+type AuditEntry struct {
+	Time   int64  `json:"time"`
+	Op     string `json:"op"`
+	Zone   string `json:"zone"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Data   string `json:"data"`
+	Reason string `json:"reason"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	RunID  string `json:"runId,omitempty"`  // sync that applied the operation
+	Source string `json:"source,omitempty"` // source of the host the record belongs to
+}
+
+// HostFailure tracks consecutive failed syncs of a host. Once skipped the host
+// is no longer retried until cleared.
+type HostFailure struct {
+	Count     int    `json:"count"`
+	LastError string `json:"lastError"`
+	Class     string `json:"class,omitempty"` // provider error class of the last failure
+	Op        string `json:"op,omitempty"`    // operation that failed last
+	Type      string `json:"type,omitempty"`  // record type of the failed operation
+	Last      int64  `json:"last,omitempty"`  // unix time of the last failure
+	Skipped   bool   `json:"skipped"`
+	Since     int64  `json:"since,omitempty"` // unix time the host was skipped
+}
+
+// JournalEntry is an operation of a plan being executed. The journal is
+// written before execution and cleared once it finishes, so entries left
+// behind belong to a run that was interrupted.
+type JournalEntry struct {
+	Op      string `json:"op"`
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Data    string `json:"data"`
+	Applied bool   `json:"applied"`
+}
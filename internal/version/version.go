@@ -0,0 +1,66 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build details, set with
+// -ldflags "-X github.com/evanofslack/caddy-dns-sync/internal/version.Version=v1.2.3"
+// and likewise for Commit and Date. Commit and Date fall back to the vcs
+// details go embeds when building from a checkout.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build details of the running binary
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range build.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.Date == "":
+				info.Date = s.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// String formats the build details for the version command
+func (i Info) String() string {
+	return "caddy-dns-sync " + i.Version + " (commit " + i.Commit + ", built " + i.Date + ", " + i.GoVersion + ")"
+}
+
+// UserAgent identifies caddy-dns-sync and its version in outgoing requests,
+// tag is appended to tell instances apart in provider audit logs
+func UserAgent(tag string) string {
+	ua := "caddy-dns-sync/" + Version
+	if tag != "" {
+		ua += " (" + tag + ")"
+	}
+	return ua
+}
@@ -0,0 +1,25 @@
+package version
+
+import "testing"
+
+func TestUserAgent(t *testing.T) {
+	if got := UserAgent(""); got != "caddy-dns-sync/dev" {
+		t.Errorf("UserAgent() = %q", got)
+	}
+	if got := UserAgent("homelab-1"); got != "caddy-dns-sync/dev (homelab-1)" {
+		t.Errorf("UserAgent(tag) = %q", got)
+	}
+}
+
+func TestGet(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+	Version, Commit, Date = "v1.2.3", "abc123", "2024-06-01T00:00:00Z"
+
+	info := Get()
+	if info.Version != "v1.2.3" || info.Commit != "abc123" || info.Date != "2024-06-01T00:00:00Z" || info.GoVersion == "" {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	if got := info.String(); got != "caddy-dns-sync v1.2.3 (commit abc123, built 2024-06-01T00:00:00Z, "+info.GoVersion+")" {
+		t.Errorf("String() = %q", got)
+	}
+}